@@ -1,6 +1,10 @@
 package api
 
 import (
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/walletutil"
 	"go.sia.tech/core/types"
 )
 
@@ -11,19 +15,36 @@ type GatewayPeer struct {
 	Version string `json:"version"`
 }
 
-// Balance combines mature and immature values.
+// Balance combines mature and immature values. LowFunds is set if the
+// balance is below the threshold, under which the benchmarks are
+// suspended.
 type Balance struct {
 	Siacoins         types.Currency `json:"siacoins"`
 	ImmatureSiacoins types.Currency `json:"immatureSiacoins"`
+	LowFunds         bool           `json:"lowFunds"`
 }
 
 // NodeStatusResponse is the response type for /node/status.
 type NodeStatusResponse struct {
-	Version    string  `json:"version"`
-	Height     uint64  `json:"heightMainnet"`
-	HeightZen  uint64  `json:"heightZen"`
-	Balance    Balance `json:"balanceMainnet"`
-	BalanceZen Balance `json:"balanceZen"`
+	Version     string    `json:"version"`
+	GitRevision string    `json:"gitRevision"`
+	BuildTime   string    `json:"buildTime"`
+	StartedAt   time.Time `json:"startedAt"`
+	Height      uint64    `json:"heightMainnet"`
+	HeightZen   uint64    `json:"heightZen"`
+	Balance     Balance   `json:"balanceMainnet"`
+	BalanceZen  Balance   `json:"balanceZen"`
+
+	// Update is only present if the update check is enabled.
+	Update *UpdateStatus `json:"update,omitempty"`
+}
+
+// UpdateStatus compares the running version with the latest release.
+type UpdateStatus struct {
+	Available     bool      `json:"available"`
+	LatestVersion string    `json:"latestVersion"`
+	ReleaseURL    string    `json:"releaseURL"`
+	CheckedAt     time.Time `json:"checkedAt"`
 }
 
 // ConsensusTipResponse is the response type for /consensus/tip.
@@ -54,3 +75,44 @@ type WalletOutputsResponse struct {
 	SiacoinOutputs []types.SiacoinElement `json:"siacoinOutputs"`
 	SiafundOutputs []types.SiafundElement `json:"siafundOutputs"`
 }
+
+// WalletStuckResponse is the response type for /wallet/stuck.
+type WalletStuckResponse struct {
+	Network      string                        `json:"network"`
+	Transactions []walletutil.StuckTransaction `json:"transactions"`
+}
+
+// BenchmarkConfigResponse is the response type for /hostdb/benchmark/config.
+type BenchmarkConfigResponse struct {
+	hostdb.BenchmarkSettings
+}
+
+// BudgetResponse is the response type for /hostdb/budget.
+type BudgetResponse struct {
+	Budgets []hostdb.BudgetState `json:"budgets"`
+}
+
+// SpendingResponse is the response type for /hostdb/spending.
+type SpendingResponse struct {
+	Totals []hostdb.NetworkSpending `json:"totals"`
+	Hosts  []hostdb.HostSpending    `json:"hosts"`
+}
+
+// DiagnosticRequest is the request type for POST /hostdb/diagnostics.
+type DiagnosticRequest struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+}
+
+// DiagnosticResponse is the response type for POST /hostdb/diagnostics.
+// Started is false if the host has been traced recently.
+type DiagnosticResponse struct {
+	Started bool `json:"started"`
+}
+
+// BenchmarkCostResponse is the response type for /hostdb/benchmark/cost.
+type BenchmarkCostResponse struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	hostdb.BenchmarkCostEstimate
+}
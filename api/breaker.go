@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when the requests to the node are suspended
+// after too many consecutive failures.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// breaker suspends the requests to a node that keeps failing, so that
+// the callers don't have to wait for the timeouts.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// allow returns an error if the circuit is open.
+func (b *breaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record records the outcome of a request.
+func (b *breaker) record(success bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		// A single failure after the cooldown opens the circuit again.
+		b.failures = b.threshold - 1
+	}
+}
@@ -1,56 +1,229 @@
 package api
 
 import (
-	"encoding/hex"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"github.com/mike76-dev/hostscore/internal/walletutil"
 	"github.com/mike76-dev/hostscore/wallet"
 	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
 	"go.sia.tech/jape"
+	"lukechampine.com/frand"
 )
 
+// ClientConfig contains the timeout, retry, and circuit breaking
+// parameters of a Client.
+type ClientConfig struct {
+	// Timeout limits the duration of a single request. Zero means no limit.
+	Timeout time.Duration
+
+	// BenchmarkTimeout limits the duration of an on-demand scan with a
+	// benchmark, which takes much longer than the other requests. Zero
+	// means no limit.
+	BenchmarkTimeout time.Duration
+
+	// Retries is the number of times a request is retried if the node
+	// cannot be reached. Only the requests that don't change the state of
+	// the node are retried.
+	Retries int
+
+	// RetryBackoff is the initial delay between the retries. It doubles
+	// with every retry, and a random jitter is added to it.
+	RetryBackoff time.Duration
+
+	// BreakerThreshold is the number of consecutive failed requests after
+	// which the requests are suspended. Zero disables circuit breaking.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long the requests stay suspended.
+	BreakerCooldown time.Duration
+}
+
+// DefaultClientConfig is the configuration used by NewClient.
+var DefaultClientConfig = ClientConfig{
+	Timeout:          time.Minute,
+	BenchmarkTimeout: 10 * time.Minute,
+	Retries:          2,
+	RetryBackoff:     time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  2 * time.Minute,
+}
+
 // A Client provides methods for interacting with a hsd API server.
 type Client struct {
-	c jape.Client
+	c       jape.Client
+	ctx     context.Context
+	cfg     ClientConfig
+	breaker *breaker
+
+	// consumer is the name the updates are requested under; empty for
+	// the default consumer.
+	consumer string
+}
+
+// WithContext returns a copy of the client that uses the provided context
+// for all requests. The copy shares the circuit breaker with the original.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	return &Client{
+		c:        c.c,
+		ctx:      ctx,
+		cfg:      c.cfg,
+		breaker:  c.breaker,
+		consumer: c.consumer,
+	}
+}
+
+// WithConsumer returns a copy of the client that requests and confirms
+// the HostDB updates under the given name, so that it gets all updates
+// regardless of the other consumers. The copy shares the circuit breaker
+// with the original.
+func (c *Client) WithConsumer(consumer string) *Client {
+	return &Client{
+		c:        c.c,
+		ctx:      c.ctx,
+		cfg:      c.cfg,
+		breaker:  c.breaker,
+		consumer: consumer,
+	}
+}
+
+// updatesQuery returns the query parameters of the updates requests.
+func (c *Client) updatesQuery(params url.Values) string {
+	if c.consumer != "" {
+		params.Set("consumer", c.consumer)
+	}
+	return params.Encode()
+}
+
+// isTransient returns true if the request failed because the node could
+// not be reached rather than because the node returned an error.
+func isTransient(err error) bool {
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// get performs a GET request, retrying it if the node cannot be reached.
+func (c *Client) get(route string, resp interface{}) error {
+	return c.do(true, func(jc *jape.Client) error { return jc.GET(route, resp) })
+}
+
+// getOnce performs a GET request that changes the state of the node, so
+// it is not retried.
+func (c *Client) getOnce(route string, resp interface{}) error {
+	return c.do(false, func(jc *jape.Client) error { return jc.GET(route, resp) })
+}
+
+// put performs a PUT request. It is not retried.
+func (c *Client) put(route string, req interface{}) error {
+	return c.do(false, func(jc *jape.Client) error { return jc.PUT(route, req) })
+}
+
+// post performs a POST request. It is not retried.
+func (c *Client) post(route string, req, resp interface{}) error {
+	return c.do(false, func(jc *jape.Client) error { return jc.POST(route, req, resp) })
+}
+
+// delete performs a DELETE request. It is not retried.
+func (c *Client) delete(route string) error {
+	return c.do(false, func(jc *jape.Client) error { return jc.DELETE(route) })
+}
+
+// do performs a request. If retry is true, the request is retried if the
+// node cannot be reached. Only the requests that don't change the state
+// of the node may be retried, because a request whose response got lost
+// may have been executed.
+func (c *Client) do(retry bool, fn func(*jape.Client) error) error {
+	return c.doWithTimeout(retry, c.cfg.Timeout, fn)
+}
+
+// doWithTimeout is like do but with its own limit on the duration of the
+// request.
+func (c *Client) doWithTimeout(retry bool, timeout time.Duration, fn func(*jape.Client) error) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	backoff := c.cfg.RetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = func() error {
+			reqCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				reqCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			return fn(c.c.WithContext(reqCtx))
+		}()
+		if err == nil || !retry || !isTransient(err) || attempt >= c.cfg.Retries || ctx.Err() != nil {
+			break
+		}
+		wait := backoff / 2
+		if backoff > 0 {
+			wait += time.Duration(frand.Intn(int(backoff)))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+
+	c.breaker.record(err == nil || !isTransient(err))
+	return err
 }
 
 // NodeStatus returns the status of the node.
 func (c *Client) NodeStatus() (resp NodeStatusResponse, err error) {
-	err = c.c.GET("/node/status", &resp)
+	err = c.get("/node/status", &resp)
 	return
 }
 
 // TxpoolTransactions returns all transactions in the transaction pool.
 func (c *Client) TxpoolTransactions(network string) (txns []types.Transaction, v2txns []types.V2Transaction, err error) {
 	var resp TxpoolTransactionsResponse
-	err = c.c.GET("/txpool/transactions?network="+network, &resp)
+	err = c.get("/txpool/transactions?network="+network, &resp)
 	return resp.Transactions, resp.V2Transactions, err
 }
 
 // TxpoolFee returns the recommended fee (per weight unit) to ensure a high
 // probability of inclusion in the next block.
 func (c *Client) TxpoolFee(network string) (resp types.Currency, err error) {
-	err = c.c.GET("/txpool/fee?network="+network, &resp)
+	err = c.get("/txpool/fee?network="+network, &resp)
 	return
 }
 
 // ConsensusNetwork returns the node's network metadata.
 func (c *Client) ConsensusNetwork(network string) (resp *consensus.Network, err error) {
 	resp = new(consensus.Network)
-	err = c.c.GET("/consensus/network?network="+network, resp)
+	err = c.get("/consensus/network?network="+network, resp)
 	return
 }
 
 // ConsensusTip returns the current tip index.
 func (c *Client) ConsensusTip(network string) (resp ConsensusTipResponse, err error) {
-	err = c.c.GET("/consensus/tip?network="+network, &resp)
+	err = c.get("/consensus/tip?network="+network, &resp)
 	return
 }
 
 // ConsensusTipState returns the current tip state.
 func (c *Client) ConsensusTipState(network string) (resp consensus.State, err error) {
-	err = c.c.GET("/consensus/tipstate?network="+network, &resp)
+	err = c.get("/consensus/tipstate?network="+network, &resp)
 	if err != nil {
 		return
 	}
@@ -60,51 +233,349 @@ func (c *Client) ConsensusTipState(network string) (resp consensus.State, err er
 
 // SyncerPeers returns the current peers of the syncer.
 func (c *Client) SyncerPeers(network string) (resp []GatewayPeer, err error) {
-	err = c.c.GET("/syncer/peers?network="+network, &resp)
+	err = c.get("/syncer/peers?network="+network, &resp)
 	return
 }
 
 // Address returns the address controlled by the wallet.
 func (c *Client) Address(network string) (resp types.Address, err error) {
-	err = c.c.GET("/wallet/address?network="+network, &resp)
+	err = c.get("/wallet/address?network="+network, &resp)
 	return
 }
 
 // Balance returns the wallet balance.
 func (c *Client) Balance(network string) (resp WalletBalanceResponse, err error) {
-	err = c.c.GET("/wallet/balance?network="+network, &resp)
+	err = c.get("/wallet/balance?network="+network, &resp)
 	return
 }
 
 // PoolTransactions returns all txpool transactions relevant to the wallet.
 func (c *Client) PoolTransactions(network string) (resp []wallet.PoolTransaction, err error) {
-	err = c.c.GET("/wallet/txpool?network="+network, &resp)
+	err = c.get("/wallet/txpool?network="+network, &resp)
 	return
 }
 
 // Outputs returns the set of unspent outputs controlled by the wallet.
 func (c *Client) Outputs(network string) (sc []types.SiacoinElement, sf []types.SiafundElement, err error) {
 	var resp WalletOutputsResponse
-	err = c.c.GET("/wallet/outputs?network="+network, &resp)
+	err = c.get("/wallet/outputs?network="+network, &resp)
 	return resp.SiacoinOutputs, resp.SiafundOutputs, err
 }
 
-// Updates returns a list of most recent HostDB updates.
-func (c *Client) Updates() (resp hostdb.HostUpdates, err error) {
-	err = c.c.GET("/hostdb/updates", &resp)
+// StuckTransactions returns the wallet transactions that failed to confirm
+// in time.
+func (c *Client) StuckTransactions(network string) (resp []walletutil.StuckTransaction, err error) {
+	var wsr WalletStuckResponse
+	err = c.get("/wallet/stuck?network="+network, &wsr)
+	return wsr.Transactions, err
+}
+
+// Updates returns a list of most recent HostDB updates. The limit is the
+// maximum number of rows of each kind; zero means the node's default.
+func (c *Client) Updates(limit int) (resp hostdb.HostUpdates, err error) {
+	err = c.get("/hostdb/updates?"+c.updatesQuery(url.Values{"limit": {strconv.Itoa(limit)}}), &resp)
 	return
 }
 
-// FinalizeUpdates confirms the receipt of the HostDB updates.
+// StreamUpdates retrieves the most recent HostDB updates as a stream and
+// passes them to fn one by one, so that large batches don't need to be
+// buffered in memory. The limit is the maximum number of rows of each kind;
+// zero means the node's default. The request is not retried, and the
+// timeout applies to the gaps between the received lines rather than to
+// the whole stream. The first item passed to fn carries the sequence
+// number of the batch. The returned ID must be passed to FinalizeUpdates.
+func (c *Client) StreamUpdates(limit int, fn func(hostdb.UpdateItem) error) (id hostdb.UpdateID, backlog int, err error) {
+	if err := c.breaker.allow(); err != nil {
+		return 0, 0, err
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Cancel the request if the node stalls.
+	var timer *time.Timer
+	if c.cfg.Timeout > 0 {
+		timer = time.AfterFunc(c.cfg.Timeout, cancel)
+		defer timer.Stop()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.c.BaseURL+"/hostdb/updates/stream?"+c.updatesQuery(url.Values{"limit": {strconv.Itoa(limit)}}), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if c.c.Password != "" {
+		req.SetBasicAuth("", c.c.Password)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.breaker.record(false)
+		return 0, 0, err
+	}
+	defer r.Body.Close()
+	c.breaker.record(true)
+	if r.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(r.Body)
+		return 0, 0, errors.New(string(msg))
+	}
+
+	dec := json.NewDecoder(r.Body)
+	for {
+		var item hostdb.UpdateItem
+		if err := dec.Decode(&item); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, 0, utils.AddContext(err, "couldn't read updates stream")
+		}
+		if timer != nil {
+			timer.Reset(c.cfg.Timeout)
+		}
+		switch {
+		case item.Error != "":
+			return 0, 0, errors.New(item.Error)
+		case item.ID != nil:
+			return *item.ID, item.Backlog, nil
+		}
+		if err := fn(item); err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+// WatchUpdates keeps a connection to the node open and calls fn with each
+// batch of updates the node pushes. The limit is the maximum number of rows
+// of each kind in a batch; zero means the node's default. Each batch must
+// be confirmed with FinalizeUpdates, otherwise it is pushed again. It
+// returns when the connection breaks or fn fails. The timeout applies to
+// the gaps between the received lines, which the node sends at least every
+// 30 seconds, and not to fn.
+func (c *Client) WatchUpdates(limit int, fn func(hostdb.HostUpdates) error) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Cancel the request if the node stalls.
+	var timer *time.Timer
+	if c.cfg.Timeout > 0 {
+		timer = time.AfterFunc(c.cfg.Timeout, cancel)
+		defer timer.Stop()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.c.BaseURL+"/hostdb/updates/push?"+c.updatesQuery(url.Values{"limit": {strconv.Itoa(limit)}}), nil)
+	if err != nil {
+		return err
+	}
+	if c.c.Password != "" {
+		req.SetBasicAuth("", c.c.Password)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.breaker.record(false)
+		return err
+	}
+	defer r.Body.Close()
+	c.breaker.record(true)
+	if r.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(r.Body)
+		return errors.New(string(msg))
+	}
+
+	dec := json.NewDecoder(r.Body)
+	var updates hostdb.HostUpdates
+	for {
+		var item hostdb.UpdateItem
+		if err := dec.Decode(&item); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return utils.AddContext(err, "couldn't read push connection")
+		}
+		if timer != nil {
+			timer.Reset(c.cfg.Timeout)
+		}
+		switch {
+		case item.Error != "":
+			return errors.New(item.Error)
+		case item.Seq != 0:
+			updates = hostdb.HostUpdates{}
+		case item.Host != nil:
+			updates.Hosts = append(updates.Hosts, *item.Host)
+		case item.Scan != nil:
+			updates.Scans = append(updates.Scans, *item.Scan)
+		case item.Benchmark != nil:
+			updates.Benchmarks = append(updates.Benchmarks, *item.Benchmark)
+		case item.ID != nil:
+			updates.ID, updates.Backlog = *item.ID, item.Backlog
+			if timer != nil {
+				timer.Stop()
+			}
+			if err := fn(updates); err != nil {
+				return err
+			}
+			if timer != nil {
+				timer.Reset(c.cfg.Timeout)
+			}
+			updates = hostdb.HostUpdates{}
+		}
+	}
+}
+
+// FinalizeUpdates confirms the receipt of the HostDB updates. The request
+// is not retried; if it fails, the updates are delivered again.
 func (c *Client) FinalizeUpdates(id hostdb.UpdateID) error {
-	return c.c.GET("/hostdb/updates/confirm?id="+hex.EncodeToString(id[:]), nil)
+	return c.getOnce("/hostdb/updates/confirm?"+c.updatesQuery(url.Values{"id": {strconv.FormatUint(id, 10)}}), nil)
+}
+
+// BenchmarkCost returns the estimated cost of benchmarking a host. If
+// sectors is zero, the number of sectors configured on the node is used.
+func (c *Client) BenchmarkCost(network string, pk types.PublicKey, sectors int) (resp BenchmarkCostResponse, err error) {
+	err = c.get(fmt.Sprintf("/hostdb/benchmark/cost?network=%s&host=%s&sectors=%d", network, pk, sectors), &resp)
+	return
+}
+
+// BenchmarkConfig returns the effective benchmark parameters of the node.
+func (c *Client) BenchmarkConfig() (resp BenchmarkConfigResponse, err error) {
+	err = c.get("/hostdb/benchmark/config", &resp)
+	return
+}
+
+// Budget returns the state of the daily benchmark budgets of the node.
+func (c *Client) Budget() (resp BudgetResponse, err error) {
+	err = c.get("/hostdb/budget", &resp)
+	return
+}
+
+// Spending returns the money spent on benchmarking the hosts. An empty
+// network means both networks, a zero public key all hosts.
+func (c *Client) Spending(network string, pk types.PublicKey) (resp SpendingResponse, err error) {
+	url := "/hostdb/spending?network=" + network
+	if pk != (types.PublicKey{}) {
+		url += "&host=" + pk.String()
+	}
+	err = c.get(url, &resp)
+	return
+}
+
+// LatencyProbes returns the latency probes of the hosts made after the
+// given time. An empty network means both networks.
+func (c *Client) LatencyProbes(network string, since time.Time) (resp []hostdb.HostProbes, err error) {
+	err = c.get(fmt.Sprintf("/hostdb/probes?network=%s&since=%s", network, since.UTC().Format(time.RFC3339)), &resp)
+	return
+}
+
+// RunDiagnostic asks the node to run a traceroute to the host. It returns
+// false if the host has been traced recently.
+func (c *Client) RunDiagnostic(network string, pk types.PublicKey) (bool, error) {
+	var resp DiagnosticResponse
+	err := c.post("/hostdb/diagnostics", DiagnosticRequest{Network: network, PublicKey: pk}, &resp)
+	return resp.Started, err
+}
+
+// Diagnostics returns the traceroute results obtained after the given
+// time. An empty network means both networks.
+func (c *Client) Diagnostics(network string, since time.Time) (resp []hostdb.Diagnostic, err error) {
+	err = c.get(fmt.Sprintf("/hostdb/diagnostics?network=%s&since=%s", network, since.UTC().Format(time.RFC3339Nano)), &resp)
+	return
+}
+
+// ScanHost scans the host immediately and, if benchmark is true,
+// benchmarks it afterwards. It returns when the results are available;
+// a request with a benchmark is limited by ClientConfig.BenchmarkTimeout
+// instead of ClientConfig.Timeout.
+func (c *Client) ScanHost(network string, pk types.PublicKey, benchmark bool) (resp hostdb.OnDemandResult, err error) {
+	timeout := c.cfg.Timeout
+	if benchmark {
+		timeout = c.cfg.BenchmarkTimeout
+	}
+	route := fmt.Sprintf("/hostdb/scan?network=%s&host=%s&benchmark=%t", network, pk, benchmark)
+	err = c.doWithTimeout(false, timeout, func(jc *jape.Client) error { return jc.POST(route, nil, &resp) })
+	return
+}
+
+// OptOuts returns the opt-outs the node honors.
+func (c *Client) OptOuts() (resp []hostdb.OptOut, err error) {
+	err = c.get("/hostdb/optouts", &resp)
+	return
+}
+
+// SetOptOuts replaces the opt-outs the node honors.
+func (c *Client) SetOptOuts(list []hostdb.OptOut) error {
+	return c.put("/hostdb/optouts", list)
+}
+
+// Blocklist returns the domains, IP addresses, subnets, and public keys
+// blocked by the node.
+func (c *Client) Blocklist() (resp []string, err error) {
+	err = c.get("/hostdb/blocklist", &resp)
+	return
+}
+
+// AddToBlocklist blocks the given entries on the node.
+func (c *Client) AddToBlocklist(entries []string) error {
+	return c.put("/hostdb/blocklist", entries)
+}
+
+// RemoveFromBlocklist unblocks the given entry on the node.
+func (c *Client) RemoveFromBlocklist(entry string) error {
+	return c.delete("/hostdb/blocklist?entry=" + url.QueryEscape(entry))
+}
+
+// Allowlist returns the state of the allowlist of the node.
+func (c *Client) Allowlist() (resp hostdb.Allowlist, err error) {
+	err = c.get("/hostdb/allowlist", &resp)
+	return
+}
+
+// AddToAllowlist adds the given hosts to the allowlist of the node.
+func (c *Client) AddToAllowlist(keys []types.PublicKey) error {
+	return c.put("/hostdb/allowlist", keys)
+}
+
+// RemoveFromAllowlist removes the given host from the allowlist of the
+// node.
+func (c *Client) RemoveFromAllowlist(pk types.PublicKey) error {
+	return c.delete("/hostdb/allowlist?host=" + pk.String())
+}
+
+// Ranks returns the host ranks known to the node.
+func (c *Client) Ranks() (resp []hostdb.HostRank, err error) {
+	err = c.get("/hostdb/ranks", &resp)
+	return
+}
+
+// SetRanks replaces the host ranks used by the adaptive benchmark
+// schedule of the node.
+func (c *Client) SetRanks(list []hostdb.HostRank) error {
+	return c.put("/hostdb/ranks", list)
 }
 
 // NewClient returns a client that communicates with a hsd server listening
 // on the specified address.
 func NewClient(addr, password string) *Client {
-	return &Client{c: jape.Client{
-		BaseURL:  addr,
-		Password: password,
-	}}
+	return NewClientWithConfig(addr, password, DefaultClientConfig)
+}
+
+// NewClientWithConfig returns a client with the custom timeout, retry, and
+// circuit breaking parameters.
+func NewClientWithConfig(addr, password string, cfg ClientConfig) *Client {
+	return &Client{
+		c: jape.Client{
+			BaseURL:  addr,
+			Password: password,
+		},
+		cfg: cfg,
+		breaker: &breaker{
+			threshold: cfg.BreakerThreshold,
+			cooldown:  cfg.BreakerCooldown,
+		},
+	}
 }
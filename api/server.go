@@ -1,8 +1,9 @@
 package api
 
 import (
-	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -24,6 +25,9 @@ type server struct {
 	sZen  *syncer.Syncer
 	w     *walletutil.Wallet
 	hdb   *hostdb.HostDB
+
+	started time.Time
+	updates func() UpdateStatus
 }
 
 func isSynced(s *syncer.Syncer) bool {
@@ -68,18 +72,30 @@ func (s *server) nodeStatusHandler(jc jape.Context) {
 		}
 	}
 
+	var update *UpdateStatus
+	if s.updates != nil {
+		us := s.updates()
+		update = &us
+	}
+
 	jc.Encode(NodeStatusResponse{
-		Version:   build.NodeVersion,
-		Height:    height,
-		HeightZen: heightZen,
+		Version:     build.NodeVersion,
+		GitRevision: build.GitRevision,
+		BuildTime:   build.BuildTime,
+		StartedAt:   s.started,
+		Height:      height,
+		HeightZen:   heightZen,
 		Balance: Balance{
 			Siacoins:         sc,
 			ImmatureSiacoins: immature,
+			LowFunds:         s.hdb.LowFunds("mainnet"),
 		},
 		BalanceZen: Balance{
 			Siacoins:         scZen,
 			ImmatureSiacoins: immatureZen,
+			LowFunds:         s.hdb.LowFunds("zen"),
 		},
+		Update: update,
 	})
 }
 
@@ -334,30 +350,451 @@ func (s *server) walletOutputsHandler(jc jape.Context) {
 	})
 }
 
+func (s *server) walletStuckHandler(jc jape.Context) {
+	var network string
+	if jc.DecodeForm("network", &network) != nil {
+		return
+	}
+	network = strings.ToLower(network)
+	if network != "" && network != "mainnet" && network != "zen" {
+		jc.Error(errors.New("wrong network parameter"), http.StatusBadRequest)
+		return
+	}
+	if network == "" {
+		network = "mainnet"
+	}
+
+	jc.Encode(WalletStuckResponse{
+		Network:      strings.ToUpper(string(network[0])) + network[1:],
+		Transactions: s.w.StuckTransactions(network),
+	})
+}
+
 func (s *server) hostDBUpdatesHandler(jc jape.Context) {
-	updates, err := s.hdb.RecentUpdates()
+	var limit int
+	var consumer string
+	if jc.DecodeForm("limit", &limit) != nil || jc.DecodeForm("consumer", &consumer) != nil {
+		return
+	}
+	updates, err := s.hdb.RecentUpdates(consumer, limit)
+	if errors.Is(err, hostdb.ErrInvalidConsumer) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
 	if jc.Check("couldn't receive HostDB updates", err) != nil {
 		return
 	}
 	jc.Encode(updates)
 }
 
+func (s *server) hostDBUpdatesStreamHandler(jc jape.Context) {
+	var limit int
+	var consumer string
+	if jc.DecodeForm("limit", &limit) != nil || jc.DecodeForm("consumer", &consumer) != nil {
+		return
+	}
+	if err := hostdb.ValidateConsumer(consumer); err != nil {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	w := jc.ResponseWriter
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	var count int
+	id, backlog, err := s.hdb.StreamUpdates(consumer, limit, func(item hostdb.UpdateItem) error {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	// The status code has already been sent, so the error can only be
+	// reported in the last line.
+	if err != nil {
+		enc.Encode(hostdb.UpdateItem{Error: err.Error()})
+		return
+	}
+	enc.Encode(hostdb.UpdateItem{ID: &id, Backlog: backlog})
+}
+
+// pushDelay is how long the updates are collected after the first one
+// before they are pushed to the portal.
+const pushDelay = 5 * time.Second
+
+// pushKeepAlive is how often an idle push connection is kept alive.
+const pushKeepAlive = 30 * time.Second
+
+// hostDBUpdatesPushHandler keeps the connection open and pushes a batch of
+// updates to the portal as soon as new updates are available, so that it
+// doesn't need to poll the node. The batches are sent in the same format
+// as by /hostdb/updates/stream, one after another, and an empty line keeps
+// the connection alive. A batch not confirmed yet is sent again unchanged.
+func (s *server) hostDBUpdatesPushHandler(jc jape.Context) {
+	var limit int
+	var consumer string
+	if jc.DecodeForm("limit", &limit) != nil || jc.DecodeForm("consumer", &consumer) != nil {
+		return
+	}
+	if err := hostdb.ValidateConsumer(consumer); err != nil {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	w := jc.ResponseWriter
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	ctx := jc.Request.Context()
+
+	push := func() error {
+		id, backlog, err := s.hdb.StreamUpdates(consumer, limit, func(item hostdb.UpdateItem) error {
+			return enc.Encode(item)
+		})
+		if err != nil {
+			// The batch is incomplete, so the connection is closed.
+			enc.Encode(hostdb.UpdateItem{Error: err.Error()})
+			return err
+		}
+		return enc.Encode(hostdb.UpdateItem{ID: &id, Backlog: backlog})
+	}
+
+	// There may be a backlog already.
+	updated := true
+	for {
+		if updated {
+			if err := push(); err != nil {
+				return
+			}
+		} else if err := enc.Encode(hostdb.UpdateItem{}); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		updated = false
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.hdb.UpdatesSignal():
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pushDelay):
+			}
+			updated = true
+		case <-time.After(pushKeepAlive):
+		}
+	}
+}
+
 func (s *server) hostDBUpdatesConfirmHandler(jc jape.Context) {
-	var id string
-	if jc.DecodeForm("id", &id) != nil {
+	var id hostdb.UpdateID
+	var consumer string
+	if jc.DecodeForm("id", &id) != nil || jc.DecodeForm("consumer", &consumer) != nil {
+		return
+	}
+
+	err := s.hdb.FinalizeUpdates(consumer, id)
+	if errors.Is(err, hostdb.ErrInvalidConsumer) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	jc.Check("couldn't finalize updates", err)
+}
+
+func (s *server) hostDBBenchmarkCostHandler(jc jape.Context) {
+	var network string
+	if jc.DecodeForm("network", &network) != nil {
+		return
+	}
+	network = strings.ToLower(network)
+	if network != "" && network != "mainnet" && network != "zen" {
+		jc.Error(errors.New("wrong network parameter"), http.StatusBadRequest)
+		return
+	}
+	if network == "" {
+		network = "mainnet"
+	}
+	var pk types.PublicKey
+	if jc.DecodeForm("host", &pk) != nil {
+		return
+	}
+	var sectors int
+	if jc.DecodeForm("sectors", &sectors) != nil {
+		return
+	}
+	if sectors < 0 || sectors > hostdb.MaxBenchmarkSectors {
+		jc.Error(fmt.Errorf("sectors must be between 0 and %d", hostdb.MaxBenchmarkSectors), http.StatusBadRequest)
+		return
+	}
+
+	estimate, err := s.hdb.EstimateBenchmarkCost(network, pk, sectors)
+	if jc.Check("couldn't estimate benchmark cost", err) != nil {
+		return
+	}
+	jc.Encode(BenchmarkCostResponse{
+		Network:               strings.ToUpper(string(network[0])) + network[1:],
+		PublicKey:             pk,
+		BenchmarkCostEstimate: estimate,
+	})
+}
+
+func (s *server) hostDBOptOutsHandler(jc jape.Context) {
+	jc.Encode(s.hdb.OptOuts())
+}
+
+func (s *server) hostDBOptOutsUpdateHandler(jc jape.Context) {
+	var list []hostdb.OptOut
+	if jc.Decode(&list) != nil {
+		return
+	}
+	err := s.hdb.SetOptOuts(list)
+	if errors.Is(err, hostdb.ErrInvalidOptOut) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	jc.Check("couldn't update opt-outs", err)
+}
+
+func (s *server) hostDBBlocklistHandler(jc jape.Context) {
+	jc.Encode(s.hdb.Blocklist())
+}
+
+func (s *server) hostDBBlocklistAddHandler(jc jape.Context) {
+	var entries []string
+	if jc.Decode(&entries) != nil {
+		return
+	}
+	err := s.hdb.AddToBlocklist(entries)
+	if errors.Is(err, hostdb.ErrInvalidBlocklistEntry) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	jc.Check("couldn't update blocklist", err)
+}
+
+func (s *server) hostDBBlocklistRemoveHandler(jc jape.Context) {
+	var entry string
+	if jc.DecodeForm("entry", &entry) != nil {
+		return
+	}
+	if entry == "" {
+		jc.Error(errors.New("entry not provided"), http.StatusBadRequest)
+		return
+	}
+	err := s.hdb.RemoveFromBlocklist([]string{entry})
+	if errors.Is(err, hostdb.ErrInvalidBlocklistEntry) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	jc.Check("couldn't update blocklist", err)
+}
+
+func (s *server) hostDBAllowlistHandler(jc jape.Context) {
+	jc.Encode(s.hdb.Allowlist())
+}
+
+func (s *server) hostDBAllowlistAddHandler(jc jape.Context) {
+	var keys []types.PublicKey
+	if jc.Decode(&keys) != nil {
+		return
+	}
+	jc.Check("couldn't update allowlist", s.hdb.AddToAllowlist(keys))
+}
+
+func (s *server) hostDBAllowlistRemoveHandler(jc jape.Context) {
+	var pk types.PublicKey
+	if jc.DecodeForm("host", &pk) != nil {
+		return
+	}
+	jc.Check("couldn't update allowlist", s.hdb.RemoveFromAllowlist([]types.PublicKey{pk}))
+}
+
+func (s *server) hostDBRanksHandler(jc jape.Context) {
+	jc.Encode(s.hdb.Ranks())
+}
+
+func (s *server) hostDBRanksUpdateHandler(jc jape.Context) {
+	var list []hostdb.HostRank
+	if jc.Decode(&list) != nil {
+		return
+	}
+	err := s.hdb.SetRanks(list)
+	if errors.Is(err, hostdb.ErrInvalidRank) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	jc.Check("couldn't update ranks", err)
+}
+
+func (s *server) hostDBBenchmarkConfigHandler(jc jape.Context) {
+	jc.Encode(BenchmarkConfigResponse{s.hdb.BenchmarkSettings()})
+}
+
+func (s *server) hostDBBudgetHandler(jc jape.Context) {
+	jc.Encode(BudgetResponse{
+		Budgets: []hostdb.BudgetState{
+			s.hdb.Budget("mainnet"),
+			s.hdb.Budget("zen"),
+		},
+	})
+}
+
+func (s *server) hostDBSpendingHandler(jc jape.Context) {
+	var network string
+	if jc.DecodeForm("network", &network) != nil {
+		return
+	}
+	network = strings.ToLower(network)
+	if network != "" && network != "mainnet" && network != "zen" {
+		jc.Error(errors.New("wrong network parameter"), http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if jc.DecodeForm("host", &pk) != nil {
+		return
+	}
+
+	networks := []string{"mainnet", "zen"}
+	if network != "" {
+		networks = []string{network}
+	}
+	resp := SpendingResponse{
+		Hosts: []hostdb.HostSpending{},
+	}
+	for _, n := range networks {
+		resp.Totals = append(resp.Totals, s.hdb.NetworkSpending(n))
+		for _, hs := range s.hdb.HostSpending(n) {
+			if pk == (types.PublicKey{}) || hs.PublicKey == pk {
+				resp.Hosts = append(resp.Hosts, hs)
+			}
+		}
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) hostDBProbesHandler(jc jape.Context) {
+	var network string
+	if jc.DecodeForm("network", &network) != nil {
+		return
+	}
+	network = strings.ToLower(network)
+	if network != "" && network != "mainnet" && network != "zen" {
+		jc.Error(errors.New("wrong network parameter"), http.StatusBadRequest)
+		return
+	}
+	var since time.Time
+	if jc.DecodeForm("since", &since) != nil {
 		return
 	}
 
-	updateID, err := hex.DecodeString(id)
-	if jc.Check("wrong update ID provided", err) != nil {
+	networks := []string{"mainnet", "zen"}
+	if network != "" {
+		networks = []string{network}
+	}
+	resp := []hostdb.HostProbes{}
+	for _, n := range networks {
+		resp = append(resp, s.hdb.LatencyProbes(n, since)...)
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) hostDBDiagnosticsHandler(jc jape.Context) {
+	var network string
+	if jc.DecodeForm("network", &network) != nil {
+		return
+	}
+	network = strings.ToLower(network)
+	if network != "" && network != "mainnet" && network != "zen" {
+		jc.Error(errors.New("wrong network parameter"), http.StatusBadRequest)
+		return
+	}
+	var since time.Time
+	if jc.DecodeForm("since", &since) != nil {
 		return
 	}
 
-	jc.Check("couldn't finalize updates", s.hdb.FinalizeUpdates(hostdb.UpdateID(updateID)))
+	networks := []string{"mainnet", "zen"}
+	if network != "" {
+		networks = []string{network}
+	}
+	resp := []hostdb.Diagnostic{}
+	for _, n := range networks {
+		resp = append(resp, s.hdb.Diagnostics(n, since)...)
+	}
+	jc.Encode(resp)
 }
 
-// NewServer returns an HTTP handler that serves the hsd API.
-func NewServer(cm *chain.Manager, cmZen *chain.Manager, s *syncer.Syncer, sZen *syncer.Syncer, w *walletutil.Wallet, hdb *hostdb.HostDB) http.Handler {
+func (s *server) hostDBDiagnosticsRunHandler(jc jape.Context) {
+	var dr DiagnosticRequest
+	if jc.Decode(&dr) != nil {
+		return
+	}
+	dr.Network = strings.ToLower(dr.Network)
+	if dr.Network != "mainnet" && dr.Network != "zen" {
+		jc.Error(errors.New("wrong network parameter"), http.StatusBadRequest)
+		return
+	}
+	started, err := s.hdb.RunDiagnostic(dr.Network, dr.PublicKey)
+	if errors.Is(err, hostdb.ErrUnknownHost) {
+		jc.Error(err, http.StatusBadRequest)
+		return
+	}
+	if jc.Check("couldn't run diagnostic", err) != nil {
+		return
+	}
+	jc.Encode(DiagnosticResponse{Started: started})
+}
+
+func (s *server) hostDBScanHandler(jc jape.Context) {
+	var network string
+	if jc.DecodeForm("network", &network) != nil {
+		return
+	}
+	network = strings.ToLower(network)
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		jc.Error(errors.New("wrong network parameter"), http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if jc.DecodeForm("host", &pk) != nil {
+		return
+	}
+	var benchmark bool
+	if jc.DecodeForm("benchmark", &benchmark) != nil {
+		return
+	}
+	result, err := s.hdb.ScanHostNow(network, pk, benchmark)
+	switch {
+	case errors.Is(err, hostdb.ErrUnknownHost), errors.Is(err, hostdb.ErrScanNotAllowed):
+		jc.Error(err, http.StatusBadRequest)
+		return
+	case errors.Is(err, hostdb.ErrScanInProgress):
+		jc.Error(err, http.StatusConflict)
+		return
+	case errors.Is(err, hostdb.ErrScanTooSoon):
+		jc.Error(err, http.StatusTooManyRequests)
+		return
+	}
+	if jc.Check("couldn't scan host", err) != nil {
+		return
+	}
+	jc.Encode(result)
+}
+
+// NewServer returns an HTTP handler that serves the hsd API. updates may
+// be nil if the update check is disabled.
+func NewServer(cm *chain.Manager, cmZen *chain.Manager, s *syncer.Syncer, sZen *syncer.Syncer, w *walletutil.Wallet, hdb *hostdb.HostDB, updates func() UpdateStatus) http.Handler {
 	srv := server{
 		cm:    cm,
 		cmZen: cmZen,
@@ -365,6 +802,9 @@ func NewServer(cm *chain.Manager, cmZen *chain.Manager, s *syncer.Syncer, sZen *
 		sZen:  sZen,
 		w:     w,
 		hdb:   hdb,
+
+		started: time.Now(),
+		updates: updates,
 	}
 	return jape.Mux(map[string]jape.Handler{
 		"GET /node/status": srv.nodeStatusHandler,
@@ -382,8 +822,29 @@ func NewServer(cm *chain.Manager, cmZen *chain.Manager, s *syncer.Syncer, sZen *
 		"GET    /wallet/balance": srv.walletBalanceHandler,
 		"GET    /wallet/txpool":  srv.walletTxpoolHandler,
 		"GET    /wallet/outputs": srv.walletOutputsHandler,
-
-		"GET    /hostdb/updates":         srv.hostDBUpdatesHandler,
-		"GET    /hostdb/updates/confirm": srv.hostDBUpdatesConfirmHandler,
+		"GET    /wallet/stuck":   srv.walletStuckHandler,
+
+		"GET    /hostdb/updates":          srv.hostDBUpdatesHandler,
+		"GET    /hostdb/updates/confirm":  srv.hostDBUpdatesConfirmHandler,
+		"GET    /hostdb/updates/stream":   srv.hostDBUpdatesStreamHandler,
+		"GET    /hostdb/updates/push":     srv.hostDBUpdatesPushHandler,
+		"GET    /hostdb/benchmark/cost":   srv.hostDBBenchmarkCostHandler,
+		"GET    /hostdb/benchmark/config": srv.hostDBBenchmarkConfigHandler,
+		"GET    /hostdb/budget":           srv.hostDBBudgetHandler,
+		"GET    /hostdb/spending":         srv.hostDBSpendingHandler,
+		"GET    /hostdb/probes":           srv.hostDBProbesHandler,
+		"GET    /hostdb/diagnostics":      srv.hostDBDiagnosticsHandler,
+		"POST   /hostdb/diagnostics":      srv.hostDBDiagnosticsRunHandler,
+		"POST   /hostdb/scan":             srv.hostDBScanHandler,
+		"GET    /hostdb/optouts":          srv.hostDBOptOutsHandler,
+		"PUT    /hostdb/optouts":          srv.hostDBOptOutsUpdateHandler,
+		"GET    /hostdb/ranks":            srv.hostDBRanksHandler,
+		"PUT    /hostdb/ranks":            srv.hostDBRanksUpdateHandler,
+		"GET    /hostdb/blocklist":        srv.hostDBBlocklistHandler,
+		"PUT    /hostdb/blocklist":        srv.hostDBBlocklistAddHandler,
+		"DELETE /hostdb/blocklist":        srv.hostDBBlocklistRemoveHandler,
+		"GET    /hostdb/allowlist":        srv.hostDBAllowlistHandler,
+		"PUT    /hostdb/allowlist":        srv.hostDBAllowlistAddHandler,
+		"DELETE /hostdb/allowlist":        srv.hostDBAllowlistRemoveHandler,
 	})
 }
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+const (
+	// alertCheckInterval determines how often the alerts are evaluated.
+	alertCheckInterval = time.Minute
+
+	// maxAlertsPerHost is the maximum number of alerts set on a host.
+	maxAlertsPerHost = 10
+
+	// maxAlertRequestSize is the maximum size of an alert request in bytes.
+	maxAlertRequestSize = 16 << 10
+
+	// maxAlertTarget is the maximum length of the alert target in bytes.
+	maxAlertTarget = 255
+
+	// notificationTimeout is the timeout of a single notification call.
+	notificationTimeout = 10 * time.Second
+
+	// notificationAttempts is the number of times a failed notification
+	// call is attempted before the notification is dropped.
+	notificationAttempts = 3
+)
+
+// Alert channels.
+const (
+	channelWebhook  = "webhook"
+	channelDiscord  = "discord"
+	channelTelegram = "telegram"
+)
+
+// Notification types.
+const (
+	alertOffline = "offline"
+	alertOnline  = "online"
+	alertScore   = "score"
+	alertPrices  = "prices"
+	alertBench   = "benchmark"
+)
+
+var (
+	errAlertNotFound    = errors.New("alert not found")
+	errTooManyAlerts    = errors.New("too many alerts")
+	errInvalidTarget    = errors.New("invalid target")
+	errInvalidChannel   = errors.New("unsupported channel")
+	errNoAlertCondition = errors.New("no alert condition set")
+)
+
+// hostAlert fires the notifications about a watched host.
+type hostAlert struct {
+	ID          int64           `json:"id"`
+	Network     string          `json:"network"`
+	PublicKey   types.PublicKey `json:"publicKey"`
+	Channel     string          `json:"channel"`
+	Target      string          `json:"target"`
+	Offline     bool            `json:"offline"`
+	MinScore    float64         `json:"minScore"`
+	PriceChange float64         `json:"priceChange"` // in percent
+	Benchmark   bool            `json:"benchmark"`
+	CreatedAt   time.Time       `json:"createdAt"`
+
+	secret string
+	state  alertState
+}
+
+// alertState is the state of the host when the alert was last evaluated.
+// It is kept in memory only, so the alerts are re-armed after a restart.
+type alertState struct {
+	initialized bool
+	online      bool
+	belowScore  bool
+	prices      rhpv2.HostSettings
+	benchFailed bool
+}
+
+type alertRequest struct {
+	Network     string          `json:"network"`
+	PublicKey   types.PublicKey `json:"publicKey"`
+	Channel     string          `json:"channel"`
+	Target      string          `json:"target"`
+	Offline     bool            `json:"offline"`
+	MinScore    float64         `json:"minScore"`
+	PriceChange float64         `json:"priceChange"`
+	Benchmark   bool            `json:"benchmark"`
+}
+
+type alertResponse struct {
+	ID     int64  `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// alertNotification is sent when an alert fires.
+type alertNotification struct {
+	AlertID    int64           `json:"alertId"`
+	Type       string          `json:"type"`
+	Network    string          `json:"network"`
+	PublicKey  types.PublicKey `json:"publicKey"`
+	NetAddress string          `json:"netAddress"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Message    string          `json:"message"`
+	Score      float64         `json:"score"`
+}
+
+// pendingNotification is a notification waiting to be delivered.
+type pendingNotification struct {
+	channel      string
+	target       string
+	secret       string
+	notification alertNotification
+}
+
+// alertManager keeps the alerts and delivers the notifications.
+type alertManager struct {
+	mu        sync.Mutex
+	alerts    map[int64]*hostAlert
+	notifiers map[string]notifier
+	client    *http.Client
+}
+
+func newAlertManager(notifiers map[string]notifier) *alertManager {
+	return &alertManager{
+		alerts:    make(map[int64]*hostAlert),
+		notifiers: notifiers,
+		client:    &http.Client{Timeout: notificationTimeout},
+	}
+}
+
+// count returns the number of alerts set on the host.
+func (am *alertManager) count(network string, pk types.PublicKey) (n int) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	for _, a := range am.alerts {
+		if a.Network == network && a.PublicKey == pk {
+			n++
+		}
+	}
+	return
+}
+
+// get returns the alert if the secret matches.
+func (am *alertManager) get(id int64, secret string) (hostAlert, bool) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	a, ok := am.alerts[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(a.secret), []byte(secret)) != 1 {
+		return hostAlert{}, false
+	}
+	return *a, true
+}
+
+// validateTarget checks if the notifications can be sent to the target
+// over the channel.
+func (am *alertManager) validateTarget(channel, target string) error {
+	nt, ok := am.notifiers[channel]
+	if !ok {
+		return errInvalidChannel
+	}
+	if len(target) > maxAlertTarget {
+		return errInvalidTarget
+	}
+	return nt.validate(target)
+}
+
+// loadAlerts loads the alerts from the database.
+func (api *portalAPI) loadAlerts() error {
+	rows, err := api.db.Query(`
+		SELECT
+			id,
+			network,
+			public_key,
+			channel,
+			target,
+			secret,
+			offline,
+			min_score,
+			price_change,
+			benchmark,
+			created_at
+		FROM alerts
+	`)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query alerts")
+	}
+	defer rows.Close()
+
+	alerts := make(map[int64]*hostAlert)
+	for rows.Next() {
+		a := new(hostAlert)
+		pk := make([]byte, 32)
+		var created int64
+		if err := rows.Scan(
+			&a.ID,
+			&a.Network,
+			&pk,
+			&a.Channel,
+			&a.Target,
+			&a.secret,
+			&a.Offline,
+			&a.MinScore,
+			&a.PriceChange,
+			&a.Benchmark,
+			&created,
+		); err != nil {
+			return utils.AddContext(err, "couldn't decode alert")
+		}
+		a.PublicKey = types.PublicKey(pk)
+		a.CreatedAt = time.Unix(created, 0)
+		alerts[a.ID] = a
+	}
+	if err := rows.Err(); err != nil {
+		return utils.AddContext(err, "couldn't load alerts")
+	}
+
+	api.alerts.mu.Lock()
+	for id, a := range alerts {
+		api.alerts.alerts[id] = a
+	}
+	api.alerts.mu.Unlock()
+
+	return nil
+}
+
+// saveAlert adds a new alert and returns it.
+func (api *portalAPI) saveAlert(ar alertRequest) (*hostAlert, error) {
+	if api.alerts.count(ar.Network, ar.PublicKey) >= maxAlertsPerHost {
+		return nil, errTooManyAlerts
+	}
+
+	a := &hostAlert{
+		Network:     ar.Network,
+		PublicKey:   ar.PublicKey,
+		Channel:     ar.Channel,
+		Target:      ar.Target,
+		Offline:     ar.Offline,
+		MinScore:    ar.MinScore,
+		PriceChange: ar.PriceChange,
+		Benchmark:   ar.Benchmark,
+		CreatedAt:   time.Now(),
+		secret:      hex.EncodeToString(frand.Bytes(16)),
+	}
+
+	_, err := api.db.Exec(`
+		INSERT INTO alerts (
+			network,
+			public_key,
+			channel,
+			target,
+			secret,
+			offline,
+			min_score,
+			price_change,
+			benchmark,
+			created_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		a.Network,
+		a.PublicKey[:],
+		a.Channel,
+		a.Target,
+		a.secret,
+		a.Offline,
+		a.MinScore,
+		a.PriceChange,
+		a.Benchmark,
+		a.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't save alert")
+	}
+
+	// The secret is unique, so it identifies the new row on every backend.
+	err = api.db.QueryRow("SELECT id FROM alerts WHERE secret = ?", a.secret).Scan(&a.ID)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't get alert ID")
+	}
+
+	api.alerts.mu.Lock()
+	api.alerts.alerts[a.ID] = a
+	api.alerts.mu.Unlock()
+
+	return a, nil
+}
+
+// deleteAlert removes the alert if the secret matches.
+func (api *portalAPI) deleteAlert(id int64, secret string) error {
+	if _, ok := api.alerts.get(id, secret); !ok {
+		return errAlertNotFound
+	}
+
+	if _, err := api.db.Exec("DELETE FROM alerts WHERE id = ?", id); err != nil {
+		return utils.AddContext(err, "couldn't delete alert")
+	}
+
+	api.alerts.mu.Lock()
+	delete(api.alerts.alerts, id)
+	api.alerts.mu.Unlock()
+
+	return nil
+}
+
+// priceDeviation returns the largest relative change of the prices in
+// percent.
+func priceDeviation(os, ns rhpv2.HostSettings) (change float64) {
+	pairs := [][2]types.Currency{
+		{os.StoragePrice, ns.StoragePrice},
+		{os.Collateral, ns.Collateral},
+		{os.UploadBandwidthPrice, ns.UploadBandwidthPrice},
+		{os.DownloadBandwidthPrice, ns.DownloadBandwidthPrice},
+	}
+	for _, p := range pairs {
+		o, n := p[0].Siacoins(), p[1].Siacoins()
+		if o == n {
+			continue
+		}
+		if o == 0 {
+			return math.Inf(1)
+		}
+		change = max(change, math.Abs(n-o)/o*100)
+	}
+	return
+}
+
+// evaluate compares the host with its state at the previous evaluation
+// and returns the notifications to send.
+func (a *hostAlert) evaluate(host *portalHost) (notifications []alertNotification) {
+	online := isOnline(*host)
+	below := a.MinScore > 0 && host.Score.TotalScore < a.MinScore
+	benchFailed, benchKnown := failingBenchmark(host)
+	if !a.state.initialized {
+		a.state = alertState{
+			initialized: true,
+			online:      online,
+			belowScore:  below,
+			prices:      host.Settings,
+			benchFailed: benchFailed,
+		}
+		return nil
+	}
+
+	notify := func(typ, msg string) {
+		notifications = append(notifications, alertNotification{
+			AlertID:    a.ID,
+			Type:       typ,
+			Network:    a.Network,
+			PublicKey:  a.PublicKey,
+			NetAddress: host.NetAddress,
+			Timestamp:  time.Now(),
+			Message:    msg,
+			Score:      host.Score.TotalScore,
+		})
+	}
+
+	if a.Offline && online != a.state.online {
+		if online {
+			notify(alertOnline, "host is back online")
+		} else {
+			notify(alertOffline, "host went offline")
+		}
+	}
+	if below && !a.state.belowScore {
+		notify(alertScore, fmt.Sprintf("score dropped below %v", a.MinScore))
+	}
+	if a.PriceChange > 0 {
+		if change := priceDeviation(a.state.prices, host.Settings); change >= a.PriceChange {
+			notify(alertPrices, fmt.Sprintf("prices changed by %.1f%%", change))
+			a.state.prices = host.Settings
+		}
+	}
+
+	if a.Benchmark && benchKnown && benchFailed && !a.state.benchFailed {
+		notify(alertBench, "latest benchmark failed")
+	}
+
+	a.state.online = online
+	a.state.belowScore = below
+	if benchKnown {
+		a.state.benchFailed = benchFailed
+	}
+	return
+}
+
+// checkAlerts evaluates all alerts and sends the notifications.
+func (api *portalAPI) checkAlerts() {
+	var pending []pendingNotification
+	hosts := api.hosts.load()
+	api.alerts.mu.Lock()
+	for _, a := range api.alerts.alerts {
+		host, ok := hosts[a.Network][a.PublicKey]
+		if !ok {
+			continue
+		}
+		for _, n := range a.evaluate(host) {
+			pending = append(pending, pendingNotification{
+				channel:      a.Channel,
+				target:       a.Target,
+				secret:       a.secret,
+				notification: n,
+			})
+		}
+	}
+	api.alerts.mu.Unlock()
+
+	for _, p := range pending {
+		go api.deliverNotification(p)
+	}
+}
+
+// deliverNotification sends the notification over the channel of the
+// alert, retrying with a growing delay if it fails.
+func (api *portalAPI) deliverNotification(p pendingNotification) {
+	nt, ok := api.alerts.notifiers[p.channel]
+	if !ok {
+		api.log.Warn("couldn't deliver alert notification", zap.Int64("alert", p.notification.AlertID), zap.String("channel", p.channel), zap.Error(errInvalidChannel))
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt < notificationAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-api.stopChan:
+				return
+			case <-time.After(time.Duration(1<<attempt) * time.Second):
+			}
+		}
+		if err = nt.send(api.alerts.client, p.target, p.secret, p.notification); err == nil {
+			return
+		}
+	}
+	api.log.Warn("couldn't deliver alert notification", zap.Int64("alert", p.notification.AlertID), zap.String("channel", p.channel), zap.Error(err))
+}
+
+func (api *portalAPI) alertsCreateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if api.mirrorURL != "" {
+		writeError(w, "alerts are not accepted by a mirror", http.StatusForbidden)
+		return
+	}
+
+	var ar alertRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAlertRequestSize)).Decode(&ar); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	ar.Network = strings.ToLower(ar.Network)
+	if ar.Network == "" {
+		ar.Network = "mainnet"
+	}
+	if ar.Network != "mainnet" && ar.Network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	ar.Channel = strings.ToLower(ar.Channel)
+	if ar.Channel == "" {
+		ar.Channel = channelWebhook
+	}
+	ar.Target = strings.TrimSpace(ar.Target)
+	if err := api.alerts.validateTarget(ar.Channel, ar.Target); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ar.MinScore < 0 || ar.PriceChange < 0 {
+		writeError(w, "invalid threshold", http.StatusBadRequest)
+		return
+	}
+	if !ar.Offline && ar.MinScore == 0 && ar.PriceChange == 0 && !ar.Benchmark {
+		writeError(w, errNoAlertCondition.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := api.hosts.load()[ar.Network][ar.PublicKey]; !ok {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	a, err := api.saveAlert(ar)
+	if errors.Is(err, errTooManyAlerts) {
+		writeError(w, "too many alerts", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't save alert", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, alertResponse{ID: a.ID, Secret: a.secret})
+}
+
+// alertParams parses the ID and the secret of the alert.
+func alertParams(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (int64, string, bool) {
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid alert ID", http.StatusBadRequest)
+		return 0, "", false
+	}
+	secret := req.FormValue("secret")
+	if secret == "" {
+		writeError(w, "secret not provided", http.StatusBadRequest)
+		return 0, "", false
+	}
+	return id, secret, true
+}
+
+func (api *portalAPI) alertsGetHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	id, secret, ok := alertParams(w, req, ps)
+	if !ok {
+		return
+	}
+	a, ok := api.alerts.get(id, secret)
+	if !ok {
+		writeError(w, "alert not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, a)
+}
+
+func (api *portalAPI) alertsDeleteHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	id, secret, ok := alertParams(w, req, ps)
+	if !ok {
+		return
+	}
+	err := api.deleteAlert(id, secret)
+	if errors.Is(err, errAlertNotFound) {
+		writeError(w, "alert not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't delete alert", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,14 +2,16 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"log"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -17,6 +19,7 @@ import (
 	"github.com/mike76-dev/hostscore/external"
 	"github.com/mike76-dev/hostscore/hostdb"
 	"github.com/mike76-dev/hostscore/internal/build"
+	"github.com/mike76-dev/hostscore/internal/sqldb"
 	rhpv2 "go.sia.tech/core/rhp/v2"
 	rhpv3 "go.sia.tech/core/rhp/v3"
 	"go.sia.tech/core/types"
@@ -36,6 +39,7 @@ type hostsResponse struct {
 	Hosts []portalHost `json:"hosts"`
 	More  bool         `json:"more"`
 	Total int          `json:"total"`
+	Next  int          `json:"next,omitempty"` // cursor of the next page
 }
 
 type keysResponse struct {
@@ -51,23 +55,73 @@ type networkHostsResponse struct {
 	Hosts hostCount `json:"hosts"`
 }
 
+// storageStats sums up the storage of a group of online hosts.
+type storageStats struct {
+	Hosts        int    `json:"hosts"`
+	TotalStorage uint64 `json:"totalStorage"`
+	UsedStorage  uint64 `json:"usedStorage"`
+}
+
+// add adds the storage of the host to the stats.
+func (ss *storageStats) add(host portalHost) {
+	ss.Hosts++
+	ss.TotalStorage += host.Settings.TotalStorage
+	if host.Settings.TotalStorage > host.Settings.RemainingStorage {
+		ss.UsedStorage += host.Settings.TotalStorage - host.Settings.RemainingStorage
+	}
+}
+
+type networkStorage struct {
+	storageStats
+	Countries map[string]storageStats `json:"countries"`
+	Tiers     map[string]storageStats `json:"tiers"`
+}
+
+type networkStorageResponse struct {
+	Storage networkStorage `json:"storage"`
+}
+
 type scansResponse struct {
 	Scans []scanHistory `json:"scans"`
 }
 
 type benchmarksResponse struct {
-	Benchmarks []hostdb.BenchmarkHistory `json:"benchmarks"`
+	Benchmarks []hostdb.BenchmarkHistory   `json:"benchmarks"`
+	Regions    map[string]regionBenchmarks `json:"regions,omitempty"`
 }
 
 type networkStatus struct {
-	Height  uint64 `json:"height"`
-	Balance string `json:"balance"`
+	Height   uint64 `json:"height"`
+	Balance  string `json:"balance"`
+	LowFunds bool   `json:"lowFunds"`
 }
 
 type nodeStatus struct {
-	Online   bool                     `json:"online"`
-	Version  string                   `json:"version"`
-	Networks map[string]networkStatus `json:"networks"`
+	Online      bool                     `json:"online"`
+	Standby     bool                     `json:"standby"`
+	Version     string                   `json:"version"`
+	GitRevision string                   `json:"gitRevision,omitempty"`
+	StartedAt   time.Time                `json:"startedAt"`
+	Update      *client.UpdateStatus     `json:"update,omitempty"`
+	Networks    map[string]networkStatus `json:"networks"`
+	Updates     updatesSchedule          `json:"updates"`
+	Location    nodeLocation             `json:"location"`
+}
+
+// nodeInfo describes the software of a node, so that the measurements
+// can be attributed to a specific build.
+type nodeInfo struct {
+	Name        string        `json:"name"`
+	Online      bool          `json:"online"`
+	Version     string        `json:"version"`
+	GitRevision string        `json:"gitRevision"`
+	Networks    []string      `json:"networks"`
+	Region      string        `json:"region"`
+	Uptime      time.Duration `json:"uptime"`
+	LastRestart time.Time     `json:"lastRestart"`
+
+	// UpdateAvailable is only reported by the nodes that check for updates.
+	UpdateAvailable bool `json:"updateAvailable"`
 }
 
 type statusResponse struct {
@@ -108,35 +162,101 @@ type scoreBreakdown struct {
 	LatencyScore      float64 `json:"latency"`
 	BenchmarksScore   float64 `json:"benchmarks"`
 	ContractsScore    float64 `json:"contracts"`
+	DurationScore     float64 `json:"duration"`
 	TotalScore        float64 `json:"total"`
 }
 
 type portalScan struct {
-	Timestamp time.Time     `json:"timestamp"`
-	Success   bool          `json:"success"`
-	Latency   time.Duration `json:"latency"`
-	Error     string        `json:"error"`
+	Timestamp  time.Time           `json:"timestamp"`
+	Success    bool                `json:"success"`
+	Latency    time.Duration       `json:"latency"`
+	Error      string              `json:"error"`
+	Failure    hostdb.FailureClass `json:"failure"`
+	ClockSkew  int64               `json:"clockSkew"`
+	ValidUntil time.Time           `json:"validUntil"`
+	InvalidSig bool                `json:"invalidSignature"`
+	IPv4       hostdb.AddressScan  `json:"ipv4"`
+	IPv6       hostdb.AddressScan  `json:"ipv6"`
+	Timings    hostdb.ScanTimings  `json:"timings"`
 }
 
 type scanHistory struct {
-	Timestamp time.Time       `json:"timestamp"`
-	Success   bool            `json:"success"`
-	Latency   time.Duration   `json:"latency"`
-	Error     string          `json:"error"`
-	PublicKey types.PublicKey `json:"publicKey"`
-	Network   string          `json:"network"`
-	Node      string          `json:"node"`
+	Timestamp  time.Time           `json:"timestamp"`
+	Success    bool                `json:"success"`
+	Latency    time.Duration       `json:"latency"`
+	Error      string              `json:"error"`
+	Failure    hostdb.FailureClass `json:"failure"`
+	ClockSkew  int64               `json:"clockSkew"`
+	ValidUntil time.Time           `json:"validUntil"`
+	InvalidSig bool                `json:"invalidSignature"`
+	IPv4       hostdb.AddressScan  `json:"ipv4"`
+	IPv6       hostdb.AddressScan  `json:"ipv6"`
+	Timings    hostdb.ScanTimings  `json:"timings"`
+	PublicKey  types.PublicKey     `json:"publicKey"`
+	Network    string              `json:"network"`
+	Node       string              `json:"node"`
+}
+
+// reachability shows how the host can be reached over each address
+// family.
+type reachability struct {
+	IPv4      hostdb.Reachability `json:"ipv4"`
+	IPv6      hostdb.Reachability `json:"ipv6"`
+	DualStack bool                `json:"dualStack"`
 }
 
 type nodeInteractions struct {
-	Uptime           time.Duration          `json:"uptime"`
-	Downtime         time.Duration          `json:"downtime"`
-	ScanHistory      []portalScan           `json:"scanHistory"`
-	BenchmarkHistory []hostdb.HostBenchmark `json:"benchmarkHistory"`
-	LastSeen         time.Time              `json:"lastSeen"`
-	ActiveHosts      int                    `json:"activeHosts"`
-	Score            scoreBreakdown         `json:"score"`
+	Uptime           time.Duration             `json:"uptime"`
+	Downtime         time.Duration             `json:"downtime"`
+	ScanHistory      []portalScan              `json:"scanHistory"`
+	BenchmarkHistory []hostdb.HostBenchmark    `json:"benchmarkHistory"`
+	LastSeen         time.Time                 `json:"lastSeen"`
+	ActiveHosts      int                       `json:"activeHosts"`
+	Score            scoreBreakdown            `json:"score"`
+	Standby          bool                      `json:"standby"`
+	Compliance       hostdb.ContractCompliance `json:"compliance"`
+	HighSkew         bool                      `json:"highSkew"`
+	InvalidSig       bool                      `json:"invalidSignature"`
+	Reachability     reachability              `json:"reachability"`
+	Traffic          benchmarkTraffic          `json:"traffic"`
 	hostdb.HostInteractions
+
+	// evicted is true if the histories have been dropped from memory to
+	// stay within the memory budget. Only the most recent scans are kept
+	// then, along with the averages of the full histories.
+	evicted bool
+	speeds  historySpeeds
+
+	// probes are the most recent latency probes, the oldest first. They
+	// are only kept in memory.
+	probes []hostdb.LatencyProbe
+}
+
+// nodeSpeeds contains the average speeds of the host measured by a node.
+type nodeSpeeds struct {
+	Latency       time.Duration `json:"latency"`
+	UploadSpeed   float64       `json:"uploadSpeed"`
+	DownloadSpeed float64       `json:"downloadSpeed"`
+	TTFB          time.Duration `json:"ttfb"`
+}
+
+// MarshalJSON adds the average speeds, so that the clients don't need
+// to calculate them from the histories.
+func (ni nodeInteractions) MarshalJSON() ([]byte, error) {
+	type interactions nodeInteractions
+	lat, ul, dl := getSpeeds(ni)
+	return json.Marshal(struct {
+		interactions
+		Speeds nodeSpeeds `json:"speeds"`
+	}{
+		interactions: interactions(ni),
+		Speeds: nodeSpeeds{
+			Latency:       lat,
+			UploadSpeed:   ul,
+			DownloadSpeed: dl,
+			TTFB:          getTTFB(ni),
+		},
+	})
 }
 
 type portalHost struct {
@@ -147,13 +267,39 @@ type portalHost struct {
 	KnownSince   uint64                      `json:"knownSince"`
 	NetAddress   string                      `json:"netaddress"`
 	Blocked      bool                        `json:"blocked"`
+	Quarantined  bool                        `json:"quarantined"`
 	Interactions map[string]nodeInteractions `json:"interactions"`
+	Federated    []federatedScore            `json:"federated,omitempty"`
+	Community    *communityScore             `json:"community,omitempty"`
 	IPNets       []string                    `json:"ipNets"`
 	LastIPChange time.Time                   `json:"lastIPChange"`
+	Reachability reachability                `json:"reachability"`
 	Score        scoreBreakdown              `json:"score"`
 	Settings     rhpv2.HostSettings          `json:"settings"`
 	PriceTable   rhpv3.HostPriceTable        `json:"priceTable"`
+	TimeZoneHint *timeZoneHint               `json:"timeZoneHint,omitempty"`
+	Traffic      benchmarkTraffic            `json:"traffic"`
+	OptOut       hostdb.OptOutLevel          `json:"optOut,omitempty"`
+	Tags         []string                    `json:"tags"`
+	SybilCluster int                         `json:"sybilCluster,omitempty"`
 	external.IPInfo
+
+	// scoreHash is the digest of the inputs of the last score calculation.
+	scoreHash types.Hash256
+
+	// priceSpikeAt is the time of the latest price increase by
+	// priceSpikeThreshold or more.
+	priceSpikeAt time.Time
+
+	// savedTags are the tags as last written to the database.
+	savedTags string
+
+	// sybilSubnets are the /24 and /64 subnets of the host.
+	sybilSubnets []string
+
+	// sybilPenalized is true if the score of the host is reduced by the
+	// sybil penalty when ranking.
+	sybilPenalized bool
 }
 
 type networkAverages struct {
@@ -176,52 +322,232 @@ const (
 	sortByStoragePrice
 	sortByUploadPrice
 	sortByDownloadPrice
+	sortByUptimeScore
+	sortByLatencyScore
+	sortByBenchmarksScore
+	sortByAgeScore
+	sortByCollateralScore
+	sortByFirstSeen
 )
 
 type portalAPI struct {
-	router   httprouter.Router
-	store    *jsonStore
-	db       *sql.DB
-	token    string
-	log      *zap.Logger
-	clients  map[string]*client.Client
-	mu       sync.RWMutex
-	cache    *responseCache
-	hosts    map[string]map[types.PublicKey]*portalHost
-	stopChan chan struct{}
-	averages map[string]map[string]networkAverages
-	nodes    map[string]nodeStatus
-	rl       *ratelimiter
-}
-
-func newAPI(s *jsonStore, db *sql.DB, token string, logger *zap.Logger, cache *responseCache) (*portalAPI, error) {
+	router     httprouter.Router
+	store      *jsonStore
+	db         *sqldb.DB
+	token      string
+	log        *zap.Logger
+	clients    map[string]*client.Client
+	mu         sync.RWMutex // guards all but the hosts
+	cache      *responseCache
+	blobs      *blobCache
+	histories  *historyCache
+	hosts      *hostStore
+	stopChan   chan struct{}
+	averages   map[string]map[string]networkAverages
+	nodes      map[string]nodeStatus
+	rl         *ratelimiter
+	failover   *failoverManager
+	shadow     *shadowState
+	schedule   *updatesScheduler
+	federation *federation
+	mirrorURL  string
+	sharedDB   bool // a mirror reading the database of the primary portal
+	events     *eventHub
+	alerts     *alertManager
+	jobs       *jobScheduler
+	embeds     *embedCache
+
+	// probesSince is the time of the latest latency probe received from
+	// each node.
+	probesSince map[string]time.Time
+
+	// tagRules are the rules the host tags are assigned by.
+	tagRules []tagRule
+
+	// sybil determines the sybil clusters.
+	sybil sybilConfig
+
+	// ranking is the outcome of the last ranking of the hosts. rankMu
+	// serializes the rankings.
+	ranking atomic.Pointer[hostRanking]
+	rankMu  sync.Mutex
+
+	// tests keeps track of the host tests requested by the operators.
+	tests *hostTests
+
+	// geoip is nil unless local GeoIP databases are configured.
+	geoip *geoIP
+
+	// diagnosticsSince is the time of the latest traceroute result
+	// received from each node.
+	diagnosticsSince map[string]time.Time
+
+	subscriptions *subscriptionManager
+	keys          *keyStore
+
+	// newHosts keeps the new hosts until they are posted to the
+	// webhooks.
+	newHosts *newHostTracker
+
+	// contributors maps the telemetry tokens to anonymous IDs.
+	contributors map[string]string
+
+	adminPassword string
+	adminRouter   *httprouter.Router
+
+	// legacyRoutes enables the deprecated routes without the version
+	// prefix.
+	legacyRoutes bool
+
+	// memoryBudget is the heap size above which the histories get
+	// evicted. Zero means no limit.
+	memoryBudget uint64
+
+	// rankPending is set when the scores have changed since the hosts
+	// were ranked the last time.
+	rankPending atomic.Bool
+
+	// updateMu serializes the update batches from the nodes.
+	updateMu sync.Mutex
+
+	// optOutMu serializes the opt-outs, so that a newer one can't be
+	// overwritten by an older one.
+	optOutMu sync.Mutex
+}
+
+func newAPI(s *jsonStore, db *sqldb.DB, token string, logger *zap.Logger, cache *responseCache, liveURL, shadowVersion, mirrorURL string, sharedDB bool) (*portalAPI, error) {
 	api := &portalAPI{
-		store:    s,
-		db:       db,
-		token:    token,
-		log:      logger,
-		clients:  make(map[string]*client.Client),
-		cache:    cache,
-		hosts:    make(map[string]map[types.PublicKey]*portalHost),
-		stopChan: make(chan struct{}),
-		averages: make(map[string]map[string]networkAverages),
-		nodes:    make(map[string]nodeStatus),
+		store:     s,
+		db:        db,
+		token:     token,
+		log:       logger,
+		clients:   make(map[string]*client.Client),
+		cache:     cache,
+		blobs:     newBlobCache(),
+		histories: newHistoryCache(defaultHistoryCacheSize),
+		hosts:     newHostStore(newHostSet()),
+		stopChan:  make(chan struct{}),
+		averages:  make(map[string]map[string]networkAverages),
+		nodes:     make(map[string]nodeStatus),
+		failover:  newFailoverManager(logger),
+		schedule:  newUpdatesScheduler(),
+		mirrorURL: strings.TrimSuffix(mirrorURL, "/"),
+		sharedDB:  sharedDB,
+		events:    newEventHub(),
+		alerts:    newAlertManager(newNotifiers(s.telegram)),
+		embeds:    newEmbedCache(),
+		tests:     newHostTests(),
+
+		probesSince:      make(map[string]time.Time),
+		diagnosticsSince: make(map[string]time.Time),
+
+		tagRules: s.tagRules,
+
+		sybil: s.sybil,
+
+		subscriptions: newSubscriptionManager(),
+		keys:          newKeyStore(s.tiers),
+		newHosts:      newNewHostTracker(s.newHosts),
 	}
 
-	api.hosts["mainnet"] = make(map[types.PublicKey]*portalHost)
-	api.hosts["zen"] = make(map[types.PublicKey]*portalHost)
+	if liveURL != "" {
+		api.shadow = newShadowState(liveURL, shadowVersion)
+	}
 
 	api.rl = newRatelimiter(api.stopChan)
+	api.jobs = newJobScheduler(logger, api.stopChan)
+
+	if s.geoip != nil {
+		g, err := newGeoIP(s.geoip, logger)
+		if err != nil {
+			return nil, err
+		}
+		api.geoip = g
+		api.jobs.add("geoip", geoIPRefreshInterval, every(geoIPRefreshInterval), g.refresh)
+	}
 
 	err := api.load()
 	if err != nil {
 		return nil, err
 	}
+	if err := api.loadAPIKeys(); err != nil {
+		return nil, err
+	}
+	if err := api.loadOptOuts(); err != nil {
+		return nil, err
+	}
+
+	// The uptime and the rollups are aggregated from the scans and the
+	// benchmarks, which a mirror copies from the primary portal too. A
+	// mirror sharing the database of the primary portal finds them
+	// aggregated already.
+	if !api.sharedDB {
+		api.jobs.add("uptime", 0, every(uptimeAggregateInterval), api.aggregateUptime)
+		api.jobs.add("rollups", 0, every(rollupInterval), api.rollUp)
+	}
+
+	// The hosts are ranked again after their scores have changed, which
+	// also happens on a mirror, e.g. when a host is quarantined.
+	api.jobs.add("ranking", rankInterval, every(rankInterval), func() error {
+		api.rankUpdatedHosts()
+		return nil
+	})
 
-	go api.doRequestStatus()
-	go api.requestUpdates()
-	go api.updateAverages()
-	go api.pruneOldScans()
+	// A mirror copies the data from the primary portal instead of
+	// contacting the nodes.
+	if api.mirrorURL != "" {
+		api.jobs.add("mirror", 0, every(mirrorSyncInterval), api.pullMirror)
+	} else {
+		go api.requestUpdates()
+		api.jobs.add("status", 0, every(statusInterval), func() error {
+			api.requestStatus()
+			return nil
+		})
+		api.jobs.add("community-scores", 0, every(communityInterval), api.calculateCommunityScores)
+		api.jobs.add("score-snapshot", 0, every(scoreSnapshotInterval), api.snapshotScores)
+		api.jobs.add("latency-probes", probesInterval, every(probesInterval), api.requestProbes)
+		api.jobs.add("diagnostics", diagnosticsInterval, every(diagnosticsInterval), api.runDiagnostics)
+		api.jobs.add("tags", tagsSaveInterval, every(tagsSaveInterval), api.saveTags)
+		api.jobs.add("network-snapshot", 0, aligned(time.Hour), api.snapshotNetworks)
+		if err := api.loadAlerts(); err != nil {
+			api.log.Error("couldn't load alerts", zap.Error(err))
+		}
+		api.jobs.add("alerts", alertCheckInterval, every(alertCheckInterval), func() error {
+			api.checkAlerts()
+			return nil
+		})
+		// A shadow instance must not announce the hosts twice.
+		if len(s.newHosts) > 0 && api.shadow == nil {
+			api.jobs.add("new-hosts", newHostCheckInterval, every(newHostCheckInterval), func() error {
+				api.announceNewHosts()
+				return nil
+			})
+		}
+		api.jobs.add("opt-outs", 0, every(optOutPushInterval), func() error {
+			api.pushOptOuts()
+			return nil
+		})
+		api.jobs.add("ranks", 0, every(rankPushInterval), func() error {
+			api.pushRanks()
+			return nil
+		})
+		if s.smtp != nil {
+			if err := api.loadSubscriptions(); err != nil {
+				api.log.Error("couldn't load subscriptions", zap.Error(err))
+			}
+			api.jobs.add("subscriptions", subscriptionCheckInterval, every(subscriptionCheckInterval), api.watchSubscriptions)
+		}
+	}
+	api.jobs.add("averages", 0, every(averagesInterval), func() error {
+		api.calculateAverages()
+		return nil
+	})
+	api.jobs.add("sybil", 0, every(sybilResolveInterval), api.resolveSybilSubnets)
+	if !api.sharedDB {
+		api.jobs.add("prune-scans", scanPruneInterval, every(scanPruneInterval), api.pruneOldScans)
+		api.jobs.add("prune-idempotency-keys", idempotencyPruneInterval, every(idempotencyPruneInterval), api.pruneIdempotencyKeys)
+	}
+	go api.refreshBlobs()
 
 	return api, nil
 }
@@ -230,29 +556,176 @@ func (api *portalAPI) close() {
 	close(api.stopChan)
 }
 
+const (
+	// apiPrefix is the path prefix of the current version of the API.
+	apiPrefix = "/v1"
+
+	// apiVersion is the current version of the API.
+	apiVersion = "1"
+
+	// apiVersionHeader carries the version of the API requested by the
+	// client and the version used in the response.
+	apiVersionHeader = "X-HostScore-API-Version"
+)
+
+// statusInterval determines how often the status of the nodes is
+// requested.
+const statusInterval = 5 * time.Minute
+
+// maxUpdatesBackoff is the maximum delay between the update requests to
+// a node that keeps failing.
+const maxUpdatesBackoff = 10 * time.Minute
+
+// streamChunkSize is the number of streamed rows that are ingested in
+// a single database transaction.
+const streamChunkSize = 500
+
 func (api *portalAPI) requestUpdates() {
-	timeout := time.Minute
+	select {
+	case <-api.stopChan:
+		return
+	case <-time.After(time.Minute):
+	}
+
+	for node, c := range api.clients {
+		go api.requestNodeUpdates(node, c)
+	}
+}
+
+// requestNodeUpdates pulls the updates from a single node, so that a slow
+// or unreachable node doesn't delay the others.
+func (api *portalAPI) requestNodeUpdates(node string, c *client.Client) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-api.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// In push mode, the node pushes new updates over a persistent
+	// connection, and they are ingested right away.
+	pushed := make(chan pushedUpdates)
+	if api.store.nodes[node].Push {
+		go api.watchNodeUpdates(ctx, node, c, pushed)
+	}
+
+	var timeout time.Duration
+	backoff := time.Minute
 	for {
+		var batch *pushedUpdates
 		select {
 		case <-api.stopChan:
 			return
 		case <-time.After(timeout):
+		case pu := <-pushed:
+			batch = &pu
 		}
 
-		timeout = time.Minute
-		for node, c := range api.clients {
-			updates, err := c.Updates()
-			if err != nil {
-				api.log.Error("failed to request updates", zap.String("node", node), zap.Error(err))
-			}
-			if err := api.insertUpdates(node, updates); err != nil {
-				api.log.Error("failed to insert updates", zap.String("node", node), zap.Error(err))
-			}
-			if len(updates.Hosts)+len(updates.Scans)+len(updates.Benchmarks) > 500 {
-				timeout = 5 * time.Second
-			}
+		var received, backlog int
+		var ok bool
+		if batch != nil {
+			received, backlog, ok = api.insertPushedUpdates(node, c, *batch)
+		} else if stream, limit := api.schedule.stream(node); stream {
+			received, backlog, ok = api.streamNodeUpdates(ctx, node, c, limit)
+		} else {
+			received, backlog, ok = api.fetchNodeUpdates(ctx, node, c)
+		}
+		if !ok {
+			timeout = backoff
+			backoff = min(2*backoff, maxUpdatesBackoff)
+			continue
+		}
+		backoff = time.Minute
+		timeout = api.schedule.negotiate(node, received, backlog)
+	}
+}
+
+// fetchNodeUpdates pulls a batch of updates from the node and ingests it.
+// It returns false if the node couldn't deliver the updates.
+func (api *portalAPI) fetchNodeUpdates(ctx context.Context, node string, c *client.Client) (received, backlog int, ok bool) {
+	batchSize := api.schedule.get(node).BatchSize
+	src, standby := api.failover.source(node, c)
+	updates, err := src.WithContext(ctx).Updates(batchSize)
+	received = countUpdates(updates)
+	api.failover.update(node, c, updates.ID, received, err)
+	if err != nil {
+		api.log.Error("failed to request updates", zap.String("node", node), zap.Error(err))
+		return 0, 0, false
+	}
+
+	if err := api.insertUpdates(node, src, standby, updates); err != nil {
+		api.log.Error("failed to insert updates", zap.String("node", node), zap.Error(err))
+	}
+
+	return received, updates.Backlog, true
+}
+
+// streamNodeUpdates pulls a large backlog from the node as a stream and
+// ingests it in chunks as the rows arrive, skipping the rows already
+// ingested if the batch is sent again. The receipt is confirmed once the
+// whole stream has been ingested. It returns false if the stream couldn't
+// be completed.
+func (api *portalAPI) streamNodeUpdates(ctx context.Context, node string, c *client.Client, limit int) (received, backlog int, ok bool) {
+	var chunk hostdb.HostUpdates
+	var offset updateOffset
+	var skip int
+	var ingestErr error
+	ingest := func() error {
+		err := api.ingestUpdates(node, chunk, offset)
+		chunk = hostdb.HostUpdates{}
+		return err
+	}
+
+	src, standby := api.failover.source(node, c)
+	id, backlog, err := src.WithContext(ctx).StreamUpdates(limit, func(item hostdb.UpdateItem) error {
+		if item.Seq != 0 {
+			offset, ingestErr = api.resumeUpdates(node, standby, item.Seq)
+			skip = offset.applied
+			return ingestErr
+		}
+		received++
+		if received <= skip {
+			return nil
+		}
+		switch {
+		case item.Host != nil:
+			chunk.Hosts = append(chunk.Hosts, *item.Host)
+		case item.Scan != nil:
+			chunk.Scans = append(chunk.Scans, *item.Scan)
+		case item.Benchmark != nil:
+			chunk.Benchmarks = append(chunk.Benchmarks, *item.Benchmark)
 		}
+		if received%streamChunkSize == 0 {
+			offset.applied = received
+			ingestErr = ingest()
+		}
+		return ingestErr
+	})
+	if ingestErr != nil {
+		api.log.Error("failed to insert updates", zap.String("node", node), zap.Error(ingestErr))
+		return 0, 0, false
+	}
+	api.failover.update(node, c, id, received, err)
+	if err != nil {
+		api.log.Error("failed to stream updates", zap.String("node", node), zap.Error(err))
+		return 0, 0, false
 	}
+
+	if received > offset.applied {
+		offset.applied = received
+		if err := ingest(); err != nil {
+			api.log.Error("failed to insert updates", zap.String("node", node), zap.Error(err))
+			return 0, 0, false
+		}
+	}
+	if err := api.finalizeUpdates(src, id); err != nil {
+		api.log.Error("failed to finalize updates", zap.String("node", node), zap.Error(err))
+	}
+
+	return received, backlog, true
 }
 
 func (api *portalAPI) requestStatus() {
@@ -280,19 +753,31 @@ func (api *portalAPI) requestStatus() {
 			} else {
 				mu.Lock()
 				nodes[n] = nodeStatus{
-					Online:   true,
-					Version:  status.Version,
-					Networks: make(map[string]networkStatus),
+					Online:      true,
+					Standby:     api.failover.onStandby(n),
+					Version:     status.Version,
+					GitRevision: status.GitRevision,
+					StartedAt:   status.StartedAt,
+					Update:      status.Update,
+					Networks:    make(map[string]networkStatus),
 				}
 				nodes[n].Networks["mainnet"] = networkStatus{
-					Height:  status.Height,
-					Balance: balanceStatus(status.Balance.Siacoins),
+					Height:   status.Height,
+					Balance:  balanceStatus(status.Balance.Siacoins),
+					LowFunds: status.Balance.LowFunds,
 				}
 				nodes[n].Networks["zen"] = networkStatus{
-					Height:  status.HeightZen,
-					Balance: balanceStatus(status.BalanceZen.Siacoins),
+					Height:   status.HeightZen,
+					Balance:  balanceStatus(status.BalanceZen.Siacoins),
+					LowFunds: status.BalanceZen.LowFunds,
 				}
 				mu.Unlock()
+				if status.Balance.LowFunds || status.BalanceZen.LowFunds {
+					api.log.Warn("node is low on funds, benchmarks are suspended", zap.String("node", n), zap.Bool("mainnet", status.Balance.LowFunds), zap.Bool("zen", status.BalanceZen.LowFunds))
+				}
+				if status.Update != nil && status.Update.Available {
+					api.log.Warn("node is outdated", zap.String("node", n), zap.String("version", status.Version), zap.String("latest", status.Update.LatestVersion))
+				}
 			}
 		case <-ctx.Done():
 			api.log.Error("NodeStatus call timed out", zap.String("node", n))
@@ -301,19 +786,14 @@ func (api *portalAPI) requestStatus() {
 			mu.Unlock()
 		}
 	}
-	api.nodes = nodes
-}
-
-func (api *portalAPI) doRequestStatus() {
-	api.requestStatus()
-	for {
-		select {
-		case <-api.stopChan:
-			return
-		case <-time.After(5 * time.Minute):
-		}
-		api.requestStatus()
+	for n, status := range nodes {
+		status.Updates = api.schedule.get(n)
+		status.Location = api.store.location(n)
+		nodes[n] = status
 	}
+	api.mu.Lock()
+	api.nodes = nodes
+	api.mu.Unlock()
 }
 
 func (api *portalAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -327,6 +807,89 @@ func (api *portalAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}*/
 
+	// The readiness probe is outside of the versioned API, so that the
+	// container health checks don't depend on the API settings.
+	if r.URL.Path == "/readyz" {
+		api.readyzHandler(w, r)
+		return
+	}
+
+	// The clients may ask for a specific version of the API. Requests
+	// without the version prefix are served by the current version, but
+	// only as long as the legacy routes are enabled.
+	w.Header().Set(apiVersionHeader, apiVersion)
+	if v := r.Header.Get(apiVersionHeader); v != "" && v != apiVersion {
+		writeError(w, "unsupported API version", http.StatusNotAcceptable)
+		return
+	}
+	if p, ok := strings.CutPrefix(r.URL.Path, apiPrefix+"/"); ok {
+		r.URL.Path = "/" + p
+		r.URL.RawPath = ""
+	} else if !api.legacyRoutes {
+		writeError(w, "not found", http.StatusNotFound)
+		return
+	} else {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiPrefix+r.URL.Path+">; rel=\"successor-version\"")
+	}
+	if !api.checkAPIKey(w, r) {
+		return
+	}
+
+	// The responses are compressed if the client accepts it. The
+	// WebSocket connections are hijacked, so they are left alone.
+	if r.URL.Path != "/ws" && acceptsGzip(r) {
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		w = gw
+	}
+
+	// The retries of a mutating request with the same idempotency key
+	// are answered with the stored response.
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" && isMutating(r) {
+		api.serveIdempotent(w, r, key, api.route)
+		return
+	}
+
+	api.route(w, r)
+}
+
+// route passes the request to the handler.
+func (api *portalAPI) route(w http.ResponseWriter, r *http.Request) {
+	// The WebSocket connections are long-lived, so they must not hold
+	// the lock.
+	if r.URL.Path == "/ws" {
+		api.eventsHandler(w, r)
+		return
+	}
+
+	// A host test can take long, so it only holds the lock while
+	// choosing the node.
+	if r.URL.Path == "/hosts/test" && r.Method == http.MethodPost {
+		api.hostsTestHandler(w, r, nil)
+		return
+	}
+
+	// An opt-out waits for the other writers of the hosts, so it doesn't
+	// hold the lock in the meantime.
+	if r.URL.Path == "/optouts" && r.Method == http.MethodPost {
+		api.optOutsCreateHandler(w, r, nil)
+		return
+	}
+
+	// The admin handlers modify the hosts, so they don't hold the lock
+	// while waiting for the other writers.
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		api.mu.RLock()
+		admin := api.adminRouter
+		api.mu.RUnlock()
+		admin.ServeHTTP(w, r)
+		return
+	}
+
+	// The hosts are read without the lock, so the handlers don't wait for
+	// the update batches. The lock only guards the rest of the state,
+	// which is replaced quickly.
 	api.mu.RLock()
 	api.router.ServeHTTP(w, r)
 	api.mu.RUnlock()
@@ -338,9 +901,18 @@ func (api *portalAPI) buildHTTPRoutes() {
 	router.GET("/hosts", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.hostsHandler(w, req, ps)
 	})
+	router.GET("/hosts/export", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsExportHandler(w, req, ps)
+	})
 	router.GET("/hosts/keys", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.hostsKeysHandler(w, req, ps)
 	})
+	router.GET("/hosts/renterd", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsRenterdHandler(w, req, ps)
+	})
+	router.GET("/hosts/new", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsNewHandler(w, req, ps)
+	})
 	router.GET("/hosts/host", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.hostsHostHandler(w, req, ps)
 	})
@@ -353,6 +925,34 @@ func (api *portalAPI) buildHTTPRoutes() {
 	router.GET("/hosts/changes", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.hostsChangesHandler(w, req, ps)
 	})
+	router.GET("/hosts/settings/history", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsSettingsHistoryHandler(w, req, ps)
+	})
+	router.GET("/hosts/score", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsScoreHandler(w, req, ps)
+	})
+	router.GET("/hosts/scores", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsScoresHandler(w, req, ps)
+	})
+	router.GET("/hosts/uptime", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsUptimeHandler(w, req, ps)
+	})
+	router.GET("/hosts/latency", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsLatencyHandler(w, req, ps)
+	})
+	router.POST("/hosts/prune", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsPruneHandler(w, req, ps)
+	})
+	router.GET("/hosts/diagnostics", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsDiagnosticsHandler(w, req, ps)
+	})
+	router.POST("/hosts/simulate", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsSimulateHandler(w, req, ps)
+	})
+
+	router.GET("/embed/host", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.embedHostHandler(w, req, ps)
+	})
 
 	router.GET("/network/hosts", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.networkHostsHandler(w, req, ps)
@@ -360,6 +960,21 @@ func (api *portalAPI) buildHTTPRoutes() {
 	router.GET("/network/averages", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.networkAveragesHandler(w, req, ps)
 	})
+	router.GET("/network/storage", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.networkStorageHandler(w, req, ps)
+	})
+	router.GET("/network/history", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.networkHistoryHandler(w, req, ps)
+	})
+	router.GET("/network/asns", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.networkASNsHandler(w, req, ps)
+	})
+	router.GET("/network/sybil", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.networkSybilHandler(w, req, ps)
+	})
+	router.GET("/network/distribution", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.networkDistributionHandler(w, req, ps)
+	})
 	router.GET("/network/countries", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.networkCountriesHandler(w, req, ps)
 	})
@@ -367,14 +982,103 @@ func (api *portalAPI) buildHTTPRoutes() {
 	router.GET("/service/status", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		api.serviceStatusHandler(w, req, ps)
 	})
+	router.GET("/service/nodes", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.serviceNodesHandler(w, req, ps)
+	})
+	router.GET("/service/compare", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.serviceCompareHandler(w, req, ps)
+	})
+
+	router.GET("/export/:table", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.exportHandler(w, req, ps)
+	})
+
+	router.POST("/hosts/report", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.hostsReportHandler(w, req, ps)
+	})
+
+	router.POST("/alerts", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.alertsCreateHandler(w, req, ps)
+	})
+	router.GET("/alerts/:id", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.alertsGetHandler(w, req, ps)
+	})
+	router.DELETE("/alerts/:id", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.alertsDeleteHandler(w, req, ps)
+	})
+
+	router.GET("/watchlist", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.watchlistHandler(w, req, ps)
+	})
+	router.POST("/watchlist", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.watchlistAddHandler(w, req, ps)
+	})
+	router.DELETE("/watchlist", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.watchlistRemoveHandler(w, req, ps)
+	})
+
+	router.GET("/optouts", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.optOutsHandler(w, req, ps)
+	})
+
+	router.POST("/subscriptions", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.subscriptionsCreateHandler(w, req, ps)
+	})
+	router.POST("/subscriptions/confirm", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.subscriptionsConfirmHandler(w, req, ps)
+	})
+	router.GET("/subscriptions", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.subscriptionsGetHandler(w, req, ps)
+	})
+	router.DELETE("/subscriptions", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.subscriptionsDeleteHandler(w, req, ps)
+	})
+
+	router.POST("/telemetry", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.telemetryHandler(w, req, ps)
+	})
+
+	router.GET("/federation", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.federationInfoHandler(w, req, ps)
+	})
+	router.GET("/federation/summary", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.federationSummaryHandler(w, req, ps)
+	})
+
+	admin := httprouter.New()
+	admin.GET("/admin/reports", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminReportsHandler(w, req, ps)
+	})
+	admin.POST("/admin/reports/:id", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminReviewHandler(w, req, ps)
+	})
+	admin.POST("/admin/quarantine", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminQuarantineHandler(w, req, ps)
+	})
+	admin.GET("/admin/keys", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminKeysHandler(w, req, ps)
+	})
+	admin.POST("/admin/keys", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminKeysCreateHandler(w, req, ps)
+	})
+	admin.DELETE("/admin/keys/:id", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminKeysRevokeHandler(w, req, ps)
+	})
+	admin.GET("/admin/jobs", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminJobsHandler(w, req, ps)
+	})
+	admin.POST("/admin/jobs/:name/run", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		api.adminJobsRunHandler(w, req, ps)
+	})
 
 	api.mu.Lock()
+	api.adminRouter = admin
 	api.router = *router
 	api.mu.Unlock()
 }
 
 func (api *portalAPI) hostsHostHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -410,11 +1114,11 @@ func (api *portalAPI) hostsHostHandler(w http.ResponseWriter, req *http.Request,
 			return
 		}
 	}
-	writeJSON(w, hostResponse{Host: host})
+	writeTaggedJSON(w, req, hostResponse{Host: host})
 }
 
 func (api *portalAPI) hostsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -426,27 +1130,80 @@ func (api *portalAPI) hostsHandler(w http.ResponseWriter, req *http.Request, _ h
 		writeError(w, "wrong network", http.StatusBadRequest)
 		return
 	}
-	query := strings.ToLower(req.FormValue("query"))
-	country := strings.ToUpper(req.FormValue("country"))
+	filter := hostFilter{
+		query:   strings.ToLower(req.FormValue("query")),
+		country: strings.ToUpper(req.FormValue("country")),
+		isp:     strings.ToLower(req.FormValue("isp")),
+		version: strings.ToLower(req.FormValue("version")),
+		tag:     strings.ToLower(req.FormValue("tag")),
+	}
+	if a := req.FormValue("asn"); a != "" {
+		asn, ok := normalizeASN(a)
+		if !ok {
+			writeError(w, "invalid ASN", http.StatusBadRequest)
+			return
+		}
+		filter.asn = asn
+	}
+	if ms := req.FormValue("minScore"); ms != "" {
+		minScore, err := strconv.ParseFloat(ms, 64)
+		if err != nil || math.IsNaN(minScore) || minScore < 0 {
+			writeError(w, "invalid minimum score", http.StatusBadRequest)
+			return
+		}
+		filter.minScore = minScore
+	}
+	switch strings.ToLower(req.FormValue("acceptingContracts")) {
+	case "", "false":
+	case "true":
+		filter.acceptingContracts = true
+	default:
+		writeError(w, "invalid acceptingContracts", http.StatusBadRequest)
+		return
+	}
+	switch strings.ToLower(req.FormValue("online")) {
+	case "":
+	case "true":
+		filter.online = onlineOnly
+	case "false":
+		filter.online = offlineOnly
+	default:
+		writeError(w, "invalid online status", http.StatusBadRequest)
+		return
+	}
 	allHosts := strings.ToLower(req.FormValue("all"))
 	var all bool
 	if allHosts == "true" {
 		all = true
 	}
-	offset, limit := int64(0), int64(-1)
+	offset, after, limit := int64(0), int64(0), int64(-1)
 	var err error
 	off := req.FormValue("offset")
-	if off == "" {
+	aft := req.FormValue("after")
+	if off == "" && aft == "" {
 		writeError(w, "offset not provided", http.StatusBadRequest)
 		return
 	}
-	offset, err = strconv.ParseInt(off, 10, 64)
-	if err != nil {
-		writeError(w, "invalid offset", http.StatusBadRequest)
+	if off != "" && aft != "" {
+		writeError(w, "offset and after cannot be combined", http.StatusBadRequest)
 		return
 	}
+	if off != "" {
+		offset, err = strconv.ParseInt(off, 10, 64)
+		if err != nil {
+			writeError(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if aft != "" {
+		after, err = strconv.ParseInt(aft, 10, 64)
+		if err != nil || after <= 0 {
+			writeError(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
 	lim := req.FormValue("limit")
-	if off == "" {
+	if lim == "" {
 		writeError(w, "limit not provided", http.StatusBadRequest)
 		return
 	}
@@ -475,6 +1232,18 @@ func (api *portalAPI) hostsHandler(w http.ResponseWriter, req *http.Request, _ h
 		sortBy = sortByUploadPrice
 	case "download":
 		sortBy = sortByDownloadPrice
+	case "uptime":
+		sortBy = sortByUptimeScore
+	case "latency":
+		sortBy = sortByLatencyScore
+	case "benchmarks":
+		sortBy = sortByBenchmarksScore
+	case "age":
+		sortBy = sortByAgeScore
+	case "collateral":
+		sortBy = sortByCollateralScore
+	case "firstseen":
+		sortBy = sortByFirstSeen
 	default:
 		writeError(w, "invalid sorting type", http.StatusBadRequest)
 		return
@@ -491,48 +1260,102 @@ func (api *portalAPI) hostsHandler(w http.ResponseWriter, req *http.Request, _ h
 	if order == "desc" {
 		asc = false
 	}
+	view := strings.ToLower(req.FormValue("view"))
+	if view != "" && view != "full" && view != "summary" {
+		writeError(w, "invalid view", http.StatusBadRequest)
+		return
+	}
+	summary := view == "summary"
+
+	// The cursors point at a rank or an ID, so that the pages stay
+	// consistent. The pages are cheap to get, so they are not cached.
+	if after > 0 {
+		if sortBy != sortByRank && sortBy != sortByID {
+			writeError(w, "cursor requires sorting by rank or id", http.StatusBadRequest)
+			return
+		}
+		hosts, more, total, err := api.getHosts(network, all, 0, int(after), int(limit), filter, sortBy, asc)
+		if err != nil {
+			api.log.Error("couldn't get hosts", zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeHostsPage(w, req, summary, hosts, more, total, sortBy)
+		return
+	}
+
+	// The first pages are served from the pre-serialized blobs.
+	if !summary && api.blobs.cacheable(int(offset), int(limit), filter) {
+		key := hostsBlobKey{
+			network: network,
+			all:     all,
+			limit:   int(limit),
+			sortBy:  sortBy,
+			asc:     asc,
+		}
+		blob, ok := api.blobs.getHosts(key)
+		if !ok {
+			gen := api.blobs.current()
+			blob, err = api.hostsBlob(key)
+			if err != nil {
+				api.log.Error("couldn't get hosts", zap.Error(err))
+				writeError(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			api.blobs.putHosts(gen, key, blob)
+		}
+		writeTagged(w, req, blob)
+		return
+	}
 
-	hosts, more, total, ok := api.cache.getHosts(network, all, int(offset), int(limit), query, country, sortBy, asc)
+	hosts, more, total, ok := api.cache.getHosts(network, all, int(offset), int(limit), filter, sortBy, asc)
 	if !ok {
-		hosts, more, total, err = api.getHosts(network, all, int(offset), int(limit), query, country, sortBy, asc)
+		gen := api.cache.current()
+		hosts, more, total, err = api.getHosts(network, all, int(offset), 0, int(limit), filter, sortBy, asc)
 		if err != nil {
 			api.log.Error("couldn't get hosts", zap.Error(err))
 			writeError(w, "internal error", http.StatusInternalServerError)
 			return
 		}
-		api.cache.putHosts(network, all, int(offset), int(limit), query, country, sortBy, asc, hosts, more, total)
+		api.cache.putHosts(gen, network, all, int(offset), int(limit), filter, sortBy, asc, hosts, more, total)
 	}
 
 	// Prefetch the next bunch of hosts.
 	if more {
 		go func() {
-			_, _, _, ok := api.cache.getHosts(network, all, int(offset+limit), int(limit), query, country, sortBy, asc)
+			_, _, _, ok := api.cache.getHosts(network, all, int(offset+limit), int(limit), filter, sortBy, asc)
 			if !ok {
-				h, m, t, err := api.getHosts(network, all, int(offset+limit), int(limit), query, country, sortBy, asc)
+				gen := api.cache.current()
+				h, m, t, err := api.getHosts(network, all, int(offset+limit), 0, int(limit), filter, sortBy, asc)
 				if err != nil {
 					return
 				}
-				api.cache.putHosts(network, all, int(offset+limit), int(limit), query, country, sortBy, asc, h, m, t)
+				api.cache.putHosts(gen, network, all, int(offset+limit), int(limit), filter, sortBy, asc, h, m, t)
 			}
 		}()
 	}
 
-	writeJSON(w, hostsResponse{
-		Hosts: hosts,
-		More:  more,
-		Total: total,
-	})
+	writeHostsPage(w, req, summary, hosts, more, total, sortBy)
 }
 
 func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
+	if _, keys, ok := api.selectHostKeys(w, req); ok {
+		writeJSON(w, keysResponse{Keys: keys})
+	}
+}
+
+// selectHostKeys returns the network and the keys of the hosts meeting
+// the criteria of the request. If the request is invalid or the keys
+// can't be retrieved, it writes the error and returns false.
+func (api *portalAPI) selectHostKeys(w http.ResponseWriter, req *http.Request) (string, []types.PublicKey, bool) {
 	err := req.ParseForm()
 	if err != nil {
 		writeError(w, "unable to parse request", http.StatusBadRequest)
-		return
+		return "", nil, false
 	}
 	network := strings.ToLower(req.FormValue("network"))
 	if network == "" {
@@ -540,7 +1363,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 	}
 	if network != "mainnet" && network != "zen" {
 		writeError(w, "wrong network", http.StatusBadRequest)
-		return
+		return "", nil, false
 	}
 	node := strings.ToLower(req.FormValue("node"))
 	if node == "" {
@@ -549,7 +1372,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 	_, ok := api.clients[node]
 	if node != "global" && !ok {
 		writeError(w, "wrong node", http.StatusBadRequest)
-		return
+		return "", nil, false
 	}
 	msp := req.FormValue("maxStoragePrice")
 	mup := req.FormValue("maxUploadPrice")
@@ -565,7 +1388,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		maxStoragePrice, err = types.ParseCurrency(msp)
 		if err != nil {
 			writeError(w, "invalid max storage price", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	if mup == "" {
@@ -574,7 +1397,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		maxUploadPrice, err = types.ParseCurrency(mup)
 		if err != nil {
 			writeError(w, "invalid max upload price", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	if mdp == "" {
@@ -583,7 +1406,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		maxDownloadPrice, err = types.ParseCurrency(mdp)
 		if err != nil {
 			writeError(w, "invalid max download price", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	if mcp == "" {
@@ -592,7 +1415,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		maxContractPrice, err = types.ParseCurrency(mcp)
 		if err != nil {
 			writeError(w, "invalid max contract price", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	if mbrp == "" {
@@ -601,7 +1424,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		maxBasePrice, err = types.ParseCurrency(mbrp)
 		if err != nil {
 			writeError(w, "invalid max base RPC price", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	if msap == "" {
@@ -610,7 +1433,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		maxSectorPrice, err = types.ParseCurrency(msap)
 		if err != nil {
 			writeError(w, "invalid max sector access price", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	md := req.FormValue("minContractDuration")
@@ -619,7 +1442,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		minDuration, err = strconv.ParseInt(md, 10, 64)
 		if err != nil {
 			writeError(w, "invalid min contract duration", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	ms := req.FormValue("minAvailableStorage")
@@ -628,7 +1451,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		minStorage, err = strconv.ParseInt(ms, 10, 64)
 		if err != nil {
 			writeError(w, "invalid min available storage", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	minVersion := req.FormValue("minVersion")
@@ -640,21 +1463,21 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		maxLatency, err = strconv.ParseInt(ml, 10, 64)
 		if err != nil {
 			writeError(w, "invalid max latency", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	if mus != "" {
 		minUploadSpeed, err = strconv.ParseInt(mus, 10, 64)
 		if err != nil {
 			writeError(w, "invalid min upload speed", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	if mds != "" {
 		minDownloadSpeed, err = strconv.ParseInt(mds, 10, 64)
 		if err != nil {
 			writeError(w, "invalid min download speed", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	countries := req.Form["country"]
@@ -664,7 +1487,7 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 		limit, err = strconv.ParseInt(lim, 10, 64)
 		if err != nil {
 			writeError(w, "invalid limit", http.StatusBadRequest)
-			return
+			return "", nil, false
 		}
 	}
 	keys, err := api.getHostKeys(
@@ -688,13 +1511,13 @@ func (api *portalAPI) hostsKeysHandler(w http.ResponseWriter, req *http.Request,
 	if err != nil {
 		api.log.Error("couldn't get host keys", zap.Error(err))
 		writeError(w, "internal error", http.StatusInternalServerError)
-		return
+		return "", nil, false
 	}
-	writeJSON(w, keysResponse{Keys: keys})
+	return network, keys, true
 }
 
 func (api *portalAPI) hostsScansHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -758,6 +1581,26 @@ func (api *portalAPI) hostsScansHandler(w http.ResponseWriter, req *http.Request
 			return
 		}
 	}
+	granularity := strings.ToLower(req.FormValue("granularity"))
+	span, ok := parseGranularity(granularity)
+	if !ok {
+		writeError(w, "invalid granularity", http.StatusBadRequest)
+		return
+	}
+	if span > 0 {
+		rollups, err := api.getScanRollups(network, node, pk, span, from, to, limit)
+		if err != nil && errors.Is(err, errHostNotFound) {
+			writeError(w, "host not found", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			api.log.Error("couldn't get scan rollups", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, scanRollupsResponse{Granularity: granularity, Scans: rollups})
+		return
+	}
 	scans, err := api.getScans(network, node, pk, all, from, to, limit)
 	if err != nil && errors.Is(err, errHostNotFound) {
 		writeError(w, "host not found", http.StatusBadRequest)
@@ -772,7 +1615,7 @@ func (api *portalAPI) hostsScansHandler(w http.ResponseWriter, req *http.Request
 }
 
 func (api *portalAPI) hostsBenchmarksHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -793,6 +1636,22 @@ func (api *portalAPI) hostsBenchmarksHandler(w http.ResponseWriter, req *http.Re
 		writeError(w, "wrong node", http.StatusBadRequest)
 		return
 	}
+	var nodes []string
+	if node != "global" {
+		nodes = []string{node}
+	}
+	region := strings.ToLower(req.FormValue("region"))
+	if region != "" {
+		if node != "global" {
+			writeError(w, "node and region cannot be combined", http.StatusBadRequest)
+			return
+		}
+		nodes = api.regionNodes(region)
+		if len(nodes) == 0 {
+			writeError(w, "wrong region", http.StatusBadRequest)
+			return
+		}
+	}
 	host := req.FormValue("host")
 	if host == "" {
 		writeError(w, "host not provided", http.StatusBadRequest)
@@ -836,7 +1695,27 @@ func (api *portalAPI) hostsBenchmarksHandler(w http.ResponseWriter, req *http.Re
 			return
 		}
 	}
-	benchmarks, err := api.getBenchmarks(network, node, pk, all, from, to, limit)
+	granularity := strings.ToLower(req.FormValue("granularity"))
+	span, ok := parseGranularity(granularity)
+	if !ok {
+		writeError(w, "invalid granularity", http.StatusBadRequest)
+		return
+	}
+	if span > 0 {
+		rollups, err := api.getBenchmarkRollups(network, nodes, pk, span, from, to, limit)
+		if err != nil && errors.Is(err, errHostNotFound) {
+			writeError(w, "host not found", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			api.log.Error("couldn't get benchmark rollups", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, benchmarkRollupsResponse{Granularity: granularity, Benchmarks: rollups})
+		return
+	}
+	benchmarks, err := api.getBenchmarks(network, nodes, pk, all, from, to, limit)
 	if err != nil && errors.Is(err, errHostNotFound) {
 		writeError(w, "host not found", http.StatusBadRequest)
 		return
@@ -846,7 +1725,10 @@ func (api *portalAPI) hostsBenchmarksHandler(w http.ResponseWriter, req *http.Re
 		writeError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, benchmarksResponse{Benchmarks: benchmarks})
+	writeJSON(w, benchmarksResponse{
+		Benchmarks: benchmarks,
+		Regions:    api.summarizeRegions(benchmarks),
+	})
 }
 
 func balanceStatus(balance types.Currency) string {
@@ -860,7 +1742,7 @@ func balanceStatus(balance types.Currency) string {
 }
 
 func (api *portalAPI) serviceStatusHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -870,8 +1752,50 @@ func (api *portalAPI) serviceStatusHandler(w http.ResponseWriter, req *http.Requ
 	})
 }
 
+// readyzHandler reports whether the portal can serve requests.
+func (api *portalAPI) readyzHandler(w http.ResponseWriter, _ *http.Request) {
+	if err := api.db.Ping(); err != nil {
+		writeError(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func (api *portalAPI) serviceNodesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	nodes := []nodeInfo{}
+	for name, status := range api.nodes {
+		info := nodeInfo{
+			Name:        name,
+			Online:      status.Online,
+			Version:     status.Version,
+			GitRevision: status.GitRevision,
+			Networks:    []string{},
+			Region:      status.Location.Region,
+
+			UpdateAvailable: status.Update != nil && status.Update.Available,
+		}
+		for network := range status.Networks {
+			info.Networks = append(info.Networks, network)
+		}
+		slices.Sort(info.Networks)
+		if status.Online && !status.StartedAt.IsZero() {
+			info.Uptime = time.Since(status.StartedAt)
+			info.LastRestart = status.StartedAt
+		}
+		nodes = append(nodes, info)
+	}
+	slices.SortFunc(nodes, func(a, b nodeInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	writeJSON(w, nodes)
+}
+
 func (api *portalAPI) networkHostsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -884,19 +1808,68 @@ func (api *portalAPI) networkHostsHandler(w http.ResponseWriter, req *http.Reque
 		return
 	}
 	var hosts hostCount
-	api.mu.RLock()
-	hosts.Total = len(api.hosts[network])
-	for _, host := range api.hosts[network] {
+	live := api.hosts.load()[network]
+	hosts.Total = len(live)
+	for _, host := range live {
 		if isOnline(*host) {
 			hosts.Online++
 		}
 	}
-	api.mu.RUnlock()
 	writeJSON(w, networkHostsResponse{Hosts: hosts})
 }
 
+func (api *portalAPI) networkStorageHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	var hosts []portalHost
+	for _, host := range api.hosts.load()[network] {
+		if host.OptOut != hostdb.OptOutDelist && isOnline(*host) {
+			hosts = append(hosts, *host)
+		}
+	}
+
+	// The tiers are the same as in the network averages.
+	slices.SortStableFunc(hosts, func(a, b portalHost) int {
+		return a.Rank - b.Rank
+	})
+	storage := networkStorage{
+		Countries: make(map[string]storageStats),
+		Tiers:     make(map[string]storageStats),
+	}
+	for i, host := range hosts {
+		storage.add(host)
+		country := host.Country
+		if country == "" {
+			country = "unknown"
+		}
+		cs := storage.Countries[country]
+		cs.add(host)
+		storage.Countries[country] = cs
+		tier := "tier3"
+		if i < 10 {
+			tier = "tier1"
+		} else if i < 100 {
+			tier = "tier2"
+		}
+		ts := storage.Tiers[tier]
+		ts.add(host)
+		storage.Tiers[tier] = ts
+	}
+	writeJSON(w, networkStorageResponse{Storage: storage})
+}
+
 func (api *portalAPI) hostsChangesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -959,7 +1932,7 @@ func (api *portalAPI) hostsChangesHandler(w http.ResponseWriter, req *http.Reque
 }
 
 func (api *portalAPI) networkAveragesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -971,11 +1944,23 @@ func (api *portalAPI) networkAveragesHandler(w http.ResponseWriter, req *http.Re
 		writeError(w, "wrong network", http.StatusBadRequest)
 		return
 	}
-	writeJSON(w, averagesResponse{Averages: api.averages[network]})
+	blob, ok := api.blobs.getAverages(network)
+	if !ok {
+		gen := api.blobs.current()
+		var err error
+		blob, err = marshalBlob(averagesResponse{Averages: api.averages[network]})
+		if err != nil {
+			api.log.Error("couldn't encode averages", zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		api.blobs.putAverages(gen, network, blob)
+	}
+	writeBlob(w, blob)
 }
 
 func (api *portalAPI) networkCountriesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if api.rl.limitExceeded(getRemoteHost(req)) {
+	if api.limitExceeded(req) {
 		writeError(w, "too many requests", http.StatusTooManyRequests)
 		return
 	}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/external"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.uber.org/zap"
+)
+
+// asnShare is the number of hosts in an autonomous system.
+type asnShare struct {
+	ASN      string  `json:"asn"`
+	Provider string  `json:"provider"`
+	Hosts    int     `json:"hosts"`
+	Share    float64 `json:"share"`
+}
+
+// asnReport shows how the hosts are spread across the autonomous
+// systems. The hosts without a known ASN are only counted in Unknown.
+type asnReport struct {
+	Total   int        `json:"total"`
+	Unknown int        `json:"unknown"`
+	ASNs    []asnShare `json:"asns"`
+}
+
+// getASNReport counts the hosts per ASN, the largest first. If all is
+// false, only the online hosts are counted.
+func (api *portalAPI) getASNReport(network string, all bool) (asnReport, error) {
+	locations, err := api.getLocations(network)
+	if err != nil {
+		return asnReport{}, err
+	}
+
+	var report asnReport
+	shares := make(map[string]*asnShare)
+	for pk, host := range api.hosts.load()[network] {
+		if host.OptOut == hostdb.OptOutDelist || (!all && !isOnline(*host)) {
+			continue
+		}
+		report.Total++
+		loc, ok := locations[pk]
+		if !ok || loc.asn == "" {
+			report.Unknown++
+			continue
+		}
+		share, ok := shares[loc.asn]
+		if !ok {
+			share = &asnShare{
+				ASN:      loc.asn,
+				Provider: external.ProviderName(loc.isp),
+			}
+			shares[loc.asn] = share
+		}
+		share.Hosts++
+	}
+
+	report.ASNs = make([]asnShare, 0, len(shares))
+	for _, share := range shares {
+		share.Share = float64(share.Hosts) / float64(report.Total)
+		report.ASNs = append(report.ASNs, *share)
+	}
+	slices.SortFunc(report.ASNs, func(a, b asnShare) int {
+		if a.Hosts != b.Hosts {
+			return cmp.Compare(b.Hosts, a.Hosts)
+		}
+		return strings.Compare(a.ASN, b.ASN)
+	})
+
+	return report, nil
+}
+
+func (api *portalAPI) networkASNsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	allHosts := strings.ToLower(req.FormValue("all"))
+	var all bool
+	if allHosts == "true" {
+		all = true
+	} else if allHosts != "" && allHosts != "false" {
+		writeError(w, "wrong all parameter", http.StatusBadRequest)
+		return
+	}
+
+	report, err := api.getASNReport(network, all)
+	if err != nil {
+		api.log.Error("couldn't get ASN report", zap.String("network", network), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, report)
+}
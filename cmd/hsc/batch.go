@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+)
+
+// insertBatchSize is the maximum number of rows inserted by a single
+// statement. It keeps the number of the placeholders well below the
+// limits of the databases.
+const insertBatchSize = 500
+
+var hostColumns = []string{
+	"id",
+	"network",
+	"public_key",
+	"first_seen",
+	"known_since",
+	"blocked",
+	"net_address",
+	"ip_nets",
+	"last_ip_change",
+	"price_score",
+	"storage_score",
+	"collateral_score",
+	"interactions_score",
+	"uptime_score",
+	"age_score",
+	"version_score",
+	"latency_score",
+	"benchmarks_score",
+	"contracts_score",
+	"duration_score",
+	"total_score",
+	"settings",
+	"price_table",
+}
+
+// hostUpsert keeps the scores of the existing hosts, which are updated
+// separately.
+const hostUpsert = ` AS new
+	ON DUPLICATE KEY (public_key) UPDATE
+		first_seen = new.first_seen,
+		known_since = new.known_since,
+		blocked = new.blocked,
+		net_address = new.net_address,
+		ip_nets = new.ip_nets,
+		last_ip_change = new.last_ip_change,
+		settings = new.settings,
+		price_table = new.price_table`
+
+var interactionColumns = []string{
+	"network",
+	"node",
+	"public_key",
+	"uptime",
+	"downtime",
+	"last_seen",
+	"active_hosts",
+	"price_score",
+	"storage_score",
+	"collateral_score",
+	"interactions_score",
+	"uptime_score",
+	"age_score",
+	"version_score",
+	"latency_score",
+	"benchmarks_score",
+	"contracts_score",
+	"duration_score",
+	"total_score",
+	"historic_successful_interactions",
+	"historic_failed_interactions",
+	"recent_successful_interactions",
+	"recent_failed_interactions",
+	"last_update",
+	"formation_successes",
+	"duration_violations",
+	"expiry_successes",
+	"expiry_failures",
+	"ingress",
+	"egress",
+	"traffic_since",
+}
+
+// interactionUpsert overwrites all columns but the key.
+var interactionUpsert = func() string {
+	var sb strings.Builder
+	sb.WriteString(" AS new\n\tON DUPLICATE KEY (network, node, public_key) UPDATE")
+	for i, column := range interactionColumns[3:] {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\n\t\t" + column + " = new." + column)
+	}
+	return sb.String()
+}()
+
+var scanColumns = []string{
+	"network",
+	"node",
+	"public_key",
+	"ran_at",
+	"success",
+	"latency",
+	"error",
+	"failure",
+	"clock_skew",
+	"valid_until",
+	"invalid_signature",
+	"ipv4",
+	"ipv4_latency",
+	"ipv6",
+	"ipv6_latency",
+	"dial_time",
+	"handshake_time",
+	"settings_time",
+}
+
+var benchmarkColumns = []string{
+	"network",
+	"node",
+	"public_key",
+	"ran_at",
+	"success",
+	"upload_speed",
+	"download_speed",
+	"ttfb",
+	"uploaded",
+	"downloaded",
+	"error",
+	"failure",
+}
+
+// insertBatch inserts the rows with multi-row statements, at most
+// insertBatchSize rows at a time. suffix is appended to each statement,
+// e.g. to turn it into an upsert. If reject is nil, the first error is
+// returned. Otherwise, each statement runs under a savepoint, so that a
+// failed one doesn't abort the transaction, and the rows of a failed
+// statement are inserted one by one: the rows that still fail are passed
+// to reject and skipped. Transient errors, e.g. a deadlock, are always
+// returned, since the transaction has to be repeated then.
+func insertBatch(tx *sqldb.Tx, table string, columns []string, rows [][]any, suffix string, reject func(error)) error {
+	row := "(?" + strings.Repeat(", ?", len(columns)-1) + ")"
+	prefix := "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES "
+	insert := func(chunk [][]any) error {
+		values := make([]string, len(chunk))
+		args := make([]any, 0, len(chunk)*len(columns))
+		for i := range chunk {
+			values[i] = row
+			args = append(args, chunk[i]...)
+		}
+		_, err := tx.Exec(prefix+strings.Join(values, ", ")+suffix, args...)
+		return err
+	}
+	for len(rows) > 0 {
+		n := min(len(rows), insertBatchSize)
+		chunk := rows[:n]
+		rows = rows[n:]
+		if reject == nil {
+			if err := insert(chunk); err != nil {
+				return err
+			}
+			continue
+		}
+		err := withSavepoint(tx, func() error { return insert(chunk) })
+		if err == nil {
+			continue
+		} else if tx.Retryable(err) {
+			return err
+		}
+		for _, r := range chunk {
+			err := withSavepoint(tx, func() error { return insert([][]any{r}) })
+			if tx.Retryable(err) {
+				return err
+			} else if err != nil {
+				reject(err)
+			}
+		}
+	}
+	return nil
+}
+
+// withSavepoint runs fn under a savepoint and rolls back to it if fn
+// fails, leaving the transaction usable. A transient error is returned
+// as is, since the whole transaction has to be repeated then.
+func withSavepoint(tx *sqldb.Tx, fn func() error) error {
+	if _, err := tx.Exec("SAVEPOINT insert_batch"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if tx.Retryable(err) {
+			return err
+		}
+		if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT insert_batch"); rbErr != nil {
+			return rbErr
+		}
+		tx.Exec("RELEASE SAVEPOINT insert_batch")
+		return err
+	}
+	_, err := tx.Exec("RELEASE SAVEPOINT insert_batch")
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/testutil"
+	"lukechampine.com/frand"
+)
+
+func TestInsertBatchSkipsBadRows(t *testing.T) {
+	db := testutil.NewDB(t, "init_portal_sqlite.sql")
+	pk := testutil.AddPortalHost(t, db, 1, "mainnet")
+	orphan := frand.Entropy256()
+
+	// The scan of an unknown host violates the foreign key.
+	scan := func(pk []byte) []any {
+		return []any{"mainnet", "eu", pk, time.Now().Unix(), true, 100, "", 0, 0, 0, false, 0, 0, 0, 0, 0, 0, 0}
+	}
+	rows := [][]any{scan(pk[:]), scan(orphan[:]), scan(pk[:])}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rejected int
+	err = insertBatch(tx, "scans", scanColumns, rows, "", func(error) { rejected++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The transaction must still be usable after the failed statement.
+	if err := insertBatch(tx, "scans", scanColumns, rows[:1], "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if rejected != 1 {
+		t.Fatalf("expected 1 rejected row, got %d", rejected)
+	}
+	if n := testutil.CountRows(t, db, "scans"); n != 3 {
+		t.Fatalf("expected 3 scans, got %d", n)
+	}
+
+	// Without reject, the error is returned.
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := insertBatch(tx, "scans", scanColumns, rows, "", nil); err == nil {
+		t.Fatal("expected the foreign key violation to be returned")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxBlobKeys is the maximum number of host pages kept serialized.
+	maxBlobKeys = 64
+
+	// maxBlobLimit is the largest page of hosts that gets serialized.
+	maxBlobLimit = 100
+)
+
+// hostsBlobKey identifies a first page of /hosts without any filters.
+type hostsBlobKey struct {
+	network string
+	all     bool
+	limit   int
+	sortBy  sortType
+	asc     bool
+}
+
+// blobCache keeps the serialized responses of the hot endpoints. The
+// blobs are dropped whenever the hosts change and regenerated in the
+// background, so that the requests rarely need to marshal anything.
+type blobCache struct {
+	mu         sync.Mutex
+	hosts      map[hostsBlobKey][]byte
+	averages   map[string][]byte
+	generation uint64
+	refresh    chan struct{}
+}
+
+func newBlobCache() *blobCache {
+	return &blobCache{
+		hosts:    make(map[hostsBlobKey][]byte),
+		averages: make(map[string][]byte),
+		refresh:  make(chan struct{}, 1),
+	}
+}
+
+// cacheable returns true if the page of hosts qualifies for a blob.
+func (bc *blobCache) cacheable(offset, limit int, filter hostFilter) bool {
+	return offset == 0 && limit > 0 && limit <= maxBlobLimit && filter == hostFilter{}
+}
+
+func (bc *blobCache) getHosts(key hostsBlobKey) ([]byte, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	blob := bc.hosts[key]
+	return blob, blob != nil
+}
+
+// putHosts stores the blob unless the hosts have changed since the
+// generation the blob was built from.
+func (bc *blobCache) putHosts(gen uint64, key hostsBlobKey, blob []byte) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if gen != bc.generation {
+		return
+	}
+	if _, ok := bc.hosts[key]; !ok && len(bc.hosts) >= maxBlobKeys {
+		return
+	}
+	bc.hosts[key] = blob
+}
+
+func (bc *blobCache) getAverages(network string) ([]byte, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	blob := bc.averages[network]
+	return blob, blob != nil
+}
+
+func (bc *blobCache) putAverages(gen uint64, network string, blob []byte) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if gen == bc.generation {
+		bc.averages[network] = blob
+	}
+}
+
+// current returns the current generation of the blobs.
+func (bc *blobCache) current() uint64 {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.generation
+}
+
+// invalidate drops the blobs but keeps their keys, so that the same
+// pages get regenerated.
+func (bc *blobCache) invalidate() {
+	if bc == nil {
+		return
+	}
+	bc.mu.Lock()
+	bc.generation++
+	for key := range bc.hosts {
+		bc.hosts[key] = nil
+	}
+	for network := range bc.averages {
+		bc.averages[network] = nil
+	}
+	bc.mu.Unlock()
+
+	select {
+	case bc.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// stale returns the keys of the blobs that need to be regenerated.
+func (bc *blobCache) stale() (keys []hostsBlobKey, networks []string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for key, blob := range bc.hosts {
+		if blob == nil {
+			keys = append(keys, key)
+		}
+	}
+	for network, blob := range bc.averages {
+		if blob == nil {
+			networks = append(networks, network)
+		}
+	}
+	return
+}
+
+// marshalBlob serializes the response the same way writeJSON does.
+func marshalBlob(obj interface{}) ([]byte, error) {
+	js, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+func writeBlob(w http.ResponseWriter, blob []byte) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(blob)
+}
+
+// hostsBlob builds the blob of a page of hosts.
+func (api *portalAPI) hostsBlob(key hostsBlobKey) ([]byte, error) {
+	hosts, more, total, err := api.getHosts(key.network, key.all, 0, 0, key.limit, hostFilter{}, key.sortBy, key.asc)
+	if err != nil {
+		return nil, err
+	}
+	return marshalBlob(hostsResponse{
+		Hosts: hosts,
+		More:  more,
+		Total: total,
+		Next:  nextCursor(hosts, more, key.sortBy),
+	})
+}
+
+// refreshBlobs regenerates the blobs after the hosts have changed.
+func (api *portalAPI) refreshBlobs() {
+	for {
+		select {
+		case <-api.stopChan:
+			return
+		case <-api.blobs.refresh:
+		}
+
+		gen := api.blobs.current()
+		keys, networks := api.blobs.stale()
+		for _, key := range keys {
+			blob, err := api.hostsBlob(key)
+			if err != nil {
+				api.log.Error("couldn't regenerate hosts blob", zap.Error(err))
+				continue
+			}
+			api.blobs.putHosts(gen, key, blob)
+		}
+		for _, network := range networks {
+			api.mu.RLock()
+			blob, err := marshalBlob(averagesResponse{Averages: api.averages[network]})
+			api.mu.RUnlock()
+			if err != nil {
+				api.log.Error("couldn't regenerate averages blob", zap.Error(err))
+				continue
+			}
+			api.blobs.putAverages(gen, network, blob)
+		}
+	}
+}
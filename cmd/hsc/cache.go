@@ -20,18 +20,21 @@ type cachedHosts struct {
 	all      bool
 	offset   int
 	limit    int
-	query    string
-	country  string
+	filter   hostFilter
 	sortBy   sortType
 	asc      bool
 	modified time.Time
 }
 
+// responseCache keeps the recently requested pages of hosts. The pages
+// expire after hostsExpireThreshold, and are dropped whenever the hosts
+// change.
 type responseCache struct {
-	hosts    []cachedHosts
-	count    int
-	mu       sync.Mutex
-	stopChan chan struct{}
+	hosts      []cachedHosts
+	count      int
+	generation uint64
+	mu         sync.Mutex
+	stopChan   chan struct{}
 }
 
 func newCache() *responseCache {
@@ -87,7 +90,7 @@ func (rc *responseCache) getHost(network string, pk types.PublicKey) (host porta
 	return
 }
 
-func (rc *responseCache) getHosts(network string, all bool, offset, limit int, query, country string, sortBy sortType, asc bool) (hosts []portalHost, more bool, total int, ok bool) {
+func (rc *responseCache) getHosts(network string, all bool, offset, limit int, filter hostFilter, sortBy sortType, asc bool) (hosts []portalHost, more bool, total int, ok bool) {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 	for _, ch := range rc.hosts {
@@ -95,8 +98,7 @@ func (rc *responseCache) getHosts(network string, all bool, offset, limit int, q
 			ch.all == all &&
 			ch.offset == offset &&
 			ch.limit == limit &&
-			ch.query == query &&
-			ch.country == country &&
+			ch.filter == filter &&
 			ch.sortBy == sortBy &&
 			ch.asc == asc &&
 			time.Since(ch.modified) < hostsExpireThreshold {
@@ -110,12 +112,17 @@ func (rc *responseCache) getHosts(network string, all bool, offset, limit int, q
 	return
 }
 
-func (rc *responseCache) putHosts(network string, all bool, offset, limit int, query, country string, sortBy sortType, asc bool, hosts []portalHost, more bool, total int) {
+// putHosts stores the page unless the hosts have changed since the
+// generation the page was built from.
+func (rc *responseCache) putHosts(gen uint64, network string, all bool, offset, limit int, filter hostFilter, sortBy sortType, asc bool, hosts []portalHost, more bool, total int) {
 	if len(hosts) > cachedHostsLimit {
 		return
 	}
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
+	if gen != rc.generation {
+		return
+	}
 	rc.count += len(hosts)
 	for rc.count > cachedHostsLimit {
 		rc.count -= len(rc.hosts[0].hosts)
@@ -133,10 +140,28 @@ func (rc *responseCache) putHosts(network string, all bool, offset, limit int, q
 		all:      all,
 		offset:   offset,
 		limit:    limit,
-		query:    query,
-		country:  country,
+		filter:   filter,
 		sortBy:   sortBy,
 		asc:      asc,
 		modified: time.Now(),
 	})
 }
+
+// current returns the current generation of the pages.
+func (rc *responseCache) current() uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.generation
+}
+
+// invalidate drops all pages.
+func (rc *responseCache) invalidate() {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.generation++
+	rc.hosts = nil
+	rc.count = 0
+}
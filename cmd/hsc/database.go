@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"database/sql"
 	"errors"
+	"maps"
 	"math"
 	"slices"
+	"sort"
 	"strings"
 	"time"
 
+	client "github.com/mike76-dev/hostscore/api"
 	"github.com/mike76-dev/hostscore/external"
 	"github.com/mike76-dev/hostscore/hostdb"
 	"github.com/mike76-dev/hostscore/internal/build"
@@ -25,196 +29,131 @@ const scanPruneThreshold = 14 * 24 * time.Hour
 // scanPruneInterval determines how often old scan records get pruned.
 const scanPruneInterval = time.Hour
 
+// rankInterval determines how often the hosts are ranked again after
+// their scores have been updated.
+const rankInterval = 30 * time.Second
+
+// averagesInterval determines how often the network averages are
+// recalculated.
+const averagesInterval = 10 * time.Minute
+
 // errHostNotFound is returned when the specified host couldn't be found.
 var errHostNotFound = errors.New("host not found")
 
-// insertUpdates updates the database with new records.
-func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) error {
-	tx, err := api.db.Begin()
+// insertUpdates updates the database with new records and confirms the
+// receipt to the node the batch came from. The records of the batch
+// already ingested are skipped.
+func (api *portalAPI) insertUpdates(node string, c *client.Client, standby bool, updates hostdb.HostUpdates) error {
+	offset, err := api.resumeUpdates(node, standby, updates.ID)
 	if err != nil {
-		return utils.AddContext(err, "couldn't start transaction")
+		return err
 	}
+	if total := countUpdates(updates); offset.applied < total {
+		updates = skipUpdates(updates, offset.applied)
+		offset.applied = total
+		if err := api.ingestUpdates(node, updates, offset); err != nil {
+			return err
+		}
+	}
+	return api.finalizeUpdates(c, updates.ID)
+}
 
-	hostStmt, err := tx.Prepare(`
-		INSERT INTO hosts (
-			id,
-			network,
-			public_key,
-			first_seen,
-			known_since,
-			blocked,
-			net_address,
-			ip_nets,
-			last_ip_change,
-			price_score,
-			storage_score,
-			collateral_score,
-			interactions_score,
-			uptime_score,
-			age_score,
-			version_score,
-			latency_score,
-			benchmarks_score,
-			contracts_score,
-			total_score,
-			settings,
-			price_table
-		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
-		ON DUPLICATE KEY UPDATE
-			first_seen = new.first_seen,
-			known_since = new.known_since,
-			blocked = new.blocked,
-			net_address = new.net_address,
-			ip_nets = new.ip_nets,
-			last_ip_change = new.last_ip_change,
-			settings = new.settings,
-			price_table = new.price_table
-	`)
-	if err != nil {
-		tx.Rollback()
-		return utils.AddContext(err, "couldn't prepare host statement")
+// finalizeUpdates confirms the receipt of the updates to the node.
+func (api *portalAPI) finalizeUpdates(c *client.Client, id hostdb.UpdateID) error {
+	if err := c.FinalizeUpdates(id); err != nil {
+		return utils.AddContext(err, "couldn't finalize updates")
 	}
-	defer hostStmt.Close()
 
-	interactionsStmt, err := tx.Prepare(`
-		INSERT INTO interactions (
-			network,
-			node,
-			public_key,
-			uptime,
-			downtime,
-			last_seen,
-			active_hosts,
-			price_score,
-			storage_score,
-			collateral_score,
-			interactions_score,
-			uptime_score,
-			age_score,
-			version_score,
-			latency_score,
-			benchmarks_score,
-			contracts_score,
-			total_score,
-			historic_successful_interactions,
-			historic_failed_interactions,
-			recent_successful_interactions,
-			recent_failed_interactions,
-			last_update
-		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
-		ON DUPLICATE KEY UPDATE
-			uptime = new.uptime,
-			downtime = new.downtime,
-			last_seen = new.last_seen,
-			active_hosts = new.active_hosts,
-			price_score = new.price_score,
-			storage_score = new.storage_score,
-			collateral_score = new.collateral_score,
-			interactions_score = new.interactions_score,
-			uptime_score = new.uptime_score,
-			age_score = new.age_score,
-			version_score = new.version_score,
-			latency_score = new.latency_score,
-			benchmarks_score = new.benchmarks_score,
-			contracts_score = new.contracts_score,
-			total_score = new.total_score,
-			historic_successful_interactions = new.historic_successful_interactions,
-			historic_failed_interactions = new.historic_failed_interactions,
-			recent_successful_interactions = new.recent_successful_interactions,
-			recent_failed_interactions = new.recent_failed_interactions,
-			last_update = new.last_update
-	`)
+	return nil
+}
+
+// ingestUpdates updates the database with new records. The transaction
+// is repeated if it fails with a transient error, e.g. a deadlock.
+func (api *portalAPI) ingestUpdates(node string, updates hostdb.HostUpdates, offset updateOffset) error {
+	ingestedRows.Add(uint64(countUpdates(updates)))
+	return api.db.Retry(func() error {
+		return api.applyUpdates(node, updates, offset)
+	})
+}
+
+// applyUpdates writes the updates in a single transaction together with
+// the offset of the batch. It may be repeated, so the records already
+// merged into the memory are skipped.
+func (api *portalAPI) applyUpdates(node string, updates hostdb.HostUpdates, offset updateOffset) error {
+	// The batches are applied one at a time, since each of them works
+	// on its own copies of the hosts.
+	api.updateMu.Lock()
+	defer api.updateMu.Unlock()
+
+	// Mark the scores if the updates come from a standby node.
+	standby := offset.standby
+
+	tx, err := api.db.Begin()
 	if err != nil {
-		tx.Rollback()
-		return utils.AddContext(err, "couldn't prepare interactions statement")
+		return utils.AddContext(err, "couldn't start transaction")
 	}
-	defer interactionsStmt.Close()
 
-	scanSuccessStmt, err := tx.Prepare(`
-		SELECT success
-		FROM scans
-		WHERE network = ?
-		AND node = ?
-		AND public_key = ?
-		ORDER BY ran_at DESC
-		LIMIT 1
-	`)
+	scanSuccessStmt, err := tx.Prepare(scanSuccessQuery)
 	if err != nil {
 		tx.Rollback()
 		return utils.AddContext(err, "couldn't prepare scan success statement")
 	}
 	defer scanSuccessStmt.Close()
 
-	scanStmt, err := tx.Prepare(`
-		INSERT INTO scans (
-			network,
-			node,
-			public_key,
-			ran_at,
-			success,
-			latency,
-			error
-		)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`)
+	priceChangeCountStmt, err := tx.Prepare(priceChangeCountQuery)
 	if err != nil {
 		tx.Rollback()
-		return utils.AddContext(err, "couldn't prepare scan statement")
+		return utils.AddContext(err, "couldn't prepare price change count statement")
 	}
-	defer scanStmt.Close()
+	defer priceChangeCountStmt.Close()
 
-	benchmarkStmt, err := tx.Prepare(`
-		INSERT INTO benchmarks (
+	priceChangeStmt, err := tx.Prepare(`
+		INSERT INTO price_changes (
 			network,
-			node,
 			public_key,
-			ran_at,
-			success,
-			upload_speed,
-			download_speed,
-			ttfb,
-			error
+			changed_at,
+			remaining_storage,
+			total_storage,
+			collateral,
+			storage_price,
+			upload_price,
+			download_price
 		)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		tx.Rollback()
-		return utils.AddContext(err, "couldn't prepare benchmark statement")
+		return utils.AddContext(err, "couldn't prepare price change statement")
 	}
-	defer benchmarkStmt.Close()
+	defer priceChangeStmt.Close()
 
-	priceChangeCountStmt, err := tx.Prepare(`
+	settingsCountStmt, err := tx.Prepare(`
 		SELECT COUNT(*)
-		FROM price_changes
-		WHERE public_key = ?
+		FROM settings_history
+		WHERE network = ?
+		AND public_key = ?
 	`)
 	if err != nil {
 		tx.Rollback()
-		return utils.AddContext(err, "couldn't prepare price change count statement")
+		return utils.AddContext(err, "couldn't prepare settings count statement")
 	}
-	defer priceChangeCountStmt.Close()
+	defer settingsCountStmt.Close()
 
-	priceChangeStmt, err := tx.Prepare(`
-		INSERT INTO price_changes (
+	settingsStmt, err := tx.Prepare(`
+		INSERT INTO settings_history (
 			network,
 			public_key,
 			changed_at,
-			remaining_storage,
-			total_storage,
-			collateral,
-			storage_price,
-			upload_price,
-			download_price
+			settings,
+			price_table
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		VALUES (?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		tx.Rollback()
-		return utils.AddContext(err, "couldn't prepare price change statement")
+		return utils.AddContext(err, "couldn't prepare settings statement")
 	}
-	defer priceChangeStmt.Close()
+	defer settingsStmt.Close()
 
 	updateScoreStmt, err := tx.Prepare(`
 		UPDATE hosts
@@ -228,6 +167,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			latency_score = ?,
 			benchmarks_score = ?,
 			contracts_score = ?,
+			duration_score = ?,
 			total_score = ?
 		WHERE network = ?
 		AND public_key = ?
@@ -238,7 +178,19 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 	}
 	defer updateScoreStmt.Close()
 
-	for _, host := range updates.Hosts {
+	// A host may appear twice in the updates, but a multi-row upsert
+	// can't affect the same row twice, so only its latest record is kept.
+	var hostRows [][]any
+	seen := make(map[string]map[types.PublicKey]struct{})
+	for i := len(updates.Hosts) - 1; i >= 0; i-- {
+		host := updates.Hosts[i]
+		if _, ok := seen[host.Network][host.PublicKey]; ok {
+			continue
+		}
+		if seen[host.Network] == nil {
+			seen[host.Network] = make(map[types.PublicKey]struct{})
+		}
+		seen[host.Network][host.PublicKey] = struct{}{}
 		var settings, pt bytes.Buffer
 		e := types.NewEncoder(&settings)
 		if (host.Settings != rhpv2.HostSettings{}) {
@@ -250,7 +202,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			utils.EncodePriceTable(&host.PriceTable, e)
 			e.Flush()
 		}
-		_, err := hostStmt.Exec(
+		hostRows = append(hostRows, []any{
 			host.ID,
 			host.Network,
 			host.PublicKey[:],
@@ -271,17 +223,22 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			0,
 			0,
 			0,
+			0,
 			settings.Bytes(),
 			pt.Bytes(),
-		)
-		if err != nil {
-			tx.Rollback()
-			return utils.AddContext(err, "couldn't update host record")
+		})
+	}
+	if err := insertBatch(tx, "hosts", hostColumns, hostRows, hostUpsert, nil); err != nil {
+		tx.Rollback()
+		if api.db.Retryable(err) {
+			return err
 		}
+		return utils.AddContext(err, "couldn't update host records")
 	}
 
+	scanRows := make([][]any, 0, len(updates.Scans))
 	for _, scan := range updates.Scans {
-		_, err := scanStmt.Exec(
+		scanRows = append(scanRows, []any{
 			scan.Network,
 			node,
 			scan.PublicKey[:],
@@ -289,14 +246,33 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			scan.Success,
 			scan.Latency.Milliseconds(),
 			scan.Error,
-		)
-		if err != nil {
-			api.log.Warn("couldn't insert scan record", zap.Stringer("host", scan.PublicKey), zap.String("network", scan.Network), zap.String("node", scan.Node), zap.Error(err))
+			uint8(scan.Failure),
+			scan.ClockSkew,
+			scan.ValidUntil.Unix(),
+			scan.InvalidSig,
+			uint8(scan.IPv4.Reachability),
+			scan.IPv4.Latency.Milliseconds(),
+			uint8(scan.IPv6.Reachability),
+			scan.IPv6.Latency.Milliseconds(),
+			utils.DurationToMS(scan.Timings.Dial),
+			utils.DurationToMS(scan.Timings.Handshake),
+			utils.DurationToMS(scan.Timings.Settings),
+		})
+	}
+	err = insertBatch(tx, "scans", scanColumns, scanRows, "", func(err error) {
+		api.log.Warn("couldn't insert scan record", zap.String("node", node), zap.Error(err))
+	})
+	if err != nil {
+		tx.Rollback()
+		if api.db.Retryable(err) {
+			return err
 		}
+		return utils.AddContext(err, "couldn't insert scan records")
 	}
 
+	benchmarkRows := make([][]any, 0, len(updates.Benchmarks))
 	for _, benchmark := range updates.Benchmarks {
-		_, err := benchmarkStmt.Exec(
+		benchmarkRows = append(benchmarkRows, []any{
 			benchmark.Network,
 			node,
 			benchmark.PublicKey[:],
@@ -305,25 +281,39 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			benchmark.UploadSpeed,
 			benchmark.DownloadSpeed,
 			benchmark.TTFB.Milliseconds(),
+			benchmark.Uploaded,
+			benchmark.Downloaded,
 			benchmark.Error,
-		)
-		if err != nil {
-			api.log.Warn("couldn't insert benchmark record", zap.Stringer("host", benchmark.PublicKey), zap.String("network", benchmark.Network), zap.String("node", benchmark.Node), zap.Error(err))
+			uint8(benchmark.Failure),
+		})
+	}
+	err = insertBatch(tx, "benchmarks", benchmarkColumns, benchmarkRows, "", func(err error) {
+		api.log.Warn("couldn't insert benchmark record", zap.String("node", node), zap.Error(err))
+	})
+	if err != nil {
+		tx.Rollback()
+		if api.db.Retryable(err) {
+			return err
 		}
+		return utils.AddContext(err, "couldn't insert benchmark records")
 	}
 
-	api.mu.Lock()
+	// Remember the scores before the update to detect the changes.
+	oldScores := map[string]map[types.PublicKey]float64{
+		"mainnet": make(map[types.PublicKey]float64),
+		"zen":     make(map[types.PublicKey]float64),
+	}
+
+	// The updates are applied to the copies of the hosts, so that the
+	// API keeps serving the current ones in the meantime.
+	staged := api.stageHosts(node, updates)
+	var newHosts []hostdb.HostDBEntry
+
 	for _, h := range updates.Hosts {
-		var host *portalHost
-		var exists bool
-		hosts, ok := api.hosts[h.Network]
-		if ok {
-			host, exists = hosts[h.PublicKey]
-		}
+		host, exists := staged[h.Network][h.PublicKey]
 		var count int
-		if err := priceChangeCountStmt.QueryRow(h.PublicKey[:]).Scan(&count); err != nil {
+		if err := priceChangeCountStmt.QueryRow(h.Network, h.PublicKey[:]).Scan(&count); err != nil {
 			tx.Rollback()
-			api.mu.Unlock()
 			return utils.AddContext(err, "couldn't count price changes")
 		}
 		if exists && (count == 0 || pricesChanged(h.Settings, host.Settings)) {
@@ -340,22 +330,59 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			e = types.NewEncoder(&dpb)
 			types.V1Currency(h.Settings.DownloadBandwidthPrice).EncodeTo(e)
 			e.Flush()
-			_, err := priceChangeStmt.Exec(
-				h.Network,
-				h.PublicKey[:],
-				time.Now().Unix(),
-				h.Settings.RemainingStorage,
-				h.Settings.TotalStorage,
-				cb.Bytes(),
-				spb.Bytes(),
-				upb.Bytes(),
-				dpb.Bytes(),
-			)
-			if err != nil {
+			err := withSavepoint(tx, func() error {
+				_, err := priceChangeStmt.Exec(
+					h.Network,
+					h.PublicKey[:],
+					time.Now().Unix(),
+					h.Settings.RemainingStorage,
+					h.Settings.TotalStorage,
+					cb.Bytes(),
+					spb.Bytes(),
+					upb.Bytes(),
+					dpb.Bytes(),
+				)
+				return err
+			})
+			if api.db.Retryable(err) {
+				tx.Rollback()
+				return err
+			} else if err != nil {
 				api.log.Warn("couldn't update price change", zap.Stringer("host", h.PublicKey), zap.String("network", h.Network), zap.String("node", node), zap.Error(err))
 			}
 		}
 
+		if exists && (h.Settings != rhpv2.HostSettings{}) {
+			var count int
+			if err := settingsCountStmt.QueryRow(h.Network, h.PublicKey[:]).Scan(&count); err != nil {
+				tx.Rollback()
+				return utils.AddContext(err, "couldn't count settings snapshots")
+			}
+			if count == 0 || settingsChanged(host.Settings, h.Settings, host.PriceTable, h.PriceTable) {
+				sb, pb := encodeSettingsSnapshot(h.Settings, h.PriceTable)
+				err := withSavepoint(tx, func() error {
+					_, err := settingsStmt.Exec(
+						h.Network,
+						h.PublicKey[:],
+						time.Now().Unix(),
+						sb,
+						pb,
+					)
+					return err
+				})
+				if api.db.Retryable(err) {
+					tx.Rollback()
+					return err
+				} else if err != nil {
+					api.log.Warn("couldn't insert settings snapshot", zap.Stringer("host", h.PublicKey), zap.String("network", h.Network), zap.String("node", node), zap.Error(err))
+				}
+			}
+		}
+
+		if exists && priceIncrease(settingsPrices(host.Settings), settingsPrices(h.Settings)) >= priceSpikeThreshold {
+			host.priceSpikeAt = time.Now()
+		}
+
 		if exists {
 			host.NetAddress = h.NetAddress
 			host.Blocked = h.Blocked
@@ -375,8 +402,10 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 				RecentFailures:    h.Interactions.RecentFailures,
 				LastUpdate:        h.Interactions.LastUpdate,
 			}
+			interactions.Compliance = h.Compliance
 			host.Interactions[node] = interactions
 		} else {
+			newHosts = append(newHosts, h)
 			host = &portalHost{
 				ID:           h.ID,
 				PublicKey:    h.PublicKey,
@@ -395,6 +424,7 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 				Downtime:    h.Downtime,
 				LastSeen:    h.LastSeen,
 				ActiveHosts: h.ActiveHosts,
+				Compliance:  h.Compliance,
 				HostInteractions: hostdb.HostInteractions{
 					HistoricSuccesses: h.Interactions.HistoricSuccesses,
 					HistoricFailures:  h.Interactions.HistoricFailures,
@@ -403,13 +433,18 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 					LastUpdate:        h.Interactions.LastUpdate,
 				},
 			}
-			info, err := external.FetchIPInfo(h.NetAddress, api.token)
+			info, err := api.fetchLocation(h.NetAddress)
 			if err != nil {
 				api.log.Error("couldn't fetch host location", zap.String("host", h.NetAddress), zap.Error(err))
 			} else {
 				if (info != external.IPInfo{}) {
-					err = api.saveLocation(h.PublicKey, h.Network, info)
-					if err != nil {
+					err = withSavepoint(tx, func() error {
+						return api.saveLocation(tx, h.PublicKey, h.Network, info)
+					})
+					if api.db.Retryable(err) {
+						tx.Rollback()
+						return err
+					} else if err != nil {
 						api.log.Error("couldn't update host location", zap.String("host", h.NetAddress), zap.Error(err))
 					}
 				} else {
@@ -418,28 +453,10 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 			}
 		}
 
-		host.Score = calculateGlobalScore(host)
-		_, err := updateScoreStmt.Exec(
-			host.Score.PricesScore,
-			host.Score.StorageScore,
-			host.Score.CollateralScore,
-			host.Score.InteractionsScore,
-			host.Score.UptimeScore,
-			host.Score.AgeScore,
-			host.Score.VersionScore,
-			host.Score.LatencyScore,
-			host.Score.BenchmarksScore,
-			host.Score.ContractsScore,
-			host.Score.TotalScore,
-			h.Network,
-			h.PublicKey[:],
-		)
-		if err != nil {
-			tx.Rollback()
-			api.mu.Unlock()
-			return utils.AddContext(err, "couldn't update score")
+		if _, ok := oldScores[h.Network][h.PublicKey]; !ok {
+			oldScores[h.Network][h.PublicKey] = host.Score.TotalScore
 		}
-		api.hosts[h.Network][h.PublicKey] = host
+		staged[h.Network][h.PublicKey] = host
 	}
 
 	toUpdate := make(map[string]map[types.PublicKey]struct{})
@@ -452,10 +469,17 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 	for _, scan := range updates.Scans {
 		toUpdate[scan.Network][scan.PublicKey] = struct{}{}
 		newScans[scan.Network][scan.PublicKey] = append(newScans[scan.Network][scan.PublicKey], portalScan{
-			Timestamp: scan.Timestamp,
-			Latency:   scan.Latency,
-			Success:   scan.Success,
-			Error:     scan.Error,
+			Timestamp:  scan.Timestamp,
+			Latency:    scan.Latency,
+			Success:    scan.Success,
+			Error:      scan.Error,
+			Failure:    scan.Failure,
+			ClockSkew:  scan.ClockSkew,
+			ValidUntil: scan.ValidUntil,
+			InvalidSig: scan.InvalidSig,
+			IPv4:       scan.IPv4,
+			IPv6:       scan.IPv6,
+			Timings:    scan.Timings,
 		})
 	}
 
@@ -467,30 +491,56 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 		newBenchmarks[benchmark.Network][benchmark.PublicKey] = append(newBenchmarks[benchmark.Network][benchmark.PublicKey], benchmark.HostBenchmark)
 	}
 
+	var interactionRows [][]any
 	for network, keys := range toUpdate {
 		for pk := range keys {
-			hosts := api.hosts[network]
-			host, exists := hosts[pk]
+			host, exists := staged[network][pk]
 			if !exists {
 				api.log.Warn("orphaned scan or benchmark found", zap.String("network", network), zap.Stringer("host", pk))
 				continue
 			}
 
+			if err := api.loadHistory(network, host); err != nil {
+				api.log.Warn("couldn't load host history", zap.Stringer("host", pk), zap.String("network", network), zap.Error(err))
+				continue
+			}
+
 			interactions := host.Interactions[node]
-			interactions.ScanHistory = append(interactions.ScanHistory, newScans[network][pk]...)
+
+			// Skip the records that have been merged already, e.g. before
+			// the transaction was repeated.
+			scans := slices.DeleteFunc(newScans[network][pk], func(scan portalScan) bool {
+				return slices.ContainsFunc(interactions.ScanHistory, func(s portalScan) bool { return s.Timestamp.Equal(scan.Timestamp) })
+			})
+			benchmarks := slices.DeleteFunc(newBenchmarks[network][pk], func(benchmark hostdb.HostBenchmark) bool {
+				return slices.ContainsFunc(interactions.BenchmarkHistory, func(b hostdb.HostBenchmark) bool { return b.Timestamp.Equal(benchmark.Timestamp) })
+			})
+			interactions.ScanHistory = append(interactions.ScanHistory, scans...)
 			slices.SortFunc(interactions.ScanHistory, func(a, b portalScan) int { return b.Timestamp.Compare(a.Timestamp) })
 			if len(interactions.ScanHistory) > 48 {
 				interactions.ScanHistory = interactions.ScanHistory[:48]
 			}
-			interactions.BenchmarkHistory = append(interactions.BenchmarkHistory, newBenchmarks[network][pk]...)
+			interactions.HighSkew = highSkew(interactions.ScanHistory)
+			interactions.InvalidSig = invalidSignature(interactions.ScanHistory)
+			interactions.Reachability = latestReachability(interactions.ScanHistory)
+			// The skipped benchmarks are stored but kept out of the
+			// history the score is calculated from.
+			for _, benchmark := range benchmarks {
+				if benchmark.Failure != hostdb.FailureSkipped {
+					interactions.BenchmarkHistory = append(interactions.BenchmarkHistory, benchmark)
+				}
+			}
 			slices.SortFunc(interactions.BenchmarkHistory, func(a, b hostdb.HostBenchmark) int { return b.Timestamp.Compare(a.Timestamp) })
 			if len(interactions.BenchmarkHistory) > 12 {
 				interactions.BenchmarkHistory = interactions.BenchmarkHistory[:12]
 			}
-			interactions.Score = calculateScore(*host, node, interactions.ScanHistory, interactions.BenchmarkHistory)
+			interactions.Traffic.add(benchmarks)
+			interactions.Score = calculateScore(*host, network, node, interactions.ScanHistory, interactions.BenchmarkHistory)
+			interactions.Standby = standby
 			host.Interactions[node] = interactions
+			host.Reachability = hostReachability(host)
 
-			_, err = interactionsStmt.Exec(
+			interactionRows = append(interactionRows, []any{
 				network,
 				node,
 				pk[:],
@@ -508,105 +558,294 @@ func (api *portalAPI) insertUpdates(node string, updates hostdb.HostUpdates) err
 				interactions.Score.LatencyScore,
 				interactions.Score.BenchmarksScore,
 				interactions.Score.ContractsScore,
+				interactions.Score.DurationScore,
 				interactions.Score.TotalScore,
 				interactions.HistoricSuccesses,
 				interactions.HistoricFailures,
 				interactions.RecentSuccesses,
 				interactions.RecentFailures,
 				interactions.LastUpdate,
-			)
-			if err != nil {
-				api.log.Warn("couldn't update host interactions", zap.Stringer("host", host.PublicKey), zap.String("network", network), zap.String("node", node), zap.Error(err))
+				interactions.Compliance.FormationSuccesses,
+				interactions.Compliance.DurationViolations,
+				interactions.Compliance.ExpirySuccesses,
+				interactions.Compliance.ExpiryFailures,
+				interactions.Traffic.Ingress,
+				interactions.Traffic.Egress,
+				interactions.Traffic.sinceUnix(),
+			})
+
+			if _, ok := oldScores[network][pk]; !ok {
+				oldScores[network][pk] = host.Score.TotalScore
 			}
+		}
+	}
 
-			host.Score = calculateGlobalScore(host)
-			_, err := updateScoreStmt.Exec(
-				host.Score.PricesScore,
-				host.Score.StorageScore,
-				host.Score.CollateralScore,
-				host.Score.InteractionsScore,
-				host.Score.UptimeScore,
-				host.Score.AgeScore,
-				host.Score.VersionScore,
-				host.Score.LatencyScore,
-				host.Score.BenchmarksScore,
-				host.Score.ContractsScore,
-				host.Score.TotalScore,
-				network,
-				pk[:],
-			)
-			if err != nil {
-				tx.Rollback()
-				api.mu.Unlock()
-				return utils.AddContext(err, "couldn't update score")
-			}
+	err = insertBatch(tx, "interactions", interactionColumns, interactionRows, interactionUpsert, func(err error) {
+		api.log.Warn("couldn't update host interactions", zap.String("node", node), zap.Error(err))
+	})
+	if err != nil {
+		tx.Rollback()
+		if api.db.Retryable(err) {
+			return err
 		}
+		return utils.AddContext(err, "couldn't update host interactions")
 	}
 
-	var hosts, hostsZen []portalHost
-	for _, host := range api.hosts["mainnet"] {
-		hosts = append(hosts, *host)
+	// The hosts are only published once the transaction has been
+	// committed, so that a failed or repeated one leaves them intact.
+	w := api.hosts.write()
+	defer w.discard()
+	scores := publishHosts(w, node, staged, toUpdate)
+	var events []portalEvent
+	if api.events.active() {
+		events = collectEvents(w, node, updates, oldScores)
 	}
-	for _, host := range api.hosts["zen"] {
-		hostsZen = append(hostsZen, *host)
+
+	for _, score := range scores {
+		if _, err := updateScoreStmt.Exec(score...); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't update score")
+		}
 	}
-	slices.SortStableFunc(hosts, func(a, b portalHost) int {
-		if a.Score.TotalScore == b.Score.TotalScore {
-			aIsOnline, bIsOnline := isOnline(a), isOnline(b)
-			if aIsOnline && !bIsOnline {
-				return -1
+
+	if err := saveUpdateOffset(tx, node, offset); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	w.publish()
+	// Sorting all hosts is expensive, so they are ranked by a job.
+	api.rankPending.Store(true)
+	api.events.publish(events)
+	if api.shadow == nil {
+		api.newHosts.track(newHosts)
+	}
+
+	return nil
+}
+
+// hostRanking is the outcome of ranking the hosts: the copies of the
+// hosts with their ranks, sybil clusters, and tags, and the clusters
+// themselves. It is never modified once computed, so it can be read
+// without a lock.
+type hostRanking struct {
+	hosts    map[string]map[types.PublicKey]*portalHost
+	clusters map[string][]sybilCluster
+}
+
+// rankUpdatedHosts ranks the hosts if their scores have been updated
+// since the last time.
+func (api *portalAPI) rankUpdatedHosts() {
+	if api.rankPending.Swap(false) {
+		api.rerankHosts()
+	}
+}
+
+// rerankHosts ranks the hosts without blocking the API. The copies of
+// the current hosts are ranked, and the outcome is applied to the hosts
+// published in the meantime.
+func (api *portalAPI) rerankHosts() {
+	// An older ranking must not replace a newer one.
+	api.rankMu.Lock()
+	defer api.rankMu.Unlock()
+
+	r := api.computeRanking(snapshotHosts(api.hosts.load()))
+	api.hosts.update(func(w *hostWriter) {
+		api.applyRanking(w, r)
+	})
+	api.ranking.Store(r)
+	api.blobs.invalidate()
+	api.cache.invalidate()
+}
+
+// rankHosts ranks the hosts that haven't been published yet. It is used
+// when the hosts are loaded.
+func (api *portalAPI) rankHosts(hosts hostSet) {
+	api.rankPending.Store(false)
+	r := api.computeRanking(snapshotHosts(hosts))
+	for network, hs := range hosts {
+		for pk, host := range hs {
+			applyRank(host, r.hosts[network][pk])
+		}
+	}
+	api.ranking.Store(r)
+}
+
+// snapshotHosts returns the copies of the hosts that can be ranked.
+func snapshotHosts(hosts hostSet) map[string]map[types.PublicKey]*portalHost {
+	snapshot := make(map[string]map[types.PublicKey]*portalHost)
+	for network, hs := range hosts {
+		snapshot[network] = make(map[types.PublicKey]*portalHost, len(hs))
+		for pk, host := range hs {
+			c := *host
+			c.Interactions = maps.Clone(host.Interactions)
+			snapshot[network][pk] = &c
+		}
+	}
+	return snapshot
+}
+
+// computeRanking finds the sybil clusters, sorts the hosts by their
+// scores, reduced by the sybil penalty, and assigns the tags. Only the
+// copies of the hosts are modified.
+func (api *portalAPI) computeRanking(snapshot map[string]map[types.PublicKey]*portalHost) *hostRanking {
+	r := &hostRanking{
+		hosts:    snapshot,
+		clusters: make(map[string][]sybilCluster),
+	}
+	for network, hosts := range snapshot {
+		r.clusters[network] = api.findSybilClusters(hosts)
+		sorted := make([]*portalHost, 0, len(hosts))
+		for _, host := range hosts {
+			sorted = append(sorted, host)
+		}
+		slices.SortStableFunc(sorted, func(a, b *portalHost) int {
+			if a.Quarantined != b.Quarantined {
+				return compareQuarantined(*a, *b)
+			}
+			aScore, bScore := api.rankingScore(*a), api.rankingScore(*b)
+			if aScore == bScore {
+				aIsOnline, bIsOnline := isOnline(*a), isOnline(*b)
+				if aIsOnline && !bIsOnline {
+					return -1
+				}
+				if !aIsOnline && bIsOnline {
+					return 1
+				}
+				return a.ID - b.ID
 			}
-			if !aIsOnline && bIsOnline {
+			if aScore < bScore {
 				return 1
+			} else {
+				return -1
 			}
-			return a.ID - b.ID
-		}
-		if a.Score.TotalScore < b.Score.TotalScore {
-			return 1
-		} else {
-			return -1
+		})
+		for i, host := range sorted {
+			host.Rank = i + 1
 		}
-	})
-	slices.SortStableFunc(hostsZen, func(a, b portalHost) int {
-		if a.Score.TotalScore == b.Score.TotalScore {
-			aIsOnline, bIsOnline := isOnline(a), isOnline(b)
-			if aIsOnline && !bIsOnline {
-				return -1
+	}
+	api.tagHosts(snapshot)
+	return r
+}
+
+// applyRanking copies the ranks, the sybil clusters, and the tags to the
+// hosts of w. Only the hosts whose ranking has changed are copied. The
+// hosts added after the snapshot was taken are ranked the next time.
+func (api *portalAPI) applyRanking(w *hostWriter, r *hostRanking) {
+	for network, hosts := range w.hosts {
+		for pk, host := range hosts {
+			ranked, ok := r.hosts[network][pk]
+			if !ok {
+				api.rankPending.Store(true)
+				continue
 			}
-			if !aIsOnline && bIsOnline {
-				return 1
+			if host.Rank == ranked.Rank && host.SybilCluster == ranked.SybilCluster &&
+				host.sybilPenalized == ranked.sybilPenalized && slices.Equal(host.Tags, ranked.Tags) {
+				continue
 			}
-			return a.ID - b.ID
+			host, _ = w.edit(network, pk)
+			applyRank(host, ranked)
 		}
-		if a.Score.TotalScore < b.Score.TotalScore {
-			return 1
-		} else {
-			return -1
+	}
+}
+
+// applyRank copies the rank, the sybil cluster, and the tags of the
+// ranked copy to the host.
+func applyRank(host, ranked *portalHost) {
+	host.Rank = ranked.Rank
+	host.SybilCluster = ranked.SybilCluster
+	host.sybilPenalized = ranked.sybilPenalized
+	host.Tags = ranked.Tags
+}
+
+// compareQuarantined puts the quarantined hosts at the end of the ranking.
+func compareQuarantined(a, b portalHost) int {
+	if a.Quarantined {
+		return 1
+	}
+	return -1
+}
+
+// highSkew returns true if the most recent successful scan found the clock
+// of the host too far from the node's one.
+func highSkew(scans []portalScan) bool {
+	for _, scan := range scans {
+		if !scan.Success {
+			continue
 		}
-	})
-	for i := range hosts {
-		api.hosts["mainnet"][hosts[i].PublicKey].Rank = i + 1
+		skew := time.Duration(scan.ClockSkew) * time.Second
+		return skew > hostdb.MaxClockSkew || skew < -hostdb.MaxClockSkew
+	}
+	return false
+}
+
+// invalidSignature returns true if the most recent scan failed because of
+// an invalid host signature.
+func invalidSignature(scans []portalScan) bool {
+	scans = hostScans(scans)
+	return len(scans) > 0 && scans[0].InvalidSig
+}
+
+// addressScan converts a stored probe result.
+func addressScan(reachability uint8, latency float64) hostdb.AddressScan {
+	return hostdb.AddressScan{
+		Reachability: hostdb.Reachability(reachability),
+		Latency:      time.Duration(latency) * time.Millisecond,
 	}
-	for i := range hostsZen {
-		api.hosts["zen"][hostsZen[i].PublicKey].Rank = i + 1
+}
+
+// scanTimings converts the stored timings of a scan.
+func scanTimings(dial, handshake, rpc float64) hostdb.ScanTimings {
+	return hostdb.ScanTimings{
+		Dial:      utils.MSToDuration(dial),
+		Handshake: utils.MSToDuration(handshake),
+		Settings:  utils.MSToDuration(rpc),
 	}
-	api.mu.Unlock()
+}
 
-	if err := tx.Commit(); err != nil {
-		return utils.AddContext(err, "couldn't commit transaction")
+// latestReachability returns the reachability of the host according to
+// the most recent scan.
+func latestReachability(scans []portalScan) reachability {
+	scans = hostScans(scans)
+	if len(scans) == 0 {
+		return reachability{}
+	}
+	return reachability{
+		IPv4:      scans[0].IPv4.Reachability,
+		IPv6:      scans[0].IPv6.Reachability,
+		DualStack: scans[0].IPv4.Reachability == hostdb.Reachable && scans[0].IPv6.Reachability == hostdb.Reachable,
 	}
+}
 
-	if err := api.clients[node].FinalizeUpdates(updates.ID); err != nil {
-		return utils.AddContext(err, "couldn't finalize updates")
+// hostReachability combines the reachabilities reported by the nodes.
+// An address family counts as reachable if at least one node could reach
+// the host over it.
+func hostReachability(host *portalHost) (r reachability) {
+	for _, interactions := range host.Interactions {
+		r.IPv4 = max(r.IPv4, interactions.Reachability.IPv4)
+		r.IPv6 = max(r.IPv6, interactions.Reachability.IPv6)
 	}
-
-	return nil
+	r.DualStack = r.IPv4 == hostdb.Reachable && r.IPv6 == hostdb.Reachable
+	return
 }
 
 // isOnline returns true if the host is considered online by at least one node.
 func isOnline(host portalHost) bool {
 	for _, interactions := range host.Interactions {
-		history := interactions.ScanHistory
+		// The scans that failed on the side of the node are skipped.
+		var history []portalScan
+		for _, scan := range interactions.ScanHistory {
+			if scan.Failure.HostFault() {
+				history = append(history, scan)
+				if len(history) == 2 {
+					break
+				}
+			}
+		}
 		if len(history) > 1 && history[0].Success && history[1].Success {
 			return true
 		}
@@ -636,26 +875,27 @@ func pricesChanged(os, ns rhpv2.HostSettings) bool {
 
 // getHost retrieves the information about a specific host.
 func (api *portalAPI) getHost(network string, pk types.PublicKey) (host portalHost, err error) {
-	api.mu.RLock()
-	hosts := api.hosts[network]
+	hosts := api.hosts.load()[network]
 	h, exists := hosts[pk]
-	api.mu.RUnlock()
-	if !exists {
+	if !exists || h.OptOut == hostdb.OptOutDelist {
 		return portalHost{}, errHostNotFound
 	}
 
-	host = *h
+	host, err = api.withHistory(network, *h)
+	if err != nil {
+		return portalHost{}, err
+	}
 	info, lastFetched, err := api.getLocation(pk, network, host.NetAddress)
 	if err != nil {
 		return portalHost{}, utils.AddContext(err, "couldn't get host location")
 	} else if host.LastIPChange.After(lastFetched) {
-		newInfo, err := external.FetchIPInfo(host.NetAddress, api.token)
+		newInfo, err := api.fetchLocation(host.NetAddress)
 		if err != nil {
 			api.log.Error("couldn't fetch host location", zap.String("host", host.NetAddress), zap.Error(err))
 		} else {
 			if (newInfo != external.IPInfo{}) {
 				info = newInfo
-				err = api.saveLocation(pk, network, info)
+				err = api.saveLocation(api.db, pk, network, info)
 				if err != nil {
 					return portalHost{}, utils.AddContext(err, "couldn't update host location")
 				}
@@ -666,71 +906,50 @@ func (api *portalAPI) getHost(network string, pk types.PublicKey) (host portalHo
 	}
 
 	host.IPInfo = info
+	host.TimeZoneHint = newTimeZoneHint(info.TimeZone, time.Now())
+	host.Traffic = totalTraffic(host.Interactions)
 	return
 }
 
-// getHosts retrieves the given number of host records.
-func (api *portalAPI) getHosts(network string, all bool, offset, limit int, query, country string, sortBy sortType, asc bool) (hosts []portalHost, more bool, total int, err error) {
+// getHosts retrieves the given number of host records. If after is not
+// zero, the records start after the host with that rank or ID, depending
+// on the sorting type, and the offset is ignored. The pages sorted by the
+// ranks or IDs are taken from the index of the host store; the others
+// require sorting all matching hosts.
+func (api *portalAPI) getHosts(network string, all bool, offset, after, limit int, filter hostFilter, sortBy sortType, asc bool) (hosts []portalHost, more bool, total int, err error) {
 	if offset < 0 {
 		offset = 0
 	}
 
-	if country != "" {
-		rows, err := api.db.Query(`
-			SELECT public_key
-			FROM locations
-			WHERE network = ?
-			AND country = ?
-		`, network, country)
+	var locations map[types.PublicKey]hostLocation
+	if filter.needsLocations() {
+		locations, err = api.getLocations(network)
 		if err != nil {
-			return nil, false, 0, utils.AddContext(err, "couldn't query public keys")
+			return nil, false, 0, err
 		}
+	}
 
-		var keys []types.PublicKey
-		for rows.Next() {
-			pk := make([]byte, 32)
-			if err := rows.Scan(&pk); err != nil {
-				rows.Close()
-				return nil, false, 0, utils.AddContext(err, "couldn't decode public key")
-			}
-			keys = append(keys, types.PublicKey(pk))
+	keep := func(host *portalHost) bool {
+		if host.OptOut == hostdb.OptOutDelist {
+			return false
 		}
-		rows.Close()
+		return filter.matches(host, locations[host.PublicKey]) && (all || (isOnline(*host) && !host.Quarantined))
+	}
 
-		api.mu.RLock()
-		allHosts := api.hosts[network]
-		for _, key := range keys {
-			host := allHosts[key]
-			if (all || isOnline(*host)) && (query == "" || strings.Contains(host.NetAddress, query)) {
-				hosts = append(hosts, *host)
-			}
-		}
-		api.mu.RUnlock()
-	} else {
-		api.mu.RLock()
-		allHosts := api.hosts[network]
-		for _, host := range allHosts {
-			if (all || isOnline(*host)) && (query == "" || strings.Contains(host.NetAddress, query)) {
-				hosts = append(hosts, *host)
-			}
+	if sortBy == sortByRank || sortBy == sortByID {
+		hosts, more, total = pageHosts(api.hosts.sorted(network, sortBy), keep, offset, after, limit, sortBy, asc)
+		return api.completeHosts(network, hosts, more, total)
+	}
+
+	for _, host := range api.hosts.load()[network] {
+		if keep(host) {
+			hosts = append(hosts, *host)
 		}
-		api.mu.RUnlock()
 	}
+	total = len(hosts)
 
 	slices.SortStableFunc(hosts, func(a, b portalHost) int {
 		switch sortBy {
-		case sortByID:
-			if asc {
-				return a.ID - b.ID
-			} else {
-				return b.ID - a.ID
-			}
-		case sortByRank:
-			if asc {
-				return a.Rank - b.Rank
-			} else {
-				return b.Rank - a.Rank
-			}
 		case sortByTotalStorage:
 			if a.Settings.TotalStorage == b.Settings.TotalStorage {
 				return a.ID - b.ID
@@ -816,6 +1035,18 @@ func (api *portalAPI) getHosts(network string, all bool, offset, limit int, quer
 					return 1
 				}
 			}
+		case sortByUptimeScore:
+			return compareHosts(a, b, a.Score.UptimeScore, b.Score.UptimeScore, asc)
+		case sortByLatencyScore:
+			return compareHosts(a, b, a.Score.LatencyScore, b.Score.LatencyScore, asc)
+		case sortByBenchmarksScore:
+			return compareHosts(a, b, a.Score.BenchmarksScore, b.Score.BenchmarksScore, asc)
+		case sortByAgeScore:
+			return compareHosts(a, b, a.Score.AgeScore, b.Score.AgeScore, asc)
+		case sortByCollateralScore:
+			return compareHosts(a, b, a.Score.CollateralScore, b.Score.CollateralScore, asc)
+		case sortByFirstSeen:
+			return compareHosts(a, b, a.FirstSeen.UnixNano(), b.FirstSeen.UnixNano(), asc)
 		}
 		return 0
 	})
@@ -829,22 +1060,31 @@ func (api *portalAPI) getHosts(network string, all bool, offset, limit int, quer
 	if offset+limit > len(hosts) {
 		limit = len(hosts) - offset
 	}
-	total = len(hosts)
-	more = offset+limit < total
+	more = offset+limit < len(hosts)
 	hosts = hosts[offset : offset+limit]
+	return api.completeHosts(network, hosts, more, total)
+}
 
+// completeHosts adds the histories, the locations, and the traffic to the
+// page of hosts.
+func (api *portalAPI) completeHosts(network string, hosts []portalHost, more bool, total int) ([]portalHost, bool, int, error) {
 	for i := range hosts {
+		var err error
+		hosts[i], err = api.withHistory(network, hosts[i])
+		if err != nil {
+			return nil, false, 0, err
+		}
 		info, lastFetched, err := api.getLocation(hosts[i].PublicKey, network, hosts[i].NetAddress)
 		if err != nil {
 			return nil, false, 0, utils.AddContext(err, "couldn't get host location")
 		} else if hosts[i].LastIPChange.After(lastFetched) {
-			newInfo, err := external.FetchIPInfo(hosts[i].NetAddress, api.token)
+			newInfo, err := api.fetchLocation(hosts[i].NetAddress)
 			if err != nil {
 				api.log.Error("couldn't fetch host location", zap.String("host", hosts[i].NetAddress), zap.Error(err))
 			} else {
 				if (newInfo != external.IPInfo{}) {
 					info = newInfo
-					err = api.saveLocation(hosts[i].PublicKey, network, info)
+					err = api.saveLocation(api.db, hosts[i].PublicKey, network, info)
 					if err != nil {
 						return nil, false, 0, utils.AddContext(err, "couldn't update host location")
 					}
@@ -855,11 +1095,98 @@ func (api *portalAPI) getHosts(network string, all bool, offset, limit int, quer
 		}
 
 		hosts[i].IPInfo = info
+		hosts[i].TimeZoneHint = newTimeZoneHint(info.TimeZone, time.Now())
+		hosts[i].Traffic = totalTraffic(hosts[i].Interactions)
+	}
+
+	return hosts, more, total, nil
+}
+
+// compareHosts compares two hosts by the given keys in the given order.
+// The hosts with equal keys are ordered by their IDs.
+func compareHosts[T cmp.Ordered](a, b portalHost, keyA, keyB T, asc bool) int {
+	if keyA == keyB {
+		return a.ID - b.ID
+	}
+	if asc {
+		return cmp.Compare(keyA, keyB)
+	}
+	return cmp.Compare(keyB, keyA)
+}
+
+// pageHosts returns a page of the hosts that satisfy keep from a list
+// sorted by the ranks or IDs in the ascending order. If after is not
+// zero, the page starts past the host with that rank or ID, which is
+// found by a binary search, and the offset is ignored.
+func pageHosts(sorted []*portalHost, keep func(*portalHost) bool, offset, after, limit int, sortBy sortType, asc bool) (hosts []portalHost, more bool, total int) {
+	for _, host := range sorted {
+		if keep(host) {
+			total++
+		}
+	}
+	if limit < 0 {
+		limit = total
+	}
+
+	key := func(i int) int {
+		if sortBy == sortByRank {
+			return sorted[i].Rank
+		}
+		return sorted[i].ID
+	}
+	n := len(sorted)
+	at := func(i int) *portalHost {
+		if asc {
+			return sorted[i]
+		}
+		return sorted[n-1-i]
+	}
+
+	var start int
+	if after > 0 {
+		offset = 0
+		if asc {
+			start = sort.Search(n, func(i int) bool { return key(i) > after })
+		} else {
+			start = n - sort.Search(n, func(i int) bool { return key(i) >= after })
+		}
 	}
 
+	for i := start; i < n; i++ {
+		host := at(i)
+		if !keep(host) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(hosts) == limit {
+			more = true
+			break
+		}
+		hosts = append(hosts, *host)
+	}
 	return
 }
 
+// nextCursor returns the cursor pointing past the last host of the page,
+// or zero if there are no more hosts or the sorting doesn't support the
+// cursors.
+func nextCursor(hosts []portalHost, more bool, sortBy sortType) int {
+	if !more || len(hosts) == 0 {
+		return 0
+	}
+	switch sortBy {
+	case sortByRank:
+		return hosts[len(hosts)-1].Rank
+	case sortByID:
+		return hosts[len(hosts)-1].ID
+	default:
+		return 0
+	}
+}
+
 // getLocation loads the host's geolocation from the database.
 // If there is none present, the function tries to fetch it using the API.
 func (api *portalAPI) getLocation(pk types.PublicKey, network, addr string) (info external.IPInfo, lastFetched time.Time, err error) {
@@ -875,6 +1202,7 @@ func (api *portalAPI) getLocation(pk types.PublicKey, network, addr string) (inf
 			isp,
 			zip,
 			time_zone,
+			asn,
 			fetched_at
 		FROM locations
 		WHERE public_key = ?
@@ -889,28 +1217,39 @@ func (api *portalAPI) getLocation(pk types.PublicKey, network, addr string) (inf
 		&info.ISP,
 		&info.ZIP,
 		&info.TimeZone,
+		&info.ASN,
 		&lf,
 	)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return external.IPInfo{}, time.Time{}, utils.AddContext(err, "couldn't query locations")
 	}
 	if err != nil {
-		info, err = external.FetchIPInfo(addr, api.token)
+		info, err = api.fetchLocation(addr)
 		if err != nil {
 			return external.IPInfo{}, time.Time{}, utils.AddContext(err, "couldn't fetch location")
 		}
-		if err := api.saveLocation(pk, network, info); err != nil {
+		if err := api.saveLocation(api.db, pk, network, info); err != nil {
 			return external.IPInfo{}, time.Time{}, utils.AddContext(err, "couldn't save location")
 		}
 		return info, time.Now(), nil
 	}
+	if info.ASN == "" {
+		info.ASN = external.ParseASN(info.ISP)
+	}
 	lastFetched = time.Unix(lf, 0)
 	return
 }
 
-// saveLocation saves the host's geolocation in the database.
-func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info external.IPInfo) error {
-	_, err := api.db.Exec(`
+// execer is implemented by both the database and a transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// saveLocation saves the host's geolocation in the database. A location
+// of a new host must be saved within the same transaction as the host,
+// because it references the host record.
+func (api *portalAPI) saveLocation(e execer, pk types.PublicKey, network string, info external.IPInfo) error {
+	_, err := e.Exec(`
 		INSERT INTO locations (
 			network,
 			public_key,
@@ -923,10 +1262,11 @@ func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info exte
 			isp,
 			zip,
 			time_zone,
+			asn,
 			fetched_at
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
-		ON DUPLICATE KEY UPDATE
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (network, public_key) UPDATE
 			ip = new.ip,
 			host_name = new.host_name,
 			city = new.city,
@@ -936,6 +1276,7 @@ func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info exte
 			isp = new.isp,
 			zip = new.zip,
 			time_zone = new.time_zone,
+			asn = new.asn,
 			fetched_at = new.fetched_at
 	`,
 		network,
@@ -949,6 +1290,7 @@ func (api *portalAPI) saveLocation(pk types.PublicKey, network string, info exte
 		info.ISP,
 		info.ZIP,
 		info.TimeZone,
+		info.ASN,
 		time.Now().Unix(),
 	)
 
@@ -969,17 +1311,15 @@ func (api *portalAPI) getScans(network, node string, pk types.PublicKey, all boo
 		limit = math.MaxInt64
 	}
 
-	api.mu.RLock()
-	hosts := api.hosts[network]
+	hosts := api.hosts.load()[network]
 	_, ok := hosts[pk]
-	api.mu.RUnlock()
 
 	if !ok {
 		return nil, errHostNotFound
 	}
 
 	rows, err := api.db.Query(`
-		SELECT node, ran_at, success, latency, error
+		SELECT node, ran_at, success, latency, error, failure, clock_skew, valid_until, invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency, dial_time, handshake_time, settings_time
 		FROM scans
 		WHERE network = ?
 		AND (? OR node = ?)
@@ -1005,21 +1345,29 @@ func (api *portalAPI) getScans(network, node string, pk types.PublicKey, all boo
 	defer rows.Close()
 
 	for rows.Next() {
-		var ra int64
-		var success bool
-		var latency float64
+		var ra, skew, vu int64
+		var success, invalidSig bool
+		var latency, latency4, latency6, dial, handshake, rpc float64
+		var ipv4, ipv6, failure uint8
 		var n, msg string
-		if err := rows.Scan(&n, &ra, &success, &latency, &msg); err != nil {
+		if err := rows.Scan(&n, &ra, &success, &latency, &msg, &failure, &skew, &vu, &invalidSig, &ipv4, &latency4, &ipv6, &latency6, &dial, &handshake, &rpc); err != nil {
 			return nil, utils.AddContext(err, "couldn't decode scan history")
 		}
 		scan := scanHistory{
-			Timestamp: time.Unix(ra, 0),
-			Success:   success,
-			Latency:   time.Duration(latency) * time.Millisecond,
-			Error:     msg,
-			PublicKey: pk,
-			Network:   network,
-			Node:      n,
+			Timestamp:  time.Unix(ra, 0),
+			Success:    success,
+			Latency:    time.Duration(latency) * time.Millisecond,
+			Error:      msg,
+			Failure:    hostdb.FailureClass(failure),
+			ClockSkew:  skew,
+			ValidUntil: time.Unix(vu, 0),
+			InvalidSig: invalidSig,
+			IPv4:       addressScan(ipv4, latency4),
+			IPv6:       addressScan(ipv6, latency6),
+			Timings:    scanTimings(dial, handshake, rpc),
+			PublicKey:  pk,
+			Network:    network,
+			Node:       n,
 		}
 		scans = append(scans, scan)
 	}
@@ -1028,7 +1376,8 @@ func (api *portalAPI) getScans(network, node string, pk types.PublicKey, all boo
 }
 
 // getBenchmarks returns the benchmark history according to the criteria provided.
-func (api *portalAPI) getBenchmarks(network, node string, pk types.PublicKey, all bool, from, to time.Time, limit int64) (benchmarks []hostdb.BenchmarkHistory, err error) {
+// If no nodes are given, the benchmarks of all nodes are returned.
+func (api *portalAPI) getBenchmarks(network string, nodes []string, pk types.PublicKey, all bool, from, to time.Time, limit int64) (benchmarks []hostdb.BenchmarkHistory, err error) {
 	f := int64(0)
 	t := time.Now().Unix()
 	if from.Unix() != (time.Time{}).Unix() {
@@ -1041,36 +1390,35 @@ func (api *portalAPI) getBenchmarks(network, node string, pk types.PublicKey, al
 		limit = math.MaxInt64
 	}
 
-	api.mu.RLock()
-	hosts := api.hosts[network]
+	hosts := api.hosts.load()[network]
 	_, ok := hosts[pk]
-	api.mu.RUnlock()
 
 	if !ok {
 		return nil, errHostNotFound
 	}
 
+	nodeFilter := "TRUE"
+	args := []any{network}
+	if len(nodes) > 0 {
+		nodeFilter = "node IN (?" + strings.Repeat(", ?", len(nodes)-1) + ")"
+		for _, n := range nodes {
+			args = append(args, n)
+		}
+	}
+	args = append(args, pk[:], f, t, all, limit)
+
 	rows, err := api.db.Query(`
-		SELECT node, ran_at, success, upload_speed, download_speed, ttfb, error
+		SELECT node, ran_at, success, upload_speed, download_speed, ttfb, uploaded, downloaded, error, failure
 		FROM benchmarks
 		WHERE network = ?
-		AND (? OR node = ?)
+		AND `+nodeFilter+`
 		AND public_key = ?
 		AND ran_at >= ?
 		AND ran_at <= ?
 		AND (? OR success = TRUE)
 		ORDER BY ran_at DESC
 		LIMIT ?
-	`,
-		network,
-		node == "global",
-		node,
-		pk[:],
-		f,
-		t,
-		all,
-		limit,
-	)
+	`, args...)
 	if err != nil {
 		return nil, utils.AddContext(err, "couldn't query benchmark history")
 	}
@@ -1080,8 +1428,10 @@ func (api *portalAPI) getBenchmarks(network, node string, pk types.PublicKey, al
 		var ra int64
 		var success bool
 		var ul, dl, ttfb float64
+		var uploaded, downloaded uint64
+		var failure uint8
 		var n, msg string
-		if err := rows.Scan(&n, &ra, &success, &ul, &dl, &ttfb, &msg); err != nil {
+		if err := rows.Scan(&n, &ra, &success, &ul, &dl, &ttfb, &uploaded, &downloaded, &msg, &failure); err != nil {
 			return nil, utils.AddContext(err, "couldn't query benchmark history")
 		}
 		benchmark := hostdb.BenchmarkHistory{
@@ -1091,7 +1441,10 @@ func (api *portalAPI) getBenchmarks(network, node string, pk types.PublicKey, al
 				UploadSpeed:   ul,
 				DownloadSpeed: dl,
 				TTFB:          time.Duration(ttfb) * time.Millisecond,
+				Uploaded:      uploaded,
+				Downloaded:    downloaded,
 				Error:         msg,
+				Failure:       hostdb.FailureClass(failure),
 			},
 			PublicKey: pk,
 			Network:   network,
@@ -1103,7 +1456,8 @@ func (api *portalAPI) getBenchmarks(network, node string, pk types.PublicKey, al
 	return
 }
 
-// load loads the online hosts map from the database.
+// load loads the hosts from the database and publishes them once they
+// are complete.
 func (api *portalAPI) load() error {
 	hostStmt, err := api.db.Prepare(`
 		SELECT
@@ -1126,9 +1480,11 @@ func (api *portalAPI) load() error {
 			latency_score,
 			benchmarks_score,
 			contracts_score,
+			duration_score,
 			total_score,
 			settings,
-			price_table
+			price_table,
+			quarantined
 		FROM hosts
 	`)
 	if err != nil {
@@ -1141,14 +1497,15 @@ func (api *portalAPI) load() error {
 		return utils.AddContext(err, "couldn't query hosts")
 	}
 
+	hosts := newHostSet()
 	for rows.Next() {
 		var id int
 		var network, netaddress, ipNets string
 		pk := make([]byte, 32)
 		var fs, lc int64
 		var ks uint64
-		var blocked bool
-		var ps, ss, cs, is, us, as, vs, ls, bs, cons, ts float64
+		var blocked, quarantined bool
+		var ps, ss, cs, is, us, as, vs, ls, bs, cons, durs, ts float64
 		var settings, pt []byte
 		if err := rows.Scan(
 			&id,
@@ -1170,9 +1527,11 @@ func (api *portalAPI) load() error {
 			&ls,
 			&bs,
 			&cons,
+			&durs,
 			&ts,
 			&settings,
 			&pt,
+			&quarantined,
 		); err != nil {
 			rows.Close()
 			return utils.AddContext(err, "couldn't decode host data")
@@ -1183,6 +1542,7 @@ func (api *portalAPI) load() error {
 			FirstSeen:    time.Unix(fs, 0),
 			KnownSince:   ks,
 			Blocked:      blocked,
+			Quarantined:  quarantined,
 			NetAddress:   netaddress,
 			IPNets:       strings.Split(ipNets, ";"),
 			LastIPChange: time.Unix(lc, 0),
@@ -1197,6 +1557,7 @@ func (api *portalAPI) load() error {
 				LatencyScore:      ls,
 				BenchmarksScore:   bs,
 				ContractsScore:    cons,
+				DurationScore:     durs,
 				TotalScore:        ts,
 			},
 			Interactions: make(map[string]nodeInteractions),
@@ -1218,70 +1579,34 @@ func (api *portalAPI) load() error {
 			}
 		}
 
-		api.hosts[network][host.PublicKey] = host
+		hosts[network][host.PublicKey] = host
 	}
 	rows.Close()
 
-	var hosts, hostsZen []portalHost
-	for _, host := range api.hosts["mainnet"] {
-		hosts = append(hosts, *host)
-	}
-	for _, host := range api.hosts["zen"] {
-		hostsZen = append(hostsZen, *host)
-	}
-	slices.SortStableFunc(hosts, func(a, b portalHost) int {
-		if a.Score.TotalScore == b.Score.TotalScore {
-			aIsOnline, bIsOnline := isOnline(a), isOnline(b)
-			if aIsOnline && !bIsOnline {
-				return -1
-			}
-			if !aIsOnline && bIsOnline {
-				return 1
-			}
-			return a.ID - b.ID
-		}
-		if a.Score.TotalScore < b.Score.TotalScore {
-			return 1
-		} else {
-			return -1
-		}
-	})
-	slices.SortStableFunc(hostsZen, func(a, b portalHost) int {
-		if a.Score.TotalScore == b.Score.TotalScore {
-			aIsOnline, bIsOnline := isOnline(a), isOnline(b)
-			if aIsOnline && !bIsOnline {
-				return -1
-			}
-			if !aIsOnline && bIsOnline {
-				return 1
-			}
-			return a.ID - b.ID
-		}
-		if a.Score.TotalScore < b.Score.TotalScore {
-			return 1
-		} else {
-			return -1
-		}
-	})
-	for i := range hosts {
-		api.hosts["mainnet"][hosts[i].PublicKey].Rank = i + 1
-	}
-	for i := range hostsZen {
-		api.hosts["zen"][hostsZen[i].PublicKey].Rank = i + 1
-	}
+	api.rankHosts(hosts)
 
-	if err := api.loadInteractions("mainnet"); err != nil {
+	if err := api.loadInteractions(hosts, "mainnet"); err != nil {
 		return utils.AddContext(err, "couldn't load mainnet interactions")
 	}
 
-	if err := api.loadInteractions("zen"); err != nil {
+	if err := api.loadInteractions(hosts, "zen"); err != nil {
 		return utils.AddContext(err, "couldn't load zen interactions")
 	}
 
+	// The tags depending on the interactions are assigned once these
+	// are loaded.
+	if err := api.loadPriceSpikes(hosts); err != nil {
+		return utils.AddContext(err, "couldn't load price spikes")
+	}
+	api.tagHosts(hosts)
+
+	api.hosts.replace(hosts)
 	return nil
 }
 
-func (api *portalAPI) loadInteractions(network string) error {
+// loadInteractions loads the interactions of the hosts of the network,
+// which haven't been published yet.
+func (api *portalAPI) loadInteractions(hosts hostSet, network string) error {
 	intStmt, err := api.db.Prepare(`
 		SELECT
 			node,
@@ -1299,12 +1624,20 @@ func (api *portalAPI) loadInteractions(network string) error {
 			latency_score,
 			benchmarks_score,
 			contracts_score,
+			duration_score,
 			total_score,
 			historic_successful_interactions,
 			historic_failed_interactions,
 			recent_successful_interactions,
 			recent_failed_interactions,
-			last_update
+			last_update,
+			formation_successes,
+			duration_violations,
+			expiry_successes,
+			expiry_failures,
+			ingress,
+			egress,
+			traffic_since
 		FROM interactions
 		WHERE network = ?
 		AND public_key = ?
@@ -1314,8 +1647,7 @@ func (api *portalAPI) loadInteractions(network string) error {
 	}
 	defer intStmt.Close()
 
-	hosts := api.hosts[network]
-	for _, host := range hosts {
+	for _, host := range hosts[network] {
 		rows, err := intStmt.Query(network, host.PublicKey[:])
 		if err != nil {
 			return utils.AddContext(err, "couldn't query interactions")
@@ -1325,9 +1657,12 @@ func (api *portalAPI) loadInteractions(network string) error {
 			var node string
 			var lu uint64
 			var ut, dt, lastSeen int64
-			var ps, ss, cs, is, us, as, vs, ls, bs, cons, ts float64
+			var ps, ss, cs, is, us, as, vs, ls, bs, cons, durs, ts float64
 			var hsi, hfi, rsi, rfi float64
 			var ah int
+			var cc hostdb.ContractCompliance
+			var ingress, egress uint64
+			var since int64
 			if err := rows.Scan(
 				&node,
 				&ut,
@@ -1344,16 +1679,25 @@ func (api *portalAPI) loadInteractions(network string) error {
 				&ls,
 				&bs,
 				&cons,
+				&durs,
 				&ts,
 				&hsi,
 				&hfi,
 				&rsi,
 				&rfi,
 				&lu,
+				&cc.FormationSuccesses,
+				&cc.DurationViolations,
+				&cc.ExpirySuccesses,
+				&cc.ExpiryFailures,
+				&ingress,
+				&egress,
+				&since,
 			); err != nil {
 				rows.Close()
 				return utils.AddContext(err, "couldn't decode interactions")
 			}
+			cc.UpdateViolation()
 			interactions := nodeInteractions{
 				Uptime:      time.Duration(ut) * time.Second,
 				Downtime:    time.Duration(dt) * time.Second,
@@ -1370,6 +1714,7 @@ func (api *portalAPI) loadInteractions(network string) error {
 					LatencyScore:      ls,
 					BenchmarksScore:   bs,
 					ContractsScore:    cons,
+					DurationScore:     durs,
 					TotalScore:        ts,
 				},
 				HostInteractions: hostdb.HostInteractions{
@@ -1379,118 +1724,383 @@ func (api *portalAPI) loadInteractions(network string) error {
 					RecentFailures:    rfi,
 					LastUpdate:        lu,
 				},
+				Compliance: cc,
+				Traffic: benchmarkTraffic{
+					Ingress: ingress,
+					Egress:  egress,
+				},
+				// The histories are loaded on demand.
+				evicted: true,
+			}
+			if since > 0 {
+				interactions.Traffic.Since = time.Unix(since, 0)
 			}
 			host.Interactions[node] = interactions
 		}
 		rows.Close()
 	}
 
-	return utils.ComposeErrors(api.loadScans(network), api.loadBenchmarks(network))
+	return utils.ComposeErrors(api.loadRecentScans(hosts, network), api.loadRecentBenchmarks(hosts, network))
+}
+
+// scanSuccessQuery retrieves the outcome of the last scan of a host.
+const scanSuccessQuery = `
+	SELECT success
+	FROM scans
+	WHERE network = ?
+	AND node = ?
+	AND public_key = ?
+	ORDER BY ran_at DESC
+	LIMIT 1
+`
+
+// priceChangeCountQuery counts the price changes of a host. The network
+// is needed for the query to use the index.
+const priceChangeCountQuery = `
+	SELECT COUNT(*)
+	FROM price_changes
+	WHERE network = ?
+	AND public_key = ?
+`
+
+// priceChangesQuery retrieves the price changes of a host within a time
+// range, the newest first.
+const priceChangesQuery = `
+	SELECT
+		changed_at,
+		remaining_storage,
+		total_storage,
+		collateral,
+		storage_price,
+		upload_price,
+		download_price
+	FROM price_changes
+	WHERE network = ?
+	AND public_key = ?
+	AND changed_at >= ?
+	AND changed_at <= ?
+	ORDER BY changed_at DESC
+	LIMIT ?
+`
+
+// scanHistoryQuery retrieves the scan history of a host.
+const scanHistoryQuery = `
+	SELECT
+		ran_at,
+		success,
+		latency,
+		error,
+		failure,
+		clock_skew,
+		valid_until,
+		invalid_signature,
+		ipv4,
+		ipv4_latency,
+		ipv6,
+		ipv6_latency,
+		dial_time,
+		handshake_time,
+		settings_time
+	FROM scans
+	WHERE network = ?
+	AND node = ?
+	AND public_key = ?
+	ORDER BY ran_at DESC
+	LIMIT 48
+`
+
+// benchmarkHistoryQuery retrieves the benchmark history of a host.
+const benchmarkHistoryQuery = `
+	SELECT
+		ran_at,
+		success,
+		upload_speed,
+		download_speed,
+		ttfb,
+		uploaded,
+		downloaded,
+		error,
+		failure
+	FROM benchmarks
+	WHERE network = ?
+	AND node = ?
+	AND public_key = ?
+	AND failure <> ?
+	ORDER BY ran_at DESC
+	LIMIT 12
+`
+
+// decodeScans reads the scan history from the query result.
+func decodeScans(rows *sql.Rows) (scans []portalScan, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var ra, skew, vu int64
+		var success, invalidSig bool
+		var latency, latency4, latency6, dial, handshake, rpc float64
+		var ipv4, ipv6, failure uint8
+		var msg string
+		if err := rows.Scan(&ra, &success, &latency, &msg, &failure, &skew, &vu, &invalidSig, &ipv4, &latency4, &ipv6, &latency6, &dial, &handshake, &rpc); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode scan history")
+		}
+		scans = append(scans, portalScan{
+			Timestamp:  time.Unix(ra, 0),
+			Success:    success,
+			Latency:    time.Duration(latency) * time.Millisecond,
+			Error:      msg,
+			Failure:    hostdb.FailureClass(failure),
+			ClockSkew:  skew,
+			ValidUntil: time.Unix(vu, 0),
+			InvalidSig: invalidSig,
+			IPv4:       addressScan(ipv4, latency4),
+			IPv6:       addressScan(ipv6, latency6),
+			Timings:    scanTimings(dial, handshake, rpc),
+		})
+	}
+	return scans, nil
+}
+
+// decodeBenchmarks reads the benchmark history from the query result.
+func decodeBenchmarks(rows *sql.Rows) (benchmarks []hostdb.HostBenchmark, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var ra int64
+		var success bool
+		var ul, dl, ttfb float64
+		var uploaded, downloaded uint64
+		var failure uint8
+		var msg string
+		if err := rows.Scan(&ra, &success, &ul, &dl, &ttfb, &uploaded, &downloaded, &msg, &failure); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode benchmarks")
+		}
+		benchmarks = append(benchmarks, hostdb.HostBenchmark{
+			Timestamp:     time.Unix(ra, 0),
+			Success:       success,
+			UploadSpeed:   ul,
+			DownloadSpeed: dl,
+			TTFB:          time.Duration(ttfb) * time.Millisecond,
+			Uploaded:      uploaded,
+			Downloaded:    downloaded,
+			Error:         msg,
+			Failure:       hostdb.FailureClass(failure),
+		})
+	}
+	return benchmarks, nil
 }
 
-func (api *portalAPI) loadScans(network string) error {
-	scanStmt, err := api.db.Prepare(`
+// recentScansQuery retrieves the scan histories of all hosts at once,
+// ordered so that the history of each host comes in one piece.
+const recentScansQuery = `
+	SELECT
+		node,
+		public_key,
+		ran_at,
+		success,
+		latency,
+		error,
+		failure,
+		clock_skew,
+		valid_until,
+		invalid_signature,
+		ipv4,
+		ipv4_latency,
+		ipv6,
+		ipv6_latency,
+		dial_time,
+		handshake_time,
+		settings_time
+	FROM (
 		SELECT
+			node,
+			public_key,
 			ran_at,
 			success,
 			latency,
-			error
+			error,
+			failure,
+			clock_skew,
+			valid_until,
+			invalid_signature,
+			ipv4,
+			ipv4_latency,
+			ipv6,
+			ipv6_latency,
+			dial_time,
+			handshake_time,
+			settings_time,
+			ROW_NUMBER() OVER (PARTITION BY node, public_key ORDER BY ran_at DESC) AS row_num
 		FROM scans
 		WHERE network = ?
-		AND node = ?
-		AND public_key = ?
-		ORDER BY ran_at DESC
-		LIMIT 48
-	`)
+	) AS recent
+	WHERE row_num <= 48
+	ORDER BY node, public_key, ran_at DESC
+`
+
+// recentBenchmarksQuery retrieves the benchmark histories of all hosts
+// at once, ordered so that the history of each host comes in one piece.
+const recentBenchmarksQuery = `
+	SELECT
+		node,
+		public_key,
+		ran_at,
+		success,
+		upload_speed,
+		download_speed,
+		ttfb,
+		uploaded,
+		downloaded,
+		error,
+		failure
+	FROM (
+		SELECT
+			node,
+			public_key,
+			ran_at,
+			success,
+			upload_speed,
+			download_speed,
+			ttfb,
+			uploaded,
+			downloaded,
+			error,
+			failure,
+			ROW_NUMBER() OVER (PARTITION BY node, public_key ORDER BY ran_at DESC) AS row_num
+		FROM benchmarks
+		WHERE network = ?
+		AND failure <> ?
+	) AS recent
+	WHERE row_num <= 12
+	ORDER BY node, public_key, ran_at DESC
+`
+
+// loadRecentScans reads the scan histories and keeps only what is needed
+// until the histories are loaded: the most recent scans, the flags, and
+// the average latency.
+func (api *portalAPI) loadRecentScans(hosts hostSet, network string) error {
+	rows, err := api.db.Query(recentScansQuery, network)
 	if err != nil {
-		return utils.AddContext(err, "couldn't prepare scan statement")
+		return utils.AddContext(err, "couldn't query scan history")
 	}
-	defer scanStmt.Close()
+	defer rows.Close()
 
-	hosts := api.hosts[network]
-	for _, host := range hosts {
-		for node, interactions := range host.Interactions {
-			rows, err := scanStmt.Query(network, node, host.PublicKey[:])
-			if err != nil {
-				return utils.AddContext(err, "couldn't query scan history")
-			}
+	var node string
+	var pk types.PublicKey
+	var scans []portalScan
+	flush := func() {
+		host, ok := hosts[network][pk]
+		if !ok {
+			return
+		}
+		interactions, ok := host.Interactions[node]
+		if !ok {
+			return
+		}
+		interactions.HighSkew = highSkew(scans)
+		interactions.InvalidSig = invalidSignature(scans)
+		interactions.Reachability = latestReachability(scans)
+		interactions.speeds.latency, _, _ = getSpeeds(nodeInteractions{ScanHistory: scans})
+		interactions.ScanHistory = slices.Clone(scans[:min(len(scans), keptScans)])
+		host.Interactions[node] = interactions
+		host.Reachability = hostReachability(host)
+	}
 
-			for rows.Next() {
-				var ra int64
-				var success bool
-				var latency float64
-				var msg string
-				if err := rows.Scan(&ra, &success, &latency, &msg); err != nil {
-					rows.Close()
-					return utils.AddContext(err, "couldn't decode scan history")
-				}
-				scan := portalScan{
-					Timestamp: time.Unix(ra, 0),
-					Success:   success,
-					Latency:   time.Duration(latency) * time.Millisecond,
-					Error:     msg,
-				}
-				interactions.ScanHistory = append(interactions.ScanHistory, scan)
+	for rows.Next() {
+		var n string
+		key := make([]byte, 32)
+		var ra, skew, vu int64
+		var success, invalidSig bool
+		var latency, latency4, latency6, dial, handshake, rpc float64
+		var ipv4, ipv6, failure uint8
+		var msg string
+		if err := rows.Scan(&n, &key, &ra, &success, &latency, &msg, &failure, &skew, &vu, &invalidSig, &ipv4, &latency4, &ipv6, &latency6, &dial, &handshake, &rpc); err != nil {
+			return utils.AddContext(err, "couldn't decode scan history")
+		}
+		if n != node || types.PublicKey(key) != pk {
+			if len(scans) > 0 {
+				flush()
 			}
-			rows.Close()
-			host.Interactions[node] = interactions
-		}
+			node, pk = n, types.PublicKey(key)
+			scans = scans[:0]
+		}
+		scans = append(scans, portalScan{
+			Timestamp:  time.Unix(ra, 0),
+			Success:    success,
+			Latency:    time.Duration(latency) * time.Millisecond,
+			Error:      msg,
+			Failure:    hostdb.FailureClass(failure),
+			ClockSkew:  skew,
+			ValidUntil: time.Unix(vu, 0),
+			InvalidSig: invalidSig,
+			IPv4:       addressScan(ipv4, latency4),
+			IPv6:       addressScan(ipv6, latency6),
+			Timings:    scanTimings(dial, handshake, rpc),
+		})
+	}
+	if len(scans) > 0 {
+		flush()
 	}
 
 	return nil
 }
 
-func (api *portalAPI) loadBenchmarks(network string) error {
-	benchmarkStmt, err := api.db.Prepare(`
-		SELECT
-			ran_at,
-			success,
-			upload_speed,
-			download_speed,
-			ttfb,
-			error
-		FROM benchmarks
-		WHERE network = ?
-		AND node = ?
-		AND public_key = ?
-		ORDER BY ran_at DESC
-		LIMIT 12
-	`)
+// loadRecentBenchmarks reads the benchmark histories and keeps only the
+// average speeds until the histories are loaded.
+func (api *portalAPI) loadRecentBenchmarks(hosts hostSet, network string) error {
+	rows, err := api.db.Query(recentBenchmarksQuery, network, hostdb.FailureSkipped)
 	if err != nil {
-		return utils.AddContext(err, "couldn't prepare benchmark statement")
+		return utils.AddContext(err, "couldn't query benchmarks")
 	}
-	defer benchmarkStmt.Close()
+	defer rows.Close()
 
-	hosts := api.hosts[network]
-	for _, host := range hosts {
-		for node, interactions := range host.Interactions {
-			rows, err := benchmarkStmt.Query(network, node, host.PublicKey[:])
-			if err != nil {
-				return utils.AddContext(err, "couldn't query benchmarks")
-			}
+	var node string
+	var pk types.PublicKey
+	var benchmarks []hostdb.HostBenchmark
+	flush := func() {
+		host, ok := hosts[network][pk]
+		if !ok {
+			return
+		}
+		interactions, ok := host.Interactions[node]
+		if !ok {
+			return
+		}
+		_, interactions.speeds.upload, interactions.speeds.download = getSpeeds(nodeInteractions{BenchmarkHistory: benchmarks})
+		interactions.speeds.ttfb = getTTFB(nodeInteractions{BenchmarkHistory: benchmarks})
+		host.Interactions[node] = interactions
+	}
 
-			for rows.Next() {
-				var ra int64
-				var success bool
-				var ul, dl, ttfb float64
-				var msg string
-				if err := rows.Scan(&ra, &success, &ul, &dl, &ttfb, &msg); err != nil {
-					rows.Close()
-					return utils.AddContext(err, "couldn't decode benchmarks")
-				}
-				benchmark := hostdb.HostBenchmark{
-					Timestamp:     time.Unix(ra, 0),
-					Success:       success,
-					UploadSpeed:   ul,
-					DownloadSpeed: dl,
-					TTFB:          time.Duration(ttfb) * time.Millisecond,
-					Error:         msg,
-				}
-				interactions.BenchmarkHistory = append(interactions.BenchmarkHistory, benchmark)
+	for rows.Next() {
+		var n string
+		key := make([]byte, 32)
+		var ra int64
+		var success bool
+		var ul, dl, ttfb float64
+		var uploaded, downloaded uint64
+		var failure uint8
+		var msg string
+		if err := rows.Scan(&n, &key, &ra, &success, &ul, &dl, &ttfb, &uploaded, &downloaded, &msg, &failure); err != nil {
+			return utils.AddContext(err, "couldn't decode benchmarks")
+		}
+		if n != node || types.PublicKey(key) != pk {
+			if len(benchmarks) > 0 {
+				flush()
 			}
-			rows.Close()
-			host.Interactions[node] = interactions
-		}
+			node, pk = n, types.PublicKey(key)
+			benchmarks = benchmarks[:0]
+		}
+		benchmarks = append(benchmarks, hostdb.HostBenchmark{
+			Timestamp:     time.Unix(ra, 0),
+			Success:       success,
+			UploadSpeed:   ul,
+			DownloadSpeed: dl,
+			TTFB:          time.Duration(ttfb) * time.Millisecond,
+			Uploaded:      uploaded,
+			Downloaded:    downloaded,
+			Error:         msg,
+			Failure:       hostdb.FailureClass(failure),
+		})
+	}
+	if len(benchmarks) > 0 {
+		flush()
 	}
 
 	return nil
@@ -1510,32 +2120,14 @@ func (api *portalAPI) getPriceChanges(network string, pk types.PublicKey, from,
 		limit = math.MaxInt64
 	}
 
-	api.mu.RLock()
-	hosts := api.hosts[network]
+	hosts := api.hosts.load()[network]
 	_, ok := hosts[pk]
-	api.mu.RUnlock()
 
 	if !ok {
 		return nil, errHostNotFound
 	}
 
-	rows, err := api.db.Query(`
-		SELECT
-			changed_at,
-			remaining_storage,
-			total_storage,
-			collateral,
-			storage_price,
-			upload_price,
-			download_price
-		FROM price_changes
-		WHERE network = ?
-		AND public_key = ?
-		AND changed_at >= ?
-		AND changed_at <= ?
-		ORDER BY changed_at DESC
-		LIMIT ?
-	`, network, pk[:], f, t, limit)
+	rows, err := api.db.Query(priceChangesQuery, network, pk[:], f, t, limit)
 	if err != nil {
 		return nil, utils.AddContext(err, "couldn't query price changes")
 	}
@@ -1584,18 +2176,17 @@ func (api *portalAPI) getPriceChanges(network string, pk types.PublicKey, from,
 // calculateAverages calculates the averages for the given network.
 func (api *portalAPI) calculateAverages() {
 	var hosts, hostsZen []portalHost
-	api.mu.RLock()
-	for _, host := range api.hosts["mainnet"] {
+	live := api.hosts.load()
+	for _, host := range live["mainnet"] {
 		if isOnline(*host) {
 			hosts = append(hosts, *host)
 		}
 	}
-	for _, host := range api.hosts["zen"] {
+	for _, host := range live["zen"] {
 		if isOnline(*host) {
 			hostsZen = append(hostsZen, *host)
 		}
 	}
-	api.mu.RUnlock()
 
 	slices.SortStableFunc(hosts, func(a, b portalHost) int {
 		return a.Rank - b.Rank
@@ -1608,6 +2199,45 @@ func (api *portalAPI) calculateAverages() {
 	api.averages["mainnet"] = calculateTiers(hosts)
 	api.averages["zen"] = calculateTiers(hostsZen)
 	api.mu.Unlock()
+
+	rescore := anchors.update("mainnet", hosts)
+	rescoreZen := anchors.update("zen", hostsZen)
+	if rescore || rescoreZen {
+		api.hosts.update(func(w *hostWriter) {
+			if rescore {
+				rescorePrices(w, "mainnet")
+			}
+			if rescoreZen {
+				rescorePrices(w, "zen")
+			}
+		})
+		api.rankPending.Store(true)
+	}
+	api.blobs.invalidate()
+	api.cache.invalidate()
+}
+
+// rescorePrices recalculates the price and the duration scores of the
+// hosts of w after the price anchor has changed. Only the in-memory
+// scores are updated; the database catches up with the next update of
+// each host.
+func rescorePrices(w *hostWriter, network string) {
+	budget := anchors.get(network)
+	duration := anchors.getDuration(network)
+	for pk := range w.network(network) {
+		host, _ := w.edit(network, pk)
+		ps := priceAdjustmentScore(hostPeriodCostForScore(host.Settings, host.PriceTable), budget)
+		ds := durationScore(host.Settings, duration)
+		for node, interactions := range host.Interactions {
+			interactions.Score.PricesScore = ps
+			interactions.Score.DurationScore = ds
+			interactions.Score.TotalScore = weights.total(interactions.Score)
+			host.Interactions[node] = interactions
+		}
+		host.Score.PricesScore = ps
+		host.Score.DurationScore = ds
+		host.Score.TotalScore = weights.total(host.Score)
+	}
 }
 
 func calculateTiers(sortedHosts []portalHost) map[string]networkAverages {
@@ -1658,20 +2288,6 @@ func calculateTiers(sortedHosts []portalHost) map[string]networkAverages {
 	return result
 }
 
-// updateAverages makes periodical calculation of the network averages.
-func (api *portalAPI) updateAverages() {
-	api.calculateAverages()
-
-	for {
-		select {
-		case <-api.stopChan:
-			return
-		case <-time.After(10 * time.Minute):
-		}
-		api.calculateAverages()
-	}
-}
-
 // getCountries returns the list of countries the hosts in the given
 // network reside in.
 func (api *portalAPI) getCountries(network string, all bool) (countries []string, _ error) {
@@ -1711,9 +2327,8 @@ func (api *portalAPI) getCountries(network string, all bool) (countries []string
 	}
 	defer stmt.Close()
 
-	api.mu.RLock()
 	allCountries := make(map[string]struct{})
-	hosts := api.hosts[network]
+	hosts := api.hosts.load()[network]
 	for pk, host := range hosts {
 		if !isOnline(*host) {
 			continue
@@ -1724,7 +2339,6 @@ func (api *portalAPI) getCountries(network string, all bool) (countries []string
 		}
 		allCountries[c] = struct{}{}
 	}
-	api.mu.RUnlock()
 
 	for c := range allCountries {
 		countries = append(countries, c)
@@ -1768,13 +2382,12 @@ func (api *portalAPI) getHostKeys(
 		allCountries[strings.ToLower(c)] = struct{}{}
 	}
 
-	api.mu.RLock()
-	hosts := api.hosts[network]
+	hosts := api.hosts.load()[network]
 	var selectedHosts []portalHost
 
 outer:
 	for _, host := range hosts {
-		if !isOnline(*host) {
+		if !isOnline(*host) || host.OptOut == hostdb.OptOutDelist {
 			continue
 		}
 
@@ -1850,7 +2463,6 @@ outer:
 		if len(countries) > 0 {
 			var c string
 			if err := stmt.QueryRow(network, host.PublicKey[:]).Scan(&c); err != nil {
-				api.mu.RUnlock()
 				return nil, utils.AddContext(err, "couldn't retrieve country")
 			}
 			if _, ok := allCountries[strings.ToLower(c)]; !ok {
@@ -1860,7 +2472,6 @@ outer:
 
 		selectedHosts = append(selectedHosts, *host)
 	}
-	api.mu.RUnlock()
 
 	slices.SortStableFunc(selectedHosts, func(a, b portalHost) int { return a.Rank - b.Rank })
 
@@ -1876,6 +2487,10 @@ outer:
 }
 
 func getSpeeds(interactions nodeInteractions) (lat time.Duration, ul, dl float64) {
+	if interactions.evicted {
+		return interactions.speeds.latency, interactions.speeds.upload, interactions.speeds.download
+	}
+
 	var scans, benchmarks int
 	for _, scan := range interactions.ScanHistory {
 		if scan.Success {
@@ -1904,21 +2519,47 @@ func getSpeeds(interactions nodeInteractions) (lat time.Duration, ul, dl float64
 	return
 }
 
-func (api *portalAPI) pruneOldScans() {
-	for {
-		select {
-		case <-api.stopChan:
-			return
-		case <-time.After(scanPruneInterval):
-		}
+// getTTFB returns the average time to first byte measured by the node.
+func getTTFB(interactions nodeInteractions) (ttfb time.Duration) {
+	if interactions.evicted {
+		return interactions.speeds.ttfb
+	}
 
-		_, err := api.db.Exec(`
-			DELETE FROM scans
-			WHERE ran_at < ?
-			LIMIT 100000
-		`, time.Now().Unix()-int64(scanPruneThreshold.Seconds()))
-		if err != nil {
-			api.log.Error("unable to prune old scans", zap.Error(err))
+	var benchmarks int
+	for _, benchmark := range interactions.BenchmarkHistory {
+		if benchmark.Success {
+			ttfb += benchmark.TTFB
+			benchmarks++
 		}
 	}
+
+	if benchmarks > 0 {
+		ttfb /= time.Duration(benchmarks)
+	}
+
+	return
+}
+
+// pruneOldScans removes the scans older than the threshold, at most
+// 100000 at a time.
+func (api *portalAPI) pruneOldScans() error {
+	// DELETE ... LIMIT is only supported by MySQL, so the batch is
+	// selected in a subquery instead. MySQL needs the extra derived
+	// table around it for two reasons: it rejects LIMIT in an IN
+	// subquery (error 1235), and it doesn't allow a subquery to read
+	// from the table being deleted from (error 1093). The derived table
+	// is materialized first, which avoids both. PostgreSQL and SQLite
+	// accept either form.
+	_, err := api.db.Exec(`
+		DELETE FROM scans
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id
+				FROM scans
+				WHERE ran_at < ?
+				LIMIT 100000
+			) AS old
+		)
+	`, time.Now().Unix()-int64(scanPruneThreshold.Seconds()))
+	return utils.AddContext(err, "unable to prune old scans")
 }
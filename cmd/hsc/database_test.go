@@ -0,0 +1,158 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/testutil"
+	"go.uber.org/zap"
+)
+
+func TestPruneOldScans(t *testing.T) {
+	db := testutil.NewDB(t, "init_portal_sqlite.sql")
+	pk := testutil.AddPortalHost(t, db, 1, "mainnet")
+
+	now := time.Now()
+	for _, ranAt := range []time.Time{
+		now.Add(-2 * scanPruneThreshold),
+		now.Add(-scanPruneThreshold - time.Hour),
+		now.Add(-time.Hour),
+	} {
+		if _, err := db.Exec(`
+			INSERT INTO scans (network, node, public_key, ran_at, success, latency, error)
+			VALUES ('mainnet', 'global', ?, ?, TRUE, 0, '')
+		`, pk[:], ranAt.Unix()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	api := &portalAPI{db: db}
+	if err := api.pruneOldScans(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CountRows(t, db, "scans"); n != 1 {
+		t.Fatalf("expected 1 scan left, got %d", n)
+	}
+}
+
+func TestRerankHosts(t *testing.T) {
+	better, worse := testHost("global", 2), testHost("global", 2)
+	better.ID, worse.ID = 1, 2
+	better.Score.TotalScore, worse.Score.TotalScore = 0.9, 0.5
+	api := &portalAPI{
+		hosts: newHostStore(hostSet{
+			"mainnet": {better.PublicKey: better, worse.PublicKey: worse},
+			"zen":     {},
+		}),
+		sybil:    defaultSybilConfig,
+		tagRules: defaultTagRules,
+	}
+
+	api.rerankHosts()
+	hosts := api.hosts.load()["mainnet"]
+	if hosts[better.PublicKey].Rank != 1 || hosts[worse.PublicKey].Rank != 2 {
+		t.Fatalf("expected ranks 1 and 2, got %d and %d", hosts[better.PublicKey].Rank, hosts[worse.PublicKey].Rank)
+	}
+	if !slices.Contains(hosts[better.PublicKey].Tags, "top100") {
+		t.Fatalf("expected the top100 tag, got %v", hosts[better.PublicKey].Tags)
+	}
+
+	// The ranking is computed on copies of the hosts, and the published
+	// hosts are left intact.
+	if better.Rank != 0 || worse.Rank != 0 {
+		t.Fatal("published hosts modified")
+	}
+	r := api.ranking.Load()
+	if r.hosts["mainnet"][worse.PublicKey] == hosts[worse.PublicKey] {
+		t.Fatal("ranking shares the hosts with the API")
+	}
+	api.hosts.update(func(w *hostWriter) {
+		host, _ := w.edit("mainnet", worse.PublicKey)
+		host.Score.TotalScore = 1
+	})
+	api.rerankHosts()
+	if api.hosts.load()["mainnet"][worse.PublicKey].Rank != 1 || api.ranking.Load() == r {
+		t.Fatal("ranking not replaced")
+	}
+	if hosts[worse.PublicKey].Rank != 2 {
+		t.Fatal("previous hosts modified")
+	}
+}
+
+func TestApplyUpdatesPublishesAfterCommit(t *testing.T) {
+	const node = "global"
+	db := testutil.NewDB(t, "init_portal_sqlite.sql")
+	pk := testutil.AddPortalHost(t, db, 1, "mainnet")
+	host := &portalHost{
+		ID:           1,
+		PublicKey:    pk,
+		NetAddress:   "host.example.com:9982",
+		Interactions: make(map[string]nodeInteractions),
+	}
+	api := &portalAPI{
+		db:        db,
+		log:       zap.NewNop(),
+		hosts:     newHostStore(hostSet{"mainnet": {pk: host}, "zen": {}}),
+		histories: newHistoryCache(1),
+		events:    newEventHub(),
+		newHosts:  newNewHostTracker(nil),
+	}
+
+	updates := hostdb.HostUpdates{
+		ID: 1,
+		Hosts: []hostdb.HostDBEntry{{
+			ID:         1,
+			Network:    "mainnet",
+			PublicKey:  pk,
+			NetAddress: "moved.example.com:9982",
+			Uptime:     time.Hour,
+		}},
+		Scans: []hostdb.ScanHistory{{
+			HostScan:  hostdb.HostScan{Timestamp: time.Now(), Success: true, Latency: 100 * time.Millisecond},
+			PublicKey: pk,
+			Network:   "mainnet",
+			Node:      node,
+		}},
+	}
+	offset := updateOffset{seq: 1, applied: countUpdates(updates)}
+
+	// The commit fails, so the hosts must be left intact.
+	if _, err := db.Exec("DROP TABLE update_offsets"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.applyUpdates(node, updates, offset); err == nil {
+		t.Fatal("expected the offset to fail")
+	}
+	if live := api.hosts.load()["mainnet"][pk]; live != host || len(host.Interactions) != 0 || host.NetAddress != "host.example.com:9982" {
+		t.Fatal("updates published before the commit")
+	}
+
+	// The repeated batch is published and writes the scores.
+	if _, err := db.Exec(`
+		CREATE TABLE update_offsets (
+			node    VARCHAR(8) NOT NULL,
+			standby BOOL NOT NULL,
+			seq     BIGINT NOT NULL,
+			applied BIGINT NOT NULL,
+			PRIMARY KEY (node, standby)
+		)
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.applyUpdates(node, updates, offset); err != nil {
+		t.Fatal(err)
+	}
+	live := api.hosts.load()["mainnet"][pk]
+	if live.NetAddress != "moved.example.com:9982" || len(live.Interactions[node].ScanHistory) != 1 {
+		t.Fatal("updates not published")
+	}
+	var score float64
+	if err := db.QueryRow("SELECT uptime_score FROM hosts WHERE public_key = ?", pk[:]).Scan(&score); err != nil {
+		t.Fatal(err)
+	}
+	if score == 0 || score != live.Score.UptimeScore {
+		t.Fatalf("expected the score %v to be saved, got %v", live.Score.UptimeScore, score)
+	}
+}
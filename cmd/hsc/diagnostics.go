@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// diagnosticsInterval determines how often the failing hosts are
+	// looked for and the traceroute results are pulled from the nodes.
+	diagnosticsInterval = 10 * time.Minute
+
+	// diagnosticFailures is the number of consecutive failed scans from
+	// a node that trigger a traceroute, provided that another node can
+	// reach the host.
+	diagnosticFailures = 3
+
+	// diagnosticRecency is how recent a successful scan from another node
+	// has to be for the host to count as reachable.
+	diagnosticRecency = 2 * time.Hour
+
+	// diagnosticRetention is how long the traceroute results are kept.
+	diagnosticRetention = 30 * 24 * time.Hour
+
+	// maxHostDiagnostics is the number of traceroute results returned per
+	// host.
+	maxHostDiagnostics = 20
+)
+
+// hostDiagnostic is a traceroute result as served by the portal.
+type hostDiagnostic struct {
+	Node      string            `json:"node"`
+	Timestamp time.Time         `json:"timestamp"`
+	Target    string            `json:"target"`
+	Reached   bool              `json:"reached"`
+	Hops      []hostdb.TraceHop `json:"hops"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// diagnosticTarget is a host that fails from one node only.
+type diagnosticTarget struct {
+	network   string
+	node      string
+	publicKey types.PublicKey
+}
+
+// failingFromNode returns true if the last diagnosticFailures scans of the
+// host failed for a reason on the host's side.
+func failingFromNode(history []portalScan) bool {
+	var n int
+	for _, scan := range history {
+		if !scan.Failure.HostFault() {
+			continue
+		}
+		if scan.Success {
+			return false
+		}
+		n++
+		if n == diagnosticFailures {
+			return true
+		}
+	}
+	return false
+}
+
+// reachableFromElsewhere returns true if a node other than the given one
+// has scanned the host successfully within diagnosticRecency.
+func reachableFromElsewhere(host *portalHost, node string) bool {
+	for n, interactions := range host.Interactions {
+		if n == node || len(interactions.ScanHistory) == 0 {
+			continue
+		}
+		scan := interactions.ScanHistory[0]
+		if scan.Success && time.Since(scan.Timestamp) < diagnosticRecency {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnosticTargets returns the hosts that fail from one node but not
+// from another one.
+func (api *portalAPI) diagnosticTargets() (targets []diagnosticTarget) {
+	for network, hosts := range api.hosts.load() {
+		for pk, host := range hosts {
+			if host.Blocked || host.OptOut == hostdb.OptOutDelist {
+				continue
+			}
+			for node, interactions := range host.Interactions {
+				if _, ok := api.clients[node]; !ok {
+					continue
+				}
+				if failingFromNode(interactions.ScanHistory) && reachableFromElsewhere(host, node) {
+					targets = append(targets, diagnosticTarget{network, node, pk})
+				}
+			}
+		}
+	}
+	return
+}
+
+// lastDiagnostic returns the time of the latest stored traceroute result
+// from the node.
+func (api *portalAPI) lastDiagnostic(node string) (time.Time, error) {
+	var ranAt int64
+	err := api.db.QueryRow(`
+		SELECT COALESCE(MAX(ran_at), 0)
+		FROM diagnostics
+		WHERE node = ?
+	`, node).Scan(&ranAt)
+	if err != nil {
+		return time.Time{}, utils.AddContext(err, "couldn't query diagnostics")
+	}
+	if ranAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(ranAt, 0), nil
+}
+
+// saveDiagnostics stores the traceroute results received from the node.
+func (api *portalAPI) saveDiagnostics(node string, diags []hostdb.Diagnostic) error {
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO diagnostics (
+			network,
+			node,
+			public_key,
+			ran_at,
+			target,
+			reached,
+			hops,
+			error
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	for _, diag := range diags {
+		hops, err := json.Marshal(diag.Hops)
+		if err != nil {
+			return utils.AddContext(err, "couldn't marshal hops")
+		}
+		_, err = stmt.Exec(
+			diag.Network,
+			node,
+			diag.PublicKey[:],
+			diag.Timestamp.Unix(),
+			diag.Target,
+			diag.Reached,
+			string(hops),
+			diag.Error,
+		)
+		if err != nil {
+			return utils.AddContext(err, "couldn't save diagnostic")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pruneOldDiagnostics removes the traceroute results older than
+// diagnosticRetention.
+func (api *portalAPI) pruneOldDiagnostics() error {
+	_, err := api.db.Exec(`
+		DELETE FROM diagnostics
+		WHERE ran_at < ?
+	`, time.Now().Add(-diagnosticRetention).Unix())
+	return utils.AddContext(err, "unable to prune old diagnostics")
+}
+
+// runDiagnostics asks the nodes to trace the hosts that only they fail to
+// reach, and pulls the results of the earlier traceroutes.
+func (api *portalAPI) runDiagnostics() error {
+	targets := api.diagnosticTargets()
+
+	var errs []error
+	for _, target := range targets {
+		c := api.failover.client(target.node, api.clients[target.node])
+		if _, err := c.RunDiagnostic(target.network, target.publicKey); err != nil {
+			errs = append(errs, utils.AddContext(err, "couldn't run diagnostic on "+target.node))
+		}
+	}
+
+	hosts := api.hosts.load()
+	for node, c := range api.clients {
+		since, ok := api.diagnosticsSince[node]
+		if !ok {
+			last, err := api.lastDiagnostic(node)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			since = last
+		}
+		diags, err := api.failover.client(node, c).Diagnostics("", since)
+		if err != nil {
+			errs = append(errs, utils.AddContext(err, "couldn't get diagnostics from "+node))
+			continue
+		}
+
+		// Only the hosts known to the portal can be stored.
+		var known []hostdb.Diagnostic
+		for _, diag := range diags {
+			if _, exists := hosts[diag.Network][diag.PublicKey]; exists {
+				known = append(known, diag)
+			}
+			if diag.Timestamp.After(since) {
+				since = diag.Timestamp
+			}
+		}
+
+		if err := api.saveDiagnostics(node, known); err != nil {
+			errs = append(errs, utils.AddContext(err, "couldn't save diagnostics from "+node))
+			continue
+		}
+		api.diagnosticsSince[node] = since
+	}
+
+	if err := api.pruneOldDiagnostics(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (api *portalAPI) hostsDiagnosticsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	h := req.FormValue("host")
+	if h == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if err := pk.UnmarshalText([]byte(h)); err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+
+	host, exists := api.hosts.load()[network][pk]
+	if !exists || host.OptOut == hostdb.OptOutDelist {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := api.db.Query(`
+		SELECT node, ran_at, target, reached, hops, error
+		FROM diagnostics
+		WHERE network = ?
+		AND public_key = ?
+		ORDER BY ran_at DESC
+		LIMIT ?
+	`, network, pk[:], maxHostDiagnostics)
+	if err != nil {
+		api.log.Error("couldn't query diagnostics", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	diags := []hostDiagnostic{}
+	for rows.Next() {
+		var diag hostDiagnostic
+		var ranAt int64
+		var hops string
+		if err := rows.Scan(&diag.Node, &ranAt, &diag.Target, &diag.Reached, &hops, &diag.Error); err != nil {
+			api.log.Error("couldn't retrieve diagnostic", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		diag.Timestamp = time.Unix(ranAt, 0)
+		if err := json.Unmarshal([]byte(hops), &diag.Hops); err != nil || diag.Hops == nil {
+			diag.Hops = []hostdb.TraceHop{}
+		}
+		diags = append(diags, diag)
+	}
+	if err := rows.Err(); err != nil {
+		api.log.Error("couldn't retrieve diagnostics", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, diags)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+)
+
+// distributionBucket is the number of hosts in a bucket of the histogram.
+// The numeric buckets contain the values from Min up to, but not
+// including, Max, in Hastings per TB per month. The last bucket has no
+// Max.
+type distributionBucket struct {
+	Label string          `json:"label"`
+	Min   *types.Currency `json:"min,omitempty"`
+	Max   *types.Currency `json:"max,omitempty"`
+	Hosts int             `json:"hosts"`
+	Share float64         `json:"share"`
+}
+
+// distribution is a histogram of the hosts of a network.
+type distribution struct {
+	By      string               `json:"by"`
+	Total   int                  `json:"total"`
+	Buckets []distributionBucket `json:"buckets"`
+}
+
+// distributionBounds contains the bucket boundaries of the numeric
+// distributions in SC per TB per month.
+var distributionBounds = map[string][]uint32{
+	"storage_price": {50, 100, 150, 200, 250, 300, 400, 500, 750, 1000},
+	"collateral":    {100, 200, 300, 400, 500, 750, 1000, 1500, 2000},
+}
+
+// numericBuckets creates the empty buckets for the boundaries.
+func numericBuckets(bounds []uint32) []distributionBucket {
+	buckets := make([]distributionBucket, 0, len(bounds)+1)
+	lower := types.ZeroCurrency
+	var prev uint32
+	for _, b := range bounds {
+		min, max := lower, types.Siacoins(b)
+		buckets = append(buckets, distributionBucket{
+			Label: fmt.Sprintf("%d-%d SC", prev, b),
+			Min:   &min,
+			Max:   &max,
+		})
+		lower, prev = max, b
+	}
+	buckets = append(buckets, distributionBucket{
+		Label: fmt.Sprintf("%d+ SC", prev),
+		Min:   &lower,
+	})
+	return buckets
+}
+
+// getDistribution counts the hosts by the given property. If all is
+// false, only the online hosts are counted.
+func (api *portalAPI) getDistribution(network, by string, all bool) distribution {
+	d := distribution{By: by}
+	bounds, numeric := distributionBounds[by]
+	if numeric {
+		d.Buckets = numericBuckets(bounds)
+	}
+	counts := make(map[string]int)
+	for _, host := range api.hosts.load()[network] {
+		if host.OptOut == hostdb.OptOutDelist || (!all && !isOnline(*host)) {
+			continue
+		}
+		d.Total++
+		switch by {
+		case "version":
+			release := strings.TrimSpace(host.Settings.Release)
+			if release == "" {
+				release = "unknown"
+			}
+			counts[release]++
+		case "country":
+			country := host.Country
+			if country == "" {
+				country = "unknown"
+			}
+			counts[country]++
+		case "storage_price", "collateral":
+			value := host.Settings.StoragePrice
+			if by == "collateral" {
+				value = host.Settings.Collateral
+			}
+			value = value.Mul64(1e12).Mul64(30 * 144)
+			i := len(d.Buckets) - 1
+			for i > 0 && d.Buckets[i].Min.Cmp(value) > 0 {
+				i--
+			}
+			d.Buckets[i].Hosts++
+		}
+	}
+
+	if !numeric {
+		d.Buckets = make([]distributionBucket, 0, len(counts))
+		for label, n := range counts {
+			d.Buckets = append(d.Buckets, distributionBucket{Label: label, Hosts: n})
+		}
+		slices.SortFunc(d.Buckets, func(a, b distributionBucket) int {
+			if a.Hosts != b.Hosts {
+				return cmp.Compare(b.Hosts, a.Hosts)
+			}
+			return strings.Compare(a.Label, b.Label)
+		})
+	}
+	if d.Total > 0 {
+		for i := range d.Buckets {
+			d.Buckets[i].Share = float64(d.Buckets[i].Hosts) / float64(d.Total)
+		}
+	}
+
+	return d
+}
+
+func (api *portalAPI) networkDistributionHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	by := strings.ToLower(req.FormValue("by"))
+	switch by {
+	case "version", "country", "storage_price", "collateral":
+	case "":
+		writeError(w, "distribution not provided", http.StatusBadRequest)
+		return
+	default:
+		writeError(w, "wrong distribution", http.StatusBadRequest)
+		return
+	}
+	allHosts := strings.ToLower(req.FormValue("all"))
+	var all bool
+	if allHosts == "true" {
+		all = true
+	} else if allHosts != "" && allHosts != "false" {
+		writeError(w, "wrong all parameter", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, api.getDistribution(network, by, all))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// embedCacheTTL is how long an embed is served from the cache. The same
+// value is sent to the browsers and the proxies, since the embeds may be
+// shown on busy pages.
+const embedCacheTTL = 5 * time.Minute
+
+// hostEmbed contains the key stats of a host to be shown on the websites
+// of the host operators. The prices are per TB, the storage price and
+// the collateral also per month.
+type hostEmbed struct {
+	PublicKey     types.PublicKey `json:"publicKey"`
+	Network       string          `json:"network"`
+	NetAddress    string          `json:"netaddress"`
+	Rank          int             `json:"rank"`
+	Score         float64         `json:"score"`
+	Uptime        float64         `json:"uptime"`
+	StoragePrice  types.Currency  `json:"storagePrice"`
+	Collateral    types.Currency  `json:"collateral"`
+	UploadPrice   types.Currency  `json:"uploadPrice"`
+	DownloadPrice types.Currency  `json:"downloadPrice"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+}
+
+// embedCache keeps the recently requested embeds.
+type embedCache struct {
+	embeds map[string]map[types.PublicKey]hostEmbed
+	mu     sync.Mutex
+}
+
+func newEmbedCache() *embedCache {
+	return &embedCache{
+		embeds: map[string]map[types.PublicKey]hostEmbed{
+			"mainnet": make(map[types.PublicKey]hostEmbed),
+			"zen":     make(map[types.PublicKey]hostEmbed),
+		},
+	}
+}
+
+func (ec *embedCache) get(network string, pk types.PublicKey) (hostEmbed, bool) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	he, ok := ec.embeds[network][pk]
+	if !ok || time.Since(he.UpdatedAt) >= embedCacheTTL {
+		return hostEmbed{}, false
+	}
+	return he, true
+}
+
+func (ec *embedCache) put(he hostEmbed) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	ec.embeds[he.Network][he.PublicKey] = he
+}
+
+// newHostEmbed collects the key stats of the host. The uptime is the
+// share of the time the host was online, as seen by all nodes.
+func newHostEmbed(network string, host *portalHost) hostEmbed {
+	var uptime, total time.Duration
+	for _, interactions := range host.Interactions {
+		uptime += interactions.Uptime
+		total += interactions.Uptime + interactions.Downtime
+	}
+	he := hostEmbed{
+		PublicKey:     host.PublicKey,
+		Network:       network,
+		NetAddress:    host.NetAddress,
+		Rank:          host.Rank,
+		Score:         host.Score.TotalScore,
+		StoragePrice:  host.Settings.StoragePrice.Mul64(1e12).Mul64(30 * 144),
+		Collateral:    host.Settings.Collateral.Mul64(1e12).Mul64(30 * 144),
+		UploadPrice:   host.Settings.UploadBandwidthPrice.Mul64(1e12),
+		DownloadPrice: host.Settings.DownloadBandwidthPrice.Mul64(1e12),
+		UpdatedAt:     time.Now(),
+	}
+	if total > 0 {
+		he.Uptime = float64(uptime) / float64(total)
+	}
+	return he
+}
+
+// embedTemplate renders the embed as a small HTML card that can be shown
+// in an iframe.
+var embedTemplate = template.Must(template.New("embed").Funcs(template.FuncMap{
+	"percent": func(f float64) string {
+		return strconv.FormatFloat(f*100, 'f', 1, 64) + "%"
+	},
+	"score": func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 2, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style>
+body { margin: 0; font-family: sans-serif; font-size: 14px; color: #222; }
+.card { border: 1px solid #ddd; border-radius: 6px; padding: 8px 12px; }
+.title { font-weight: bold; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 2px 0; }
+td:last-child { text-align: right; }
+.footer { margin-top: 4px; font-size: 11px; color: #888; }
+</style>
+</head>
+<body>
+<div class="card">
+<div class="title">{{.NetAddress}}</div>
+<table>
+<tr><td>Rank</td><td>{{if .Rank}}#{{.Rank}}{{else}}-{{end}}</td></tr>
+<tr><td>Score</td><td>{{score .Score}}</td></tr>
+<tr><td>Uptime</td><td>{{percent .Uptime}}</td></tr>
+<tr><td>Storage</td><td>{{.StoragePrice}}/TB/month</td></tr>
+<tr><td>Collateral</td><td>{{.Collateral}}/TB/month</td></tr>
+<tr><td>Upload</td><td>{{.UploadPrice}}/TB</td></tr>
+<tr><td>Download</td><td>{{.DownloadPrice}}/TB</td></tr>
+</table>
+<div class="footer">HostScore, {{.Network}}, updated {{.UpdatedAt.UTC.Format "2006-01-02 15:04"}} UTC</div>
+</div>
+</body>
+</html>
+`))
+
+func (api *portalAPI) embedHostHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	h := req.FormValue("host")
+	if h == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if err := pk.UnmarshalText([]byte(h)); err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(req.FormValue("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "html" {
+		writeError(w, "wrong format", http.StatusBadRequest)
+		return
+	}
+
+	he, ok := api.embeds.get(network, pk)
+	if !ok {
+		host, exists := api.hosts.load()[network][pk]
+		if !exists || host.OptOut == hostdb.OptOutDelist {
+			writeError(w, "host not found", http.StatusBadRequest)
+			return
+		}
+		he = newHostEmbed(network, host)
+		api.embeds.put(he)
+	}
+
+	// The embeds are requested from the websites of the host operators.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(embedCacheTTL.Seconds())))
+
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := embedTemplate.Execute(w, he); err != nil {
+			api.log.Error("couldn't render embed", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+		}
+		return
+	}
+	writeJSON(w, he)
+}
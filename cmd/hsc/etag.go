@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.sia.tech/core/types"
+)
+
+// etag returns the entity tag of the response body.
+func etag(body []byte) string {
+	h := types.HashBytes(body)
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// notModified returns true if the client already has the response with
+// the given entity tag.
+func notModified(req *http.Request, tag string) bool {
+	header := req.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTagged writes the response body along with its entity tag, or
+// only the status 304 if the client already has it.
+func writeTagged(w http.ResponseWriter, req *http.Request, body []byte) {
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	if notModified(req, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeBlob(w, body)
+}
+
+// writeTaggedJSON is like writeJSON, but it supports the conditional
+// requests.
+func writeTaggedJSON(w http.ResponseWriter, req *http.Request, obj any) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeTagged(w, req, append(body, '\n'))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/websocket"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// subscriberBuffer is the number of events that can be queued for
+	// a subscriber. A subscriber that falls further behind is dropped.
+	subscriberBuffer = 256
+
+	// wsPingInterval determines how often the WebSocket clients are pinged.
+	wsPingInterval = 30 * time.Second
+)
+
+// Event types.
+const (
+	eventScore     = "score"
+	eventScan      = "scan"
+	eventBenchmark = "benchmark"
+)
+
+// portalEvent is sent to the WebSocket clients when a host changes.
+type portalEvent struct {
+	Type      string                `json:"type"`
+	Network   string                `json:"network"`
+	PublicKey types.PublicKey       `json:"publicKey"`
+	Node      string                `json:"node,omitempty"`
+	Score     *scoreBreakdown       `json:"score,omitempty"`
+	Rank      int                   `json:"rank,omitempty"`
+	Scan      *portalScan           `json:"scan,omitempty"`
+	Benchmark *hostdb.HostBenchmark `json:"benchmark,omitempty"`
+}
+
+type subscriber struct {
+	events  chan portalEvent
+	network string
+	host    *types.PublicKey
+}
+
+func (s *subscriber) wants(e portalEvent) bool {
+	if s.network != "" && s.network != e.Network {
+		return false
+	}
+	return s.host == nil || *s.host == e.PublicKey
+}
+
+// eventHub distributes the events among the subscribers.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs: make(map[*subscriber]struct{}),
+	}
+}
+
+func (eh *eventHub) subscribe(network string, host *types.PublicKey) *subscriber {
+	s := &subscriber{
+		events:  make(chan portalEvent, subscriberBuffer),
+		network: network,
+		host:    host,
+	}
+	eh.mu.Lock()
+	eh.subs[s] = struct{}{}
+	eh.mu.Unlock()
+	return s
+}
+
+func (eh *eventHub) unsubscribe(s *subscriber) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	if _, ok := eh.subs[s]; ok {
+		delete(eh.subs, s)
+		close(s.events)
+	}
+}
+
+// active returns true if there are any subscribers.
+func (eh *eventHub) active() bool {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	return len(eh.subs) > 0
+}
+
+// publish sends the events to the subscribers without blocking.
+func (eh *eventHub) publish(events []portalEvent) {
+	eh.mu.Lock()
+	defer eh.mu.Unlock()
+	for s := range eh.subs {
+	loop:
+		for _, e := range events {
+			if !s.wants(e) {
+				continue
+			}
+			select {
+			case s.events <- e:
+			default:
+				// The subscriber is too slow, drop it.
+				delete(eh.subs, s)
+				close(s.events)
+				break loop
+			}
+		}
+	}
+}
+
+// collectEvents creates the events caused by the updates, which have
+// been applied to the hosts of w. oldScores contains the total scores of
+// the updated hosts before the update.
+func collectEvents(w *hostWriter, node string, updates hostdb.HostUpdates, oldScores map[string]map[types.PublicKey]float64) (events []portalEvent) {
+	for network, scores := range oldScores {
+		for pk, old := range scores {
+			host, ok := w.get(network, pk)
+			if !ok || host.Score.TotalScore == old {
+				continue
+			}
+			score := host.Score
+			events = append(events, portalEvent{
+				Type:      eventScore,
+				Network:   network,
+				PublicKey: pk,
+				Score:     &score,
+				Rank:      host.Rank,
+			})
+		}
+	}
+
+	for _, scan := range updates.Scans {
+		events = append(events, portalEvent{
+			Type:      eventScan,
+			Network:   scan.Network,
+			PublicKey: scan.PublicKey,
+			Node:      node,
+			Scan: &portalScan{
+				Timestamp:  scan.Timestamp,
+				Success:    scan.Success,
+				Latency:    scan.Latency,
+				Error:      scan.Error,
+				Failure:    scan.Failure,
+				ClockSkew:  scan.ClockSkew,
+				ValidUntil: scan.ValidUntil,
+				InvalidSig: scan.InvalidSig,
+				IPv4:       scan.IPv4,
+				IPv6:       scan.IPv6,
+				Timings:    scan.Timings,
+			},
+		})
+	}
+
+	for _, benchmark := range updates.Benchmarks {
+		b := benchmark.HostBenchmark
+		events = append(events, portalEvent{
+			Type:      eventBenchmark,
+			Network:   benchmark.Network,
+			PublicKey: benchmark.PublicKey,
+			Node:      node,
+			Benchmark: &b,
+		})
+	}
+
+	return
+}
+
+func (api *portalAPI) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network != "" && network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	var host *types.PublicKey
+	if h := req.FormValue("host"); h != "" {
+		var pk types.PublicKey
+		if err := pk.UnmarshalText([]byte(h)); err != nil {
+			writeError(w, "invalid public key", http.StatusBadRequest)
+			return
+		}
+		host = &pk
+	}
+
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		api.log.Debug("websocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	sub := api.events.subscribe(network, host)
+	defer api.events.unsubscribe(sub)
+
+	// The messages from the client are ignored, but they need to be read
+	// to process the control frames and to detect a disconnect.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-api.stopChan:
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.Ping(); err != nil {
+				return
+			}
+		case e, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// pricesExplanation contains the inputs of the price score.
+type pricesExplanation struct {
+	ContractPrice types.Currency `json:"contractPrice"`
+	UploadCost    types.Currency `json:"uploadCost"`
+	DownloadCost  types.Currency `json:"downloadCost"`
+	StorageCost   types.Currency `json:"storageCost"`
+	PeriodCost    types.Currency `json:"periodCost"`
+	Budget        types.Currency `json:"budget"`
+	Score         float64        `json:"score"`
+}
+
+// storageExplanation contains the inputs of the storage score.
+type storageExplanation struct {
+	RemainingStorage uint64  `json:"remainingStorage"`
+	ExpectedStorage  uint64  `json:"expectedStorage"`
+	DataPerHost      uint64  `json:"dataPerHost"`
+	Score            float64 `json:"score"`
+}
+
+// collateralExplanation contains the inputs of the collateral score.
+type collateralExplanation struct {
+	CollateralCost     types.Currency `json:"collateralCost"`
+	MaxCollateral      types.Currency `json:"maxCollateral"`
+	ExpectedCollateral types.Currency `json:"expectedCollateral"`
+	Cutoff             types.Currency `json:"cutoff"`
+	FullScoreAt        types.Currency `json:"fullScoreAt"`
+	Score              float64        `json:"score"`
+}
+
+// ageExplanation contains the inputs of the age score.
+type ageExplanation struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	AgeDays   float64   `json:"ageDays"`
+	Score     float64   `json:"score"`
+}
+
+// versionExplanation contains the inputs of the version score.
+type versionExplanation struct {
+	Version  string               `json:"version"`
+	Release  string               `json:"release"`
+	Required []releaseRequirement `json:"required"`
+	Score    float64              `json:"score"`
+}
+
+// contractsExplanation contains the inputs of the contracts score.
+type contractsExplanation struct {
+	AcceptingContracts bool    `json:"acceptingContracts"`
+	Score              float64 `json:"score"`
+}
+
+// durationExplanation contains the inputs of the duration score.
+type durationExplanation struct {
+	MaxDuration    uint64  `json:"maxDuration"`
+	MedianDuration uint64  `json:"medianDuration"`
+	WindowSize     uint64  `json:"windowSize"`
+	MinWindowSize  uint64  `json:"minWindowSize"`
+	MaxWindowSize  uint64  `json:"maxWindowSize"`
+	Score          float64 `json:"score"`
+}
+
+// interactionsExplanation contains the inputs of the interactions score.
+type interactionsExplanation struct {
+	Successes float64 `json:"successes"`
+	Failures  float64 `json:"failures"`
+	Score     float64 `json:"score"`
+}
+
+// uptimeExplanation contains the inputs of the uptime score.
+type uptimeExplanation struct {
+	Uptime   time.Duration `json:"uptime"`
+	Downtime time.Duration `json:"downtime"`
+	Ratio    float64       `json:"ratio"`
+	Scans    int           `json:"scans"`
+	Score    float64       `json:"score"`
+}
+
+// latencyExplanation contains the inputs of the latency score.
+type latencyExplanation struct {
+	AverageLatency      float64   `json:"averageLatency"` // in milliseconds
+	SuccessfulScans     int       `json:"successfulScans"`
+	AverageProbeLatency float64   `json:"averageProbeLatency"` // in milliseconds
+	SuccessfulProbes    int       `json:"successfulProbes"`
+	LastMeasured        time.Time `json:"lastMeasured"`
+	Decay               float64   `json:"decay"`
+	Score               float64   `json:"score"`
+}
+
+// benchmarksExplanation contains the inputs of the benchmarks score.
+type benchmarksExplanation struct {
+	AverageUploadSpeed   float64   `json:"averageUploadSpeed"`
+	AverageDownloadSpeed float64   `json:"averageDownloadSpeed"`
+	SuccessfulBenchmarks int       `json:"successfulBenchmarks"`
+	LastMeasured         time.Time `json:"lastMeasured"`
+	Decay                float64   `json:"decay"`
+	Score                float64   `json:"score"`
+}
+
+// nodeExplanation contains the inputs of the scores measured by a node.
+type nodeExplanation struct {
+	Interactions interactionsExplanation `json:"interactions"`
+	Uptime       uptimeExplanation       `json:"uptime"`
+	Latency      latencyExplanation      `json:"latency"`
+	Benchmarks   benchmarksExplanation   `json:"benchmarks"`
+	Score        scoreBreakdown          `json:"score"`
+}
+
+// scoreExplanation breaks the host's score down to the raw inputs of
+// each factor.
+type scoreExplanation struct {
+	Network    string                     `json:"network"`
+	PublicKey  types.PublicKey            `json:"publicKey"`
+	Rank       int                        `json:"rank"`
+	Weights    scoreWeights               `json:"weights"`
+	Prices     pricesExplanation          `json:"prices"`
+	Storage    storageExplanation         `json:"storage"`
+	Collateral collateralExplanation      `json:"collateral"`
+	Age        ageExplanation             `json:"age"`
+	Version    versionExplanation         `json:"version"`
+	Contracts  contractsExplanation       `json:"contracts"`
+	Duration   durationExplanation        `json:"duration"`
+	Nodes      map[string]nodeExplanation `json:"nodes"`
+	Federated  []federatedScore           `json:"federated,omitempty"`
+	Score      scoreBreakdown             `json:"score"`
+}
+
+// explainScore collects the inputs of the host's score.
+func explainScore(host *portalHost, network string) scoreExplanation {
+	expectedCollateral, cutoff := collateralCutoff(host.PriceTable)
+	se := scoreExplanation{
+		Network:   network,
+		PublicKey: host.PublicKey,
+		Rank:      host.Rank,
+		Weights:   weights,
+		Prices: pricesExplanation{
+			ContractPrice: contractPriceForScore(host.Settings, host.PriceTable),
+			UploadCost:    uploadCostForScore(host.PriceTable, dataPerHost),
+			DownloadCost:  downloadCostForScore(host.PriceTable, dataPerHost),
+			StorageCost:   storageCostForScore(host.PriceTable, dataPerHost),
+			PeriodCost:    hostPeriodCostForScore(host.Settings, host.PriceTable),
+			Budget:        anchors.get(network),
+			Score:         host.Score.PricesScore,
+		},
+		Storage: storageExplanation{
+			RemainingStorage: host.Settings.RemainingStorage,
+			ExpectedStorage:  host.Settings.RemainingStorage / 4,
+			DataPerHost:      dataPerHost,
+			Score:            host.Score.StorageScore,
+		},
+		Collateral: collateralExplanation{
+			CollateralCost:     host.PriceTable.CollateralCost,
+			MaxCollateral:      host.PriceTable.MaxCollateral,
+			ExpectedCollateral: expectedCollateral,
+			Cutoff:             cutoff,
+			FullScoreAt:        cutoff.Mul64(4),
+			Score:              host.Score.CollateralScore,
+		},
+		Age: ageExplanation{
+			FirstSeen: host.FirstSeen,
+			AgeDays:   time.Since(host.FirstSeen).Hours() / 24,
+			Score:     host.Score.AgeScore,
+		},
+		Version: versionExplanation{
+			Version:  host.Settings.Version,
+			Release:  host.Settings.Release,
+			Required: releases,
+			Score:    host.Score.VersionScore,
+		},
+		Contracts: contractsExplanation{
+			AcceptingContracts: host.Settings.AcceptingContracts,
+			Score:              host.Score.ContractsScore,
+		},
+		Duration: durationExplanation{
+			MaxDuration:    host.Settings.MaxDuration,
+			MedianDuration: anchors.getDuration(network),
+			WindowSize:     host.Settings.WindowSize,
+			MinWindowSize:  minWindowSize,
+			MaxWindowSize:  maxWindowSize,
+			Score:          host.Score.DurationScore,
+		},
+		Nodes:     make(map[string]nodeExplanation),
+		Federated: host.Federated,
+		Score:     host.Score,
+	}
+
+	for node, interactions := range host.Interactions {
+		var ratio float64
+		if total := interactions.Uptime + interactions.Downtime; total > 0 {
+			ratio = float64(interactions.Uptime) / float64(total)
+		}
+		latency, scans := averageLatency(interactions.ScanHistory)
+		probeLatency, probes := averageProbeLatency(interactions.probes)
+		ul, dl, benchmarks := averageSpeeds(interactions.BenchmarkHistory)
+		lastLatency := latestLatency(interactions.ScanHistory, interactions.probes)
+		lastBenchmark := latestBenchmark(interactions.BenchmarkHistory)
+		se.Nodes[node] = nodeExplanation{
+			Interactions: interactionsExplanation{
+				Successes: interactions.HistoricSuccesses,
+				Failures:  interactions.HistoricFailures,
+				Score:     interactions.Score.InteractionsScore,
+			},
+			Uptime: uptimeExplanation{
+				Uptime:   interactions.Uptime,
+				Downtime: interactions.Downtime,
+				Ratio:    ratio,
+				Scans:    len(interactions.ScanHistory),
+				Score:    interactions.Score.UptimeScore,
+			},
+			Latency: latencyExplanation{
+				AverageLatency:      latency,
+				SuccessfulScans:     scans,
+				AverageProbeLatency: probeLatency,
+				SuccessfulProbes:    probes,
+				LastMeasured:        lastLatency,
+				Decay:               decayFactor(lastLatency),
+				Score:               interactions.Score.LatencyScore,
+			},
+			Benchmarks: benchmarksExplanation{
+				AverageUploadSpeed:   ul,
+				AverageDownloadSpeed: dl,
+				SuccessfulBenchmarks: benchmarks,
+				LastMeasured:         lastBenchmark,
+				Decay:                decayFactor(lastBenchmark),
+				Score:                interactions.Score.BenchmarksScore,
+			},
+			Score: interactions.Score,
+		}
+	}
+
+	return se
+}
+
+func (api *portalAPI) hostsScoreHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	h := req.FormValue("host")
+	if h == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if err := pk.UnmarshalText([]byte(h)); err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+
+	ph, ok := api.hosts.load()[network][pk]
+	if !ok {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+	host, err := api.withHistory(network, *ph)
+	if err != nil {
+		api.log.Error("couldn't load host history", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, explainScore(&host, network))
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultExportLimit is the default number of rows in an export page.
+	defaultExportLimit = 1000
+
+	// maxExportLimit is the largest number of rows in an export page.
+	maxExportLimit = 10000
+)
+
+// exportTable describes a database table that can be exported to another
+// portal.
+type exportTable struct {
+	name    string
+	columns []string
+	// cursor is the auto-increment column used to page through the table.
+	// If empty, the table is paged by an offset.
+	cursor  string
+	orderBy string
+	// key lists the columns identifying a row when it is copied over an
+	// existing one.
+	key string
+}
+
+// exportTables lists the exported tables in the order they need to be
+// imported to satisfy the foreign keys. The network averages are not
+// exported, because they are calculated from the hosts.
+var exportTables = []exportTable{
+	{
+		name: "hosts",
+		columns: []string{
+			"id", "network", "public_key", "first_seen", "known_since",
+			"blocked", "net_address", "ip_nets", "last_ip_change",
+			"price_score", "storage_score", "collateral_score",
+			"interactions_score", "uptime_score", "age_score",
+			"version_score", "latency_score", "benchmarks_score",
+			"contracts_score", "duration_score", "total_score", "settings",
+			"price_table", "quarantined", "tags",
+		},
+		orderBy: "network, id",
+		key:     "public_key",
+	},
+	{
+		name: "interactions",
+		columns: []string{
+			"network", "node", "public_key", "uptime", "downtime",
+			"last_seen", "active_hosts", "price_score", "storage_score",
+			"collateral_score", "interactions_score", "uptime_score",
+			"age_score", "version_score", "latency_score",
+			"benchmarks_score", "contracts_score", "duration_score",
+			"total_score",
+			"historic_successful_interactions", "historic_failed_interactions",
+			"recent_successful_interactions", "recent_failed_interactions",
+			"last_update", "formation_successes", "duration_violations",
+			"expiry_successes", "expiry_failures", "ingress", "egress",
+			"traffic_since",
+		},
+		orderBy: "network, node, public_key",
+		key:     "network, node, public_key",
+	},
+	{
+		name: "scans",
+		columns: []string{
+			"id", "network", "node", "public_key", "ran_at", "success",
+			"latency", "error", "failure", "clock_skew", "valid_until",
+			"invalid_signature", "ipv4", "ipv4_latency", "ipv6",
+			"ipv6_latency", "dial_time", "handshake_time", "settings_time",
+		},
+		cursor:  "id",
+		orderBy: "id",
+		key:     "id",
+	},
+	{
+		name: "benchmarks",
+		columns: []string{
+			"id", "network", "node", "public_key", "ran_at", "success",
+			"upload_speed", "download_speed", "ttfb", "uploaded",
+			"downloaded", "error", "failure",
+		},
+		cursor:  "id",
+		orderBy: "id",
+		key:     "id",
+	},
+	{
+		name: "price_changes",
+		columns: []string{
+			"id", "network", "public_key", "changed_at", "remaining_storage",
+			"total_storage", "collateral", "storage_price", "upload_price",
+			"download_price",
+		},
+		cursor:  "id",
+		orderBy: "id",
+		key:     "id",
+	},
+	{
+		name: "settings_history",
+		columns: []string{
+			"id", "network", "public_key", "changed_at", "settings",
+			"price_table",
+		},
+		cursor:  "id",
+		orderBy: "id",
+		key:     "id",
+	},
+	{
+		name: "diagnostics",
+		columns: []string{
+			"id", "network", "node", "public_key", "ran_at", "target",
+			"reached", "hops", "error",
+		},
+		cursor:  "id",
+		orderBy: "id",
+		key:     "id",
+	},
+	{
+		name: "score_history",
+		columns: []string{
+			"id", "network", "public_key", "day", "price_score",
+			"storage_score", "collateral_score", "interactions_score",
+			"uptime_score", "age_score", "version_score", "latency_score",
+			"benchmarks_score", "contracts_score", "duration_score",
+			"total_score",
+		},
+		cursor:  "id",
+		orderBy: "id",
+		key:     "id",
+	},
+	{
+		name: "network_history",
+		columns: []string{
+			"id", "network", "hour", "hosts", "online_hosts",
+			"accepting_contracts", "total_storage", "used_storage",
+			"storage_price", "collateral", "upload_price", "download_price",
+			"upload_speed", "download_speed", "ttfb", "collateral_capacity",
+		},
+		cursor:  "id",
+		orderBy: "id",
+		key:     "id",
+	},
+	{
+		name: "locations",
+		columns: []string{
+			"network", "public_key", "ip", "host_name", "city", "region",
+			"country", "loc", "isp", "zip", "time_zone", "asn",
+			"fetched_at",
+		},
+		orderBy: "network, public_key",
+		key:     "network, public_key",
+	},
+}
+
+// exportResponse is a page of raw table rows. The values are sent as
+// bytes, so that they can be inserted into another database unchanged;
+// nil stands for NULL.
+type exportResponse struct {
+	Columns []string    `json:"columns"`
+	Rows    [][]*[]byte `json:"rows"`
+	More    bool        `json:"more"`
+	Next    int64       `json:"next"`
+}
+
+func findExportTable(name string) (exportTable, bool) {
+	for _, t := range exportTables {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return exportTable{}, false
+}
+
+// exportRows returns a page of rows from the table. after is the last
+// cursor value of the previous page, or the offset if the table has no
+// cursor.
+func (api *portalAPI) exportRows(t exportTable, after int64, limit int) (resp exportResponse, err error) {
+	where := ""
+	query := "SELECT " + strings.Join(t.columns, ", ") + " FROM " + t.name
+	args := []interface{}{}
+	if t.cursor != "" {
+		where = " WHERE " + t.cursor + " > ?"
+		args = append(args, after)
+	}
+	query += where + " ORDER BY " + t.orderBy + " LIMIT ?"
+	args = append(args, limit+1)
+	if t.cursor == "" {
+		query += " OFFSET ?"
+		args = append(args, after)
+	}
+
+	rows, err := api.db.Query(query, args...)
+	if err != nil {
+		return exportResponse{}, utils.AddContext(err, "couldn't query "+t.name)
+	}
+	defer rows.Close()
+
+	resp.Columns = t.columns
+	resp.Next = after
+	for rows.Next() {
+		if len(resp.Rows) == limit {
+			resp.More = true
+			break
+		}
+		raw := make([]sql.RawBytes, len(t.columns))
+		dest := make([]interface{}, len(t.columns))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return exportResponse{}, utils.AddContext(err, "couldn't decode "+t.name)
+		}
+		row := make([]*[]byte, len(t.columns))
+		for i, b := range raw {
+			if b != nil {
+				v := append([]byte{}, b...)
+				row[i] = &v
+			}
+		}
+		resp.Rows = append(resp.Rows, row)
+		if t.cursor != "" {
+			// The cursor is always the first column.
+			resp.Next, _ = strconv.ParseInt(string(raw[0]), 10, 64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return exportResponse{}, utils.AddContext(err, "couldn't read "+t.name)
+	}
+	if t.cursor == "" {
+		resp.Next = after + int64(len(resp.Rows))
+	}
+
+	return
+}
+
+func (api *portalAPI) exportHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	t, ok := findExportTable(ps.ByName("table"))
+	if !ok {
+		writeError(w, "unknown table", http.StatusNotFound)
+		return
+	}
+	var after int64
+	if a := req.FormValue("after"); a != "" {
+		var err error
+		after, err = strconv.ParseInt(a, 10, 64)
+		if err != nil || after < 0 {
+			writeError(w, "invalid after parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultExportLimit
+	if l := req.FormValue("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			writeError(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxExportLimit)
+	}
+
+	resp, err := api.exportRows(t, after, limit)
+	if err != nil {
+		api.log.Error("couldn't export rows", zap.String("table", t.name), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, resp)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	client "github.com/mike76-dev/hostscore/api"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.uber.org/zap"
+)
+
+// defaultFailoverTimeout is how long a primary node may fail to deliver
+// updates before hsc switches to the standby node.
+const defaultFailoverTimeout = 15 * time.Minute
+
+// failoverChecks is the number of consecutive polls that decide a switch.
+// The primary node must deliver no new updates for as many polls, on top
+// of the timeout, before hsc switches to the standby node, and it must
+// pass the probe as many times in a row before hsc switches back.
+const failoverChecks = 3
+
+// failoverState keeps track of a primary node and its cold standby.
+type failoverState struct {
+	standby    *client.Client
+	timeout    time.Duration
+	lastUpdate time.Time
+	lastID     hostdb.UpdateID
+	misses     int
+	recoveries int
+	active     bool
+}
+
+// failoverManager switches between the primary and the standby nodes.
+type failoverManager struct {
+	mu     sync.Mutex
+	states map[string]*failoverState
+	log    *zap.Logger
+}
+
+func newFailoverManager(logger *zap.Logger) *failoverManager {
+	return &failoverManager{
+		states: make(map[string]*failoverState),
+		log:    logger,
+	}
+}
+
+// addStandby pairs a standby node with the primary node at the location.
+func (fm *failoverManager) addStandby(location string, standby *client.Client, timeout time.Duration) {
+	if timeout == 0 {
+		timeout = defaultFailoverTimeout
+	}
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.states[location] = &failoverState{
+		standby:    standby,
+		timeout:    timeout,
+		lastUpdate: time.Now(),
+	}
+}
+
+// onStandby returns true if the updates for the location are currently
+// pulled from the standby node.
+func (fm *failoverManager) onStandby(location string) bool {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fs, ok := fm.states[location]
+	return ok && fs.active
+}
+
+// client returns the client to pull the updates from.
+func (fm *failoverManager) client(location string, primary *client.Client) *client.Client {
+	c, _ := fm.source(location, primary)
+	return c
+}
+
+// source returns the client to pull the updates from and whether it is
+// the standby node. A poll must use the same source throughout, since
+// update may switch the nodes in the middle of it.
+func (fm *failoverManager) source(location string, primary *client.Client) (*client.Client, bool) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	if fs, ok := fm.states[location]; ok && fs.active {
+		return fs.standby, true
+	}
+	return primary, false
+}
+
+// update records the result of the last update request and switches
+// between the primary and the standby node if needed. A response that is
+// empty, or that repeats the last batch, counts as a failure to deliver.
+func (fm *failoverManager) update(location string, primary *client.Client, id hostdb.UpdateID, received int, err error) {
+	fm.mu.Lock()
+	fs, ok := fm.states[location]
+	if !ok {
+		fm.mu.Unlock()
+		return
+	}
+	if err == nil && received > 0 && id != fs.lastID {
+		fs.lastUpdate = time.Now()
+		fs.misses = 0
+	} else {
+		fs.misses++
+	}
+	if err == nil {
+		fs.lastID = id
+	}
+	active := fs.active
+	expired := fs.misses >= failoverChecks && time.Since(fs.lastUpdate) > fs.timeout
+	fm.mu.Unlock()
+
+	if !active {
+		if expired {
+			fm.switchTo(fs, true)
+			fm.log.Warn("primary node stopped delivering updates, switching to standby", zap.String("node", location))
+		}
+		return
+	}
+
+	// Check if the primary node is back.
+	back := probePrimary(primary, fs.standby)
+	fm.mu.Lock()
+	if back {
+		fs.recoveries++
+	} else {
+		fs.recoveries = 0
+	}
+	recovered := fs.recoveries >= failoverChecks
+	fm.mu.Unlock()
+
+	if recovered {
+		fm.switchTo(fs, false)
+		fm.log.Info("primary node is back, switching from standby", zap.String("node", location))
+	}
+}
+
+// probePrimary returns true if the primary node responds and has caught
+// up with the standby node. Only the status of the nodes is requested,
+// since requesting the updates would create a batch on the primary node.
+func probePrimary(primary, standby *client.Client) bool {
+	status, err := primary.NodeStatus()
+	if err != nil {
+		return false
+	}
+	ref, err := standby.NodeStatus()
+	if err != nil {
+		// The standby node can't tell, so a responding primary is enough.
+		return true
+	}
+	return status.Height >= ref.Height && status.HeightZen >= ref.HeightZen
+}
+
+// switchTo switches to the standby node or back to the primary node and
+// starts counting anew.
+func (fm *failoverManager) switchTo(fs *failoverState, standby bool) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fs.active = standby
+	fs.lastUpdate = time.Now()
+	fs.lastID = 0
+	fs.misses = 0
+	fs.recoveries = 0
+}
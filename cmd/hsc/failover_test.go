@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	client "github.com/mike76-dev/hostscore/api"
+	"go.uber.org/zap"
+)
+
+// statusServer returns a node that reports the given height in its status
+// and counts the other requests.
+func statusServer(t *testing.T, height *atomic.Uint64, other *atomic.Int32) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/node/status" {
+			other.Add(1)
+			http.NotFound(w, req)
+			return
+		}
+		json.NewEncoder(w).Encode(client.NodeStatusResponse{
+			Height:    height.Load(),
+			HeightZen: height.Load(),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return client.NewClient(srv.URL, "")
+}
+
+func TestFailover(t *testing.T) {
+	var primaryHeight, standbyHeight atomic.Uint64
+	var other atomic.Int32
+	primary := statusServer(t, &primaryHeight, &other)
+	standby := statusServer(t, &standbyHeight, &other)
+	standbyHeight.Store(100)
+
+	fm := newFailoverManager(zap.NewNop())
+	fm.addStandby("eu", standby, time.Nanosecond)
+
+	// A primary node that keeps sending the same batch, or empty ones,
+	// has stopped delivering.
+	fm.update("eu", primary, 5, 10, nil)
+	fm.update("eu", primary, 5, 10, nil)
+	fm.update("eu", primary, 6, 0, nil)
+	if fm.onStandby("eu") {
+		t.Fatal("switched to the standby node too early")
+	}
+
+	// A poll must stick to the node it started with, even if the nodes
+	// are switched in the middle of it.
+	src, onStandby := fm.source("eu", primary)
+	fm.update("eu", primary, 6, 10, nil)
+	if !fm.onStandby("eu") {
+		t.Fatal("expected to switch to the standby node")
+	}
+	if src != primary || onStandby {
+		t.Fatal("expected the poll to keep the primary node")
+	}
+	if src, onStandby := fm.source("eu", primary); src != standby || !onStandby {
+		t.Fatal("expected the next poll to use the standby node")
+	}
+
+	// The primary node must catch up with the standby node several times
+	// in a row before hsc switches back.
+	for i := 0; i < failoverChecks; i++ {
+		fm.update("eu", primary, uint64(i+1), 10, nil)
+	}
+	if !fm.onStandby("eu") {
+		t.Fatal("switched back to a primary node that is behind")
+	}
+	primaryHeight.Store(100)
+	for i := 0; i < failoverChecks-1; i++ {
+		fm.update("eu", primary, uint64(i+10), 10, nil)
+	}
+	if !fm.onStandby("eu") {
+		t.Fatal("switched back to the primary node too early")
+	}
+	fm.update("eu", primary, 20, 10, nil)
+	if fm.onStandby("eu") {
+		t.Fatal("expected to switch back to the primary node")
+	}
+
+	// The probes must not create batches of updates on the nodes.
+	if n := other.Load(); n != 0 {
+		t.Fatalf("expected only status requests, got %d others", n)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// federationInterval determines how often the summaries are pulled
+	// from the peer portals.
+	federationInterval = 30 * time.Minute
+
+	// federationMaxAge is the age after which the scores received from
+	// a peer portal are not taken into account anymore.
+	federationMaxAge = 24 * time.Hour
+)
+
+// federationPeer is another portal whose nodes are counted as additional
+// vantage points.
+type federationPeer struct {
+	Name      string          `json:"name"`
+	URL       string          `json:"url"`
+	PublicKey types.PublicKey `json:"publicKey"`
+}
+
+type federationConfig struct {
+	Name  string           `json:"name"`
+	Peers []federationPeer `json:"peers"`
+}
+
+// federation holds the identity of the portal and the scores received from
+// the peer portals.
+type federation struct {
+	name  string
+	key   types.PrivateKey
+	peers []federationPeer
+	mu    sync.Mutex
+	// last contains the time when each peer was last synced successfully.
+	last map[string]time.Time
+}
+
+// federatedScore is a score of a host as seen by a node of a peer portal.
+type federatedScore struct {
+	Portal    string         `json:"portal"`
+	Node      string         `json:"node"`
+	Score     scoreBreakdown `json:"score"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+type summaryHost struct {
+	PublicKey types.PublicKey           `json:"publicKey"`
+	Nodes     map[string]scoreBreakdown `json:"nodes"`
+}
+
+// federationSummary contains the scores of the hosts as seen by the own
+// nodes of a portal.
+type federationSummary struct {
+	Portal    string          `json:"portal"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Network   string          `json:"network"`
+	Timestamp time.Time       `json:"timestamp"`
+	Hosts     []summaryHost   `json:"hosts"`
+}
+
+// signedSummary carries the summary as raw bytes, so that the signature
+// can be verified without re-encoding it.
+type signedSummary struct {
+	Summary   json.RawMessage `json:"summary"`
+	Signature types.Signature `json:"signature"`
+}
+
+// loadFederation loads the federation config and the portal key from the
+// directory. If there is no config, federation is disabled and nil is
+// returned. A new key is generated if there is none yet.
+func loadFederation(dir string) (*federation, error) {
+	js, err := os.ReadFile(filepath.Join(dir, "federation.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var fc federationConfig
+	if err := json.Unmarshal(js, &fc); err != nil {
+		return nil, utils.AddContext(err, "couldn't decode federation config")
+	}
+	if fc.Name == "" {
+		return nil, errors.New("portal name not set in federation config")
+	}
+
+	keyPath := filepath.Join(dir, "federation.key")
+	var key types.PrivateKey
+	if b, err := os.ReadFile(keyPath); os.IsNotExist(err) {
+		key = types.GeneratePrivateKey()
+		if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(key)), 0600); err != nil {
+			return nil, utils.AddContext(err, "couldn't save federation key")
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		key, err = hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(key) != 64 {
+			return nil, errors.New("invalid federation key")
+		}
+	}
+
+	for i := range fc.Peers {
+		fc.Peers[i].URL = strings.TrimSuffix(fc.Peers[i].URL, "/")
+	}
+
+	return &federation{
+		name:  fc.Name,
+		key:   key,
+		peers: fc.Peers,
+		last:  make(map[string]time.Time),
+	}, nil
+}
+
+// summary creates a signed summary of the scores calculated by the own
+// nodes. The scores received from the peers are not included, so that
+// they don't travel in circles.
+func (api *portalAPI) summary(network string) (signedSummary, error) {
+	fs := federationSummary{
+		Portal:    api.federation.name,
+		PublicKey: api.federation.key.PublicKey(),
+		Network:   network,
+		Timestamp: time.Now(),
+	}
+
+	for pk, host := range api.hosts.load()[network] {
+		sh := summaryHost{
+			PublicKey: pk,
+			Nodes:     make(map[string]scoreBreakdown),
+		}
+		for node, interactions := range host.Interactions {
+			sh.Nodes[node] = interactions.Score
+		}
+		if len(sh.Nodes) > 0 {
+			fs.Hosts = append(fs.Hosts, sh)
+		}
+	}
+
+	js, err := json.Marshal(fs)
+	if err != nil {
+		return signedSummary{}, err
+	}
+	return signedSummary{
+		Summary:   js,
+		Signature: api.federation.key.SignHash(types.HashBytes(js)),
+	}, nil
+}
+
+// fetchSummary retrieves a summary from the peer and verifies it.
+func fetchSummary(peer federationPeer, network string) (fs federationSummary, err error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(fmt.Sprintf("%s%s/federation/summary?network=%s", peer.URL, apiPrefix, network))
+	if err != nil {
+		return federationSummary{}, utils.AddContext(err, "couldn't query peer")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return federationSummary{}, fmt.Errorf("peer returned %s", resp.Status)
+	}
+
+	var ss signedSummary
+	if err := json.NewDecoder(resp.Body).Decode(&ss); err != nil {
+		return federationSummary{}, utils.AddContext(err, "couldn't decode summary")
+	}
+	if !peer.PublicKey.VerifyHash(types.HashBytes(ss.Summary), ss.Signature) {
+		return federationSummary{}, errors.New("invalid signature")
+	}
+	if err := json.Unmarshal(ss.Summary, &fs); err != nil {
+		return federationSummary{}, utils.AddContext(err, "couldn't decode summary")
+	}
+
+	switch {
+	case fs.PublicKey != peer.PublicKey:
+		return federationSummary{}, errors.New("summary signed by a different portal")
+	case fs.Network != network:
+		return federationSummary{}, errors.New("wrong network")
+	case time.Since(fs.Timestamp) > federationMaxAge || time.Until(fs.Timestamp) > time.Hour:
+		return federationSummary{}, errors.New("summary timestamp out of range")
+	}
+
+	return fs, nil
+}
+
+// mergeSummary replaces the scores previously received from the peer with
+// the new ones and recalculates the scores of the hosts.
+func (api *portalAPI) mergeSummary(peer federationPeer, fs federationSummary) {
+	scores := make(map[types.PublicKey][]federatedScore)
+	for _, sh := range fs.Hosts {
+		for node, sb := range sh.Nodes {
+			scores[sh.PublicKey] = append(scores[sh.PublicKey], federatedScore{
+				Portal:    peer.Name,
+				Node:      node,
+				Score:     sb,
+				Timestamp: fs.Timestamp,
+			})
+		}
+	}
+
+	api.hosts.update(func(w *hostWriter) {
+		for pk, host := range w.network(fs.Network) {
+			var federated []federatedScore
+			for _, f := range host.Federated {
+				if f.Portal != peer.Name && time.Since(f.Timestamp) <= federationMaxAge {
+					federated = append(federated, f)
+				}
+			}
+			federated = append(federated, scores[pk]...)
+			if len(federated) == 0 && len(host.Federated) == 0 {
+				continue
+			}
+			host, _ = w.edit(fs.Network, pk)
+			host.Federated = federated
+			updateGlobalScore(host, fs.Network)
+		}
+	})
+	api.rankPending.Store(true)
+}
+
+// syncFederation pulls the summaries from the peer portals. A peer that
+// can't be reached doesn't prevent the others from being synced.
+func (api *portalAPI) syncFederation() error {
+	var errs []error
+	for _, peer := range api.federation.peers {
+		for _, network := range []string{"mainnet", "zen"} {
+			fs, err := fetchSummary(peer, network)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("couldn't fetch %s summary from %s: %w", network, peer.Name, err))
+				continue
+			}
+			api.mergeSummary(peer, fs)
+			api.federation.mu.Lock()
+			api.federation.last[peer.Name] = time.Now()
+			api.federation.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type federationPeerStatus struct {
+	Name       string          `json:"name"`
+	URL        string          `json:"url"`
+	PublicKey  types.PublicKey `json:"publicKey"`
+	LastSynced time.Time       `json:"lastSynced"`
+}
+
+type federationInfoResponse struct {
+	Portal    string                 `json:"portal"`
+	PublicKey types.PublicKey        `json:"publicKey"`
+	Peers     []federationPeerStatus `json:"peers"`
+}
+
+func (api *portalAPI) federationInfoHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if api.federation == nil {
+		writeError(w, "federation not enabled", http.StatusNotFound)
+		return
+	}
+
+	resp := federationInfoResponse{
+		Portal:    api.federation.name,
+		PublicKey: api.federation.key.PublicKey(),
+	}
+	api.federation.mu.Lock()
+	for _, peer := range api.federation.peers {
+		resp.Peers = append(resp.Peers, federationPeerStatus{
+			Name:       peer.Name,
+			URL:        peer.URL,
+			PublicKey:  peer.PublicKey,
+			LastSynced: api.federation.last[peer.Name],
+		})
+	}
+	api.federation.mu.Unlock()
+
+	writeJSON(w, resp)
+}
+
+func (api *portalAPI) federationSummaryHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if api.federation == nil {
+		writeError(w, "federation not enabled", http.StatusNotFound)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+
+	ss, err := api.summary(network)
+	if err != nil {
+		api.log.Error("couldn't create federation summary", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, ss)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mike76-dev/hostscore/external"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.uber.org/zap"
+)
+
+// geoIPRefreshInterval determines how often the GeoIP database files are
+// checked for updates, e.g. by geoipupdate.
+const geoIPRefreshInterval = time.Hour
+
+// geoIPConfig contains the paths to the local MaxMind database files.
+// The City database provides the location, the ASN database the ISP.
+type geoIPConfig struct {
+	City string `json:"city"`
+	ASN  string `json:"asn,omitempty"`
+}
+
+func (gc *geoIPConfig) validate() error {
+	if gc.City == "" {
+		return errors.New("GeoIP City database not provided")
+	}
+	return nil
+}
+
+// geoIPFile is a database file loaded into memory.
+type geoIPFile struct {
+	path    string
+	db      *external.GeoIPDB
+	modTime time.Time
+}
+
+// reload loads the file if it has changed since it was last loaded. It
+// returns true if it has been reloaded.
+func (gf *geoIPFile) reload() (bool, error) {
+	if gf.path == "" {
+		return false, nil
+	}
+	fi, err := os.Stat(gf.path)
+	if err != nil {
+		return false, err
+	}
+	if gf.db != nil && fi.ModTime().Equal(gf.modTime) {
+		return false, nil
+	}
+	db, err := external.OpenGeoIPDB(gf.path)
+	if err != nil {
+		return false, utils.AddContext(err, "couldn't open "+gf.path)
+	}
+	gf.db, gf.modTime = db, fi.ModTime()
+	return true, nil
+}
+
+// geoIP looks the hosts up in the local databases.
+type geoIP struct {
+	mu   sync.RWMutex
+	city geoIPFile
+	asn  geoIPFile
+	log  *zap.Logger
+}
+
+func newGeoIP(gc *geoIPConfig, logger *zap.Logger) (*geoIP, error) {
+	g := &geoIP{
+		city: geoIPFile{path: gc.City},
+		asn:  geoIPFile{path: gc.ASN},
+		log:  logger,
+	}
+	if err := g.refresh(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// refresh reloads the database files that have changed. A file that
+// fails to load is kept in its previous version.
+func (g *geoIP) refresh() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var errs []error
+	for _, gf := range []*geoIPFile{&g.city, &g.asn} {
+		reloaded, err := gf.reload()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if reloaded {
+			g.log.Info("GeoIP database loaded", zap.String("type", gf.db.Type), zap.Time("built", gf.db.BuildTime))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lookup returns the location of the IP address. It returns false if the
+// address is not in the City database.
+func (g *geoIP) lookup(ip string) (external.IPInfo, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.city.db == nil {
+		return external.IPInfo{}, false
+	}
+	info, err := g.city.db.Lookup(ip)
+	if err != nil {
+		return external.IPInfo{}, false
+	}
+	if g.asn.db != nil {
+		if asnInfo, err := g.asn.db.Lookup(ip); err == nil {
+			info.ISP, info.ASN = asnInfo.ISP, asnInfo.ASN
+		}
+	}
+	return info, true
+}
+
+// fetchLocation returns the location of the host. The local GeoIP
+// databases are tried first, if configured, and the IPInfo API is the
+// fallback.
+func (api *portalAPI) fetchLocation(addr string) (external.IPInfo, error) {
+	if api.geoip == nil {
+		return external.FetchIPInfo(addr, api.token)
+	}
+	ip, err := external.ResolveIP(addr)
+	if err != nil || ip == "" {
+		return external.IPInfo{}, err
+	}
+	if info, ok := api.geoip.lookup(ip); ok {
+		return info, nil
+	}
+	return external.FetchIPInfoByIP(ip, api.token)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/external"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// exportFlushInterval is the number of hosts written between the flushes
+// of the export stream.
+const exportFlushInterval = 100
+
+// exportedHost is a host record in the bulk export. The prices are in
+// Hastings, the storage in bytes.
+type exportedHost struct {
+	ID          int                `json:"id"`
+	Rank        int                `json:"rank"`
+	PublicKey   types.PublicKey    `json:"publicKey"`
+	NetAddress  string             `json:"netAddress"`
+	FirstSeen   time.Time          `json:"firstSeen"`
+	Online      bool               `json:"online"`
+	Quarantined bool               `json:"quarantined"`
+	OptOut      hostdb.OptOutLevel `json:"optOut,omitempty"`
+	Tags        []string           `json:"tags"`
+	Score       scoreBreakdown     `json:"score"`
+	Settings    rhpv2.HostSettings `json:"settings"`
+	Location    external.IPInfo    `json:"location"`
+}
+
+// hostCSVHeader lists the columns of the CSV export.
+var hostCSVHeader = []string{
+	"id", "rank", "public_key", "net_address", "first_seen", "online",
+	"quarantined", "opt_out", "tags", "prices_score", "storage_score",
+	"collateral_score", "interactions_score", "uptime_score", "age_score",
+	"version_score", "latency_score", "benchmarks_score",
+	"contracts_score", "duration_score", "total_score", "accepting_contracts",
+	"max_duration", "window_size", "total_storage", "remaining_storage",
+	"storage_price", "upload_price", "download_price", "contract_price",
+	"base_rpc_price", "sector_access_price", "collateral",
+	"max_collateral", "version", "release", "ip", "country", "region",
+	"city", "loc", "isp", "asn", "time_zone",
+}
+
+// csvRecord returns the host as a CSV row matching hostCSVHeader.
+func (eh exportedHost) csvRecord() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	return []string{
+		strconv.Itoa(eh.ID),
+		strconv.Itoa(eh.Rank),
+		eh.PublicKey.String(),
+		eh.NetAddress,
+		eh.FirstSeen.UTC().Format(time.RFC3339),
+		strconv.FormatBool(eh.Online),
+		strconv.FormatBool(eh.Quarantined),
+		string(eh.OptOut),
+		strings.Join(eh.Tags, ";"),
+		f(eh.Score.PricesScore),
+		f(eh.Score.StorageScore),
+		f(eh.Score.CollateralScore),
+		f(eh.Score.InteractionsScore),
+		f(eh.Score.UptimeScore),
+		f(eh.Score.AgeScore),
+		f(eh.Score.VersionScore),
+		f(eh.Score.LatencyScore),
+		f(eh.Score.BenchmarksScore),
+		f(eh.Score.ContractsScore),
+		f(eh.Score.DurationScore),
+		f(eh.Score.TotalScore),
+		strconv.FormatBool(eh.Settings.AcceptingContracts),
+		strconv.FormatUint(eh.Settings.MaxDuration, 10),
+		strconv.FormatUint(eh.Settings.WindowSize, 10),
+		strconv.FormatUint(eh.Settings.TotalStorage, 10),
+		strconv.FormatUint(eh.Settings.RemainingStorage, 10),
+		eh.Settings.StoragePrice.ExactString(),
+		eh.Settings.UploadBandwidthPrice.ExactString(),
+		eh.Settings.DownloadBandwidthPrice.ExactString(),
+		eh.Settings.ContractPrice.ExactString(),
+		eh.Settings.BaseRPCPrice.ExactString(),
+		eh.Settings.SectorAccessPrice.ExactString(),
+		eh.Settings.Collateral.ExactString(),
+		eh.Settings.MaxCollateral.ExactString(),
+		eh.Settings.Version,
+		eh.Settings.Release,
+		eh.Location.IP,
+		eh.Location.Country,
+		eh.Location.Region,
+		eh.Location.City,
+		eh.Location.Location,
+		eh.Location.ISP,
+		eh.Location.ASN,
+		eh.Location.TimeZone,
+	}
+}
+
+// getExportedHosts returns all listed hosts of the network in the order
+// of their rank.
+func (api *portalAPI) getExportedHosts(network string, all bool) ([]exportedHost, error) {
+	locations, err := api.getFullLocations(network)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []exportedHost
+	for _, host := range api.hosts.load()[network] {
+		if host.OptOut == hostdb.OptOutDelist {
+			continue
+		}
+		online := isOnline(*host)
+		if !all && (!online || host.Quarantined) {
+			continue
+		}
+		hosts = append(hosts, exportedHost{
+			ID:          host.ID,
+			Rank:        host.Rank,
+			PublicKey:   host.PublicKey,
+			NetAddress:  host.NetAddress,
+			FirstSeen:   host.FirstSeen,
+			Online:      online,
+			Quarantined: host.Quarantined,
+			OptOut:      host.OptOut,
+			Tags:        host.Tags,
+			Score:       host.Score,
+			Settings:    host.Settings,
+			Location:    locations[host.PublicKey],
+		})
+	}
+
+	slices.SortFunc(hosts, func(a, b exportedHost) int {
+		return a.Rank - b.Rank
+	})
+
+	return hosts, nil
+}
+
+// getFullLocations loads the locations of all hosts of the network.
+func (api *portalAPI) getFullLocations(network string) (map[types.PublicKey]external.IPInfo, error) {
+	rows, err := api.db.Query(`
+		SELECT
+			public_key,
+			ip,
+			host_name,
+			city,
+			region,
+			country,
+			loc,
+			isp,
+			zip,
+			time_zone,
+			asn
+		FROM locations
+		WHERE network = ?
+	`, network)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query locations")
+	}
+	defer rows.Close()
+
+	locations := make(map[types.PublicKey]external.IPInfo)
+	for rows.Next() {
+		pk := make([]byte, 32)
+		var info external.IPInfo
+		if err := rows.Scan(
+			&pk,
+			&info.IP,
+			&info.HostName,
+			&info.City,
+			&info.Region,
+			&info.Country,
+			&info.Location,
+			&info.ISP,
+			&info.ZIP,
+			&info.TimeZone,
+			&info.ASN,
+		); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode location")
+		}
+		if info.ASN == "" {
+			info.ASN = external.ParseASN(info.ISP)
+		}
+		locations[types.PublicKey(pk)] = info
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load locations")
+	}
+
+	return locations, nil
+}
+
+func (api *portalAPI) hostsExportHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(req.FormValue("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "jsonl" {
+		writeError(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+	all := strings.ToLower(req.FormValue("all")) == "true"
+
+	hosts, err := api.getExportedHosts(network, all)
+	if err != nil {
+		api.log.Error("couldn't export hosts", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "hosts-" + network + "." + format
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	flusher, _ := w.(http.Flusher)
+
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for i, host := range hosts {
+			if err := enc.Encode(host); err != nil {
+				api.log.Debug("couldn't stream hosts", zap.Error(err))
+				return
+			}
+			if flusher != nil && (i+1)%exportFlushInterval == 0 {
+				flusher.Flush()
+			}
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	if err := cw.Write(hostCSVHeader); err != nil {
+		api.log.Debug("couldn't stream hosts", zap.Error(err))
+		return
+	}
+	for i, host := range hosts {
+		if err := cw.Write(host.csvRecord()); err != nil {
+			api.log.Debug("couldn't stream hosts", zap.Error(err))
+			return
+		}
+		if (i+1)%exportFlushInterval == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		api.log.Debug("couldn't stream hosts", zap.Error(err))
+	}
+}
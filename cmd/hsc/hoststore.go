@@ -0,0 +1,222 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"go.sia.tech/core/types"
+)
+
+// hostSet contains the hosts of each network.
+type hostSet map[string]map[types.PublicKey]*portalHost
+
+// newHostSet returns an empty set of hosts.
+func newHostSet() hostSet {
+	return hostSet{
+		"mainnet": make(map[types.PublicKey]*portalHost),
+		"zen":     make(map[types.PublicKey]*portalHost),
+	}
+}
+
+// hostStore keeps the hosts in memory. It is copy-on-write: a published
+// set of hosts is never modified, so the readers load it without a lock
+// and never wait for the writers. The writers are serialized; each of
+// them modifies the copies of the hosts it changes and publishes a new
+// set when it is done.
+type hostStore struct {
+	mu      sync.Mutex
+	current atomic.Pointer[hostSet]
+	index   atomic.Pointer[hostIndex]
+}
+
+// hostIndex keeps the hosts of a published set sorted by the keys the
+// cursors point at, so that a page can be found without sorting all of
+// them. It is built lazily and dropped together with the set.
+type hostIndex struct {
+	hosts  *hostSet
+	mu     sync.Mutex
+	sorted map[hostIndexKey][]*portalHost
+}
+
+// hostIndexKey identifies a sorted list of hosts in the index.
+type hostIndexKey struct {
+	network string
+	sortBy  sortType
+}
+
+// newHostStore returns a store holding the given hosts.
+func newHostStore(hosts hostSet) *hostStore {
+	hs := &hostStore{}
+	hs.current.Store(&hosts)
+	return hs
+}
+
+// load returns the current set of hosts. Neither the set nor the hosts
+// may be modified.
+func (hs *hostStore) load() hostSet {
+	return *hs.current.Load()
+}
+
+// replace publishes a new set of hosts, e.g. after they have been
+// reloaded from the database.
+func (hs *hostStore) replace(hosts hostSet) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.current.Store(&hosts)
+	hs.index.Store(nil)
+}
+
+// sorted returns the hosts of the network from the current set, sorted by
+// their ranks or IDs in the ascending order. The hosts with equal ranks
+// are ordered by their IDs. The list is sorted once per published set and
+// must not be modified.
+func (hs *hostStore) sorted(network string, sortBy sortType) []*portalHost {
+	hosts := hs.current.Load()
+	idx := hs.index.Load()
+	if idx == nil || idx.hosts != hosts {
+		fresh := &hostIndex{
+			hosts:  hosts,
+			sorted: make(map[hostIndexKey][]*portalHost),
+		}
+		if hs.index.CompareAndSwap(idx, fresh) {
+			idx = fresh
+		} else if idx = hs.index.Load(); idx == nil || idx.hosts != hosts {
+			// A newer set has been published meanwhile, so this one
+			// isn't worth keeping.
+			idx = fresh
+		}
+	}
+
+	key := hostIndexKey{network: network, sortBy: sortBy}
+	idx.mu.Lock()
+	sorted, ok := idx.sorted[key]
+	idx.mu.Unlock()
+	if ok {
+		return sorted
+	}
+
+	sorted = slices.Collect(maps.Values((*hosts)[network]))
+	slices.SortFunc(sorted, func(a, b *portalHost) int {
+		if sortBy == sortByRank && a.Rank != b.Rank {
+			return a.Rank - b.Rank
+		}
+		return a.ID - b.ID
+	})
+	idx.mu.Lock()
+	idx.sorted[key] = sorted
+	idx.mu.Unlock()
+	return sorted
+}
+
+// write starts modifying the hosts. The other writers wait until the
+// changes are published or discarded.
+func (hs *hostStore) write() *hostWriter {
+	hs.mu.Lock()
+	base := hs.load()
+	return &hostWriter{
+		store:  hs,
+		hosts:  maps.Clone(base),
+		copied: make(map[string]bool),
+		owned:  make(map[*portalHost]bool),
+	}
+}
+
+// update modifies the hosts with fn and publishes the changes.
+func (hs *hostStore) update(fn func(w *hostWriter)) {
+	w := hs.write()
+	fn(w)
+	w.publish()
+}
+
+// hostWriter modifies the copies of the hosts. The networks are copied
+// the first time a host is added to them or changed, and the hosts the
+// first time they are changed.
+type hostWriter struct {
+	store  *hostStore
+	hosts  hostSet
+	copied map[string]bool
+	owned  map[*portalHost]bool
+	done   bool
+}
+
+// network returns the hosts of the network, which must not be modified
+// directly.
+func (w *hostWriter) network(network string) map[types.PublicKey]*portalHost {
+	return w.hosts[network]
+}
+
+// get returns the host, which must not be modified.
+func (w *hostWriter) get(network string, pk types.PublicKey) (*portalHost, bool) {
+	host, ok := w.hosts[network][pk]
+	return host, ok
+}
+
+// edit returns the copy of the host that can be modified.
+func (w *hostWriter) edit(network string, pk types.PublicKey) (*portalHost, bool) {
+	host, ok := w.hosts[network][pk]
+	if !ok {
+		return nil, false
+	}
+	if w.owned[host] {
+		return host, true
+	}
+	c := copyHost(host)
+	w.put(network, c)
+	return c, true
+}
+
+// put adds the host or replaces the existing one. The host must not be
+// shared with a published set.
+func (w *hostWriter) put(network string, host *portalHost) {
+	if !w.copied[network] {
+		w.hosts[network] = maps.Clone(w.hosts[network])
+		if w.hosts[network] == nil {
+			w.hosts[network] = make(map[types.PublicKey]*portalHost)
+		}
+		w.copied[network] = true
+	}
+	w.hosts[network][host.PublicKey] = host
+	w.owned[host] = true
+}
+
+// publish makes the changes visible to the readers.
+func (w *hostWriter) publish() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.store.current.Store(&w.hosts)
+	w.store.index.Store(nil)
+	w.store.mu.Unlock()
+}
+
+// discard drops the changes. It does nothing if they have been published
+// already.
+func (w *hostWriter) discard() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.store.mu.Unlock()
+}
+
+// copyHost returns a copy of the host that can be modified without
+// affecting the original. The slices are clipped, so that appending to
+// them doesn't write to the arrays shared with the original.
+func copyHost(host *portalHost) *portalHost {
+	c := *host
+	c.Interactions = maps.Clone(host.Interactions)
+	for node, interactions := range c.Interactions {
+		interactions.ScanHistory = slices.Clip(interactions.ScanHistory)
+		interactions.BenchmarkHistory = slices.Clip(interactions.BenchmarkHistory)
+		interactions.probes = slices.Clip(interactions.probes)
+		c.Interactions[node] = interactions
+	}
+	c.IPNets = slices.Clip(c.IPNets)
+	c.Tags = slices.Clip(c.Tags)
+	c.Federated = slices.Clip(c.Federated)
+	c.sybilSubnets = slices.Clip(c.sybilSubnets)
+	return &c
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestHostStoreCopyOnWrite(t *testing.T) {
+	const node = "global"
+	host := testHost(node, 2)
+	hs := newHostStore(hostSet{
+		"mainnet": {host.PublicKey: host},
+		"zen":     {},
+	})
+
+	// The readers don't wait for a writer in progress and see the hosts
+	// as they were before.
+	w := hs.write()
+	edited, _ := w.edit("mainnet", host.PublicKey)
+	edited.Rank = 1
+	interactions := edited.Interactions[node]
+	interactions.ScanHistory = append(interactions.ScanHistory, portalScan{Timestamp: time.Now()})
+	edited.Interactions[node] = interactions
+
+	loaded := make(chan hostSet)
+	go func() { loaded <- hs.load() }()
+	select {
+	case hosts := <-loaded:
+		if hosts["mainnet"][host.PublicKey] != host {
+			t.Fatal("reader saw unpublished changes")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader blocked by writer")
+	}
+	if host.Rank != 0 || len(host.Interactions[node].ScanHistory) != 2 {
+		t.Fatal("published host modified")
+	}
+
+	// Discarded changes are never published.
+	w.discard()
+	if hs.load()["mainnet"][host.PublicKey] != host {
+		t.Fatal("discarded changes published")
+	}
+
+	hs.update(func(w *hostWriter) {
+		edited, _ := w.edit("mainnet", host.PublicKey)
+		edited.Rank = 1
+	})
+	if hs.load()["mainnet"][host.PublicKey].Rank != 1 || host.Rank != 0 {
+		t.Fatal("changes not published on a copy")
+	}
+}
+
+func TestHostStoreSorted(t *testing.T) {
+	hosts := make(map[types.PublicKey]*portalHost)
+	for i := 1; i <= 10; i++ {
+		host := testHost("global", 0)
+		host.ID = i
+		host.Rank = 11 - i
+		hosts[host.PublicKey] = host
+	}
+	hs := newHostStore(hostSet{"mainnet": hosts, "zen": {}})
+
+	// The index is kept until another set is published.
+	byRank := hs.sorted("mainnet", sortByRank)
+	if &hs.sorted("mainnet", sortByRank)[0] != &byRank[0] {
+		t.Fatal("index rebuilt for the same set")
+	}
+	hs.update(func(w *hostWriter) {
+		for _, host := range byRank {
+			edited, _ := w.edit("mainnet", host.PublicKey)
+			edited.Rank = host.ID
+		}
+	})
+	byRank = hs.sorted("mainnet", sortByRank)
+	for i, host := range byRank {
+		if host.Rank != i+1 || host.ID != i+1 {
+			t.Fatalf("expected rank %d at %d, got %d", i+1, i, host.Rank)
+		}
+	}
+
+	even := func(host *portalHost) bool { return host.ID%2 == 0 }
+	ids := func(page []portalHost) (ids []int) {
+		for _, host := range page {
+			ids = append(ids, host.ID)
+		}
+		return
+	}
+	tests := []struct {
+		offset, after, limit int
+		asc                  bool
+		want                 []int
+		more                 bool
+	}{
+		{offset: 0, after: 0, limit: 2, asc: true, want: []int{2, 4}, more: true},
+		{offset: 1, after: 0, limit: -1, asc: true, want: []int{4, 6, 8, 10}},
+		{offset: 3, after: 4, limit: 2, asc: true, want: []int{6, 8}, more: true},
+		{offset: 0, after: 7, limit: 2, asc: true, want: []int{8, 10}},
+		{offset: 0, after: 10, limit: 2, asc: true},
+		{offset: 0, after: 8, limit: 2, asc: false, want: []int{6, 4}, more: true},
+		{offset: 0, after: 3, limit: 2, asc: false, want: []int{2}},
+		{offset: 0, after: 11, limit: 1, asc: false, want: []int{10}, more: true},
+	}
+	for _, tt := range tests {
+		page, more, total := pageHosts(byRank, even, tt.offset, tt.after, tt.limit, sortByRank, tt.asc)
+		if !slices.Equal(ids(page), tt.want) || more != tt.more || total != 5 {
+			t.Errorf("offset %d after %d limit %d asc %v: expected %v (more %v), got %v (more %v, total %d)",
+				tt.offset, tt.after, tt.limit, tt.asc, tt.want, tt.more, ids(page), more, total)
+		}
+	}
+}
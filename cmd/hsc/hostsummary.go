@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+)
+
+// hostSummary is the slim form of a host returned by /hosts with
+// view=summary. It leaves out the full settings, the price table, the
+// subnets, and the interactions with the nodes.
+type hostSummary struct {
+	ID                 int                `json:"id"`
+	Rank               int                `json:"rank"`
+	PublicKey          types.PublicKey    `json:"publicKey"`
+	NetAddress         string             `json:"netaddress"`
+	FirstSeen          time.Time          `json:"firstSeen"`
+	Online             bool               `json:"online"`
+	Quarantined        bool               `json:"quarantined"`
+	OptOut             hostdb.OptOutLevel `json:"optOut,omitempty"`
+	Tags               []string           `json:"tags"`
+	Score              scoreBreakdown     `json:"score"`
+	AcceptingContracts bool               `json:"acceptingContracts"`
+	TotalStorage       uint64             `json:"totalStorage"`
+	RemainingStorage   uint64             `json:"remainingStorage"`
+	StoragePrice       types.Currency     `json:"storagePrice"`
+	Collateral         types.Currency     `json:"collateral"`
+	UploadPrice        types.Currency     `json:"uploadPrice"`
+	DownloadPrice      types.Currency     `json:"downloadPrice"`
+	ContractPrice      types.Currency     `json:"contractPrice"`
+	Version            string             `json:"version"`
+	Release            string             `json:"release"`
+	Country            string             `json:"country"`
+}
+
+type hostSummariesResponse struct {
+	Hosts []hostSummary `json:"hosts"`
+	More  bool          `json:"more"`
+	Total int           `json:"total"`
+	Next  int           `json:"next,omitempty"`
+}
+
+func summarizeHost(host portalHost) hostSummary {
+	return hostSummary{
+		ID:                 host.ID,
+		Rank:               host.Rank,
+		PublicKey:          host.PublicKey,
+		NetAddress:         host.NetAddress,
+		FirstSeen:          host.FirstSeen,
+		Online:             isOnline(host),
+		Quarantined:        host.Quarantined,
+		OptOut:             host.OptOut,
+		Tags:               host.Tags,
+		Score:              host.Score,
+		AcceptingContracts: host.Settings.AcceptingContracts,
+		TotalStorage:       host.Settings.TotalStorage,
+		RemainingStorage:   host.Settings.RemainingStorage,
+		StoragePrice:       host.Settings.StoragePrice,
+		Collateral:         host.Settings.Collateral,
+		UploadPrice:        host.Settings.UploadBandwidthPrice,
+		DownloadPrice:      host.Settings.DownloadBandwidthPrice,
+		ContractPrice:      host.Settings.ContractPrice,
+		Version:            host.Settings.Version,
+		Release:            host.Settings.Release,
+		Country:            host.Country,
+	}
+}
+
+// writeHostsPage writes a page of hosts in the requested view.
+func writeHostsPage(w http.ResponseWriter, req *http.Request, summary bool, hosts []portalHost, more bool, total int, sortBy sortType) {
+	if !summary {
+		writeTaggedJSON(w, req, hostsResponse{
+			Hosts: hosts,
+			More:  more,
+			Total: total,
+			Next:  nextCursor(hosts, more, sortBy),
+		})
+		return
+	}
+	summaries := make([]hostSummary, 0, len(hosts))
+	for _, host := range hosts {
+		summaries = append(summaries, summarizeHost(host))
+	}
+	writeTaggedJSON(w, req, hostSummariesResponse{
+		Hosts: summaries,
+		More:  more,
+		Total: total,
+		Next:  nextCursor(hosts, more, sortBy),
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// hostTestCooldown is how long a client has to wait between two host
+	// tests.
+	hostTestCooldown = time.Minute
+
+	// maxHostTests is the number of host tests running at the same time
+	// across all nodes.
+	maxHostTests = 10
+)
+
+// hostTestResult is the result of a host test.
+type hostTestResult struct {
+	Node string          `json:"node"`
+	Scan hostdb.HostScan `json:"scan"`
+}
+
+// errTestTooSoon is returned when a client has run a test within
+// hostTestCooldown or is running one.
+var errTestTooSoon = errors.New("only one test per minute is allowed")
+
+// errNoTestNode is returned when no node can run a test.
+var errNoTestNode = errors.New("no node available, try again later")
+
+// hostTests keeps track of the running host tests, so that they are
+// spread across the nodes, and throttles the clients.
+type hostTests struct {
+	running map[string]int
+	total   int
+	clients map[string]time.Time
+	pending map[string]struct{}
+	mu      sync.Mutex
+}
+
+func newHostTests() *hostTests {
+	return &hostTests{
+		running: make(map[string]int),
+		clients: make(map[string]time.Time),
+		pending: make(map[string]struct{}),
+	}
+}
+
+// start picks the node running the fewest tests among the candidates for
+// the test of the client. The client can't start another test until this
+// one is done.
+func (ht *hostTests) start(client string, candidates []string) (string, error) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	for c, t := range ht.clients {
+		if time.Since(t) >= hostTestCooldown {
+			delete(ht.clients, c)
+		}
+	}
+	if _, ok := ht.clients[client]; ok {
+		return "", errTestTooSoon
+	}
+	if _, ok := ht.pending[client]; ok {
+		return "", errTestTooSoon
+	}
+	if ht.total >= maxHostTests || len(candidates) == 0 {
+		return "", errNoTestNode
+	}
+	node := candidates[0]
+	for _, c := range candidates[1:] {
+		if ht.running[c] < ht.running[node] || (ht.running[c] == ht.running[node] && c < node) {
+			node = c
+		}
+	}
+	ht.running[node]++
+	ht.total++
+	ht.pending[client] = struct{}{}
+	return node, nil
+}
+
+// done finishes the test of the client. The cooldown of the client only
+// starts if the node has run the test.
+func (ht *hostTests) done(client, node string, dispatched bool) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	ht.running[node]--
+	ht.total--
+	delete(ht.pending, client)
+	if dispatched {
+		ht.clients[client] = time.Now()
+	}
+}
+
+// hostsTestHandler scans the host from the least busy node and returns
+// the result. It doesn't hold the lock while the scan is running.
+func (api *portalAPI) hostsTestHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	h := req.FormValue("host")
+	if h == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if err := pk.UnmarshalText([]byte(h)); err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+
+	api.mu.RLock()
+	host, exists := api.hosts.load()[network][pk]
+	known := exists && host.OptOut != hostdb.OptOutDelist
+	var candidates []string
+	for node := range api.clients {
+		if api.nodes[node].Online {
+			candidates = append(candidates, node)
+		}
+	}
+	api.mu.RUnlock()
+	if !known {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	client := getRemoteHost(req)
+	node, err := api.tests.start(client, candidates)
+	if errors.Is(err, errTestTooSoon) {
+		writeError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		writeError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	var dispatched bool
+	defer func() { api.tests.done(client, node, dispatched) }()
+
+	c := api.failover.client(node, api.clients[node])
+	result, err := c.ScanHost(network, pk, false)
+	dispatched = err == nil
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, hostdb.ErrScanTooSoon.Error()):
+			writeError(w, "host was tested recently, try again later", http.StatusTooManyRequests)
+		case strings.Contains(msg, hostdb.ErrScanInProgress.Error()):
+			writeError(w, "host is being scanned, try again later", http.StatusConflict)
+		case strings.Contains(msg, hostdb.ErrScanNotAllowed.Error()), strings.Contains(msg, hostdb.ErrUnknownHost.Error()):
+			writeError(w, "host can't be tested", http.StatusBadRequest)
+		default:
+			api.log.Error("couldn't test host", zap.String("network", network), zap.String("node", node), zap.Stringer("host", pk), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, hostTestResult{
+		Node: node,
+		Scan: result.Scan,
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHostTestsCooldown(t *testing.T) {
+	ht := newHostTests()
+
+	// No node available: the client may try again right away.
+	if _, err := ht.start("client", nil); !errors.Is(err, errNoTestNode) {
+		t.Fatalf("expected errNoTestNode, got %v", err)
+	}
+	node, err := ht.start("client", []string{"b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node != "a" {
+		t.Fatalf("expected node a, got %s", node)
+	}
+
+	// The test is running, so the client has to wait.
+	if _, err := ht.start("client", []string{"a"}); !errors.Is(err, errTestTooSoon) {
+		t.Fatalf("expected errTestTooSoon, got %v", err)
+	}
+
+	// The node failed to run the test: no cooldown.
+	ht.done("client", node, false)
+	node, err = ht.start("client", []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The node ran the test: the cooldown starts.
+	ht.done("client", node, true)
+	if _, err := ht.start("client", []string{"a"}); !errors.Is(err, errTestTooSoon) {
+		t.Fatalf("expected errTestTooSoon, got %v", err)
+	}
+	if ht.total != 0 || ht.running["a"] != 0 {
+		t.Fatalf("expected no running tests, got %d", ht.total)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// idempotencyKeyHeader is the header carrying the idempotency key
+	// of a mutating request.
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotencyReplayedHeader is set on the responses replayed from
+	// an earlier request with the same key.
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength is the maximum length of an idempotency key.
+	maxIdempotencyKeyLength = 255
+
+	// maxIdempotentRequestSize is the maximum size of the body of a
+	// request with an idempotency key. It matches the largest request
+	// the portal accepts.
+	maxIdempotentRequestSize = maxTelemetrySize
+
+	// idempotencyKeyTTL is how long the responses are kept for replaying.
+	idempotencyKeyTTL = 24 * time.Hour
+
+	// idempotencyPruneInterval determines how often the expired keys
+	// are removed.
+	idempotencyPruneInterval = time.Hour
+)
+
+// errKeyExists is returned when an idempotency key has already been used.
+var errKeyExists = errors.New("idempotency key exists")
+
+// idempotentRecord is a request stored under an idempotency key. A zero
+// status means that the request is still being processed.
+type idempotentRecord struct {
+	fingerprint types.Hash256
+	status      int
+	contentType string
+	body        []byte
+}
+
+// responseRecorder captures the response, so that it can be replayed.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.status == 0 {
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// isMutating returns true if the request may change the state of the
+// portal.
+func isMutating(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// requestFingerprint returns the digest of the request, which identifies
+// it together with the idempotency key. The credentials are included, so
+// that a client can't get the response to the request of another client.
+func requestFingerprint(r *http.Request, body []byte) types.Hash256 {
+	return types.HashBytes(bytes.Join([][]byte{
+		[]byte(r.Header.Get("Authorization")),
+		[]byte(r.Header.Get(apiKeyHeader)),
+		[]byte(r.Method),
+		[]byte(r.URL.Path),
+		[]byte(r.URL.RawQuery),
+		body,
+	}, []byte{0}))
+}
+
+// serveIdempotent serves a mutating request carrying an idempotency key.
+// The first request with the key is passed to next, and its response is
+// stored. The retries with the same key get the stored response instead
+// of being applied again. Server errors are not stored, so that the
+// request can be retried.
+func (api *portalAPI) serveIdempotent(w http.ResponseWriter, r *http.Request, key string, next http.HandlerFunc) {
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, "idempotency key too long", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestSize))
+	if err != nil {
+		writeError(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := requestFingerprint(r, body)
+
+	err = api.reserveIdempotencyKey(key, fingerprint)
+	if errors.Is(err, errKeyExists) {
+		record, err := api.getIdempotencyKey(key)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The key has been released in the meantime.
+			writeError(w, "request in progress, try again", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			api.log.Error("couldn't retrieve idempotency key", zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if record.fingerprint != fingerprint {
+			writeError(w, "idempotency key reused with a different request", http.StatusUnprocessableEntity)
+			return
+		}
+		if record.status == 0 {
+			writeError(w, "request in progress, try again", http.StatusConflict)
+			return
+		}
+		if record.contentType != "" {
+			w.Header().Set("Content-Type", record.contentType)
+		}
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		w.WriteHeader(record.status)
+		w.Write(record.body)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't save idempotency key", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	rr := &responseRecorder{ResponseWriter: w}
+	next(rr, r)
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+
+	if rr.status >= http.StatusInternalServerError {
+		err = api.releaseIdempotencyKey(key)
+	} else {
+		err = api.completeIdempotencyKey(key, rr.status, w.Header().Get("Content-Type"), rr.body.Bytes())
+	}
+	if err != nil {
+		api.log.Error("couldn't update idempotency key", zap.Error(err))
+	}
+}
+
+// reserveIdempotencyKey stores the key of a request before the request is
+// processed. errKeyExists is returned if the key has already been used.
+func (api *portalAPI) reserveIdempotencyKey(key string, fingerprint types.Hash256) error {
+	// An expired key may be reused even before it is pruned.
+	_, err := api.db.Exec(`
+		DELETE FROM idempotency_keys
+		WHERE id_key = ?
+		AND created_at < ?
+	`, key, time.Now().Add(-idempotencyKeyTTL).Unix())
+	if err != nil {
+		return utils.AddContext(err, "couldn't delete expired idempotency key")
+	}
+
+	_, err = api.db.Exec(`
+		INSERT INTO idempotency_keys (
+			id_key,
+			fingerprint,
+			status,
+			content_type,
+			body,
+			created_at
+		)
+		VALUES (?, ?, 0, '', ?, ?)
+	`, key, fingerprint[:], []byte{}, time.Now().Unix())
+	if err == nil {
+		return nil
+	}
+
+	// The unique key violation is reported differently by each database,
+	// so check if the key is there instead.
+	var count int
+	if qErr := api.db.QueryRow("SELECT COUNT(*) FROM idempotency_keys WHERE id_key = ?", key).Scan(&count); qErr == nil && count > 0 {
+		return errKeyExists
+	}
+	return utils.AddContext(err, "couldn't insert idempotency key")
+}
+
+// getIdempotencyKey retrieves the request stored under the key.
+func (api *portalAPI) getIdempotencyKey(key string) (record idempotentRecord, err error) {
+	fp := make([]byte, 32)
+	err = api.db.QueryRow(`
+		SELECT fingerprint, status, content_type, body
+		FROM idempotency_keys
+		WHERE id_key = ?
+		AND created_at >= ?
+	`, key, time.Now().Add(-idempotencyKeyTTL).Unix()).Scan(&fp, &record.status, &record.contentType, &record.body)
+	copy(record.fingerprint[:], fp)
+	return
+}
+
+// completeIdempotencyKey stores the response to the request.
+func (api *portalAPI) completeIdempotencyKey(key string, status int, contentType string, body []byte) error {
+	_, err := api.db.Exec(`
+		UPDATE idempotency_keys
+		SET status = ?, content_type = ?, body = ?
+		WHERE id_key = ?
+	`, status, contentType, body, key)
+	return err
+}
+
+// releaseIdempotencyKey removes the key, so that the request can be
+// retried.
+func (api *portalAPI) releaseIdempotencyKey(key string) error {
+	_, err := api.db.Exec("DELETE FROM idempotency_keys WHERE id_key = ?", key)
+	return err
+}
+
+// pruneIdempotencyKeys removes the expired keys.
+func (api *portalAPI) pruneIdempotencyKeys() error {
+	_, err := api.db.Exec(`
+		DELETE FROM idempotency_keys
+		WHERE created_at < ?
+	`, time.Now().Add(-idempotencyKeyTTL).Unix())
+	return utils.AddContext(err, "unable to prune idempotency keys")
+}
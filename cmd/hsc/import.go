@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+)
+
+const (
+	// importRetries is the number of times an export page is requested
+	// before the import is aborted.
+	importRetries = 5
+
+	// importRetryInterval is the delay between the retries. The source
+	// portal applies a rate limit, so the requests need to be paced.
+	importRetryInterval = 5 * time.Second
+)
+
+// importPortal populates an empty database with the data exported by
+// another portal.
+func importPortal(db *sqldb.DB, from string) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM hosts").Scan(&count); err != nil {
+		return utils.AddContext(err, "couldn't count hosts")
+	}
+	if count > 0 {
+		return errors.New("database is not empty")
+	}
+
+	from = strings.TrimSuffix(from, "/")
+	client := &http.Client{Timeout: time.Minute}
+	for _, t := range exportTables {
+		n, err := importTable(db, client, from, t, 0, false)
+		if err != nil {
+			return utils.AddContext(err, "couldn't import "+t.name)
+		}
+		log.Printf("Imported %d rows into %s\n", n, t.name)
+	}
+
+	return nil
+}
+
+// importTable copies the rows of the table from the source portal,
+// starting after the provided cursor or offset. If upsert is true, the
+// existing rows are overwritten.
+func importTable(db *sqldb.DB, client *http.Client, from string, t exportTable, after int64, upsert bool) (n int, err error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(t.columns)), ", ")
+	query := "INSERT INTO " + t.name + " (" + strings.Join(t.columns, ", ") + ") VALUES (" + placeholders + ")"
+	if upsert {
+		updates := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			updates[i] = c + " = new." + c
+		}
+		query += " AS new ON DUPLICATE KEY (" + t.key + ") UPDATE " + strings.Join(updates, ", ")
+	}
+
+	for {
+		page, err := fetchExportPage(client, fmt.Sprintf("%s%s/export/%s?after=%d&limit=%d", from, apiPrefix, t.name, after, maxExportLimit))
+		if err != nil {
+			return n, err
+		}
+		if !slices.Equal(page.Columns, t.columns) {
+			return n, errors.New("table schemas don't match")
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return n, utils.AddContext(err, "couldn't start transaction")
+		}
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			tx.Rollback()
+			return n, utils.AddContext(err, "couldn't prepare statement")
+		}
+		for _, row := range page.Rows {
+			if len(row) != len(t.columns) {
+				stmt.Close()
+				tx.Rollback()
+				return n, errors.New("wrong number of values in a row")
+			}
+			args := make([]interface{}, len(row))
+			for i, v := range row {
+				if v != nil {
+					args[i] = *v
+				}
+			}
+			if _, err := stmt.Exec(args...); err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return n, utils.AddContext(err, "couldn't insert row")
+			}
+		}
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			return n, utils.AddContext(err, "couldn't commit transaction")
+		}
+
+		n += len(page.Rows)
+		if !page.More {
+			return n, nil
+		}
+		after = page.Next
+	}
+}
+
+// fetchExportPage requests a page of rows, retrying if the source portal
+// is rate-limiting the requests or cannot be reached.
+func fetchExportPage(client *http.Client, url string) (page exportResponse, err error) {
+	for attempt := 0; attempt < importRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(importRetryInterval)
+		}
+		var resp *http.Response
+		resp, err = client.Get(url)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			resp.Body.Close()
+			err = fmt.Errorf("source portal returned %s", resp.Status)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return exportResponse{}, fmt.Errorf("source portal returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return exportResponse{}, utils.AddContext(err, "couldn't decode response")
+		}
+		return page, nil
+	}
+	return exportResponse{}, utils.AddContext(err, "couldn't fetch rows")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+// errUnknownJob is returned when a job with the given name doesn't exist.
+var errUnknownJob = errors.New("unknown job")
+
+// job is a task that the portal runs periodically.
+type job struct {
+	name    string
+	next    func(time.Time) time.Time
+	fn      func() error
+	trigger chan struct{}
+
+	mu           sync.Mutex
+	running      bool
+	runs         uint64
+	failures     uint64
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    string
+	nextRun      time.Time
+}
+
+// jobStatus is the public state of a job.
+type jobStatus struct {
+	Name         string        `json:"name"`
+	Running      bool          `json:"running"`
+	Runs         uint64        `json:"runs"`
+	Failures     uint64        `json:"failures"`
+	LastRun      time.Time     `json:"lastRun"`
+	LastDuration time.Duration `json:"lastDuration"`
+	LastError    string        `json:"lastError,omitempty"`
+	NextRun      time.Time     `json:"nextRun"`
+}
+
+// jobScheduler runs the periodic jobs of the portal and keeps track of
+// their state.
+type jobScheduler struct {
+	jobs     map[string]*job
+	log      *zap.Logger
+	stopChan chan struct{}
+	mu       sync.RWMutex
+}
+
+func newJobScheduler(log *zap.Logger, stopChan chan struct{}) *jobScheduler {
+	return &jobScheduler{
+		jobs:     make(map[string]*job),
+		log:      log,
+		stopChan: stopChan,
+	}
+}
+
+// every returns a schedule that runs a job at a fixed interval after the
+// previous run has finished.
+func every(d time.Duration) func(time.Time) time.Time {
+	return func(t time.Time) time.Time {
+		return t.Add(d)
+	}
+}
+
+// aligned returns a schedule that runs a job at the multiples of d, e.g.
+// at the start of every hour.
+func aligned(d time.Duration) func(time.Time) time.Time {
+	return func(t time.Time) time.Time {
+		return t.Truncate(d).Add(d)
+	}
+}
+
+// add registers a job and starts it. The first run happens after the
+// delay, the following ones at the times returned by next.
+func (js *jobScheduler) add(name string, delay time.Duration, next func(time.Time) time.Time, fn func() error) {
+	j := &job{
+		name:    name,
+		next:    next,
+		fn:      fn,
+		trigger: make(chan struct{}, 1),
+		nextRun: time.Now().Add(delay),
+	}
+	js.mu.Lock()
+	js.jobs[name] = j
+	js.mu.Unlock()
+	go js.run(j)
+}
+
+// run executes the job until the portal shuts down.
+func (js *jobScheduler) run(j *job) {
+	for {
+		j.mu.Lock()
+		wait := time.Until(j.nextRun)
+		j.mu.Unlock()
+
+		select {
+		case <-js.stopChan:
+			return
+		case <-j.trigger:
+		case <-time.After(wait):
+		}
+
+		j.mu.Lock()
+		j.running = true
+		j.mu.Unlock()
+
+		start := time.Now()
+		err := j.fn()
+
+		j.mu.Lock()
+		j.running = false
+		j.runs++
+		j.lastRun = start
+		j.lastDuration = time.Since(start)
+		j.lastError = ""
+		if err != nil {
+			j.failures++
+			j.lastError = err.Error()
+		}
+		j.nextRun = j.next(time.Now())
+		j.mu.Unlock()
+
+		if err != nil {
+			js.log.Error("job failed", zap.String("job", j.name), zap.Error(err))
+		}
+	}
+}
+
+// trigger makes the job run as soon as possible. If the job is running,
+// it runs once more after it has finished.
+func (js *jobScheduler) trigger(name string) error {
+	js.mu.RLock()
+	j, ok := js.jobs[name]
+	js.mu.RUnlock()
+	if !ok {
+		return errUnknownJob
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+		// A run is already pending.
+	}
+	return nil
+}
+
+// status returns the state of all jobs, sorted by name.
+func (js *jobScheduler) status() []jobStatus {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+	statuses := make([]jobStatus, 0, len(js.jobs))
+	for _, j := range js.jobs {
+		j.mu.Lock()
+		statuses = append(statuses, jobStatus{
+			Name:         j.name,
+			Running:      j.running,
+			Runs:         j.runs,
+			Failures:     j.failures,
+			LastRun:      j.lastRun,
+			LastDuration: j.lastDuration,
+			LastError:    j.lastError,
+			NextRun:      j.nextRun,
+		})
+		j.mu.Unlock()
+	}
+	slices.SortFunc(statuses, func(a, b jobStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return statuses
+}
+
+func (api *portalAPI) adminJobsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	writeJSON(w, api.jobs.status())
+}
+
+func (api *portalAPI) adminJobsRunHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	err := api.jobs.trigger(ps.ByName("name"))
+	if errors.Is(err, errUnknownJob) {
+		writeError(w, "job not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+const (
+	// apiKeyHeader carries the API key of the client.
+	apiKeyHeader = "X-HostScore-API-Key"
+
+	// apiKeyPrefix makes the API keys easy to recognize, e.g. by the
+	// secret scanners.
+	apiKeyPrefix = "hs_"
+
+	// maxAPIKeyName is the maximum length of the API key name in bytes.
+	maxAPIKeyName = 64
+)
+
+// defaultRateLimitTiers are the rate limit tiers available if none are
+// configured, in requests per second.
+var defaultRateLimitTiers = map[string]int{
+	"standard": 50,
+	"premium":  200,
+}
+
+var (
+	errInvalidAPIKey = errors.New("invalid API key")
+	errAPIKeyUnknown = errors.New("API key not found")
+	errInvalidTier   = errors.New("unknown rate limit tier")
+)
+
+// apiKey identifies a client with a higher rate limit than anonymous
+// clients get.
+type apiKey struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Tier      string    `json:"tier"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type apiKeyRequest struct {
+	Name string `json:"name"`
+	Tier string `json:"tier"`
+}
+
+type apiKeyResponse struct {
+	apiKey
+	Key string `json:"key"`
+}
+
+// keyStore keeps the API keys, indexed by their hashes. The keys
+// themselves are not stored anywhere.
+type keyStore struct {
+	mu    sync.RWMutex
+	keys  map[[32]byte]*apiKey
+	tiers map[string]int
+}
+
+func newKeyStore(tiers map[string]int) *keyStore {
+	if len(tiers) == 0 {
+		tiers = defaultRateLimitTiers
+	}
+	return &keyStore{
+		keys:  make(map[[32]byte]*apiKey),
+		tiers: tiers,
+	}
+}
+
+// validateTiers checks the configured rate limit tiers.
+func validateTiers(tiers map[string]int) error {
+	for name, limit := range tiers {
+		if name == "" || len(name) > 32 || limit <= 0 {
+			return errors.New("rate limit tiers must have names and positive limits")
+		}
+	}
+	return nil
+}
+
+// lookup returns the API key and its rate limit.
+func (ks *keyStore) lookup(key string) (apiKey, int, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return apiKey{}, 0, false
+	}
+	// A key whose tier has been removed from the config gets the
+	// anonymous limit.
+	limit, ok := ks.tiers[k.Tier]
+	if !ok {
+		limit = maxRequestsPerSecond
+	}
+	return *k, limit, true
+}
+
+// limitExceeded returns true if there are too many requests from the
+// client. The clients with a valid API key are limited by the tier of
+// the key, the others by their address.
+func (api *portalAPI) limitExceeded(req *http.Request) bool {
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		if k, limit, ok := api.keys.lookup(key); ok {
+			return api.rl.limitExceeded("key:"+strconv.FormatInt(k.ID, 10), limit)
+		}
+	}
+	return api.rl.limitExceeded(getRemoteHost(req), maxRequestsPerSecond)
+}
+
+// checkAPIKey writes an error if the request carries an unknown API key.
+// Such a request is rejected instead of being served as anonymous, so
+// that a revoked key doesn't go unnoticed.
+func (api *portalAPI) checkAPIKey(w http.ResponseWriter, req *http.Request) bool {
+	key := req.Header.Get(apiKeyHeader)
+	if key == "" {
+		return true
+	}
+	if _, _, ok := api.keys.lookup(key); !ok {
+		writeError(w, errInvalidAPIKey.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// loadAPIKeys loads the API keys from the database.
+func (api *portalAPI) loadAPIKeys() error {
+	rows, err := api.db.Query("SELECT id, name, key_hash, tier, created_at FROM api_keys")
+	if err != nil {
+		return utils.AddContext(err, "couldn't query API keys")
+	}
+	defer rows.Close()
+
+	keys := make(map[[32]byte]*apiKey)
+	for rows.Next() {
+		k := new(apiKey)
+		hash := make([]byte, 32)
+		var created int64
+		if err := rows.Scan(&k.ID, &k.Name, &hash, &k.Tier, &created); err != nil {
+			return utils.AddContext(err, "couldn't decode API key")
+		}
+		k.CreatedAt = time.Unix(created, 0)
+		keys[[32]byte(hash)] = k
+	}
+	if err := rows.Err(); err != nil {
+		return utils.AddContext(err, "couldn't load API keys")
+	}
+
+	api.keys.mu.Lock()
+	api.keys.keys = keys
+	api.keys.mu.Unlock()
+
+	return nil
+}
+
+// createAPIKey generates a new API key. The key is returned only once,
+// only its hash is kept.
+func (api *portalAPI) createAPIKey(name, tier string) (apiKey, string, error) {
+	api.keys.mu.RLock()
+	_, ok := api.keys.tiers[tier]
+	api.keys.mu.RUnlock()
+	if !ok {
+		return apiKey{}, "", errInvalidTier
+	}
+
+	key := apiKeyPrefix + hex.EncodeToString(frand.Bytes(32))
+	hash := sha256.Sum256([]byte(key))
+	k := &apiKey{
+		Name:      name,
+		Tier:      tier,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := api.db.Exec(`
+		INSERT INTO api_keys (name, key_hash, tier, created_at)
+		VALUES (?, ?, ?, ?)
+	`, k.Name, hash[:], k.Tier, k.CreatedAt.Unix())
+	if err != nil {
+		return apiKey{}, "", utils.AddContext(err, "couldn't save API key")
+	}
+
+	// The hash is unique, so it identifies the new row on every backend.
+	err = api.db.QueryRow("SELECT id FROM api_keys WHERE key_hash = ?", hash[:]).Scan(&k.ID)
+	if err != nil {
+		return apiKey{}, "", utils.AddContext(err, "couldn't get API key ID")
+	}
+
+	api.keys.mu.Lock()
+	api.keys.keys[hash] = k
+	api.keys.mu.Unlock()
+
+	return *k, key, nil
+}
+
+// revokeAPIKey deletes the API key.
+func (api *portalAPI) revokeAPIKey(id int64) error {
+	res, err := api.db.Exec("DELETE FROM api_keys WHERE id = ?", id)
+	if err != nil {
+		return utils.AddContext(err, "couldn't delete API key")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errAPIKeyUnknown
+	}
+
+	api.keys.mu.Lock()
+	for hash, k := range api.keys.keys {
+		if k.ID == id {
+			delete(api.keys.keys, hash)
+		}
+	}
+	api.keys.mu.Unlock()
+
+	return nil
+}
+
+func (api *portalAPI) adminKeysHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	var keys []apiKey
+	api.keys.mu.RLock()
+	for _, k := range api.keys.keys {
+		keys = append(keys, *k)
+	}
+	api.keys.mu.RUnlock()
+	slices.SortFunc(keys, func(a, b apiKey) int { return int(a.ID - b.ID) })
+	writeJSON(w, keys)
+}
+
+func (api *portalAPI) adminKeysCreateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	var kr apiKeyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxReportSize)).Decode(&kr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	kr.Name = strings.TrimSpace(kr.Name)
+	if kr.Name == "" || len(kr.Name) > maxAPIKeyName {
+		writeError(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+
+	k, key, err := api.createAPIKey(kr.Name, kr.Tier)
+	if errors.Is(err, errInvalidTier) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't create API key", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, apiKeyResponse{apiKey: k, Key: key})
+}
+
+func (api *portalAPI) adminKeysRevokeHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid key ID", http.StatusBadRequest)
+		return
+	}
+
+	err = api.revokeAPIKey(id)
+	if errors.Is(err, errAPIKeyUnknown) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't revoke API key", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSMTPPort is the submission port used if none is configured.
+const defaultSMTPPort = 587
+
+// smtpConfig contains the settings of the mail server the notification
+// emails are sent through.
+type smtpConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+func (sc *smtpConfig) validate() error {
+	if sc.Host == "" {
+		return errors.New("SMTP host not provided")
+	}
+	if sc.Port == 0 {
+		sc.Port = defaultSMTPPort
+	}
+	if sc.Port < 0 || sc.Port > 65535 {
+		return errors.New("invalid SMTP port")
+	}
+	if _, err := mail.ParseAddress(sc.From); err != nil {
+		return fmt.Errorf("invalid SMTP sender address: %w", err)
+	}
+	return nil
+}
+
+// parseEmail checks the email address and returns it without the display
+// name, so that it is safe to put in a header.
+func parseEmail(s string) (string, error) {
+	if len(s) > maxAlertTarget || strings.ContainsAny(s, "\r\n") {
+		return "", errInvalidEmail
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", errInvalidEmail
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// sendMail sends a plain text email. The connection is upgraded with
+// STARTTLS if the server supports it, and the credentials are only sent
+// over an encrypted connection.
+func (sc *smtpConfig) sendMail(to, subject, body string) error {
+	from, err := mail.ParseAddress(sc.From)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if sc.User != "" {
+		auth = smtp.PlainAuth("", sc.User, sc.Password, sc.Host)
+	}
+	addr := net.JoinHostPort(sc.Host, strconv.Itoa(sc.Port))
+	return smtp.SendMail(addr, auth, from.Address, []string{to}, msg.Bytes())
+}
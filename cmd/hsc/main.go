@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"flag"
 	"fmt"
 	"log"
@@ -11,38 +10,214 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
-	client "github.com/mike76-dev/hostscore/api"
 	"github.com/mike76-dev/hostscore/internal/build"
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
 	"github.com/mike76-dev/hostscore/persist"
-	"golang.org/x/term"
 )
 
+// shutdownTimeout is how long hsc waits for the open requests to finish.
+const shutdownTimeout = 10 * time.Second
+
 func getDBPassword() string {
-	dbPassword := os.Getenv("HSC_DB_PASSWORD")
-	if dbPassword != "" {
-		log.Println("Using HSC_DB_PASSWORD environment variable.")
-	} else {
-		fmt.Print("Enter database password: ")
-		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Println()
-		if err != nil {
-			log.Fatalf("Could not read database password: %v\n", err)
-		}
-		dbPassword = string(pw)
+	dbPassword, err := utils.ReadSecret("HSC_DB_PASSWORD", "Enter database password: ")
+	if err != nil {
+		log.Fatalf("Could not read database password: %v\n", err)
 	}
 	return dbPassword
 }
 
+// getSecret returns an optional secret from the environment.
+func getSecret(env string) string {
+	secret, source, err := utils.LookupSecret(env)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if source != "" {
+		log.Printf("Using %s environment variable.\n", source)
+	}
+	return secret
+}
+
+// listenAddr returns the address to listen at. A port alone binds to the
+// loopback interface, because the portal is meant to be run behind a
+// reverse proxy.
+func listenAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
+
+// defaultDir returns the data directory set by HSC_DATA_DIR, or the
+// current one.
+func defaultDir() string {
+	if dir := os.Getenv("HSC_DATA_DIR"); dir != "" {
+		return dir
+	}
+	return "."
+}
+
+// portalIndexes lists the indexes required by the hot queries.
+var portalIndexes = []sqldb.Index{
+	{Table: "interactions", Columns: []string{"network", "public_key"}},
+	{Table: "scans", Columns: []string{"network", "node", "public_key", "ran_at"}},
+	{Table: "scans", Columns: []string{"ran_at"}},
+	{Table: "benchmarks", Columns: []string{"network", "node", "public_key", "ran_at"}},
+	{Table: "benchmarks", Columns: []string{"ran_at"}},
+	{Table: "price_changes", Columns: []string{"network", "public_key", "changed_at"}},
+	{Table: "settings_history", Columns: []string{"network", "public_key", "changed_at"}},
+	{Table: "diagnostics", Columns: []string{"network", "public_key", "ran_at"}},
+	{Table: "score_history", Columns: []string{"network", "public_key", "day"}},
+	{Table: "scan_rollups", Columns: []string{"span", "period"}},
+	{Table: "benchmark_rollups", Columns: []string{"span", "period"}},
+	{Table: "network_history", Columns: []string{"network", "hour"}},
+	{Table: "subscriptions", Columns: []string{"email"}},
+}
+
+// portalTables lists the tables of the portal. A shadow instance uses its
+// own versions of them.
+var portalTables = []string{
+	"hosts",
+	"interactions",
+	"scans",
+	"benchmarks",
+	"price_changes",
+	"settings_history",
+	"diagnostics",
+	"locations",
+	"community_reports",
+	"host_reports",
+	"score_history",
+	"network_history",
+	"alerts",
+	"subscriptions",
+	"api_keys",
+	"watchlist",
+	"opt_outs",
+	"idempotency_keys",
+	"uptime_daily",
+	"scan_rollups",
+	"benchmark_rollups",
+	"update_offsets",
+}
+
+var tablesVersionRegex = regexp.MustCompile(`^[a-z0-9_]{1,16}$`)
+
+// tablesVersion returns the version of the tables of a shadow instance:
+// the given one, or the release version, e.g. v2_1_0.
+func tablesVersion(version string) (string, error) {
+	if version == "" {
+		version = "v" + strings.ReplaceAll(build.ClientVersion, ".", "_")
+	}
+	if !tablesVersionRegex.MatchString(version) {
+		return "", fmt.Errorf("invalid tables version %q: set -shadow-version to lowercase letters, digits, and underscores", version)
+	}
+	return version, nil
+}
+
+// connectDB connects to the database. If the version is set, the queries
+// use the versioned tables, which are created if they don't exist yet.
+func connectDB(dbType, dbAddr, dbUser, dbName, version string) *sqldb.DB {
+	var dbPassword string
+	if dbType != sqldb.SQLite {
+		dbPassword = getDBPassword()
+	}
+
+	log.Println("Connecting to the SQL database...")
+	db, err := sqldb.Open(sqldb.Config{
+		Type:     dbType,
+		Addr:     dbAddr,
+		User:     dbUser,
+		Password: dbPassword,
+		Name:     dbName,
+		Version:  version,
+		Tables:   portalTables,
+	})
+	if err != nil {
+		log.Fatalf("Could not connect to the database: %v\n", err)
+	}
+	err = db.Ping()
+	if err != nil {
+		log.Fatalf("Database not responding: %v\n", err)
+	}
+	if version != "" {
+		if err := db.CreateVersionedTables(); err != nil {
+			log.Fatalf("Could not create the versioned tables: %v\n", err)
+		}
+	}
+	checkIndexes(db, portalIndexes)
+	return db
+}
+
+// checkIndexes warns if any of the expected indexes is missing.
+func checkIndexes(db *sqldb.DB, expected []sqldb.Index) {
+	missing, err := db.MissingIndexes(expected)
+	if err != nil {
+		log.Printf("Could not check the database indexes: %v\n", err)
+		return
+	}
+	for _, idx := range missing {
+		log.Printf("Warning: index on %v is missing, queries may be slow\n", idx)
+	}
+}
+
+// runImport populates a new portal database from another portal.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	from := fs.String("from", "", "URL of the portal to import the data from")
+	dbName := fs.String("db-name", "", "name of the MySQL database")
+	dbUser := fs.String("db-user", "", "name of the database user")
+	dbType := fs.String("db-type", sqldb.MySQL, "type of the database: mysql, postgres, or sqlite")
+	dbAddr := fs.String("db-addr", "", "address of the database server; defaults to the local one")
+	fs.Parse(args)
+
+	if *from == "" {
+		log.Fatalln("Source portal URL not provided")
+	}
+
+	db := connectDB(*dbType, *dbAddr, *dbUser, *dbName, "")
+	defer db.Close()
+
+	log.Println("Importing data from", *from)
+	if err := importPortal(db, *from); err != nil {
+		log.Fatalf("Import failed: %v\n", err)
+	}
+	log.Println("Import completed")
+}
+
 func main() {
 	log.SetFlags(0)
 
-	dir := flag.String("dir", ".", "directory to store files in")
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "renterd" {
+		runRenterd(os.Args[2:])
+		return
+	}
+
+	dir := flag.String("dir", defaultDir(), "directory to store files in; defaults to HSC_DATA_DIR or the current one")
 	dbName := flag.String("db-name", "", "name of the MySQL database")
 	dbUser := flag.String("db-user", "", "name of the database user")
-	portalPort := flag.String("portal", ":8080", "port number the portal server listens at")
+	dbType := flag.String("db-type", sqldb.MySQL, "type of the database: mysql, postgres, or sqlite")
+	dbAddr := flag.String("db-addr", "", "address of the database server; defaults to the local one")
+	portalPort := flag.String("portal", ":8080", "address or port number the portal server listens at; a port alone binds to localhost")
+	liveURL := flag.String("live", "", "URL of the live instance; if set, hsc runs in shadow mode")
+	shadowVersion := flag.String("shadow-version", "", "with -live, version of the tables in the live database the shadow instance writes to; defaults to the release version")
+	mirrorURL := flag.String("mirror", "", "URL of the primary portal; if set, hsc runs as a read-only mirror")
+	sharedDB := flag.Bool("shared-db", false, "with -mirror, read the database of the primary portal, e.g. a read replica, instead of copying it")
+	metricsAddr := flag.String("metrics", "", "address to serve Prometheus metrics on; disabled if empty")
+	historyCache := flag.Int("history-cache", defaultHistoryCacheSize, "number of hosts whose histories are cached after being loaded on demand")
+	legacyRoutes := flag.Bool("legacy-routes", true, "serve the API also without the /v1 prefix; deprecated")
+	memoryBudget := flag.Uint64("memory-budget", 0, "memory budget in MiB; if exceeded, the histories of the offline and low-ranked hosts are evicted from memory; disabled if zero")
 	flag.Parse()
 
 	err := os.MkdirAll(*dir, 0700)
@@ -57,41 +232,40 @@ func main() {
 		fmt.Println("Git Revision " + build.GitRevision)
 	}
 
-	dbPassword := getDBPassword()
-
-	log.Println("Connecting to the SQL database...")
-	cfg := mysql.Config{
-		User:                 *dbUser,
-		Passwd:               dbPassword,
-		Net:                  "tcp",
-		Addr:                 "127.0.0.1:3306",
-		DBName:               *dbName,
-		AllowNativePasswords: true,
-	}
-	db, err := sql.Open("mysql", cfg.FormatDSN())
-	if err != nil {
-		log.Fatalf("Could not connect to the database: %v\n", err)
+	if *liveURL != "" && *mirrorURL != "" {
+		log.Fatalln("Shadow mode and mirror mode cannot be combined")
 	}
-	err = db.Ping()
-	if err != nil {
-		log.Fatalf("MySQL database not responding: %v\n", err)
+	var version string
+	if *liveURL != "" {
+		version, err = tablesVersion(*shadowVersion)
+		if err != nil {
+			log.Fatalln(err)
+		}
 	}
-	db.SetConnMaxLifetime(time.Minute * 3)
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(10)
+
+	db := connectDB(*dbType, *dbAddr, *dbUser, *dbName, version)
 	defer db.Close()
 
-	apiToken := os.Getenv("HSC_API_TOKEN")
-	if apiToken != "" {
-		log.Println("Using HSC_API_TOKEN environment variable.")
-	}
+	apiToken := getSecret("HSC_API_TOKEN")
+	adminPassword := getSecret("HSC_ADMIN_PASSWORD")
 
 	s, err := newJSONStore(*dir)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if s.weights != defaultScoreWeights {
+		log.Println("Using custom score weights")
+	}
+	weights = s.weights
+	releases = s.releases
+	if s.smtp != nil {
+		log.Println("Email notifications enabled, sending through", s.smtp.Host)
+	}
+	if s.telegram != nil {
+		log.Println("Telegram notifications enabled")
+	}
 
-	l, err := net.Listen("tcp", "127.0.0.1"+*portalPort)
+	l, err := net.Listen("tcp", listenAddr(*portalPort))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -105,14 +279,63 @@ func main() {
 	cache := newCache()
 	defer cache.close()
 
-	api, err := newAPI(s, db, apiToken, logger, cache)
+	if *liveURL != "" {
+		log.Printf("Running in shadow mode next to %s, using the %s tables\n", *liveURL, version)
+	}
+	if *sharedDB && *mirrorURL == "" {
+		log.Fatalln("A shared database requires mirror mode")
+	}
+	if *mirrorURL != "" {
+		log.Println("Running as a read-only mirror of", *mirrorURL)
+	}
+	if *sharedDB {
+		log.Println("Sharing the database of the primary portal")
+	}
+
+	api, err := newAPI(s, db, apiToken, logger, cache, *liveURL, version, *mirrorURL, *sharedDB)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer api.close()
 
 	for key, node := range s.nodes {
-		api.clients[key] = client.NewClient(node.Address, node.Password)
+		api.clients[key] = api.nodeClient(node.Address, node.Password)
+		if node.Standby != nil {
+			standby := api.nodeClient(node.Standby.Address, node.Standby.Password)
+			api.failover.addStandby(key, standby, time.Duration(node.FailoverTimeout)*time.Minute)
+		}
+	}
+	api.adminPassword = adminPassword
+	api.legacyRoutes = *legacyRoutes
+	if *legacyRoutes {
+		log.Println("Serving the deprecated routes without the", apiPrefix, "prefix")
+	}
+	api.histories.resize(*historyCache)
+	if *memoryBudget > 0 {
+		api.memoryBudget = *memoryBudget << 20
+		debug.SetMemoryLimit(int64(api.memoryBudget))
+		log.Printf("Memory budget set to %d MiB\n", *memoryBudget)
+		api.jobs.add("memory", memoryCheckInterval, every(memoryCheckInterval), api.manageMemory)
+	}
+	api.contributors, err = loadContributors(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(api.contributors) > 0 && *mirrorURL == "" {
+		log.Printf("Accepting telemetry from %d contributors\n", len(api.contributors))
+	}
+	fed, err := loadFederation(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fed != nil && *mirrorURL != "" {
+		// The hosts are replaced on every sync, so the federated scores
+		// would be lost.
+		log.Println("Federation is not available in mirror mode")
+	} else if fed != nil {
+		log.Println("Federation enabled, portal key:", fed.key.PublicKey())
+		api.federation = fed
+		api.jobs.add("federation", 0, every(federationInterval), api.syncFederation)
 	}
 	api.buildHTTPRoutes()
 
@@ -121,14 +344,28 @@ func main() {
 	go srv.Serve(l)
 	fmt.Println("Listening on", l.Addr())
 
+	if *metricsAddr != "" {
+		lm, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Serving metrics on", lm.Addr())
+		go api.startMetrics(lm)
+	}
+
+	shutdownDone := make(chan struct{})
 	go func() {
 		<-closeChan
-		srv.Shutdown(context.Background())
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+		close(shutdownDone)
 	}()
 
 	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
 	<-signalCh
 	fmt.Println("Shutting down...")
 	closeChan <- 1
+	<-shutdownDone
 }
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"container/list"
+	"maps"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultHistoryCacheSize is the default number of hosts whose
+	// histories are kept in the cache after being loaded on demand.
+	defaultHistoryCacheSize = 1000
+
+	// memoryCheckInterval determines how often the heap size is checked
+	// against the memory budget.
+	memoryCheckInterval = time.Minute
+
+	// keptScans is the number of the most recent scans that survive the
+	// eviction. This is enough to tell if the host is online.
+	keptScans = 2
+
+	// evictionTarget is the fraction of the memory budget the heap is
+	// brought down to, so that the evictions don't happen on every check.
+	evictionTarget = 0.8
+)
+
+// historySpeeds contains the averages of an evicted history.
+type historySpeeds struct {
+	latency  time.Duration
+	upload   float64
+	download float64
+	ttfb     time.Duration
+}
+
+// historySize estimates the memory taken by the histories of the host.
+func historySize(host *portalHost) (size uint64) {
+	for _, interactions := range host.Interactions {
+		if interactions.evicted {
+			continue
+		}
+		for _, scan := range interactions.ScanHistory {
+			size += uint64(unsafe.Sizeof(scan)) + uint64(len(scan.Error))
+		}
+		for _, benchmark := range interactions.BenchmarkHistory {
+			size += uint64(unsafe.Sizeof(benchmark)) + uint64(len(benchmark.Error))
+		}
+	}
+	return
+}
+
+// isEvicted returns true if any history of the host has been evicted.
+func isEvicted(host *portalHost) bool {
+	for _, interactions := range host.Interactions {
+		if interactions.evicted {
+			return true
+		}
+	}
+	return false
+}
+
+// evictHistory drops the histories of the host from memory. The scores
+// calculated from the full histories stay in place. The host must not
+// have been published.
+func evictHistory(host *portalHost) {
+	for node, interactions := range host.Interactions {
+		if interactions.evicted {
+			continue
+		}
+		lat, ul, dl := getSpeeds(interactions)
+		interactions.speeds = historySpeeds{
+			latency:  lat,
+			upload:   ul,
+			download: dl,
+			ttfb:     getTTFB(interactions),
+		}
+		if len(interactions.ScanHistory) > keptScans {
+			interactions.ScanHistory = slices.Clone(interactions.ScanHistory[:keptScans])
+		}
+		interactions.BenchmarkHistory = nil
+		interactions.evicted = true
+		host.Interactions[node] = interactions
+	}
+}
+
+// nodeHistory contains the histories of a host measured by a node.
+type nodeHistory struct {
+	scans      []portalScan
+	benchmarks []hostdb.HostBenchmark
+}
+
+// fetchHistory reads the evicted histories of the host from the database.
+func (api *portalAPI) fetchHistory(network string, host *portalHost) (map[string]nodeHistory, error) {
+	histories := make(map[string]nodeHistory)
+	for node, interactions := range host.Interactions {
+		if !interactions.evicted {
+			continue
+		}
+
+		rows, err := api.db.Query(scanHistoryQuery, network, node, host.PublicKey[:])
+		if err != nil {
+			return nil, utils.AddContext(err, "couldn't query scan history")
+		}
+		scans, err := decodeScans(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err = api.db.Query(benchmarkHistoryQuery, network, node, host.PublicKey[:], hostdb.FailureSkipped)
+		if err != nil {
+			return nil, utils.AddContext(err, "couldn't query benchmarks")
+		}
+		benchmarks, err := decodeBenchmarks(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		histories[node] = nodeHistory{scans, benchmarks}
+	}
+	return histories, nil
+}
+
+// applyHistory puts the histories back in place of the evicted ones.
+func applyHistory(host *portalHost, histories map[string]nodeHistory) {
+	for node, interactions := range host.Interactions {
+		h, ok := histories[node]
+		if !ok || !interactions.evicted {
+			continue
+		}
+		interactions.ScanHistory = h.scans
+		interactions.BenchmarkHistory = h.benchmarks
+		interactions.evicted = false
+		interactions.speeds = historySpeeds{}
+		host.Interactions[node] = interactions
+	}
+}
+
+// loadHistory loads the evicted histories of the host from the database,
+// so that the scores can be recalculated. The host must not have been
+// published.
+func (api *portalAPI) loadHistory(network string, host *portalHost) error {
+	if !isEvicted(host) {
+		return nil
+	}
+	histories, err := api.fetchHistory(network, host)
+	if err != nil {
+		return err
+	}
+	applyHistory(host, histories)
+	api.histories.remove(network, host.PublicKey)
+	return nil
+}
+
+// withHistory returns a copy of the host with the evicted histories
+// loaded. The host itself stays evicted.
+func (api *portalAPI) withHistory(network string, host portalHost) (portalHost, error) {
+	if !isEvicted(&host) {
+		return host, nil
+	}
+	histories, ok := api.histories.get(network, host.PublicKey)
+	if !ok {
+		var err error
+		histories, err = api.fetchHistory(network, &host)
+		if err != nil {
+			return portalHost{}, utils.AddContext(err, "couldn't load host history")
+		}
+		api.histories.put(network, host.PublicKey, histories)
+	}
+	host.Interactions = maps.Clone(host.Interactions)
+	applyHistory(&host, histories)
+	return host, nil
+}
+
+// evictHistories evicts the histories of the offline hosts of w first,
+// then of the lowest-ranked ones, until the given amount of memory is
+// freed.
+func (api *portalAPI) evictHistories(w *hostWriter, target uint64) (evicted int, freed uint64) {
+	type candidate struct {
+		network string
+		host    *portalHost
+		online  bool
+		size    uint64
+	}
+
+	var candidates []candidate
+	for network, hosts := range w.hosts {
+		for _, host := range hosts {
+			if size := historySize(host); size > 0 {
+				candidates = append(candidates, candidate{
+					network: network,
+					host:    host,
+					online:  isOnline(*host),
+					size:    size,
+				})
+			}
+		}
+	}
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		if a.online != b.online {
+			if a.online {
+				return 1
+			}
+			return -1
+		}
+		return b.host.Rank - a.host.Rank
+	})
+
+	for _, c := range candidates {
+		if freed >= target {
+			break
+		}
+		host, _ := w.edit(c.network, c.host.PublicKey)
+		evictHistory(host)
+		api.histories.remove(c.network, c.host.PublicKey)
+		freed += c.size
+		evicted++
+	}
+
+	return
+}
+
+// manageMemory keeps the heap within the memory budget by evicting the
+// histories of the hosts. The evicted histories are loaded from the
+// database again when they are needed.
+func (api *portalAPI) manageMemory() error {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc <= api.memoryBudget {
+		return nil
+	}
+
+	target := ms.HeapAlloc - uint64(float64(api.memoryBudget)*evictionTarget)
+	var evicted int
+	var freed uint64
+	api.hosts.update(func(w *hostWriter) {
+		evicted, freed = api.evictHistories(w, target)
+	})
+
+	if evicted > 0 {
+		api.log.Info("evicted host histories",
+			zap.Int("hosts", evicted),
+			zap.Uint64("freed", freed),
+			zap.Uint64("heap", ms.HeapAlloc),
+			zap.Uint64("budget", api.memoryBudget),
+		)
+		debug.FreeOSMemory()
+	}
+	return nil
+}
+
+// historyKey identifies a host in the history cache.
+type historyKey struct {
+	network string
+	pk      types.PublicKey
+}
+
+// historyEntry is an entry of the history cache.
+type historyEntry struct {
+	key       historyKey
+	histories map[string]nodeHistory
+}
+
+// historyCache is an LRU cache of the histories loaded on demand for the
+// evicted hosts, so that a popular host page doesn't hit the database
+// each time.
+type historyCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[historyKey]*list.Element
+	order    *list.List
+}
+
+func newHistoryCache(capacity int) *historyCache {
+	return &historyCache{
+		capacity: capacity,
+		entries:  make(map[historyKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (hc *historyCache) get(network string, pk types.PublicKey) (map[string]nodeHistory, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	el, ok := hc.entries[historyKey{network, pk}]
+	if !ok {
+		return nil, false
+	}
+	hc.order.MoveToFront(el)
+	return el.Value.(*historyEntry).histories, true
+}
+
+func (hc *historyCache) put(network string, pk types.PublicKey, histories map[string]nodeHistory) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	key := historyKey{network, pk}
+	if el, ok := hc.entries[key]; ok {
+		el.Value.(*historyEntry).histories = histories
+		hc.order.MoveToFront(el)
+		return
+	}
+	hc.entries[key] = hc.order.PushFront(&historyEntry{key, histories})
+	hc.shrink()
+}
+
+// remove drops the histories of the host, which happens when they are
+// loaded into the host itself or have become stale.
+func (hc *historyCache) remove(network string, pk types.PublicKey) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	key := historyKey{network, pk}
+	if el, ok := hc.entries[key]; ok {
+		hc.order.Remove(el)
+		delete(hc.entries, key)
+	}
+}
+
+// resize changes the capacity of the cache.
+func (hc *historyCache) resize(capacity int) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.capacity = capacity
+	hc.shrink()
+}
+
+// clear empties the cache.
+func (hc *historyCache) clear() {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.entries = make(map[historyKey]*list.Element)
+	hc.order.Init()
+}
+
+// shrink drops the least recently used entries above the capacity.
+// NOTE: a lock must be acquired before calling shrink.
+func (hc *historyCache) shrink() {
+	for hc.order.Len() > hc.capacity {
+		el := hc.order.Back()
+		hc.order.Remove(el)
+		delete(hc.entries, el.Value.(*historyEntry).key)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	client "github.com/mike76-dev/hostscore/api"
+	"github.com/mike76-dev/hostscore/internal/metrics"
+)
+
+// ingestedRows counts the rows received from the nodes.
+var ingestedRows atomic.Uint64
+
+func perNode[T any](nodes map[string]T, value func(string, T) float64) (samples []metrics.Sample) {
+	for name, n := range nodes {
+		samples = append(samples, metrics.Sample{
+			Labels: map[string]string{"node": name},
+			Value:  value(name, n),
+		})
+	}
+	return
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (api *portalAPI) startMetrics(l net.Listener) error {
+	r := metrics.NewRegistry()
+	r.Register("hsc_hosts", "Number of known hosts.", metrics.Gauge, func() []metrics.Sample {
+		hosts := api.hosts.load()
+		return metrics.PerNetwork(float64(len(hosts["mainnet"])), float64(len(hosts["zen"])))
+	})
+	r.Register("hsc_node_online", "Whether the node responded to the last status request.", metrics.Gauge, func() []metrics.Sample {
+		api.mu.RLock()
+		defer api.mu.RUnlock()
+		return perNode(api.nodes, func(_ string, ns nodeStatus) float64 { return boolValue(ns.Online) })
+	})
+	r.Register("hsc_node_standby", "Whether the updates are pulled from the standby node.", metrics.Gauge, func() []metrics.Sample {
+		return perNode(api.clients, func(name string, _ *client.Client) float64 { return boolValue(api.failover.onStandby(name)) })
+	})
+	r.Register("hsc_node_update_backlog", "Number of updates the node has yet to deliver.", metrics.Gauge, func() []metrics.Sample {
+		return perNode(api.clients, func(name string, _ *client.Client) float64 { return float64(api.schedule.get(name).Backlog) })
+	})
+	r.Register("hsc_node_update_batch_size", "Negotiated update batch size.", metrics.Gauge, func() []metrics.Sample {
+		return perNode(api.clients, func(name string, _ *client.Client) float64 { return float64(api.schedule.get(name).BatchSize) })
+	})
+	r.CounterFunc("hsc_ingested_rows_total", "Number of rows received from the nodes.", func() float64 {
+		return float64(ingestedRows.Load())
+	})
+	r.GaugeFunc("hsc_db_ping_seconds", "Time it takes to ping the database.", func() float64 {
+		return metrics.PingLatency(api.db.DB)
+	})
+	r.CounterFunc("hsc_db_deadlocks_total", "Number of database deadlocks.", func() float64 {
+		return float64(api.db.Deadlocks())
+	})
+	r.CounterFunc("hsc_db_retries_total", "Number of database operations retried after a transient error.", func() float64 {
+		return float64(api.db.Retries())
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	return http.Serve(l, mux)
+}
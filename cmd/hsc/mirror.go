@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.uber.org/zap"
+)
+
+// mirrorSyncInterval determines how often a mirror pulls the data from
+// the primary portal.
+const mirrorSyncInterval = 10 * time.Minute
+
+// pullMirror copies the new and the changed rows from the primary portal
+// and reloads the hosts. If the mirror shares the database of the primary
+// portal, e.g. through a read replica, nothing needs to be copied.
+func (api *portalAPI) pullMirror() error {
+	client := &http.Client{Timeout: time.Minute}
+	tables := exportTables
+	if api.sharedDB {
+		tables = nil
+	}
+	for _, t := range tables {
+		// The rows with a cursor never change, so only the new ones are
+		// fetched. The other tables are small enough to be fetched in full.
+		var after int64
+		if t.cursor != "" {
+			err := api.db.QueryRow("SELECT COALESCE(MAX(" + t.cursor + "), 0) FROM " + t.name).Scan(&after)
+			if err != nil {
+				return utils.AddContext(err, "couldn't get last "+t.name+" row")
+			}
+		}
+		if _, err := importTable(api.db, client, api.mirrorURL, t, after, true); err != nil {
+			return utils.AddContext(err, "couldn't sync "+t.name)
+		}
+	}
+
+	// Load the hosts in the background and swap them, so that the API
+	// isn't blocked while the database is being read.
+	loaded := &portalAPI{
+		db:       api.db,
+		log:      api.log,
+		hosts:    newHostStore(newHostSet()),
+		tagRules: api.tagRules,
+		sybil:    api.sybil,
+	}
+	if err := loaded.load(); err != nil {
+		return utils.AddContext(err, "couldn't load hosts")
+	}
+
+	nodes, err := mirrorStatus(client, api.mirrorURL)
+	if err != nil {
+		api.log.Error("couldn't get primary portal status", zap.Error(err))
+	}
+
+	api.hosts.replace(loaded.hosts.load())
+	api.ranking.Store(loaded.ranking.Load())
+	api.histories.clear()
+	if nodes != nil {
+		api.mu.Lock()
+		api.nodes = nodes
+		api.mu.Unlock()
+	}
+	api.calculateAverages()
+
+	return nil
+}
+
+// mirrorStatus retrieves the status of the nodes from the primary portal.
+func mirrorStatus(client *http.Client, primary string) (map[string]nodeStatus, error) {
+	resp, err := client.Get(fmt.Sprintf("%s%s/service/status", primary, apiPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("primary portal returned %s", resp.Status)
+	}
+	var sr statusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return nil, err
+	}
+	return sr.Nodes, nil
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	rhpv3 "go.sia.tech/core/rhp/v3"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// networkSnapshot contains the statistics of a network at a given time.
+// The prices are averaged over the online hosts, the speeds over the
+// hosts that have been benchmarked successfully.
+type networkSnapshot struct {
+	Timestamp          time.Time      `json:"timestamp"`
+	Hosts              int            `json:"hosts"`
+	OnlineHosts        int            `json:"onlineHosts"`
+	AcceptingContracts int            `json:"acceptingContracts"`
+	TotalStorage       uint64         `json:"totalStorage"`
+	UsedStorage        uint64         `json:"usedStorage"`
+	StoragePrice       types.Currency `json:"storagePrice"`
+	Collateral         types.Currency `json:"collateral"`
+	UploadPrice        types.Currency `json:"uploadPrice"`
+	DownloadPrice      types.Currency `json:"downloadPrice"`
+	UploadSpeed        float64        `json:"uploadSpeed"`
+	DownloadSpeed      float64        `json:"downloadSpeed"`
+	TTFB               time.Duration  `json:"ttfb"`
+	CollateralCapacity types.Currency `json:"collateralCapacity"`
+}
+
+// collateralCapacity estimates how much collateral the host could lock
+// if all of its remaining storage was filled with contracts of the
+// assumed period.
+func collateralCapacity(settings rhpv2.HostSettings, pt rhpv3.HostPriceTable) types.Currency {
+	capacity, overflow := pt.CollateralCost.Mul64WithOverflow(settings.RemainingStorage)
+	if !overflow {
+		capacity, overflow = capacity.Mul64WithOverflow(contractPeriod)
+	}
+	if overflow || capacity.Cmp(pt.MaxCollateral) > 0 {
+		return pt.MaxCollateral
+	}
+	return capacity
+}
+
+type networkHistoryResponse struct {
+	History []networkSnapshot `json:"history"`
+}
+
+// snapshotNetworks stores the statistics of both networks, unless they
+// have been stored already this hour.
+func (api *portalAPI) snapshotNetworks() error {
+	var last int64
+	if err := api.db.QueryRow("SELECT COALESCE(MAX(hour), 0) FROM network_history").Scan(&last); err != nil {
+		return utils.AddContext(err, "couldn't get last network snapshot")
+	}
+
+	hour := time.Now().Unix() / 3600
+	if hour <= last {
+		return nil
+	}
+	return utils.AddContext(api.saveNetworkSnapshot(hour), "couldn't save network snapshot")
+}
+
+// calculateNetworkSnapshot calculates the current statistics of the
+// network.
+func (api *portalAPI) calculateNetworkSnapshot(network string) (ns networkSnapshot) {
+	var benchmarked, withTTFB uint64
+	for _, host := range api.hosts.load()[network] {
+		if host.OptOut == hostdb.OptOutDelist {
+			continue
+		}
+		ns.Hosts++
+		if !isOnline(*host) {
+			continue
+		}
+		ns.OnlineHosts++
+		if host.Settings.AcceptingContracts {
+			ns.AcceptingContracts++
+		}
+		ns.TotalStorage += host.Settings.TotalStorage
+		if host.Settings.TotalStorage > host.Settings.RemainingStorage {
+			ns.UsedStorage += host.Settings.TotalStorage - host.Settings.RemainingStorage
+		}
+		ns.StoragePrice = ns.StoragePrice.Add(host.Settings.StoragePrice)
+		ns.Collateral = ns.Collateral.Add(host.Settings.Collateral)
+		ns.UploadPrice = ns.UploadPrice.Add(host.Settings.UploadBandwidthPrice)
+		ns.DownloadPrice = ns.DownloadPrice.Add(host.Settings.DownloadBandwidthPrice)
+		ns.CollateralCapacity = ns.CollateralCapacity.Add(collateralCapacity(host.Settings, host.PriceTable))
+
+		// Average the speeds measured by the nodes first, so that the
+		// hosts benchmarked by more nodes don't weigh more.
+		var ul, dl float64
+		var ttfb time.Duration
+		var nodes, nodesTTFB int
+		for _, interactions := range host.Interactions {
+			_, u, d := getSpeeds(interactions)
+			if u > 0 || d > 0 {
+				ul += u
+				dl += d
+				nodes++
+			}
+			if t := getTTFB(interactions); t > 0 {
+				ttfb += t
+				nodesTTFB++
+			}
+		}
+		if nodes > 0 {
+			ns.UploadSpeed += ul / float64(nodes)
+			ns.DownloadSpeed += dl / float64(nodes)
+			benchmarked++
+		}
+		if nodesTTFB > 0 {
+			ns.TTFB += ttfb / time.Duration(nodesTTFB)
+			withTTFB++
+		}
+	}
+
+	if ns.OnlineHosts > 0 {
+		n := uint64(ns.OnlineHosts)
+		ns.StoragePrice = ns.StoragePrice.Div64(n)
+		ns.Collateral = ns.Collateral.Div64(n)
+		ns.UploadPrice = ns.UploadPrice.Div64(n)
+		ns.DownloadPrice = ns.DownloadPrice.Div64(n)
+	}
+	if benchmarked > 0 {
+		ns.UploadSpeed /= float64(benchmarked)
+		ns.DownloadSpeed /= float64(benchmarked)
+	}
+	if withTTFB > 0 {
+		ns.TTFB /= time.Duration(withTTFB)
+	}
+
+	return
+}
+
+// saveNetworkSnapshot saves the current statistics of both networks as
+// the statistics of the given hour.
+func (api *portalAPI) saveNetworkSnapshot(hour int64) error {
+	snapshots := make(map[string]networkSnapshot)
+	for network := range api.hosts.load() {
+		snapshots[network] = api.calculateNetworkSnapshot(network)
+	}
+
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO network_history (
+			network,
+			hour,
+			hosts,
+			online_hosts,
+			accepting_contracts,
+			total_storage,
+			used_storage,
+			storage_price,
+			collateral,
+			upload_price,
+			download_price,
+			upload_speed,
+			download_speed,
+			ttfb,
+			collateral_capacity
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	encode := func(c types.Currency) []byte {
+		var buf bytes.Buffer
+		e := types.NewEncoder(&buf)
+		types.V1Currency(c).EncodeTo(e)
+		e.Flush()
+		return buf.Bytes()
+	}
+
+	for network, ns := range snapshots {
+		_, err := stmt.Exec(
+			network,
+			hour,
+			ns.Hosts,
+			ns.OnlineHosts,
+			ns.AcceptingContracts,
+			ns.TotalStorage,
+			ns.UsedStorage,
+			encode(ns.StoragePrice),
+			encode(ns.Collateral),
+			encode(ns.UploadPrice),
+			encode(ns.DownloadPrice),
+			ns.UploadSpeed,
+			ns.DownloadSpeed,
+			ns.TTFB.Milliseconds(),
+			encode(ns.CollateralCapacity),
+		)
+		if err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't save network snapshot")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getNetworkHistory retrieves the hourly statistics of the given network.
+func (api *portalAPI) getNetworkHistory(network string, from, to time.Time, limit int64) (history []networkSnapshot, err error) {
+	f := int64(0)
+	t := time.Now().Unix() / 3600
+	if from.Unix() != (time.Time{}).Unix() {
+		f = from.Unix() / 3600
+	}
+	if to.Unix() != (time.Time{}).Unix() {
+		t = to.Unix() / 3600
+	}
+	if limit < 0 {
+		limit = math.MaxInt64
+	}
+
+	rows, err := api.db.Query(`
+		SELECT
+			hour,
+			hosts,
+			online_hosts,
+			accepting_contracts,
+			total_storage,
+			used_storage,
+			storage_price,
+			collateral,
+			upload_price,
+			download_price,
+			upload_speed,
+			download_speed,
+			ttfb,
+			collateral_capacity
+		FROM network_history
+		WHERE network = ?
+		AND hour >= ?
+		AND hour <= ?
+		ORDER BY hour DESC
+		LIMIT ?
+	`, network, f, t, limit)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query network history")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hour, ttfb int64
+		var spb, cb, upb, dpb, ccb []byte
+		var ns networkSnapshot
+		if err := rows.Scan(
+			&hour,
+			&ns.Hosts,
+			&ns.OnlineHosts,
+			&ns.AcceptingContracts,
+			&ns.TotalStorage,
+			&ns.UsedStorage,
+			&spb,
+			&cb,
+			&upb,
+			&dpb,
+			&ns.UploadSpeed,
+			&ns.DownloadSpeed,
+			&ttfb,
+			&ccb,
+		); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode network snapshot")
+		}
+
+		for _, c := range []struct {
+			b    []byte
+			c    *types.Currency
+			name string
+		}{
+			{spb, &ns.StoragePrice, "storage price"},
+			{cb, &ns.Collateral, "collateral"},
+			{upb, &ns.UploadPrice, "upload price"},
+			{dpb, &ns.DownloadPrice, "download price"},
+			{ccb, &ns.CollateralCapacity, "collateral capacity"},
+		} {
+			d := types.NewBufDecoder(c.b)
+			if (*types.V1Currency)(c.c).DecodeFrom(d); d.Err() != nil {
+				return nil, utils.AddContext(d.Err(), "couldn't decode "+c.name)
+			}
+		}
+		ns.Timestamp = time.Unix(hour*3600, 0).UTC()
+		ns.TTFB = time.Duration(ttfb) * time.Millisecond
+		history = append(history, ns)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load network history")
+	}
+
+	// Sort in ascending order.
+	slices.Reverse(history)
+
+	return
+}
+
+func (api *portalAPI) networkHistoryHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	var from, to time.Time
+	var err error
+	f := req.FormValue("from")
+	if f != "" {
+		from, err = time.Parse(time.RFC3339, f)
+		if err != nil {
+			writeError(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	t := req.FormValue("to")
+	if t != "" {
+		to, err = time.Parse(time.RFC3339, t)
+		if err != nil {
+			writeError(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := int64(-1)
+	lim := req.FormValue("limit")
+	if lim != "" {
+		limit, err = strconv.ParseInt(lim, 10, 64)
+		if err != nil {
+			writeError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	history, err := api.getNetworkHistory(network, from, to, limit)
+	if err != nil {
+		api.log.Error("couldn't get network history", zap.String("network", network), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, networkHistoryResponse{History: history})
+}
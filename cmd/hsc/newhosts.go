@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// newHostCheckInterval determines how often the new hosts are
+	// announced to the webhooks.
+	newHostCheckInterval = time.Minute
+
+	// newHostSettingsWait is how long a new host is waited for to be
+	// scanned, so that its settings are included in the announcement.
+	newHostSettingsWait = time.Hour
+
+	// newHostMaxAge is how long after it was first seen a host is still
+	// announced. It keeps a portal catching up with the nodes from
+	// announcing the whole network.
+	newHostMaxAge = 24 * time.Hour
+
+	// maxNewHosts is the maximum number of hosts returned by /hosts/new.
+	maxNewHosts = 1000
+)
+
+// newHostWebhook is a URL the new hosts are posted to. If the secret is
+// set, the body is signed with it.
+type newHostWebhook struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// validateNewHostWebhooks checks the webhook URLs.
+func validateNewHostWebhooks(hooks []newHostWebhook) error {
+	for _, hook := range hooks {
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+			return errors.New("invalid new host webhook URL")
+		}
+	}
+	return nil
+}
+
+// newHost is a host that has announced itself for the first time.
+type newHost struct {
+	Network    string              `json:"network"`
+	PublicKey  types.PublicKey     `json:"publicKey"`
+	NetAddress string              `json:"netAddress"`
+	FirstSeen  time.Time           `json:"firstSeen"`
+	KnownSince uint64              `json:"knownSince"`
+	Settings   *rhpv2.HostSettings `json:"settings,omitempty"`
+}
+
+// newHostsNotification is posted to the webhooks.
+type newHostsNotification struct {
+	Timestamp time.Time `json:"timestamp"`
+	Hosts     []newHost `json:"hosts"`
+}
+
+type newHostsResponse struct {
+	Hosts []newHost `json:"hosts"`
+}
+
+// toNewHost returns the announcement of the host.
+func toNewHost(network string, host *portalHost) newHost {
+	nh := newHost{
+		Network:    network,
+		PublicKey:  host.PublicKey,
+		NetAddress: host.NetAddress,
+		FirstSeen:  host.FirstSeen,
+		KnownSince: host.KnownSince,
+	}
+	if (host.Settings != rhpv2.HostSettings{}) {
+		settings := host.Settings
+		nh.Settings = &settings
+	}
+	return nh
+}
+
+// newHostTracker keeps the new hosts until they are announced to the
+// webhooks. The pending hosts are kept in memory only.
+type newHostTracker struct {
+	mu      sync.Mutex
+	hooks   []newHostWebhook
+	pending map[string]map[types.PublicKey]time.Time
+	client  *http.Client
+}
+
+func newNewHostTracker(hooks []newHostWebhook) *newHostTracker {
+	return &newHostTracker{
+		hooks: hooks,
+		pending: map[string]map[types.PublicKey]time.Time{
+			"mainnet": make(map[types.PublicKey]time.Time),
+			"zen":     make(map[types.PublicKey]time.Time),
+		},
+		client: &http.Client{Timeout: notificationTimeout},
+	}
+}
+
+// track queues the hosts seen by the portal for the first time.
+func (nt *newHostTracker) track(hosts []hostdb.HostDBEntry) {
+	if len(nt.hooks) == 0 {
+		return
+	}
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	for _, host := range hosts {
+		if time.Since(host.FirstSeen) > newHostMaxAge {
+			continue
+		}
+		if _, ok := nt.pending[host.Network][host.PublicKey]; !ok {
+			nt.pending[host.Network][host.PublicKey] = time.Now()
+		}
+	}
+}
+
+// announceNewHosts posts the new hosts, which have been scanned or waited
+// for long enough, to the webhooks.
+func (api *portalAPI) announceNewHosts() {
+	var ready []newHost
+	hosts := api.hosts.load()
+	api.newHosts.mu.Lock()
+	for network, keys := range api.newHosts.pending {
+		for pk, since := range keys {
+			host, ok := hosts[network][pk]
+			if !ok || host.OptOut == hostdb.OptOutDelist {
+				delete(keys, pk)
+				continue
+			}
+			if (host.Settings == rhpv2.HostSettings{}) && time.Since(since) < newHostSettingsWait {
+				continue
+			}
+			ready = append(ready, toNewHost(network, host))
+			delete(keys, pk)
+		}
+	}
+	api.newHosts.mu.Unlock()
+
+	if len(ready) == 0 {
+		return
+	}
+	slices.SortFunc(ready, func(a, b newHost) int { return a.FirstSeen.Compare(b.FirstSeen) })
+	body, err := json.Marshal(newHostsNotification{
+		Timestamp: time.Now(),
+		Hosts:     ready,
+	})
+	if err != nil {
+		api.log.Error("couldn't encode new hosts", zap.Error(err))
+		return
+	}
+	for _, hook := range api.newHosts.hooks {
+		go api.deliverNewHosts(hook, body)
+	}
+}
+
+// deliverNewHosts posts the new hosts to the webhook, retrying with a
+// growing delay if it fails.
+func (api *portalAPI) deliverNewHosts(hook newHostWebhook, body []byte) {
+	header := make(http.Header)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		header.Set("X-HostScore-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	var err error
+	for attempt := 0; attempt < notificationAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-api.stopChan:
+				return
+			case <-time.After(time.Duration(1<<attempt) * time.Second):
+			}
+		}
+		if err = postJSON(api.newHosts.client, hook.URL, body, header); err == nil {
+			return
+		}
+	}
+	api.log.Warn("couldn't deliver new hosts", zap.Error(err))
+}
+
+// hostsNewHandler returns the hosts first seen after the given time, the
+// oldest first.
+func (api *portalAPI) hostsNewHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	since := time.Now().Add(-24 * time.Hour)
+	if s := req.FormValue("since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := maxNewHosts
+	if lim := req.FormValue("limit"); lim != "" {
+		l, err := strconv.Atoi(lim)
+		if err != nil || l <= 0 {
+			writeError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(l, maxNewHosts)
+	}
+
+	hosts := make([]newHost, 0)
+	for _, host := range api.hosts.load()[network] {
+		if host.FirstSeen.After(since) && host.OptOut != hostdb.OptOutDelist {
+			hosts = append(hosts, toNewHost(network, host))
+		}
+	}
+
+	slices.SortFunc(hosts, func(a, b newHost) int {
+		if c := a.FirstSeen.Compare(b.FirstSeen); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.PublicKey.String(), b.PublicKey.String())
+	})
+	if len(hosts) > limit {
+		hosts = hosts[:limit]
+	}
+
+	writeJSON(w, newHostsResponse{Hosts: hosts})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+)
+
+// notifier delivers the alert notifications over a channel.
+type notifier interface {
+	// validate checks if the notifications can be sent to the target.
+	validate(target string) error
+
+	// send delivers the notification to the target. The secret of the
+	// alert may be used to authenticate the notification.
+	send(client *http.Client, target, secret string, n alertNotification) error
+}
+
+// telegramConfig contains the settings of the Telegram bot the
+// notifications are sent by.
+type telegramConfig struct {
+	BotToken string `json:"botToken"`
+}
+
+func (tc *telegramConfig) validate() error {
+	if tc.BotToken == "" {
+		return errors.New("Telegram bot token not provided")
+	}
+	return nil
+}
+
+// newNotifiers returns the notifiers available with the given config.
+func newNotifiers(telegram *telegramConfig) map[string]notifier {
+	notifiers := map[string]notifier{
+		channelWebhook: webhookNotifier{},
+		channelDiscord: discordNotifier{},
+	}
+	if telegram != nil {
+		notifiers[channelTelegram] = telegramNotifier{token: telegram.BotToken}
+	}
+	return notifiers
+}
+
+// postJSON posts the body to the URL and checks the response status.
+func postJSON(client *http.Client, target string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errInvalidTarget
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// The URL may contain a token, so it must not end up in the logs.
+	resp, err := client.Do(req)
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// formatNotification renders the notification as a chat message.
+func formatNotification(n alertNotification) string {
+	return fmt.Sprintf("%s (%s): %s\nScore: %.4f\nPublic key: %s", n.NetAddress, n.Network, n.Message, n.Score, n.PublicKey)
+}
+
+// webhookNotifier posts the notifications as JSON to any URL.
+type webhookNotifier struct{}
+
+func (webhookNotifier) validate(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errInvalidTarget
+	}
+	// Don't let the portal be used to reach the internal network.
+	if utils.IsLocal(net.JoinHostPort(u.Hostname(), "0")) {
+		return errInvalidTarget
+	}
+	return nil
+}
+
+// send posts the notification to the URL. The body is signed with the
+// secret of the alert, so that the receiver can verify its origin.
+func (webhookNotifier) send(client *http.Client, target, secret string, n alertNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	header := make(http.Header)
+	header.Set("X-HostScore-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return postJSON(client, target, body, header)
+}
+
+// discordHosts are the hosts the Discord webhooks are served from.
+var discordHosts = map[string]bool{
+	"discord.com":        true,
+	"discordapp.com":     true,
+	"ptb.discord.com":    true,
+	"canary.discord.com": true,
+}
+
+// discordNotifier posts the notifications to a Discord channel webhook.
+type discordNotifier struct{}
+
+func (discordNotifier) validate(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "https" || !discordHosts[u.Hostname()] {
+		return errInvalidTarget
+	}
+	if !strings.HasPrefix(u.Path, "/api/webhooks/") {
+		return errInvalidTarget
+	}
+	return nil
+}
+
+func (discordNotifier) send(client *http.Client, target, _ string, n alertNotification) error {
+	body, err := json.Marshal(struct {
+		Content string `json:"content"`
+	}{formatNotification(n)})
+	if err != nil {
+		return err
+	}
+	return postJSON(client, target, body, nil)
+}
+
+// telegramUsername matches the public usernames of Telegram channels.
+var telegramUsername = regexp.MustCompile(`^@[A-Za-z][A-Za-z0-9_]{4,31}$`)
+
+// telegramNotifier sends the notifications by a Telegram bot. The target
+// is the ID of the chat or the username of the channel, which the bot
+// must have been added to.
+type telegramNotifier struct {
+	token string
+}
+
+func (telegramNotifier) validate(target string) error {
+	if _, err := strconv.ParseInt(target, 10, 64); err == nil {
+		return nil
+	}
+	if telegramUsername.MatchString(target) {
+		return nil
+	}
+	return errInvalidTarget
+}
+
+func (tn telegramNotifier) send(client *http.Client, target, _ string, n alertNotification) error {
+	body, err := json.Marshal(struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}{target, formatNotification(n)})
+	if err != nil {
+		return err
+	}
+	return postJSON(client, "https://api.telegram.org/bot"+tn.token+"/sendMessage", body, nil)
+}
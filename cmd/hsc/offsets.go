@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.uber.org/zap"
+)
+
+// updateOffset tells how far a batch of updates from a node has been
+// ingested: seq is the sequence number of the batch, and applied is the
+// number of its records already written. It is saved in the same
+// transaction as the records, so that a batch sent again, because the
+// receipt wasn't confirmed, is neither lost nor applied twice. The
+// standby node keeps its own sequence.
+type updateOffset struct {
+	standby bool
+	seq     hostdb.UpdateID
+	applied int
+}
+
+// resumeUpdates returns the offset to ingest the batch of updates with the
+// given sequence number from the primary or the standby node.
+func (api *portalAPI) resumeUpdates(node string, standby bool, seq hostdb.UpdateID) (updateOffset, error) {
+	var saved updateOffset
+	err := api.db.QueryRow(`
+		SELECT seq, applied
+		FROM update_offsets
+		WHERE node = ?
+		AND standby = ?
+	`, node, standby).Scan(&saved.seq, &saved.applied)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return updateOffset{}, utils.AddContext(err, "couldn't load update offset")
+	}
+
+	offset := updateOffset{standby: standby, seq: seq}
+	switch {
+	case seq == saved.seq:
+		offset.applied = saved.applied
+	case seq < saved.seq:
+		// The node must have lost its database, so the batch is new.
+		api.log.Warn("update sequence has been reset", zap.String("node", node), zap.Bool("standby", standby), zap.Uint64("seq", seq), zap.Uint64("saved", saved.seq))
+	}
+
+	return offset, nil
+}
+
+// saveUpdateOffset saves the offset of the batch of updates from the node.
+func saveUpdateOffset(tx *sqldb.Tx, node string, offset updateOffset) error {
+	_, err := tx.Exec(`
+		INSERT INTO update_offsets (node, standby, seq, applied)
+		VALUES (?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (node, standby) UPDATE
+			seq = new.seq,
+			applied = new.applied
+	`, node, offset.standby, offset.seq, offset.applied)
+	return utils.AddContext(err, "couldn't save update offset")
+}
+
+// countUpdates returns the number of the records in the batch of updates.
+func countUpdates(updates hostdb.HostUpdates) int {
+	return len(updates.Hosts) + len(updates.Scans) + len(updates.Benchmarks)
+}
+
+// skipUpdates removes the first n records from the batch of updates in the
+// order they are streamed by the node: the hosts, the scans, and the
+// benchmarks of each network.
+func skipUpdates(updates hostdb.HostUpdates, n int) hostdb.HostUpdates {
+	if n <= 0 {
+		return updates
+	}
+	remaining := hostdb.HostUpdates{
+		ID:      updates.ID,
+		Backlog: updates.Backlog,
+	}
+	var i int
+	for _, network := range []string{"mainnet", "zen"} {
+		for _, host := range updates.Hosts {
+			if host.Network != network {
+				continue
+			}
+			if i >= n {
+				remaining.Hosts = append(remaining.Hosts, host)
+			}
+			i++
+		}
+		for _, scan := range updates.Scans {
+			if scan.Network != network {
+				continue
+			}
+			if i >= n {
+				remaining.Scans = append(remaining.Scans, scan)
+			}
+			i++
+		}
+		for _, benchmark := range updates.Benchmarks {
+			if benchmark.Network != network {
+				continue
+			}
+			if i >= n {
+				remaining.Benchmarks = append(remaining.Benchmarks, benchmark)
+			}
+			i++
+		}
+	}
+	return remaining
+}
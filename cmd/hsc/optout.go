@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// optOutNone cancels a previous opt-out.
+	optOutNone = "none"
+
+	// optOutWindow is how far the timestamp of an opt-out request may be
+	// from the current time.
+	optOutWindow = time.Hour
+
+	// optOutPushInterval is how often the opt-outs are pushed to the
+	// nodes, in case a node has missed a change.
+	optOutPushInterval = time.Hour
+
+	// maxOptOutRequestSize is the maximum size of an opt-out request.
+	maxOptOutRequestSize = 4096
+)
+
+var (
+	errInvalidOptOutSignature = errors.New("invalid signature")
+	errStaleOptOut            = errors.New("opt-out request is outdated")
+)
+
+// optOutRequest is signed by the host operator with the host key to
+// prove the control over the host.
+type optOutRequest struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Level     string          `json:"level"`
+	Timestamp int64           `json:"timestamp"`
+	Signature types.Signature `json:"signature"`
+}
+
+// optOutRecord is a public record of an opt-out.
+type optOutRecord struct {
+	Network     string             `json:"network"`
+	PublicKey   types.PublicKey    `json:"publicKey"`
+	Level       hostdb.OptOutLevel `json:"level"`
+	RequestedAt time.Time          `json:"requestedAt"`
+}
+
+// optOutMessage returns the message the host operator needs to sign.
+func optOutMessage(network string, pk types.PublicKey, level string, timestamp int64) string {
+	return fmt.Sprintf("HostScore opt-out\nnetwork: %s\nhost: %s\nlevel: %s\ntimestamp: %d", network, pk, level, timestamp)
+}
+
+// verify checks the signature and the timestamp of the request.
+func (or optOutRequest) verify(now time.Time) error {
+	ts := time.Unix(or.Timestamp, 0)
+	if ts.Before(now.Add(-optOutWindow)) || ts.After(now.Add(optOutWindow)) {
+		return errStaleOptOut
+	}
+	h := types.HashBytes([]byte(optOutMessage(or.Network, or.PublicKey, or.Level, or.Timestamp)))
+	if !or.PublicKey.VerifyHash(h, or.Signature) {
+		return errInvalidOptOutSignature
+	}
+	return nil
+}
+
+// loadOptOuts marks the hosts that have opted out.
+func (api *portalAPI) loadOptOuts() error {
+	rows, err := api.db.Query("SELECT network, public_key, level FROM opt_outs")
+	if err != nil {
+		return utils.AddContext(err, "couldn't query opt-outs")
+	}
+	defer rows.Close()
+
+	w := api.hosts.write()
+	defer w.discard()
+	for rows.Next() {
+		var network, level string
+		pk := make([]byte, 32)
+		if err := rows.Scan(&network, &pk, &level); err != nil {
+			return utils.AddContext(err, "couldn't decode opt-out")
+		}
+		if level == optOutNone {
+			continue
+		}
+		if host, ok := w.edit(network, types.PublicKey(pk)); ok {
+			host.OptOut = hostdb.OptOutLevel(level)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return utils.AddContext(err, "couldn't load opt-outs")
+	}
+	w.publish()
+
+	return nil
+}
+
+// setOptOut saves the opt-out of the host. An opt-out can only be
+// replaced by a newer one, so that an intercepted request can't be
+// replayed after the operator has changed their mind.
+func (api *portalAPI) setOptOut(or optOutRequest) error {
+	api.optOutMu.Lock()
+	defer api.optOutMu.Unlock()
+
+	if _, ok := api.hosts.load()[or.Network][or.PublicKey]; !ok {
+		return errHostNotFound
+	}
+
+	var last int64
+	err := api.db.QueryRow(`
+		SELECT requested_at
+		FROM opt_outs
+		WHERE network = ?
+		AND public_key = ?
+	`, or.Network, or.PublicKey[:]).Scan(&last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return utils.AddContext(err, "couldn't query opt-out")
+	}
+	if or.Timestamp <= last {
+		return errStaleOptOut
+	}
+
+	_, err = api.db.Exec(`
+		INSERT INTO opt_outs (network, public_key, level, requested_at)
+		VALUES (?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (network, public_key) UPDATE
+			level = new.level,
+			requested_at = new.requested_at
+	`, or.Network, or.PublicKey[:], or.Level, or.Timestamp)
+	if err != nil {
+		return utils.AddContext(err, "couldn't save opt-out")
+	}
+
+	api.hosts.update(func(w *hostWriter) {
+		if host, ok := w.edit(or.Network, or.PublicKey); ok {
+			host.OptOut = hostdb.OptOutLevel(or.Level)
+			if or.Level == optOutNone {
+				host.OptOut = ""
+			}
+		}
+	})
+
+	return nil
+}
+
+// getOptOuts returns the current opt-outs.
+func (api *portalAPI) getOptOuts(network string) ([]optOutRecord, error) {
+	rows, err := api.db.Query(`
+		SELECT public_key, level, requested_at
+		FROM opt_outs
+		WHERE network = ?
+		AND level <> ?
+		ORDER BY requested_at DESC
+	`, network, optOutNone)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query opt-outs")
+	}
+	defer rows.Close()
+
+	records := []optOutRecord{}
+	for rows.Next() {
+		var level string
+		var ts int64
+		pk := make([]byte, 32)
+		if err := rows.Scan(&pk, &level, &ts); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode opt-out")
+		}
+		records = append(records, optOutRecord{
+			Network:     network,
+			PublicKey:   types.PublicKey(pk),
+			Level:       hostdb.OptOutLevel(level),
+			RequestedAt: time.Unix(ts, 0),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load opt-outs")
+	}
+
+	return records, nil
+}
+
+// pushOptOuts sends the opt-outs to all nodes, which stop scanning or
+// benchmarking the hosts that have opted out.
+func (api *portalAPI) pushOptOuts() {
+	var list []hostdb.OptOut
+	for network, hosts := range api.hosts.load() {
+		for pk, host := range hosts {
+			if host.OptOut != "" {
+				list = append(list, hostdb.OptOut{
+					Network:   network,
+					PublicKey: pk,
+					Level:     host.OptOut,
+				})
+			}
+		}
+	}
+
+	for node, c := range api.clients {
+		if err := c.SetOptOuts(list); err != nil {
+			api.log.Warn("couldn't push opt-outs", zap.String("node", node), zap.Error(err))
+		}
+	}
+}
+
+func (api *portalAPI) optOutsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+
+	records, err := api.getOptOuts(network)
+	if err != nil {
+		api.log.Error("couldn't get opt-outs", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, records)
+}
+
+func (api *portalAPI) optOutsCreateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if api.mirrorURL != "" {
+		writeError(w, "opt-outs are not accepted by a mirror", http.StatusForbidden)
+		return
+	}
+
+	var or optOutRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxOptOutRequestSize)).Decode(&or); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	if or.Network != "mainnet" && or.Network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	if or.Level != string(hostdb.OptOutBenchmarks) && or.Level != string(hostdb.OptOutDelist) && or.Level != optOutNone {
+		writeError(w, "invalid opt-out level", http.StatusBadRequest)
+		return
+	}
+	if err := or.verify(time.Now()); err != nil {
+		writeError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := api.setOptOut(or)
+	if errors.Is(err, errHostNotFound) {
+		writeError(w, "host not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errStaleOptOut) {
+		writeError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't save opt-out", zap.Stringer("host", or.PublicKey), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	go api.pushOptOuts()
+
+	w.WriteHeader(http.StatusNoContent)
+}
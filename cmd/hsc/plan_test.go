@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/testutil"
+)
+
+// TestQueryPlans makes sure that the hot queries use the indexes
+// created by init_portal_sqlite.sql instead of scanning the tables.
+func TestQueryPlans(t *testing.T) {
+	db := testutil.NewDB(t, "init_portal_sqlite.sql")
+	pk := make([]byte, 32)
+	tests := []struct {
+		name  string
+		query string
+		args  []any
+		want  string
+		// sorted requires the index to deliver the rows in order.
+		sorted bool
+	}{
+		{
+			name:   "scan success",
+			query:  scanSuccessQuery,
+			args:   []any{"mainnet", "global", pk},
+			want:   "SEARCH scans USING INDEX idx_scans (network=? AND node=? AND public_key=?)",
+			sorted: true,
+		},
+		{
+			name:   "scan history",
+			query:  scanHistoryQuery,
+			args:   []any{"mainnet", "global", pk},
+			want:   "SEARCH scans USING INDEX idx_scans (network=? AND node=? AND public_key=?)",
+			sorted: true,
+		},
+		{
+			name:   "benchmark history",
+			query:  benchmarkHistoryQuery,
+			args:   []any{"mainnet", "global", pk, hostdb.FailureSkipped},
+			want:   "SEARCH benchmarks USING INDEX idx_benchmarks (network=? AND node=? AND public_key=?)",
+			sorted: true,
+		},
+		{
+			name:  "recent scans",
+			query: recentScansQuery,
+			args:  []any{"mainnet"},
+			want:  "SEARCH scans USING INDEX idx_scans (network=?)",
+		},
+		{
+			name:  "recent benchmarks",
+			query: recentBenchmarksQuery,
+			args:  []any{"mainnet", hostdb.FailureSkipped},
+			want:  "SEARCH benchmarks USING INDEX idx_benchmarks (network=?)",
+		},
+		{
+			name:  "price change count",
+			query: priceChangeCountQuery,
+			args:  []any{"mainnet", pk},
+			want:  "SEARCH price_changes USING COVERING INDEX idx_price_changes (network=? AND public_key=?)",
+		},
+		{
+			name:   "price changes",
+			query:  priceChangesQuery,
+			args:   []any{"mainnet", pk, 0, 1, 10},
+			want:   "SEARCH price_changes USING INDEX idx_price_changes (network=? AND public_key=? AND changed_at>? AND changed_at<?)",
+			sorted: true,
+		},
+		{
+			name:   "price changes since",
+			query:  priceChangesSinceQuery,
+			args:   []any{"mainnet", pk, 0},
+			want:   "SEARCH price_changes USING INDEX idx_price_changes (network=? AND public_key=? AND changed_at>?)",
+			sorted: true,
+		},
+		{
+			name:   "recent price changes",
+			query:  recentPriceChangesQuery,
+			args:   []any{0},
+			want:   "SCAN price_changes USING INDEX idx_price_changes",
+			sorted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := testutil.QueryPlan(t, db, tt.query, tt.args...)
+			if !strings.Contains(plan, tt.want) {
+				t.Fatalf("expected %q in the plan, got:\n%s", tt.want, plan)
+			}
+			if tt.sorted && strings.Contains(plan, "TEMP B-TREE") {
+				t.Fatalf("expected the index to sort the rows, got:\n%s", plan)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+const (
+	// probesInterval determines how often the latency probes are
+	// requested from the nodes. The nodes probe the hosts every 5 minutes.
+	probesInterval = 5 * time.Minute
+
+	// maxProbes is the number of probes kept per host and node, which
+	// makes one day.
+	maxProbes = 288
+
+	// minProbes is the number of successful probes required to calculate
+	// the latency score from the probes rather than from the scans.
+	minProbes = 12
+)
+
+type latencyProbesResponse struct {
+	Probes map[string][]hostdb.LatencyProbe `json:"probes"`
+}
+
+// averageProbeLatency returns the average latency of the successful
+// probes in milliseconds and the number of such probes.
+func averageProbeLatency(probes []hostdb.LatencyProbe) (float64, int) {
+	var total time.Duration
+	var n int
+	for _, probe := range probes {
+		if probe.Success {
+			total += probe.Latency
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return utils.DurationToMS(total) / float64(n), n
+}
+
+// requestProbes pulls the new latency probes from the nodes and updates
+// the latency scores. Only the in-memory scores are updated; the
+// database catches up with the next update of each host.
+func (api *portalAPI) requestProbes() error {
+	var errs []error
+	changed := false
+	for node, c := range api.clients {
+		since := api.probesSince[node]
+		hps, err := api.failover.client(node, c).LatencyProbes("", since)
+		if err != nil {
+			errs = append(errs, utils.AddContext(err, "couldn't get latency probes from "+node))
+			continue
+		}
+
+		api.mu.Lock()
+		for _, hp := range hps {
+			if len(hp.Probes) == 0 {
+				continue
+			}
+			if last := hp.Probes[len(hp.Probes)-1].Timestamp; last.After(api.probesSince[node]) {
+				api.probesSince[node] = last
+			}
+		}
+		api.mu.Unlock()
+
+		w := api.hosts.write()
+		for _, hp := range hps {
+			if len(hp.Probes) == 0 {
+				continue
+			}
+			host, exists := w.get(hp.Network, hp.PublicKey)
+			if !exists {
+				continue
+			}
+			interactions, exists := host.Interactions[node]
+			if !exists {
+				continue
+			}
+			host, _ = w.edit(hp.Network, hp.PublicKey)
+			interactions.probes = append(interactions.probes, hp.Probes...)
+			if len(interactions.probes) > maxProbes {
+				interactions.probes = slices.Clone(interactions.probes[len(interactions.probes)-maxProbes:])
+			}
+			// The scans of an evicted history are incomplete, so the
+			// latency score is only replaced if there are enough probes.
+			if _, n := averageProbeLatency(interactions.probes); n >= minProbes || !interactions.evicted {
+				interactions.Score.LatencyScore = latencyScore(interactions.ScanHistory, interactions.probes)
+				interactions.Score.TotalScore = weights.total(interactions.Score)
+			}
+			host.Interactions[node] = interactions
+			if updateGlobalScore(host, hp.Network) {
+				changed = true
+			}
+		}
+		w.publish()
+	}
+
+	if changed {
+		api.rerankHosts()
+	}
+
+	return errors.Join(errs...)
+}
+
+func (api *portalAPI) hostsLatencyHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	h := req.FormValue("host")
+	if h == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if err := pk.UnmarshalText([]byte(h)); err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+
+	host, exists := api.hosts.load()[network][pk]
+	if !exists || host.OptOut == hostdb.OptOutDelist {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	probes := make(map[string][]hostdb.LatencyProbe)
+	for node, interactions := range host.Interactions {
+		probes[node] = slices.Clone(interactions.probes)
+		if probes[node] == nil {
+			probes[node] = []hostdb.LatencyProbe{}
+		}
+	}
+
+	writeJSON(w, latencyProbesResponse{Probes: probes})
+}
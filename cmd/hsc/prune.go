@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxPruneHosts is the maximum number of hosts checked in one request.
+	maxPruneHosts = 500
+
+	// maxPruneRequestSize is the maximum size of a pruning request in
+	// bytes.
+	maxPruneRequestSize = 64 << 10
+
+	// pruneWindow is the period the price spikes and the score drops are
+	// looked for in.
+	pruneWindow = 7 * 24 * time.Hour
+
+	// offlineStreakThreshold is how long a host has to be offline before
+	// it is flagged.
+	offlineStreakThreshold = 24 * time.Hour
+
+	// priceSpikeThreshold is the relative increase of a price within the
+	// window that flags the host.
+	priceSpikeThreshold = 0.5
+
+	// scoreDropThreshold is the relative decrease of the total score
+	// within the window that flags the host.
+	scoreDropThreshold = 0.5
+)
+
+// Pruning reasons.
+const (
+	pruneUnknown      = "unknown"
+	pruneDelisted     = "delisted"
+	pruneBlocked      = "blocked"
+	pruneQuarantined  = "quarantined"
+	pruneOffline      = "offline"
+	pruneNotAccepting = "notAcceptingContracts"
+	prunePriceSpike   = "priceSpike"
+	pruneScoreDrop    = "scoreDrop"
+)
+
+type pruneRequest struct {
+	Network string            `json:"network"`
+	Hosts   []types.PublicKey `json:"hosts"`
+}
+
+// pruneReason tells why a host is considered risky. Value is the number
+// the decision was made on, if there is one: the hours offline, the
+// relative price increase, or the relative score decrease.
+type pruneReason struct {
+	Code    string     `json:"code"`
+	Message string     `json:"message"`
+	Since   *time.Time `json:"since,omitempty"`
+	Value   float64    `json:"value,omitempty"`
+}
+
+// pruneCandidate is the verdict on one of the renter's hosts.
+type pruneCandidate struct {
+	PublicKey types.PublicKey `json:"publicKey"`
+	Risky     bool            `json:"risky"`
+	Rank      int             `json:"rank,omitempty"`
+	Score     float64         `json:"score"`
+	Reasons   []pruneReason   `json:"reasons"`
+}
+
+// lastSuccessfulScan returns the time of the most recent successful scan
+// of the host by any node. If there is none in the scan history, the
+// time of the oldest scan is returned, since the host has been offline
+// at least as long.
+func lastSuccessfulScan(host *portalHost) (last time.Time) {
+	var oldest time.Time
+	for _, interactions := range host.Interactions {
+		for _, scan := range interactions.ScanHistory {
+			if scan.Success && scan.Timestamp.After(last) {
+				last = scan.Timestamp
+			}
+			if oldest.IsZero() || scan.Timestamp.Before(oldest) {
+				oldest = scan.Timestamp
+			}
+		}
+	}
+	if last.IsZero() {
+		return oldest
+	}
+	return
+}
+
+// priceIncrease returns the largest relative increase of the storage,
+// upload, and download prices from the old to the new values.
+func priceIncrease(op, np priceChange) (increase float64) {
+	pairs := [][2]types.Currency{
+		{op.StoragePrice, np.StoragePrice},
+		{op.UploadPrice, np.UploadPrice},
+		{op.DownloadPrice, np.DownloadPrice},
+	}
+	for _, p := range pairs {
+		o, n := p[0].Siacoins(), p[1].Siacoins()
+		if o == 0 || n <= o {
+			continue
+		}
+		increase = max(increase, (n-o)/o)
+	}
+	return
+}
+
+// pastScore returns the latest stored total score of the host at or
+// before the given time. It returns false if there is no such score.
+func (api *portalAPI) pastScore(network string, pk types.PublicKey, at time.Time) (float64, bool, error) {
+	var score float64
+	err := api.db.QueryRow(`
+		SELECT total_score
+		FROM score_history
+		WHERE network = ?
+		AND public_key = ?
+		AND day <= ?
+		ORDER BY day DESC
+		LIMIT 1
+	`, network, pk[:], at.Unix()/86400).Scan(&score)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, utils.AddContext(err, "couldn't query score history")
+	}
+	return score, true, nil
+}
+
+// checkPruneCandidate collects the reasons for dropping the host.
+func (api *portalAPI) checkPruneCandidate(network string, pk types.PublicKey) (pruneCandidate, error) {
+	pc := pruneCandidate{PublicKey: pk}
+	host, exists := api.hosts.load()[network][pk]
+	if !exists {
+		pc.Reasons = append(pc.Reasons, pruneReason{
+			Code:    pruneUnknown,
+			Message: "host is not known",
+		})
+		pc.Risky = true
+		return pc, nil
+	}
+	if host.OptOut == hostdb.OptOutDelist {
+		pc.Reasons = append(pc.Reasons, pruneReason{
+			Code:    pruneDelisted,
+			Message: "host has opted out of the listing",
+		})
+		pc.Risky = true
+		return pc, nil
+	}
+	pc.Rank = host.Rank
+	pc.Score = host.Score.TotalScore
+
+	if host.Blocked {
+		pc.Reasons = append(pc.Reasons, pruneReason{
+			Code:    pruneBlocked,
+			Message: "host is blocked",
+		})
+	}
+	if host.Quarantined {
+		pc.Reasons = append(pc.Reasons, pruneReason{
+			Code:    pruneQuarantined,
+			Message: "host is quarantined",
+		})
+	}
+
+	if !isOnline(*host) {
+		since := lastSuccessfulScan(host)
+		if !since.IsZero() && time.Since(since) >= offlineStreakThreshold {
+			hours := time.Since(since).Hours()
+			pc.Reasons = append(pc.Reasons, pruneReason{
+				Code:    pruneOffline,
+				Message: fmt.Sprintf("host has been offline for %.0f hours", hours),
+				Since:   &since,
+				Value:   hours,
+			})
+		}
+	}
+
+	if !host.Settings.AcceptingContracts {
+		pc.Reasons = append(pc.Reasons, pruneReason{
+			Code:    pruneNotAccepting,
+			Message: "host is not accepting contracts",
+		})
+	}
+
+	from := time.Now().Add(-pruneWindow)
+	changes, err := api.priceChangesSince(network, pk, from)
+	if err != nil {
+		return pruneCandidate{}, err
+	}
+	current := priceChange{
+		StoragePrice:  host.Settings.StoragePrice,
+		UploadPrice:   host.Settings.UploadBandwidthPrice,
+		DownloadPrice: host.Settings.DownloadBandwidthPrice,
+	}
+	var spike float64
+	var spikeSince time.Time
+	for _, change := range changes {
+		if increase := priceIncrease(change, current); increase > spike {
+			spike = increase
+			spikeSince = change.Timestamp
+		}
+	}
+	if spike >= priceSpikeThreshold {
+		pc.Reasons = append(pc.Reasons, pruneReason{
+			Code:    prunePriceSpike,
+			Message: fmt.Sprintf("prices have increased by %.0f%%", spike*100),
+			Since:   &spikeSince,
+			Value:   spike,
+		})
+	}
+
+	past, ok, err := api.pastScore(network, pk, from)
+	if err != nil {
+		return pruneCandidate{}, err
+	}
+	if ok && past > 0 {
+		if drop := (past - host.Score.TotalScore) / past; drop >= scoreDropThreshold {
+			day := from.Truncate(24 * time.Hour)
+			pc.Reasons = append(pc.Reasons, pruneReason{
+				Code:    pruneScoreDrop,
+				Message: fmt.Sprintf("score has dropped by %.0f%%", drop*100),
+				Since:   &day,
+				Value:   drop,
+			})
+		}
+	}
+
+	pc.Risky = len(pc.Reasons) > 0
+	if pc.Reasons == nil {
+		pc.Reasons = []pruneReason{}
+	}
+	return pc, nil
+}
+
+func (api *portalAPI) hostsPruneHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	var pr pruneRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxPruneRequestSize)).Decode(&pr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	pr.Network = strings.ToLower(pr.Network)
+	if pr.Network == "" {
+		pr.Network = "mainnet"
+	}
+	if pr.Network != "mainnet" && pr.Network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	if len(pr.Hosts) == 0 {
+		writeError(w, "no hosts provided", http.StatusBadRequest)
+		return
+	}
+	if len(pr.Hosts) > maxPruneHosts {
+		writeError(w, fmt.Sprintf("too many hosts, at most %d allowed", maxPruneHosts), http.StatusBadRequest)
+		return
+	}
+
+	candidates := make([]pruneCandidate, 0, len(pr.Hosts))
+	for _, pk := range pr.Hosts {
+		pc, err := api.checkPruneCandidate(pr.Network, pk)
+		if err != nil {
+			api.log.Error("couldn't check host", zap.String("network", pr.Network), zap.Stringer("host", pk), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		candidates = append(candidates, pc)
+	}
+
+	writeJSON(w, candidates)
+}
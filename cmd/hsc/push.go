@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	client "github.com/mike76-dev/hostscore/api"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.uber.org/zap"
+)
+
+// pushRetryInterval is how long the portal waits before reconnecting to
+// a node in push mode.
+const pushRetryInterval = 30 * time.Second
+
+// errPushSourceChanged is returned when hsc has switched between the
+// primary and the standby node while a push connection was open.
+var errPushSourceChanged = errors.New("update source has changed")
+
+// pushedUpdates is a batch of updates pushed by a node, along with the
+// node it came from.
+type pushedUpdates struct {
+	updates hostdb.HostUpdates
+	src     *client.Client
+	standby bool
+}
+
+// watchNodeUpdates keeps a push connection to the node open and hands the
+// pushed batches over to the update loop. The loop keeps polling the node
+// in case the connection breaks.
+func (api *portalAPI) watchNodeUpdates(ctx context.Context, node string, c *client.Client, pushed chan<- pushedUpdates) {
+	for {
+		src, standby := api.failover.source(node, c)
+		limit := api.schedule.get(node).BatchSize
+		err := src.WithContext(ctx).WatchUpdates(limit, func(updates hostdb.HostUpdates) error {
+			// The batch must be confirmed on the node it came from, so the
+			// connection is reopened after a switch.
+			if current, _ := api.failover.source(node, c); current != src {
+				return errPushSourceChanged
+			}
+			select {
+			case pushed <- pushedUpdates{updates: updates, src: src, standby: standby}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		api.log.Debug("push connection closed", zap.String("node", node), zap.Error(err))
+		if errors.Is(err, errPushSourceChanged) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pushRetryInterval):
+		}
+	}
+}
+
+// insertPushedUpdates ingests a batch of updates pushed by the node, like
+// fetchNodeUpdates does with a polled one.
+func (api *portalAPI) insertPushedUpdates(node string, c *client.Client, pu pushedUpdates) (received, backlog int, ok bool) {
+	received = countUpdates(pu.updates)
+	api.failover.update(node, c, pu.updates.ID, received, nil)
+	if err := api.insertUpdates(node, pu.src, pu.standby, pu.updates); err != nil {
+		api.log.Error("failed to insert updates", zap.String("node", node), zap.Error(err))
+	}
+	return received, pu.updates.Backlog, true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	client "github.com/mike76-dev/hostscore/api"
+	"github.com/mike76-dev/hostscore/hostdb"
+)
+
+func TestWatchUpdates(t *testing.T) {
+	// The node sends a keep-alive line and a batch, then closes.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/hostdb/updates/push" || req.FormValue("limit") != "10" {
+			http.NotFound(w, req)
+			return
+		}
+		id := hostdb.UpdateID(7)
+		enc := json.NewEncoder(w)
+		for _, item := range []hostdb.UpdateItem{
+			{},
+			{Seq: id},
+			{Host: &hostdb.HostDBEntry{ID: 1}},
+			{Scan: &hostdb.ScanHistory{}},
+			{ID: &id, Backlog: 3},
+		} {
+			enc.Encode(item)
+		}
+	}))
+	defer srv.Close()
+
+	var batches []hostdb.HostUpdates
+	err := client.NewClient(srv.URL, "").WatchUpdates(10, func(updates hostdb.HostUpdates) error {
+		batches = append(batches, updates)
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected the closed connection to be reported")
+	}
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 batch, got %d", len(batches))
+	}
+	if b := batches[0]; b.ID != 7 || b.Backlog != 3 || len(b.Hosts) != 1 || len(b.Scans) != 1 {
+		t.Fatalf("unexpected batch %+v", b)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.uber.org/zap"
+)
+
+// rankPushInterval is how often the host ranks are pushed to the nodes.
+// The ranks don't change much within an hour.
+const rankPushInterval = time.Hour
+
+// pushRanks sends the ranks of the online hosts to all nodes, which use
+// them to schedule the benchmarks.
+func (api *portalAPI) pushRanks() {
+	var list []hostdb.HostRank
+	for network, hosts := range api.hosts.load() {
+		for pk, host := range hosts {
+			if host.Rank > 0 && host.OptOut != hostdb.OptOutDelist && isOnline(*host) {
+				list = append(list, hostdb.HostRank{
+					Network:   network,
+					PublicKey: pk,
+					Rank:      host.Rank,
+				})
+			}
+		}
+	}
+
+	for node, c := range api.clients {
+		if err := c.SetRanks(list); err != nil {
+			api.log.Warn("couldn't push host ranks", zap.String("node", node), zap.Error(err))
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 )
 
+// maxRequestsPerSecond is the rate limit of the anonymous clients.
 const maxRequestsPerSecond = 10
 
 // ratelimiter keeps the API request stats and determines whether
@@ -38,13 +39,14 @@ func newRatelimiter(stopChan chan struct{}) *ratelimiter {
 	return rl
 }
 
-// limitExceeded returns true if there are too many requests from the given host.
-func (rl *ratelimiter) limitExceeded(addr string) bool {
+// limitExceeded returns true if there are more requests per second from
+// the given client than the limit allows.
+func (rl *ratelimiter) limitExceeded(client string, limit int) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	rl.requests[addr]++
-	return rl.requests[addr] > maxRequestsPerSecond
+	rl.requests[client]++
+	return rl.requests[client] > limit
 }
 
 // getRemoteHost returns the address of the remote host.
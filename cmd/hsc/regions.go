@@ -0,0 +1,95 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+)
+
+// nodeLocation describes where a node is running.
+type nodeLocation struct {
+	Region   string `json:"region,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// regionBenchmarks summarizes the successful benchmarks run from the
+// nodes of a region.
+type regionBenchmarks struct {
+	Nodes         []string      `json:"nodes"`
+	Benchmarks    int           `json:"benchmarks"`
+	UploadSpeed   float64       `json:"uploadSpeed"`
+	DownloadSpeed float64       `json:"downloadSpeed"`
+	TTFB          time.Duration `json:"ttfb"`
+}
+
+// location returns the location of the node as configured.
+func (s *jsonStore) location(node string) nodeLocation {
+	n := s.nodes[node]
+	return nodeLocation{
+		Region:   n.Region,
+		Country:  n.Country,
+		Provider: n.Provider,
+	}
+}
+
+// nodeLocation returns the location of the node. In the mirror mode,
+// the location is taken from the status of the primary portal.
+// NOTE: a lock must be acquired before calling nodeLocation.
+func (api *portalAPI) nodeLocation(node string) nodeLocation {
+	if _, ok := api.store.nodes[node]; ok {
+		return api.store.location(node)
+	}
+	return api.nodes[node].Location
+}
+
+// regionNodes returns the nodes located in the region.
+// NOTE: a lock must be acquired before calling regionNodes.
+func (api *portalAPI) regionNodes(region string) (nodes []string) {
+	for node := range api.nodes {
+		if strings.EqualFold(api.nodeLocation(node).Region, region) {
+			nodes = append(nodes, node)
+		}
+	}
+	slices.Sort(nodes)
+	return
+}
+
+// summarizeRegions averages the successful benchmarks by the region of
+// the node that ran them. The nodes without a region are skipped.
+// NOTE: a lock must be acquired before calling summarizeRegions.
+func (api *portalAPI) summarizeRegions(benchmarks []hostdb.BenchmarkHistory) map[string]regionBenchmarks {
+	regions := make(map[string]regionBenchmarks)
+	ttfb := make(map[string]time.Duration)
+	for _, b := range benchmarks {
+		region := strings.ToLower(api.nodeLocation(b.Node).Region)
+		if region == "" {
+			continue
+		}
+		rb := regions[region]
+		if !slices.Contains(rb.Nodes, b.Node) {
+			rb.Nodes = append(rb.Nodes, b.Node)
+			slices.Sort(rb.Nodes)
+		}
+		if b.Success {
+			rb.Benchmarks++
+			rb.UploadSpeed += b.UploadSpeed
+			rb.DownloadSpeed += b.DownloadSpeed
+			ttfb[region] += b.TTFB
+		}
+		regions[region] = rb
+	}
+
+	for region, rb := range regions {
+		if rb.Benchmarks > 0 {
+			rb.UploadSpeed /= float64(rb.Benchmarks)
+			rb.DownloadSpeed /= float64(rb.Benchmarks)
+			rb.TTFB = ttfb[region] / time.Duration(rb.Benchmarks)
+		}
+		regions[region] = rb
+	}
+
+	return regions
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// renterdAllowlistUpdate is the body of a renterd bus request updating
+// the host allowlist.
+type renterdAllowlistUpdate struct {
+	Add    []types.PublicKey `json:"add"`
+	Remove []types.PublicKey `json:"remove"`
+	Clear  bool              `json:"clear"`
+}
+
+// renterdBlocklistUpdate is the body of a renterd bus request updating
+// the host blocklist, which contains host names and IP addresses.
+type renterdBlocklistUpdate struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+	Clear  bool     `json:"clear"`
+}
+
+// hostsRenterdHandler returns the hosts selected like by hostsKeysHandler
+// as a renterd allowlist or blocklist update. Unless clear is false, the
+// update replaces the list, so that the renter stays in sync with the
+// selection.
+func (api *portalAPI) hostsRenterdHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	list := strings.ToLower(req.FormValue("list"))
+	if list == "" {
+		list = "allowlist"
+	}
+	if list != "allowlist" && list != "blocklist" {
+		writeError(w, "invalid list", http.StatusBadRequest)
+		return
+	}
+	replace := true
+	switch strings.ToLower(req.FormValue("clear")) {
+	case "", "true":
+	case "false":
+		replace = false
+	default:
+		writeError(w, "invalid clear parameter", http.StatusBadRequest)
+		return
+	}
+
+	network, keys, ok := api.selectHostKeys(w, req)
+	if !ok {
+		return
+	}
+
+	if list == "allowlist" {
+		if keys == nil {
+			keys = []types.PublicKey{}
+		}
+		writeJSON(w, renterdAllowlistUpdate{
+			Add:    keys,
+			Remove: []types.PublicKey{},
+			Clear:  replace,
+		})
+		return
+	}
+
+	// The blocklist holds the host names, so the keys are translated.
+	update := renterdBlocklistUpdate{
+		Add:    []string{},
+		Remove: []string{},
+		Clear:  replace,
+	}
+	seen := make(map[string]struct{})
+	hosts := api.hosts.load()
+	for _, pk := range keys {
+		host, exists := hosts[network][pk]
+		if !exists {
+			continue
+		}
+		name, _, err := net.SplitHostPort(host.NetAddress)
+		if err != nil || name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		update.Add = append(update.Add, name)
+	}
+	writeJSON(w, update)
+}
+
+// runRenterd fetches a host selection from a portal and either prints it
+// as a renterd list update or applies it on a renterd bus.
+func runRenterd(args []string) {
+	fs := flag.NewFlagSet("renterd", flag.ExitOnError)
+	portalURL := fs.String("portal", "https://api.hostscore.info/v1", "URL of the portal API")
+	network := fs.String("network", "mainnet", "network of the hosts: mainnet or zen")
+	list := fs.String("list", "allowlist", "list to update: allowlist or blocklist")
+	filter := fs.String("filter", "", "criteria of the host selection as a query string, e.g. \"maxStoragePrice=200SC&limit=50\"; see /hosts/keys")
+	keep := fs.Bool("keep", false, "add the hosts to the list instead of replacing it")
+	busURL := fs.String("renterd", "", "URL of the renterd bus API, e.g. http://localhost:9980/api/bus; if empty, the update is printed")
+	password := fs.String("password", "", "renterd API password; defaults to RENTERD_API_PASSWORD")
+	fs.Parse(args)
+
+	query, err := url.ParseQuery(*filter)
+	if err != nil {
+		log.Fatalf("Invalid filter: %v\n", err)
+	}
+	query.Set("network", *network)
+	query.Set("list", *list)
+	query.Set("clear", fmt.Sprint(!*keep))
+
+	body, err := fetchRenterdList(strings.TrimSuffix(*portalURL, "/"), query)
+	if err != nil {
+		log.Fatalf("Couldn't fetch the hosts: %v\n", err)
+	}
+
+	if *busURL == "" {
+		os.Stdout.Write(body)
+		return
+	}
+
+	if *password == "" {
+		*password = os.Getenv("RENTERD_API_PASSWORD")
+	}
+	route := strings.TrimSuffix(*busURL, "/") + "/hosts/" + *list
+	if err := pushRenterdList(route, *password, body); err != nil {
+		log.Fatalf("Couldn't update the %s: %v\n", *list, err)
+	}
+	log.Printf("Updated the renterd %s\n", *list)
+}
+
+// fetchRenterdList retrieves the renterd list update from the portal.
+func fetchRenterdList(portalURL string, query url.Values) ([]byte, error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(portalURL + "/hosts/renterd?" + query.Encode())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query portal")
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't read response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		var msg string
+		if json.Unmarshal(body, &msg) != nil {
+			msg = strings.TrimSpace(string(body))
+		}
+		return nil, fmt.Errorf("portal returned %s: %s", resp.Status, msg)
+	}
+	return body, nil
+}
+
+// pushRenterdList applies the list update on the renterd bus.
+func pushRenterdList(route, password string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, route, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("", password)
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return utils.AddContext(err, "couldn't reach renterd")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("renterd returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxReportReason is the maximum length of the reason in bytes.
+	maxReportReason = 1000
+
+	// maxReportsPerDay is the number of reports a single user can submit
+	// per 24 hours.
+	maxReportsPerDay = 10
+
+	// maxReportSize is the maximum size of a report request in bytes.
+	maxReportSize = 16 << 10
+)
+
+// Report statuses.
+const (
+	reportOpen      = "open"
+	reportDismissed = "dismissed"
+	reportAccepted  = "accepted"
+)
+
+// reportCategories lists the reasons a host can be reported for.
+var reportCategories = map[string]struct{}{
+	"spam":    {},
+	"illegal": {},
+	"scam":    {},
+	"other":   {},
+}
+
+var errTooManyReports = errors.New("too many reports")
+
+// hostReport is a user complaint about a host.
+type hostReport struct {
+	ID         int64           `json:"id"`
+	Network    string          `json:"network"`
+	PublicKey  types.PublicKey `json:"publicKey"`
+	Category   string          `json:"category"`
+	Reason     string          `json:"reason"`
+	Reporter   string          `json:"reporter"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Status     string          `json:"status"`
+	ReviewedAt time.Time       `json:"reviewedAt"`
+}
+
+type reportRequest struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Category  string          `json:"category"`
+	Reason    string          `json:"reason"`
+}
+
+type reviewRequest struct {
+	Status     string `json:"status"`
+	Quarantine bool   `json:"quarantine"`
+}
+
+type quarantineRequest struct {
+	Network     string          `json:"network"`
+	PublicKey   types.PublicKey `json:"publicKey"`
+	Quarantined bool            `json:"quarantined"`
+}
+
+// reporterID returns an anonymous ID of the reporter, so that the reports
+// from the same user can be grouped without storing their IP address.
+func reporterID(addr string) string {
+	h := types.HashBytes([]byte(addr))
+	return hex.EncodeToString(h[:8])
+}
+
+// saveReport adds a new report to the database.
+func (api *portalAPI) saveReport(r reportRequest, reporter string) error {
+	var count int
+	err := api.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM host_reports
+		WHERE reporter = ?
+		AND created_at > ?
+	`, reporter, time.Now().Add(-24*time.Hour).Unix()).Scan(&count)
+	if err != nil {
+		return utils.AddContext(err, "couldn't count reports")
+	}
+	if count >= maxReportsPerDay {
+		return errTooManyReports
+	}
+
+	_, err = api.db.Exec(`
+		INSERT INTO host_reports (
+			network,
+			public_key,
+			category,
+			reason,
+			reporter,
+			created_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, r.Network, r.PublicKey[:], r.Category, r.Reason, reporter, time.Now().Unix())
+	if err != nil {
+		return utils.AddContext(err, "couldn't save report")
+	}
+
+	return nil
+}
+
+// getReports retrieves the reports with the given status. If the status
+// or the network is empty, all reports are returned.
+func (api *portalAPI) getReports(status, network string, offset, limit int) (reports []hostReport, err error) {
+	rows, err := api.db.Query(`
+		SELECT
+			id,
+			network,
+			public_key,
+			category,
+			reason,
+			reporter,
+			created_at,
+			status,
+			reviewed_at
+		FROM host_reports
+		WHERE (? = '' OR status = ?)
+		AND (? = '' OR network = ?)
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, status, status, network, network, limit, offset)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query reports")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r hostReport
+		pk := make([]byte, 32)
+		var created, reviewed int64
+		if err := rows.Scan(
+			&r.ID,
+			&r.Network,
+			&pk,
+			&r.Category,
+			&r.Reason,
+			&r.Reporter,
+			&created,
+			&r.Status,
+			&reviewed,
+		); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode report")
+		}
+		r.PublicKey = types.PublicKey(pk)
+		r.CreatedAt = time.Unix(created, 0)
+		if reviewed > 0 {
+			r.ReviewedAt = time.Unix(reviewed, 0)
+		}
+		reports = append(reports, r)
+	}
+
+	return reports, rows.Err()
+}
+
+// reviewReport sets the status of the report and returns the host it
+// refers to.
+func (api *portalAPI) reviewReport(id int64, status string) (network string, pk types.PublicKey, err error) {
+	key := make([]byte, 32)
+	err = api.db.QueryRow(`
+		SELECT network, public_key
+		FROM host_reports
+		WHERE id = ?
+	`, id).Scan(&network, &key)
+	if err != nil {
+		return "", types.PublicKey{}, err
+	}
+
+	_, err = api.db.Exec(`
+		UPDATE host_reports
+		SET status = ?, reviewed_at = ?
+		WHERE id = ?
+	`, status, time.Now().Unix(), id)
+	if err != nil {
+		return "", types.PublicKey{}, utils.AddContext(err, "couldn't update report")
+	}
+
+	return network, types.PublicKey(key), nil
+}
+
+// setQuarantined puts the host in the quarantine or releases it from
+// there. Quarantined hosts are ranked last and hidden from the default
+// host list.
+func (api *portalAPI) setQuarantined(network string, pk types.PublicKey, quarantined bool) error {
+	w := api.hosts.write()
+	defer w.discard()
+
+	host, ok := w.edit(network, pk)
+	if !ok {
+		return errHostNotFound
+	}
+
+	_, err := api.db.Exec(`
+		UPDATE hosts
+		SET quarantined = ?
+		WHERE network = ?
+		AND public_key = ?
+	`, quarantined, network, pk[:])
+	if err != nil {
+		return utils.AddContext(err, "couldn't update host")
+	}
+
+	host.Quarantined = quarantined
+	w.publish()
+	api.rankPending.Store(true)
+
+	return nil
+}
+
+func (api *portalAPI) hostsReportHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if api.mirrorURL != "" {
+		writeError(w, "reports are not accepted by a mirror", http.StatusForbidden)
+		return
+	}
+
+	var rr reportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxReportSize)).Decode(&rr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	rr.Network = strings.ToLower(rr.Network)
+	if rr.Network == "" {
+		rr.Network = "mainnet"
+	}
+	if rr.Network != "mainnet" && rr.Network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	rr.Category = strings.ToLower(rr.Category)
+	if _, ok := reportCategories[rr.Category]; !ok {
+		writeError(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+	rr.Reason = strings.TrimSpace(rr.Reason)
+	if rr.Reason == "" {
+		writeError(w, "reason not provided", http.StatusBadRequest)
+		return
+	}
+	if len(rr.Reason) > maxReportReason {
+		writeError(w, "reason too long", http.StatusBadRequest)
+		return
+	}
+	if _, ok := api.hosts.load()[rr.Network][rr.PublicKey]; !ok {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	err := api.saveReport(rr, reporterID(getRemoteHost(req)))
+	if errors.Is(err, errTooManyReports) {
+		writeError(w, "too many reports", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't save report", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkAdmin returns true if the request carries the admin password.
+func (api *portalAPI) checkAdmin(w http.ResponseWriter, req *http.Request) bool {
+	if api.adminPassword == "" {
+		writeError(w, "admin API disabled", http.StatusForbidden)
+		return false
+	}
+	_, password, ok := req.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(api.adminPassword)) != 1 {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (api *portalAPI) adminReportsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	status := strings.ToLower(req.FormValue("status"))
+	if status != "" && status != reportOpen && status != reportDismissed && status != reportAccepted {
+		writeError(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network != "" && network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	offset, limit := int64(0), int64(100)
+	var err error
+	if off := req.FormValue("offset"); off != "" {
+		offset, err = strconv.ParseInt(off, 10, 64)
+		if err != nil || offset < 0 {
+			writeError(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if lim := req.FormValue("limit"); lim != "" {
+		limit, err = strconv.ParseInt(lim, 10, 64)
+		if err != nil || limit <= 0 {
+			writeError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reports, err := api.getReports(status, network, int(offset), int(limit))
+	if err != nil {
+		api.log.Error("couldn't get reports", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, reports)
+}
+
+func (api *portalAPI) adminReviewHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid report ID", http.StatusBadRequest)
+		return
+	}
+	var rr reviewRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxReportSize)).Decode(&rr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	if rr.Status != reportDismissed && rr.Status != reportAccepted {
+		writeError(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	if rr.Quarantine && rr.Status != reportAccepted {
+		writeError(w, "only an accepted report can lead to quarantine", http.StatusBadRequest)
+		return
+	}
+
+	network, pk, err := api.reviewReport(id, rr.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't review report", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if rr.Quarantine {
+		if err := api.setQuarantined(network, pk, true); err != nil && !errors.Is(err, errHostNotFound) {
+			api.log.Error("couldn't quarantine host", zap.Stringer("host", pk), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *portalAPI) adminQuarantineHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if !api.checkAdmin(w, req) {
+		return
+	}
+	if api.mirrorURL != "" {
+		writeError(w, "a mirror cannot quarantine hosts", http.StatusForbidden)
+		return
+	}
+	var qr quarantineRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxReportSize)).Decode(&qr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	network := strings.ToLower(qr.Network)
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+
+	err := api.setQuarantined(network, qr.PublicKey, qr.Quarantined)
+	if errors.Is(err, errHostNotFound) {
+		writeError(w, "host not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't quarantine host", zap.Stringer("host", qr.PublicKey), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+const (
+	// rollupInterval determines how often the new scans and benchmarks
+	// are rolled up.
+	rollupInterval = time.Hour
+
+	// rollupDelay is how long after the end of a period its scans and
+	// benchmarks are rolled up, so that the late updates from the nodes
+	// are included.
+	rollupDelay = time.Hour
+)
+
+// rollupSpans maps the granularities to the length of their periods in
+// seconds.
+var rollupSpans = map[string]int64{
+	"hourly": 3600,
+	"daily":  86400,
+}
+
+// parseGranularity returns the period length of the granularity, or zero
+// for the raw records.
+func parseGranularity(granularity string) (int64, bool) {
+	if granularity == "" || granularity == "raw" {
+		return 0, true
+	}
+	span, ok := rollupSpans[granularity]
+	return span, ok
+}
+
+// scanRollup summarizes the scans of a host by a node over a period.
+// The latency is the average of the successful scans.
+type scanRollup struct {
+	Timestamp   time.Time     `json:"timestamp"`
+	Scans       int           `json:"scans"`
+	Successes   int           `json:"successes"`
+	SuccessRate float64       `json:"successRate"`
+	Latency     time.Duration `json:"latency"`
+	Node        string        `json:"node"`
+}
+
+type scanRollupsResponse struct {
+	Granularity string       `json:"granularity"`
+	Scans       []scanRollup `json:"scans"`
+}
+
+// benchmarkRollup summarizes the benchmarks of a host by a node over a
+// period. The speeds and the TTFB are the averages of the successful
+// benchmarks.
+type benchmarkRollup struct {
+	Timestamp     time.Time     `json:"timestamp"`
+	Benchmarks    int           `json:"benchmarks"`
+	Successes     int           `json:"successes"`
+	SuccessRate   float64       `json:"successRate"`
+	UploadSpeed   float64       `json:"uploadSpeed"`
+	DownloadSpeed float64       `json:"downloadSpeed"`
+	TTFB          time.Duration `json:"ttfb"`
+	Node          string        `json:"node"`
+}
+
+type benchmarkRollupsResponse struct {
+	Granularity string            `json:"granularity"`
+	Benchmarks  []benchmarkRollup `json:"benchmarks"`
+}
+
+// rollUp summarizes the scans and the benchmarks of the complete periods
+// that haven't been rolled up yet. The summaries are kept forever, while
+// the raw scans are pruned.
+func (api *portalAPI) rollUp() error {
+	end := time.Now().Add(-rollupDelay).Unix()
+	for _, span := range rollupSpans {
+		if err := api.rollUpScans(span, end-end%span); err != nil {
+			return utils.AddContext(err, "couldn't roll up scans")
+		}
+		if err := api.rollUpBenchmarks(span, end-end%span); err != nil {
+			return utils.AddContext(err, "couldn't roll up benchmarks")
+		}
+	}
+	return nil
+}
+
+// rollupStart returns the beginning of the first period of the source
+// table that hasn't been rolled up yet.
+func (api *portalAPI) rollupStart(table, source string, span int64) (int64, error) {
+	var last int64
+	err := api.db.QueryRow("SELECT COALESCE(MAX(period), -1) FROM "+table+" WHERE span = ?", span).Scan(&last)
+	if err != nil {
+		return 0, utils.AddContext(err, "couldn't get last rollup")
+	}
+	if last >= 0 {
+		return last + span, nil
+	}
+	var first int64
+	if err := api.db.QueryRow("SELECT COALESCE(MIN(ran_at), 0) FROM " + source).Scan(&first); err != nil {
+		return 0, utils.AddContext(err, "couldn't get first record")
+	}
+	return first - first%span, nil
+}
+
+// rollUpScans summarizes the scans made between the start of the first
+// pending period and end.
+func (api *portalAPI) rollUpScans(span, end int64) error {
+	start, err := api.rollupStart("scan_rollups", "scans", span)
+	if err != nil || start >= end {
+		return err
+	}
+
+	s := strconv.FormatInt(span, 10)
+	rows, err := api.db.Query(`
+		SELECT
+			network,
+			node,
+			public_key,
+			ran_at - ran_at % `+s+` AS period,
+			COUNT(*),
+			SUM(CASE WHEN success THEN 1 ELSE 0 END),
+			AVG(CASE WHEN success THEN latency END)
+		FROM scans
+		WHERE ran_at >= ?
+		AND ran_at < ?
+		GROUP BY network, node, public_key, period
+	`, start, end)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query scans")
+	}
+
+	type rollup struct {
+		network   string
+		node      string
+		pk        []byte
+		period    int64
+		scans     int
+		successes int
+		latency   sql.NullFloat64
+	}
+	var rollups []rollup
+	for rows.Next() {
+		var r rollup
+		if err := rows.Scan(&r.network, &r.node, &r.pk, &r.period, &r.scans, &r.successes, &r.latency); err != nil {
+			rows.Close()
+			return utils.AddContext(err, "couldn't decode scans")
+		}
+		rollups = append(rollups, r)
+	}
+	rows.Close()
+
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO scan_rollups (
+			network,
+			node,
+			public_key,
+			span,
+			period,
+			scans,
+			successes,
+			latency
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	for _, r := range rollups {
+		if _, err := stmt.Exec(r.network, r.node, r.pk, span, r.period, r.scans, r.successes, r.latency.Float64); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't save scan rollup")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rollUpBenchmarks summarizes the benchmarks run between the start of the
+// first pending period and end.
+func (api *portalAPI) rollUpBenchmarks(span, end int64) error {
+	start, err := api.rollupStart("benchmark_rollups", "benchmarks", span)
+	if err != nil || start >= end {
+		return err
+	}
+
+	s := strconv.FormatInt(span, 10)
+	rows, err := api.db.Query(`
+		SELECT
+			network,
+			node,
+			public_key,
+			ran_at - ran_at % `+s+` AS period,
+			COUNT(*),
+			SUM(CASE WHEN success THEN 1 ELSE 0 END),
+			AVG(CASE WHEN success THEN upload_speed END),
+			AVG(CASE WHEN success THEN download_speed END),
+			AVG(CASE WHEN success THEN ttfb END)
+		FROM benchmarks
+		WHERE ran_at >= ?
+		AND ran_at < ?
+		GROUP BY network, node, public_key, period
+	`, start, end)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query benchmarks")
+	}
+
+	type rollup struct {
+		network    string
+		node       string
+		pk         []byte
+		period     int64
+		benchmarks int
+		successes  int
+		ul, dl     sql.NullFloat64
+		ttfb       sql.NullFloat64
+	}
+	var rollups []rollup
+	for rows.Next() {
+		var r rollup
+		if err := rows.Scan(&r.network, &r.node, &r.pk, &r.period, &r.benchmarks, &r.successes, &r.ul, &r.dl, &r.ttfb); err != nil {
+			rows.Close()
+			return utils.AddContext(err, "couldn't decode benchmarks")
+		}
+		rollups = append(rollups, r)
+	}
+	rows.Close()
+
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO benchmark_rollups (
+			network,
+			node,
+			public_key,
+			span,
+			period,
+			benchmarks,
+			successes,
+			upload_speed,
+			download_speed,
+			ttfb
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	for _, r := range rollups {
+		if _, err := stmt.Exec(r.network, r.node, r.pk, span, r.period, r.benchmarks, r.successes, r.ul.Float64, r.dl.Float64, r.ttfb.Float64); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't save benchmark rollup")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rollupRange converts the time range of a request to the bounds of the
+// periods, and the limit to the number of rows.
+func rollupRange(from, to time.Time, limit int64) (int64, int64, int64) {
+	f := int64(0)
+	t := time.Now().Unix()
+	if from.Unix() != (time.Time{}).Unix() {
+		f = from.Unix()
+	}
+	if to.Unix() != (time.Time{}).Unix() {
+		t = to.Unix()
+	}
+	if limit < 0 {
+		limit = math.MaxInt64
+	}
+	return f, t, limit
+}
+
+// getScanRollups returns the scan summaries of the host, the newest
+// first. If node is "global", the summaries of all nodes are returned.
+func (api *portalAPI) getScanRollups(network, node string, pk types.PublicKey, span int64, from, to time.Time, limit int64) ([]scanRollup, error) {
+	if _, ok := api.hosts.load()[network][pk]; !ok {
+		return nil, errHostNotFound
+	}
+	f, t, limit := rollupRange(from, to, limit)
+
+	rows, err := api.db.Query(`
+		SELECT node, period, scans, successes, latency
+		FROM scan_rollups
+		WHERE network = ?
+		AND (? OR node = ?)
+		AND public_key = ?
+		AND span = ?
+		AND period >= ?
+		AND period <= ?
+		ORDER BY period DESC
+		LIMIT ?
+	`, network, node == "global", node, pk[:], span, f-f%span, t, limit)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query scan rollups")
+	}
+	defer rows.Close()
+
+	rollups := []scanRollup{}
+	for rows.Next() {
+		var r scanRollup
+		var period int64
+		var latency float64
+		if err := rows.Scan(&r.Node, &period, &r.Scans, &r.Successes, &latency); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode scan rollup")
+		}
+		r.Timestamp = time.Unix(period, 0)
+		r.Latency = time.Duration(latency * float64(time.Millisecond))
+		if r.Scans > 0 {
+			r.SuccessRate = float64(r.Successes) / float64(r.Scans)
+		}
+		rollups = append(rollups, r)
+	}
+
+	return rollups, nil
+}
+
+// getBenchmarkRollups returns the benchmark summaries of the host, the
+// newest first. If no nodes are given, the summaries of all nodes are
+// returned.
+func (api *portalAPI) getBenchmarkRollups(network string, nodes []string, pk types.PublicKey, span int64, from, to time.Time, limit int64) ([]benchmarkRollup, error) {
+	if _, ok := api.hosts.load()[network][pk]; !ok {
+		return nil, errHostNotFound
+	}
+	f, t, limit := rollupRange(from, to, limit)
+
+	nodeFilter := "TRUE"
+	args := []any{network}
+	if len(nodes) > 0 {
+		nodeFilter = "node IN (?" + strings.Repeat(", ?", len(nodes)-1) + ")"
+		for _, n := range nodes {
+			args = append(args, n)
+		}
+	}
+	args = append(args, pk[:], span, f-f%span, t, limit)
+
+	rows, err := api.db.Query(`
+		SELECT node, period, benchmarks, successes, upload_speed, download_speed, ttfb
+		FROM benchmark_rollups
+		WHERE network = ?
+		AND `+nodeFilter+`
+		AND public_key = ?
+		AND span = ?
+		AND period >= ?
+		AND period <= ?
+		ORDER BY period DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query benchmark rollups")
+	}
+	defer rows.Close()
+
+	rollups := []benchmarkRollup{}
+	for rows.Next() {
+		var r benchmarkRollup
+		var period int64
+		var ttfb float64
+		if err := rows.Scan(&r.Node, &period, &r.Benchmarks, &r.Successes, &r.UploadSpeed, &r.DownloadSpeed, &ttfb); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode benchmark rollup")
+		}
+		r.Timestamp = time.Unix(period, 0)
+		r.TTFB = time.Duration(ttfb * float64(time.Millisecond))
+		if r.Benchmarks > 0 {
+			r.SuccessRate = float64(r.Successes) / float64(r.Benchmarks)
+		}
+		rollups = append(rollups, r)
+	}
+
+	return rollups, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+)
+
+const (
+	// minUpdatesInterval is the delay between the update requests to a node
+	// that has a backlog.
+	minUpdatesInterval = 5 * time.Second
+
+	// defaultUpdatesInterval is the delay between the update requests to
+	// a node that has just delivered some updates.
+	defaultUpdatesInterval = time.Minute
+
+	// maxUpdatesInterval is the maximum delay between the update requests
+	// to an idle node.
+	maxUpdatesInterval = 5 * time.Minute
+)
+
+// updatesSchedule is the batch size and the polling interval negotiated
+// with a node based on the backlog it reports.
+type updatesSchedule struct {
+	BatchSize int   `json:"batchSize"`
+	Interval  int64 `json:"interval"` // in seconds
+	Backlog   int   `json:"backlog"`
+	Streaming bool  `json:"streaming"`
+}
+
+// updatesScheduler keeps track of the update schedules of the nodes.
+type updatesScheduler struct {
+	mu        sync.Mutex
+	schedules map[string]updatesSchedule
+}
+
+func newUpdatesScheduler() *updatesScheduler {
+	return &updatesScheduler{
+		schedules: make(map[string]updatesSchedule),
+	}
+}
+
+// stream returns true if the backlog of the node is too large to be
+// fetched in batches, e.g. when the portal is bootstrapping, and returns
+// the number of rows to request.
+func (us *updatesScheduler) stream(node string) (bool, int) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	s, ok := us.schedules[node]
+	if !ok {
+		return false, 0
+	}
+	s.Streaming = s.Backlog > hostdb.MaxUpdatesLimit
+	us.schedules[node] = s
+	return s.Streaming, min(s.Backlog, hostdb.MaxStreamUpdatesLimit)
+}
+
+// get returns the current schedule for the node.
+func (us *updatesScheduler) get(node string) updatesSchedule {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	s, ok := us.schedules[node]
+	if !ok {
+		s = updatesSchedule{
+			BatchSize: hostdb.DefaultUpdatesLimit,
+			Interval:  int64(defaultUpdatesInterval.Seconds()),
+		}
+	}
+	return s
+}
+
+// negotiate adjusts the schedule of the node after a batch of updates has
+// been received and returns the delay before the next request. A node that
+// falls behind is polled more often and with larger batches, while an idle
+// node is polled less and less often.
+func (us *updatesScheduler) negotiate(node string, received, backlog int) time.Duration {
+	s := us.get(node)
+	interval := time.Duration(s.Interval) * time.Second
+
+	switch {
+	case backlog > 0:
+		if backlog > s.BatchSize {
+			s.BatchSize = min(2*s.BatchSize, hostdb.MaxUpdatesLimit)
+		}
+		interval = minUpdatesInterval
+	case received == 0:
+		s.BatchSize = max(s.BatchSize/2, hostdb.DefaultUpdatesLimit)
+		interval = min(max(2*interval, defaultUpdatesInterval), maxUpdatesInterval)
+	default:
+		interval = defaultUpdatesInterval
+	}
+
+	s.Interval = int64(interval.Seconds())
+	s.Backlog = backlog
+	us.mu.Lock()
+	us.schedules[node] = s
+	us.mu.Unlock()
+	return interval
+}
@@ -1,75 +1,278 @@
 package main
 
 import (
+	"errors"
 	"math"
 	"math/big"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mike76-dev/hostscore/hostdb"
 	"github.com/mike76-dev/hostscore/internal/build"
+	"github.com/mike76-dev/hostscore/internal/utils"
 	rhpv2 "go.sia.tech/core/rhp/v2"
 	rhpv3 "go.sia.tech/core/rhp/v3"
 	"go.sia.tech/core/types"
 )
 
 // To calculate the score of each host, we need to assume the settings
-// of an average renter.
+// of an average renter. hostPeriodBudget is only used until enough hosts
+// are online to derive the budget from the network median.
 var (
 	hostPeriodBudget = types.Siacoins(1000)              // 1 KS
 	dataPerHost      = uint64(1024 * 1024 * 1024 * 1024) // 1 TiB
 	contractPeriod   = uint64(144 * 30)                  // 1 month
 )
 
+const (
+	// minAnchorHosts is the number of online hosts required to derive
+	// the price anchor from the network.
+	minAnchorHosts = 10
+
+	// anchorWindow is the number of median samples the price anchor is
+	// averaged over. With the averages recalculated every 10 minutes,
+	// this makes one day.
+	anchorWindow = 144
+
+	// minWindowSize is the proof window below which a host risks missing
+	// the storage proofs. maxWindowSize is the proof window above which
+	// the renter funds stay locked for unusually long.
+	minWindowSize = 144     // 1 day
+	maxWindowSize = 144 * 7 // 1 week
+
+	// freshnessPeriod is how long the latency and the benchmark
+	// measurements keep their full weight. decayHalfLife is how long it
+	// takes the scores calculated from the older measurements to halve.
+	freshnessPeriod = 48 * time.Hour
+	decayHalfLife   = 7 * 24 * time.Hour
+)
+
+// priceAnchor tracks the rolling median of the host costs, so that the
+// price score reflects how a host compares to the rest of the network.
+// It also tracks the median contract duration the hosts offer.
+type priceAnchor struct {
+	mu       sync.RWMutex
+	samples  map[string][]types.Currency
+	budget   map[string]types.Currency
+	duration map[string]uint64
+}
+
+// anchors contains the price anchors of both networks.
+var anchors = &priceAnchor{
+	samples:  make(map[string][]types.Currency),
+	budget:   make(map[string]types.Currency),
+	duration: make(map[string]uint64),
+}
+
+// medianUint64 returns the median of the values. The slice gets sorted.
+func medianUint64(values []uint64) uint64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// medianCurrency returns the median of the values. The slice gets sorted.
+func medianCurrency(values []types.Currency) types.Currency {
+	slices.SortFunc(values, func(a, b types.Currency) int { return a.Cmp(b) })
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return values[n/2-1].Add(values[n/2]).Div64(2)
+}
+
+// update adds the median cost of the online hosts to the samples and
+// recalculates the anchor. It returns true if the anchor has changed.
+func (pa *priceAnchor) update(network string, hosts []portalHost) bool {
+	if len(hosts) < minAnchorHosts {
+		return false
+	}
+	costs := make([]types.Currency, 0, len(hosts))
+	durations := make([]uint64, 0, len(hosts))
+	for _, host := range hosts {
+		costs = append(costs, hostPeriodCostForScore(host.Settings, host.PriceTable))
+		durations = append(durations, host.Settings.MaxDuration)
+	}
+	median := medianCurrency(costs)
+	if median.IsZero() {
+		return false
+	}
+	duration := medianUint64(durations)
+
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	changed := duration != pa.duration[network]
+	pa.duration[network] = duration
+	samples := append(pa.samples[network], median)
+	if len(samples) > anchorWindow {
+		samples = samples[len(samples)-anchorWindow:]
+	}
+	pa.samples[network] = samples
+	budget := medianCurrency(slices.Clone(samples))
+	changed = changed || !budget.Equals(pa.budget[network])
+	pa.budget[network] = budget
+	return changed
+}
+
+// get returns the host cost the price score is calculated against.
+func (pa *priceAnchor) get(network string) types.Currency {
+	pa.mu.RLock()
+	defer pa.mu.RUnlock()
+	if budget, ok := pa.budget[network]; ok {
+		return budget
+	}
+	return hostPeriodBudget
+}
+
+// getDuration returns the contract duration the duration score is
+// calculated against.
+func (pa *priceAnchor) getDuration(network string) uint64 {
+	pa.mu.RLock()
+	defer pa.mu.RUnlock()
+	if duration := pa.duration[network]; duration > 0 {
+		return duration
+	}
+	return contractPeriod
+}
+
+// scoreWeights contains the exponents the individual factors are raised
+// to before they are multiplied. A weight of 1 keeps the factor as is,
+// a smaller weight softens its impact, and a weight of 0 excludes the
+// factor from the total score.
+type scoreWeights struct {
+	Prices       float64 `json:"prices"`
+	Storage      float64 `json:"storage"`
+	Collateral   float64 `json:"collateral"`
+	Interactions float64 `json:"interactions"`
+	Uptime       float64 `json:"uptime"`
+	Age          float64 `json:"age"`
+	Version      float64 `json:"version"`
+	Latency      float64 `json:"latency"`
+	Benchmarks   float64 `json:"benchmarks"`
+	Contracts    float64 `json:"contracts"`
+	Duration     float64 `json:"duration"`
+}
+
+// defaultScoreWeights results in the plain product of all factors.
+var defaultScoreWeights = scoreWeights{
+	Prices:       1,
+	Storage:      1,
+	Collateral:   1,
+	Interactions: 1,
+	Uptime:       1,
+	Age:          1,
+	Version:      1,
+	Latency:      1,
+	Benchmarks:   1,
+	Contracts:    1,
+	Duration:     1,
+}
+
+// weights are the score weights used by the portal. They are set once
+// at startup.
+var weights = defaultScoreWeights
+
+// validate checks that all weights are non-negative numbers.
+func (sw scoreWeights) validate() error {
+	for _, w := range []float64{
+		sw.Prices,
+		sw.Storage,
+		sw.Collateral,
+		sw.Interactions,
+		sw.Uptime,
+		sw.Age,
+		sw.Version,
+		sw.Latency,
+		sw.Benchmarks,
+		sw.Contracts,
+		sw.Duration,
+	} {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return errors.New("score weights must be non-negative numbers")
+		}
+	}
+	return nil
+}
+
+// total combines the factors into the total score.
+func (sw scoreWeights) total(sb scoreBreakdown) float64 {
+	return math.Pow(sb.PricesScore, sw.Prices) *
+		math.Pow(sb.StorageScore, sw.Storage) *
+		math.Pow(sb.CollateralScore, sw.Collateral) *
+		math.Pow(sb.InteractionsScore, sw.Interactions) *
+		math.Pow(sb.UptimeScore, sw.Uptime) *
+		math.Pow(sb.AgeScore, sw.Age) *
+		math.Pow(sb.VersionScore, sw.Version) *
+		math.Pow(sb.LatencyScore, sw.Latency) *
+		math.Pow(sb.BenchmarksScore, sw.Benchmarks) *
+		math.Pow(sb.ContractsScore, sw.Contracts) *
+		math.Pow(sb.DurationScore, sw.Duration)
+}
+
 // calculateScore calculates the total host's score.
-func calculateScore(host portalHost, node string, scans []portalScan, benchmarks []hostdb.HostBenchmark) scoreBreakdown {
+func calculateScore(host portalHost, network, node string, scans []portalScan, benchmarks []hostdb.HostBenchmark) scoreBreakdown {
 	hostPeriodCost := hostPeriodCostForScore(host.Settings, host.PriceTable)
 	interactions, ok := host.Interactions[node]
 	if !ok {
 		return scoreBreakdown{}
 	}
 	sb := scoreBreakdown{
-		PricesScore:       priceAdjustmentScore(hostPeriodCost),
+		PricesScore:       priceAdjustmentScore(hostPeriodCost, anchors.get(network)),
 		StorageScore:      storageRemainingScore(host.Settings),
 		CollateralScore:   collateralScore(host.PriceTable),
 		InteractionsScore: interactionScore(interactions.HistoricSuccesses, interactions.HistoricFailures),
 		UptimeScore:       uptimeScore(interactions.Uptime, interactions.Downtime, scans),
 		AgeScore:          ageScore(host.FirstSeen),
 		VersionScore:      versionScore(host.Settings),
-		LatencyScore:      latencyScore(scans),
+		LatencyScore:      latencyScore(scans, interactions.probes),
 		BenchmarksScore:   benchmarksScore(benchmarks),
 		ContractsScore:    contractsScore(host.Settings),
+		DurationScore:     durationScore(host.Settings, anchors.getDuration(network)),
 	}
-	sb.TotalScore = sb.PricesScore *
-		sb.StorageScore *
-		sb.CollateralScore *
-		sb.InteractionsScore *
-		sb.UptimeScore *
-		sb.AgeScore *
-		sb.VersionScore *
-		sb.LatencyScore *
-		sb.BenchmarksScore *
-		sb.ContractsScore
+	sb.TotalScore = weights.total(sb)
 	return sb
 }
 
-// calculateGlobalScore calculates the average score over all nodes.
-func calculateGlobalScore(host *portalHost) scoreBreakdown {
+// calculateGlobalScore calculates the average score over all nodes,
+// including the nodes of the federated portals.
+func calculateGlobalScore(host *portalHost, network string) scoreBreakdown {
 	hostPeriodCost := hostPeriodCostForScore(host.Settings, host.PriceTable)
 	sb := scoreBreakdown{
-		PricesScore:     priceAdjustmentScore(hostPeriodCost),
+		PricesScore:     priceAdjustmentScore(hostPeriodCost, anchors.get(network)),
 		StorageScore:    storageRemainingScore(host.Settings),
 		CollateralScore: collateralScore(host.PriceTable),
 		AgeScore:        ageScore(host.FirstSeen),
 		VersionScore:    versionScore(host.Settings),
 		ContractsScore:  contractsScore(host.Settings),
+		DurationScore:   durationScore(host.Settings, anchors.getDuration(network)),
 	}
 	var us, is, ls, bs float64
 	var count int
 	for _, interactions := range host.Interactions {
-		us += uptimeScore(interactions.Uptime, interactions.Downtime, interactions.ScanHistory)
 		is += interactionScore(interactions.HistoricSuccesses, interactions.HistoricFailures)
-		ls += latencyScore(interactions.ScanHistory)
-		bs += benchmarksScore(interactions.BenchmarkHistory)
+		if interactions.evicted {
+			// The scores of an evicted history were calculated before the eviction.
+			us += interactions.Score.UptimeScore
+			ls += interactions.Score.LatencyScore
+			bs += interactions.Score.BenchmarksScore
+		} else {
+			us += uptimeScore(interactions.Uptime, interactions.Downtime, hostScans(interactions.ScanHistory))
+			ls += latencyScore(interactions.ScanHistory, interactions.probes)
+			bs += benchmarksScore(interactions.BenchmarkHistory)
+		}
+		count++
+	}
+	// The nodes of the peer portals count as additional vantage points.
+	for _, f := range host.Federated {
+		us += f.Score.UptimeScore
+		is += f.Score.InteractionsScore
+		ls += f.Score.LatencyScore
+		bs += f.Score.BenchmarksScore
 		count++
 	}
 	if count > 0 {
@@ -78,19 +281,80 @@ func calculateGlobalScore(host *portalHost) scoreBreakdown {
 		sb.LatencyScore = ls / float64(count)
 		sb.BenchmarksScore = bs / float64(count)
 	}
-	sb.TotalScore = sb.PricesScore *
-		sb.StorageScore *
-		sb.CollateralScore *
-		sb.InteractionsScore *
-		sb.UptimeScore *
-		sb.AgeScore *
-		sb.VersionScore *
-		sb.LatencyScore *
-		sb.BenchmarksScore *
-		sb.ContractsScore
+	sb.TotalScore = weights.total(sb)
 	return sb
 }
 
+// scoreInputsHash returns a digest of everything the global score of the
+// host depends on. The current hour is included, because the age and
+// the uptime scores also depend on the time.
+func scoreInputsHash(host *portalHost, network string) types.Hash256 {
+	h := types.NewHasher()
+	h.E.WriteUint64(uint64(time.Now().Unix() / 3600))
+	types.V2Currency(anchors.get(network)).EncodeTo(h.E)
+	h.E.WriteUint64(anchors.getDuration(network))
+	h.E.WriteTime(host.FirstSeen)
+	utils.EncodeSettings(&host.Settings, h.E)
+	utils.EncodePriceTable(&host.PriceTable, h.E)
+
+	nodes := make([]string, 0, len(host.Interactions))
+	for node := range host.Interactions {
+		nodes = append(nodes, node)
+	}
+	slices.Sort(nodes)
+	for _, node := range nodes {
+		interactions := host.Interactions[node]
+		h.E.WriteString(node)
+		h.E.WriteUint64(uint64(interactions.Uptime))
+		h.E.WriteUint64(uint64(interactions.Downtime))
+		h.E.WriteUint64(math.Float64bits(interactions.HistoricSuccesses))
+		h.E.WriteUint64(math.Float64bits(interactions.HistoricFailures))
+		h.E.WriteUint64(uint64(len(interactions.ScanHistory)))
+		for _, scan := range interactions.ScanHistory {
+			h.E.WriteTime(scan.Timestamp)
+			h.E.WriteBool(scan.Success)
+			h.E.WriteUint8(uint8(scan.Failure))
+			h.E.WriteUint64(uint64(scan.Latency))
+		}
+		h.E.WriteUint64(uint64(len(interactions.probes)))
+		if n := len(interactions.probes); n > 0 {
+			h.E.WriteTime(interactions.probes[n-1].Timestamp)
+		}
+		h.E.WriteUint64(uint64(len(interactions.BenchmarkHistory)))
+		for _, benchmark := range interactions.BenchmarkHistory {
+			h.E.WriteTime(benchmark.Timestamp)
+			h.E.WriteBool(benchmark.Success)
+			h.E.WriteUint64(math.Float64bits(benchmark.UploadSpeed))
+			h.E.WriteUint64(math.Float64bits(benchmark.DownloadSpeed))
+		}
+	}
+
+	h.E.WriteUint64(uint64(len(host.Federated)))
+	for _, f := range host.Federated {
+		h.E.WriteString(f.Portal)
+		h.E.WriteString(f.Node)
+		h.E.WriteUint64(math.Float64bits(f.Score.UptimeScore))
+		h.E.WriteUint64(math.Float64bits(f.Score.InteractionsScore))
+		h.E.WriteUint64(math.Float64bits(f.Score.LatencyScore))
+		h.E.WriteUint64(math.Float64bits(f.Score.BenchmarksScore))
+	}
+
+	return h.Sum()
+}
+
+// updateGlobalScore recalculates the global score of the host unless
+// none of its inputs have changed since the last calculation. It returns
+// true if the score has been recalculated.
+func updateGlobalScore(host *portalHost, network string) bool {
+	hash := scoreInputsHash(host, network)
+	if hash == host.scoreHash {
+		return false
+	}
+	host.Score = calculateGlobalScore(host, network)
+	host.scoreHash = hash
+	return true
+}
+
 // priceAdjustmentScore computes a score between 0 and 1 for a host given its
 // price settings.
 //   - 0.5 is returned if the host's costs exactly match the settings.
@@ -100,8 +364,8 @@ func calculateGlobalScore(host *portalHost) scoreBreakdown {
 //   - If the host is more expensive than expected, an exponential malus is applied.
 //     A 2x ratio will already cause the score to drop to 0.16 and a 3x ratio causes
 //     it to drop to 0.05.
-func priceAdjustmentScore(hostCostPerPeriod types.Currency) float64 {
-	ratio := new(big.Rat).SetFrac(hostCostPerPeriod.Big(), hostPeriodBudget.Big())
+func priceAdjustmentScore(hostCostPerPeriod, budget types.Currency) float64 {
+	ratio := new(big.Rat).SetFrac(hostCostPerPeriod.Big(), budget.Big())
 	fRatio, _ := ratio.Float64()
 	switch ratio.Cmp(new(big.Rat).SetUint64(1)) {
 	case 0:
@@ -171,12 +435,10 @@ func ageScore(knownSince time.Time) float64 {
 	return weight
 }
 
-func collateralScore(pt rhpv3.HostPriceTable) float64 {
-	// Ignore hosts which have set their max collateral to 0.
-	if pt.MaxCollateral.IsZero() || pt.CollateralCost.IsZero() {
-		return 0
-	}
-
+// collateralCutoff returns the collateral the host is expected to put in
+// for the assumed allocation and the minimum collateral required to get
+// a non-zero score.
+func collateralCutoff(pt rhpv3.HostPriceTable) (expectedCollateral, cutoff types.Currency) {
 	// Convenience variables.
 	ratioNum := uint64(3)
 	ratioDenom := uint64(2)
@@ -186,7 +448,7 @@ func collateralScore(pt rhpv3.HostPriceTable) float64 {
 	storageCost := pt.AppendSectorCost(contractPeriod).Storage.Mul64(numSectors)
 
 	// Calculate the expected collateral for the host allocation.
-	expectedCollateral := pt.CollateralCost.Mul64(dataPerHost).Mul64(contractPeriod)
+	expectedCollateral = pt.CollateralCost.Mul64(dataPerHost).Mul64(contractPeriod)
 	if expectedCollateral.Cmp(pt.MaxCollateral) > 0 {
 		expectedCollateral = pt.MaxCollateral
 	}
@@ -199,7 +461,18 @@ func collateralScore(pt rhpv3.HostPriceTable) float64 {
 	// Determine a cutoff at 150% of the storage cost. Meaning that a host
 	// should be willing to put in at least 1.5x the amount of money the renter
 	// expects to spend on storage on that host.
-	cutoff := storageCost.Mul64(ratioNum).Div64(ratioDenom)
+	cutoff = storageCost.Mul64(ratioNum).Div64(ratioDenom)
+
+	return
+}
+
+func collateralScore(pt rhpv3.HostPriceTable) float64 {
+	// Ignore hosts which have set their max collateral to 0.
+	if pt.MaxCollateral.IsZero() || pt.CollateralCost.IsZero() {
+		return 0
+	}
+
+	expectedCollateral, cutoff := collateralCutoff(pt)
 
 	// The score is a linear function between 0 and 1 where the upper limit is
 	// 4 times the cutoff. Beyond that, we don't care if a host puts in more
@@ -225,6 +498,14 @@ func collateralScore(pt rhpv3.HostPriceTable) float64 {
 	}
 }
 
+// hostScans returns the scans without the ones that failed on the side
+// of the node, since they say nothing about the host.
+func hostScans(history []portalScan) []portalScan {
+	return slices.DeleteFunc(slices.Clone(history), func(scan portalScan) bool {
+		return !scan.Failure.HostFault()
+	})
+}
+
 func interactionScore(hs, hf float64) float64 {
 	success, fail := 30.0, 1.0
 	success += hs
@@ -286,6 +567,41 @@ func uptimeScore(ut, dt time.Duration, history []portalScan) float64 {
 	return math.Pow(ratio, 200*math.Min(1-ratio, 0.30))
 }
 
+// releaseRequirement is the minimum supported release of a host
+// software, e.g. hostd. The version score of the hosts running an older
+// release is multiplied by Penalty.
+type releaseRequirement struct {
+	Software   string  `json:"software"`
+	MinVersion string  `json:"minVersion"`
+	Penalty    float64 `json:"penalty"`
+}
+
+// defaultReleaseRequirements is used unless set in nodes.json.
+var defaultReleaseRequirements = []releaseRequirement{
+	{Software: "hostd", MinVersion: "1.1.2", Penalty: 0.5},
+}
+
+// releases are the minimum supported releases used by the portal. They
+// are set once at startup.
+var releases = defaultReleaseRequirements
+
+// validateReleases checks that each requirement names a software and a
+// version, and that the penalty is between 0 and 1.
+func validateReleases(rs []releaseRequirement) error {
+	for _, r := range rs {
+		if r.Software == "" || releaseVersion(r.MinVersion) == "" {
+			return errors.New("a release requirement must contain the software and the version")
+		}
+		if math.IsNaN(r.Penalty) || r.Penalty < 0 || r.Penalty > 1 {
+			return errors.New("release penalty must be between 0 and 1")
+		}
+	}
+	return nil
+}
+
+// versionScore penalizes the outdated protocol versions, and the releases
+// older than the minimum supported ones. The release is reported by the
+// host software, e.g. "hostd v1.1.2".
 func versionScore(settings rhpv2.HostSettings) float64 {
 	versions := []struct {
 		version string
@@ -300,6 +616,16 @@ func versionScore(settings rhpv2.HostSettings) float64 {
 			weight *= v.penalty
 		}
 	}
+	release := strings.ToLower(settings.Release)
+	version := releaseVersion(release)
+	for _, r := range releases {
+		if version == "" || !strings.HasPrefix(release, strings.ToLower(r.Software)) {
+			continue
+		}
+		if build.VersionCmp(version, releaseVersion(r.MinVersion)) < 0 {
+			weight *= r.Penalty
+		}
+	}
 	return weight
 }
 
@@ -376,21 +702,87 @@ func hostPeriodCostForScore(settings rhpv2.HostSettings, pt rhpv3.HostPriceTable
 		Add(siafundFee)
 }
 
-// latencyScore calculates a score from the host's latency measurements.
-func latencyScore(history []portalScan) float64 {
-	var averageLatency float64
+// averageLatency returns the average network latency of the successful scans
+// in milliseconds and the number of such scans.
+func averageLatency(history []portalScan) (float64, int) {
 	var totalLatency time.Duration
 	var totalSuccessfulScans int
 	for _, scan := range history {
 		if scan.Success {
-			totalLatency += scan.Latency
+			totalLatency += networkLatency(scan)
 			totalSuccessfulScans++
 		}
 	}
 
-	if totalSuccessfulScans > 0 {
-		averageLatency = float64(totalLatency.Milliseconds()) / float64(totalSuccessfulScans)
+	if totalSuccessfulScans == 0 {
+		return 0, 0
+	}
+
+	return utils.DurationToMS(totalLatency) / float64(totalSuccessfulScans), totalSuccessfulScans
+}
+
+// networkLatency returns the part of the scan latency caused by the
+// network. The settings RPC is left out, because it also depends on how
+// fast the host reads its settings. The scans made before the timings
+// were recorded only have the total latency.
+func networkLatency(scan portalScan) time.Duration {
+	if nl := scan.Timings.Network(); nl > 0 {
+		return nl
+	}
+	return scan.Latency
+}
+
+// decayFactor returns the multiplier of a score calculated from the
+// measurements last taken at the given time. It is 1 within
+// freshnessPeriod and halves every decayHalfLife afterwards, so that the
+// stale measurements gradually lose their weight.
+func decayFactor(lastMeasured time.Time) float64 {
+	if lastMeasured.IsZero() {
+		return 1
+	}
+	stale := time.Since(lastMeasured) - freshnessPeriod
+	if stale <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(stale)/float64(decayHalfLife))
+}
+
+// latestLatency returns the time of the latest successful latency
+// measurement, either a scan or a probe.
+func latestLatency(history []portalScan, probes []hostdb.LatencyProbe) (latest time.Time) {
+	for _, scan := range history {
+		if scan.Success && scan.Timestamp.After(latest) {
+			latest = scan.Timestamp
+		}
+	}
+	for _, probe := range probes {
+		if probe.Success && probe.Timestamp.After(latest) {
+			latest = probe.Timestamp
+		}
+	}
+	return
+}
+
+// latestBenchmark returns the time of the latest successful benchmark.
+func latestBenchmark(benchmarks []hostdb.HostBenchmark) (latest time.Time) {
+	for _, benchmark := range benchmarks {
+		if benchmark.Success && benchmark.Timestamp.After(latest) {
+			latest = benchmark.Timestamp
+		}
+	}
+	return
+}
+
+// latencyScore calculates a score from the host's latency measurements.
+// The latency probes are preferred over the scans if there are enough of
+// them, because they are much more frequent. The score decays if the
+// latest measurement is stale.
+func latencyScore(history []portalScan, probes []hostdb.LatencyProbe) float64 {
+	averageLatency, _ := averageLatency(history)
+	if probeLatency, n := averageProbeLatency(probes); n >= minProbes {
+		averageLatency = probeLatency
 	}
+	decay := decayFactor(latestLatency(history, probes))
 
 	// Catch an edge case.
 	if averageLatency == 0 {
@@ -404,31 +796,38 @@ func latencyScore(history []portalScan) float64 {
 
 	// If the latency is below 10ms, return 1.
 	if averageLatency < 10 {
-		return 1
+		return decay
 	}
 
-	return (1000 - averageLatency) / 1000
+	return (1000 - averageLatency) / 1000 * decay
 }
 
-// benchmarksScore calculates a score from the host's latest benchmarks.
-func benchmarksScore(benchmarks []hostdb.HostBenchmark) float64 {
-	var averageUploadSpeed, averageDownloadSpeed float64
-	var totalSuccessfulBenchmarks int
+// averageSpeeds returns the average upload and download speeds of the
+// successful benchmarks and the number of such benchmarks.
+func averageSpeeds(benchmarks []hostdb.HostBenchmark) (upload, download float64, n int) {
 	for _, benchmark := range benchmarks {
 		if benchmark.Success {
-			averageUploadSpeed += benchmark.UploadSpeed
-			averageDownloadSpeed += benchmark.DownloadSpeed
-			totalSuccessfulBenchmarks++
+			upload += benchmark.UploadSpeed
+			download += benchmark.DownloadSpeed
+			n++
 		}
 	}
 
+	if n == 0 {
+		return 0, 0, 0
+	}
+
+	return upload / float64(n), download / float64(n), n
+}
+
+// benchmarksScore calculates a score from the host's latest benchmarks.
+// The score decays if the latest successful benchmark is stale.
+func benchmarksScore(benchmarks []hostdb.HostBenchmark) float64 {
+	averageUploadSpeed, averageDownloadSpeed, totalSuccessfulBenchmarks := averageSpeeds(benchmarks)
 	if totalSuccessfulBenchmarks == 0 {
 		return 0
 	}
 
-	averageUploadSpeed /= float64(totalSuccessfulBenchmarks)
-	averageDownloadSpeed /= float64(totalSuccessfulBenchmarks)
-
 	var uploadSpeedFactor, downloadSpeedFactor float64
 	if averageUploadSpeed >= 5e7 { // 50 MB/s
 		uploadSpeedFactor = 1
@@ -445,7 +844,7 @@ func benchmarksScore(benchmarks []hostdb.HostBenchmark) float64 {
 		downloadSpeedFactor = averageDownloadSpeed / 1e8
 	}
 
-	return uploadSpeedFactor * downloadSpeedFactor
+	return uploadSpeedFactor * downloadSpeedFactor * decayFactor(latestBenchmark(benchmarks))
 }
 
 // contractsScore returns 1 if the host is accepting contracts,
@@ -456,3 +855,23 @@ func contractsScore(settings rhpv2.HostSettings) float64 {
 	}
 	return 0
 }
+
+// durationScore calculates a score from the contract terms the host
+// offers. A host offering a shorter maximum contract duration than the
+// network median gets a linear penalty, and so does a host with a proof
+// window outside the usual range.
+func durationScore(settings rhpv2.HostSettings, median uint64) float64 {
+	durationFactor := 1.0
+	if settings.MaxDuration < median {
+		durationFactor = float64(settings.MaxDuration) / float64(median)
+	}
+
+	windowFactor := 1.0
+	if settings.WindowSize < minWindowSize {
+		windowFactor = float64(settings.WindowSize) / minWindowSize
+	} else if settings.WindowSize > maxWindowSize {
+		windowFactor = maxWindowSize / float64(settings.WindowSize)
+	}
+
+	return durationFactor * windowFactor
+}
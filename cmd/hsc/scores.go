@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// scoreSnapshotInterval determines how often the portal checks if a new
+// daily score snapshot is due.
+const scoreSnapshotInterval = time.Hour
+
+// scoreSnapshot is the score of a host on a given day.
+type scoreSnapshot struct {
+	Day   time.Time      `json:"day"`
+	Score scoreBreakdown `json:"score"`
+}
+
+type scoreHistoryResponse struct {
+	Scores []scoreSnapshot `json:"scores"`
+}
+
+// snapshotScores stores the scores of all hosts, unless they have been
+// stored already today.
+func (api *portalAPI) snapshotScores() error {
+	var last int64
+	if err := api.db.QueryRow("SELECT COALESCE(MAX(day), 0) FROM score_history").Scan(&last); err != nil {
+		return utils.AddContext(err, "couldn't get last score snapshot")
+	}
+
+	today := time.Now().Unix() / 86400
+	if today <= last {
+		return nil
+	}
+	return utils.AddContext(api.saveScoreSnapshot(today), "couldn't save score snapshot")
+}
+
+// saveScoreSnapshot saves the current scores of all hosts as the scores
+// of the given day.
+func (api *portalAPI) saveScoreSnapshot(day int64) error {
+	type snapshot struct {
+		network string
+		pk      types.PublicKey
+		score   scoreBreakdown
+	}
+
+	var snapshots []snapshot
+	for network, hosts := range api.hosts.load() {
+		for pk, host := range hosts {
+			snapshots = append(snapshots, snapshot{network, pk, host.Score})
+		}
+	}
+
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO score_history (
+			network,
+			public_key,
+			day,
+			price_score,
+			storage_score,
+			collateral_score,
+			interactions_score,
+			uptime_score,
+			age_score,
+			version_score,
+			latency_score,
+			benchmarks_score,
+			contracts_score,
+			duration_score,
+			total_score
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	for _, s := range snapshots {
+		_, err := stmt.Exec(
+			s.network,
+			s.pk[:],
+			day,
+			s.score.PricesScore,
+			s.score.StorageScore,
+			s.score.CollateralScore,
+			s.score.InteractionsScore,
+			s.score.UptimeScore,
+			s.score.AgeScore,
+			s.score.VersionScore,
+			s.score.LatencyScore,
+			s.score.BenchmarksScore,
+			s.score.ContractsScore,
+			s.score.DurationScore,
+			s.score.TotalScore,
+		)
+		if err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't save score")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getScoreHistory retrieves the daily scores of the given host.
+func (api *portalAPI) getScoreHistory(network string, pk types.PublicKey, from, to time.Time, limit int64) (scores []scoreSnapshot, err error) {
+	f := int64(0)
+	t := time.Now().Unix() / 86400
+	if from.Unix() != (time.Time{}).Unix() {
+		f = from.Unix() / 86400
+	}
+	if to.Unix() != (time.Time{}).Unix() {
+		t = to.Unix() / 86400
+	}
+	if limit < 0 {
+		limit = math.MaxInt64
+	}
+
+	_, ok := api.hosts.load()[network][pk]
+
+	if !ok {
+		return nil, errHostNotFound
+	}
+
+	rows, err := api.db.Query(`
+		SELECT
+			day,
+			price_score,
+			storage_score,
+			collateral_score,
+			interactions_score,
+			uptime_score,
+			age_score,
+			version_score,
+			latency_score,
+			benchmarks_score,
+			contracts_score,
+			duration_score,
+			total_score
+		FROM score_history
+		WHERE network = ?
+		AND public_key = ?
+		AND day >= ?
+		AND day <= ?
+		ORDER BY day DESC
+		LIMIT ?
+	`, network, pk[:], f, t, limit)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query score history")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day int64
+		var sb scoreBreakdown
+		if err := rows.Scan(
+			&day,
+			&sb.PricesScore,
+			&sb.StorageScore,
+			&sb.CollateralScore,
+			&sb.InteractionsScore,
+			&sb.UptimeScore,
+			&sb.AgeScore,
+			&sb.VersionScore,
+			&sb.LatencyScore,
+			&sb.BenchmarksScore,
+			&sb.ContractsScore,
+			&sb.DurationScore,
+			&sb.TotalScore,
+		); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode score")
+		}
+		scores = append(scores, scoreSnapshot{
+			Day:   time.Unix(day*86400, 0).UTC(),
+			Score: sb,
+		})
+	}
+
+	return
+}
+
+func (api *portalAPI) hostsScoresHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	host := req.FormValue("host")
+	if host == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	err := pk.UnmarshalText([]byte(host))
+	if err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	var from, to time.Time
+	f := req.FormValue("from")
+	if f != "" {
+		from, err = time.Parse(time.RFC3339, f)
+		if err != nil {
+			writeError(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	t := req.FormValue("to")
+	if t != "" {
+		to, err = time.Parse(time.RFC3339, t)
+		if err != nil {
+			writeError(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := int64(-1)
+	lim := req.FormValue("limit")
+	if lim != "" {
+		limit, err = strconv.ParseInt(lim, 10, 64)
+		if err != nil {
+			writeError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	scores, err := api.getScoreHistory(network, pk, from, to, limit)
+	if err != nil && errors.Is(err, errHostNotFound) {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't get score history", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, scoreHistoryResponse{Scores: scores})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mike76-dev/hostscore/external"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// hostFilter narrows down the list of hosts. The empty fields match all
+// hosts. The strings are lowercase, except for the country code.
+type hostFilter struct {
+	query              string // net address, public key prefix, country name, ISP, or version
+	country            string // two-letter country code
+	isp                string
+	asn                string // uppercase, e.g. AS24940
+	version            string
+	tag                string
+	minScore           float64
+	acceptingContracts bool
+	online             onlineFilter
+}
+
+// onlineFilter selects the hosts by their online status.
+type onlineFilter uint8
+
+const (
+	anyStatus onlineFilter = iota
+	onlineOnly
+	offlineOnly
+)
+
+// hostLocation contains the location fields the hosts can be searched by.
+type hostLocation struct {
+	country string
+	isp     string
+	asn     string
+}
+
+// needsLocations returns true if the filter can't be applied without the
+// locations of the hosts.
+func (hf hostFilter) needsLocations() bool {
+	return hf.query != "" || hf.country != "" || hf.isp != "" || hf.asn != ""
+}
+
+// matches returns true if the host satisfies all conditions of the filter.
+// The cheapest conditions are checked first.
+func (hf hostFilter) matches(host *portalHost, loc hostLocation) bool {
+	if host.Score.TotalScore < hf.minScore {
+		return false
+	}
+	if hf.acceptingContracts && !host.Settings.AcceptingContracts {
+		return false
+	}
+	if hf.country != "" && loc.country != hf.country {
+		return false
+	}
+	if hf.isp != "" && !strings.Contains(strings.ToLower(loc.isp), hf.isp) {
+		return false
+	}
+	if hf.asn != "" && loc.asn != hf.asn {
+		return false
+	}
+	if hf.version != "" && !matchesVersion(host, hf.version) {
+		return false
+	}
+	if hf.tag != "" && !slices.Contains(host.Tags, hf.tag) {
+		return false
+	}
+	if hf.online != anyStatus && isOnline(*host) != (hf.online == onlineOnly) {
+		return false
+	}
+	if hf.query == "" {
+		return true
+	}
+	if strings.Contains(host.NetAddress, hf.query) {
+		return true
+	}
+	pk := host.PublicKey.String()
+	if strings.HasPrefix(pk, hf.query) || strings.HasPrefix(strings.TrimPrefix(pk, "ed25519:"), hf.query) {
+		return true
+	}
+	if name, ok := countryNames[loc.country]; ok && strings.Contains(strings.ToLower(name), hf.query) {
+		return true
+	}
+	if strings.Contains(strings.ToLower(loc.isp), hf.query) {
+		return true
+	}
+	return matchesVersion(host, hf.query)
+}
+
+// matchesVersion returns true if the host's protocol version starts with
+// the given string, or its release contains it.
+func matchesVersion(host *portalHost, version string) bool {
+	return strings.HasPrefix(host.Settings.Version, version) || strings.Contains(strings.ToLower(host.Settings.Release), version)
+}
+
+// getLocations loads the searchable location fields of all hosts.
+func (api *portalAPI) getLocations(network string) (map[types.PublicKey]hostLocation, error) {
+	rows, err := api.db.Query(`
+		SELECT public_key, country, isp, asn
+		FROM locations
+		WHERE network = ?
+	`, network)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query locations")
+	}
+	defer rows.Close()
+
+	locations := make(map[types.PublicKey]hostLocation)
+	for rows.Next() {
+		pk := make([]byte, 32)
+		var loc hostLocation
+		if err := rows.Scan(&pk, &loc.country, &loc.isp, &loc.asn); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode location")
+		}
+		if loc.asn == "" {
+			loc.asn = external.ParseASN(loc.isp)
+		}
+		locations[types.PublicKey(pk)] = loc
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load locations")
+	}
+
+	return locations, nil
+}
+
+// normalizeASN converts an autonomous system number given as "24940",
+// "as24940", or "AS24940" to the form stored with the locations. It
+// returns false if the string is not a valid ASN.
+func normalizeASN(s string) (string, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if !strings.HasPrefix(s, "AS") {
+		s = "AS" + s
+	}
+	if external.ParseASN(s) == "" {
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	rhpv3 "go.sia.tech/core/rhp/v3"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// settingsSnapshot is the full set of the host settings and the price
+// table at the moment one of them changed. The changes are the JSON
+// names of the fields that differ from the previous snapshot.
+type settingsSnapshot struct {
+	Timestamp         time.Time            `json:"timestamp"`
+	Settings          rhpv2.HostSettings   `json:"settings"`
+	PriceTable        rhpv3.HostPriceTable `json:"priceTable"`
+	SettingsChanges   []string             `json:"settingsChanges"`
+	PriceTableChanges []string             `json:"priceTableChanges"`
+}
+
+type settingsHistoryResponse struct {
+	Snapshots []settingsSnapshot `json:"snapshots"`
+}
+
+// stableSettings returns a copy of the settings without the fields that
+// change all the time, so that they don't produce a new snapshot.
+func stableSettings(hs rhpv2.HostSettings) rhpv2.HostSettings {
+	hs.RemainingStorage = 0
+	hs.RevisionNumber = 0
+	return hs
+}
+
+// stablePriceTable returns a copy of the price table without the fields
+// that change with every scan.
+func stablePriceTable(pt rhpv3.HostPriceTable) rhpv3.HostPriceTable {
+	pt.UID = rhpv3.SettingsID{}
+	pt.Validity = 0
+	pt.HostBlockHeight = 0
+	return pt
+}
+
+// settingsChanged returns true if any of the stable fields of the settings
+// or the price table have changed. An empty price table, e.g. of a host
+// that failed the last scan, is not compared.
+func settingsChanged(os, ns rhpv2.HostSettings, opt, npt rhpv3.HostPriceTable) bool {
+	if stableSettings(os) != stableSettings(ns) {
+		return true
+	}
+	if (opt == rhpv3.HostPriceTable{}) || (npt == rhpv3.HostPriceTable{}) {
+		return false
+	}
+	return stablePriceTable(opt) != stablePriceTable(npt)
+}
+
+// changedFields returns the JSON names of the fields that differ between
+// the two values, sorted alphabetically.
+func changedFields(a, b any) []string {
+	fields := []string{}
+	var am, bm map[string]json.RawMessage
+	if js, err := json.Marshal(a); err != nil || json.Unmarshal(js, &am) != nil {
+		return fields
+	}
+	if js, err := json.Marshal(b); err != nil || json.Unmarshal(js, &bm) != nil {
+		return fields
+	}
+	for name, value := range bm {
+		if !bytes.Equal(am[name], value) {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// encodeSettingsSnapshot encodes the settings and the price table the
+// same way they are stored in the hosts table.
+func encodeSettingsSnapshot(hs rhpv2.HostSettings, pt rhpv3.HostPriceTable) ([]byte, []byte) {
+	var sb, pb bytes.Buffer
+	e := types.NewEncoder(&sb)
+	utils.EncodeSettings(&hs, e)
+	e.Flush()
+	if (pt != rhpv3.HostPriceTable{}) {
+		e = types.NewEncoder(&pb)
+		utils.EncodePriceTable(&pt, e)
+		e.Flush()
+	}
+	return sb.Bytes(), pb.Bytes()
+}
+
+// getSettingsHistory retrieves the settings snapshots of the host in the
+// ascending order. Each snapshot lists the fields changed since the
+// previous one; all fields are listed for the first snapshot ever taken.
+func (api *portalAPI) getSettingsHistory(network string, pk types.PublicKey, from, to time.Time, limit int64) ([]settingsSnapshot, error) {
+	f := int64(0)
+	t := time.Now().Unix()
+	if from.Unix() != (time.Time{}).Unix() {
+		f = from.Unix()
+	}
+	if to.Unix() != (time.Time{}).Unix() {
+		t = to.Unix()
+	}
+	if limit < 0 || limit > math.MaxInt32 {
+		limit = math.MaxInt32
+	}
+
+	// One more snapshot is retrieved to find the changes in the oldest
+	// one returned.
+	rows, err := api.db.Query(`
+		SELECT
+			changed_at,
+			settings,
+			price_table
+		FROM settings_history
+		WHERE network = ?
+		AND public_key = ?
+		AND changed_at <= ?
+		ORDER BY changed_at DESC
+		LIMIT ?
+	`, network, pk[:], t, limit+1)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query settings history")
+	}
+	defer rows.Close()
+
+	var snapshots []settingsSnapshot
+	for rows.Next() {
+		var ca int64
+		var sb, pb []byte
+		if err := rows.Scan(&ca, &sb, &pb); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode settings snapshot")
+		}
+		ss := settingsSnapshot{Timestamp: time.Unix(ca, 0)}
+		d := types.NewBufDecoder(sb)
+		utils.DecodeSettings(&ss.Settings, d)
+		if err := d.Err(); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode host settings")
+		}
+		if len(pb) > 0 {
+			d = types.NewBufDecoder(pb)
+			utils.DecodePriceTable(&ss.PriceTable, d)
+			if err := d.Err(); err != nil {
+				return nil, utils.AddContext(err, "couldn't decode host price table")
+			}
+		}
+		snapshots = append(snapshots, ss)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't retrieve settings history")
+	}
+
+	// Sort in ascending order.
+	slices.Reverse(snapshots)
+
+	var prev *settingsSnapshot
+	result := make([]settingsSnapshot, 0, len(snapshots))
+	for i := range snapshots {
+		ss := &snapshots[i]
+		ss.PriceTableChanges = []string{}
+		if prev == nil {
+			ss.SettingsChanges = changedFields(nil, stableSettings(ss.Settings))
+			if (ss.PriceTable != rhpv3.HostPriceTable{}) {
+				ss.PriceTableChanges = changedFields(nil, stablePriceTable(ss.PriceTable))
+			}
+		} else {
+			ss.SettingsChanges = changedFields(stableSettings(prev.Settings), stableSettings(ss.Settings))
+			if (ss.PriceTable != rhpv3.HostPriceTable{}) && (prev.PriceTable != rhpv3.HostPriceTable{}) {
+				ss.PriceTableChanges = changedFields(stablePriceTable(prev.PriceTable), stablePriceTable(ss.PriceTable))
+			}
+		}
+		prev = ss
+		if ss.Timestamp.Unix() >= f && int64(len(snapshots)-i) <= limit {
+			result = append(result, *ss)
+		}
+	}
+
+	return result, nil
+}
+
+func (api *portalAPI) hostsSettingsHistoryHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	host := req.FormValue("host")
+	if host == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	err := pk.UnmarshalText([]byte(host))
+	if err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	var from, to time.Time
+	f := req.FormValue("from")
+	if f != "" {
+		from, err = time.Parse(time.RFC3339, f)
+		if err != nil {
+			writeError(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	t := req.FormValue("to")
+	if t != "" {
+		to, err = time.Parse(time.RFC3339, t)
+		if err != nil {
+			writeError(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := int64(-1)
+	lim := req.FormValue("limit")
+	if lim != "" {
+		limit, err = strconv.ParseInt(lim, 10, 64)
+		if err != nil {
+			writeError(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := api.hosts.load()[network][pk]; !ok {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := api.getSettingsHistory(network, pk, from, to, limit)
+	if err != nil {
+		api.log.Error("couldn't get settings history", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, settingsHistoryResponse{Snapshots: snapshots})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	client "github.com/mike76-dev/hostscore/api"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// scoreTolerance is the maximum difference between the live and the shadow
+// scores that is not reported.
+const scoreTolerance = 1e-6
+
+// shadowState is used when hsc runs in shadow mode next to a live instance.
+// In this mode, the updates are requested from the nodes under a consumer
+// name of their own, so that the shadow gets and confirms all updates
+// regardless of the live instance, and they are ingested into the
+// versioned tables in the database of the live instance.
+type shadowState struct {
+	liveURL  string
+	consumer string
+}
+
+type scoreDiff struct {
+	PublicKey types.PublicKey `json:"publicKey"`
+	Live      float64         `json:"live"`
+	Shadow    float64         `json:"shadow"`
+}
+
+type compareResponse struct {
+	LiveHosts   int               `json:"liveHosts"`
+	ShadowHosts int               `json:"shadowHosts"`
+	Missing     []types.PublicKey `json:"missing"`
+	Extra       []types.PublicKey `json:"extra"`
+	ScoreDiffs  []scoreDiff       `json:"scoreDiffs"`
+}
+
+func newShadowState(liveURL, version string) *shadowState {
+	return &shadowState{
+		liveURL:  strings.TrimSuffix(liveURL, "/"),
+		consumer: "shadow_" + version,
+	}
+}
+
+// nodeClient returns a client of the node, configured as in nodes.json. A
+// shadow instance requests the updates under its own consumer name.
+func (api *portalAPI) nodeClient(addr, password string) *client.Client {
+	c := client.NewClientWithConfig(addr, password, api.store.client.clientConfig())
+	if api.shadow != nil {
+		c = c.WithConsumer(api.shadow.consumer)
+	}
+	return c
+}
+
+// liveHosts retrieves the hosts from the live instance.
+func (ss *shadowState) liveHosts(network string) ([]portalHost, error) {
+	url := fmt.Sprintf("%s%s/hosts?network=%s&all=true&offset=0&limit=-1&sort=id", ss.liveURL, apiPrefix, network)
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query live instance")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("live instance returned %s", resp.Status)
+	}
+	var hr hostsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&hr); err != nil {
+		return nil, utils.AddContext(err, "couldn't decode live response")
+	}
+	return hr.Hosts, nil
+}
+
+func (api *portalAPI) serviceCompareHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.shadow == nil {
+		writeError(w, "not running in shadow mode", http.StatusNotFound)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+
+	live, err := api.shadow.liveHosts(network)
+	if err != nil {
+		api.log.Error("couldn't get live hosts", zap.String("network", network), zap.Error(err))
+		writeError(w, "couldn't reach live instance", http.StatusBadGateway)
+		return
+	}
+	shadow, _, _, err := api.getHosts(network, true, 0, 0, -1, hostFilter{}, sortByID, true)
+	if err != nil {
+		api.log.Error("couldn't get hosts", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := compareResponse{
+		LiveHosts:   len(live),
+		ShadowHosts: len(shadow),
+	}
+	shadowScores := make(map[types.PublicKey]float64)
+	for _, host := range shadow {
+		shadowScores[host.PublicKey] = host.Score.TotalScore
+	}
+	for _, host := range live {
+		score, ok := shadowScores[host.PublicKey]
+		if !ok {
+			resp.Missing = append(resp.Missing, host.PublicKey)
+			continue
+		}
+		delete(shadowScores, host.PublicKey)
+		if math.Abs(score-host.Score.TotalScore) > scoreTolerance {
+			resp.ScoreDiffs = append(resp.ScoreDiffs, scoreDiff{
+				PublicKey: host.PublicKey,
+				Live:      host.Score.TotalScore,
+				Shadow:    score,
+			})
+		}
+	}
+	for pk := range shadowScores {
+		resp.Extra = append(resp.Extra, pk)
+	}
+
+	writeJSON(w, resp)
+}
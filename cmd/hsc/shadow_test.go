@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/testutil"
+)
+
+func TestPortalTables(t *testing.T) {
+	script, err := os.ReadFile("../../init_portal.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for _, m := range regexp.MustCompile(`CREATE TABLE (\w+)`).FindAllStringSubmatch(string(script), -1) {
+		tables = append(tables, m[1])
+	}
+	// A table missing from the list would be shared with the live
+	// instance in shadow mode.
+	if !slices.Equal(tables, portalTables) {
+		t.Fatalf("expected the tables %v, got %v", tables, portalTables)
+	}
+}
+
+func TestVersionedTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hostscore.db")
+	open := func(version string) *sqldb.DB {
+		db, err := sqldb.Open(sqldb.Config{
+			Type:    sqldb.SQLite,
+			Name:    path,
+			Version: version,
+			Tables:  portalTables,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	live := open("")
+	script, err := os.ReadFile("../../init_portal_sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := live.DB.Exec(string(script)); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AddPortalHost(t, live, 1, "mainnet")
+
+	shadow := open("v2")
+	if err := shadow.CreateVersionedTables(); err != nil {
+		t.Fatal(err)
+	}
+	// Creating the tables again has no effect.
+	if err := shadow.CreateVersionedTables(); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := shadow.MissingIndexes(portalIndexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) > 0 {
+		t.Fatalf("the versioned tables lack the indexes %v", missing)
+	}
+
+	pk := testutil.AddPortalHost(t, shadow, 1, "mainnet")
+	if _, err := shadow.Exec(`
+		INSERT INTO scans (network, node, public_key, ran_at, success, latency, error)
+		VALUES ('mainnet', 'global', ?, 0, TRUE, 0, '')
+	`, pk[:]); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CountRows(t, live, "hosts"); n != 1 {
+		t.Fatalf("expected 1 live host, got %d", n)
+	}
+	if n := testutil.CountRows(t, live, "hosts_v2"); n != 1 {
+		t.Fatalf("expected 1 shadow host, got %d", n)
+	}
+	if n := testutil.CountRows(t, live, "scans"); n != 0 {
+		t.Fatalf("expected no live scans, got %d", n)
+	}
+
+	// The columns named like the tables are left alone.
+	for i := 0; i < 2; i++ {
+		if _, err := shadow.Exec(`
+			INSERT INTO network_history (
+				network, hour, hosts, online_hosts, accepting_contracts,
+				total_storage, used_storage, storage_price, collateral,
+				upload_price, download_price, upload_speed, download_speed,
+				ttfb, collateral_capacity
+			)
+			VALUES ('mainnet', 1, ?, 0, 0, 0, 0, x'', x'', x'', x'', 0, 0, 0, x'') AS new
+			ON DUPLICATE KEY (network, hour) UPDATE
+				hosts = new.hosts
+		`, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var hosts int
+	if err := shadow.QueryRow("SELECT hosts FROM network_history WHERE network = 'mainnet' AND hour = 1").Scan(&hosts); err != nil {
+		t.Fatal(err)
+	}
+	if hosts != 2 {
+		t.Fatalf("expected 2 hosts in the network history, got %d", hosts)
+	}
+
+	// The deletions cascade in the copies.
+	if _, err := shadow.Exec("DELETE FROM hosts WHERE public_key = ?", pk[:]); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CountRows(t, live, "scans_v2"); n != 0 {
+		t.Fatalf("expected the shadow scans to be deleted, got %d", n)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+)
+
+// maxSimulationSize is the maximum size of a simulation request.
+const maxSimulationSize = 4096
+
+// simulationRequest contains the hypothetical settings of a host. The
+// prices are in the units of the host settings, i.e. Hastings per byte
+// (per block for the storage and the collateral). The fields left out
+// keep their current values.
+type simulationRequest struct {
+	Network          string          `json:"network"`
+	PublicKey        types.PublicKey `json:"publicKey"`
+	StoragePrice     *types.Currency `json:"storagePrice,omitempty"`
+	Collateral       *types.Currency `json:"collateral,omitempty"`
+	MaxCollateral    *types.Currency `json:"maxCollateral,omitempty"`
+	UploadPrice      *types.Currency `json:"uploadPrice,omitempty"`
+	DownloadPrice    *types.Currency `json:"downloadPrice,omitempty"`
+	ContractPrice    *types.Currency `json:"contractPrice,omitempty"`
+	RemainingStorage *uint64         `json:"remainingStorage,omitempty"`
+}
+
+// simulationResult compares the current score of the host with the one
+// it would receive with the hypothetical settings.
+type simulationResult struct {
+	Current       scoreBreakdown `json:"current"`
+	Simulated     scoreBreakdown `json:"simulated"`
+	CurrentRank   int            `json:"currentRank"`
+	SimulatedRank int            `json:"simulatedRank"`
+}
+
+// apply overrides the settings and the price table of the host with the
+// hypothetical values. Both are changed, because the score uses some
+// values from the settings and some from the price table.
+func (sr simulationRequest) apply(host *portalHost) {
+	if sr.StoragePrice != nil {
+		host.Settings.StoragePrice = *sr.StoragePrice
+		host.PriceTable.WriteStoreCost = *sr.StoragePrice
+	}
+	if sr.Collateral != nil {
+		host.Settings.Collateral = *sr.Collateral
+		host.PriceTable.CollateralCost = *sr.Collateral
+	}
+	if sr.MaxCollateral != nil {
+		host.Settings.MaxCollateral = *sr.MaxCollateral
+		host.PriceTable.MaxCollateral = *sr.MaxCollateral
+	}
+	if sr.UploadPrice != nil {
+		host.Settings.UploadBandwidthPrice = *sr.UploadPrice
+		host.PriceTable.UploadBandwidthCost = *sr.UploadPrice
+	}
+	if sr.DownloadPrice != nil {
+		host.Settings.DownloadBandwidthPrice = *sr.DownloadPrice
+		host.PriceTable.DownloadBandwidthCost = *sr.DownloadPrice
+	}
+	if sr.ContractPrice != nil {
+		host.Settings.ContractPrice = *sr.ContractPrice
+		host.PriceTable.ContractPrice = *sr.ContractPrice
+	}
+	if sr.RemainingStorage != nil {
+		host.Settings.RemainingStorage = *sr.RemainingStorage
+	}
+}
+
+// simulatedRank returns the rank the host would have with the given
+// ranking score, the other hosts keeping their scores.
+func (api *portalAPI) simulatedRank(network string, host *portalHost, score float64) int {
+	rank := 1
+	for pk, h := range api.hosts.load()[network] {
+		if pk == host.PublicKey {
+			continue
+		}
+		if h.Quarantined != host.Quarantined {
+			if compareQuarantined(*h, *host) < 0 {
+				rank++
+			}
+			continue
+		}
+		if s := api.rankingScore(*h); s > score || (s == score && h.ID < host.ID) {
+			rank++
+		}
+	}
+	return rank
+}
+
+// hostsSimulateHandler returns the score the host would receive with the
+// hypothetical settings, so that the operator can tune the prices before
+// changing them. The rest of the score inputs stay the same.
+func (api *portalAPI) hostsSimulateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	var sr simulationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxSimulationSize)).Decode(&sr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	sr.Network = strings.ToLower(sr.Network)
+	if sr.Network == "" {
+		sr.Network = "mainnet"
+	}
+	if sr.Network != "mainnet" && sr.Network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	host, ok := api.hosts.load()[sr.Network][sr.PublicKey]
+	if !ok || host.OptOut == hostdb.OptOutDelist {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	sim := *host
+	sr.apply(&sim)
+	sb := calculateGlobalScore(&sim, sr.Network)
+	sim.Score = sb
+
+	writeJSON(w, simulationResult{
+		Current:       host.Score,
+		Simulated:     sb,
+		CurrentRank:   host.Rank,
+		SimulatedRank: api.simulatedRank(sr.Network, host, api.rankingScore(sim)),
+	})
+}
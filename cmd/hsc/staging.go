@@ -0,0 +1,136 @@
+package main
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+)
+
+// cloneHost returns a copy of the host that the updates from the node
+// can be applied to without affecting the original.
+func cloneHost(host *portalHost, node string) *portalHost {
+	c := *host
+	c.Interactions = maps.Clone(host.Interactions)
+	if interactions, ok := c.Interactions[node]; ok {
+		interactions.ScanHistory = slices.Clone(interactions.ScanHistory)
+		interactions.BenchmarkHistory = slices.Clone(interactions.BenchmarkHistory)
+		c.Interactions[node] = interactions
+	}
+	return &c
+}
+
+// stageHosts returns the copies of the hosts affected by the updates.
+func (api *portalAPI) stageHosts(node string, updates hostdb.HostUpdates) map[string]map[types.PublicKey]*portalHost {
+	staged := map[string]map[types.PublicKey]*portalHost{
+		"mainnet": make(map[types.PublicKey]*portalHost),
+		"zen":     make(map[types.PublicKey]*portalHost),
+	}
+	hosts := api.hosts.load()
+	stage := func(network string, pk types.PublicKey) {
+		if _, ok := staged[network]; !ok {
+			return
+		}
+		if _, ok := staged[network][pk]; ok {
+			return
+		}
+		if host, exists := hosts[network][pk]; exists {
+			staged[network][pk] = cloneHost(host, node)
+		}
+	}
+
+	for _, h := range updates.Hosts {
+		stage(h.Network, h.PublicKey)
+	}
+	for _, scan := range updates.Scans {
+		stage(scan.Network, scan.PublicKey)
+	}
+	for _, benchmark := range updates.Benchmarks {
+		stage(benchmark.Network, benchmark.PublicKey)
+	}
+	return staged
+}
+
+// publishHosts replaces the hosts of w with their updated copies. Only
+// the fields set by the updates are copied, so that the changes made by
+// the other jobs in the meantime aren't lost: the quarantine, the tags, the
+// opt-out, the federated scores, the location, and the sybil data are
+// always kept, and so are the histories of the node unless the updates
+// have changed them. It returns the arguments of the score updates of
+// the hosts whose global scores have changed.
+func publishHosts(w *hostWriter, node string, staged map[string]map[types.PublicKey]*portalHost, histories map[string]map[types.PublicKey]struct{}) (scores [][]any) {
+	for network, hosts := range staged {
+		for pk, host := range hosts {
+			live, exists := w.edit(network, pk)
+			if !exists {
+				w.put(network, host)
+				live = host
+			} else {
+				live.NetAddress = host.NetAddress
+				live.Blocked = host.Blocked
+				live.IPNets = host.IPNets
+				live.LastIPChange = host.LastIPChange
+				live.Settings = host.Settings
+				live.PriceTable = host.PriceTable
+				live.priceSpikeAt = host.priceSpikeAt
+				if interactions, ok := host.Interactions[node]; ok {
+					_, changed := histories[network][pk]
+					evicted := isEvicted(live)
+					live.Interactions[node] = mergeInteractions(live.Interactions[node], interactions, changed)
+					// The host may have been evicted in the meantime.
+					if changed && evicted {
+						evictHistory(live)
+					}
+				}
+				live.Reachability = hostReachability(live)
+			}
+			if updateGlobalScore(live, network) {
+				scores = append(scores, []any{
+					live.Score.PricesScore,
+					live.Score.StorageScore,
+					live.Score.CollateralScore,
+					live.Score.InteractionsScore,
+					live.Score.UptimeScore,
+					live.Score.AgeScore,
+					live.Score.VersionScore,
+					live.Score.LatencyScore,
+					live.Score.BenchmarksScore,
+					live.Score.ContractsScore,
+					live.Score.DurationScore,
+					live.Score.TotalScore,
+					network,
+					pk[:],
+				})
+			}
+		}
+	}
+	return
+}
+
+// mergeInteractions returns the live interactions of a node updated with
+// the staged ones. The histories and what is derived from them are only
+// taken if the updates have changed them; otherwise the live ones may
+// have been loaded or evicted in the meantime. The latency probes are
+// only kept in memory, so they are never taken from the staged copy.
+func mergeInteractions(live, staged nodeInteractions, historiesChanged bool) nodeInteractions {
+	live.Uptime = staged.Uptime
+	live.Downtime = staged.Downtime
+	live.LastSeen = staged.LastSeen
+	live.ActiveHosts = staged.ActiveHosts
+	live.Compliance = staged.Compliance
+	live.HostInteractions = staged.HostInteractions
+	if historiesChanged {
+		live.ScanHistory = staged.ScanHistory
+		live.BenchmarkHistory = staged.BenchmarkHistory
+		live.HighSkew = staged.HighSkew
+		live.InvalidSig = staged.InvalidSig
+		live.Reachability = staged.Reachability
+		live.Traffic = staged.Traffic
+		live.Score = staged.Score
+		live.Standby = staged.Standby
+		live.evicted = staged.evicted
+		live.speeds = staged.speeds
+	}
+	return live
+}
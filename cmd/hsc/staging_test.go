@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+// testHost returns a host with a scan history of the given length.
+func testHost(node string, scans int) *portalHost {
+	var history []portalScan
+	for i := 0; i < scans; i++ {
+		history = append(history, portalScan{Timestamp: time.Now().Add(-time.Duration(i) * time.Hour), Success: true})
+	}
+	return &portalHost{
+		PublicKey: types.PublicKey(frand.Entropy256()),
+		Interactions: map[string]nodeInteractions{
+			node: {ScanHistory: history},
+		},
+	}
+}
+
+func TestPublishHostsKeepsLiveChanges(t *testing.T) {
+	const node = "global"
+	host := testHost(node, 48)
+	api := &portalAPI{hosts: newHostStore(hostSet{
+		"mainnet": {host.PublicKey: host},
+	})}
+
+	staged := api.stageHosts(node, hostdb.HostUpdates{
+		Hosts: []hostdb.HostDBEntry{{Network: "mainnet", PublicKey: host.PublicKey}},
+	})
+	copied := staged["mainnet"][host.PublicKey]
+	copied.NetAddress = "host.example.com:9982"
+	interactions := copied.Interactions[node]
+	interactions.LastSeen = time.Now()
+	copied.Interactions[node] = interactions
+
+	// The other jobs change the host in the meantime.
+	api.hosts.update(func(w *hostWriter) {
+		live, _ := w.edit("mainnet", host.PublicKey)
+		live.Quarantined = true
+		live.Tags = []string{"top100"}
+		interactions := live.Interactions[node]
+		interactions.probes = []hostdb.LatencyProbe{{Timestamp: time.Now()}}
+		live.Interactions[node] = interactions
+		evictHistory(live)
+	})
+
+	api.hosts.update(func(w *hostWriter) {
+		publishHosts(w, node, staged, nil)
+	})
+
+	live := api.hosts.load()["mainnet"][host.PublicKey]
+	if live.NetAddress != "host.example.com:9982" || live.Interactions[node].LastSeen.IsZero() {
+		t.Fatal("updates not published")
+	}
+	if !live.Quarantined || len(live.Tags) != 1 {
+		t.Fatal("quarantine or tags lost")
+	}
+	if len(live.Interactions[node].probes) != 1 {
+		t.Fatal("probes lost")
+	}
+	if !live.Interactions[node].evicted || len(live.Interactions[node].ScanHistory) > keptScans {
+		t.Fatal("eviction lost")
+	}
+	if host.NetAddress != "" || host.Quarantined || len(host.Interactions[node].ScanHistory) != 48 {
+		t.Fatal("published host modified")
+	}
+}
+
+func TestPublishHostsEvictsChangedHistories(t *testing.T) {
+	const node = "global"
+	host := testHost(node, 48)
+	api := &portalAPI{hosts: newHostStore(hostSet{
+		"mainnet": {host.PublicKey: host},
+	})}
+
+	staged := api.stageHosts(node, hostdb.HostUpdates{
+		Scans: []hostdb.ScanHistory{{Network: "mainnet", PublicKey: host.PublicKey}},
+	})
+	copied := staged["mainnet"][host.PublicKey]
+	interactions := copied.Interactions[node]
+	interactions.ScanHistory = append([]portalScan{{Timestamp: time.Now(), Success: true}}, interactions.ScanHistory[:47]...)
+	copied.Interactions[node] = interactions
+
+	api.hosts.update(func(w *hostWriter) {
+		live, _ := w.edit("mainnet", host.PublicKey)
+		evictHistory(live)
+	})
+	api.hosts.update(func(w *hostWriter) {
+		publishHosts(w, node, staged, map[string]map[types.PublicKey]struct{}{
+			"mainnet": {host.PublicKey: {}},
+		})
+	})
+
+	live := api.hosts.load()["mainnet"][host.PublicKey]
+	if !live.Interactions[node].evicted || len(live.Interactions[node].ScanHistory) > keptScans {
+		t.Fatal("eviction lost")
+	}
+}
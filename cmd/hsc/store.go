@@ -2,27 +2,110 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"time"
+
+	client "github.com/mike76-dev/hostscore/api"
 )
 
-type node struct {
-	Location string `json:"location"`
+type standbyNode struct {
 	Address  string `json:"address"`
 	Password string `json:"password"`
 }
 
+type node struct {
+	Location        string       `json:"location"`
+	Address         string       `json:"address"`
+	Password        string       `json:"password"`
+	Standby         *standbyNode `json:"standby,omitempty"`
+	FailoverTimeout int          `json:"failoverTimeout,omitempty"` // in minutes
+	Push            bool         `json:"push,omitempty"`
+	Region          string       `json:"region,omitempty"`
+	Country         string       `json:"country,omitempty"`
+	Provider        string       `json:"provider,omitempty"`
+}
+
+// nodeClientConfig contains the timeouts, the retries, and the circuit
+// breaking of the requests to the nodes. The durations are in seconds.
+type nodeClientConfig struct {
+	Timeout          int `json:"timeout"`
+	BenchmarkTimeout int `json:"benchmarkTimeout"`
+	Retries          int `json:"retries"`
+	RetryBackoff     int `json:"retryBackoff"`
+	BreakerThreshold int `json:"breakerThreshold"`
+	BreakerCooldown  int `json:"breakerCooldown"`
+}
+
+// defaultNodeClientConfig is used unless set in nodes.json.
+var defaultNodeClientConfig = nodeClientConfig{
+	Timeout:          int(client.DefaultClientConfig.Timeout.Seconds()),
+	BenchmarkTimeout: int(client.DefaultClientConfig.BenchmarkTimeout.Seconds()),
+	Retries:          client.DefaultClientConfig.Retries,
+	RetryBackoff:     int(client.DefaultClientConfig.RetryBackoff.Seconds()),
+	BreakerThreshold: client.DefaultClientConfig.BreakerThreshold,
+	BreakerCooldown:  int(client.DefaultClientConfig.BreakerCooldown.Seconds()),
+}
+
+func (nc nodeClientConfig) validate() error {
+	if nc.Timeout < 0 || nc.BenchmarkTimeout < 0 || nc.RetryBackoff < 0 || nc.BreakerCooldown < 0 {
+		return errors.New("node client durations may not be negative")
+	}
+	if nc.Retries < 0 || nc.BreakerThreshold < 0 {
+		return errors.New("node client retries and breaker threshold may not be negative")
+	}
+	return nil
+}
+
+// clientConfig returns the configuration of the node clients.
+func (nc nodeClientConfig) clientConfig() client.ClientConfig {
+	return client.ClientConfig{
+		Timeout:          time.Duration(nc.Timeout) * time.Second,
+		BenchmarkTimeout: time.Duration(nc.BenchmarkTimeout) * time.Second,
+		Retries:          nc.Retries,
+		RetryBackoff:     time.Duration(nc.RetryBackoff) * time.Second,
+		BreakerThreshold: nc.BreakerThreshold,
+		BreakerCooldown:  time.Duration(nc.BreakerCooldown) * time.Second,
+	}
+}
+
 type persistData struct {
-	Nodes []node `json:"nodes"`
+	Nodes    []node               `json:"nodes"`
+	Weights  scoreWeights         `json:"weights"`
+	SMTP     *smtpConfig          `json:"smtp,omitempty"`
+	Telegram *telegramConfig      `json:"telegram,omitempty"`
+	Tiers    map[string]int       `json:"rateLimitTiers,omitempty"`
+	TagRules []tagRule            `json:"tagRules,omitempty"`
+	GeoIP    *geoIPConfig         `json:"geoip,omitempty"`
+	Sybil    sybilConfig          `json:"sybil"`
+	Releases []releaseRequirement `json:"releases,omitempty"`
+	NewHosts []newHostWebhook     `json:"newHostWebhooks,omitempty"`
+	Client   nodeClientConfig     `json:"nodeClient"`
 }
 
 type jsonStore struct {
-	nodes map[string]node
+	nodes    map[string]node
+	weights  scoreWeights
+	smtp     *smtpConfig
+	telegram *telegramConfig
+	tiers    map[string]int
+	tagRules []tagRule
+	geoip    *geoIPConfig
+	sybil    sybilConfig
+	releases []releaseRequirement
+	newHosts []newHostWebhook
+	client   nodeClientConfig
 }
 
 func newJSONStore(dir string) (*jsonStore, error) {
 	s := &jsonStore{
-		nodes: make(map[string]node),
+		nodes:    make(map[string]node),
+		weights:  defaultScoreWeights,
+		tagRules: defaultTagRules,
+		sybil:    defaultSybilConfig,
+		releases: defaultReleaseRequirements,
+		client:   defaultNodeClientConfig,
 	}
 	err := s.load(dir)
 	if err != nil {
@@ -32,7 +115,9 @@ func newJSONStore(dir string) (*jsonStore, error) {
 }
 
 func (s *jsonStore) load(dir string) error {
-	var p persistData
+	// The weights, the sybil and the node client settings that are not set
+	// keep their default values.
+	p := persistData{Weights: defaultScoreWeights, Sybil: defaultSybilConfig, Client: defaultNodeClientConfig}
 	if js, err := os.ReadFile(filepath.Join(dir, "nodes.json")); os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
@@ -40,8 +125,58 @@ func (s *jsonStore) load(dir string) error {
 	} else if err := json.Unmarshal(js, &p); err != nil {
 		return err
 	}
+	if err := p.Weights.validate(); err != nil {
+		return err
+	}
+	if p.SMTP != nil {
+		if err := p.SMTP.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateTiers(p.Tiers); err != nil {
+		return err
+	}
+	if p.Telegram != nil {
+		if err := p.Telegram.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateTagRules(p.TagRules); err != nil {
+		return err
+	}
+	if p.GeoIP != nil {
+		if err := p.GeoIP.validate(); err != nil {
+			return err
+		}
+	}
+	if err := p.Sybil.validate(); err != nil {
+		return err
+	}
+	if err := validateReleases(p.Releases); err != nil {
+		return err
+	}
+	if err := validateNewHostWebhooks(p.NewHosts); err != nil {
+		return err
+	}
+	if err := p.Client.validate(); err != nil {
+		return err
+	}
 	for _, n := range p.Nodes {
 		s.nodes[n.Location] = n
 	}
+	s.weights = p.Weights
+	s.smtp = p.SMTP
+	s.telegram = p.Telegram
+	s.tiers = p.Tiers
+	if p.TagRules != nil {
+		s.tagRules = p.TagRules
+	}
+	s.geoip = p.GeoIP
+	s.sybil = p.Sybil
+	if p.Releases != nil {
+		s.releases = p.Releases
+	}
+	s.newHosts = p.NewHosts
+	s.client = p.Client
 	return nil
 }
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+	"lukechampine.com/frand"
+)
+
+const (
+	// subscriptionCheckInterval determines how often the subscriptions
+	// are evaluated.
+	subscriptionCheckInterval = time.Minute
+
+	// subscriptionConfirmTimeout is how long an unconfirmed subscription
+	// is kept before it is removed.
+	subscriptionConfirmTimeout = 24 * time.Hour
+
+	// maxSubscriptionsPerEmail is the maximum number of hosts an email
+	// address can be subscribed to.
+	maxSubscriptionsPerEmail = 50
+
+	// failedScansThreshold is the number of consecutive failed scans
+	// after which the host is reported as failing.
+	failedScansThreshold = 3
+
+	// derankThreshold is the number of places the host has to drop in the
+	// ranking to be reported as deranked.
+	derankThreshold = 50
+
+	// mailAttempts is the number of times sending an email is attempted
+	// before the notification is dropped.
+	mailAttempts = 3
+)
+
+var (
+	errInvalidEmail          = errors.New("invalid email address")
+	errTooManySubscriptions  = errors.New("too many subscriptions")
+	errSubscriptionNotFound  = errors.New("subscription not found")
+	errNotificationsDisabled = errors.New("email notifications are not enabled")
+)
+
+// subscriptionKey identifies a subscription.
+type subscriptionKey struct {
+	network string
+	pk      types.PublicKey
+	email   string
+}
+
+// subscription subscribes an email address to the notifications about
+// a host.
+type subscription struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Confirmed bool            `json:"confirmed"`
+	CreatedAt time.Time       `json:"createdAt"`
+
+	email  string
+	secret string
+	state  subscriptionState
+}
+
+// subscriptionState is the state of the host when the subscription was
+// last evaluated. Like with the alerts, it is kept in memory only.
+type subscriptionState struct {
+	initialized      bool
+	failingScans     bool
+	failingBenchmark bool
+	rank             int
+}
+
+type subscriptionRequest struct {
+	Email      string            `json:"email"`
+	Network    string            `json:"network"`
+	PublicKeys []types.PublicKey `json:"publicKeys"`
+}
+
+type subscriptionsResponse struct {
+	Subscriptions []subscription `json:"subscriptions"`
+}
+
+// pendingEmail is an email waiting to be sent.
+type pendingEmail struct {
+	to      string
+	subject string
+	body    string
+}
+
+// subscriptionManager keeps the email subscriptions.
+type subscriptionManager struct {
+	mu   sync.Mutex
+	subs map[subscriptionKey]*subscription
+}
+
+func newSubscriptionManager() *subscriptionManager {
+	return &subscriptionManager{
+		subs: make(map[subscriptionKey]*subscription),
+	}
+}
+
+// secret returns the secret of the email address, if it has any
+// subscriptions.
+func (sm *subscriptionManager) secret(email string) (string, int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	var secret string
+	var n int
+	for key, s := range sm.subs {
+		if key.email == email {
+			secret = s.secret
+			n++
+		}
+	}
+	return secret, n
+}
+
+// list returns the subscriptions of the email address if the secret
+// matches.
+func (sm *subscriptionManager) list(email, secret string) ([]subscription, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	var subs []subscription
+	for key, s := range sm.subs {
+		if key.email != email {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(s.secret), []byte(secret)) != 1 {
+			return nil, false
+		}
+		subs = append(subs, *s)
+	}
+	return subs, len(subs) > 0
+}
+
+// loadSubscriptions loads the subscriptions from the database.
+func (api *portalAPI) loadSubscriptions() error {
+	rows, err := api.db.Query(`
+		SELECT
+			network,
+			public_key,
+			email,
+			secret,
+			confirmed,
+			created_at
+		FROM subscriptions
+	`)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query subscriptions")
+	}
+	defer rows.Close()
+
+	subs := make(map[subscriptionKey]*subscription)
+	for rows.Next() {
+		s := new(subscription)
+		pk := make([]byte, 32)
+		var created int64
+		if err := rows.Scan(
+			&s.Network,
+			&pk,
+			&s.email,
+			&s.secret,
+			&s.Confirmed,
+			&created,
+		); err != nil {
+			return utils.AddContext(err, "couldn't decode subscription")
+		}
+		s.PublicKey = types.PublicKey(pk)
+		s.CreatedAt = time.Unix(created, 0)
+		subs[subscriptionKey{s.Network, s.PublicKey, s.email}] = s
+	}
+	if err := rows.Err(); err != nil {
+		return utils.AddContext(err, "couldn't load subscriptions")
+	}
+
+	api.subscriptions.mu.Lock()
+	for key, s := range subs {
+		api.subscriptions.subs[key] = s
+	}
+	api.subscriptions.mu.Unlock()
+
+	return nil
+}
+
+// subscribe subscribes the email address to the hosts. The new
+// subscriptions stay inactive until they are confirmed with the secret
+// sent to the address.
+func (api *portalAPI) subscribe(sr subscriptionRequest) error {
+	secret, n := api.subscriptions.secret(sr.Email)
+	if n+len(sr.PublicKeys) > maxSubscriptionsPerEmail {
+		return errTooManySubscriptions
+	}
+	if secret == "" {
+		secret = hex.EncodeToString(frand.Bytes(16))
+	}
+
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO subscriptions (
+			network,
+			public_key,
+			email,
+			secret,
+			confirmed,
+			created_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (network, public_key, email) UPDATE
+			created_at = new.created_at
+	`)
+	if err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	var subs []*subscription
+	for _, pk := range sr.PublicKeys {
+		s := &subscription{
+			Network:   sr.Network,
+			PublicKey: pk,
+			CreatedAt: time.Now(),
+			email:     sr.Email,
+			secret:    secret,
+		}
+		if _, err := stmt.Exec(s.Network, pk[:], s.email, s.secret, false, s.CreatedAt.Unix()); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't save subscription")
+		}
+		subs = append(subs, s)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	api.subscriptions.mu.Lock()
+	for _, s := range subs {
+		key := subscriptionKey{s.Network, s.PublicKey, s.email}
+		if old, ok := api.subscriptions.subs[key]; ok {
+			old.CreatedAt = s.CreatedAt
+			continue
+		}
+		api.subscriptions.subs[key] = s
+	}
+	api.subscriptions.mu.Unlock()
+
+	go api.sendEmail(pendingEmail{
+		to:      sr.Email,
+		subject: "Confirm your HostScore subscription",
+		body: fmt.Sprintf(
+			"Someone, hopefully you, has subscribed this address to the notifications about %d %s host(s) on HostScore.\n\n"+
+				"To confirm the subscription, use the following code:\n\n%s\n\n"+
+				"If you did not request this, just ignore this email.\n",
+			len(sr.PublicKeys), sr.Network, secret,
+		),
+	})
+
+	return nil
+}
+
+// confirmSubscriptions activates the subscriptions of the email address.
+func (api *portalAPI) confirmSubscriptions(email, secret string) error {
+	if _, ok := api.subscriptions.list(email, secret); !ok {
+		return errSubscriptionNotFound
+	}
+
+	if _, err := api.db.Exec("UPDATE subscriptions SET confirmed = ? WHERE email = ?", true, email); err != nil {
+		return utils.AddContext(err, "couldn't confirm subscriptions")
+	}
+
+	api.subscriptions.mu.Lock()
+	for key, s := range api.subscriptions.subs {
+		if key.email == email {
+			s.Confirmed = true
+		}
+	}
+	api.subscriptions.mu.Unlock()
+
+	return nil
+}
+
+// unsubscribe removes the subscription of the email address to the host,
+// or all of its subscriptions if no host is given.
+func (api *portalAPI) unsubscribe(email, secret, network string, pk types.PublicKey) error {
+	if _, ok := api.subscriptions.list(email, secret); !ok {
+		return errSubscriptionNotFound
+	}
+
+	var err error
+	if pk == (types.PublicKey{}) {
+		_, err = api.db.Exec("DELETE FROM subscriptions WHERE email = ?", email)
+	} else {
+		_, err = api.db.Exec("DELETE FROM subscriptions WHERE network = ? AND public_key = ? AND email = ?", network, pk[:], email)
+	}
+	if err != nil {
+		return utils.AddContext(err, "couldn't delete subscription")
+	}
+
+	api.subscriptions.mu.Lock()
+	for key := range api.subscriptions.subs {
+		if key.email == email && (pk == (types.PublicKey{}) || (key.network == network && key.pk == pk)) {
+			delete(api.subscriptions.subs, key)
+		}
+	}
+	api.subscriptions.mu.Unlock()
+
+	return nil
+}
+
+// pruneSubscriptions removes the subscriptions that haven't been
+// confirmed in time.
+func (api *portalAPI) pruneSubscriptions() error {
+	cutoff := time.Now().Add(-subscriptionConfirmTimeout)
+	if _, err := api.db.Exec("DELETE FROM subscriptions WHERE confirmed = ? AND created_at < ?", false, cutoff.Unix()); err != nil {
+		return utils.AddContext(err, "couldn't prune subscriptions")
+	}
+
+	api.subscriptions.mu.Lock()
+	for key, s := range api.subscriptions.subs {
+		if !s.Confirmed && s.CreatedAt.Before(cutoff) {
+			delete(api.subscriptions.subs, key)
+		}
+	}
+	api.subscriptions.mu.Unlock()
+
+	return nil
+}
+
+// failingScans returns true if the latest scans of the host have failed
+// on all nodes. The second value is false if there are not enough scans
+// to tell, e.g. because the history has been evicted.
+func failingScans(host *portalHost) (failing, known bool) {
+	failing = true
+	for _, interactions := range host.Interactions {
+		history := interactions.ScanHistory
+		if len(history) < failedScansThreshold {
+			continue
+		}
+		known = true
+		for _, scan := range history[:failedScansThreshold] {
+			if scan.Success {
+				failing = false
+			}
+		}
+	}
+	return failing && known, known
+}
+
+// failingBenchmark returns true if the latest benchmark of the host has
+// failed on all nodes that have benchmarked it.
+func failingBenchmark(host *portalHost) (failing, known bool) {
+	failing = true
+	for _, interactions := range host.Interactions {
+		if len(interactions.BenchmarkHistory) == 0 {
+			continue
+		}
+		known = true
+		if interactions.BenchmarkHistory[0].Success {
+			failing = false
+		}
+	}
+	return failing && known, known
+}
+
+// evaluate compares the host with its state at the previous evaluation
+// and returns the messages to send.
+func (s *subscription) evaluate(host *portalHost) (messages []string) {
+	scans, scansKnown := failingScans(host)
+	benchmark, benchmarkKnown := failingBenchmark(host)
+	if !s.state.initialized {
+		s.state = subscriptionState{
+			initialized:      true,
+			failingScans:     scans,
+			failingBenchmark: benchmark,
+			rank:             host.Rank,
+		}
+		return nil
+	}
+
+	if scansKnown {
+		if scans && !s.state.failingScans {
+			messages = append(messages, fmt.Sprintf("The last %d scans of the host have failed.", failedScansThreshold))
+		}
+		s.state.failingScans = scans
+	}
+	if benchmarkKnown {
+		if benchmark && !s.state.failingBenchmark {
+			messages = append(messages, "The latest benchmark of the host has failed.")
+		}
+		s.state.failingBenchmark = benchmark
+	}
+
+	// Unranked hosts have a zero rank.
+	switch {
+	case host.Rank == 0:
+	case s.state.rank == 0 || host.Rank < s.state.rank:
+		s.state.rank = host.Rank
+	case host.Rank-s.state.rank >= derankThreshold:
+		messages = append(messages, fmt.Sprintf("The host has dropped from rank %d to rank %d.", s.state.rank, host.Rank))
+		s.state.rank = host.Rank
+	}
+
+	return
+}
+
+// checkSubscriptions evaluates the confirmed subscriptions and sends the
+// notifications.
+func (api *portalAPI) checkSubscriptions() {
+	var pending []pendingEmail
+	hosts := api.hosts.load()
+	api.subscriptions.mu.Lock()
+	for key, s := range api.subscriptions.subs {
+		if !s.Confirmed {
+			continue
+		}
+		host, ok := hosts[key.network][key.pk]
+		if !ok {
+			continue
+		}
+		messages := s.evaluate(host)
+		if len(messages) == 0 {
+			continue
+		}
+		pending = append(pending, pendingEmail{
+			to:      key.email,
+			subject: fmt.Sprintf("HostScore: %s needs attention", host.NetAddress),
+			body: fmt.Sprintf(
+				"Host: %s\nPublic key: %s\nNetwork: %s\nRank: %d\nScore: %.4f\n\n%s\n\n"+
+					"To unsubscribe, send a DELETE request to "+apiPrefix+"/subscriptions with the email and the secret %s.\n",
+				host.NetAddress, key.pk, key.network, host.Rank, host.Score.TotalScore,
+				strings.Join(messages, "\n"), s.secret,
+			),
+		})
+	}
+	api.subscriptions.mu.Unlock()
+
+	for _, p := range pending {
+		go api.sendEmail(p)
+	}
+}
+
+// watchSubscriptions prunes and evaluates the subscriptions.
+func (api *portalAPI) watchSubscriptions() error {
+	err := api.pruneSubscriptions()
+	api.checkSubscriptions()
+	return utils.AddContext(err, "couldn't prune subscriptions")
+}
+
+// sendEmail sends the email, retrying with a growing delay if it fails.
+func (api *portalAPI) sendEmail(p pendingEmail) {
+	var err error
+	for attempt := 0; attempt < mailAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-api.stopChan:
+				return
+			case <-time.After(time.Duration(1<<attempt) * time.Second):
+			}
+		}
+		if err = api.store.smtp.sendMail(p.to, p.subject, p.body); err == nil {
+			return
+		}
+	}
+	api.log.Warn("couldn't send email", zap.Error(err))
+}
+
+// subscriptionParams parses the email address and the secret.
+func subscriptionParams(w http.ResponseWriter, req *http.Request) (string, string, bool) {
+	email, err := parseEmail(req.FormValue("email"))
+	if err != nil {
+		writeError(w, "invalid email address", http.StatusBadRequest)
+		return "", "", false
+	}
+	secret := req.FormValue("secret")
+	if secret == "" {
+		writeError(w, "secret not provided", http.StatusBadRequest)
+		return "", "", false
+	}
+	return email, secret, true
+}
+
+// checkNotifications writes an error if the subscriptions can't be used
+// on this portal.
+func (api *portalAPI) checkNotifications(w http.ResponseWriter) bool {
+	if api.mirrorURL != "" {
+		writeError(w, "subscriptions are not accepted by a mirror", http.StatusForbidden)
+		return false
+	}
+	if api.store.smtp == nil {
+		writeError(w, errNotificationsDisabled.Error(), http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+func (api *portalAPI) subscriptionsCreateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !api.checkNotifications(w) {
+		return
+	}
+
+	var sr subscriptionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAlertRequestSize)).Decode(&sr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	email, err := parseEmail(sr.Email)
+	if err != nil {
+		writeError(w, "invalid email address", http.StatusBadRequest)
+		return
+	}
+	sr.Email = email
+	sr.Network = strings.ToLower(sr.Network)
+	if sr.Network == "" {
+		sr.Network = "mainnet"
+	}
+	if sr.Network != "mainnet" && sr.Network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	if len(sr.PublicKeys) == 0 {
+		writeError(w, "no hosts provided", http.StatusBadRequest)
+		return
+	}
+	hosts := api.hosts.load()
+	for _, pk := range sr.PublicKeys {
+		if _, ok := hosts[sr.Network][pk]; !ok {
+			writeError(w, "host not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = api.subscribe(sr)
+	if errors.Is(err, errTooManySubscriptions) {
+		writeError(w, "too many subscriptions", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't save subscription", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (api *portalAPI) subscriptionsConfirmHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !api.checkNotifications(w) {
+		return
+	}
+	email, secret, ok := subscriptionParams(w, req)
+	if !ok {
+		return
+	}
+	err := api.confirmSubscriptions(email, secret)
+	if errors.Is(err, errSubscriptionNotFound) {
+		writeError(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't confirm subscriptions", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *portalAPI) subscriptionsGetHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !api.checkNotifications(w) {
+		return
+	}
+	email, secret, ok := subscriptionParams(w, req)
+	if !ok {
+		return
+	}
+	subs, ok := api.subscriptions.list(email, secret)
+	if !ok {
+		writeError(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, subscriptionsResponse{Subscriptions: subs})
+}
+
+func (api *portalAPI) subscriptionsDeleteHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !api.checkNotifications(w) {
+		return
+	}
+	email, secret, ok := subscriptionParams(w, req)
+	if !ok {
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if host := req.FormValue("host"); host != "" {
+		if err := pk.UnmarshalText([]byte(host)); err != nil {
+			writeError(w, "invalid public key", http.StatusBadRequest)
+			return
+		}
+	}
+	err := api.unsubscribe(email, secret, network, pk)
+	if errors.Is(err, errSubscriptionNotFound) {
+		writeError(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't delete subscription", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"go.sia.tech/core/types"
+)
+
+// sybilResolveInterval determines how often the addresses of the hosts
+// are resolved to find their subnets.
+const sybilResolveInterval = time.Hour
+
+// sybilConfig determines which hosts form a sybil cluster. The hosts
+// sharing a /24 (IPv4) or a /64 (IPv6) subnet with at least
+// MinSubnetHosts-1 other online hosts, or a wallet address with at least
+// MinWalletHosts-1 other ones, are clustered together. Penalty is the
+// fraction the ranking score of each cluster member but the best one is
+// reduced by. A zero Penalty only reports the clusters.
+type sybilConfig struct {
+	MinSubnetHosts int     `json:"minSubnetHosts"`
+	MinWalletHosts int     `json:"minWalletHosts"`
+	Penalty        float64 `json:"penalty"`
+}
+
+// defaultSybilConfig is used unless set in nodes.json.
+var defaultSybilConfig = sybilConfig{
+	MinSubnetHosts: 3,
+	MinWalletHosts: 2,
+	Penalty:        0,
+}
+
+func (sc sybilConfig) validate() error {
+	if sc.MinSubnetHosts < 2 || sc.MinWalletHosts < 2 {
+		return errors.New("a sybil cluster must consist of at least two hosts")
+	}
+	if math.IsNaN(sc.Penalty) || sc.Penalty < 0 || sc.Penalty >= 1 {
+		return errors.New("sybil penalty must be between 0 and 1")
+	}
+	return nil
+}
+
+// sybilCluster is a group of hosts likely run by the same operator.
+type sybilCluster struct {
+	ID      int               `json:"id"`
+	Hosts   []sybilHost       `json:"hosts"`
+	Subnets []string          `json:"subnets"`
+	Wallets []types.Address   `json:"wallets"`
+	members []types.PublicKey // sorted by the score, the best first
+}
+
+// sybilHost is a member of a sybil cluster.
+type sybilHost struct {
+	PublicKey  types.PublicKey `json:"publicKey"`
+	NetAddress string          `json:"netaddress"`
+	Rank       int             `json:"rank"`
+	Penalized  bool            `json:"penalized"`
+}
+
+// sybilReport lists the sybil clusters of a network, the largest first.
+type sybilReport struct {
+	Penalty  float64        `json:"penalty"`
+	Clusters []sybilCluster `json:"clusters"`
+}
+
+// sybilSubnets returns the /24 subnets of the IPv4 addresses and the /64
+// subnets of the IPv6 addresses.
+func sybilSubnets(ips []net.IP) []string {
+	var subnets []string
+	for _, ip := range ips {
+		var ipNet net.IPNet
+		if ip4 := ip.To4(); ip4 != nil {
+			ipNet = net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+		} else {
+			ipNet = net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+		}
+		if s := ipNet.String(); !slices.Contains(subnets, s) {
+			subnets = append(subnets, s)
+		}
+	}
+	return subnets
+}
+
+// resolveSybilSubnets looks up the addresses of the online hosts. The
+// subnets stored by the nodes are not used, because they are /54 for
+// IPv6, which is too coarse.
+func (api *portalAPI) resolveSybilSubnets() error {
+	type hostAddress struct {
+		network string
+		pk      types.PublicKey
+		addr    string
+	}
+	var addresses []hostAddress
+	for network, hosts := range api.hosts.load() {
+		for pk, host := range hosts {
+			if host.OptOut != hostdb.OptOutDelist && isOnline(*host) {
+				addresses = append(addresses, hostAddress{network, pk, host.NetAddress})
+			}
+		}
+	}
+
+	resolved := make(map[hostAddress][]string)
+	for _, ha := range addresses {
+		h, _, err := net.SplitHostPort(ha.addr)
+		if err != nil {
+			continue
+		}
+		ips, err := net.LookupIP(h)
+		if err != nil {
+			continue
+		}
+		resolved[ha] = sybilSubnets(ips)
+	}
+
+	api.hosts.update(func(w *hostWriter) {
+		for ha, subnets := range resolved {
+			if host, exists := w.edit(ha.network, ha.pk); exists {
+				host.sybilSubnets = subnets
+			}
+		}
+	})
+	// The clusters are found when the hosts are ranked.
+	api.rankPending.Store(true)
+
+	return nil
+}
+
+// findSybilClusters groups the online hosts of a network by their subnets
+// and wallet addresses. All members of a cluster but the one with the
+// best score are penalized. The subnets of the hosts not resolved yet are
+// taken from the nodes. The hosts are modified, so they must be the
+// copies made for the ranking.
+func (api *portalAPI) findSybilClusters(hosts map[types.PublicKey]*portalHost) []sybilCluster {
+	parent := make(map[types.PublicKey]types.PublicKey)
+	var find func(types.PublicKey) types.PublicKey
+	find = func(pk types.PublicKey) types.PublicKey {
+		if p := parent[pk]; p != pk {
+			parent[pk] = find(p)
+		}
+		return parent[pk]
+	}
+
+	subnetHosts := make(map[string][]types.PublicKey)
+	walletHosts := make(map[types.Address][]types.PublicKey)
+	for pk, host := range hosts {
+		host.SybilCluster, host.sybilPenalized = 0, false
+		if host.OptOut == hostdb.OptOutDelist || !isOnline(*host) {
+			continue
+		}
+		parent[pk] = pk
+		subnets := host.sybilSubnets
+		if subnets == nil {
+			subnets = host.IPNets
+		}
+		for _, subnet := range subnets {
+			if subnet != "" {
+				subnetHosts[subnet] = append(subnetHosts[subnet], pk)
+			}
+		}
+		if host.Settings.Address != types.VoidAddress {
+			walletHosts[host.Settings.Address] = append(walletHosts[host.Settings.Address], pk)
+		}
+	}
+
+	// Only the subnets and the wallets shared by enough hosts link
+	// them together.
+	var subnets []string
+	for subnet, pks := range subnetHosts {
+		if len(pks) < api.sybil.MinSubnetHosts {
+			continue
+		}
+		subnets = append(subnets, subnet)
+		for _, pk := range pks[1:] {
+			parent[find(pk)] = find(pks[0])
+		}
+	}
+	var wallets []types.Address
+	for wallet, pks := range walletHosts {
+		if len(pks) < api.sybil.MinWalletHosts {
+			continue
+		}
+		wallets = append(wallets, wallet)
+		for _, pk := range pks[1:] {
+			parent[find(pk)] = find(pks[0])
+		}
+	}
+
+	groups := make(map[types.PublicKey]*sybilCluster)
+	for pk := range parent {
+		root := find(pk)
+		cluster, ok := groups[root]
+		if !ok {
+			cluster = &sybilCluster{}
+			groups[root] = cluster
+		}
+		cluster.members = append(cluster.members, pk)
+	}
+	for _, subnet := range subnets {
+		cluster := groups[find(subnetHosts[subnet][0])]
+		cluster.Subnets = append(cluster.Subnets, subnet)
+	}
+	for _, wallet := range wallets {
+		cluster := groups[find(walletHosts[wallet][0])]
+		cluster.Wallets = append(cluster.Wallets, wallet)
+	}
+
+	var clusters []sybilCluster
+	for _, cluster := range groups {
+		if len(cluster.members) < 2 {
+			continue
+		}
+		slices.SortFunc(cluster.members, func(a, b types.PublicKey) int {
+			if c := cmp.Compare(hosts[b].Score.TotalScore, hosts[a].Score.TotalScore); c != 0 {
+				return c
+			}
+			return hosts[a].ID - hosts[b].ID
+		})
+		slices.Sort(cluster.Subnets)
+		slices.SortFunc(cluster.Wallets, func(a, b types.Address) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		// The cluster is identified by the oldest host.
+		cluster.ID = hosts[cluster.members[0]].ID
+		for i, pk := range cluster.members {
+			cluster.ID = min(cluster.ID, hosts[pk].ID)
+			hosts[pk].sybilPenalized = i > 0 && api.sybil.Penalty > 0
+		}
+		for _, pk := range cluster.members {
+			hosts[pk].SybilCluster = cluster.ID
+		}
+		clusters = append(clusters, *cluster)
+	}
+	slices.SortFunc(clusters, func(a, b sybilCluster) int {
+		if len(a.members) != len(b.members) {
+			return len(b.members) - len(a.members)
+		}
+		return a.ID - b.ID
+	})
+	return clusters
+}
+
+// rankingScore returns the score the host is ranked by, which includes
+// the sybil penalty.
+func (api *portalAPI) rankingScore(host portalHost) float64 {
+	if host.sybilPenalized {
+		return host.Score.TotalScore * (1 - api.sybil.Penalty)
+	}
+	return host.Score.TotalScore
+}
+
+// getSybilReport returns the sybil clusters of the network as of the
+// last ranking. It doesn't need the lock.
+func (api *portalAPI) getSybilReport(network string) sybilReport {
+	report := sybilReport{Penalty: api.sybil.Penalty}
+	r := api.ranking.Load()
+	if r == nil {
+		report.Clusters = []sybilCluster{}
+		return report
+	}
+	report.Clusters = make([]sybilCluster, 0, len(r.clusters[network]))
+	for _, cluster := range r.clusters[network] {
+		c := cluster
+		c.Hosts = make([]sybilHost, 0, len(cluster.members))
+		if c.Subnets == nil {
+			c.Subnets = []string{}
+		}
+		if c.Wallets == nil {
+			c.Wallets = []types.Address{}
+		}
+		for _, pk := range cluster.members {
+			host, exists := r.hosts[network][pk]
+			if !exists {
+				continue
+			}
+			c.Hosts = append(c.Hosts, sybilHost{
+				PublicKey:  pk,
+				NetAddress: host.NetAddress,
+				Rank:       host.Rank,
+				Penalized:  host.sybilPenalized,
+			})
+		}
+		report.Clusters = append(report.Clusters, c)
+	}
+	return report
+}
+
+func (api *portalAPI) networkSybilHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, api.getSybilReport(network))
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/build"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// tagsSaveInterval determines how often the changed tags are written to
+// the database.
+const tagsSaveInterval = 10 * time.Minute
+
+// Tag conditions.
+const (
+	// tagFirstSeenWithin matches the hosts first seen within Value days.
+	tagFirstSeenWithin = "firstSeenWithin"
+
+	// tagRankAtMost matches the online hosts ranked Value or better.
+	tagRankAtMost = "rankAtMost"
+
+	// tagPriceSpikeWithin matches the hosts that have raised a price by
+	// priceSpikeThreshold or more within Value days.
+	tagPriceSpikeWithin = "priceSpikeWithin"
+
+	// tagSubnetHostsAtLeast matches the hosts sharing a subnet with at
+	// least Value online hosts, including themselves.
+	tagSubnetHostsAtLeast = "subnetHostsAtLeast"
+
+	// tagStaleVersion matches the hosts running an older release than
+	// the one most common among the online hosts.
+	tagStaleVersion = "staleVersion"
+)
+
+// tagRule assigns the tag to the hosts matching the condition. Value is
+// the parameter of the condition, if it has one.
+type tagRule struct {
+	Tag       string  `json:"tag"`
+	Condition string  `json:"condition"`
+	Value     float64 `json:"value,omitempty"`
+}
+
+// defaultTagRules are used unless the rules are set in nodes.json.
+var defaultTagRules = []tagRule{
+	{Tag: "new", Condition: tagFirstSeenWithin, Value: 7},
+	{Tag: "top100", Condition: tagRankAtMost, Value: 100},
+	{Tag: "price-spike", Condition: tagPriceSpikeWithin, Value: 7},
+	{Tag: "single-subnet-farm", Condition: tagSubnetHostsAtLeast, Value: 3},
+	{Tag: "stale-version", Condition: tagStaleVersion},
+}
+
+var tagRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// validateTagRules checks that the rules have valid tags, known
+// conditions, and positive values where required.
+func validateTagRules(rules []tagRule) error {
+	for _, rule := range rules {
+		if !tagRegex.MatchString(rule.Tag) {
+			return fmt.Errorf("invalid tag %q: only lowercase letters, digits, and dashes are allowed", rule.Tag)
+		}
+		switch rule.Condition {
+		case tagFirstSeenWithin, tagRankAtMost, tagPriceSpikeWithin, tagSubnetHostsAtLeast:
+			if rule.Value <= 0 {
+				return fmt.Errorf("tag %q: the value must be positive", rule.Tag)
+			}
+		case tagStaleVersion:
+		default:
+			return fmt.Errorf("tag %q: unknown condition %q", rule.Tag, rule.Condition)
+		}
+	}
+	return nil
+}
+
+var releaseVersionRegex = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// releaseVersion extracts the version number from the release string of
+// the host, e.g. "2.0.4" from "hostd v2.0.4".
+func releaseVersion(release string) string {
+	return releaseVersionRegex.FindString(release)
+}
+
+// settingsPrices returns the prices of the settings for comparing them
+// with priceIncrease.
+func settingsPrices(settings rhpv2.HostSettings) priceChange {
+	return priceChange{
+		StoragePrice:  settings.StoragePrice,
+		UploadPrice:   settings.UploadBandwidthPrice,
+		DownloadPrice: settings.DownloadBandwidthPrice,
+	}
+}
+
+// tagContext contains the network-wide data the rules are evaluated
+// against.
+type tagContext struct {
+	online        map[types.PublicKey]bool
+	subnetHosts   map[string]int
+	commonVersion string
+}
+
+// newTagContext collects the data about the hosts of the network.
+func newTagContext(hosts map[types.PublicKey]*portalHost) tagContext {
+	tc := tagContext{
+		online:      make(map[types.PublicKey]bool),
+		subnetHosts: make(map[string]int),
+	}
+	versions := make(map[string]int)
+	for pk, host := range hosts {
+		if host.OptOut == hostdb.OptOutDelist || !isOnline(*host) {
+			continue
+		}
+		tc.online[pk] = true
+		for _, subnet := range host.IPNets {
+			if subnet != "" {
+				tc.subnetHosts[subnet]++
+			}
+		}
+		if v := releaseVersion(host.Settings.Release); v != "" {
+			versions[v]++
+		}
+	}
+	var count int
+	for v, n := range versions {
+		if n > count || (n == count && build.VersionCmp(v, tc.commonVersion) > 0) {
+			tc.commonVersion, count = v, n
+		}
+	}
+	return tc
+}
+
+// matches returns true if the host satisfies the condition of the rule.
+func (tc tagContext) matches(rule tagRule, host *portalHost) bool {
+	days := time.Duration(rule.Value * float64(24*time.Hour))
+	switch rule.Condition {
+	case tagFirstSeenWithin:
+		return time.Since(host.FirstSeen) < days
+	case tagRankAtMost:
+		return tc.online[host.PublicKey] && !host.Quarantined && host.Rank > 0 && float64(host.Rank) <= rule.Value
+	case tagPriceSpikeWithin:
+		return !host.priceSpikeAt.IsZero() && time.Since(host.priceSpikeAt) < days
+	case tagSubnetHostsAtLeast:
+		for _, subnet := range host.IPNets {
+			if subnet != "" && float64(tc.subnetHosts[subnet]) >= rule.Value {
+				return true
+			}
+		}
+	case tagStaleVersion:
+		v := releaseVersion(host.Settings.Release)
+		return v != "" && tc.commonVersion != "" && build.VersionCmp(v, tc.commonVersion) < 0
+	}
+	return false
+}
+
+// tagHosts evaluates the tag rules on the given hosts, which must not
+// have been published. The ranking evaluates them on its copies of the
+// hosts.
+func (api *portalAPI) tagHosts(networks hostSet) {
+	for _, hosts := range networks {
+		tc := newTagContext(hosts)
+		for _, host := range hosts {
+			tags := []string{}
+			for _, rule := range api.tagRules {
+				if !slices.Contains(tags, rule.Tag) && tc.matches(rule, host) {
+					tags = append(tags, rule.Tag)
+				}
+			}
+			host.Tags = tags
+		}
+	}
+}
+
+// recentPriceChangesQuery retrieves the price changes of all hosts after
+// a point of time, ordered so that the changes of each host come in one
+// piece.
+const recentPriceChangesQuery = `
+	SELECT
+		network,
+		public_key,
+		changed_at,
+		storage_price,
+		upload_price,
+		download_price
+	FROM price_changes
+	WHERE changed_at > ?
+	ORDER BY network, public_key, changed_at ASC
+`
+
+// loadPriceSpikes finds the recent price spikes of the hosts, which are
+// otherwise only detected when the prices change. The hosts must not have
+// been published.
+func (api *portalAPI) loadPriceSpikes(hosts hostSet) error {
+	var window float64
+	for _, rule := range api.tagRules {
+		if rule.Condition == tagPriceSpikeWithin {
+			window = max(window, rule.Value)
+		}
+	}
+	if window == 0 {
+		return nil
+	}
+	// The changes before the window are needed as the base of the
+	// first change within it.
+	from := time.Now().Add(-2 * time.Duration(window*float64(24*time.Hour)))
+
+	rows, err := api.db.Query(recentPriceChangesQuery, from.Unix())
+	if err != nil {
+		return utils.AddContext(err, "couldn't query price changes")
+	}
+	defer rows.Close()
+
+	var last priceChange
+	var lastNetwork string
+	var lastKey types.PublicKey
+	for rows.Next() {
+		var network string
+		pk := make([]byte, 32)
+		var ca int64
+		var spb, upb, dpb []byte
+		if err := rows.Scan(&network, &pk, &ca, &spb, &upb, &dpb); err != nil {
+			return utils.AddContext(err, "couldn't decode price change")
+		}
+		pc := priceChange{Timestamp: time.Unix(ca, 0)}
+		for _, field := range []struct {
+			c *types.Currency
+			b []byte
+		}{
+			{&pc.StoragePrice, spb},
+			{&pc.UploadPrice, upb},
+			{&pc.DownloadPrice, dpb},
+		} {
+			d := types.NewBufDecoder(field.b)
+			if (*types.V1Currency)(field.c).DecodeFrom(d); d.Err() != nil {
+				return utils.AddContext(d.Err(), "couldn't decode price")
+			}
+		}
+		if network == lastNetwork && types.PublicKey(pk) == lastKey && priceIncrease(last, pc) >= priceSpikeThreshold {
+			if host, exists := hosts[network][lastKey]; exists {
+				host.priceSpikeAt = pc.Timestamp
+			}
+		}
+		last, lastNetwork, lastKey = pc, network, types.PublicKey(pk)
+	}
+
+	return utils.AddContext(rows.Err(), "couldn't load price changes")
+}
+
+// saveTags writes the tags that have changed since the last save to the
+// database.
+func (api *portalAPI) saveTags() error {
+	type hostTags struct {
+		network string
+		pk      types.PublicKey
+		tags    string
+	}
+	var changed []hostTags
+	for network, hosts := range api.hosts.load() {
+		for pk, host := range hosts {
+			if tags := strings.Join(host.Tags, ";"); tags != host.savedTags {
+				changed = append(changed, hostTags{network, pk, tags})
+			}
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	stmt, err := api.db.Prepare(`
+		UPDATE hosts
+		SET tags = ?
+		WHERE network = ?
+		AND public_key = ?
+	`)
+	if err != nil {
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	var errs []error
+	saved := changed[:0]
+	for _, ht := range changed {
+		if _, err := stmt.Exec(ht.tags, ht.network, ht.pk[:]); err != nil {
+			api.log.Debug("couldn't save tags", zap.String("network", ht.network), zap.Stringer("host", ht.pk), zap.Error(err))
+			errs = append(errs, err)
+			continue
+		}
+		saved = append(saved, ht)
+	}
+
+	api.hosts.update(func(w *hostWriter) {
+		for _, ht := range saved {
+			if host, exists := w.edit(ht.network, ht.pk); exists {
+				host.savedTags = ht.tags
+			}
+		}
+	})
+
+	if len(errs) > 0 {
+		return utils.AddContext(errors.Join(errs...), fmt.Sprintf("couldn't save the tags of %d hosts", len(errs)))
+	}
+	return nil
+}
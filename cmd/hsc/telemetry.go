@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// communityWindow is the period the community reports are aggregated
+	// over.
+	communityWindow = 30 * 24 * time.Hour
+
+	// communityInterval determines how often the community scores are
+	// recalculated.
+	communityInterval = 10 * time.Minute
+
+	// minCommunityContributors is the number of distinct contributors
+	// required before a community score is published, so that a single
+	// renter cannot make or break a host.
+	minCommunityContributors = 3
+
+	// maxTelemetryReports is the maximum number of hosts in a submission.
+	maxTelemetryReports = 1000
+
+	// maxTelemetryCount is the maximum value of a counter in a report.
+	maxTelemetryCount = 10000
+
+	// maxTelemetrySize is the maximum size of a submission in bytes.
+	maxTelemetrySize = 1 << 20
+)
+
+// communityScore aggregates the interaction outcomes reported by renters.
+// It is kept separate from the portal's own measurements and doesn't
+// affect the total score.
+type communityScore struct {
+	Contributors       int     `json:"contributors"`
+	FormationSuccesses int64   `json:"formationSuccesses"`
+	FormationFailures  int64   `json:"formationFailures"`
+	UploadSuccesses    int64   `json:"uploadSuccesses"`
+	UploadFailures     int64   `json:"uploadFailures"`
+	DownloadSuccesses  int64   `json:"downloadSuccesses"`
+	DownloadFailures   int64   `json:"downloadFailures"`
+	Score              float64 `json:"score"`
+}
+
+// telemetryReport contains the outcomes of a renter's interactions with
+// a host since the last submission.
+type telemetryReport struct {
+	PublicKey          types.PublicKey `json:"publicKey"`
+	FormationSuccesses uint32          `json:"formationSuccesses"`
+	FormationFailures  uint32          `json:"formationFailures"`
+	UploadSuccesses    uint32          `json:"uploadSuccesses"`
+	UploadFailures     uint32          `json:"uploadFailures"`
+	DownloadSuccesses  uint32          `json:"downloadSuccesses"`
+	DownloadFailures   uint32          `json:"downloadFailures"`
+}
+
+type telemetryRequest struct {
+	Network string            `json:"network"`
+	Reports []telemetryReport `json:"reports"`
+}
+
+type telemetryResponse struct {
+	Accepted int `json:"accepted"`
+	Ignored  int `json:"ignored"`
+}
+
+type telemetryConfig struct {
+	Tokens []string `json:"tokens"`
+}
+
+// loadContributors loads the tokens of the renters allowed to submit
+// telemetry. The tokens are mapped to anonymous contributor IDs, so that
+// the reports cannot be linked to the renters.
+func loadContributors(dir string) (map[string]string, error) {
+	contributors := make(map[string]string)
+	js, err := os.ReadFile(filepath.Join(dir, "telemetry.json"))
+	if os.IsNotExist(err) {
+		return contributors, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var tc telemetryConfig
+	if err := json.Unmarshal(js, &tc); err != nil {
+		return nil, utils.AddContext(err, "couldn't decode telemetry config")
+	}
+	for _, token := range tc.Tokens {
+		h := types.HashBytes([]byte(token))
+		contributors[token] = hex.EncodeToString(h[:8])
+	}
+	return contributors, nil
+}
+
+func (r telemetryReport) valid() bool {
+	for _, c := range []uint32{
+		r.FormationSuccesses,
+		r.FormationFailures,
+		r.UploadSuccesses,
+		r.UploadFailures,
+		r.DownloadSuccesses,
+		r.DownloadFailures,
+	} {
+		if c > maxTelemetryCount {
+			return false
+		}
+	}
+	return true
+}
+
+// saveReports adds the reports to the contributor's daily totals. The
+// reports about unknown hosts are ignored.
+func (api *portalAPI) saveReports(network, contributor string, reports []telemetryReport) (accepted int, err error) {
+	tx, err := api.db.Begin()
+	if err != nil {
+		return 0, utils.AddContext(err, "couldn't start transaction")
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO community_reports (
+			network,
+			public_key,
+			contributor,
+			day,
+			formation_successes,
+			formation_failures,
+			upload_successes,
+			upload_failures,
+			download_successes,
+			download_failures
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (network, public_key, contributor, day) UPDATE
+			formation_successes = community_reports.formation_successes + new.formation_successes,
+			formation_failures = community_reports.formation_failures + new.formation_failures,
+			upload_successes = community_reports.upload_successes + new.upload_successes,
+			upload_failures = community_reports.upload_failures + new.upload_failures,
+			download_successes = community_reports.download_successes + new.download_successes,
+			download_failures = community_reports.download_failures + new.download_failures
+	`)
+	if err != nil {
+		tx.Rollback()
+		return 0, utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	day := time.Now().Unix() / 86400
+	hosts := api.hosts.load()
+	for _, r := range reports {
+		_, known := hosts[network][r.PublicKey]
+		if !known || !r.valid() {
+			continue
+		}
+		_, err := stmt.Exec(
+			network,
+			r.PublicKey[:],
+			contributor,
+			day,
+			r.FormationSuccesses,
+			r.FormationFailures,
+			r.UploadSuccesses,
+			r.UploadFailures,
+			r.DownloadSuccesses,
+			r.DownloadFailures,
+		)
+		if err != nil {
+			tx.Rollback()
+			return 0, utils.AddContext(err, "couldn't save report")
+		}
+		accepted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	return accepted, nil
+}
+
+// calculateCommunityScores aggregates the recent community reports.
+func (api *portalAPI) calculateCommunityScores() error {
+	rows, err := api.db.Query(`
+		SELECT
+			network,
+			public_key,
+			COUNT(DISTINCT contributor),
+			SUM(formation_successes),
+			SUM(formation_failures),
+			SUM(upload_successes),
+			SUM(upload_failures),
+			SUM(download_successes),
+			SUM(download_failures)
+		FROM community_reports
+		WHERE day >= ?
+		GROUP BY network, public_key
+	`, time.Now().Add(-communityWindow).Unix()/86400)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query community reports")
+	}
+	defer rows.Close()
+
+	scores := map[string]map[types.PublicKey]*communityScore{
+		"mainnet": make(map[types.PublicKey]*communityScore),
+		"zen":     make(map[types.PublicKey]*communityScore),
+	}
+	for rows.Next() {
+		var network string
+		var cs communityScore
+		pk := make([]byte, 32)
+		if err := rows.Scan(
+			&network,
+			&pk,
+			&cs.Contributors,
+			&cs.FormationSuccesses,
+			&cs.FormationFailures,
+			&cs.UploadSuccesses,
+			&cs.UploadFailures,
+			&cs.DownloadSuccesses,
+			&cs.DownloadFailures,
+		); err != nil {
+			return utils.AddContext(err, "couldn't decode community report")
+		}
+		if _, ok := scores[network]; !ok || cs.Contributors < minCommunityContributors {
+			continue
+		}
+		successes := cs.FormationSuccesses + cs.UploadSuccesses + cs.DownloadSuccesses
+		failures := cs.FormationFailures + cs.UploadFailures + cs.DownloadFailures
+		cs.Score = interactionScore(float64(successes), float64(failures))
+		scores[network][types.PublicKey(pk)] = &cs
+	}
+	if err := rows.Err(); err != nil {
+		return utils.AddContext(err, "couldn't read community reports")
+	}
+
+	api.hosts.update(func(w *hostWriter) {
+		for network, hosts := range w.hosts {
+			for pk, host := range hosts {
+				if host.Community == nil && scores[network][pk] == nil {
+					continue
+				}
+				host, _ = w.edit(network, pk)
+				host.Community = scores[network][pk]
+			}
+		}
+	})
+
+	return nil
+}
+
+func (api *portalAPI) telemetryHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if api.mirrorURL != "" {
+		writeError(w, "telemetry is not accepted by a mirror", http.StatusForbidden)
+		return
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	contributor, known := api.contributors[token]
+	if !ok || !known {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var tr telemetryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxTelemetrySize)).Decode(&tr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	network := strings.ToLower(tr.Network)
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	if len(tr.Reports) > maxTelemetryReports {
+		writeError(w, "too many reports", http.StatusBadRequest)
+		return
+	}
+
+	accepted, err := api.saveReports(network, contributor, tr.Reports)
+	if err != nil {
+		api.log.Error("couldn't save telemetry", zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, telemetryResponse{
+		Accepted: accepted,
+		Ignored:  len(tr.Reports) - accepted,
+	})
+}
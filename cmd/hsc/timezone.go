@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// The time zones of the hosts must be known even if the system
+	// doesn't have the time zone database installed.
+	_ "time/tzdata"
+)
+
+// timeZoneHint helps the renters to plan around the likely maintenance
+// hours of a host, which usually fall on the local night.
+type timeZoneHint struct {
+	UTCOffset   int    `json:"utcOffset"`   // in minutes, on the current date
+	MidnightUTC string `json:"midnightUTC"` // local midnight as the UTC time of day
+}
+
+// newTimeZoneHint derives the hint from the IANA time zone of the host
+// at the given time. It returns nil if the time zone is unknown.
+func newTimeZoneHint(tz string, now time.Time) *timeZoneHint {
+	if tz == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil
+	}
+	_, offset := now.In(loc).Zone()
+	minutes := offset / 60
+	midnight := ((-minutes)%(24*60) + 24*60) % (24 * 60)
+	return &timeZoneHint{
+		UTCOffset:   minutes,
+		MidnightUTC: fmt.Sprintf("%02d:%02d", midnight/60, midnight%60),
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mike76-dev/hostscore/hostdb"
+)
+
+// benchmarkTraffic is the amount of data exchanged with the host during
+// the benchmarks, from the host's point of view. It is approximate, since
+// only the sector data is counted.
+type benchmarkTraffic struct {
+	Ingress uint64    `json:"ingress"` // bytes received by the host
+	Egress  uint64    `json:"egress"`  // bytes sent by the host
+	Since   time.Time `json:"since"`
+}
+
+// add counts the data transferred during the benchmarks.
+func (bt *benchmarkTraffic) add(benchmarks []hostdb.HostBenchmark) {
+	for _, benchmark := range benchmarks {
+		bt.Ingress += benchmark.Uploaded
+		bt.Egress += benchmark.Downloaded
+		if bt.Since.IsZero() || benchmark.Timestamp.Before(bt.Since) {
+			bt.Since = benchmark.Timestamp
+		}
+	}
+}
+
+// merge adds the traffic measured by another node.
+func (bt *benchmarkTraffic) merge(other benchmarkTraffic) {
+	bt.Ingress += other.Ingress
+	bt.Egress += other.Egress
+	if !other.Since.IsZero() && (bt.Since.IsZero() || other.Since.Before(bt.Since)) {
+		bt.Since = other.Since
+	}
+}
+
+// sinceUnix returns the start of the measurements as stored in the
+// database, where zero means that there have been none yet.
+func (bt benchmarkTraffic) sinceUnix() int64 {
+	if bt.Since.IsZero() {
+		return 0
+	}
+	return bt.Since.Unix()
+}
+
+// totalTraffic sums up the traffic measured by all nodes.
+func totalTraffic(interactions map[string]nodeInteractions) (total benchmarkTraffic) {
+	for _, ni := range interactions {
+		total.merge(ni.Traffic)
+	}
+	return
+}
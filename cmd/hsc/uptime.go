@@ -0,0 +1,337 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// uptimeAggregateInterval determines how often the portal checks if
+	// the scans of a new day can be aggregated.
+	uptimeAggregateInterval = time.Hour
+
+	// uptimeAggregateDelay is how long after the end of a day its scans
+	// are aggregated, so that the late updates from the nodes are
+	// included.
+	uptimeAggregateDelay = time.Hour
+
+	// uptimeSlot is the time slot the scans are grouped in. It matches
+	// the scan interval of the nodes, so that a slot has a scan from
+	// each node.
+	uptimeSlot = 30 * time.Minute
+
+	// uptimeSlotsPerDay is the number of slots in a day.
+	uptimeSlotsPerDay = int(24 * time.Hour / uptimeSlot)
+
+	// defaultUptimePeriod and maxUptimePeriod are the default and the
+	// longest window of an uptime report in days.
+	defaultUptimePeriod = 30
+	maxUptimePeriod     = 365
+)
+
+// uptimeDay is the uptime of a host on a given day. A slot is up if any
+// node scanned the host successfully in it, and down if all scans
+// failed; the slots without scans are skipped. An incident is a run of
+// consecutive down slots. The leading and the trailing outages are the
+// runs touching the beginning and the end of the day, so that the
+// incidents spanning midnight can be joined.
+type uptimeDay struct {
+	up        int
+	down      int
+	incidents int
+	longest   int
+	leading   int
+	trailing  int
+}
+
+// summarizeUptimeDay calculates the uptime of a day from the states of
+// the slots.
+func summarizeUptimeDay(slots map[int]bool) (ud uptimeDay) {
+	var run int
+	leading := true
+	for slot := 0; slot < uptimeSlotsPerDay; slot++ {
+		up, ok := slots[slot]
+		if !ok {
+			continue
+		}
+		if up {
+			ud.up++
+			run = 0
+			leading = false
+			continue
+		}
+		ud.down++
+		if run == 0 {
+			ud.incidents++
+		}
+		run++
+		if leading {
+			ud.leading = run
+		}
+		ud.longest = max(ud.longest, run)
+	}
+	ud.trailing = run
+	return
+}
+
+// uptimeReport is the uptime of a host over a window of whole days.
+type uptimeReport struct {
+	Network       string          `json:"network"`
+	PublicKey     types.PublicKey `json:"publicKey"`
+	Period        string          `json:"period"`
+	From          time.Time       `json:"from"`
+	To            time.Time       `json:"to"`
+	Days          int             `json:"days"`
+	Uptime        float64         `json:"uptime"`
+	Incidents     int             `json:"incidents"`
+	LongestOutage time.Duration   `json:"longestOutage"`
+}
+
+// aggregateUptime aggregates the scans of each complete day that hasn't
+// been aggregated yet. The raw scans are pruned after two weeks, so the
+// daily aggregates are what the longer windows are calculated from.
+func (api *portalAPI) aggregateUptime() error {
+	var last int64
+	if err := api.db.QueryRow("SELECT COALESCE(MAX(day), 0) FROM uptime_daily").Scan(&last); err != nil {
+		return utils.AddContext(err, "couldn't get last uptime aggregate")
+	}
+	if last == 0 {
+		var first int64
+		if err := api.db.QueryRow("SELECT COALESCE(MIN(ran_at), 0) FROM scans").Scan(&first); err != nil {
+			return utils.AddContext(err, "couldn't get first scan")
+		}
+		if first == 0 {
+			return nil
+		}
+		last = first/86400 - 1
+	}
+
+	today := time.Now().Add(-uptimeAggregateDelay).Unix() / 86400
+	for day := last + 1; day < today; day++ {
+		if err := api.aggregateUptimeDay(day); err != nil {
+			return utils.AddContext(err, "couldn't aggregate uptime")
+		}
+	}
+	return nil
+}
+
+// aggregateUptimeDay saves the uptime of all hosts scanned on the given
+// day.
+func (api *portalAPI) aggregateUptimeDay(day int64) error {
+	type hostKey struct {
+		network string
+		pk      types.PublicKey
+	}
+
+	start := day * 86400
+	rows, err := api.db.Query(`
+		SELECT network, public_key, ran_at, success
+		FROM scans
+		WHERE ran_at >= ?
+		AND ran_at < ?
+	`, start, start+86400)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query scans")
+	}
+
+	hosts := make(map[hostKey]map[int]bool)
+	for rows.Next() {
+		var network string
+		pk := make([]byte, 32)
+		var ranAt int64
+		var success bool
+		if err := rows.Scan(&network, &pk, &ranAt, &success); err != nil {
+			rows.Close()
+			return utils.AddContext(err, "couldn't decode scan")
+		}
+		key := hostKey{network, types.PublicKey(pk)}
+		slots, ok := hosts[key]
+		if !ok {
+			slots = make(map[int]bool)
+			hosts[key] = slots
+		}
+		slot := int((ranAt - start) / int64(uptimeSlot.Seconds()))
+		slots[slot] = slots[slot] || success
+	}
+	rows.Close()
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO uptime_daily (
+			network,
+			public_key,
+			day,
+			up_slots,
+			down_slots,
+			incidents,
+			longest_outage,
+			leading_outage,
+			trailing_outage
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	for key, slots := range hosts {
+		ud := summarizeUptimeDay(slots)
+		_, err := stmt.Exec(
+			key.network,
+			key.pk[:],
+			day,
+			ud.up,
+			ud.down,
+			ud.incidents,
+			ud.longest,
+			ud.leading,
+			ud.trailing,
+		)
+		if err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't save uptime")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getUptimeReport calculates the uptime of the host over the given
+// number of the last complete days. The outages continuing from one day
+// to the next are counted as one incident.
+func (api *portalAPI) getUptimeReport(network string, pk types.PublicKey, days int) (uptimeReport, error) {
+	today := time.Now().Unix() / 86400
+	report := uptimeReport{
+		Network:   network,
+		PublicKey: pk,
+		Period:    strconv.Itoa(days) + "d",
+		From:      time.Unix((today-int64(days))*86400, 0).UTC(),
+		To:        time.Unix(today*86400, 0).UTC(),
+	}
+
+	rows, err := api.db.Query(`
+		SELECT
+			up_slots,
+			down_slots,
+			incidents,
+			longest_outage,
+			leading_outage,
+			trailing_outage
+		FROM uptime_daily
+		WHERE network = ?
+		AND public_key = ?
+		AND day >= ?
+		AND day < ?
+		ORDER BY day ASC
+	`, network, pk[:], today-int64(days), today)
+	if err != nil {
+		return uptimeReport{}, utils.AddContext(err, "couldn't query uptime")
+	}
+	defer rows.Close()
+
+	var up, down, longest, carry int
+	for rows.Next() {
+		var ud uptimeDay
+		if err := rows.Scan(&ud.up, &ud.down, &ud.incidents, &ud.longest, &ud.leading, &ud.trailing); err != nil {
+			return uptimeReport{}, utils.AddContext(err, "couldn't decode uptime")
+		}
+		if ud.up+ud.down == 0 {
+			continue
+		}
+		report.Days++
+		up += ud.up
+		down += ud.down
+		report.Incidents += ud.incidents
+		longest = max(longest, ud.longest)
+		if carry > 0 && ud.leading > 0 {
+			// The outage started the day before.
+			report.Incidents--
+			longest = max(longest, carry+ud.leading)
+		}
+		if ud.up == 0 {
+			carry += ud.down
+		} else {
+			carry = ud.trailing
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return uptimeReport{}, utils.AddContext(err, "couldn't retrieve uptime")
+	}
+
+	if up+down > 0 {
+		report.Uptime = float64(up) / float64(up+down)
+	}
+	report.LongestOutage = time.Duration(longest) * uptimeSlot
+
+	return report, nil
+}
+
+// parseUptimePeriod parses a period like "30d".
+func parseUptimePeriod(period string) (int, bool) {
+	if period == "" {
+		return defaultUptimePeriod, true
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+	if err != nil || !strings.HasSuffix(period, "d") || days < 1 || days > maxUptimePeriod {
+		return 0, false
+	}
+	return days, true
+}
+
+func (api *portalAPI) hostsUptimeHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	h := req.FormValue("host")
+	if h == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if err := pk.UnmarshalText([]byte(h)); err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	days, ok := parseUptimePeriod(strings.ToLower(req.FormValue("period")))
+	if !ok {
+		writeError(w, "invalid period", http.StatusBadRequest)
+		return
+	}
+	if _, ok := api.hosts.load()[network][pk]; !ok {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	report, err := api.getUptimeReport(network, pk, days)
+	if err != nil {
+		api.log.Error("couldn't get uptime report", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, report)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxWatchlistSize is the maximum number of hosts on a watchlist.
+	maxWatchlistSize = 100
+
+	// maxWatchlistRequestSize is the maximum size of a watchlist request
+	// in bytes.
+	maxWatchlistRequestSize = 4 << 10
+)
+
+var (
+	errWatchlistFull    = errors.New("watchlist is full")
+	errNotOnWatchlist   = errors.New("host not on watchlist")
+	errAPIKeyNotPresent = errors.New("API key required")
+)
+
+// watchlistEntry is a host on the watchlist of an API key, along with its
+// state at the time of the last fetch.
+type watchlistEntry struct {
+	network     string
+	publicKey   types.PublicKey
+	lastFetched time.Time
+	lastScore   float64
+	lastOnline  bool
+}
+
+type watchlistRequest struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+}
+
+// watchlistEvent is something that happened to a watched host since the
+// last fetch.
+type watchlistEvent struct {
+	Type      string       `json:"type"`
+	Timestamp time.Time    `json:"timestamp"`
+	Node      string       `json:"node,omitempty"`
+	Message   string       `json:"message"`
+	Prices    *priceChange `json:"prices,omitempty"`
+}
+
+// watchlistHost is the current status of a watched host.
+type watchlistHost struct {
+	Network    string           `json:"network"`
+	PublicKey  types.PublicKey  `json:"publicKey"`
+	NetAddress string           `json:"netaddress"`
+	Online     bool             `json:"online"`
+	Rank       int              `json:"rank"`
+	Score      float64          `json:"score"`
+	ScoreDelta float64          `json:"scoreDelta"`
+	Since      time.Time        `json:"since"`
+	Events     []watchlistEvent `json:"events"`
+}
+
+// watchlistKey returns the API key of the request. The watchlists are
+// kept per API key, so the anonymous clients can't have one.
+func (api *portalAPI) watchlistKey(w http.ResponseWriter, req *http.Request) (apiKey, bool) {
+	key := req.Header.Get(apiKeyHeader)
+	if key == "" {
+		writeError(w, errAPIKeyNotPresent.Error(), http.StatusUnauthorized)
+		return apiKey{}, false
+	}
+	k, _, ok := api.keys.lookup(key)
+	if !ok {
+		writeError(w, errInvalidAPIKey.Error(), http.StatusUnauthorized)
+		return apiKey{}, false
+	}
+	return k, true
+}
+
+// getWatchlist loads the watchlist of the API key.
+func (api *portalAPI) getWatchlist(keyID int64) ([]watchlistEntry, error) {
+	rows, err := api.db.Query(`
+		SELECT network, public_key, last_fetched, last_score, last_online
+		FROM watchlist
+		WHERE key_id = ?
+		ORDER BY added_at ASC
+	`, keyID)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query watchlist")
+	}
+	defer rows.Close()
+
+	var entries []watchlistEntry
+	for rows.Next() {
+		var we watchlistEntry
+		pk := make([]byte, 32)
+		var lf int64
+		if err := rows.Scan(&we.network, &pk, &lf, &we.lastScore, &we.lastOnline); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode watchlist entry")
+		}
+		copy(we.publicKey[:], pk)
+		we.lastFetched = time.Unix(lf, 0)
+		entries = append(entries, we)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load watchlist")
+	}
+
+	return entries, nil
+}
+
+// addToWatchlist puts the host on the watchlist of the API key. The
+// current state of the host is the baseline of the first fetch.
+func (api *portalAPI) addToWatchlist(keyID int64, network string, host *portalHost) error {
+	var count, exists int
+	err := api.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN network = ? AND public_key = ? THEN 1 ELSE 0 END), 0)
+		FROM watchlist
+		WHERE key_id = ?
+	`, network, host.PublicKey[:], keyID).Scan(&count, &exists)
+	if err != nil {
+		return utils.AddContext(err, "couldn't count watchlist entries")
+	}
+	if exists > 0 {
+		return nil
+	}
+	if count >= maxWatchlistSize {
+		return errWatchlistFull
+	}
+
+	now := time.Now().Unix()
+	_, err = api.db.Exec(`
+		INSERT INTO watchlist (
+			key_id,
+			network,
+			public_key,
+			added_at,
+			last_fetched,
+			last_score,
+			last_online
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, keyID, network, host.PublicKey[:], now, now, host.Score.TotalScore, isOnline(*host))
+	if err != nil {
+		return utils.AddContext(err, "couldn't save watchlist entry")
+	}
+
+	return nil
+}
+
+// removeFromWatchlist deletes the host from the watchlist of the API key.
+func (api *portalAPI) removeFromWatchlist(keyID int64, network string, pk types.PublicKey) error {
+	res, err := api.db.Exec(`
+		DELETE FROM watchlist
+		WHERE key_id = ?
+		AND network = ?
+		AND public_key = ?
+	`, keyID, network, pk[:])
+	if err != nil {
+		return utils.AddContext(err, "couldn't delete watchlist entry")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errNotOnWatchlist
+	}
+	return nil
+}
+
+// priceChangesSinceQuery retrieves the price changes of a host after a
+// point of time, the oldest first.
+const priceChangesSinceQuery = `
+	SELECT
+		changed_at,
+		remaining_storage,
+		total_storage,
+		collateral,
+		storage_price,
+		upload_price,
+		download_price
+	FROM price_changes
+	WHERE network = ?
+	AND public_key = ?
+	AND changed_at > ?
+	ORDER BY changed_at ASC
+`
+
+// priceChangesSince returns the price changes of the host after the given
+// time, the oldest first.
+func (api *portalAPI) priceChangesSince(network string, pk types.PublicKey, since time.Time) ([]priceChange, error) {
+	rows, err := api.db.Query(priceChangesSinceQuery, network, pk[:], since.Unix())
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query price changes")
+	}
+	defer rows.Close()
+
+	var pcs []priceChange
+	for rows.Next() {
+		var ca int64
+		var rs, ts uint64
+		var cb, spb, upb, dpb []byte
+		if err := rows.Scan(&ca, &rs, &ts, &cb, &spb, &upb, &dpb); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode price change")
+		}
+		pc := priceChange{
+			Timestamp:        time.Unix(ca, 0),
+			RemainingStorage: rs,
+			TotalStorage:     ts,
+		}
+		for _, field := range []struct {
+			c *types.Currency
+			b []byte
+		}{
+			{&pc.Collateral, cb},
+			{&pc.StoragePrice, spb},
+			{&pc.UploadPrice, upb},
+			{&pc.DownloadPrice, dpb},
+		} {
+			d := types.NewBufDecoder(field.b)
+			if (*types.V1Currency)(field.c).DecodeFrom(d); d.Err() != nil {
+				return nil, utils.AddContext(d.Err(), "couldn't decode price")
+			}
+		}
+		pcs = append(pcs, pc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load price changes")
+	}
+
+	return pcs, nil
+}
+
+// watchlistEvents collects what has happened to the host since the last
+// fetch.
+func (api *portalAPI) watchlistEvents(we watchlistEntry, host *portalHost, online bool) ([]watchlistEvent, error) {
+	now := time.Now()
+	var events []watchlistEvent
+	if online != we.lastOnline {
+		e := watchlistEvent{
+			Type:      alertOnline,
+			Timestamp: now,
+			Message:   "host is back online",
+		}
+		if !online {
+			e.Type = alertOffline
+			e.Message = "host went offline"
+		}
+		events = append(events, e)
+	}
+
+	if delta := host.Score.TotalScore - we.lastScore; delta != 0 {
+		events = append(events, watchlistEvent{
+			Type:      alertScore,
+			Timestamp: now,
+			Message:   "score changed",
+		})
+	}
+
+	for node, interactions := range host.Interactions {
+		for _, benchmark := range interactions.BenchmarkHistory {
+			if !benchmark.Timestamp.After(we.lastFetched) {
+				continue
+			}
+			if benchmark.Success || !benchmark.Failure.HostFault() {
+				continue
+			}
+			events = append(events, watchlistEvent{
+				Type:      alertBench,
+				Timestamp: benchmark.Timestamp,
+				Node:      node,
+				Message:   "benchmark failed: " + benchmark.Error,
+			})
+		}
+	}
+
+	pcs, err := api.priceChangesSince(we.network, we.publicKey, we.lastFetched)
+	if err != nil {
+		return nil, err
+	}
+	for _, pc := range pcs {
+		events = append(events, watchlistEvent{
+			Type:      alertPrices,
+			Timestamp: pc.Timestamp,
+			Message:   "settings changed",
+			Prices:    &pc,
+		})
+	}
+
+	slices.SortStableFunc(events, func(a, b watchlistEvent) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	return events, nil
+}
+
+// saveWatchlistState remembers the state of the watched hosts, so that the
+// next fetch only returns what has changed since.
+func (api *portalAPI) saveWatchlistState(keyID int64, hosts []watchlistHost, fetched time.Time) error {
+	tx, err := api.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+
+	for _, wh := range hosts {
+		_, err := tx.Exec(`
+			UPDATE watchlist
+			SET last_fetched = ?, last_score = ?, last_online = ?
+			WHERE key_id = ?
+			AND network = ?
+			AND public_key = ?
+		`, fetched.Unix(), wh.Score, wh.Online, keyID, wh.Network, wh.PublicKey[:])
+		if err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't update watchlist entry")
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (api *portalAPI) watchlistHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	k, ok := api.watchlistKey(w, req)
+	if !ok {
+		return
+	}
+
+	entries, err := api.getWatchlist(k.ID)
+	if err != nil {
+		api.log.Error("couldn't get watchlist", zap.Int64("key", k.ID), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	fetched := time.Now()
+	hosts := make([]watchlistHost, 0, len(entries))
+	live := api.hosts.load()
+	for _, we := range entries {
+		// The hosts that have been delisted in the meantime are
+		// skipped but stay on the watchlist.
+		host, exists := live[we.network][we.publicKey]
+		if !exists || host.OptOut == hostdb.OptOutDelist {
+			continue
+		}
+		online := isOnline(*host)
+		events, err := api.watchlistEvents(we, host, online)
+		if err != nil {
+			api.log.Error("couldn't get watchlist events", zap.String("network", we.network), zap.Stringer("host", we.publicKey), zap.Error(err))
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		hosts = append(hosts, watchlistHost{
+			Network:    we.network,
+			PublicKey:  we.publicKey,
+			NetAddress: host.NetAddress,
+			Online:     online,
+			Rank:       host.Rank,
+			Score:      host.Score.TotalScore,
+			ScoreDelta: host.Score.TotalScore - we.lastScore,
+			Since:      we.lastFetched,
+			Events:     events,
+		})
+	}
+
+	if err := api.saveWatchlistState(k.ID, hosts, fetched); err != nil {
+		api.log.Error("couldn't save watchlist state", zap.Int64("key", k.ID), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, hosts)
+}
+
+func (api *portalAPI) watchlistAddHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	k, ok := api.watchlistKey(w, req)
+	if !ok {
+		return
+	}
+
+	var wr watchlistRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxWatchlistRequestSize)).Decode(&wr); err != nil {
+		writeError(w, "couldn't decode request", http.StatusBadRequest)
+		return
+	}
+	wr.Network = strings.ToLower(wr.Network)
+	if wr.Network == "" {
+		wr.Network = "mainnet"
+	}
+	if wr.Network != "mainnet" && wr.Network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	host, exists := api.hosts.load()[wr.Network][wr.PublicKey]
+	if !exists || host.OptOut == hostdb.OptOutDelist {
+		writeError(w, "host not found", http.StatusBadRequest)
+		return
+	}
+
+	err := api.addToWatchlist(k.ID, wr.Network, host)
+	if errors.Is(err, errWatchlistFull) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't add host to watchlist", zap.Int64("key", k.ID), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *portalAPI) watchlistRemoveHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if api.limitExceeded(req) {
+		writeError(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	k, ok := api.watchlistKey(w, req)
+	if !ok {
+		return
+	}
+
+	network := strings.ToLower(req.FormValue("network"))
+	if network == "" {
+		network = "mainnet"
+	}
+	if network != "mainnet" && network != "zen" {
+		writeError(w, "wrong network", http.StatusBadRequest)
+		return
+	}
+	h := req.FormValue("host")
+	if h == "" {
+		writeError(w, "host not provided", http.StatusBadRequest)
+		return
+	}
+	var pk types.PublicKey
+	if err := pk.UnmarshalText([]byte(h)); err != nil {
+		writeError(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+
+	err := api.removeFromWatchlist(k.ID, network, pk)
+	if errors.Is(err, errNotOnWatchlist) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.log.Error("couldn't remove host from watchlist", zap.Int64("key", k.ID), zap.Error(err))
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
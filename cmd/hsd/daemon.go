@@ -1,16 +1,22 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/mike76-dev/hostscore/internal/build"
 	"github.com/mike76-dev/hostscore/persist"
 )
 
+// shutdownTimeout is how long hsd waits for the components to stop.
+const shutdownTimeout = 30 * time.Second
+
 // startDaemon starts the hsd server.
 func startDaemon(config *persist.HSDConfig, apiPassword, dbPassword, seed, seedZen string) error {
 	fmt.Printf("hsd v%v\n", build.NodeVersion)
@@ -35,11 +41,33 @@ func startDaemon(config *persist.HSDConfig, apiPassword, dbPassword, seed, seedZ
 	stop := n.Start()
 	log.Println("api: Listening on", l.Addr())
 	go startWeb(l, n, apiPassword)
+	if config.MetricsAddr != "" {
+		lm, err := net.Listen("tcp", config.MetricsAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("metrics: Listening on", lm.Addr())
+		go startMetrics(lm, n)
+	}
 	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
 	<-signalCh
 	log.Println("Shutting down...")
-	stop()
+
+	// Don't let a hanging component block the shutdown, since the
+	// container runtimes kill the process after a grace period anyway.
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-signalCh:
+		return errors.New("shutdown interrupted")
+	case <-time.After(shutdownTimeout):
+		return errors.New("shutdown timed out")
+	}
 
 	return nil
 }
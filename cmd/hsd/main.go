@@ -7,10 +7,11 @@ import (
 	"strings"
 
 	"github.com/mike76-dev/hostscore/internal/build"
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
 	"github.com/mike76-dev/hostscore/persist"
 	"github.com/mike76-dev/hostscore/wallet"
 	"go.sia.tech/core/types"
-	"golang.org/x/term"
 	"lukechampine.com/flagg"
 )
 
@@ -28,65 +29,33 @@ var config persist.HSDConfig
 var configDir string
 
 func getAPIPassword() string {
-	apiPassword := os.Getenv("HSD_API_PASSWORD")
-	if apiPassword != "" {
-		log.Println("Using HSD_API_PASSWORD environment variable.")
-	} else {
-		fmt.Print("Enter API password: ")
-		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Println()
-		if err != nil {
-			log.Fatalf("Could not read API password: %v\n", err)
-		}
-		apiPassword = string(pw)
+	apiPassword, err := utils.ReadSecret("HSD_API_PASSWORD", "Enter API password: ")
+	if err != nil {
+		log.Fatalf("Could not read API password: %v\n", err)
 	}
 	return apiPassword
 }
 
 func getDBPassword() string {
-	dbPassword := os.Getenv("HSD_DB_PASSWORD")
-	if dbPassword != "" {
-		log.Println("Using HSD_DB_PASSWORD environment variable.")
-	} else {
-		fmt.Print("Enter database password: ")
-		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Println()
-		if err != nil {
-			log.Fatalf("Could not read database password: %v\n", err)
-		}
-		dbPassword = string(pw)
+	dbPassword, err := utils.ReadSecret("HSD_DB_PASSWORD", "Enter database password: ")
+	if err != nil {
+		log.Fatalf("Could not read database password: %v\n", err)
 	}
 	return dbPassword
 }
 
 func getWalletSeed() string {
-	seed := os.Getenv("HSD_WALLET_SEED")
-	if seed != "" {
-		log.Println("Using HSD_WALLET_SEED environment variable.")
-	} else {
-		fmt.Print("Enter Mainnet wallet seed: ")
-		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Println()
-		if err != nil {
-			log.Fatalf("Could not read wallet seed: %v\n", err)
-		}
-		seed = string(pw)
+	seed, err := utils.ReadSecret("HSD_WALLET_SEED", "Enter Mainnet wallet seed: ")
+	if err != nil {
+		log.Fatalf("Could not read wallet seed: %v\n", err)
 	}
 	return seed
 }
 
 func getWalletSeedZen() string {
-	seed := os.Getenv("HSD_WALLET_SEED_ZEN")
-	if seed != "" {
-		log.Println("Using HSD_WALLET_SEED_ZEN environment variable.")
-	} else {
-		fmt.Print("Enter Zen wallet seed: ")
-		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Println()
-		if err != nil {
-			log.Fatalf("Could not read wallet seed: %v\n", err)
-		}
-		seed = string(pw)
+	seed, err := utils.ReadSecret("HSD_WALLET_SEED_ZEN", "Enter Zen wallet seed: ")
+	if err != nil {
+		log.Fatalf("Could not read wallet seed: %v\n", err)
 	}
 	return seed
 }
@@ -132,7 +101,10 @@ func main() {
 	var gatewayMainnet,
 		gatewayZen,
 		apiAddr,
+		metricsAddr,
 		dir,
+		dbType,
+		dbAddr,
 		dbUser,
 		dbName string
 
@@ -141,7 +113,10 @@ func main() {
 	rootCmd.StringVar(&gatewayMainnet, "addr-mainnet", "", "Mainnet p2p address to listen on")
 	rootCmd.StringVar(&gatewayZen, "addr-zen", "", "Zen p2p address to listen on")
 	rootCmd.StringVar(&apiAddr, "api-addr", "", "address to serve API on")
+	rootCmd.StringVar(&metricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on")
 	rootCmd.StringVar(&dir, "dir", "", "directory to store node state in")
+	rootCmd.StringVar(&dbType, "db-type", "", "type of the database: mysql, postgres, or sqlite")
+	rootCmd.StringVar(&dbAddr, "db-addr", "", "address of the database server; defaults to the local one")
 	rootCmd.StringVar(&dbUser, "db-user", "", "username for accessing the database")
 	rootCmd.StringVar(&dbName, "db-name", "", "name of MYSQL database")
 	versionCmd := flagg.New("version", versionUsage)
@@ -172,9 +147,21 @@ func main() {
 		if apiAddr != "" {
 			config.APIAddr = apiAddr
 		}
+		if metricsAddr != "" {
+			config.MetricsAddr = metricsAddr
+		}
+		if dir == "" {
+			dir = os.Getenv("HSD_DATA_DIR")
+		}
 		if dir != "" {
 			config.Dir = dir
 		}
+		if dbType != "" {
+			config.DBType = dbType
+		}
+		if dbAddr != "" {
+			config.DBAddr = dbAddr
+		}
 		if dbUser != "" {
 			config.DBUser = dbUser
 		}
@@ -191,8 +178,11 @@ func main() {
 		// Fetch API password.
 		apiPassword := getAPIPassword()
 
-		// Fetch DB password.
-		dbPassword := getDBPassword()
+		// Fetch DB password. SQLite needs none.
+		var dbPassword string
+		if config.DBType != sqldb.SQLite {
+			dbPassword = getDBPassword()
+		}
 
 		// Fetch wallet seeds.
 		seed := getWalletSeed()
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/mike76-dev/hostscore/internal/metrics"
+	"go.sia.tech/core/types"
+)
+
+// balance returns the mature balance of the wallet in Siacoins.
+func (n *node) balance(network string) float64 {
+	scos, _, err := n.w.UnspentOutputs(network)
+	if err != nil {
+		return 0
+	}
+	height := n.cm.TipState().Index.Height
+	if network == "zen" {
+		height = n.cmZen.TipState().Index.Height
+	}
+	var sc types.Currency
+	for _, sco := range scos {
+		if height >= sco.MaturityHeight {
+			sc = sc.Add(sco.SiacoinOutput.Value)
+		}
+	}
+	return sc.Siacoins()
+}
+
+func startMetrics(l net.Listener, n *node) error {
+	r := metrics.NewRegistry()
+	r.Register("hsd_hosts", "Number of known hosts.", metrics.Gauge, func() []metrics.Sample {
+		stats := n.hdb.Stats()
+		return metrics.PerNetwork(float64(stats.Hosts["mainnet"]), float64(stats.Hosts["zen"]))
+	})
+	r.Register("hsd_scan_queue_depth", "Number of hosts waiting to be scanned.", metrics.Gauge, func() []metrics.Sample {
+		stats := n.hdb.Stats()
+		return metrics.PerNetwork(float64(stats.ScanQueue["mainnet"]), float64(stats.ScanQueue["zen"]))
+	})
+	r.Register("hsd_benchmark_queue_depth", "Number of hosts waiting to be benchmarked.", metrics.Gauge, func() []metrics.Sample {
+		stats := n.hdb.Stats()
+		return metrics.PerNetwork(float64(stats.BenchmarkQueue["mainnet"]), float64(stats.BenchmarkQueue["zen"]))
+	})
+	r.GaugeFunc("hsd_scan_threads", "Number of running scan threads.", func() float64 {
+		return float64(n.hdb.Stats().ScanThreads)
+	})
+	r.GaugeFunc("hsd_benchmark_threads", "Number of running benchmark threads.", func() float64 {
+		return float64(n.hdb.Stats().BenchmarkThreads)
+	})
+	r.CounterFunc("hsd_scans_total", "Number of completed scans.", func() float64 {
+		return float64(n.hdb.Stats().ScansDone)
+	})
+	r.CounterFunc("hsd_benchmarks_total", "Number of completed benchmarks.", func() float64 {
+		return float64(n.hdb.Stats().BenchmarksDone)
+	})
+	r.Register("hsd_wallet_balance_siacoins", "Confirmed wallet balance.", metrics.Gauge, func() []metrics.Sample {
+		return metrics.PerNetwork(n.balance("mainnet"), n.balance("zen"))
+	})
+	r.Register("hsd_block_height", "Height of the chain tip.", metrics.Gauge, func() []metrics.Sample {
+		return metrics.PerNetwork(float64(n.cm.TipState().Index.Height), float64(n.cmZen.TipState().Index.Height))
+	})
+	r.GaugeFunc("hsd_db_ping_seconds", "Time it takes to ping the database.", func() float64 {
+		return metrics.PingLatency(n.db.DB)
+	})
+	r.CounterFunc("hsd_db_deadlocks_total", "Number of database deadlocks.", func() float64 {
+		return float64(n.db.Deadlocks())
+	})
+	r.CounterFunc("hsd_db_retries_total", "Number of database operations retried after a transient error.", func() float64 {
+		return float64(n.db.Retries())
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	return http.Serve(l, mux)
+}
@@ -2,25 +2,29 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
 	"github.com/mike76-dev/hostscore/hostdb"
+	"github.com/mike76-dev/hostscore/internal/sqldb"
 	"github.com/mike76-dev/hostscore/internal/syncerutil"
 	"github.com/mike76-dev/hostscore/internal/utils"
 	"github.com/mike76-dev/hostscore/internal/walletutil"
 	"github.com/mike76-dev/hostscore/persist"
 	"go.sia.tech/core/gateway"
+	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils"
 	"go.sia.tech/coreutils/chain"
 	"go.sia.tech/coreutils/syncer"
 )
 
+// minBenchmarkInterval is the shortest benchmark interval an operator
+// may configure. A benchmark itself can take several minutes.
+const minBenchmarkInterval = 30 * time.Minute
+
 // Network bootstraps.
 var (
 	mainnetBootstrap = []string{
@@ -69,32 +73,54 @@ type node struct {
 	sZen  *syncer.Syncer
 	w     *walletutil.Wallet
 	hdb   *hostdb.HostDB
-	db    *sql.DB
+	db    *sqldb.DB
+
+	// updates is nil if the update check is disabled.
+	updates *updateChecker
 
 	Start func() (stop func())
 }
 
+// checkIndexes warns if any of the indexes required by the hot queries
+// is missing.
+func checkIndexes(db *sqldb.DB) {
+	var expected []sqldb.Index
+	for _, network := range []string{"mainnet", "zen"} {
+		for _, table := range []string{"hdb_scans_", "hdb_benchmarks_"} {
+			expected = append(expected,
+				sqldb.Index{Table: table + network, Columns: []string{"public_key", "ran_at"}},
+				sqldb.Index{Table: table + network, Columns: []string{"ran_at"}},
+			)
+		}
+	}
+
+	missing, err := db.MissingIndexes(expected)
+	if err != nil {
+		log.Printf("Could not check the database indexes: %v\n", err)
+		return
+	}
+	for _, idx := range missing {
+		log.Printf("Warning: index on %v is missing, queries may be slow\n", idx)
+	}
+}
+
 func newNode(config *persist.HSDConfig, dbPassword, seed, seedZen string) (*node, error) {
 	log.Println("Connecting to the SQL database...")
-	cfg := mysql.Config{
-		User:                 config.DBUser,
-		Passwd:               dbPassword,
-		Net:                  "tcp",
-		Addr:                 "127.0.0.1:3306",
-		DBName:               config.DBName,
-		AllowNativePasswords: true,
-	}
-	mdb, err := sql.Open("mysql", cfg.FormatDSN())
+	mdb, err := sqldb.Open(sqldb.Config{
+		Type:     config.DBType,
+		Addr:     config.DBAddr,
+		User:     config.DBUser,
+		Password: dbPassword,
+		Name:     config.DBName,
+	})
 	if err != nil {
 		log.Fatalf("Could not connect to the database: %v\n", err)
 	}
 	err = mdb.Ping()
 	if err != nil {
-		log.Fatalf("MySQL database not responding: %v\n", err)
+		log.Fatalf("Database not responding: %v\n", err)
 	}
-	mdb.SetConnMaxLifetime(time.Minute * 3)
-	mdb.SetMaxOpenConns(10)
-	mdb.SetMaxIdleConns(10)
+	checkIndexes(mdb)
 
 	// Make sure the path is an absolute one.
 	dir, err := filepath.Abs(config.Dir)
@@ -214,17 +240,102 @@ func newNode(config *persist.HSDConfig, dbPassword, seed, seedZen string) (*node
 	sZen := syncer.New(lZen, cmZen, psZen, headerZen, syncer.WithLogger(loggerZen))
 
 	log.Println("Loading wallet...")
-	w, err := walletutil.NewWallet(mdb, seed, seedZen, config.Dir, cm, cmZen, s, sZen)
+	maxFee, maxFeeZen := types.ZeroCurrency, types.ZeroCurrency
+	if config.MaxFeeMainnet != "" {
+		maxFee, err = types.ParseCurrency(config.MaxFeeMainnet)
+		if err != nil {
+			log.Fatalf("Invalid max Mainnet fee: %v\n", config.MaxFeeMainnet)
+		}
+	}
+	if config.MaxFeeZen != "" {
+		maxFeeZen, err = types.ParseCurrency(config.MaxFeeZen)
+		if err != nil {
+			log.Fatalf("Invalid max Zen fee: %v\n", config.MaxFeeZen)
+		}
+	}
+	w, err := walletutil.NewWallet(mdb, seed, seedZen, config.Dir, maxFee, maxFeeZen, config.StuckThreshold, cm, cmZen, s, sZen)
 	if err != nil {
 		return nil, err
 	}
 
 	log.Println("Loading host database...")
-	hdb, errChan := hostdb.NewHostDB(mdb, config.Dir, cm, cmZen, s, sZen, w)
+	bc := hostdb.BenchmarkConfig{Sectors: config.BenchmarkSectors}
+	if config.BenchmarkInterval != "" {
+		bc.Interval, err = time.ParseDuration(config.BenchmarkInterval)
+		if err != nil || bc.Interval < minBenchmarkInterval {
+			log.Fatalf("Invalid benchmark interval: %v\n", config.BenchmarkInterval)
+		}
+	}
+	switch hostdb.BenchmarkSchedule(config.BenchmarkSchedule) {
+	case "", hostdb.ScheduleFixed, hostdb.ScheduleAdaptive:
+		bc.Schedule = hostdb.BenchmarkSchedule(config.BenchmarkSchedule)
+	default:
+		log.Fatalf("Invalid benchmark schedule: %v\n", config.BenchmarkSchedule)
+	}
+	if config.MaxBenchmarkInterval != "" {
+		bc.MaxInterval, err = time.ParseDuration(config.MaxBenchmarkInterval)
+		if err != nil || bc.MaxInterval < max(bc.Interval, minBenchmarkInterval) {
+			log.Fatalf("Invalid max benchmark interval: %v\n", config.MaxBenchmarkInterval)
+		}
+	}
+	if config.BenchmarkTopRank < 0 {
+		log.Fatalf("Invalid benchmark top rank: %v\n", config.BenchmarkTopRank)
+	}
+	bc.TopRank = config.BenchmarkTopRank
+	if config.MaxBenchmarkCostMainnet != "" {
+		bc.MaxCost, err = types.ParseCurrency(config.MaxBenchmarkCostMainnet)
+		if err != nil {
+			log.Fatalf("Invalid max Mainnet benchmark cost: %v\n", config.MaxBenchmarkCostMainnet)
+		}
+	}
+	if config.MaxBenchmarkCostZen != "" {
+		bc.MaxCostZen, err = types.ParseCurrency(config.MaxBenchmarkCostZen)
+		if err != nil {
+			log.Fatalf("Invalid max Zen benchmark cost: %v\n", config.MaxBenchmarkCostZen)
+		}
+	}
+	if config.BenchmarkBudgetMainnet != "" {
+		bc.Budget, err = types.ParseCurrency(config.BenchmarkBudgetMainnet)
+		if err != nil {
+			log.Fatalf("Invalid Mainnet benchmark budget: %v\n", config.BenchmarkBudgetMainnet)
+		}
+	}
+	if config.BenchmarkBudgetZen != "" {
+		bc.BudgetZen, err = types.ParseCurrency(config.BenchmarkBudgetZen)
+		if err != nil {
+			log.Fatalf("Invalid Zen benchmark budget: %v\n", config.BenchmarkBudgetZen)
+		}
+	}
+	if config.MinBalanceMainnet != "" {
+		bc.MinBalance, err = types.ParseCurrency(config.MinBalanceMainnet)
+		if err != nil {
+			log.Fatalf("Invalid min Mainnet balance: %v\n", config.MinBalanceMainnet)
+		}
+	}
+	if config.MinBalanceZen != "" {
+		bc.MinBalanceZen, err = types.ParseCurrency(config.MinBalanceZen)
+		if err != nil {
+			log.Fatalf("Invalid min Zen balance: %v\n", config.MinBalanceZen)
+		}
+	}
+	ac := hostdb.AllowlistConfig{Enabled: config.AllowlistMode}
+	for _, h := range config.Allowlist {
+		var pk types.PublicKey
+		if err := pk.UnmarshalText([]byte(h)); err != nil {
+			log.Fatalf("Invalid allowlist entry: %v\n", h)
+		}
+		ac.Hosts = append(ac.Hosts, pk)
+	}
+	hdb, errChan := hostdb.NewHostDB(mdb, config.Dir, cm, cmZen, s, sZen, w, bc, ac)
 	if err := utils.PeekErr(errChan); err != nil {
 		return nil, err
 	}
 
+	var uc *updateChecker
+	if config.UpdateCheck {
+		uc = newUpdateChecker()
+	}
+
 	return &node{
 		cm:    cm,
 		cmZen: cmZen,
@@ -233,6 +344,8 @@ func newNode(config *persist.HSDConfig, dbPassword, seed, seedZen string) (*node
 		w:     w,
 		hdb:   hdb,
 		db:    mdb,
+
+		updates: uc,
 		Start: func() func() {
 			ctx := context.Background()
 			ch := make(chan struct{})
@@ -246,6 +359,9 @@ func newNode(config *persist.HSDConfig, dbPassword, seed, seedZen string) (*node
 				close(chZen)
 			}()
 			return func() {
+				if uc != nil {
+					uc.Close()
+				}
 				l.Close()
 				<-ch
 				lZen.Close()
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mike76-dev/hostscore/api"
+	"github.com/mike76-dev/hostscore/external"
+	"github.com/mike76-dev/hostscore/internal/build"
+)
+
+// updateCheckInterval is how often the latest release is checked.
+const updateCheckInterval = 24 * time.Hour
+
+// updateChecker compares the running version with the latest release
+// published on GitHub.
+type updateChecker struct {
+	status   api.UpdateStatus
+	mu       sync.Mutex
+	stopChan chan struct{}
+}
+
+// newUpdateChecker starts checking for updates periodically.
+func newUpdateChecker() *updateChecker {
+	uc := &updateChecker{stopChan: make(chan struct{})}
+	go uc.run()
+	return uc
+}
+
+func (uc *updateChecker) run() {
+	for {
+		uc.check()
+		select {
+		case <-uc.stopChan:
+			return
+		case <-time.After(updateCheckInterval):
+		}
+	}
+}
+
+func (uc *updateChecker) check() {
+	release, err := external.FetchLatestRelease()
+	if err != nil {
+		log.Println("WARN: couldn't check for updates:", err)
+		return
+	}
+	latest := strings.TrimPrefix(release.Tag, "v")
+	available := build.IsVersion(latest) && build.VersionCmp(build.NodeVersion, latest) < 0
+	if available {
+		log.Printf("Update available: hsd v%s (running v%s), see %s\n", latest, build.NodeVersion, release.URL)
+	}
+
+	uc.mu.Lock()
+	uc.status = api.UpdateStatus{
+		Available:     available,
+		LatestVersion: latest,
+		ReleaseURL:    release.URL,
+		CheckedAt:     time.Now(),
+	}
+	uc.mu.Unlock()
+}
+
+// Status returns the result of the last check.
+func (uc *updateChecker) Status() api.UpdateStatus {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.status
+}
+
+// Close stops the checker.
+func (uc *updateChecker) Close() {
+	close(uc.stopChan)
+}
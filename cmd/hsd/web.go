@@ -10,9 +10,19 @@ import (
 )
 
 func startWeb(l net.Listener, node *node, password string) error {
-	server := api.NewServer(node.cm, node.cmZen, node.s, node.sZen, node.w, node.hdb)
+	var updates func() api.UpdateStatus
+	if node.updates != nil {
+		updates = node.updates.Status
+	}
+	server := api.NewServer(node.cm, node.cmZen, node.s, node.sZen, node.w, node.hdb, updates)
 	api := jape.BasicAuth(password)(server)
 	return http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The readiness probe needs no password, so that it can be used
+		// by the container health checks.
+		if r.URL.Path == "/readyz" {
+			readyzHandler(w, node)
+			return
+		}
 		if strings.HasPrefix(r.URL.Path, "/api") {
 			r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api")
 			api.ServeHTTP(w, r)
@@ -20,3 +30,14 @@ func startWeb(l net.Listener, node *node, password string) error {
 		}
 	}))
 }
+
+// readyzHandler reports whether the node can serve requests. The node is
+// ready before the chains are synced, since the initial sync can take
+// hours.
+func readyzHandler(w http.ResponseWriter, node *node) {
+	if err := node.db.Ping(); err != nil {
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
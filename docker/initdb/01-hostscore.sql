@@ -0,0 +1,13 @@
+-- Creates the databases of hsd and hsc. The user is created by the MySQL
+-- image from MYSQL_USER and MYSQL_PASSWORD.
+CREATE DATABASE IF NOT EXISTS hostscore;
+CREATE DATABASE IF NOT EXISTS hsc;
+GRANT ALL PRIVILEGES ON hostscore.* TO 'hsuser'@'%';
+GRANT ALL PRIVILEGES ON hsc.* TO 'hsuser'@'%';
+FLUSH PRIVILEGES;
+
+USE hostscore;
+SOURCE /schema/init.sql;
+
+USE hsc;
+SOURCE /schema/init_portal.sql;
@@ -7,6 +7,9 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mike76-dev/hostscore/internal/utils"
 )
@@ -22,6 +25,31 @@ type IPInfo struct {
 	ISP      string `json:"org"`
 	ZIP      string `json:"postal"`
 	TimeZone string `json:"timezone"`
+	ASN      string `json:"asn"`
+}
+
+// ParseASN extracts the autonomous system number from the organization
+// reported by IPInfo, e.g. "AS24940" from "AS24940 Hetzner Online GmbH".
+// It returns an empty string if there is none.
+func ParseASN(org string) string {
+	asn, _, _ := strings.Cut(org, " ")
+	if len(asn) < 3 || !strings.EqualFold(asn[:2], "AS") {
+		return ""
+	}
+	if _, err := strconv.ParseUint(asn[2:], 10, 32); err != nil {
+		return ""
+	}
+	return strings.ToUpper(asn)
+}
+
+// ProviderName returns the organization reported by IPInfo without the
+// autonomous system number.
+func ProviderName(org string) string {
+	if ParseASN(org) == "" {
+		return org
+	}
+	_, name, _ := strings.Cut(org, " ")
+	return strings.TrimSpace(name)
 }
 
 const (
@@ -30,9 +58,19 @@ const (
 
 	// ipInfoAPI is the endpoint of the IPInfo geolocation API.
 	ipInfoAPI = "https://ipinfo.io/"
+
+	// releasesAPI is the endpoint of the latest HostScore release.
+	releasesAPI = "https://api.github.com/repos/mike76-dev/hostscore/releases/latest"
 )
 
 type (
+	// Release describes a published HostScore release.
+	Release struct {
+		Tag         string    `json:"tag_name"`
+		URL         string    `json:"html_url"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+
 	// marketResponse holds the market API response.
 	marketResponse struct {
 		Message string             `json:"message"`
@@ -60,22 +98,35 @@ func FetchSCRates() (map[string]float64, error) {
 	return nil, utils.AddContext(err, "falied to fetch SC exchange rates")
 }
 
-// FetchIPInfo uses the IPInfo API to fetch the host's geolocation.
-func FetchIPInfo(addr, token string) (IPInfo, error) {
+// ResolveIP returns the first IP address the host part of the address
+// resolves to. It returns an empty string if the name can't be resolved.
+func ResolveIP(addr string) (string, error) {
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return IPInfo{}, err
+		return "", err
 	}
 
 	ips, err := net.LookupHost(host)
-	if err != nil {
-		return IPInfo{}, nil
+	if err != nil || len(ips) == 0 {
+		return "", nil
 	}
-	if len(ips) == 0 {
-		return IPInfo{}, nil
+
+	return ips[0], nil
+}
+
+// FetchIPInfo uses the IPInfo API to fetch the host's geolocation.
+func FetchIPInfo(addr, token string) (IPInfo, error) {
+	ip, err := ResolveIP(addr)
+	if err != nil || ip == "" {
+		return IPInfo{}, err
 	}
+	return FetchIPInfoByIP(ip, token)
+}
 
-	resp, err := http.Get(ipInfoAPI + ips[0] + "?token=" + token)
+// FetchIPInfoByIP uses the IPInfo API to fetch the geolocation of the IP
+// address.
+func FetchIPInfoByIP(ip, token string) (IPInfo, error) {
+	resp, err := http.Get(ipInfoAPI + ip + "?token=" + token)
 	if err != nil {
 		return IPInfo{}, err
 	}
@@ -85,9 +136,35 @@ func FetchIPInfo(addr, token string) (IPInfo, error) {
 		return IPInfo{}, errors.New("failed to fetch host location")
 	}
 
-	var data IPInfo
+	// The paid plans return the ASN details as an object, which is
+	// ignored in favor of the number contained in the organization.
+	var data struct {
+		IPInfo
+		ASN json.RawMessage `json:"asn"`
+	}
 	dec := json.NewDecoder(resp.Body)
 	err = dec.Decode(&data)
+	data.IPInfo.ASN = ParseASN(data.ISP)
+
+	return data.IPInfo, err
+}
+
+// FetchLatestRelease retrieves the latest HostScore release from GitHub.
+func FetchLatestRelease() (Release, error) {
+	resp, err := http.Get(releasesAPI)
+	if err != nil {
+		return Release{}, utils.AddContext(err, "failed to fetch latest release")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Release{}, errors.New("failed to fetch latest release")
+	}
+
+	var data Release
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return Release{}, errors.New("wrong format of latest release")
+	}
 
-	return data, err
+	return data, nil
 }
@@ -0,0 +1,406 @@
+package external
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// metadataMarker precedes the metadata section of a MaxMind DB file.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// maxMetadataSize is the maximum size of the metadata section.
+const maxMetadataSize = 128 << 10
+
+// ErrNotFound is returned if the address is not in the GeoIP database.
+var ErrNotFound = errors.New("address not found")
+
+// A GeoIPDB is a MaxMind DB file, such as GeoLite2-City or GeoLite2-ASN,
+// loaded into memory.
+type GeoIPDB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+
+	// Type is the database type, e.g. "GeoLite2-City".
+	Type string
+
+	// BuildTime is the time the database was built.
+	BuildTime time.Time
+}
+
+// OpenGeoIPDB reads the MaxMind DB file at the given path.
+func OpenGeoIPDB(path string) (*GeoIPDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	start := max(0, len(buf)-maxMetadataSize)
+	i := bytes.LastIndex(buf[start:], metadataMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	metaStart := start + i + len(metadataMarker)
+	d := mmdbDecoder{buf: buf[metaStart:]}
+	v, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode metadata: %w", err)
+	}
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+
+	db := &GeoIPDB{
+		buf:        buf,
+		nodeCount:  uint(mmdbUint(meta["node_count"])),
+		recordSize: uint(mmdbUint(meta["record_size"])),
+		ipVersion:  uint(mmdbUint(meta["ip_version"])),
+		BuildTime:  time.Unix(int64(mmdbUint(meta["build_epoch"])), 0),
+	}
+	db.Type, _ = meta["database_type"].(string)
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(start+i) {
+		return nil, errors.New("corrupt search tree")
+	}
+	db.data = buf[treeSize+16 : start+i]
+
+	// The IPv4 addresses are stored in the ::/96 subtree of an IPv6
+	// database.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < db.nodeCount; j++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// record returns the left (bit 0) or the right (bit 1) record of the
+// node.
+func (db *GeoIPDB) record(node uint, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := db.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		off := node * 7
+		b := db.buf[off : off+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(db.buf[off : off+4]))
+	}
+}
+
+// lookup returns the record of the IP address.
+func (db *GeoIPDB) lookup(ip net.IP) (map[string]any, error) {
+	node, bits := uint(0), 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return nil, ErrNotFound
+	}
+	if node-db.nodeCount < 16 {
+		return nil, errCorruptData
+	}
+
+	d := mmdbDecoder{buf: db.data}
+	v, _, err := d.decode(node - db.nodeCount - 16)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid record")
+	}
+	return rec, nil
+}
+
+// Lookup returns the geolocation of the IP address. The fields depend on
+// the type of the database: a City database provides the location, an
+// ASN database the ISP and the ASN.
+func (db *GeoIPDB) Lookup(ip string) (IPInfo, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return IPInfo{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+	rec, err := db.lookup(addr)
+	if err != nil {
+		return IPInfo{}, err
+	}
+
+	info := IPInfo{IP: ip}
+	info.Country = mmdbPath(rec, "country", "iso_code")
+	info.City = mmdbPath(rec, "city", "names", "en")
+	info.ZIP = mmdbPath(rec, "postal", "code")
+	info.TimeZone = mmdbPath(rec, "location", "time_zone")
+	if subdivisions, ok := rec["subdivisions"].([]any); ok && len(subdivisions) > 0 {
+		if s, ok := subdivisions[0].(map[string]any); ok {
+			info.Region = mmdbPath(s, "names", "en")
+		}
+	}
+	if loc, ok := rec["location"].(map[string]any); ok {
+		lat, okLat := loc["latitude"].(float64)
+		lon, okLon := loc["longitude"].(float64)
+		if okLat && okLon {
+			info.Location = strconv.FormatFloat(lat, 'f', 4, 64) + "," + strconv.FormatFloat(lon, 'f', 4, 64)
+		}
+	}
+	if asn := mmdbUint(rec["autonomous_system_number"]); asn > 0 {
+		info.ASN = "AS" + strconv.FormatUint(asn, 10)
+		info.ISP = info.ASN
+		if org, ok := rec["autonomous_system_organization"].(string); ok && org != "" {
+			info.ISP += " " + org
+		}
+	}
+
+	return info, nil
+}
+
+// mmdbPath returns the string at the path of nested maps, or an empty
+// string if there is none.
+func mmdbPath(m map[string]any, path ...string) string {
+	for i, key := range path {
+		v, ok := m[key]
+		if !ok {
+			return ""
+		}
+		if i == len(path)-1 {
+			s, _ := v.(string)
+			return s
+		}
+		if m, ok = v.(map[string]any); !ok {
+			return ""
+		}
+	}
+	return ""
+}
+
+// mmdbUint converts a decoded unsigned integer to uint64.
+func mmdbUint(v any) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		if n >= 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
+
+// MaxMind DB data types.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// maxMMDBDepth limits the nesting of the decoded values.
+const maxMMDBDepth = 32
+
+// mmdbDecoder decodes the values of a MaxMind DB data section. The
+// pointers are relative to the start of buf.
+type mmdbDecoder struct {
+	buf   []byte
+	depth int
+}
+
+var errCorruptData = errors.New("corrupt MaxMind DB data")
+
+// bytes returns n bytes at the offset.
+func (d *mmdbDecoder) bytes(off, n uint) ([]byte, error) {
+	if off+n > uint(len(d.buf)) || off+n < off {
+		return nil, errCorruptData
+	}
+	return d.buf[off : off+n], nil
+}
+
+// uintN decodes a big-endian unsigned integer of n bytes.
+func (d *mmdbDecoder) uintN(off, n uint) (uint64, error) {
+	b, err := d.bytes(off, n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decode returns the value at the offset and the offset of the next
+// value.
+func (d *mmdbDecoder) decode(off uint) (any, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxMMDBDepth {
+		return nil, 0, errCorruptData
+	}
+
+	ctrl, err := d.bytes(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	off++
+	typ := uint(ctrl[0] >> 5)
+
+	if typ == mmdbPointer {
+		ss := uint(ctrl[0]>>3) & 0x3
+		vvv := uint64(ctrl[0] & 0x7)
+		p, err := d.uintN(off, ss+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch ss {
+		case 0:
+			p |= vvv << 8
+		case 1:
+			p = (p | vvv<<16) + 2048
+		case 2:
+			p = (p | vvv<<24) + 526336
+		}
+		v, _, err := d.decode(uint(p))
+		return v, off + ss + 1, err
+	}
+
+	if typ == mmdbExtended {
+		ext, err := d.bytes(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		off++
+		typ = 7 + uint(ext[0])
+	}
+
+	size := uint(ctrl[0] & 0x1f)
+	switch {
+	case size == 29:
+		n, err := d.uintN(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		size, off = 29+uint(n), off+1
+	case size == 30:
+		n, err := d.uintN(off, 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		size, off = 285+uint(n), off+2
+	case size == 31:
+		n, err := d.uintN(off, 3)
+		if err != nil {
+			return nil, 0, err
+		}
+		size, off = 65821+uint(n), off+3
+	}
+
+	switch typ {
+	case mmdbString:
+		b, err := d.bytes(off, size)
+		return string(b), off + size, err
+	case mmdbDouble:
+		n, err := d.uintN(off, 8)
+		return math.Float64frombits(n), off + 8, err
+	case mmdbFloat:
+		n, err := d.uintN(off, 4)
+		return float64(math.Float32frombits(uint32(n))), off + 4, err
+	case mmdbBytes, mmdbUint128:
+		b, err := d.bytes(off, size)
+		return b, off + size, err
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, errCorruptData
+		}
+		n, err := d.uintN(off, size)
+		return n, off + size, err
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, errCorruptData
+		}
+		n, err := d.uintN(off, size)
+		return int32(uint32(n)), off + size, err
+	case mmdbBool:
+		return size != 0, off, nil
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errCorruptData
+			}
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], off = v, next
+		}
+		return m, off, nil
+	case mmdbArray:
+		a := make([]any, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, off = append(a, v), next
+		}
+		return a, off, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported MaxMind DB data type %d", typ)
+	}
+}
@@ -7,6 +7,8 @@ toolchain go1.23.2
 require (
 	github.com/go-sql-driver/mysql v1.7.1
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.24
 	gitlab.com/NebulousLabs/merkletree v0.0.0-20200118113624-07fbf710afc4
 	go.sia.tech/core v0.4.8-0.20240926222149-2c8b541119dc
 	go.sia.tech/coreutils v0.3.3-0.20240927170025-f45eedc64d6f
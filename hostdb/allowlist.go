@@ -0,0 +1,174 @@
+package hostdb
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// AllowlistConfig enables the allowlist mode, in which only the hosts on
+// the allowlist are scanned and benchmarked. Hosts are added to the
+// allowlist on every start, besides the ones added with the API.
+type AllowlistConfig struct {
+	Enabled bool
+	Hosts   []types.PublicKey
+}
+
+// Allowlist is the state of the allowlist.
+type Allowlist struct {
+	Enabled bool              `json:"enabled"`
+	Hosts   []types.PublicKey `json:"hosts"`
+}
+
+// allowlist keeps the public keys of the hosts allowed in the allowlist
+// mode.
+type allowlist struct {
+	enabled bool
+	keys    map[types.PublicKey]struct{}
+	mu      sync.Mutex
+}
+
+// allows returns true if the host may be scanned and benchmarked.
+func (al *allowlist) allows(pk types.PublicKey) bool {
+	return !al.enabled || al.contains(pk)
+}
+
+// contains returns true if the host is on the allowlist.
+func (al *allowlist) contains(pk types.PublicKey) bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	_, ok := al.keys[pk]
+	return ok
+}
+
+func (al *allowlist) list() []types.PublicKey {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	keys := make([]types.PublicKey, 0, len(al.keys))
+	for pk := range al.keys {
+		keys = append(keys, pk)
+	}
+	slices.SortFunc(keys, func(a, b types.PublicKey) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return keys
+}
+
+// loadAllowlist loads the allowlist from the database and adds the
+// configured hosts to it.
+func loadAllowlist(db *sqldb.DB, ac AllowlistConfig) (*allowlist, error) {
+	rows, err := db.Query("SELECT public_key FROM hdb_allowlist")
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query allowlist")
+	}
+	defer rows.Close()
+
+	al := &allowlist{
+		enabled: ac.Enabled,
+		keys:    make(map[types.PublicKey]struct{}),
+	}
+	for rows.Next() {
+		pk := make([]byte, 32)
+		if err := rows.Scan(&pk); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode allowlist entry")
+		}
+		al.keys[types.PublicKey(pk)] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load allowlist")
+	}
+
+	for _, pk := range ac.Hosts {
+		if _, ok := al.keys[pk]; ok {
+			continue
+		}
+		if _, err := db.Exec("INSERT INTO hdb_allowlist (public_key) VALUES (?)", pk[:]); err != nil {
+			return nil, utils.AddContext(err, "couldn't insert allowlist entry")
+		}
+		al.keys[pk] = struct{}{}
+	}
+
+	return al, nil
+}
+
+// Allowlist returns the state of the allowlist.
+func (hdb *HostDB) Allowlist() Allowlist {
+	return Allowlist{
+		Enabled: hdb.allowlist.enabled,
+		Hosts:   hdb.allowlist.list(),
+	}
+}
+
+// AddToAllowlist adds the hosts to the allowlist. In the allowlist mode,
+// they are scanned and benchmarked from now on.
+func (hdb *HostDB) AddToAllowlist(keys []types.PublicKey) error {
+	tx, err := hdb.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	var added []types.PublicKey
+	for _, pk := range keys {
+		if slices.Contains(added, pk) || hdb.allowlist.contains(pk) {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO hdb_allowlist (public_key) VALUES (?)", pk[:]); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't insert allowlist entry")
+		}
+		added = append(added, pk)
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	hdb.allowlist.mu.Lock()
+	for _, pk := range added {
+		hdb.allowlist.keys[pk] = struct{}{}
+	}
+	hdb.allowlist.mu.Unlock()
+
+	// Don't wait for the next round of scans.
+	if !hdb.allowlist.enabled {
+		return nil
+	}
+	for _, pk := range added {
+		for _, s := range []*hostDBStore{hdb.s, hdb.sZen} {
+			s.mu.Lock()
+			if host, exists := s.hosts[pk]; exists && !host.Blocked {
+				hdb.queueScan(host)
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// RemoveFromAllowlist removes the hosts from the allowlist. In the
+// allowlist mode, they are not scanned or benchmarked anymore.
+func (hdb *HostDB) RemoveFromAllowlist(keys []types.PublicKey) error {
+	tx, err := hdb.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	for _, pk := range keys {
+		if _, err := tx.Exec("DELETE FROM hdb_allowlist WHERE public_key = ?", pk[:]); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't delete allowlist entry")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	hdb.allowlist.mu.Lock()
+	for _, pk := range keys {
+		delete(hdb.allowlist.keys, pk)
+	}
+	hdb.allowlist.mu.Unlock()
+	return nil
+}
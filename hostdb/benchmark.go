@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"math"
 	"net"
 	"strings"
@@ -19,16 +20,77 @@ import (
 )
 
 const (
-	benchmarkInterval  = 2 * time.Hour
-	benchmarkBatchSize = 1 << 26 // 64 MiB
+	defaultBenchmarkInterval  = 2 * time.Hour
+	defaultBenchmarkBatchSize = 1 << 26 // 64 MiB
 )
 
+// MaxBenchmarkSectors is the largest number of sectors the cost of a
+// benchmark can be estimated for.
+const MaxBenchmarkSectors = 1024 // 4 GiB
+
+var (
+	// errTooExpensive is returned when the estimated cost of a benchmark
+	// exceeds the configured ceiling.
+	errTooExpensive = errors.New(skippedPrefix + "too expensive to benchmark")
+
+	// errCostOverflow is returned when the prices of the host are so high
+	// that the cost of a benchmark overflows.
+	errCostOverflow = errors.New("benchmark cost overflows")
+)
+
+// BenchmarkConfig contains the benchmark parameters set by the operator.
+// The zero values stand for the defaults.
+type BenchmarkConfig struct {
+	Sectors       int
+	Interval      time.Duration
+	Schedule      BenchmarkSchedule
+	MaxInterval   time.Duration
+	TopRank       int
+	MaxCost       types.Currency
+	MaxCostZen    types.Currency
+	Budget        types.Currency
+	BudgetZen     types.Currency
+	MinBalance    types.Currency
+	MinBalanceZen types.Currency
+}
+
+// BenchmarkSettings are the effective benchmark parameters of the node.
+type BenchmarkSettings struct {
+	Sectors     int               `json:"sectors"`
+	DataSize    uint64            `json:"dataSize"`
+	Interval    time.Duration     `json:"interval"`
+	Schedule    BenchmarkSchedule `json:"schedule"`
+	MaxInterval time.Duration     `json:"maxInterval"`
+	TopRank     int               `json:"topRank"`
+	MaxCost     types.Currency    `json:"maxCost"`
+	MaxCostZen  types.Currency    `json:"maxCostZen"`
+}
+
+// BenchmarkCostEstimate is the estimated cost of benchmarking a host.
+type BenchmarkCostEstimate struct {
+	Sectors      int            `json:"sectors"`
+	Cost         types.Currency `json:"cost"`
+	MaxCost      types.Currency `json:"maxCost"`
+	TooExpensive bool           `json:"tooExpensive"`
+}
+
 // benchmarkHost runs an up/download benchmark on a host.
 func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 	if host.Network != "mainnet" && host.Network != "zen" {
 		panic("wrong host network")
 	}
 
+	// The operator may have opted out or the host may have been removed
+	// from the allowlist after it was queued, and the benchmark is
+	// deferred if the daily budget is spent.
+	if hdb.optOuts.level(host.Network, host.PublicKey) != "" || !hdb.allowlist.allows(host.PublicKey) || hdb.budget.exhausted(host.Network) {
+		hdb.mu.Lock()
+		delete(hdb.scanMap, host.PublicKey)
+		hdb.benchmarkThreads--
+		hdb.mu.Unlock()
+		return
+	}
+
 	// Update historic interactions of the host if necessary.
 	hdb.updateHostHistoricInteractions(host)
 	limits := hdb.priceLimits
@@ -46,6 +108,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 	var success bool
 	var ul, dl float64
 	var ttfb time.Duration
+	var uploaded, downloaded uint64
 	var errMsg string
 	err := func() error {
 		// Do some checks first.
@@ -69,14 +132,49 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 
 		h, _, _ := net.SplitHostPort(host.NetAddress)
 		addr := net.JoinHostPort(h, host.Settings.SiaMuxPort)
-		numSectors := benchmarkBatchSize / rhpv2.SectorSize
+		numSectors := hdb.benchmarkSectors()
 		var uploadCost, downloadCost types.Currency
 
+		// Check if the benchmark is within the cost ceiling.
+		if maxCost := hdb.maxBenchmarkCost(host.Network); !maxCost.IsZero() {
+			cost, err := estimateBenchmarkCost(host, numSectors, height)
+			if errors.Is(err, errCostOverflow) {
+				return errTooExpensive
+			}
+			if err == nil && cost.Cmp(maxCost) > 0 {
+				return fmt.Errorf("%w: %v > %v", errTooExpensive, cost, maxCost)
+			}
+		}
+
+		// Revisions close to the contract expiry are tracked separately,
+		// because some hosts misbehave near the end of a contract.
+		var nearExpiry bool
+		recordExpiry := func(err error) {
+			if !nearExpiry {
+				return
+			}
+			if err != nil && (strings.Contains(err.Error(), "canceled") || strings.Contains(err.Error(), "insufficient balance")) {
+				return
+			}
+			if err != nil {
+				host.Compliance.ExpiryFailures++
+			} else {
+				host.Compliance.ExpirySuccesses++
+			}
+			host.Compliance.UpdateViolation()
+		}
+
 		// Check if we have a contract with this host and if it has enough money in it.
-		if host.Revision.WindowStart <= height+144 ||
-			host.Revision.ValidRenterPayout().Cmp(benchmarkCost(host)) < 0 {
+		if host.Revision.WindowStart <= height+144+renewWindow ||
+			host.Revision.ValidRenterPayout().Cmp(benchmarkCost(host, numSectors)) < 0 {
+			// Don't form new contracts if the wallet is running dry.
+			if hdb.LowFunds(host.Network) {
+				return errLowFunds
+			}
+
 			var rev rhpv2.ContractRevision
 			var txnSet []types.Transaction
+			var cost types.Currency
 			formCtx, formCancel := context.WithTimeout(context.Background(), 2*time.Minute)
 			defer formCancel()
 			go func() {
@@ -86,22 +184,51 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 				case <-formCtx.Done():
 				}
 			}()
-			err = rhp.WithTransportV2(formCtx, settings.NetAddress, host.PublicKey, func(t *rhpv2.Transport) error {
-				renterTxnSet, err := hdb.prepareContractFormation(host)
+
+			// Try to renew the existing contract first, as long as the
+			// revision deadline hasn't passed. A new contract is formed
+			// if the host refuses to renew.
+			previous := host.Revision.ParentID
+			var renewed bool
+			if (previous != types.FileContractID{}) && host.Revision.WindowStart > height+144 {
+				rev, txnSet, cost, err = hdb.renewContract(formCtx, host, addr, numSectors, limits)
+				if errors.Is(err, errOverBudget) {
+					return err
+				}
 				if err != nil {
-					return utils.AddContext(err, "couldn't prepare contract")
+					hdb.log.Debug("couldn't renew contract, forming a new one", zap.String("network", host.Network), zap.String("host", host.NetAddress), zap.Stringer("id", previous), zap.Error(err))
+				} else {
+					renewed = true
 				}
+			}
+
+			if !renewed {
+				err = rhp.WithTransportV2(formCtx, settings.NetAddress, host.PublicKey, func(t *rhpv2.Transport) error {
+					renterTxnSet, c, err := hdb.prepareContractFormation(host, numSectors)
+					if errors.Is(err, errOverBudget) {
+						return err
+					}
+					if err != nil {
+						return utils.AddContext(err, "couldn't prepare contract")
+					}
+
+					rev, txnSet, err = rhp.RPCFormContract(formCtx, t, key, renterTxnSet)
+					if err != nil {
+						hdb.w.Release(renterTxnSet...)
+						hdb.budget.release(host.Network, c)
+						return utils.AddContext(err, "couldn't form contract")
+					}
+					cost = c
 
-				rev, txnSet, err = rhp.RPCFormContract(formCtx, t, key, renterTxnSet)
+					return nil
+				})
 				if err != nil {
-					hdb.w.Release(renterTxnSet...)
-					return utils.AddContext(err, "couldn't form contract")
+					if settings.MaxDuration >= contractDuration && isDurationError(err) {
+						host.Compliance.DurationViolations++
+						host.Compliance.UpdateViolation()
+					}
+					return err
 				}
-
-				return nil
-			})
-			if err != nil {
-				return err
 			}
 
 			if host.Network == "zen" {
@@ -111,6 +238,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 					return utils.AddContext(err, "invalid transaction set")
 				}
 				hdb.syncerZen.BroadcastTransactionSet(txnSet)
+				hdb.w.AddPending(host.Network, txnSet)
 			} else {
 				_, err := hdb.cm.AddPoolTransactions(txnSet)
 				if err != nil {
@@ -118,11 +246,22 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 					return utils.AddContext(err, "invalid transaction set")
 				}
 				hdb.syncer.BroadcastTransactionSet(txnSet)
+				hdb.w.AddPending(host.Network, txnSet)
 			}
 
 			host.Revision = rev.Revision
-			hdb.log.Info("successfully formed contract", zap.String("network", host.Network), zap.String("host", host.NetAddress), zap.Stringer("id", rev.Revision.ParentID))
+			host.Compliance.FormationSuccesses++
+			host.Compliance.UpdateViolation()
+			hdb.recordContract(host, rev.Revision, previous, renewed, cost)
+			hdb.hostSpending.record(host.Network, host.PublicKey, spendingContracts, cost)
+			if renewed {
+				hdb.log.Info("successfully renewed contract", zap.String("network", host.Network), zap.String("host", host.NetAddress), zap.Stringer("id", rev.Revision.ParentID), zap.Stringer("renewedFrom", previous))
+			} else {
+				hdb.log.Info("successfully formed contract", zap.String("network", host.Network), zap.String("host", host.NetAddress), zap.Stringer("id", rev.Revision.ParentID))
+			}
 		} else {
+			nearExpiry = host.Revision.WindowStart <= height+144+renewWindow+nearExpiryWindow
+
 			// Fetch the latest revision.
 			revCtx, revCancel := context.WithTimeout(context.Background(), 30*time.Second)
 			defer revCancel()
@@ -142,6 +281,7 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 				return nil
 			})
 			if err != nil {
+				recordExpiry(err)
 				return err
 			}
 		}
@@ -150,6 +290,11 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 		// at the same time.
 		for {
 			hdb.mu.Lock()
+			if hdb.draining {
+				// Don't start uploading when shutting down.
+				hdb.mu.Unlock()
+				return errDraining
+			}
 			if !hdb.benchmarking {
 				hdb.benchmarking = true
 				hdb.mu.Unlock()
@@ -218,8 +363,18 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			if err != nil {
 				return utils.AddContext(err, "unable to estimate costs")
 			}
-			amount := uploadCost.Add(downloadCost).Mul64(uint64(numSectors))
-			amount = amount.Add(pt.FundAccountCost)
+			sectorCost, overflow := uploadCost.AddWithOverflow(downloadCost)
+			if overflow {
+				return errCostOverflow
+			}
+			amount, overflow := sectorCost.Mul64WithOverflow(uint64(numSectors))
+			if overflow {
+				return errCostOverflow
+			}
+			amount, overflow = amount.AddWithOverflow(pt.FundAccountCost)
+			if overflow {
+				return errCostOverflow
+			}
 			if amount.Cmp(balance) <= 0 {
 				return nil
 			}
@@ -229,12 +384,18 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 				host.Revision = types.FileContractRevision{}
 				return errors.New("insufficient balance")
 			}
+			if err := hdb.budget.reserve(host.Network, amount); err != nil {
+				return err
+			}
 			if err := rhp.RPCFundAccount(ptCtx, t, &payment, rhpv3.Account(key.PublicKey()), pt.UID); err != nil {
+				hdb.budget.release(host.Network, amount)
 				return utils.AddContext(err, "unable to fund account")
 			}
+			hdb.hostSpending.record(host.Network, host.PublicKey, spendingFunding, amount)
 
 			return nil
 		})
+		recordExpiry(err)
 		if err != nil {
 			return err
 		}
@@ -252,23 +413,27 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			case <-upCtx.Done():
 			}
 		}()
+		var spent types.Currency
 		err = rhp.WithTransportV3(upCtx, addr, host.PublicKey, func(t *rhpv3.Transport) error {
 			start = time.Now()
 			for i := 0; i < numSectors; i++ {
 				frand.Read(data[:256])
 				payment := rhpv3.PayByEphemeralAccount(rhpv3.Account(key.PublicKey()), uploadCost, host.PriceTable.HostBlockHeight+6, key)
-				root, _, err := rhp.RPCAppendSector(upCtx, t, key, host.PriceTable, &host.Revision, &payment, &data)
+				root, cost, err := rhp.RPCAppendSector(upCtx, t, key, host.PriceTable, &host.Revision, &payment, &data)
 				if err != nil {
 					return utils.AddContext(err, "unable to upload sector")
 				}
+				spent = spent.Add(cost)
 				roots[i] = root
+				uploaded += rhpv2.SectorSize
 			}
 			return nil
 		})
+		hdb.hostSpending.record(host.Network, host.PublicKey, spendingUpload, spent)
 		if err != nil {
 			return err
 		}
-		ul = float64(benchmarkBatchSize) / time.Since(start).Seconds()
+		ul = float64(numSectors*rhpv2.SectorSize) / time.Since(start).Seconds()
 
 		// Run a download benchmark.
 		dnCtx, dnCancel := context.WithTimeout(context.Background(), 5*time.Minute)
@@ -280,15 +445,20 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			case <-dnCtx.Done():
 			}
 		}()
+		spent = types.ZeroCurrency
 		err = rhp.WithTransportV3(dnCtx, addr, host.PublicKey, func(t *rhpv3.Transport) error {
 			start = time.Now()
 			for i := 0; i < numSectors; i++ {
 				payment := rhpv3.PayByEphemeralAccount(rhpv3.Account(key.PublicKey()), downloadCost, host.PriceTable.HostBlockHeight+6, key)
 				buf := bytes.NewBuffer(data[:])
-				_, _, err := rhp.RPCReadSector(dnCtx, t, buf, host.PriceTable, &payment, 0, rhpv2.SectorSize, roots[i])
+				cost, refund, err := rhp.RPCReadSector(dnCtx, t, buf, host.PriceTable, &payment, 0, rhpv2.SectorSize, roots[i])
 				if err != nil {
 					return utils.AddContext(err, "unable to download sector")
 				}
+				if cost.Cmp(refund) > 0 {
+					spent = spent.Add(cost.Sub(refund))
+				}
+				downloaded += rhpv2.SectorSize
 				if i == 0 {
 					ttfb = time.Since(start)
 				}
@@ -296,17 +466,14 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 			if err != nil {
 				return err
 			}
-			dl = float64(benchmarkBatchSize) / time.Since(start).Seconds()
+			dl = float64(numSectors*rhpv2.SectorSize) / time.Since(start).Seconds()
 
 			return nil
 		})
+		hdb.hostSpending.record(host.Network, host.PublicKey, spendingDownload, spent)
 		return err
 	}()
-	if err != nil && strings.Contains(err.Error(), "canceled") {
-		// Shutting down.
-		return
-	}
-	if err != nil && strings.Contains(err.Error(), "insufficient balance") {
+	if err != nil && (strings.Contains(err.Error(), "insufficient balance") || errors.Is(err, errOverBudget)) {
 		// Not the host's fault.
 		hdb.mu.Lock()
 		delete(hdb.scanMap, host.PublicKey)
@@ -314,9 +481,19 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 		hdb.mu.Unlock()
 		return
 	}
+	failure := hdb.classifyFailure(host.Network, err)
+	if err != nil && (errors.Is(err, errDraining) || strings.Contains(err.Error(), "canceled")) {
+		// Shutting down. The benchmark is recorded anyway, so that the
+		// state of the contract and the data transferred so far are
+		// saved.
+		failure = FailureRestarted
+	}
 	if err == nil {
 		success = true
 		hdb.IncrementSuccessfulInteractions(host)
+	} else if !failure.HostFault() {
+		// Record the benchmark but don't penalize the host.
+		errMsg = err.Error()
 	} else {
 		errMsg = err.Error()
 		hdb.IncrementFailedInteractions(host)
@@ -326,9 +503,12 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 		Timestamp:     timestamp,
 		Success:       success,
 		Error:         errMsg,
+		Failure:       failure,
 		UploadSpeed:   ul,
 		DownloadSpeed: dl,
 		TTFB:          ttfb,
+		Uploaded:      uploaded,
+		Downloaded:    downloaded,
 	}
 	if host.Network == "zen" {
 		err = hdb.sZen.updateBenchmarks(host, benchmark)
@@ -343,14 +523,20 @@ func (hdb *HostDB) benchmarkHost(host *HostDBEntry) {
 	hdb.mu.Lock()
 	delete(hdb.scanMap, host.PublicKey)
 	hdb.benchmarkThreads--
+	hdb.benchmarksDone++
 	hdb.mu.Unlock()
 }
 
 // calculateBenchmarkInterval calculates a benchmark interval depending on
 // how many previous benchmarks have been failed.
 func (s *hostDBStore) calculateBenchmarkInterval(host *HostDBEntry) time.Duration {
+	if s.hdb.benchmarkSchedule() == ScheduleAdaptive {
+		return s.calculateAdaptiveBenchmarkInterval(host)
+	}
+
+	benchmarkInterval := s.hdb.benchmarkInterval()
 	if host.LastBenchmark.Timestamp.IsZero() {
-		return benchmarkInterval // 2 hours
+		return benchmarkInterval // 2 hours by default
 	}
 
 	num := s.lastFailedBenchmarks(host)
@@ -358,32 +544,203 @@ func (s *hostDBStore) calculateBenchmarkInterval(host *HostDBEntry) time.Duratio
 		return math.MaxInt64 // never
 	}
 	if num > 11 {
-		return benchmarkInterval * 84 // 7 days
+		return benchmarkInterval * 84 // 7 days by default
 	}
 	if num > 9 {
-		return benchmarkInterval * 36 // 3 days
+		return benchmarkInterval * 36 // 3 days by default
 	}
 	if num > 7 {
-		return benchmarkInterval * 12 // 24 hours
+		return benchmarkInterval * 12 // 24 hours by default
 	}
 	if num > 5 {
-		return benchmarkInterval * 4 // 8 hours
+		return benchmarkInterval * 4 // 8 hours by default
 	}
 	if num > 3 {
-		return benchmarkInterval * 2 // 4 hours
+		return benchmarkInterval * 2 // 4 hours by default
 	}
 	return benchmarkInterval
 }
 
+// benchmarkSectors returns the number of sectors uploaded and downloaded
+// during a benchmark.
+func (hdb *HostDB) benchmarkSectors() int {
+	if hdb.benchmarkConfig.Sectors > 0 {
+		return hdb.benchmarkConfig.Sectors
+	}
+	return defaultBenchmarkBatchSize / rhpv2.SectorSize
+}
+
+// benchmarkInterval returns the interval between the benchmarks of a
+// host. The interval grows if the benchmarks keep failing.
+func (hdb *HostDB) benchmarkInterval() time.Duration {
+	if hdb.benchmarkConfig.Interval > 0 {
+		return hdb.benchmarkConfig.Interval
+	}
+	return defaultBenchmarkInterval
+}
+
+// BenchmarkSettings returns the effective benchmark parameters.
+func (hdb *HostDB) BenchmarkSettings() BenchmarkSettings {
+	sectors := hdb.benchmarkSectors()
+	settings := BenchmarkSettings{
+		Sectors:    sectors,
+		DataSize:   uint64(sectors) * rhpv2.SectorSize,
+		Interval:   hdb.benchmarkInterval(),
+		Schedule:   hdb.benchmarkSchedule(),
+		MaxCost:    hdb.maxBenchmarkCost("mainnet"),
+		MaxCostZen: hdb.maxBenchmarkCost("zen"),
+	}
+	if settings.Schedule == ScheduleAdaptive {
+		settings.MaxInterval = hdb.maxBenchmarkInterval()
+		settings.TopRank = hdb.benchmarkTopRank()
+	}
+	return settings
+}
+
+// maxBenchmarkCost returns the cost ceiling of a single benchmark.
+func (hdb *HostDB) maxBenchmarkCost(network string) types.Currency {
+	if network == "zen" {
+		return hdb.benchmarkConfig.MaxCostZen
+	}
+	return hdb.benchmarkConfig.MaxCost
+}
+
+// EstimateBenchmarkCost returns the estimated cost of benchmarking a host.
+// If sectors is zero, the configured number of sectors is used.
+func (hdb *HostDB) EstimateBenchmarkCost(network string, pk types.PublicKey, sectors int) (BenchmarkCostEstimate, error) {
+	if network != "mainnet" && network != "zen" {
+		panic("wrong network provided")
+	}
+	if sectors <= 0 {
+		sectors = hdb.benchmarkSectors()
+	}
+	if sectors > MaxBenchmarkSectors {
+		return BenchmarkCostEstimate{}, fmt.Errorf("the number of sectors may not exceed %d", MaxBenchmarkSectors)
+	}
+
+	s := hdb.s
+	if network == "zen" {
+		s = hdb.sZen
+	}
+	s.mu.Lock()
+	h, exists := s.hosts[pk]
+	var host HostDBEntry
+	if exists {
+		host = *h
+	}
+	height := s.tip.Height
+	s.mu.Unlock()
+	if !exists {
+		return BenchmarkCostEstimate{}, errors.New("host not found")
+	}
+
+	cost, err := estimateBenchmarkCost(&host, sectors, height)
+	if err != nil {
+		return BenchmarkCostEstimate{}, err
+	}
+	maxCost := hdb.maxBenchmarkCost(network)
+
+	return BenchmarkCostEstimate{
+		Sectors:      sectors,
+		Cost:         cost,
+		MaxCost:      maxCost,
+		TooExpensive: !maxCost.IsZero() && cost.Cmp(maxCost) > 0,
+	}, nil
+}
+
+// estimateBenchmarkCost estimates the cost of running a single benchmark
+// even if there is no contract with the host yet. The prices are set by
+// the host, so errCostOverflow is returned if the cost overflows.
+func estimateBenchmarkCost(host *HostDBEntry, numSectors int, height uint64) (types.Currency, error) {
+	n := uint64(numSectors)
+	if (host.PriceTable != rhpv3.HostPriceTable{}) {
+		windowEnd := host.Revision.WindowEnd
+		if windowEnd <= height {
+			windowEnd = height + contractDuration
+		}
+		pt := host.PriceTable
+		if pt.HostBlockHeight == 0 || pt.HostBlockHeight > windowEnd {
+			pt.HostBlockHeight = height
+		}
+		uploadCost, _, _, err := rhp.UploadSectorCost(pt, windowEnd)
+		if err != nil {
+			return types.ZeroCurrency, utils.AddContext(err, "unable to estimate upload cost")
+		}
+		downloadCost, err := rhp.ReadSectorCost(pt, rhpv2.SectorSize)
+		if err != nil {
+			return types.ZeroCurrency, utils.AddContext(err, "unable to estimate download cost")
+		}
+		sectorCost, ok := sumCosts(uploadCost, downloadCost)
+		if !ok {
+			return types.ZeroCurrency, errCostOverflow
+		}
+		transferCost, overflow := sectorCost.Mul64WithOverflow(n)
+		if overflow {
+			return types.ZeroCurrency, errCostOverflow
+		}
+		cost, ok := sumCosts(pt.UpdatePriceTableCost, pt.FundAccountCost, pt.LatestRevisionCost, transferCost)
+		if !ok {
+			return types.ZeroCurrency, errCostOverflow
+		}
+		return cost, nil
+	}
+
+	settings := host.Settings
+	if (settings == rhpv2.HostSettings{}) {
+		return types.ZeroCurrency, errors.New("host settings unavailable")
+	}
+	dataSize := n * rhpv2.SectorSize
+	bandwidthPrice, ok := sumCosts(settings.UploadBandwidthPrice, settings.DownloadBandwidthPrice)
+	if !ok {
+		return types.ZeroCurrency, errCostOverflow
+	}
+	bandwidthCost, overflow := bandwidthPrice.Mul64WithOverflow(dataSize)
+	if overflow {
+		return types.ZeroCurrency, errCostOverflow
+	}
+	storageCost, overflow := settings.StoragePrice.Mul64WithOverflow(dataSize)
+	if overflow {
+		return types.ZeroCurrency, errCostOverflow
+	}
+	storageCost, overflow = storageCost.Mul64WithOverflow(contractDuration)
+	if overflow {
+		return types.ZeroCurrency, errCostOverflow
+	}
+	rpcPrice, ok := sumCosts(settings.BaseRPCPrice, settings.SectorAccessPrice)
+	if !ok {
+		return types.ZeroCurrency, errCostOverflow
+	}
+	rpcCost, overflow := rpcPrice.Mul64WithOverflow(2 * n)
+	if overflow {
+		return types.ZeroCurrency, errCostOverflow
+	}
+	cost, ok := sumCosts(bandwidthCost, storageCost, rpcCost)
+	if !ok {
+		return types.ZeroCurrency, errCostOverflow
+	}
+	return cost, nil
+}
+
+// sumCosts adds up the costs. It returns false if the sum overflows.
+func sumCosts(costs ...types.Currency) (sum types.Currency, ok bool) {
+	for _, c := range costs {
+		var overflow bool
+		sum, overflow = sum.AddWithOverflow(c)
+		if overflow {
+			return types.ZeroCurrency, false
+		}
+	}
+	return sum, true
+}
+
 // benchmarkCost estimates the cost of running a single benchmark.
-func benchmarkCost(host *HostDBEntry) types.Currency {
+func benchmarkCost(host *HostDBEntry, numSectors int) types.Currency {
 	if (host.Settings == rhpv2.HostSettings{}) ||
 		(host.PriceTable == rhpv3.HostPriceTable{}) ||
 		(host.Revision.ParentID == types.FileContractID{}) {
 		return types.ZeroCurrency
 	}
 
-	numSectors := benchmarkBatchSize / rhpv2.SectorSize
 	uploadCost, _, _, err := rhp.UploadSectorCost(host.PriceTable, host.Revision.WindowEnd)
 	if err != nil {
 		return types.ZeroCurrency
@@ -392,11 +749,14 @@ func benchmarkCost(host *HostDBEntry) types.Currency {
 	if err != nil {
 		return types.ZeroCurrency
 	}
-	uploadCost = uploadCost.Mul64(uint64(numSectors))
-	downloadCost = downloadCost.Mul64(uint64(numSectors))
-	return host.PriceTable.UpdatePriceTableCost.
-		Add(host.PriceTable.FundAccountCost).
-		Add(host.PriceTable.LatestRevisionCost).
-		Add(uploadCost).
-		Add(downloadCost)
+	sectorCost, ok := sumCosts(uploadCost, downloadCost)
+	if !ok {
+		return types.ZeroCurrency
+	}
+	transferCost, overflow := sectorCost.Mul64WithOverflow(uint64(numSectors))
+	if overflow {
+		return types.ZeroCurrency
+	}
+	cost, _ := sumCosts(host.PriceTable.UpdatePriceTableCost, host.PriceTable.FundAccountCost, host.PriceTable.LatestRevisionCost, transferCost)
+	return cost
 }
@@ -0,0 +1,47 @@
+package hostdb
+
+import (
+	"errors"
+	"testing"
+
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	rhpv3 "go.sia.tech/core/rhp/v3"
+	"go.sia.tech/core/types"
+)
+
+func TestEstimateBenchmarkCost(t *testing.T) {
+	maxCurrency := types.NewCurrency(^uint64(0), ^uint64(0))
+
+	// The settings are used if there is no price table.
+	host := &HostDBEntry{Settings: rhpv2.HostSettings{
+		UploadBandwidthPrice:   types.NewCurrency64(1),
+		DownloadBandwidthPrice: types.NewCurrency64(1),
+		BaseRPCPrice:           types.NewCurrency64(1),
+	}}
+	cost, err := estimateBenchmarkCost(host, 2, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := types.NewCurrency64(4*rhpv2.SectorSize + 4); !cost.Equals(want) {
+		t.Fatalf("expected %v, got %v", want, cost)
+	}
+
+	// Prices high enough to overflow must not panic.
+	host.Settings.StoragePrice = maxCurrency.Div64(2)
+	if _, err := estimateBenchmarkCost(host, MaxBenchmarkSectors, 100); !errors.Is(err, errCostOverflow) {
+		t.Fatalf("expected errCostOverflow, got %v", err)
+	}
+	host.PriceTable = rhpv3.HostPriceTable{
+		UpdatePriceTableCost: maxCurrency,
+		LatestRevisionCost:   maxCurrency,
+	}
+	if _, err := estimateBenchmarkCost(host, 1, 100); !errors.Is(err, errCostOverflow) {
+		t.Fatalf("expected errCostOverflow, got %v", err)
+	}
+
+	// The number of sectors is capped.
+	hdb := &HostDB{}
+	if _, err := hdb.EstimateBenchmarkCost("mainnet", types.PublicKey{}, MaxBenchmarkSectors+1); err == nil {
+		t.Fatal("expected too many sectors to be rejected")
+	}
+}
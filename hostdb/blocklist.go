@@ -0,0 +1,157 @@
+package hostdb
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidBlocklistEntry is returned if a blocklist entry is neither a
+// domain, an IP address, a subnet, nor a public key.
+var ErrInvalidBlocklistEntry = errors.New("invalid blocklist entry")
+
+// domainRegex matches the domains with at least two labels, so that a
+// TLD can't be blocked by accident.
+var domainRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// normalizeBlocklistEntry checks the entry and converts it to the form
+// stored in the blocklist.
+func normalizeBlocklistEntry(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.HasPrefix(entry, "ed25519:") {
+		var pk types.PublicKey
+		if err := pk.UnmarshalText([]byte(entry)); err != nil {
+			return "", ErrInvalidBlocklistEntry
+		}
+		return pk.String(), nil
+	}
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		return ipNet.String(), nil
+	}
+	entry = strings.ToLower(entry)
+	host := entry
+	if h, _, err := net.SplitHostPort(entry); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil && !domainRegex.MatchString(host) {
+		return "", ErrInvalidBlocklistEntry
+	}
+	return entry, nil
+}
+
+// Blocklist returns the blocked domains, IP addresses, subnets, and
+// public keys.
+func (hdb *HostDB) Blocklist() []string {
+	return hdb.blockedDomains.list()
+}
+
+// AddToBlocklist blocks the given domains, IP addresses, subnets, or
+// public keys. The hosts matching them are not scanned or benchmarked
+// anymore.
+func (hdb *HostDB) AddToBlocklist(entries []string) error {
+	current := hdb.blockedDomains.list()
+	var added []string
+	for _, entry := range entries {
+		e, err := normalizeBlocklistEntry(entry)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(current, e) && !slices.Contains(added, e) {
+			added = append(added, e)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	tx, err := hdb.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	for _, e := range added {
+		if _, err := tx.Exec("INSERT INTO hdb_domains (dom) VALUES (?)", e); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't insert blocklist entry")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	hdb.blockedDomains.set(append(current, added...))
+	return hdb.applyBlocklist()
+}
+
+// RemoveFromBlocklist unblocks the given entries. The hosts not matching
+// any other entry are scanned again.
+func (hdb *HostDB) RemoveFromBlocklist(entries []string) error {
+	var removed []string
+	for _, entry := range entries {
+		e, err := normalizeBlocklistEntry(entry)
+		if err != nil {
+			return err
+		}
+		removed = append(removed, e)
+	}
+
+	tx, err := hdb.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	for _, e := range removed {
+		if _, err := tx.Exec("DELETE FROM hdb_domains WHERE dom = ?", e); err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't delete blocklist entry")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	var remaining []string
+	for _, e := range hdb.blockedDomains.list() {
+		if !slices.Contains(removed, e) {
+			remaining = append(remaining, e)
+		}
+	}
+	hdb.blockedDomains.set(remaining)
+	return hdb.applyBlocklist()
+}
+
+// applyBlocklist re-evaluates the blocklist on the hosts of both
+// networks.
+func (hdb *HostDB) applyBlocklist() error {
+	return errors.Join(hdb.s.applyBlocklist(), hdb.sZen.applyBlocklist())
+}
+
+// applyBlocklist blocks or unblocks the hosts whose status has changed
+// with the blocklist, and saves them.
+func (s *hostDBStore) applyBlocklist() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, host := range s.hosts {
+		blocked := s.hdb.blockedDomains.isHostBlocked(host)
+		if blocked == host.Blocked {
+			continue
+		}
+		host.Blocked = blocked
+		if err := s.update(host); err != nil {
+			return utils.AddContext(err, "couldn't update host")
+		}
+		n++
+	}
+	if n > 0 {
+		if err := s.commit(); err != nil {
+			return err
+		}
+		s.log.Info("blocklist applied", zap.String("network", s.network), zap.Int("changed", n))
+	}
+	return nil
+}
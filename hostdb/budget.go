@@ -0,0 +1,193 @@
+package hostdb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// errOverBudget is returned when a benchmark would exceed the daily
+// spending budget of the network.
+var errOverBudget = errors.New("daily benchmark budget exceeded")
+
+// BudgetState is the spending on the benchmarks of a network on the
+// current day (UTC). A zero limit means that the spending is unlimited.
+type BudgetState struct {
+	Network   string         `json:"network"`
+	Limit     types.Currency `json:"limit"`
+	Spent     types.Currency `json:"spent"`
+	Remaining types.Currency `json:"remaining"`
+	Exhausted bool           `json:"exhausted"`
+	Deferred  uint64         `json:"deferred"`
+	ResetsAt  time.Time      `json:"resetsAt"`
+}
+
+// networkSpending is the spending of a network on a given day.
+type networkSpending struct {
+	day      int64
+	spent    types.Currency
+	deferred uint64
+}
+
+// benchmarkBudget tracks the daily spending on the contract formation
+// and the account funding.
+type benchmarkBudget struct {
+	db       *sqldb.DB
+	log      *zap.Logger
+	limits   map[string]types.Currency
+	spending map[string]*networkSpending
+	mu       sync.Mutex
+}
+
+// today returns the number of the current day since the Unix epoch.
+func today() int64 {
+	return time.Now().Unix() / 86400
+}
+
+// encodeCurrency encodes the amount the way the currencies are stored.
+func encodeCurrency(c types.Currency) []byte {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	types.V1Currency(c).EncodeTo(e)
+	e.Flush()
+	return buf.Bytes()
+}
+
+// loadBudget loads the spending of the current day from the database.
+func loadBudget(db *sqldb.DB, l *zap.Logger, limit, limitZen types.Currency) (*benchmarkBudget, error) {
+	b := &benchmarkBudget{
+		db:  db,
+		log: l,
+		limits: map[string]types.Currency{
+			"mainnet": limit,
+			"zen":     limitZen,
+		},
+		spending: make(map[string]*networkSpending),
+	}
+
+	day := today()
+	for _, network := range []string{"mainnet", "zen"} {
+		ns := &networkSpending{day: day}
+		var buf []byte
+		err := db.QueryRow(`
+			SELECT amount
+			FROM hdb_spending
+			WHERE network = ?
+			AND day = ?
+		`, network, day).Scan(&buf)
+		if err == nil {
+			d := types.NewBufDecoder(buf)
+			if (*types.V1Currency)(&ns.spent).DecodeFrom(d); d.Err() != nil {
+				return nil, utils.AddContext(d.Err(), "couldn't decode spending")
+			}
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.AddContext(err, "couldn't load spending")
+		}
+		b.spending[network] = ns
+	}
+
+	return b, nil
+}
+
+// current returns the spending of the current day.
+// NOTE: a lock must be acquired before calling current.
+func (b *benchmarkBudget) current(network string) *networkSpending {
+	ns := b.spending[network]
+	if day := today(); ns.day != day {
+		*ns = networkSpending{day: day}
+	}
+	return ns
+}
+
+// exhausted returns true if nothing is left of the budget for today.
+// The deferred benchmarks are counted.
+func (b *benchmarkBudget) exhausted(network string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit := b.limits[network]
+	ns := b.current(network)
+	if limit.IsZero() || ns.spent.Cmp(limit) < 0 {
+		return false
+	}
+	ns.deferred++
+	return true
+}
+
+// reserve adds the amount to the spending of the day, unless the budget
+// would be exceeded.
+func (b *benchmarkBudget) reserve(network string, amount types.Currency) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit := b.limits[network]
+	ns := b.current(network)
+	spent := ns.spent.Add(amount)
+	if !limit.IsZero() && spent.Cmp(limit) > 0 {
+		ns.deferred++
+		return errOverBudget
+	}
+	ns.spent = spent
+	b.save(network, ns)
+	return nil
+}
+
+// release returns the amount reserved for an operation that has failed.
+func (b *benchmarkBudget) release(network string, amount types.Currency) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ns := b.current(network)
+	if ns.spent.Cmp(amount) < 0 {
+		// The day has changed in the meantime.
+		return
+	}
+	ns.spent = ns.spent.Sub(amount)
+	b.save(network, ns)
+}
+
+// save persists the spending of the day.
+// NOTE: a lock must be acquired before calling save.
+func (b *benchmarkBudget) save(network string, ns *networkSpending) {
+	_, err := b.db.Exec(`
+		INSERT INTO hdb_spending (network, day, amount)
+		VALUES (?, ?, ?) AS new
+		ON DUPLICATE KEY (network, day) UPDATE
+			amount = new.amount
+	`, network, ns.day, encodeCurrency(ns.spent))
+	if err != nil {
+		b.log.Error("couldn't save spending", zap.String("network", network), zap.Error(err))
+	}
+}
+
+// state returns the budget state of the network.
+func (b *benchmarkBudget) state(network string) BudgetState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit := b.limits[network]
+	ns := b.current(network)
+	bs := BudgetState{
+		Network:  network,
+		Limit:    limit,
+		Spent:    ns.spent,
+		Deferred: ns.deferred,
+		ResetsAt: time.Unix((ns.day+1)*86400, 0).UTC(),
+	}
+	if !limit.IsZero() {
+		if ns.spent.Cmp(limit) < 0 {
+			bs.Remaining = limit.Sub(ns.spent)
+		} else {
+			bs.Exhausted = true
+		}
+	}
+	return bs
+}
+
+// Budget returns the state of the daily benchmark budget of the network.
+func (hdb *HostDB) Budget(network string) BudgetState {
+	return hdb.budget.state(network)
+}
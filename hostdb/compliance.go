@@ -0,0 +1,50 @@
+package hostdb
+
+import "strings"
+
+// nearExpiryWindow is the number of blocks before the renewal threshold
+// during which a contract is considered to be close to its expiry.
+const nearExpiryWindow = 36
+
+// ContractCompliance tracks whether a host honors the contract terms it
+// advertises.
+type ContractCompliance struct {
+	FormationSuccesses int  `json:"formationSuccesses"`
+	DurationViolations int  `json:"durationViolations"`
+	ExpirySuccesses    int  `json:"expirySuccesses"`
+	ExpiryFailures     int  `json:"expiryFailures"`
+	Violation          bool `json:"violation"`
+}
+
+// UpdateViolation recalculates the violation flag.
+func (cc *ContractCompliance) UpdateViolation() {
+	cc.Violation = cc.DurationViolations > cc.FormationSuccesses ||
+		cc.ExpiryFailures > cc.ExpirySuccesses
+}
+
+// durationErrors are the messages with which hostd and siad reject a
+// contract because of its duration or proof window. The other formation
+// errors, e.g. a timeout or a gouging price, say nothing about whether
+// the host honors the advertised terms.
+var durationErrors = []string{
+	// hostd
+	"contract duration is too long",
+	"proof window is too small",
+	"contract ends too soon to safely submit the contract transaction",
+	// siad
+	"renter proposed a file contract with a too-long duration",
+	"renter proposed a file contract with a storage proof window that is too small",
+	"renter proposed a file contract with a window that starts too soon",
+}
+
+// isDurationError returns true if the host rejected a contract because of
+// its duration or proof window.
+func isDurationError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range durationErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,350 @@
+package hostdb
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidConsumer is returned when the name of a consumer of the
+// updates is not valid.
+var ErrInvalidConsumer = errors.New("invalid consumer name")
+
+var consumerRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidateConsumer checks the name of a consumer of the updates. An empty
+// name stands for the default consumer.
+func ValidateConsumer(consumer string) error {
+	if consumer != "" && !consumerRegex.MatchString(consumer) {
+		return ErrInvalidConsumer
+	}
+	return nil
+}
+
+// updateCursor marks the last records a named consumer has received. The
+// hosts are ordered by the time they were modified and then by their ID,
+// the scans and the benchmarks by their ID.
+type updateCursor struct {
+	modified  int64
+	host      int
+	scan      int64
+	benchmark int64
+}
+
+// EncodeTo implements types.EncoderTo.
+func (uc updateCursor) EncodeTo(e *types.Encoder) {
+	e.WriteUint64(uint64(uc.modified))
+	e.WriteUint64(uint64(uc.host))
+	e.WriteUint64(uint64(uc.scan))
+	e.WriteUint64(uint64(uc.benchmark))
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (uc *updateCursor) DecodeFrom(d *types.Decoder) {
+	uc.modified = int64(d.ReadUint64())
+	uc.host = int(d.ReadUint64())
+	uc.scan = int64(d.ReadUint64())
+	uc.benchmark = int64(d.ReadUint64())
+}
+
+// covers returns true if the host modified at the given time has been
+// sent to the consumer up to the cursor.
+func (uc updateCursor) covers(modified int64, host int) bool {
+	return modified < uc.modified || (modified == uc.modified && host <= uc.host)
+}
+
+// consumerUpdates tracks the updates of a named consumer, e.g. a shadow
+// portal. Unlike the receipts of the default consumer, which mark the
+// records as fetched, the receipts of a named consumer only move its own
+// cursor, so that it gets every update no matter who else confirms them.
+type consumerUpdates struct {
+	acked   UpdateID
+	cursor  updateCursor
+	pending pendingUpdates
+	next    updateCursor
+}
+
+// EncodeTo implements types.EncoderTo.
+func (cu consumerUpdates) EncodeTo(e *types.Encoder) {
+	cu.cursor.EncodeTo(e)
+	cu.pending.EncodeTo(e)
+	cu.next.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (cu *consumerUpdates) DecodeFrom(d *types.Decoder) {
+	cu.cursor.DecodeFrom(d)
+	cu.pending.DecodeFrom(d)
+	cu.next.DecodeFrom(d)
+}
+
+// loadConsumers loads the cursors of the named consumers.
+func (s *hostDBStore) loadConsumers() error {
+	s.consumers = make(map[string]*consumerUpdates)
+	rows, err := s.db.Query(`
+		SELECT consumer, acked, state
+		FROM hdb_consumers
+		WHERE network = ?
+	`, s.network)
+	if err != nil {
+		return utils.AddContext(err, "couldn't query consumers")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var consumer string
+		var acked uint64
+		var state []byte
+		if err := rows.Scan(&consumer, &acked, &state); err != nil {
+			return utils.AddContext(err, "couldn't decode consumer")
+		}
+		cu := &consumerUpdates{acked: acked}
+		d := types.NewBufDecoder(state)
+		cu.DecodeFrom(d)
+		if err := d.Err(); err != nil {
+			return utils.AddContext(err, "couldn't decode consumer state")
+		}
+		s.consumers[consumer] = cu
+	}
+
+	return utils.AddContext(rows.Err(), "couldn't load consumers")
+}
+
+// saveConsumer persists the cursor of the named consumer.
+// NOTE: a lock must be acquired before calling saveConsumer.
+func (s *hostDBStore) saveConsumer(consumer string, cu consumerUpdates) error {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	cu.EncodeTo(e)
+	e.Flush()
+	_, err := s.tx.Exec(`
+		INSERT INTO hdb_consumers (network, consumer, acked, state)
+		VALUES (?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (network, consumer) UPDATE
+			acked = new.acked,
+			state = new.state
+	`, s.network, consumer, cu.acked, buf.Bytes())
+	return utils.AddContext(err, "couldn't save consumer")
+}
+
+// consumerIDs returns the ID of the last batch of updates the named
+// consumer has confirmed and the ID of the batch not confirmed yet, which
+// is zero if there is none.
+func (s *hostDBStore) consumerIDs(consumer string) (acked, pending UpdateID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cu, ok := s.consumers[consumer]; ok {
+		return cu.acked, cu.pending.id
+	}
+	return 0, 0
+}
+
+// pickConsumerUpdates selects the records after the cursor of the named
+// consumer for the batch of updates with the given ID, and persists the
+// batch before it is sent. The hosts modified within the current second
+// are left for the next batch, so that no host modified later within the
+// same second is skipped. The scans and the benchmarks are only sent
+// after their host.
+func (s *hostDBStore) pickConsumerUpdates(consumer string, id UpdateID, limit int) error {
+	if s.tx == nil {
+		s.log.Error("there is no transaction", zap.String("network", s.network))
+		return errors.New("no database transaction")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cu consumerUpdates
+	if c, ok := s.consumers[consumer]; ok {
+		cu = *c
+	}
+	err := s.commitWithRetry(func() error {
+		cu.pending = pendingUpdates{id: id}
+		cu.next = cu.cursor
+		rows, err := s.tx.Query(`
+			SELECT id, modified
+			FROM hdb_hosts_`+s.network+`
+			WHERE (modified > ? OR (modified = ? AND id > ?))
+			AND modified < ?
+			ORDER BY modified ASC, id ASC
+			LIMIT ?
+		`, cu.cursor.modified, cu.cursor.modified, cu.cursor.host, time.Now().Unix(), limit)
+		if err != nil {
+			return utils.AddContext(err, "couldn't query hosts")
+		}
+		for rows.Next() {
+			var id int
+			var modified int64
+			if err := rows.Scan(&id, &modified); err != nil {
+				rows.Close()
+				return utils.AddContext(err, "couldn't decode host ID")
+			}
+			cu.pending.hosts = append(cu.pending.hosts, id)
+			cu.next.modified, cu.next.host = modified, id
+		}
+		rows.Close()
+
+		for _, table := range []string{"scans", "benchmarks"} {
+			after := cu.cursor.scan
+			if table == "benchmarks" {
+				after = cu.cursor.benchmark
+			}
+			rows, err := s.tx.Query(`
+				SELECT r.id, h.id, h.modified
+				FROM hdb_`+table+`_`+s.network+` r
+				JOIN hdb_hosts_`+s.network+` h
+				ON r.public_key = h.public_key
+				WHERE r.id > ?
+				ORDER BY r.id ASC
+				LIMIT ?
+			`, after, limit)
+			if err != nil {
+				return utils.AddContext(err, "couldn't query "+table)
+			}
+			for rows.Next() {
+				var id int64
+				var host int
+				var modified int64
+				if err := rows.Scan(&id, &host, &modified); err != nil {
+					rows.Close()
+					return utils.AddContext(err, "couldn't decode record ID")
+				}
+				if !cu.next.covers(modified, host) {
+					break
+				}
+				if table == "scans" {
+					cu.pending.scans = append(cu.pending.scans, id)
+					cu.next.scan = id
+				} else {
+					cu.pending.benchmarks = append(cu.pending.benchmarks, id)
+					cu.next.benchmark = id
+				}
+			}
+			rows.Close()
+		}
+
+		return s.saveConsumer(consumer, cu)
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.consumers == nil {
+		s.consumers = make(map[string]*consumerUpdates)
+	}
+	s.consumers[consumer] = &cu
+	return nil
+}
+
+// streamConsumerUpdates passes the records of the batch of updates of the
+// named consumer to emit one by one, and returns the number of the records
+// after the batch. The records are collected first, so that the store
+// isn't locked while emit is running.
+func (s *hostDBStore) streamConsumerUpdates(consumer string, id UpdateID, emit func(UpdateItem) error) (backlog int, err error) {
+	if s.tx == nil {
+		s.log.Error("there is no transaction", zap.String("network", s.network))
+		return 0, errors.New("no database transaction")
+	}
+
+	items, backlog, err := s.consumerUpdateItems(consumer, id)
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		if err := emit(item); err != nil {
+			return 0, err
+		}
+	}
+
+	return backlog, nil
+}
+
+// consumerUpdateItems returns the records of the batch of updates of the
+// named consumer and the number of the records after the batch.
+func (s *hostDBStore) consumerUpdateItems(consumer string, id UpdateID) (items []UpdateItem, backlog int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending pendingUpdates
+	var next updateCursor
+	if cu, ok := s.consumers[consumer]; ok {
+		next = cu.cursor
+		if cu.pending.id == id {
+			pending, next = cu.pending, cu.next
+		}
+	}
+
+	items, err = s.updateItems(pending)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Count the rows after the batch.
+	err = s.tx.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM hdb_hosts_`+s.network+` WHERE modified > ? OR (modified = ? AND id > ?)) +
+			(SELECT COUNT(*) FROM hdb_scans_`+s.network+` WHERE id > ?) +
+			(SELECT COUNT(*) FROM hdb_benchmarks_`+s.network+` WHERE id > ?)
+	`, next.modified, next.modified, next.host, next.scan, next.benchmark).Scan(&backlog)
+	if err != nil {
+		return nil, 0, utils.AddContext(err, "couldn't count pending updates")
+	}
+
+	return
+}
+
+// finalizeConsumerUpdates moves the cursor of the named consumer past the
+// batch after the consumer confirms the receipt. Confirming a batch again
+// has no effect.
+func (s *hostDBStore) finalizeConsumerUpdates(consumer string, id UpdateID) error {
+	if s.tx == nil {
+		s.log.Error("there is no transaction", zap.String("network", s.network))
+		return errors.New("no database transaction")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.consumers[consumer]
+	if !ok || id == 0 || id != c.pending.id {
+		return nil
+	}
+
+	cu := consumerUpdates{acked: id, cursor: c.next, next: c.next}
+	if err := s.commitWithRetry(func() error {
+		return s.saveConsumer(consumer, cu)
+	}); err != nil {
+		return err
+	}
+
+	s.consumers[consumer] = &cu
+	return nil
+}
+
+// nextConsumerUpdates returns the ID of the batch of updates to send to
+// the named consumer, like nextUpdates does for the default consumer.
+func (hdb *HostDB) nextConsumerUpdates(consumer string, limit int) (UpdateID, error) {
+	hdb.updatesMu.Lock()
+	defer hdb.updatesMu.Unlock()
+
+	acked, pending := hdb.s.consumerIDs(consumer)
+	ackedZen, pendingZen := hdb.sZen.consumerIDs(consumer)
+	if id := max(pending, pendingZen); id != 0 {
+		return id, nil
+	}
+
+	id := max(acked, ackedZen) + 1
+	if err := hdb.s.pickConsumerUpdates(consumer, id, limit); err != nil {
+		return 0, err
+	}
+	if err := hdb.sZen.pickConsumerUpdates(consumer, id, limit); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
@@ -1,24 +1,45 @@
 package hostdb
 
 import (
+	"context"
+	"errors"
+	"math"
 	"time"
 
 	"github.com/mike76-dev/hostscore/internal/utils"
+	"github.com/mike76-dev/hostscore/rhp"
 	"github.com/mike76-dev/hostscore/wallet"
 	"go.sia.tech/core/consensus"
 	rhpv2 "go.sia.tech/core/rhp/v2"
+	rhpv3 "go.sia.tech/core/rhp/v3"
 	"go.sia.tech/core/types"
+	"go.uber.org/zap"
 )
 
+// renewWindow is the number of blocks before the revision deadline at
+// which a contract is renewed. The hosts reject any revisions, including
+// the renewals, in the last 144 blocks before the proof window.
+const renewWindow = 72
+
+// benchmarkDataSize returns the amount of data that is uploaded to a host
+// over the lifetime of a contract.
+func benchmarkDataSize(numSectors int, interval time.Duration) uint64 {
+	// A block is mined every 10 minutes.
+	numBenchmarks := uint64(time.Duration(contractDuration) * 10 * time.Minute / interval)
+	if numBenchmarks == 0 {
+		numBenchmarks = 1
+	}
+	return uint64(numSectors*rhpv2.SectorSize) * numBenchmarks
+}
+
 // calculateFunding calculates the funding of a benchmarking contract.
-func calculateFunding(settings rhpv2.HostSettings, txnFee types.Currency) (funding, collateral types.Currency) {
+func calculateFunding(settings rhpv2.HostSettings, txnFee types.Currency, numSectors int, interval time.Duration) (funding, collateral types.Currency) {
 	contractCost := settings.ContractPrice
 	downloadCost := settings.DownloadBandwidthPrice
 	uploadCost := settings.UploadBandwidthPrice
 	storageCost := settings.StoragePrice
 
-	numBenchmarks := contractDuration / (6 * benchmarkInterval / time.Hour)
-	dataSize := benchmarkBatchSize * numBenchmarks
+	dataSize := benchmarkDataSize(numSectors, interval)
 
 	downloadCost = downloadCost.Mul64(uint64(dataSize))
 	uploadCost = uploadCost.Mul64(uint64(dataSize))
@@ -35,8 +56,9 @@ func calculateFunding(settings rhpv2.HostSettings, txnFee types.Currency) (fundi
 }
 
 // prepareContractFormation creates a new contract and a formation
-// transaction set.
-func (hdb *HostDB) prepareContractFormation(host *HostDBEntry) ([]types.Transaction, error) {
+// transaction set. The cost of the formation is reserved from the daily
+// budget and returned, so that it can be released if the formation fails.
+func (hdb *HostDB) prepareContractFormation(host *HostDBEntry, numSectors int) ([]types.Transaction, types.Currency, error) {
 	if host.Network != "mainnet" && host.Network != "zen" {
 		panic("wrong host network")
 	}
@@ -57,7 +79,7 @@ func (hdb *HostDB) prepareContractFormation(host *HostDBEntry) ([]types.Transact
 	ourKey := hdb.w.Key(host.Network)
 	ourAddr := hdb.w.Address(host.Network)
 
-	funding, collateral := calculateFunding(settings, txnFee.Mul64(2048))
+	funding, collateral := calculateFunding(settings, txnFee.Mul64(2048), numSectors, hdb.benchmarkInterval())
 	fc := rhpv2.PrepareContractFormation(ourKey.PublicKey(), host.PublicKey, funding, collateral, blockHeight+contractDuration, settings, ourAddr)
 	cost := rhpv2.ContractFormationCost(state, fc, settings.ContractPrice)
 
@@ -68,13 +90,181 @@ func (hdb *HostDB) prepareContractFormation(host *HostDBEntry) ([]types.Transact
 	txn.MinerFees = []types.Currency{txnFee}
 	cost = cost.Add(txnFee)
 
+	if err := hdb.budget.reserve(host.Network, cost); err != nil {
+		return nil, types.ZeroCurrency, err
+	}
 	parents, toSign, err := hdb.w.Fund(host.Network, &txn, cost, true)
 	if err != nil {
-		return nil, utils.AddContext(err, "unable to fund transaction")
+		hdb.budget.release(host.Network, cost)
+		return nil, types.ZeroCurrency, utils.AddContext(err, "unable to fund transaction")
 	}
 
 	cf := wallet.ExplicitCoveredFields(txn)
 	hdb.w.Sign(host.Network, &txn, toSign, cf)
 
-	return append(parents, txn), nil
+	return append(parents, txn), cost, nil
+}
+
+// prepareContractRenewal creates a renewal transaction set, which contains
+// the final revision of the old contract and the new contract. The
+// transaction is funded but not signed yet, because the host still needs
+// to add its inputs. The cost of the renewal is reserved from the daily
+// budget and returned, so that it can be released if the renewal fails.
+func (hdb *HostDB) prepareContractRenewal(host *HostDBEntry, rev types.FileContractRevision, pt rhpv3.HostPriceTable, numSectors int) ([]types.Transaction, []types.Hash256, types.Currency, error) {
+	if host.Network != "mainnet" && host.Network != "zen" {
+		panic("wrong host network")
+	}
+
+	var blockHeight uint64
+	var state consensus.State
+	var txnFee types.Currency
+	if host.Network == "zen" {
+		blockHeight = hdb.sZen.tip.Height
+		state = hdb.cmZen.TipState()
+		txnFee = hdb.cmZen.RecommendedFee().Mul64(4)
+	} else {
+		blockHeight = hdb.s.tip.Height
+		state = hdb.cm.TipState()
+		txnFee = hdb.cm.RecommendedFee().Mul64(4)
+	}
+	settings := host.Settings
+	ourAddr := hdb.w.Address(host.Network)
+
+	// The final revision lets the host keep the collateral of the old
+	// contract without submitting a storage proof.
+	finalRevision := rev
+	finalRevision.MissedProofOutputs = finalRevision.ValidProofOutputs
+	finalRevision.RevisionNumber = math.MaxUint64
+
+	funding, _ := calculateFunding(settings, txnFee.Mul64(2048), numSectors, hdb.benchmarkInterval())
+	dataSize := benchmarkDataSize(numSectors, hdb.benchmarkInterval())
+	fc, basePrice, err := rhpv3.PrepareContractRenewal(rev, settings.Address, ourAddr, funding, types.ZeroCurrency, pt, dataSize, blockHeight+contractDuration)
+	if err != nil {
+		return nil, nil, types.ZeroCurrency, utils.AddContext(err, "couldn't prepare renewal")
+	}
+
+	txn := types.Transaction{
+		FileContracts:         []types.FileContract{fc},
+		FileContractRevisions: []types.FileContractRevision{finalRevision},
+	}
+	txnFee = txnFee.Mul64(state.TransactionWeight(txn))
+	txn.MinerFees = []types.Currency{txnFee}
+	cost := rhpv3.ContractRenewalCost(state, pt, fc, txnFee, basePrice)
+
+	if err := hdb.budget.reserve(host.Network, cost); err != nil {
+		return nil, nil, types.ZeroCurrency, err
+	}
+	parents, toSign, err := hdb.w.Fund(host.Network, &txn, cost, true)
+	if err != nil {
+		hdb.budget.release(host.Network, cost)
+		return nil, nil, types.ZeroCurrency, utils.AddContext(err, "unable to fund transaction")
+	}
+
+	return append(parents, txn), toSign, cost, nil
+}
+
+// renewContract renews the current contract with the host. The latest
+// revision is fetched first, because the final revision must be built on
+// top of it.
+func (hdb *HostDB) renewContract(ctx context.Context, host *HostDBEntry, addr string, numSectors int, limits hostDBPriceLimits) (rev rhpv2.ContractRevision, txnSet []types.Transaction, cost types.Currency, err error) {
+	key := hdb.w.Key(host.Network)
+	err = rhp.WithTransportV3(ctx, addr, host.PublicKey, func(t *rhpv3.Transport) error {
+		latest, err := rhp.RPCLatestRevision(ctx, t, host.Revision.ParentID)
+		if err != nil {
+			return utils.AddContext(err, "unable to get latest revision")
+		}
+
+		var renterTxnSet []types.Transaction
+		var toSign []types.Hash256
+		rev, txnSet, err = rhp.RPCRenewContract(ctx, t, key, latest, func(pt rhpv3.HostPriceTable) ([]types.Transaction, error) {
+			if err := checkGouging(nil, &pt, limits); err != nil {
+				return nil, err
+			}
+			var err error
+			renterTxnSet, toSign, cost, err = hdb.prepareContractRenewal(host, latest, pt, numSectors)
+			return renterTxnSet, err
+		}, func(txn *types.Transaction, cf types.CoveredFields) {
+			hdb.w.Sign(host.Network, txn, toSign, cf)
+		})
+		if err != nil {
+			if renterTxnSet != nil {
+				hdb.w.Release(renterTxnSet...)
+				hdb.budget.release(host.Network, cost)
+			}
+			if errors.Is(err, errOverBudget) {
+				return err
+			}
+			return utils.AddContext(err, "couldn't renew contract")
+		}
+
+		return nil
+	})
+	return
+}
+
+// The states of a benchmarking contract.
+const (
+	contractActive   = "active"
+	contractRenewed  = "renewed"
+	contractReplaced = "replaced"
+)
+
+// recordContract saves the new contract with the host. The previous
+// contract, if any, is marked as renewed or as replaced by a newly formed
+// one.
+func (hdb *HostDB) recordContract(host *HostDBEntry, rev types.FileContractRevision, previous types.FileContractID, renewed bool, cost types.Currency) {
+	var renewedFrom []byte
+	if renewed {
+		renewedFrom = previous[:]
+	}
+	_, err := hdb.db.Exec(`
+		INSERT INTO hdb_contracts (
+			id,
+			network,
+			public_key,
+			formed_at,
+			window_start,
+			window_end,
+			renewed_from,
+			renewed_to,
+			state,
+			cost
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		rev.ParentID[:],
+		host.Network,
+		host.PublicKey[:],
+		time.Now().Unix(),
+		rev.WindowStart,
+		rev.WindowEnd,
+		renewedFrom,
+		nil,
+		contractActive,
+		encodeCurrency(cost),
+	)
+	if err != nil {
+		hdb.log.Error("couldn't save contract", zap.String("network", host.Network), zap.Stringer("id", rev.ParentID), zap.Error(err))
+		return
+	}
+
+	if (previous == types.FileContractID{}) {
+		return
+	}
+	if renewed {
+		_, err = hdb.db.Exec(`
+			UPDATE hdb_contracts
+			SET state = ?, renewed_to = ?
+			WHERE id = ?
+		`, contractRenewed, rev.ParentID[:], previous[:])
+	} else {
+		_, err = hdb.db.Exec(`
+			UPDATE hdb_contracts
+			SET state = ?
+			WHERE id = ?
+		`, contractReplaced, previous[:])
+	}
+	if err != nil {
+		hdb.log.Error("couldn't update contract", zap.String("network", host.Network), zap.Stringer("id", previous), zap.Error(err))
+	}
 }
@@ -0,0 +1,254 @@
+package hostdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// diagnosticCooldown is the minimum time between two traceroutes to
+	// the same host.
+	diagnosticCooldown = 6 * time.Hour
+
+	// diagnosticTimeout is the time after which a traceroute is killed.
+	diagnosticTimeout = 2 * time.Minute
+
+	// maxDiagnostics is the number of traceroute results kept in memory.
+	// The portal pulls them long before they are dropped.
+	maxDiagnostics = 1000
+
+	// maxDiagnosticThreads is the maximum number of traceroutes run in
+	// parallel.
+	maxDiagnosticThreads = 5
+
+	// maxTraceHops is the maximum TTL of a traceroute.
+	maxTraceHops = 30
+)
+
+// ErrUnknownHost is returned if a diagnostic is requested for a host that
+// is not in the database.
+var ErrUnknownHost = errors.New("unknown host")
+
+// A TraceHop is one hop of a traceroute. Address is empty and RTT is zero
+// if the hop did not respond.
+type TraceHop struct {
+	TTL     int           `json:"ttl"`
+	Address string        `json:"address,omitempty"`
+	RTT     time.Duration `json:"rtt,omitempty"`
+}
+
+// A Diagnostic is the result of a traceroute from the node to a host. It
+// helps to tell a host outage from a routing issue between the node and
+// the host.
+type Diagnostic struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Timestamp time.Time       `json:"timestamp"`
+	Target    string          `json:"target"`
+	Reached   bool            `json:"reached"`
+	Hops      []TraceHop      `json:"hops"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// diagnostics keeps the recent traceroute results in memory.
+type diagnostics struct {
+	mu      sync.Mutex
+	results []Diagnostic
+	last    map[string]map[types.PublicKey]time.Time
+	sem     chan struct{}
+}
+
+func newDiagnostics() *diagnostics {
+	return &diagnostics{
+		last: map[string]map[types.PublicKey]time.Time{
+			"mainnet": make(map[types.PublicKey]time.Time),
+			"zen":     make(map[types.PublicKey]time.Time),
+		},
+		sem: make(chan struct{}, maxDiagnosticThreads),
+	}
+}
+
+// reserve marks the host as being traced. It returns false if the host
+// was traced within the cooldown period.
+func (d *diagnostics) reserve(network string, pk types.PublicKey) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.last[network][pk]; ok && time.Since(last) < diagnosticCooldown {
+		return false
+	}
+	d.last[network][pk] = time.Now()
+	return true
+}
+
+// add stores the result, dropping the oldest one if there are too many.
+// The result is timestamped on completion, so that it can be pulled
+// incrementally even if it took longer than the ones started after it.
+func (d *diagnostics) add(diag Diagnostic) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	diag.Timestamp = time.Now()
+	d.results = append(d.results, diag)
+	if len(d.results) > maxDiagnostics {
+		d.results = d.results[len(d.results)-maxDiagnostics:]
+	}
+	for network, hosts := range d.last {
+		for pk, last := range hosts {
+			if time.Since(last) >= diagnosticCooldown {
+				delete(d.last[network], pk)
+			}
+		}
+	}
+}
+
+// parseTraceroute extracts the hops from the output of `traceroute -n -q 1`.
+// The lines look like ` 3  10.0.0.1  12.345 ms` or ` 4  *`.
+func parseTraceroute(output []byte) []TraceHop {
+	var hops []TraceHop
+	sc := bufio.NewScanner(bytes.NewReader(output))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		ttl, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue // header line
+		}
+		hop := TraceHop{TTL: ttl}
+		if fields[1] != "*" {
+			hop.Address = fields[1]
+			if len(fields) >= 4 && fields[3] == "ms" {
+				if ms, err := strconv.ParseFloat(fields[2], 64); err == nil {
+					hop.RTT = time.Duration(ms * float64(time.Millisecond))
+				}
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// traceroute runs a traceroute to the host part of the address.
+func (hdb *HostDB) traceroute(network string, pk types.PublicKey, addr string) Diagnostic {
+	diag := Diagnostic{
+		Network:   network,
+		PublicKey: pk,
+		Hops:      []TraceHop{},
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	diag.Target = host
+
+	path, err := exec.LookPath("traceroute")
+	if err != nil {
+		diag.Error = "traceroute is not installed on the node"
+		return diag
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-hdb.tg.StopChan():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	cmd := exec.CommandContext(ctx, path, "-n", "-q", "1", "-w", "2", "-m", strconv.Itoa(maxTraceHops), host)
+	output, err := cmd.Output()
+	if hops := parseTraceroute(output); len(hops) > 0 {
+		diag.Hops = hops
+	}
+	if err != nil {
+		diag.Error = err.Error()
+		return diag
+	}
+
+	// The trace is complete if the last hop is the target itself.
+	if len(diag.Hops) > 0 {
+		last := diag.Hops[len(diag.Hops)-1].Address
+		if ips, err := net.DefaultResolver.LookupHost(ctx, host); err == nil {
+			for _, ip := range ips {
+				if ip == last {
+					diag.Reached = true
+					break
+				}
+			}
+		}
+	}
+
+	return diag
+}
+
+// RunDiagnostic starts a traceroute to the host in the background. The
+// result can be retrieved with Diagnostics once it is ready. It returns
+// false if the host has been traced recently.
+func (hdb *HostDB) RunDiagnostic(network string, pk types.PublicKey) (bool, error) {
+	s := hdb.s
+	if network == "zen" {
+		s = hdb.sZen
+	}
+	s.mu.Lock()
+	host, exists := s.hosts[pk]
+	var addr string
+	if exists {
+		addr = host.NetAddress
+	}
+	s.mu.Unlock()
+	if !exists {
+		return false, ErrUnknownHost
+	}
+
+	if !hdb.diagnostics.reserve(network, pk) {
+		return false, nil
+	}
+
+	if err := hdb.tg.Add(); err != nil {
+		return false, err
+	}
+	go func() {
+		defer hdb.tg.Done()
+		select {
+		case hdb.diagnostics.sem <- struct{}{}:
+		case <-hdb.tg.StopChan():
+			return
+		}
+		defer func() { <-hdb.diagnostics.sem }()
+
+		diag := hdb.traceroute(network, pk, addr)
+		if diag.Error != "" {
+			hdb.log.Debug("traceroute failed", zap.String("network", network), zap.Stringer("host", pk), zap.String("error", diag.Error))
+		}
+		hdb.diagnostics.add(diag)
+	}()
+
+	return true, nil
+}
+
+// Diagnostics returns the traceroute results of the network obtained
+// after the given time, the oldest first.
+func (hdb *HostDB) Diagnostics(network string, since time.Time) []Diagnostic {
+	hdb.diagnostics.mu.Lock()
+	defer hdb.diagnostics.mu.Unlock()
+	var diags []Diagnostic
+	for _, diag := range hdb.diagnostics.results {
+		if diag.Network == network && diag.Timestamp.After(since) {
+			diags = append(diags, diag)
+		}
+	}
+	return diags
+}
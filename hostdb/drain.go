@@ -0,0 +1,74 @@
+package hostdb
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// drainTimeout is how long the running benchmarks are waited for on
+	// shutdown before they are interrupted.
+	drainTimeout = time.Minute
+
+	// interruptTimeout is how long the interrupted benchmarks are waited
+	// for to save their state.
+	interruptTimeout = 10 * time.Second
+)
+
+// errDraining is returned if a benchmark hasn't started by the time the
+// node is shutting down.
+var errDraining = errors.New("benchmark interrupted by a restart")
+
+// drain stops queuing new scans and benchmarks, drops the queued ones,
+// and waits up to drainTimeout for the running benchmarks to complete.
+func (hdb *HostDB) drain() {
+	hdb.mu.Lock()
+	hdb.draining = true
+	for _, host := range hdb.scanList {
+		delete(hdb.scanMap, host.PublicKey)
+	}
+	for _, host := range hdb.benchmarkList {
+		delete(hdb.scanMap, host.PublicKey)
+	}
+	hdb.scanList = nil
+	hdb.benchmarkList = nil
+	running := hdb.benchmarkThreads
+	hdb.mu.Unlock()
+
+	if running > 0 {
+		hdb.log.Info("waiting for running benchmarks", zap.Int("benchmarks", running))
+	}
+	if !hdb.waitForBenchmarks(drainTimeout) {
+		hdb.mu.Lock()
+		running = hdb.benchmarkThreads
+		hdb.mu.Unlock()
+		hdb.log.Warn("interrupting running benchmarks", zap.Int("benchmarks", running))
+	}
+}
+
+// isDraining returns true if the node is shutting down.
+func (hdb *HostDB) isDraining() bool {
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	return hdb.draining
+}
+
+// waitForBenchmarks waits until no benchmarks are running. It returns
+// false if some are still running after the timeout.
+func (hdb *HostDB) waitForBenchmarks(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		hdb.mu.Lock()
+		running := hdb.benchmarkThreads
+		hdb.mu.Unlock()
+		if running <= 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,97 @@
+package hostdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FailureClass tells who is to blame for a failed scan or benchmark.
+type FailureClass uint8
+
+const (
+	// FailureUnknown is the class of the successful interactions and of
+	// the failures recorded before the classification was introduced.
+	// The latter are treated as the host's failures.
+	FailureUnknown FailureClass = iota
+
+	// FailureHost means that the host failed to respond properly.
+	FailureHost
+
+	// FailureProber means that the interaction failed for a reason on
+	// the side of the node, e.g. a network outage or a lack of funds.
+	FailureProber
+
+	// FailureRestarted means that the interaction was interrupted,
+	// because the node was shutting down.
+	FailureRestarted
+
+	// FailureSkipped means that the benchmark was not run, e.g. because
+	// it would have cost more than the configured ceiling.
+	FailureSkipped
+)
+
+var failureClassNames = []string{"unknown", "host", "prober", "restarted", "skipped"}
+
+// String implements fmt.Stringer.
+func (fc FailureClass) String() string {
+	if int(fc) < len(failureClassNames) {
+		return failureClassNames[fc]
+	}
+	return failureClassNames[FailureUnknown]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (fc FailureClass) MarshalText() ([]byte, error) {
+	return []byte(fc.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (fc *FailureClass) UnmarshalText(b []byte) error {
+	for i, name := range failureClassNames {
+		if string(b) == name {
+			*fc = FailureClass(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown failure class: %s", b)
+}
+
+// HostFault returns true if the failure counts against the host.
+func (fc FailureClass) HostFault() bool {
+	return fc != FailureProber && fc != FailureRestarted && fc != FailureSkipped
+}
+
+// proberErrors are the parts of the error messages that point at a
+// problem on the side of the node rather than of the host.
+var proberErrors = []string{
+	"insufficient balance",
+	"insufficient renter funds",
+	"network is unreachable",
+	"too many open files",
+	"cannot assign requested address",
+	"temporary failure in name resolution",
+	"server misbehaving",
+}
+
+// classifyFailure determines who is to blame for the error of a scan or
+// a benchmark. A failure while the node has no peers is most likely
+// caused by an outage of the node's own network.
+func (hdb *HostDB) classifyFailure(network string, err error) FailureClass {
+	if err == nil {
+		return FailureUnknown
+	}
+	if errors.Is(err, errTooExpensive) || errors.Is(err, errLowFunds) || errors.Is(err, errOverBudget) {
+		return FailureSkipped
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range proberErrors {
+		if strings.Contains(msg, s) {
+			return FailureProber
+		}
+	}
+	if !hdb.online(network) {
+		return FailureProber
+	}
+	return FailureHost
+}
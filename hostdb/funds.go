@@ -0,0 +1,54 @@
+package hostdb
+
+import (
+	"errors"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// skippedPrefix marks the benchmarks that were not run for a reason on
+// our side.
+const skippedPrefix = "skipped: "
+
+// errLowFunds is returned when the wallet balance is below the threshold,
+// so that no new contracts are formed.
+var errLowFunds = errors.New(skippedPrefix + "insufficient funds")
+
+// minBalance returns the wallet balance below which no new benchmarking
+// contracts are formed. Zero means no threshold.
+func (hdb *HostDB) minBalance(network string) types.Currency {
+	if network == "zen" {
+		return hdb.benchmarkConfig.MinBalanceZen
+	}
+	return hdb.benchmarkConfig.MinBalance
+}
+
+// LowFunds returns true if the wallet balance of the network is below
+// the configured threshold.
+func (hdb *HostDB) LowFunds(network string) bool {
+	threshold := hdb.minBalance(network)
+	if threshold.IsZero() {
+		return false
+	}
+	balance, _, err := hdb.w.Balance(network)
+	if err != nil {
+		hdb.log.Error("couldn't get wallet balance", zap.String("network", network), zap.Error(err))
+		return false
+	}
+	low := balance.Cmp(threshold) < 0
+
+	// Only warn when the state changes, not on every benchmark.
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	if low != hdb.lowFunds[network] {
+		if low {
+			hdb.log.Warn("wallet balance is low, benchmarks are suspended until the wallet is refilled", zap.String("network", network), zap.Stringer("balance", balance), zap.Stringer("threshold", threshold), zap.Stringer("address", hdb.w.Address(network)))
+		} else {
+			hdb.log.Info("wallet refilled, benchmarks resumed", zap.String("network", network), zap.Stringer("balance", balance))
+		}
+		hdb.lowFunds[network] = low
+	}
+
+	return low
+}
@@ -1,7 +1,6 @@
 package hostdb
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"path/filepath"
@@ -9,6 +8,7 @@ import (
 	"time"
 
 	"github.com/mike76-dev/hostscore/external"
+	"github.com/mike76-dev/hostscore/internal/sqldb"
 	siasync "github.com/mike76-dev/hostscore/internal/sync"
 	"github.com/mike76-dev/hostscore/internal/utils"
 	"github.com/mike76-dev/hostscore/internal/walletutil"
@@ -19,7 +19,6 @@ import (
 	"go.sia.tech/coreutils/chain"
 	"go.sia.tech/coreutils/syncer"
 	"go.uber.org/zap"
-	"lukechampine.com/frand"
 )
 
 // A HostDBEntry represents one host entry in the HostDB. It
@@ -44,6 +43,8 @@ type HostDBEntry struct {
 	Revision      types.FileContractRevision `json:"-"`
 	Settings      rhpv2.HostSettings         `json:"settings"`
 	PriceTable    rhpv3.HostPriceTable       `json:"priceTable"`
+	Compliance    ContractCompliance         `json:"compliance"`
+	Preferences   BenchmarkPreferences       `json:"preferences"`
 	external.IPInfo
 }
 
@@ -63,10 +64,81 @@ type HostScan struct {
 	Success    bool                 `json:"success"`
 	Latency    time.Duration        `json:"latency"`
 	Error      string               `json:"error"`
+	Failure    FailureClass         `json:"failure"`
+	ClockSkew  int64                `json:"clockSkew"`
+	ValidUntil time.Time            `json:"validUntil"`
+	InvalidSig bool                 `json:"invalidSignature"`
+	IPv4       AddressScan          `json:"ipv4"`
+	IPv6       AddressScan          `json:"ipv6"`
+	Timings    ScanTimings          `json:"timings"`
 	Settings   rhpv2.HostSettings   `json:"settings"`
 	PriceTable rhpv3.HostPriceTable `json:"priceTable"`
 }
 
+// Reachability shows if a host could be reached over an address family.
+type Reachability uint8
+
+const (
+	// ReachabilityUnknown means that the address family wasn't probed.
+	ReachabilityUnknown Reachability = iota
+
+	// NoAddress means that the host has no address of the family.
+	NoAddress
+
+	// Unreachable means that the handshake over the family failed.
+	Unreachable
+
+	// Reachable means that the handshake over the family succeeded.
+	Reachable
+)
+
+var reachabilityNames = []string{"unknown", "none", "unreachable", "reachable"}
+
+// String implements fmt.Stringer.
+func (r Reachability) String() string {
+	if int(r) < len(reachabilityNames) {
+		return reachabilityNames[r]
+	}
+	return reachabilityNames[ReachabilityUnknown]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r Reachability) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (r *Reachability) UnmarshalText(b []byte) error {
+	for i, name := range reachabilityNames {
+		if string(b) == name {
+			*r = Reachability(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown reachability: %s", b)
+}
+
+// An AddressScan contains the result of probing a host over a single
+// address family.
+type AddressScan struct {
+	Reachability Reachability  `json:"reachability"`
+	Latency      time.Duration `json:"latency"`
+}
+
+// ScanTimings splits the latency of a scan into the connection, the
+// handshake, and the settings RPC. Unlike the RPC, the first two don't
+// depend on the host's hardware.
+type ScanTimings struct {
+	Dial      time.Duration `json:"dial"`
+	Handshake time.Duration `json:"handshake"`
+	Settings  time.Duration `json:"settings"`
+}
+
+// Network returns the part of the latency caused by the network.
+func (st ScanTimings) Network() time.Duration {
+	return st.Dial + st.Handshake
+}
+
 // ScanHistory combines the scan history with the host's public key.
 type ScanHistory struct {
 	HostScan
@@ -81,9 +153,12 @@ type HostBenchmark struct {
 	Timestamp     time.Time     `json:"timestamp"`
 	Success       bool          `json:"success"`
 	Error         string        `json:"error"`
+	Failure       FailureClass  `json:"failure"`
 	UploadSpeed   float64       `json:"uploadSpeed"`
 	DownloadSpeed float64       `json:"downloadSpeed"`
 	TTFB          time.Duration `json:"ttfb"`
+	Uploaded      uint64        `json:"uploaded"`   // bytes
+	Downloaded    uint64        `json:"downloaded"` // bytes
 }
 
 // BenchmarkHistory combines the benchmark history with the host's public key.
@@ -94,8 +169,23 @@ type BenchmarkHistory struct {
 	Node      string          `json:"node"`
 }
 
-// UpdateID is the ID of a HostUpdate.
-type UpdateID = [8]byte
+const (
+	// DefaultUpdatesLimit is the default maximum number of rows of each
+	// kind in a batch of updates.
+	DefaultUpdatesLimit = 1000
+
+	// MaxUpdatesLimit is the largest batch size a client may request.
+	MaxUpdatesLimit = 10000
+
+	// MaxStreamUpdatesLimit is the largest batch size a client may request
+	// when streaming the updates. It is higher than MaxUpdatesLimit, because
+	// a stream is not buffered in memory.
+	MaxStreamUpdatesLimit = 100000
+)
+
+// UpdateID is the sequence number of a batch of updates. The sequence
+// numbers start at one and only grow.
+type UpdateID = uint64
 
 // HostUpdates represents a batch of updates sent to the client.
 type HostUpdates struct {
@@ -103,6 +193,21 @@ type HostUpdates struct {
 	Hosts      []HostDBEntry      `json:"hosts"`
 	Scans      []ScanHistory      `json:"scans"`
 	Benchmarks []BenchmarkHistory `json:"benchmarks"`
+	Backlog    int                `json:"backlog"`
+}
+
+// UpdateItem is a single line of a stream of updates. Exactly one of Host,
+// Scan, and Benchmark is set, except for the first line, which carries the
+// sequence number of the batch, and the last line, which carries the ID
+// of the batch and the backlog, or an error if the stream was aborted.
+type UpdateItem struct {
+	Seq       UpdateID          `json:"seq,omitempty"`
+	Host      *HostDBEntry      `json:"host,omitempty"`
+	Scan      *ScanHistory      `json:"scan,omitempty"`
+	Benchmark *BenchmarkHistory `json:"benchmark,omitempty"`
+	ID        *UpdateID         `json:"id,omitempty"`
+	Backlog   int               `json:"backlog,omitempty"`
+	Error     string            `json:"error,omitempty"`
 }
 
 // The HostDB is a database of hosts.
@@ -119,30 +224,105 @@ type HostDB struct {
 	log            *zap.Logger
 	closeFn        func()
 
-	tg siasync.ThreadGroup
-	mu sync.Mutex
+	tg        siasync.ThreadGroup
+	mu        sync.Mutex
+	updatesMu sync.Mutex
+
+	signalMu      sync.Mutex
+	updatesSignal chan struct{}
 
 	benchmarking     bool
+	draining         bool
 	scanList         []*HostDBEntry
 	benchmarkList    []*HostDBEntry
 	scanMap          map[types.PublicKey]bool
 	scanThreads      int
 	benchmarkThreads int
+	scansDone        uint64
+	benchmarksDone   uint64
 	priceLimits      hostDBPriceLimits
 	blockedDomains   *blockedDomains
+	optOuts          *optOuts
+	allowlist        *allowlist
+	ranks            *hostRanks
+	budget           *benchmarkBudget
+	hostSpending     *hostSpending
+	probes           *latencyProbes
+	diagnostics      *diagnostics
+	onDemand         *onDemandScans
+	lowFunds         map[string]bool
+	benchmarkConfig  BenchmarkConfig
+	db               *sqldb.DB
+}
+
+// Stats contains the runtime statistics of the HostDB.
+type Stats struct {
+	Hosts            map[string]int
+	ScanQueue        map[string]int
+	BenchmarkQueue   map[string]int
+	ScanThreads      int
+	BenchmarkThreads int
+	ScansDone        uint64
+	BenchmarksDone   uint64
 }
 
-// RecentUpdates returns a list of the most recent updates since the last retrieval.
-func (hdb *HostDB) RecentUpdates() (HostUpdates, error) {
-	var id UpdateID
-	frand.Read(id[:])
+// Stats returns the runtime statistics of the HostDB.
+func (hdb *HostDB) Stats() Stats {
+	stats := Stats{
+		Hosts:          make(map[string]int),
+		ScanQueue:      make(map[string]int),
+		BenchmarkQueue: make(map[string]int),
+	}
+
+	hdb.mu.Lock()
+	for _, host := range hdb.scanList {
+		stats.ScanQueue[host.Network]++
+	}
+	for _, host := range hdb.benchmarkList {
+		stats.BenchmarkQueue[host.Network]++
+	}
+	stats.ScanThreads = hdb.scanThreads
+	stats.BenchmarkThreads = hdb.benchmarkThreads
+	stats.ScansDone = hdb.scansDone
+	stats.BenchmarksDone = hdb.benchmarksDone
+	hdb.mu.Unlock()
+
+	hdb.s.mu.Lock()
+	stats.Hosts["mainnet"] = len(hdb.s.hosts)
+	hdb.s.mu.Unlock()
+	hdb.sZen.mu.Lock()
+	stats.Hosts["zen"] = len(hdb.sZen.hosts)
+	hdb.sZen.mu.Unlock()
+
+	return stats
+}
+
+// RecentUpdates returns a list of the most recent updates since the last
+// retrieval. The limit is applied to each kind of rows in each network; if
+// it is zero, DefaultUpdatesLimit is used. A batch not confirmed yet is
+// returned again unchanged, regardless of the limit. A named consumer
+// gets the updates after its own cursor, see consumerUpdates; an empty
+// name stands for the default consumer.
+func (hdb *HostDB) RecentUpdates(consumer string, limit int) (HostUpdates, error) {
+	if err := ValidateConsumer(consumer); err != nil {
+		return HostUpdates{}, err
+	}
+	if limit <= 0 {
+		limit = DefaultUpdatesLimit
+	}
+	limit = min(limit, MaxUpdatesLimit)
+
+	id, err := hdb.nextBatch(consumer, limit)
+	if err != nil {
+		return HostUpdates{}, err
+	}
 
-	updates, err := hdb.s.getRecentUpdates(id)
+	updates, err := hdb.s.getRecentUpdates(consumer, id)
 	if err != nil {
 		return HostUpdates{}, err
 	}
 
-	updatesZen, err := hdb.sZen.getRecentUpdates(id)
+	updatesZen, err := hdb.sZen.getRecentUpdates(consumer, id)
 	if err != nil {
 		return HostUpdates{}, err
 	}
@@ -150,20 +330,70 @@ func (hdb *HostDB) RecentUpdates() (HostUpdates, error) {
 	updates.Hosts = append(updates.Hosts, updatesZen.Hosts...)
 	updates.Scans = append(updates.Scans, updatesZen.Scans...)
 	updates.Benchmarks = append(updates.Benchmarks, updatesZen.Benchmarks...)
+	updates.Backlog += updatesZen.Backlog
 
 	return updates, nil
 }
 
-// FinalizeUpdates updates the timestamps after the client confirms the data receipt.
-func (hdb *HostDB) FinalizeUpdates(id UpdateID) error {
+// StreamUpdates passes the most recent updates since the last retrieval to
+// emit one by one, preceded by the sequence number of the batch. The
+// records are collected before they are passed to emit, so that the
+// stores aren't locked while a slow client is reading them. The limit is applied to each kind of rows
+// in each network; if it is zero, DefaultUpdatesLimit is used. A batch
+// not confirmed yet is streamed again unchanged, regardless of the limit.
+// The returned ID must be passed to FinalizeUpdates after the client
+// confirms the receipt. The consumer is the same as in RecentUpdates.
+func (hdb *HostDB) StreamUpdates(consumer string, limit int, emit func(UpdateItem) error) (id UpdateID, backlog int, err error) {
+	if err := ValidateConsumer(consumer); err != nil {
+		return 0, 0, err
+	}
+	if limit <= 0 {
+		limit = DefaultUpdatesLimit
+	}
+	limit = min(limit, MaxStreamUpdatesLimit)
+
+	id, err = hdb.nextBatch(consumer, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := emit(UpdateItem{Seq: id}); err != nil {
+		return 0, 0, err
+	}
+
+	backlog, err = hdb.s.streamUpdates(consumer, id, emit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	backlogZen, err := hdb.sZen.streamUpdates(consumer, id, emit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return id, backlog + backlogZen, nil
+}
+
+// FinalizeUpdates marks the batch of updates as received after the client
+// confirms the receipt. Confirming a batch again has no effect.
+func (hdb *HostDB) FinalizeUpdates(consumer string, id UpdateID) error {
+	if err := ValidateConsumer(consumer); err != nil {
+		return err
+	}
+	if consumer != "" {
+		return utils.ComposeErrors(hdb.s.finalizeConsumerUpdates(consumer, id), hdb.sZen.finalizeConsumerUpdates(consumer, id))
+	}
 	return utils.ComposeErrors(hdb.s.finalizeUpdates(id), hdb.sZen.finalizeUpdates(id))
 }
 
 // Close shuts down HostDB.
 func (hdb *HostDB) Close() {
+	hdb.drain()
 	if err := hdb.tg.Stop(); err != nil {
 		hdb.log.Error("unable to stop threads", zap.Error(err))
 	}
+	// Let the interrupted benchmarks save their state before the stores
+	// are closed.
+	hdb.waitForBenchmarks(interruptTimeout)
 	hdb.unsubscribe()
 	hdb.unsubscribeZen()
 	hdb.s.close()
@@ -172,7 +402,7 @@ func (hdb *HostDB) Close() {
 }
 
 // loadBlockedDomains loads the list of blocked domains.
-func loadBlockedDomains(db *sql.DB) (*blockedDomains, error) {
+func loadBlockedDomains(db *sqldb.DB) (*blockedDomains, error) {
 	var domains []string
 	rows, err := db.Query("SELECT dom FROM hdb_domains")
 	if err != nil {
@@ -206,7 +436,7 @@ func syncStore(store *hostDBStore, cm *chain.Manager, index types.ChainIndex) er
 }
 
 // NewHostDB returns a new HostDB.
-func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager, syncer *syncer.Syncer, syncerZen *syncer.Syncer, w *walletutil.Wallet) (*HostDB, <-chan error) {
+func NewHostDB(db *sqldb.DB, dir string, cm *chain.Manager, cmZen *chain.Manager, syncer *syncer.Syncer, syncerZen *syncer.Syncer, w *walletutil.Wallet, bc BenchmarkConfig, ac AllowlistConfig) (*HostDB, <-chan error) {
 	errChan := make(chan error, 1)
 	l, closeFn, err := persist.NewFileLogger(filepath.Join(dir, "hostdb.log"))
 	if err != nil {
@@ -219,6 +449,30 @@ func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager,
 		return nil, errChan
 	}
 
+	oo, err := loadOptOuts(db)
+	if err != nil {
+		errChan <- err
+		return nil, errChan
+	}
+
+	al, err := loadAllowlist(db, ac)
+	if err != nil {
+		errChan <- err
+		return nil, errChan
+	}
+
+	budget, err := loadBudget(db, l, bc.Budget, bc.BudgetZen)
+	if err != nil {
+		errChan <- err
+		return nil, errChan
+	}
+
+	hs, err := loadHostSpending(db, l)
+	if err != nil {
+		errChan <- err
+		return nil, errChan
+	}
+
 	store, tip, err := newHostDBStore(db, l, "mainnet", domains)
 	if err != nil {
 		errChan <- err
@@ -231,16 +485,17 @@ func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager,
 	}
 
 	hdb := &HostDB{
-		syncer:    syncer,
-		syncerZen: syncerZen,
-		cm:        cm,
-		cmZen:     cmZen,
-		w:         w,
-		s:         store,
-		sZen:      storeZen,
-		log:       l,
-		closeFn:   closeFn,
-		scanMap:   make(map[types.PublicKey]bool),
+		syncer:        syncer,
+		syncerZen:     syncerZen,
+		cm:            cm,
+		cmZen:         cmZen,
+		w:             w,
+		s:             store,
+		sZen:          storeZen,
+		log:           l,
+		closeFn:       closeFn,
+		scanMap:       make(map[types.PublicKey]bool),
+		updatesSignal: make(chan struct{}),
 		priceLimits: hostDBPriceLimits{
 			maxContractPrice:     maxContractPrice,
 			maxUploadPrice:       maxUploadPriceSC,
@@ -249,7 +504,18 @@ func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager,
 			maxBaseRPCPrice:      maxBaseRPCPriceSC,
 			maxSectorAccessPrice: maxSectorAccessPriceSC,
 		},
-		blockedDomains: domains,
+		blockedDomains:  domains,
+		optOuts:         oo,
+		allowlist:       al,
+		ranks:           newHostRanks(),
+		budget:          budget,
+		hostSpending:    hs,
+		probes:          newLatencyProbes(),
+		diagnostics:     newDiagnostics(),
+		onDemand:        newOnDemandScans(),
+		lowFunds:        make(map[string]bool),
+		benchmarkConfig: bc,
+		db:              db,
 	}
 	hdb.s.hdb = hdb
 	hdb.sZen.hdb = hdb
@@ -315,6 +581,9 @@ func NewHostDB(db *sql.DB, dir string, cm *chain.Manager, cmZen *chain.Manager,
 	// Start the scanning thread.
 	go hdb.scanHosts()
 
+	// Start the latency probes.
+	go hdb.probeHosts()
+
 	// Periodically prune old scans and benchmarks.
 	go hdb.pruneOldRecords()
 
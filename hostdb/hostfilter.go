@@ -2,37 +2,74 @@ package hostdb
 
 import (
 	"net"
+	"slices"
 	"strings"
 	"sync"
+
+	"go.sia.tech/core/types"
 )
 
+// blockedDomains is the blocklist of the HostDB. The entries can be
+// domains, IP addresses, subnets in CIDR notation, or public keys.
 type blockedDomains struct {
+	entries map[string]struct{}
 	domains map[string]struct{}
+	keys    map[types.PublicKey]struct{}
 	mu      sync.Mutex
 }
 
-func newBlockedDomains(domains []string) *blockedDomains {
-	blocked := &blockedDomains{
-		domains: make(map[string]struct{}),
-	}
-	blocked.addDomains(domains)
+func newBlockedDomains(entries []string) *blockedDomains {
+	blocked := &blockedDomains{}
+	blocked.set(entries)
 	return blocked
 }
 
-func (bd *blockedDomains) addDomains(domains []string) {
-	bd.mu.Lock()
-	defer bd.mu.Unlock()
-	for _, domain := range domains {
-		bd.domains[domain] = struct{}{}
+// set replaces the entries of the blocklist. The domains are resolved,
+// so that the hosts announced with their IP addresses are blocked too.
+func (bd *blockedDomains) set(list []string) {
+	entries := make(map[string]struct{})
+	domains := make(map[string]struct{})
+	keys := make(map[types.PublicKey]struct{})
+	for _, entry := range list {
+		entries[entry] = struct{}{}
+		var pk types.PublicKey
+		if pk.UnmarshalText([]byte(entry)) == nil {
+			keys[pk] = struct{}{}
+			continue
+		}
+		domains[entry] = struct{}{}
 
-		addrs, err := net.LookupHost(domain)
+		addrs, err := net.LookupHost(entry)
 		if err != nil {
 			continue
 		}
 		for _, addr := range addrs {
-			bd.domains[addr] = struct{}{}
+			domains[addr] = struct{}{}
 		}
 	}
+	bd.mu.Lock()
+	bd.entries, bd.domains, bd.keys = entries, domains, keys
+	bd.mu.Unlock()
+}
+
+// list returns the entries of the blocklist in alphabetical order.
+func (bd *blockedDomains) list() []string {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	entries := make([]string, 0, len(bd.entries))
+	for entry := range bd.entries {
+		entries = append(entries, entry)
+	}
+	slices.Sort(entries)
+	return entries
+}
+
+// isHostBlocked checks both the public key and the address of the host.
+func (bd *blockedDomains) isHostBlocked(host *HostDBEntry) bool {
+	bd.mu.Lock()
+	_, blocked := bd.keys[host.PublicKey]
+	bd.mu.Unlock()
+	return blocked || bd.isBlocked(host.NetAddress)
 }
 
 func (bd *blockedDomains) isBlocked(addr string) bool {
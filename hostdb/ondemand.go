@@ -0,0 +1,163 @@
+package hostdb
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// onDemandCooldown is how long a host can't be scanned on demand again.
+const onDemandCooldown = 10 * time.Minute
+
+var (
+	// ErrScanInProgress is returned if the host is already being scanned
+	// or benchmarked.
+	ErrScanInProgress = errors.New("host is already being scanned")
+
+	// ErrScanTooSoon is returned if the host has been scanned on demand
+	// within onDemandCooldown.
+	ErrScanTooSoon = errors.New("host was scanned on demand recently")
+
+	// ErrScanNotAllowed is returned if the host is blocked, has opted out,
+	// or is not on the allowlist.
+	ErrScanNotAllowed = errors.New("host may not be scanned")
+)
+
+// OnDemandResult is the result of a scan requested on demand, followed by
+// a benchmark if it was requested too.
+type OnDemandResult struct {
+	Scan           HostScan       `json:"scan"`
+	Benchmark      *HostBenchmark `json:"benchmark,omitempty"`
+	BenchmarkError string         `json:"benchmarkError,omitempty"`
+}
+
+// onDemandScans keeps the times of the latest scans requested on demand.
+type onDemandScans struct {
+	lastRun map[types.PublicKey]time.Time
+	mu      sync.Mutex
+}
+
+func newOnDemandScans() *onDemandScans {
+	return &onDemandScans{
+		lastRun: make(map[types.PublicKey]time.Time),
+	}
+}
+
+// reserve returns false if the host has been scanned on demand within
+// onDemandCooldown.
+func (ods *onDemandScans) reserve(pk types.PublicKey) bool {
+	ods.mu.Lock()
+	defer ods.mu.Unlock()
+	for key, t := range ods.lastRun {
+		if time.Since(t) >= onDemandCooldown {
+			delete(ods.lastRun, key)
+		}
+	}
+	if _, ok := ods.lastRun[pk]; ok {
+		return false
+	}
+	ods.lastRun[pk] = time.Now()
+	return true
+}
+
+// ScanHostNow scans the host immediately, bypassing the schedule, and
+// benchmarks it afterwards if benchmark is true and the scan succeeds.
+// It blocks until the results are available.
+func (hdb *HostDB) ScanHostNow(network string, pk types.PublicKey, benchmark bool) (OnDemandResult, error) {
+	s := hdb.s
+	if network == "zen" {
+		s = hdb.sZen
+	}
+	s.mu.Lock()
+	host, exists := s.hosts[pk]
+	var blocked bool
+	var lastBenchmark time.Time
+	if exists {
+		blocked = host.Blocked
+		lastBenchmark = host.LastBenchmark.Timestamp
+	}
+	s.mu.Unlock()
+	if !exists {
+		return OnDemandResult{}, ErrUnknownHost
+	}
+	if blocked || hdb.optOuts.level(network, pk) == OptOutDelist || !hdb.allowlist.allows(pk) {
+		return OnDemandResult{}, ErrScanNotAllowed
+	}
+
+	if err := hdb.tg.Add(); err != nil {
+		return OnDemandResult{}, err
+	}
+	defer hdb.tg.Done()
+
+	// Claim the host like the scheduler does, so that it isn't scanned
+	// twice at the same time.
+	hdb.mu.Lock()
+	if hdb.draining {
+		hdb.mu.Unlock()
+		return OnDemandResult{}, errDraining
+	}
+	if _, queued := hdb.scanMap[pk]; queued {
+		hdb.mu.Unlock()
+		return OnDemandResult{}, ErrScanInProgress
+	}
+	if !hdb.onDemand.reserve(pk) {
+		hdb.mu.Unlock()
+		return OnDemandResult{}, ErrScanTooSoon
+	}
+	hdb.scanMap[pk] = false
+	hdb.scanThreads++
+	hdb.mu.Unlock()
+
+	start := time.Now()
+	hdb.scanHost(host)
+
+	var result OnDemandResult
+	s.mu.Lock()
+	if len(host.ScanHistory) > 0 {
+		result.Scan = host.ScanHistory[len(host.ScanHistory)-1]
+	}
+	s.mu.Unlock()
+	if result.Scan.Timestamp.Before(start) {
+		return OnDemandResult{}, errors.New("scan was interrupted")
+	}
+	if !benchmark {
+		return result, nil
+	}
+	if !result.Scan.Success {
+		result.BenchmarkError = "host is offline"
+		return result, nil
+	}
+
+	hdb.mu.Lock()
+	if hdb.draining {
+		hdb.mu.Unlock()
+		result.BenchmarkError = errDraining.Error()
+		return result, nil
+	}
+	if _, queued := hdb.scanMap[pk]; queued {
+		hdb.mu.Unlock()
+		result.BenchmarkError = ErrScanInProgress.Error()
+		return result, nil
+	}
+	hdb.scanMap[pk] = true
+	hdb.benchmarkThreads++
+	hdb.mu.Unlock()
+
+	hdb.benchmarkHost(host)
+
+	s.mu.Lock()
+	if host.LastBenchmark.Timestamp.After(lastBenchmark) {
+		b := host.LastBenchmark
+		result.Benchmark = &b
+	}
+	s.mu.Unlock()
+	if result.Benchmark == nil {
+		// The benchmark is skipped if the operator has opted out of it,
+		// or if the node can't pay for it.
+		result.BenchmarkError = "benchmark was not run"
+	}
+
+	return result, nil
+}
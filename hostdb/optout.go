@@ -0,0 +1,162 @@
+package hostdb
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// OptOutLevel is how far a host operator has opted out of HostScore.
+type OptOutLevel string
+
+const (
+	// OptOutBenchmarks excludes the host from the benchmarks, so that no
+	// contracts are formed with it. The host is still scanned.
+	OptOutBenchmarks OptOutLevel = "benchmarks"
+
+	// OptOutDelist excludes the host from both the scans and the
+	// benchmarks.
+	OptOutDelist OptOutLevel = "delist"
+)
+
+// ErrInvalidOptOut is returned if an opt-out has an unknown network or
+// level.
+var ErrInvalidOptOut = errors.New("invalid opt-out")
+
+// An OptOut is a request of a host operator not to be benchmarked or
+// not to be listed at all.
+type OptOut struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Level     OptOutLevel     `json:"level"`
+}
+
+// Validate checks the network and the level of the opt-out.
+func (oo OptOut) Validate() error {
+	if oo.Network != "mainnet" && oo.Network != "zen" {
+		return ErrInvalidOptOut
+	}
+	if oo.Level != OptOutBenchmarks && oo.Level != OptOutDelist {
+		return ErrInvalidOptOut
+	}
+	return nil
+}
+
+// optOuts keeps the opt-outs distributed by the portal.
+type optOuts struct {
+	levels map[string]map[types.PublicKey]OptOutLevel
+	mu     sync.Mutex
+}
+
+func newOptOuts(list []OptOut) *optOuts {
+	oo := &optOuts{}
+	oo.set(list)
+	return oo
+}
+
+func (oo *optOuts) set(list []OptOut) {
+	levels := map[string]map[types.PublicKey]OptOutLevel{
+		"mainnet": make(map[types.PublicKey]OptOutLevel),
+		"zen":     make(map[types.PublicKey]OptOutLevel),
+	}
+	for _, o := range list {
+		levels[o.Network][o.PublicKey] = o.Level
+	}
+	oo.mu.Lock()
+	oo.levels = levels
+	oo.mu.Unlock()
+}
+
+// level returns the opt-out level of the host, or an empty string if the
+// host hasn't opted out.
+func (oo *optOuts) level(network string, pk types.PublicKey) OptOutLevel {
+	oo.mu.Lock()
+	defer oo.mu.Unlock()
+	return oo.levels[network][pk]
+}
+
+func (oo *optOuts) list() (list []OptOut) {
+	oo.mu.Lock()
+	defer oo.mu.Unlock()
+	for network, levels := range oo.levels {
+		for pk, level := range levels {
+			list = append(list, OptOut{
+				Network:   network,
+				PublicKey: pk,
+				Level:     level,
+			})
+		}
+	}
+	return
+}
+
+// loadOptOuts loads the opt-outs from the database.
+func loadOptOuts(db *sqldb.DB) (*optOuts, error) {
+	rows, err := db.Query("SELECT network, public_key, level FROM hdb_optouts")
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query opt-outs")
+	}
+	defer rows.Close()
+
+	var list []OptOut
+	for rows.Next() {
+		var o OptOut
+		pk := make([]byte, 32)
+		if err := rows.Scan(&o.Network, &pk, &o.Level); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode opt-out")
+		}
+		o.PublicKey = types.PublicKey(pk)
+		if o.Validate() == nil {
+			list = append(list, o)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load opt-outs")
+	}
+
+	return newOptOuts(list), nil
+}
+
+// OptOuts returns the opt-outs the HostDB honors.
+func (hdb *HostDB) OptOuts() []OptOut {
+	return hdb.optOuts.list()
+}
+
+// SetOptOuts replaces the opt-outs the HostDB honors. The hosts that have
+// opted out are not scanned or benchmarked anymore, depending on the
+// level of the opt-out.
+func (hdb *HostDB) SetOptOuts(list []OptOut) error {
+	for _, o := range list {
+		if err := o.Validate(); err != nil {
+			return err
+		}
+	}
+
+	tx, err := hdb.db.Begin()
+	if err != nil {
+		return utils.AddContext(err, "couldn't start transaction")
+	}
+	if _, err := tx.Exec("DELETE FROM hdb_optouts"); err != nil {
+		tx.Rollback()
+		return utils.AddContext(err, "couldn't delete opt-outs")
+	}
+	for _, o := range list {
+		_, err := tx.Exec(`
+			INSERT INTO hdb_optouts (network, public_key, level)
+			VALUES (?, ?, ?)
+		`, o.Network, o.PublicKey[:], o.Level)
+		if err != nil {
+			tx.Rollback()
+			return utils.AddContext(err, "couldn't insert opt-out")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+
+	hdb.optOuts.set(list)
+	return nil
+}
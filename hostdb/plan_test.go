@@ -0,0 +1,78 @@
+package hostdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mike76-dev/hostscore/internal/testutil"
+)
+
+// TestQueryPlans makes sure that the hot queries use the indexes
+// created by init_sqlite.sql instead of scanning the tables.
+func TestQueryPlans(t *testing.T) {
+	db := testutil.NewDB(t, "init_sqlite.sql")
+	pk := make([]byte, 32)
+	for _, network := range []string{"mainnet", "zen"} {
+		scans := "USING INDEX idx_hdb_scans_" + network + " (public_key=?)"
+		benchmarks := "USING INDEX idx_hdb_benchmarks_" + network + " (public_key=?)"
+		tests := []struct {
+			name  string
+			query string
+			args  []any
+			want  string
+			// sorted requires the index to deliver the rows in order.
+			sorted bool
+		}{
+			{
+				name:   "latest scans",
+				query:  latestScansQuery(network),
+				args:   []any{pk, FailureProber},
+				want:   scans,
+				sorted: true,
+			},
+			{
+				name:   "latest benchmark",
+				query:  latestBenchmarkQuery(network),
+				args:   []any{pk},
+				want:   benchmarks,
+				sorted: true,
+			},
+			{
+				name:   "failed scans",
+				query:  lastFailedScansQuery(network),
+				args:   []any{pk, FailureProber},
+				want:   scans,
+				sorted: true,
+			},
+			{
+				name:   "failed benchmarks",
+				query:  lastFailedBenchmarksQuery(network),
+				args:   []any{pk, FailureProber, FailureRestarted, FailureSkipped},
+				want:   benchmarks,
+				sorted: true,
+			},
+			{
+				name:  "pending updates",
+				query: pendingCountQuery(network),
+				want:  "USING COVERING INDEX idx_hdb_hosts_" + network + "_fetched (fetched=?)",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(network+"/"+tt.name, func(t *testing.T) {
+				plan := testutil.QueryPlan(t, db, tt.query, tt.args...)
+				for _, step := range strings.Split(plan, "\n") {
+					// A subquery in the column list scans a constant row.
+					if strings.HasPrefix(step, "SCAN ") && step != "SCAN CONSTANT ROW" {
+						t.Fatalf("expected no table scans, got:\n%s", plan)
+					}
+				}
+				if !strings.Contains(plan, tt.want) {
+					t.Fatalf("expected %q in the plan, got:\n%s", tt.want, plan)
+				}
+				if tt.sorted && strings.Contains(plan, "TEMP B-TREE") {
+					t.Fatalf("expected the index to sort the rows, got:\n%s", plan)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package hostdb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// preferenceHintPrefix marks a benchmarking preference published by the
+// host. The RHP2 settings have no dedicated field for it, so the hints are
+// appended to the release string, e.g. "hostd v1.1.2 hostscore:no-benchmark"
+// or "hostd v1.1.2 hostscore:window=01:00-05:00".
+const preferenceHintPrefix = "hostscore:"
+
+// BenchmarkPreferences are the wishes of the host regarding the benchmarks.
+type BenchmarkPreferences struct {
+	NoBenchmark bool `json:"noBenchmark"`
+
+	// Window is the time of day in UTC, when the host prefers to be
+	// benchmarked, in the HH:MM-HH:MM format.
+	Window string `json:"window,omitempty"`
+
+	// Deferred is the number of times a benchmark was skipped or postponed
+	// to respect the preferences.
+	Deferred int `json:"deferred"`
+
+	windowStart int // minutes since midnight
+	windowEnd   int
+	deferred    bool // true if the current benchmark was already counted
+}
+
+// update parses the hints found in the release string. Invalid hints are
+// ignored.
+func (bp *BenchmarkPreferences) update(release string) {
+	bp.NoBenchmark = false
+	bp.Window = ""
+	for _, field := range strings.Fields(release) {
+		hint, ok := strings.CutPrefix(strings.ToLower(field), preferenceHintPrefix)
+		if !ok {
+			continue
+		}
+		if hint == "no-benchmark" {
+			bp.NoBenchmark = true
+			continue
+		}
+		if window, ok := strings.CutPrefix(hint, "window="); ok {
+			start, end, err := parseWindow(window)
+			if err == nil {
+				bp.Window = window
+				bp.windowStart, bp.windowEnd = start, end
+			}
+		}
+	}
+}
+
+// allows returns true if the host may be benchmarked at the given time.
+func (bp BenchmarkPreferences) allows(t time.Time) bool {
+	if bp.NoBenchmark {
+		return false
+	}
+	if bp.Window == "" {
+		return true
+	}
+	t = t.UTC()
+	now := t.Hour()*60 + t.Minute()
+	if bp.windowStart <= bp.windowEnd {
+		return now >= bp.windowStart && now < bp.windowEnd
+	}
+	// The window spans midnight.
+	return now >= bp.windowStart || now < bp.windowEnd
+}
+
+// deferBenchmark records that a benchmark was held back. A benchmark
+// is only counted once until the host is scanned again.
+func (bp *BenchmarkPreferences) deferBenchmark() {
+	if !bp.deferred {
+		bp.Deferred++
+		bp.deferred = true
+	}
+}
+
+// parseWindow parses a time window in the HH:MM-HH:MM format.
+func parseWindow(window string) (start, end int, err error) {
+	s, e, ok := strings.Cut(window, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid window %q", window)
+	}
+	if start, err = parseTimeOfDay(s); err != nil {
+		return
+	}
+	if end, err = parseTimeOfDay(e); err != nil {
+		return
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("empty window %q", window)
+	}
+	return
+}
+
+// parseTimeOfDay converts HH:MM into the minutes since midnight.
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,233 @@
+package hostdb
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+const (
+	// probeInterval determines how often the online hosts are probed.
+	probeInterval = 5 * time.Minute
+
+	// probeTimeout is the time after which a probe counts as failed.
+	probeTimeout = 5 * time.Second
+
+	// probeHistory is the number of probes kept per host. With a probe
+	// every 5 minutes, this makes one day.
+	probeHistory = 288
+
+	// maxProbeThreads is the maximum number of probes run in parallel.
+	maxProbeThreads = 100
+)
+
+// A LatencyProbe is the time it took to open a TCP connection to the
+// host. Unlike a scan, a probe involves no RHP interaction, so it can be
+// done much more often.
+type LatencyProbe struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Success   bool          `json:"success"`
+	Latency   time.Duration `json:"latency"`
+}
+
+// HostProbes are the latency probes of a host.
+type HostProbes struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Probes    []LatencyProbe  `json:"probes"`
+}
+
+// probeEntry is the compact form of a LatencyProbe. A negative latency
+// marks a failed probe.
+type probeEntry struct {
+	timestamp int64 // seconds
+	latency   int32 // microseconds
+}
+
+// probeRing is a fixed-size ring buffer of the most recent probes of a
+// host.
+type probeRing struct {
+	entries [probeHistory]probeEntry
+	next    int
+	full    bool
+}
+
+// add puts the probe in the buffer, overwriting the oldest one if the
+// buffer is full.
+func (pr *probeRing) add(p LatencyProbe) {
+	e := probeEntry{timestamp: p.Timestamp.Unix(), latency: -1}
+	if p.Success {
+		e.latency = int32(min(p.Latency.Microseconds(), int64(1<<31-1)))
+	}
+	pr.entries[pr.next] = e
+	pr.next = (pr.next + 1) % probeHistory
+	if pr.next == 0 {
+		pr.full = true
+	}
+}
+
+// since returns the probes made after the given time, the oldest first.
+func (pr *probeRing) since(t time.Time) []LatencyProbe {
+	start, n := 0, pr.next
+	if pr.full {
+		start, n = pr.next, probeHistory
+	}
+	var probes []LatencyProbe
+	for i := 0; i < n; i++ {
+		e := pr.entries[(start+i)%probeHistory]
+		ts := time.Unix(e.timestamp, 0)
+		if !ts.After(t) {
+			continue
+		}
+		p := LatencyProbe{Timestamp: ts}
+		if e.latency >= 0 {
+			p.Success = true
+			p.Latency = time.Duration(e.latency) * time.Microsecond
+		}
+		probes = append(probes, p)
+	}
+	return probes
+}
+
+// latencyProbes keeps the probe buffers of the hosts in memory.
+type latencyProbes struct {
+	mu    sync.Mutex
+	hosts map[string]map[types.PublicKey]*probeRing
+}
+
+func newLatencyProbes() *latencyProbes {
+	return &latencyProbes{
+		hosts: map[string]map[types.PublicKey]*probeRing{
+			"mainnet": make(map[types.PublicKey]*probeRing),
+			"zen":     make(map[types.PublicKey]*probeRing),
+		},
+	}
+}
+
+// record adds the probe to the buffer of the host.
+func (lp *latencyProbes) record(network string, pk types.PublicKey, p LatencyProbe) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	pr, ok := lp.hosts[network][pk]
+	if !ok {
+		pr = new(probeRing)
+		lp.hosts[network][pk] = pr
+	}
+	pr.add(p)
+}
+
+// probeTarget is a host to be probed.
+type probeTarget struct {
+	publicKey types.PublicKey
+	address   string
+}
+
+// hostsForProbe returns the hosts that were online at the last scan.
+// The offline hosts are left to the scans.
+func (s *hostDBStore) hostsForProbe() []probeTarget {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var targets []probeTarget
+	for pk, host := range s.hosts {
+		if host.Blocked || len(host.ScanHistory) == 0 {
+			continue
+		}
+		if !host.ScanHistory[len(host.ScanHistory)-1].Success {
+			continue
+		}
+		if s.hdb.optOuts.level(s.network, pk) == OptOutDelist || !s.hdb.allowlist.allows(pk) {
+			continue
+		}
+		targets = append(targets, probeTarget{pk, host.NetAddress})
+	}
+	return targets
+}
+
+// probeHost measures the time it takes to open a TCP connection to the
+// address.
+func (hdb *HostDB) probeHost(addr string) LatencyProbe {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	connCloseChan := make(chan struct{})
+	go func() {
+		select {
+		case <-hdb.tg.StopChan():
+		case <-connCloseChan:
+		}
+		cancel()
+	}()
+	defer close(connCloseChan)
+
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	p := LatencyProbe{Timestamp: start}
+	if err != nil {
+		return p
+	}
+	p.Latency = time.Since(start)
+	p.Success = true
+	conn.Close()
+	return p
+}
+
+// probeHosts is an ongoing function which will probe the latency of the
+// online hosts periodically.
+func (hdb *HostDB) probeHosts() {
+	if err := hdb.tg.Add(); err != nil {
+		hdb.log.Error("couldn't add a thread", zap.Error(err))
+		return
+	}
+	defer hdb.tg.Done()
+
+	for {
+		select {
+		case <-hdb.tg.StopChan():
+			return
+		case <-time.After(probeInterval):
+		}
+
+		sem := make(chan struct{}, maxProbeThreads)
+		var wg sync.WaitGroup
+		for _, s := range []*hostDBStore{hdb.s, hdb.sZen} {
+			// A failed probe says nothing about the host if the node
+			// itself is offline.
+			if !hdb.online(s.network) {
+				continue
+			}
+			for _, target := range s.hostsForProbe() {
+				sem <- struct{}{}
+				wg.Add(1)
+				go func(network string, target probeTarget) {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+					hdb.probes.record(network, target.publicKey, hdb.probeHost(target.address))
+				}(s.network, target)
+			}
+		}
+		wg.Wait()
+	}
+}
+
+// LatencyProbes returns the probes of the hosts of the network made after
+// the given time.
+func (hdb *HostDB) LatencyProbes(network string, since time.Time) []HostProbes {
+	hdb.probes.mu.Lock()
+	defer hdb.probes.mu.Unlock()
+	var hps []HostProbes
+	for pk, pr := range hdb.probes.hosts[network] {
+		if probes := pr.since(since); len(probes) > 0 {
+			hps = append(hps, HostProbes{
+				Network:   network,
+				PublicKey: pk,
+				Probes:    probes,
+			})
+		}
+	}
+	return hps
+}
@@ -14,6 +14,12 @@ import (
 )
 
 const (
+	// MaxClockSkew is the maximum difference between the clock of the host
+	// and the clock of the node, beyond which the host is flagged. The
+	// renters reject the signed prices of the host once they expire by
+	// their own clock, so a host far behind hands out stale prices.
+	MaxClockSkew = 5 * time.Minute
+
 	scanInterval        = 30 * time.Minute
 	scanBatchSize       = 20
 	maxScanThreads      = 1000
@@ -26,10 +32,20 @@ func (hdb *HostDB) queueScan(host *HostDBEntry) {
 	if host.Network != "mainnet" && host.Network != "zen" {
 		panic("wrong host network")
 	}
+	// Respect the wish of the host operator.
+	level := hdb.optOuts.level(host.Network, host.PublicKey)
+	if level == OptOutDelist {
+		return
+	}
+	// In the allowlist mode, all other hosts are ignored.
+	if !hdb.allowlist.allows(host.PublicKey) {
+		return
+	}
 	// If this entry is already in the scan pool, can return immediately.
+	// Nothing is queued anymore once the node is shutting down.
 	hdb.mu.Lock()
 	_, exists := hdb.scanMap[host.PublicKey]
-	if exists {
+	if exists || hdb.draining {
 		hdb.mu.Unlock()
 		return
 	}
@@ -41,6 +57,17 @@ func (hdb *HostDB) queueScan(host *HostDBEntry) {
 		interval = hdb.s.calculateScanInterval(host)
 	}
 	toBenchmark := len(host.ScanHistory) > 0 && time.Since(host.ScanHistory[len(host.ScanHistory)-1].Timestamp) < interval
+	if toBenchmark && level == OptOutBenchmarks {
+		hdb.mu.Unlock()
+		return
+	}
+	// Respect the preferences published by the host.
+	host.Preferences.update(host.Settings.Release)
+	if toBenchmark && !host.Preferences.allows(time.Now()) {
+		host.Preferences.deferBenchmark()
+		hdb.mu.Unlock()
+		return
+	}
 	hdb.scanMap[host.PublicKey] = toBenchmark
 	if toBenchmark {
 		hdb.benchmarkList = append(hdb.benchmarkList, host)
@@ -72,9 +99,13 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 	var settings rhpv2.HostSettings
 	var pt rhpv3.HostPriceTable
 	var latency time.Duration
+	var timings rhp.ConnTimings
+	var rpcTime time.Duration
 	var success bool
 	var errMsg string
 	var start time.Time
+	var skew int64
+	var validUntil time.Time
 	err = func() error {
 		// Create a context and set up its cancelling.
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -90,9 +121,11 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 
 		// Initiate RHP2 protocol.
 		start = time.Now()
-		err := rhp.WithTransportV2(ctx, host.NetAddress, host.PublicKey, func(t *rhpv2.Transport) error {
+		err := rhp.WithTimedTransportV2(ctx, host.NetAddress, host.PublicKey, &timings, func(t *rhpv2.Transport) error {
 			var err error
+			rpcStart := time.Now()
 			settings, err = rhp.RPCSettings(ctx, t)
+			rpcTime = time.Since(rpcStart)
 			return err
 		})
 		latency = time.Since(start)
@@ -107,6 +140,9 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 				})
 				return err
 			})
+			if err == nil {
+				skew, validUntil = hdb.clockSkew(ctx, host, pt)
+			}
 		}
 
 		return err
@@ -115,21 +151,47 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 		// Shutting down.
 		return
 	}
+	failure := hdb.classifyFailure(host.Network, err)
+	var ipv4, ipv6 AddressScan
+	if failure != FailureProber {
+		// The reachability can't be told if the node itself is to blame.
+		ipv4, ipv6 = hdb.probeAddresses(host, settings, success)
+	}
 	if err == nil {
 		hdb.IncrementSuccessfulInteractions(host)
+	} else if failure == FailureProber {
+		// Record the scan but don't penalize the host.
+		errMsg = err.Error()
 	} else {
 		errMsg = err.Error()
 		hdb.IncrementFailedInteractions(host)
 	}
+	invalidSig := err != nil && isSignatureError(err)
+	if invalidSig {
+		hdb.log.Debug("invalid host signature", zap.String("network", host.Network), zap.String("host", host.NetAddress), zap.Error(err))
+	}
 
 	scan := HostScan{
 		Timestamp:  start,
 		Success:    success,
 		Latency:    latency,
 		Error:      errMsg,
+		Failure:    failure,
+		ClockSkew:  skew,
+		ValidUntil: validUntil,
+		InvalidSig: invalidSig,
+		IPv4:       ipv4,
+		IPv6:       ipv6,
 		Settings:   settings,
 		PriceTable: pt,
 	}
+	if success {
+		scan.Timings = ScanTimings{
+			Dial:      timings.Dial,
+			Handshake: timings.Handshake,
+			Settings:  rpcTime,
+		}
+	}
 
 	// Update the host database.
 	if host.Network == "zen" {
@@ -144,10 +206,59 @@ func (hdb *HostDB) scanHost(host *HostDBEntry) {
 	// Delete the host from scanMap.
 	hdb.mu.Lock()
 	delete(hdb.scanMap, host.PublicKey)
+	host.Preferences.deferred = false
 	hdb.scanThreads--
+	hdb.scansDone++
 	hdb.mu.Unlock()
 }
 
+// probeAddresses completes the handshake with the siamux address of the
+// host over IPv4 and IPv6 separately. If the scan has failed, the host
+// is not reachable over either family, so only its net address is
+// resolved.
+func (hdb *HostDB) probeAddresses(host *HostDBEntry, settings rhpv2.HostSettings, success bool) (ipv4, ipv6 AddressScan) {
+	addr := host.NetAddress
+	if success {
+		addr = settings.SiamuxAddr()
+	}
+	addr4, addr6, err := utils.ResolveAddresses(addr)
+	if err != nil {
+		return
+	}
+
+	probe := func(addr string) AddressScan {
+		if addr == "" {
+			return AddressScan{Reachability: NoAddress}
+		}
+		if !success {
+			return AddressScan{Reachability: Unreachable}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		connCloseChan := make(chan struct{})
+		go func() {
+			select {
+			case <-hdb.tg.StopChan():
+			case <-connCloseChan:
+			}
+			cancel()
+		}()
+		defer close(connCloseChan)
+
+		start := time.Now()
+		err := rhp.WithTransportV3(ctx, addr, host.PublicKey, func(*rhpv3.Transport) error { return nil })
+		if err != nil {
+			return AddressScan{Reachability: Unreachable}
+		}
+		return AddressScan{
+			Reachability: Reachable,
+			Latency:      time.Since(start),
+		}
+	}
+
+	return probe(addr4), probe(addr6)
+}
+
 // scanHosts is an ongoing function which will scan the full set of hosts
 // periodically.
 func (hdb *HostDB) scanHosts() {
@@ -188,7 +299,15 @@ func (hdb *HostDB) scanHosts() {
 				hdb.scanList = hdb.scanList[batchSize:]
 				hdb.mu.Unlock()
 				go func() {
-					for _, entry := range list {
+					for i, entry := range list {
+						if hdb.isDraining() {
+							hdb.mu.Lock()
+							for _, e := range list[i:] {
+								delete(hdb.scanMap, e.PublicKey)
+							}
+							hdb.mu.Unlock()
+							return
+						}
 						hdb.scanHost(entry)
 					}
 				}()
@@ -251,3 +370,32 @@ func (s *hostDBStore) calculateScanInterval(host *HostDBEntry) time.Duration {
 	}
 	return scanInterval
 }
+
+// clockSkew fetches the prices the host signs over RHP4 and compares
+// their expiry with the local clock, returning the skew in seconds and the
+// expiry. The host guarantees the prices as long as its RHP3 price table,
+// so the expiry less that time is what the clock of the host showed. The
+// skew is zero if the host can't be reached over RHP4.
+func (hdb *HostDB) clockSkew(ctx context.Context, host *HostDBEntry, pt rhpv3.HostPriceTable) (int64, time.Time) {
+	addr, err := rhp.SiamuxV4Addr(host.NetAddress)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	prices, err := rhp.RPCPricesV4(ctx, addr, host.PublicKey)
+	if err != nil {
+		hdb.log.Debug("couldn't fetch RHP4 prices", zap.String("network", host.Network), zap.String("host", host.NetAddress), zap.Error(err))
+		return 0, time.Time{}
+	}
+	skew := prices.ValidUntil.Sub(time.Now().Add(pt.Validity))
+	return int64(skew.Round(time.Second).Seconds()), prices.ValidUntil
+}
+
+// isSignatureError returns true if the host was reachable but failed to
+// prove the ownership of its public key during the handshake. Neither the
+// RHP2 settings nor the RHP3 price table are signed separately, so the
+// handshake signature is what vouches for them.
+func isSignatureError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "handshake signature was invalid") ||
+		strings.Contains(msg, "invalid signature")
+}
@@ -0,0 +1,183 @@
+package hostdb
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// BenchmarkSchedule determines how the benchmark interval of a host is
+// calculated.
+type BenchmarkSchedule string
+
+const (
+	// ScheduleFixed benchmarks all hosts at the same interval, which only
+	// grows along a fixed ladder if the benchmarks keep failing.
+	ScheduleFixed BenchmarkSchedule = "fixed"
+
+	// ScheduleAdaptive benchmarks the top-ranked hosts at the base interval
+	// and backs off exponentially on the failing, full, or low-ranked ones.
+	ScheduleAdaptive BenchmarkSchedule = "adaptive"
+)
+
+const (
+	defaultMaxBenchmarkInterval = 7 * 24 * time.Hour
+	defaultBenchmarkTopRank     = 100
+
+	// maxBackoffExponent limits the exponential backoff, so that the
+	// interval can't overflow.
+	maxBackoffExponent = 16
+)
+
+// ErrInvalidRank is returned if a host rank has an unknown network or a
+// non-positive value.
+var ErrInvalidRank = errors.New("invalid host rank")
+
+// A HostRank is the position of a host in the ranking of the portal.
+type HostRank struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	Rank      int             `json:"rank"`
+}
+
+// Validate checks the network and the value of the rank.
+func (hr HostRank) Validate() error {
+	if hr.Network != "mainnet" && hr.Network != "zen" {
+		return ErrInvalidRank
+	}
+	if hr.Rank < 1 {
+		return ErrInvalidRank
+	}
+	return nil
+}
+
+// hostRanks keeps the host ranks distributed by the portal. The ranks are
+// not persisted, since the portal pushes them periodically.
+type hostRanks struct {
+	ranks map[string]map[types.PublicKey]int
+	mu    sync.Mutex
+}
+
+func newHostRanks() *hostRanks {
+	hr := &hostRanks{}
+	hr.set(nil)
+	return hr
+}
+
+func (hr *hostRanks) set(list []HostRank) {
+	ranks := map[string]map[types.PublicKey]int{
+		"mainnet": make(map[types.PublicKey]int),
+		"zen":     make(map[types.PublicKey]int),
+	}
+	for _, r := range list {
+		ranks[r.Network][r.PublicKey] = r.Rank
+	}
+	hr.mu.Lock()
+	hr.ranks = ranks
+	hr.mu.Unlock()
+}
+
+// rank returns the rank of the host, or zero if the rank is unknown.
+func (hr *hostRanks) rank(network string, pk types.PublicKey) int {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hr.ranks[network][pk]
+}
+
+func (hr *hostRanks) list() (list []HostRank) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	for network, ranks := range hr.ranks {
+		for pk, rank := range ranks {
+			list = append(list, HostRank{
+				Network:   network,
+				PublicKey: pk,
+				Rank:      rank,
+			})
+		}
+	}
+	return
+}
+
+// Ranks returns the host ranks known to the HostDB.
+func (hdb *HostDB) Ranks() []HostRank {
+	return hdb.ranks.list()
+}
+
+// SetRanks replaces the host ranks. They are only used by the adaptive
+// benchmark schedule.
+func (hdb *HostDB) SetRanks(list []HostRank) error {
+	for _, r := range list {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	hdb.ranks.set(list)
+	return nil
+}
+
+// benchmarkSchedule returns the benchmark schedule of the node.
+func (hdb *HostDB) benchmarkSchedule() BenchmarkSchedule {
+	if hdb.benchmarkConfig.Schedule != "" {
+		return hdb.benchmarkConfig.Schedule
+	}
+	return ScheduleFixed
+}
+
+// maxBenchmarkInterval returns the longest interval between the
+// benchmarks of a host under the adaptive schedule.
+func (hdb *HostDB) maxBenchmarkInterval() time.Duration {
+	if hdb.benchmarkConfig.MaxInterval > 0 {
+		return hdb.benchmarkConfig.MaxInterval
+	}
+	return defaultMaxBenchmarkInterval
+}
+
+// benchmarkTopRank returns the rank up to which the hosts are benchmarked
+// at the base interval under the adaptive schedule.
+func (hdb *HostDB) benchmarkTopRank() int {
+	if hdb.benchmarkConfig.TopRank > 0 {
+		return hdb.benchmarkConfig.TopRank
+	}
+	return defaultBenchmarkTopRank
+}
+
+// calculateAdaptiveBenchmarkInterval calculates a benchmark interval
+// depending on the rank of the host, its storage, and how many previous
+// benchmarks have been failed. The interval doubles with every failed
+// benchmark, up to the configured maximum.
+func (s *hostDBStore) calculateAdaptiveBenchmarkInterval(host *HostDBEntry) time.Duration {
+	base := s.hdb.benchmarkInterval()
+	if host.LastBenchmark.Timestamp.IsZero() {
+		return base
+	}
+
+	num := s.lastFailedBenchmarks(host)
+	if num > 13 && s.lastFailedScans(host) > 18 {
+		return math.MaxInt64 // never
+	}
+
+	interval := base
+
+	// The hosts outside the top ranks, or whose rank is unknown, are
+	// benchmarked half as often.
+	if rank := s.hdb.ranks.rank(host.Network, host.PublicKey); rank == 0 || rank > s.hdb.benchmarkTopRank() {
+		interval *= 2
+	}
+
+	// A host that can't store any data is of little use to the renters.
+	if host.Settings.RemainingStorage == 0 || !host.Settings.AcceptingContracts {
+		interval *= 4
+	}
+
+	maxInterval := s.hdb.maxBenchmarkInterval()
+	for i := 0; i < num && i < maxBackoffExponent && interval < maxInterval; i++ {
+		interval *= 2
+	}
+
+	// The maximum never shortens the base interval.
+	return max(min(interval, maxInterval), base)
+}
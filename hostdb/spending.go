@@ -0,0 +1,211 @@
+package hostdb
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// Spending categories.
+const (
+	spendingContracts = iota
+	spendingFunding
+	spendingUpload
+	spendingDownload
+)
+
+// Spending is the money spent on benchmarking. The contracts are paid
+// from the wallet, including the fees and the renter funds. The account
+// funding is paid from the contracts, and the upload and download fees
+// from the accounts, so they are parts of the contract spending.
+type Spending struct {
+	Contracts types.Currency `json:"contracts"`
+	Funding   types.Currency `json:"funding"`
+	Upload    types.Currency `json:"upload"`
+	Download  types.Currency `json:"download"`
+}
+
+// add adds the amount to the given category.
+func (s *Spending) add(category int, amount types.Currency) {
+	switch category {
+	case spendingContracts:
+		s.Contracts = s.Contracts.Add(amount)
+	case spendingFunding:
+		s.Funding = s.Funding.Add(amount)
+	case spendingUpload:
+		s.Upload = s.Upload.Add(amount)
+	case spendingDownload:
+		s.Download = s.Download.Add(amount)
+	}
+}
+
+// HostSpending is the money spent on benchmarking a host.
+type HostSpending struct {
+	Network   string          `json:"network"`
+	PublicKey types.PublicKey `json:"publicKey"`
+	LastSpent time.Time       `json:"lastSpent"`
+	Spending
+}
+
+// NetworkSpending is the money spent on benchmarking all hosts of a
+// network.
+type NetworkSpending struct {
+	Network string `json:"network"`
+	Hosts   int    `json:"hosts"`
+	Spending
+}
+
+// hostSpending keeps track of the money spent on each host.
+type hostSpending struct {
+	db    *sqldb.DB
+	log   *zap.Logger
+	hosts map[string]map[types.PublicKey]*HostSpending
+	mu    sync.Mutex
+}
+
+// loadHostSpending loads the spending on the hosts from the database.
+func loadHostSpending(db *sqldb.DB, l *zap.Logger) (*hostSpending, error) {
+	hs := &hostSpending{
+		db:  db,
+		log: l,
+		hosts: map[string]map[types.PublicKey]*HostSpending{
+			"mainnet": make(map[types.PublicKey]*HostSpending),
+			"zen":     make(map[types.PublicKey]*HostSpending),
+		},
+	}
+
+	rows, err := db.Query(`
+		SELECT network, public_key, contracts, funding, upload, download, last_spent
+		FROM hdb_host_spending
+	`)
+	if err != nil {
+		return nil, utils.AddContext(err, "couldn't query host spending")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := new(HostSpending)
+		pk := make([]byte, 32)
+		var cb, fb, upb, dnb []byte
+		var ls int64
+		if err := rows.Scan(&s.Network, &pk, &cb, &fb, &upb, &dnb, &ls); err != nil {
+			return nil, utils.AddContext(err, "couldn't decode host spending")
+		}
+		if _, ok := hs.hosts[s.Network]; !ok {
+			continue
+		}
+		copy(s.PublicKey[:], pk)
+		s.LastSpent = time.Unix(ls, 0)
+		for _, field := range []struct {
+			c *types.Currency
+			b []byte
+		}{
+			{&s.Contracts, cb},
+			{&s.Funding, fb},
+			{&s.Upload, upb},
+			{&s.Download, dnb},
+		} {
+			d := types.NewBufDecoder(field.b)
+			if (*types.V1Currency)(field.c).DecodeFrom(d); d.Err() != nil {
+				return nil, utils.AddContext(d.Err(), "couldn't decode amount")
+			}
+		}
+		hs.hosts[s.Network][s.PublicKey] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.AddContext(err, "couldn't load host spending")
+	}
+
+	return hs, nil
+}
+
+// record adds the amount to the spending on the host and persists it.
+func (hs *hostSpending) record(network string, pk types.PublicKey, category int, amount types.Currency) {
+	if amount.IsZero() {
+		return
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	s, ok := hs.hosts[network][pk]
+	if !ok {
+		s = &HostSpending{
+			Network:   network,
+			PublicKey: pk,
+		}
+		hs.hosts[network][pk] = s
+	}
+	s.add(category, amount)
+	s.LastSpent = time.Now()
+
+	_, err := hs.db.Exec(`
+		INSERT INTO hdb_host_spending (
+			network,
+			public_key,
+			contracts,
+			funding,
+			upload,
+			download,
+			last_spent
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (network, public_key) UPDATE
+			contracts = new.contracts,
+			funding = new.funding,
+			upload = new.upload,
+			download = new.download,
+			last_spent = new.last_spent
+	`,
+		network,
+		pk[:],
+		encodeCurrency(s.Contracts),
+		encodeCurrency(s.Funding),
+		encodeCurrency(s.Upload),
+		encodeCurrency(s.Download),
+		s.LastSpent.Unix(),
+	)
+	if err != nil {
+		hs.log.Error("couldn't save host spending", zap.String("network", network), zap.Stringer("host", pk), zap.Error(err))
+	}
+}
+
+// HostSpending returns the spending on the hosts of the network, the
+// most expensive first.
+func (hdb *HostDB) HostSpending(network string) []HostSpending {
+	hdb.hostSpending.mu.Lock()
+	defer hdb.hostSpending.mu.Unlock()
+	spending := make([]HostSpending, 0, len(hdb.hostSpending.hosts[network]))
+	for _, s := range hdb.hostSpending.hosts[network] {
+		spending = append(spending, *s)
+	}
+	slices.SortFunc(spending, func(a, b HostSpending) int {
+		if c := b.Contracts.Cmp(a.Contracts); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.PublicKey[:], b.PublicKey[:])
+	})
+	return spending
+}
+
+// NetworkSpending returns the total spending on the hosts of the network.
+func (hdb *HostDB) NetworkSpending(network string) NetworkSpending {
+	hdb.hostSpending.mu.Lock()
+	defer hdb.hostSpending.mu.Unlock()
+	ns := NetworkSpending{
+		Network: network,
+		Hosts:   len(hdb.hostSpending.hosts[network]),
+	}
+	for _, s := range hdb.hostSpending.hosts[network] {
+		ns.Contracts = ns.Contracts.Add(s.Contracts)
+		ns.Funding = ns.Funding.Add(s.Funding)
+		ns.Upload = ns.Upload.Add(s.Upload)
+		ns.Download = ns.Download.Add(s.Download)
+	}
+	return ns
+}
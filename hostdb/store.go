@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mike76-dev/hostscore/internal/sqldb"
 	"github.com/mike76-dev/hostscore/internal/utils"
 	rhpv2 "go.sia.tech/core/rhp/v2"
 	rhpv3 "go.sia.tech/core/rhp/v3"
@@ -17,8 +18,8 @@ import (
 )
 
 type hostDBStore struct {
-	db      *sql.DB
-	tx      *sql.Tx
+	db      *sqldb.DB
+	tx      *sqldb.Tx
 	log     *zap.Logger
 	network string
 	hdb     *HostDB
@@ -33,10 +34,25 @@ type hostDBStore struct {
 	tip           types.ChainIndex
 	lastCommitted time.Time
 
-	lastUpdate HostUpdates
+	acked      UpdateID
+	lastUpdate pendingUpdates
+	consumers  map[string]*consumerUpdates
 }
 
-func newHostDBStore(db *sql.DB, logger *zap.Logger, network string, domains *blockedDomains) (*hostDBStore, types.ChainIndex, error) {
+// pendingUpdates holds the IDs of the records sent to the client, which
+// are marked as fetched once the client confirms the receipt. The batch
+// is persisted, so that it is sent again unchanged, even after a restart,
+// until it is confirmed. A record is pending while its fetched timestamp
+// is zero, which is reset whenever the record is modified, so that the
+// pending records can be found using an index.
+type pendingUpdates struct {
+	id         UpdateID
+	hosts      []int
+	scans      []int64
+	benchmarks []int64
+}
+
+func newHostDBStore(db *sqldb.DB, logger *zap.Logger, network string, domains *blockedDomains) (*hostDBStore, types.ChainIndex, error) {
 	s := &hostDBStore{
 		db:               db,
 		log:              logger,
@@ -53,7 +69,8 @@ func newHostDBStore(db *sql.DB, logger *zap.Logger, network string, domains *blo
 	return s, s.tip, nil
 }
 
-// update updates the host entry in the database.
+// update updates the host entry in the database. The entry is written
+// within the current transaction, which is committed by the caller.
 // NOTE: a lock must be acquired before calling update.
 func (s *hostDBStore) update(host *HostDBEntry) error {
 	if host.Network != s.network {
@@ -62,13 +79,27 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 	if s.tx == nil {
 		return errors.New("there is no transaction")
 	}
-	if host.Blocked || s.hdb.blockedDomains.isBlocked(host.NetAddress) {
+	s.trackHost(host)
+	return s.saveHost(host)
+}
+
+// trackHost updates the in-memory state of the host and notifies the
+// clients waiting for the updates.
+// NOTE: a lock must be acquired before calling trackHost.
+func (s *hostDBStore) trackHost(host *HostDBEntry) {
+	if host.Blocked || s.hdb.blockedDomains.isHostBlocked(host) {
 		host.Blocked = true
 		s.blockedHosts[host.PublicKey] = struct{}{}
 	} else {
 		delete(s.blockedHosts, host.PublicKey)
 	}
 	s.hosts[host.PublicKey] = host
+	s.hdb.notifyUpdates()
+}
+
+// saveHost writes the host entry within the current transaction.
+// NOTE: a lock must be acquired before calling saveHost.
+func (s *hostDBStore) saveHost(host *HostDBEntry) error {
 	var rev, settings, pt bytes.Buffer
 	e := types.NewEncoder(&rev)
 	if (host.Revision.ParentID != types.FileContractID{}) {
@@ -106,11 +137,16 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 			revision,
 			settings,
 			price_table,
+			formation_successes,
+			duration_violations,
+			expiry_successes,
+			expiry_failures,
+			deferred_benchmarks,
 			modified,
 			fetched
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
-		ON DUPLICATE KEY UPDATE
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (public_key) UPDATE
 			first_seen = new.first_seen,
 			known_since = new.known_since,
 			blocked = new.blocked,
@@ -128,7 +164,13 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 			revision = new.revision,
 			settings = new.settings,
 			price_table = new.price_table,
-			modified = new.modified
+			formation_successes = new.formation_successes,
+			duration_violations = new.duration_violations,
+			expiry_successes = new.expiry_successes,
+			expiry_failures = new.expiry_failures,
+			deferred_benchmarks = new.deferred_benchmarks,
+			modified = new.modified,
+			fetched = new.fetched
 	`,
 		host.ID,
 		host.PublicKey[:],
@@ -149,19 +191,56 @@ func (s *hostDBStore) update(host *HostDBEntry) error {
 		rev.Bytes(),
 		settings.Bytes(),
 		pt.Bytes(),
+		host.Compliance.FormationSuccesses,
+		host.Compliance.DurationViolations,
+		host.Compliance.ExpirySuccesses,
+		host.Compliance.ExpiryFailures,
+		host.Preferences.Deferred,
 		time.Now().Unix(),
 		0,
 	)
+	return err
+}
+
+// commit commits the current transaction and starts a new one.
+// NOTE: a lock must be acquired before calling commit.
+func (s *hostDBStore) commit() error {
+	if err := s.tx.Commit(); err != nil {
+		return utils.AddContext(err, "couldn't commit transaction")
+	}
+	s.lastCommitted = time.Now()
+	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return utils.AddContext(err, "couldn't start transaction")
 	}
+	s.tx = tx
+	return nil
+}
 
-	if err := s.tx.Commit(); err != nil {
+// commitWithRetry runs the statements of fn in a transaction of their own
+// and commits it. If this fails with a transient error, e.g. a deadlock,
+// the transaction is rolled back, and the statements are repeated in a new
+// one. The statements pending in the current transaction are committed
+// first, since they can't be repeated.
+// NOTE: a lock must be acquired before calling commitWithRetry.
+func (s *hostDBStore) commitWithRetry(fn func() error) error {
+	if err := s.commit(); err != nil {
 		return err
 	}
-
-	s.tx, err = s.db.Begin()
-	return err
+	return s.db.Retry(func() error {
+		err := fn()
+		if err == nil {
+			err = s.tx.Commit()
+		} else {
+			s.tx.Rollback()
+		}
+		tx, txErr := s.db.Begin()
+		if txErr != nil {
+			return utils.AddContext(txErr, "couldn't start transaction")
+		}
+		s.tx = tx
+		return err
+	})
 }
 
 // updateScanHistory adds a new scan to the host's scan history.
@@ -175,21 +254,25 @@ func (s *hostDBStore) updateScanHistory(host *HostDBEntry, scan HostScan) error
 		return errors.New("there is no transaction")
 	}
 
-	if scan.Success {
-		host.LastSeen = scan.Timestamp
-		if len(host.ScanHistory) > 0 {
-			host.Uptime += scan.Timestamp.Sub(host.ScanHistory[len(host.ScanHistory)-1].Timestamp)
-		}
-	} else {
-		if len(host.ScanHistory) > 0 {
-			host.Downtime += scan.Timestamp.Sub(host.ScanHistory[len(host.ScanHistory)-1].Timestamp)
+	// A scan that failed on our side says nothing about the host, so it
+	// is only stored in the database.
+	if scan.Failure != FailureProber {
+		if scan.Success {
+			host.LastSeen = scan.Timestamp
+			if len(host.ScanHistory) > 0 {
+				host.Uptime += scan.Timestamp.Sub(host.ScanHistory[len(host.ScanHistory)-1].Timestamp)
+			}
+		} else {
+			if len(host.ScanHistory) > 0 {
+				host.Downtime += scan.Timestamp.Sub(host.ScanHistory[len(host.ScanHistory)-1].Timestamp)
+			}
 		}
-	}
 
-	// Limit the in-memory history to two most recent scans.
-	host.ScanHistory = append(host.ScanHistory, scan)
-	if len(host.ScanHistory) > 2 {
-		host.ScanHistory = host.ScanHistory[1:]
+		// Limit the in-memory history to two most recent scans.
+		host.ScanHistory = append(host.ScanHistory, scan)
+		if len(host.ScanHistory) > 2 {
+			host.ScanHistory = host.ScanHistory[1:]
+		}
 	}
 
 	var settings, pt bytes.Buffer
@@ -213,18 +296,40 @@ func (s *hostDBStore) updateScanHistory(host *HostDBEntry, scan HostScan) error
 			success,
 			latency,
 			error,
+			failure,
+			clock_skew,
+			valid_until,
+			invalid_signature,
+			ipv4,
+			ipv4_latency,
+			ipv6,
+			ipv6_latency,
+			dial_time,
+			handshake_time,
+			settings_time,
 			settings,
 			price_table,
 			modified,
 			fetched
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		host.PublicKey[:],
 		scan.Timestamp.Unix(),
 		scan.Success,
 		scan.Latency.Milliseconds(),
 		scan.Error,
+		uint8(scan.Failure),
+		scan.ClockSkew,
+		scan.ValidUntil.Unix(),
+		scan.InvalidSig,
+		uint8(scan.IPv4.Reachability),
+		scan.IPv4.Latency.Milliseconds(),
+		uint8(scan.IPv6.Reachability),
+		scan.IPv6.Latency.Milliseconds(),
+		utils.DurationToMS(scan.Timings.Dial),
+		utils.DurationToMS(scan.Timings.Handshake),
+		utils.DurationToMS(scan.Timings.Settings),
 		settings.Bytes(),
 		pt.Bytes(),
 		time.Now().Unix(),
@@ -233,11 +338,12 @@ func (s *hostDBStore) updateScanHistory(host *HostDBEntry, scan HostScan) error
 	if err != nil {
 		return utils.AddContext(err, "couldn't update scan history")
 	}
-
-	err = s.update(host)
-	if err != nil {
+	if err := s.update(host); err != nil {
 		return utils.AddContext(err, "couldn't update host")
 	}
+	if err := s.commit(); err != nil {
+		return err
+	}
 
 	if (len(host.ScanHistory) > 0 && host.ScanHistory[len(host.ScanHistory)-1].Success) && (len(host.ScanHistory) > 1 && host.ScanHistory[len(host.ScanHistory)-2].Success || len(host.ScanHistory) == 1) {
 		s.activeHostsCache[host.PublicKey] = host.IPNets
@@ -268,11 +374,14 @@ func (s *hostDBStore) updateBenchmarks(host *HostDBEntry, benchmark HostBenchmar
 			upload_speed,
 			download_speed,
 			ttfb,
+			uploaded,
+			downloaded,
 			error,
+			failure,
 			modified,
 			fetched
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		host.PublicKey[:],
 		benchmark.Timestamp.Unix(),
@@ -280,20 +389,84 @@ func (s *hostDBStore) updateBenchmarks(host *HostDBEntry, benchmark HostBenchmar
 		benchmark.UploadSpeed,
 		benchmark.DownloadSpeed,
 		benchmark.TTFB.Milliseconds(),
+		benchmark.Uploaded,
+		benchmark.Downloaded,
 		benchmark.Error,
+		uint8(benchmark.Failure),
 		time.Now().Unix(),
 		0,
 	)
 	if err != nil {
 		return utils.AddContext(err, "couldn't update benchmarks")
 	}
-
-	err = s.update(host)
-	if err != nil {
+	if err := s.update(host); err != nil {
 		return utils.AddContext(err, "couldn't update host")
 	}
+	return s.commit()
+}
 
-	return nil
+// latestScansQuery returns the query retrieving the last two scans of a
+// host, which are loaded at startup.
+func latestScansQuery(network string) string {
+	return `
+	SELECT ran_at, success, latency, error, failure, clock_skew, valid_until, invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency, dial_time, handshake_time, settings_time, settings, price_table
+	FROM hdb_scans_` + network + `
+	WHERE public_key = ?
+	AND failure <> ?
+	ORDER BY ran_at DESC
+	LIMIT 2
+`
+}
+
+// pendingCountQuery returns the query counting the records not fetched
+// yet, which runs on every poll.
+func pendingCountQuery(network string) string {
+	return `
+	SELECT
+		(SELECT COUNT(*) FROM hdb_hosts_` + network + ` WHERE fetched = 0) +
+		(SELECT COUNT(*) FROM hdb_scans_` + network + ` WHERE fetched = 0) +
+		(SELECT COUNT(*) FROM hdb_benchmarks_` + network + ` WHERE fetched = 0)
+`
+}
+
+// latestBenchmarkQuery returns the query retrieving the last benchmark
+// of a host, which is loaded at startup.
+func latestBenchmarkQuery(network string) string {
+	return `
+	SELECT ran_at, success, upload_speed, download_speed, ttfb, error
+	FROM hdb_benchmarks_` + network + `
+	WHERE public_key = ?
+	ORDER BY ran_at DESC
+	LIMIT 1
+`
+}
+
+// lastFailedScansQuery returns the query counting the scans of a host
+// failed in a row.
+func lastFailedScansQuery(network string) string {
+	return `
+	SELECT COUNT(*)
+	FROM hdb_scans_` + network + ` AS a
+	WHERE a.public_key = ?
+	AND a.success = FALSE
+	AND a.failure <> ?
+	AND (
+		a.ran_at > (
+			SELECT b.ran_at
+			FROM hdb_scans_` + network + ` AS b
+			WHERE b.public_key = a.public_key
+			AND b.success = TRUE
+			ORDER BY b.ran_at DESC
+			LIMIT 1
+		)
+		OR (
+			SELECT COUNT(*)
+			FROM hdb_scans_` + network + ` AS c
+			WHERE c.public_key = a.public_key
+			AND c.success = TRUE
+		) = 0
+	)
+`
 }
 
 // lastFailedScans returns the number of scans failed in a row.
@@ -308,28 +481,7 @@ func (s *hostDBStore) lastFailedScans(host *HostDBEntry) int {
 	}
 
 	var count int
-	err := s.tx.QueryRow(`
-		SELECT COUNT(*)
-		FROM hdb_scans_`+s.network+` AS a
-		WHERE a.public_key = ?
-		AND a.success = FALSE
-		AND (
-			a.ran_at > (
-				SELECT b.ran_at
-				FROM hdb_scans_`+s.network+` AS b
-				WHERE b.public_key = a.public_key
-				AND b.success = TRUE
-				ORDER BY b.ran_at DESC
-				LIMIT 1
-			)
-			OR (
-				SELECT COUNT(*)
-				FROM hdb_scans_`+s.network+` AS c
-				WHERE c.public_key = a.public_key
-				AND c.success = TRUE
-			) = 0
-		)
-	`, host.PublicKey[:]).Scan(&count)
+	err := s.tx.QueryRow(lastFailedScansQuery(s.network), host.PublicKey[:], FailureProber).Scan(&count)
 	if err != nil {
 		s.log.Error("couldn't query scans", zap.String("network", s.network), zap.Error(err))
 		return 0
@@ -338,6 +490,34 @@ func (s *hostDBStore) lastFailedScans(host *HostDBEntry) int {
 	return count
 }
 
+// lastFailedBenchmarksQuery returns the query counting the benchmarks
+// of a host failed in a row.
+func lastFailedBenchmarksQuery(network string) string {
+	return `
+	SELECT COUNT(*)
+	FROM hdb_benchmarks_` + network + ` AS a
+	WHERE a.public_key = ?
+	AND a.success = FALSE
+	AND a.failure NOT IN (?, ?, ?)
+	AND (
+		a.ran_at > (
+			SELECT b.ran_at
+			FROM hdb_benchmarks_` + network + ` AS b
+			WHERE b.public_key = a.public_key
+			AND b.success = TRUE
+			ORDER BY b.ran_at DESC
+			LIMIT 1
+		)
+		OR (
+			SELECT COUNT(*)
+			FROM hdb_benchmarks_` + network + ` AS c
+			WHERE c.public_key = a.public_key
+			AND c.success = TRUE
+		) = 0
+	)
+`
+}
+
 // lastFailedBenchmarks returns the number of benchmarks failed in a row.
 // NOTE: a lock must be acquired before calling this function.
 func (s *hostDBStore) lastFailedBenchmarks(host *HostDBEntry) int {
@@ -350,28 +530,7 @@ func (s *hostDBStore) lastFailedBenchmarks(host *HostDBEntry) int {
 	}
 
 	var count int
-	err := s.tx.QueryRow(`
-		SELECT COUNT(*)
-		FROM hdb_benchmarks_`+s.network+` AS a
-		WHERE a.public_key = ?
-		AND a.success = FALSE
-		AND (
-			a.ran_at > (
-				SELECT b.ran_at
-				FROM hdb_benchmarks_`+s.network+` AS b
-				WHERE b.public_key = a.public_key
-				AND b.success = TRUE
-				ORDER BY b.ran_at DESC
-				LIMIT 1
-			)
-			OR (
-				SELECT COUNT(*)
-				FROM hdb_benchmarks_`+s.network+` AS c
-				WHERE c.public_key = a.public_key
-				AND c.success = TRUE
-			) = 0
-		)
-	`, host.PublicKey[:]).Scan(&count)
+	err := s.tx.QueryRow(lastFailedBenchmarksQuery(s.network), host.PublicKey[:], FailureProber, FailureRestarted, FailureSkipped).Scan(&count)
 	if err != nil {
 		s.log.Error("couldn't query benchmarks", zap.String("network", s.network), zap.Error(err))
 		return 0
@@ -409,6 +568,13 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 	s.tip.Height = height
 	copy(s.tip.ID[:], id)
 
+	if err := s.loadUpdates(); err != nil {
+		return err
+	}
+	if err := s.loadConsumers(); err != nil {
+		return err
+	}
+
 	rows, err := s.db.Query(`
 		SELECT
 			id,
@@ -429,7 +595,12 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 			last_update,
 			revision,
 			settings,
-			price_table
+			price_table,
+			formation_successes,
+			duration_violations,
+			expiry_successes,
+			expiry_failures,
+			deferred_benchmarks
 		FROM hdb_hosts_` + s.network,
 	)
 	if err != nil {
@@ -445,7 +616,9 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 		var ut, dt, fs, ls, lc int64
 		var hsi, hfi, rsi, rfi float64
 		var rev, settings, pt []byte
-		if err := rows.Scan(&id, &pk, &fs, &ks, &b, &na, &ut, &dt, &ls, &ip, &lc, &hsi, &hfi, &rsi, &rfi, &lu, &rev, &settings, &pt); err != nil {
+		var cc ContractCompliance
+		var db int
+		if err := rows.Scan(&id, &pk, &fs, &ks, &b, &na, &ut, &dt, &ls, &ip, &lc, &hsi, &hfi, &rsi, &rfi, &lu, &rev, &settings, &pt, &cc.FormationSuccesses, &cc.DurationViolations, &cc.ExpirySuccesses, &cc.ExpiryFailures, &db); err != nil {
 			rows.Close()
 			return utils.AddContext(err, "couldn't scan host data")
 		}
@@ -470,6 +643,9 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 				LastUpdate:        lu,
 			},
 		}
+		cc.UpdateViolation()
+		host.Compliance = cc
+		host.Preferences.Deferred = db
 		if len(rev) > 0 {
 			d := types.NewBufDecoder(rev)
 			host.Revision.DecodeFrom(d)
@@ -482,6 +658,7 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 				return utils.AddContext(err, "couldn't decode host settings")
 			}
 		}
+		host.Preferences.update(host.Settings.Release)
 		if len(pt) > 0 {
 			d := types.NewBufDecoder(pt)
 			utils.DecodePriceTable(&host.PriceTable, d)
@@ -490,7 +667,7 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 				return utils.AddContext(err, "couldn't decode host price table")
 			}
 		}
-		if host.Blocked || domains.isBlocked(host.NetAddress) {
+		if host.Blocked || domains.isHostBlocked(host) {
 			host.Blocked = true
 			s.blockedHosts[host.PublicKey] = struct{}{}
 		}
@@ -498,13 +675,7 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 	}
 	rows.Close()
 
-	scanStmt, err := s.db.Prepare(`
-		SELECT ran_at, success, latency, error, settings, price_table
-		FROM hdb_scans_` + s.network + `
-		WHERE public_key = ?
-		ORDER BY ran_at DESC
-		LIMIT 2
-	`)
+	scanStmt, err := s.db.Prepare(latestScansQuery(s.network))
 	if err != nil {
 		return utils.AddContext(err, "couldn't prepare scan statement")
 	}
@@ -536,38 +707,50 @@ func (s *hostDBStore) load(domains *blockedDomains) error {
 	}
 	defer priceTableStmt.Close()
 
-	benchmarkStmt, err := s.db.Prepare(`
-		SELECT ran_at, success, upload_speed, download_speed, ttfb, error
-		FROM hdb_benchmarks_` + s.network + `
-		WHERE public_key = ?
-		ORDER BY ran_at DESC
-		LIMIT 1
-	`)
+	benchmarkStmt, err := s.db.Prepare(latestBenchmarkQuery(s.network))
 	if err != nil {
 		return utils.AddContext(err, "couldn't prepare benchmark statement")
 	}
 	defer benchmarkStmt.Close()
 
 	for _, host := range s.hosts {
-		rows, err := scanStmt.Query(host.PublicKey[:])
+		rows, err := scanStmt.Query(host.PublicKey[:], FailureProber)
 		if err != nil {
 			return utils.AddContext(err, "couldn't query scans")
 		}
 		for rows.Next() {
-			var ra int64
-			var success bool
-			var latency float64
+			var ra, skew, vu int64
+			var success, invalidSig bool
+			var latency, latency4, latency6, dial, handshake, rpc float64
+			var ipv4, ipv6, failure uint8
 			var msg string
 			var settings, pt []byte
-			if err := rows.Scan(&ra, &success, &latency, &msg, &settings, &pt); err != nil {
+			if err := rows.Scan(&ra, &success, &latency, &msg, &failure, &skew, &vu, &invalidSig, &ipv4, &latency4, &ipv6, &latency6, &dial, &handshake, &rpc, &settings, &pt); err != nil {
 				rows.Close()
 				return utils.AddContext(err, "couldn't load scan history")
 			}
 			scan := HostScan{
-				Timestamp: time.Unix(ra, 0),
-				Success:   success,
-				Latency:   time.Duration(latency) * time.Millisecond,
-				Error:     msg,
+				Timestamp:  time.Unix(ra, 0),
+				Success:    success,
+				Latency:    time.Duration(latency) * time.Millisecond,
+				Error:      msg,
+				Failure:    FailureClass(failure),
+				ClockSkew:  skew,
+				ValidUntil: time.Unix(vu, 0),
+				InvalidSig: invalidSig,
+				IPv4: AddressScan{
+					Reachability: Reachability(ipv4),
+					Latency:      time.Duration(latency4) * time.Millisecond,
+				},
+				IPv6: AddressScan{
+					Reachability: Reachability(ipv6),
+					Latency:      time.Duration(latency6) * time.Millisecond,
+				},
+				Timings: ScanTimings{
+					Dial:      utils.MSToDuration(dial),
+					Handshake: utils.MSToDuration(handshake),
+					Settings:  utils.MSToDuration(rpc),
+				},
 			}
 			if len(settings) > 0 {
 				d := types.NewBufDecoder(settings)
@@ -671,8 +854,12 @@ func (s *hostDBStore) updateChainState(applied []chain.ApplyUpdate, mayCommit bo
 			row = 2
 		}
 		_, err := s.tx.Exec(`
-			REPLACE INTO hdb_tip (id, network, height, bid)
-			VALUES (?, ?, ?, ?)
+			INSERT INTO hdb_tip (id, network, height, bid)
+			VALUES (?, ?, ?, ?) AS new
+			ON DUPLICATE KEY (id) UPDATE
+				network = new.network,
+				height = new.height,
+				bid = new.bid
 		`, row, s.network, s.tip.Height, s.tip.ID[:])
 		if err != nil {
 			s.log.Error("couldn't update tip", zap.String("network", s.network), zap.Error(err))
@@ -765,15 +952,7 @@ func (s *hostDBStore) updateChainState(applied []chain.ApplyUpdate, mayCommit bo
 	}
 
 	if mayCommit || time.Since(s.lastCommitted) >= 3*time.Second {
-		err := s.tx.Commit()
-		if err != nil {
-			return utils.AddContext(err, "couldn't commit transaction")
-		}
-		s.lastCommitted = time.Now()
-		s.tx, err = s.db.Begin()
-		if err != nil {
-			return utils.AddContext(err, "couldn't start transaction")
-		}
+		return s.commit()
 	}
 
 	return nil
@@ -805,229 +984,372 @@ func (s *hostDBStore) checkSubnets(ipNets []string) int {
 	return s.activeHostsInSubnet(ipNets)
 }
 
-// getRecentUpdates returns the most recently updated database records
-// since the last retrieval.
+// getRecentUpdates returns the records of the batch of updates of the
+// consumer.
+func (s *hostDBStore) getRecentUpdates(consumer string, id UpdateID) (updates HostUpdates, err error) {
+	updates.Backlog, err = s.streamUpdates(consumer, id, func(item UpdateItem) error {
+		switch {
+		case item.Host != nil:
+			updates.Hosts = append(updates.Hosts, *item.Host)
+		case item.Scan != nil:
+			updates.Scans = append(updates.Scans, *item.Scan)
+		case item.Benchmark != nil:
+			updates.Benchmarks = append(updates.Benchmarks, *item.Benchmark)
+		}
+		return nil
+	})
+	if err != nil {
+		return HostUpdates{}, err
+	}
+	updates.ID = id
+	return
+}
+
+// pickUpdates selects the most recently updated database records since
+// the last retrieval for the batch of updates with the given ID, and
+// persists the batch before it is sent.
 // The batch size is limited to avoid sending too large responses.
-func (s *hostDBStore) getRecentUpdates(id UpdateID) (updates HostUpdates, err error) {
+func (s *hostDBStore) pickUpdates(id UpdateID, limit int) error {
 	if s.tx == nil {
 		s.log.Error("there is no transaction", zap.String("network", s.network))
-		return HostUpdates{}, errors.New("no database transaction")
+		return errors.New("no database transaction")
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	rows, err := s.tx.Query(`
-		SELECT public_key
-		FROM hdb_hosts_` + s.network + `
-		WHERE modified > fetched
-		ORDER BY id ASC
-		LIMIT 1000
-	`)
-	if err != nil {
-		return HostUpdates{}, utils.AddContext(err, "couldn't query hosts")
-	}
-
-	for rows.Next() {
-		pk := make([]byte, 32)
-		if err := rows.Scan(&pk); err != nil {
-			rows.Close()
-			return HostUpdates{}, utils.AddContext(err, "couldn't decode host data")
-		}
-		host := s.hosts[types.PublicKey(pk)]
-		host.ActiveHosts = s.activeHostsInSubnet(host.IPNets)
-		updates.Hosts = append(updates.Hosts, *host)
-	}
-	rows.Close()
-
-	rows, err = s.tx.Query(`
-		SELECT s.id, s.public_key, s.ran_at, s.success, s.latency, s.error, s.settings, s.price_table
-		FROM hdb_scans_` + s.network + ` s
-		JOIN hdb_hosts_` + s.network + ` h
-		ON s.public_key = h.public_key
-		WHERE s.modified > s.fetched
-		AND h.modified <= h.fetched
-		ORDER BY s.id ASC
-		LIMIT 1000
-	`)
-	if err != nil {
-		return HostUpdates{}, utils.AddContext(err, "couldn't query scans")
-	}
-
-	for rows.Next() {
-		var id, ra int64
-		var success bool
-		var latency float64
-		var msg string
-		var settings, pt []byte
-		pk := make([]byte, 32)
-		if err := rows.Scan(&id, &pk, &ra, &success, &latency, &msg, &settings, &pt); err != nil {
-			rows.Close()
-			return HostUpdates{}, utils.AddContext(err, "couldn't decode scans")
-		}
-		scan := ScanHistory{
-			HostScan: HostScan{
-				ID:        id,
-				Timestamp: time.Unix(ra, 0),
-				Success:   success,
-				Latency:   time.Duration(latency) * time.Millisecond,
-				Error:     msg,
-			},
-			PublicKey: types.PublicKey(pk),
-			Network:   s.network,
+	var pending pendingUpdates
+	err := s.commitWithRetry(func() error {
+		pending = pendingUpdates{id: id}
+		rows, err := s.tx.Query(`
+			SELECT id
+			FROM hdb_hosts_`+s.network+`
+			WHERE fetched = 0
+			ORDER BY id ASC
+			LIMIT ?
+		`, limit)
+		if err != nil {
+			return utils.AddContext(err, "couldn't query hosts")
 		}
-		if len(settings) > 0 {
-			d := types.NewBufDecoder(settings)
-			utils.DecodeSettings(&scan.Settings, d)
-			if err := d.Err(); err != nil {
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
 				rows.Close()
-				return HostUpdates{}, utils.AddContext(err, "couldn't decode host settings")
+				return utils.AddContext(err, "couldn't decode host ID")
 			}
+			pending.hosts = append(pending.hosts, id)
 		}
-		if len(pt) > 0 {
-			d := types.NewBufDecoder(pt)
-			utils.DecodePriceTable(&scan.PriceTable, d)
-			if err := d.Err(); err != nil {
-				rows.Close()
-				return HostUpdates{}, utils.AddContext(err, "couldn't decode host price table")
+		rows.Close()
+
+		// The scans and the benchmarks are only sent after their host.
+		for _, table := range []string{"scans", "benchmarks"} {
+			rows, err := s.tx.Query(`
+				SELECT r.id
+				FROM hdb_`+table+`_`+s.network+` r
+				JOIN hdb_hosts_`+s.network+` h
+				ON r.public_key = h.public_key
+				WHERE r.fetched = 0
+				AND h.fetched > 0
+				ORDER BY r.id ASC
+				LIMIT ?
+			`, limit)
+			if err != nil {
+				return utils.AddContext(err, "couldn't query "+table)
 			}
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return utils.AddContext(err, "couldn't decode record ID")
+				}
+				if table == "scans" {
+					pending.scans = append(pending.scans, id)
+				} else {
+					pending.benchmarks = append(pending.benchmarks, id)
+				}
+			}
+			rows.Close()
 		}
-		updates.Scans = append(updates.Scans, scan)
-	}
-	rows.Close()
 
-	rows, err = s.tx.Query(`
-		SELECT b.id, b.public_key, b.ran_at, b.success, b.upload_speed, b.download_speed, b.ttfb, b.error
-		FROM hdb_benchmarks_` + s.network + ` b
-		JOIN hdb_hosts_` + s.network + ` h
-		ON b.public_key = h.public_key
-		WHERE b.modified > b.fetched
-		AND h.modified <= h.fetched
-		ORDER BY b.id ASC
-		LIMIT 1000
-	`)
+		return s.saveUpdates(s.acked, pending)
+	})
 	if err != nil {
-		return HostUpdates{}, utils.AddContext(err, "couldn't query benchmarks")
-	}
-
-	for rows.Next() {
-		var id, ra int64
-		var success bool
-		var ul, dl, ttfb float64
-		var msg string
-		pk := make([]byte, 32)
-		if err := rows.Scan(&id, &pk, &ra, &success, &ul, &dl, &ttfb, &msg); err != nil {
-			rows.Close()
-			return HostUpdates{}, utils.AddContext(err, "couldn't decode benchmarks")
-		}
-		benchmark := BenchmarkHistory{
-			HostBenchmark: HostBenchmark{
-				ID:            id,
-				Timestamp:     time.Unix(ra, 0),
-				Success:       success,
-				UploadSpeed:   ul,
-				DownloadSpeed: dl,
-				TTFB:          time.Duration(ttfb) * time.Millisecond,
-				Error:         msg,
-			},
-			PublicKey: types.PublicKey(pk),
-			Network:   s.network,
-		}
-		updates.Benchmarks = append(updates.Benchmarks, benchmark)
+		return err
 	}
-	rows.Close()
-
-	updates.ID = id
-	s.lastUpdate = updates
 
-	return
+	s.lastUpdate = pending
+	return nil
 }
 
-// finalizeUpdates updates the timestamps after the client confirms the data receipt.
-func (s *hostDBStore) finalizeUpdates(id UpdateID) error {
-	if id != s.lastUpdate.ID {
-		return nil
+// streamUpdates passes the records of the batch of updates of the
+// consumer to emit one by one, and returns the number of the records
+// after the batch.
+func (s *hostDBStore) streamUpdates(consumer string, id UpdateID, emit func(UpdateItem) error) (int, error) {
+	if consumer != "" {
+		return s.streamConsumerUpdates(consumer, id, emit)
 	}
+	return s.streamRecentUpdates(id, emit)
+}
 
+// streamRecentUpdates passes the records of the batch of updates to emit
+// one by one, and returns the number of the records that didn't fit into
+// the batch. Nothing is emitted if the store has no part in the batch.
+// The records are collected first, so that the store isn't locked while
+// emit is running.
+func (s *hostDBStore) streamRecentUpdates(id UpdateID, emit func(UpdateItem) error) (backlog int, err error) {
 	if s.tx == nil {
 		s.log.Error("there is no transaction", zap.String("network", s.network))
-		return errors.New("no database transaction")
+		return 0, errors.New("no database transaction")
+	}
+
+	items, backlog, err := s.recentUpdateItems(id)
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		if err := emit(item); err != nil {
+			return 0, err
+		}
 	}
 
+	return backlog, nil
+}
+
+// recentUpdateItems returns the records of the batch of updates and the
+// number of the records that didn't fit into the batch.
+func (s *hostDBStore) recentUpdateItems(id UpdateID) (items []UpdateItem, backlog int, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	hostStsmt, err := s.tx.Prepare(`
-		UPDATE hdb_hosts_` + s.network + `
-		SET fetched = ?
-		WHERE id = ?
-	`)
-	if err != nil {
-		return utils.AddContext(err, "couldn't prepare host statement")
+	var pending pendingUpdates
+	if s.lastUpdate.id == id {
+		pending = s.lastUpdate
 	}
 
-	for _, host := range s.lastUpdate.Hosts {
-		if host.Network != s.network {
-			continue
-		}
-		_, err := hostStsmt.Exec(time.Now().Unix(), host.ID)
-		if err != nil {
-			hostStsmt.Close()
-			return utils.AddContext(err, "couldn't update timestamp in hosts table")
-		}
+	items, err = s.updateItems(pending)
+	if err != nil {
+		return nil, 0, err
 	}
-	hostStsmt.Close()
 
-	scanStmt, err := s.tx.Prepare(`
-		UPDATE hdb_scans_` + s.network + `
-		SET fetched = ?
-		WHERE id = ?
-	`)
+	// Count the rows that didn't fit into the batch.
+	var total int
+	err = s.tx.QueryRow(pendingCountQuery(s.network)).Scan(&total)
 	if err != nil {
-		return utils.AddContext(err, "couldn't prepare scan statement")
+		return nil, 0, utils.AddContext(err, "couldn't count pending updates")
 	}
+	backlog = max(total-len(pending.hosts)-len(pending.scans)-len(pending.benchmarks), 0)
 
-	for _, scan := range s.lastUpdate.Scans {
-		if scan.Network != s.network {
-			continue
+	return
+}
+
+// updateItems returns the records of the batch. The records deleted in
+// the meantime are skipped.
+// NOTE: a lock must be acquired before calling updateItems.
+func (s *hostDBStore) updateItems(pending pendingUpdates) (items []UpdateItem, err error) {
+	if len(pending.hosts) > 0 {
+		hosts := make(map[int]*HostDBEntry)
+		for _, host := range s.hosts {
+			hosts[host.ID] = host
 		}
-		_, err := scanStmt.Exec(time.Now().Unix(), scan.ID)
-		if err != nil {
-			scanStmt.Close()
-			return utils.AddContext(err, "couldn't update timestamp in scans table")
+		for _, id := range pending.hosts {
+			host, exists := hosts[id]
+			if !exists {
+				continue
+			}
+			host.ActiveHosts = s.activeHostsInSubnet(host.IPNets)
+			entry := *host
+			items = append(items, UpdateItem{Host: &entry})
 		}
 	}
-	scanStmt.Close()
 
-	benchmarkStmt, err := s.tx.Prepare(`
-		UPDATE hdb_benchmarks_` + s.network + `
-		SET fetched = ?
-		WHERE id = ?
-	`)
-	if err != nil {
-		return utils.AddContext(err, "couldn't prepare benchmark statement")
+	if len(pending.scans) > 0 {
+		ids := make(map[int64]struct{})
+		for _, id := range pending.scans {
+			ids[id] = struct{}{}
+		}
+		rows, err := s.tx.Query(`
+			SELECT id, public_key, ran_at, success, latency, error, failure, clock_skew, valid_until, invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency, dial_time, handshake_time, settings_time, settings, price_table
+			FROM hdb_scans_`+s.network+`
+			WHERE id >= ?
+			AND id <= ?
+			ORDER BY id ASC
+		`, pending.scans[0], pending.scans[len(pending.scans)-1])
+		if err != nil {
+			return nil, utils.AddContext(err, "couldn't query scans")
+		}
+
+		for rows.Next() {
+			var id, ra, skew, vu int64
+			var success, invalidSig bool
+			var latency, latency4, latency6, dial, handshake, rpc float64
+			var ipv4, ipv6, failure uint8
+			var msg string
+			var settings, pt []byte
+			pk := make([]byte, 32)
+			if err := rows.Scan(&id, &pk, &ra, &success, &latency, &msg, &failure, &skew, &vu, &invalidSig, &ipv4, &latency4, &ipv6, &latency6, &dial, &handshake, &rpc, &settings, &pt); err != nil {
+				rows.Close()
+				return nil, utils.AddContext(err, "couldn't decode scans")
+			}
+			if _, ok := ids[id]; !ok {
+				continue
+			}
+			scan := ScanHistory{
+				HostScan: HostScan{
+					ID:         id,
+					Timestamp:  time.Unix(ra, 0),
+					Success:    success,
+					Latency:    time.Duration(latency) * time.Millisecond,
+					Error:      msg,
+					Failure:    FailureClass(failure),
+					ClockSkew:  skew,
+					ValidUntil: time.Unix(vu, 0),
+					InvalidSig: invalidSig,
+					IPv4: AddressScan{
+						Reachability: Reachability(ipv4),
+						Latency:      time.Duration(latency4) * time.Millisecond,
+					},
+					IPv6: AddressScan{
+						Reachability: Reachability(ipv6),
+						Latency:      time.Duration(latency6) * time.Millisecond,
+					},
+					Timings: ScanTimings{
+						Dial:      utils.MSToDuration(dial),
+						Handshake: utils.MSToDuration(handshake),
+						Settings:  utils.MSToDuration(rpc),
+					},
+				},
+				PublicKey: types.PublicKey(pk),
+				Network:   s.network,
+			}
+			if len(settings) > 0 {
+				d := types.NewBufDecoder(settings)
+				utils.DecodeSettings(&scan.Settings, d)
+				if err := d.Err(); err != nil {
+					rows.Close()
+					return nil, utils.AddContext(err, "couldn't decode host settings")
+				}
+			}
+			if len(pt) > 0 {
+				d := types.NewBufDecoder(pt)
+				utils.DecodePriceTable(&scan.PriceTable, d)
+				if err := d.Err(); err != nil {
+					rows.Close()
+					return nil, utils.AddContext(err, "couldn't decode host price table")
+				}
+			}
+			items = append(items, UpdateItem{Scan: &scan})
+		}
+		rows.Close()
 	}
 
-	for _, benchmark := range s.lastUpdate.Benchmarks {
-		if benchmark.Network != s.network {
-			continue
+	if len(pending.benchmarks) > 0 {
+		ids := make(map[int64]struct{})
+		for _, id := range pending.benchmarks {
+			ids[id] = struct{}{}
 		}
-		_, err := benchmarkStmt.Exec(time.Now().Unix(), benchmark.ID)
+		rows, err := s.tx.Query(`
+			SELECT id, public_key, ran_at, success, upload_speed, download_speed, ttfb, uploaded, downloaded, error, failure
+			FROM hdb_benchmarks_`+s.network+`
+			WHERE id >= ?
+			AND id <= ?
+			ORDER BY id ASC
+		`, pending.benchmarks[0], pending.benchmarks[len(pending.benchmarks)-1])
 		if err != nil {
-			benchmarkStmt.Close()
-			return utils.AddContext(err, "couldn't update timestamp in benchmarks table")
+			return nil, utils.AddContext(err, "couldn't query benchmarks")
+		}
+
+		for rows.Next() {
+			var id, ra int64
+			var success bool
+			var ul, dl, ttfb float64
+			var uploaded, downloaded uint64
+			var failure uint8
+			var msg string
+			pk := make([]byte, 32)
+			if err := rows.Scan(&id, &pk, &ra, &success, &ul, &dl, &ttfb, &uploaded, &downloaded, &msg, &failure); err != nil {
+				rows.Close()
+				return nil, utils.AddContext(err, "couldn't decode benchmarks")
+			}
+			if _, ok := ids[id]; !ok {
+				continue
+			}
+			benchmark := BenchmarkHistory{
+				HostBenchmark: HostBenchmark{
+					ID:            id,
+					Timestamp:     time.Unix(ra, 0),
+					Success:       success,
+					UploadSpeed:   ul,
+					DownloadSpeed: dl,
+					TTFB:          time.Duration(ttfb) * time.Millisecond,
+					Uploaded:      uploaded,
+					Downloaded:    downloaded,
+					Error:         msg,
+					Failure:       FailureClass(failure),
+				},
+				PublicKey: types.PublicKey(pk),
+				Network:   s.network,
+			}
+			items = append(items, UpdateItem{Benchmark: &benchmark})
 		}
+		rows.Close()
 	}
-	benchmarkStmt.Close()
 
-	s.lastUpdate = HostUpdates{}
+	return items, nil
+}
 
-	if err := s.tx.Commit(); err != nil {
-		return utils.AddContext(err, "couldn't commit transaction")
+// finalizeUpdates marks the records of the batch as fetched after the
+// client confirms the receipt. Confirming a batch again has no effect.
+func (s *hostDBStore) finalizeUpdates(id UpdateID) error {
+	if s.tx == nil {
+		s.log.Error("there is no transaction", zap.String("network", s.network))
+		return errors.New("no database transaction")
 	}
 
-	s.tx, err = s.db.Begin()
-	return err
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == 0 || id != s.lastUpdate.id {
+		return nil
+	}
+
+	err := s.commitWithRetry(func() error {
+		for _, table := range []string{"hosts", "scans", "benchmarks"} {
+			stmt, err := s.tx.Prepare(`
+				UPDATE hdb_` + table + `_` + s.network + `
+				SET fetched = ?
+				WHERE id = ?
+			`)
+			if err != nil {
+				return utils.AddContext(err, "couldn't prepare "+table+" statement")
+			}
+			var ids []int64
+			switch table {
+			case "hosts":
+				for _, id := range s.lastUpdate.hosts {
+					ids = append(ids, int64(id))
+				}
+			case "scans":
+				ids = s.lastUpdate.scans
+			case "benchmarks":
+				ids = s.lastUpdate.benchmarks
+			}
+			for _, id := range ids {
+				if _, err := stmt.Exec(time.Now().Unix(), id); err != nil {
+					stmt.Close()
+					return utils.AddContext(err, "couldn't update timestamp in "+table+" table")
+				}
+			}
+			stmt.Close()
+		}
+		return s.saveUpdates(id, pendingUpdates{})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.acked = id
+	s.lastUpdate = pendingUpdates{}
+	return nil
 }
 
 func (s *hostDBStore) getHostsForScan() {
@@ -1057,26 +1379,39 @@ func (s *hostDBStore) pruneOldRecords() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.tx.Exec(`
-		DELETE FROM hdb_scans_`+s.network+`
-		WHERE ran_at < ?
-	`, time.Now().AddDate(0, 0, -7).Unix())
-	if err != nil {
-		return utils.AddContext(err, "couldn't delete old scans")
-	}
-
-	_, err = s.tx.Exec(`
-		DELETE FROM hdb_benchmarks_`+s.network+`
-		WHERE ran_at < ?
-	`, time.Now().AddDate(0, 0, -28).Unix())
-	if err != nil {
-		return utils.AddContext(err, "couldn't delete old benchmarks")
+	// The records of the batches not confirmed yet are kept, because the
+	// batches need to be sent again unchanged.
+	batches := []pendingUpdates{s.lastUpdate}
+	for _, cu := range s.consumers {
+		batches = append(batches, cu.pending)
+	}
+	keep := func(records func(pendingUpdates) []int64) (cond string, args []any) {
+		for _, pending := range batches {
+			if ids := records(pending); len(ids) > 0 {
+				cond += " AND (id < ? OR id > ?)"
+				args = append(args, ids[0], ids[len(ids)-1])
+			}
+		}
+		return
 	}
+	scans := func(pu pendingUpdates) []int64 { return pu.scans }
+	benchmarks := func(pu pendingUpdates) []int64 { return pu.benchmarks }
 
-	if err := s.tx.Commit(); err != nil {
-		return utils.AddContext(err, "couldn't commit transaction")
-	}
+	return s.commitWithRetry(func() error {
+		cond, args := keep(scans)
+		_, err := s.tx.Exec(`
+			DELETE FROM hdb_scans_`+s.network+`
+			WHERE ran_at < ?
+			`+cond, append([]any{time.Now().AddDate(0, 0, -7).Unix()}, args...)...)
+		if err != nil {
+			return utils.AddContext(err, "couldn't delete old scans")
+		}
 
-	s.tx, err = s.db.Begin()
-	return err
+		cond, args = keep(benchmarks)
+		_, err = s.tx.Exec(`
+			DELETE FROM hdb_benchmarks_`+s.network+`
+			WHERE ran_at < ?
+			`+cond, append([]any{time.Now().AddDate(0, 0, -28).Unix()}, args...)...)
+		return utils.AddContext(err, "couldn't delete old benchmarks")
+	})
 }
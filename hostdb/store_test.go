@@ -0,0 +1,323 @@
+package hostdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/testutil"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+func TestPruneOldRecords(t *testing.T) {
+	db := testutil.NewDB(t, "init_sqlite.sql")
+	pk := testutil.AddNodeHost(t, db, "mainnet")
+
+	now := time.Now()
+	for _, ranAt := range []time.Time{
+		now.AddDate(0, 0, -30), // past both thresholds
+		now.AddDate(0, 0, -10), // past the scan threshold only
+		now.Add(-time.Hour),
+	} {
+		if _, err := db.Exec(`
+			INSERT INTO hdb_scans_mainnet (public_key, ran_at, success, latency, error, modified, fetched)
+			VALUES (?, ?, TRUE, 0, '', 0, 0)
+		`, pk[:], ranAt.Unix()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`
+			INSERT INTO hdb_benchmarks_mainnet (public_key, ran_at, success, upload_speed, download_speed, ttfb, error, modified, fetched)
+			VALUES (?, ?, TRUE, 0, 0, 0, '', 0, 0)
+		`, pk[:], ranAt.Unix()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &hostDBStore{
+		db:      db,
+		tx:      tx,
+		log:     zap.NewNop(),
+		network: "mainnet",
+	}
+	if err := s.pruneOldRecords(); err != nil {
+		t.Fatal(err)
+	}
+	s.tx.Rollback()
+
+	if n := testutil.CountRows(t, db, "hdb_scans_mainnet"); n != 1 {
+		t.Fatalf("expected 1 scan left, got %d", n)
+	}
+	if n := testutil.CountRows(t, db, "hdb_benchmarks_mainnet"); n != 2 {
+		t.Fatalf("expected 2 benchmarks left, got %d", n)
+	}
+}
+
+func TestPruneOldRecordsKeepsPendingBatch(t *testing.T) {
+	db := testutil.NewDB(t, "init_sqlite.sql")
+	pk := testutil.AddNodeHost(t, db, "zen")
+
+	old := time.Now().AddDate(0, 0, -30).Unix()
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		res, err := db.Exec(`
+			INSERT INTO hdb_scans_zen (public_key, ran_at, success, latency, error, modified, fetched)
+			VALUES (?, ?, TRUE, 0, '', 0, 0)
+		`, pk[:], old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &hostDBStore{
+		db:         db,
+		tx:         tx,
+		log:        zap.NewNop(),
+		network:    "zen",
+		lastUpdate: pendingUpdates{id: 1, scans: ids[1:]},
+	}
+	if err := s.pruneOldRecords(); err != nil {
+		t.Fatal(err)
+	}
+	s.tx.Rollback()
+
+	// The scans of the unconfirmed batch must be sent again unchanged.
+	if n := testutil.CountRows(t, db, "hdb_scans_zen"); n != 2 {
+		t.Fatalf("expected 2 scans left, got %d", n)
+	}
+}
+
+func TestConsumerUpdates(t *testing.T) {
+	db := testutil.NewDB(t, "init_sqlite.sql")
+	pk := testutil.AddNodeHost(t, db, "mainnet")
+	pkLate := testutil.AddNodeHost(t, db, "mainnet")
+
+	// The second host is modified no earlier than the current second, so
+	// it is left for a later batch, together with its scan.
+	now := time.Now().Unix()
+	if _, err := db.Exec("UPDATE hdb_hosts_mainnet SET modified = ? WHERE public_key = ?", now+60, pkLate[:]); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []types.PublicKey{pk, pk, pkLate} {
+		if _, err := db.Exec(`
+			INSERT INTO hdb_scans_mainnet (public_key, ran_at, success, latency, error, modified, fetched)
+			VALUES (?, ?, TRUE, 0, '', ?, 0)
+		`, key[:], now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newStore := func() *hostDBStore {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &hostDBStore{
+			db:      db,
+			tx:      tx,
+			log:     zap.NewNop(),
+			network: "mainnet",
+		}
+		t.Cleanup(func() { s.tx.Rollback() })
+		if err := s.loadConsumers(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	s := newStore()
+
+	stream := func(id UpdateID) (scans, backlog int) {
+		t.Helper()
+		backlog, err := s.streamConsumerUpdates("shadow", id, func(item UpdateItem) error {
+			if item.Scan != nil {
+				scans++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if err := s.pickConsumerUpdates("shadow", 1, 100); err != nil {
+		t.Fatal(err)
+	}
+	if cu := s.consumers["shadow"]; len(cu.pending.hosts) != 1 || len(cu.pending.scans) != 2 {
+		t.Fatalf("expected 1 host and 2 scans, got %d and %d", len(cu.pending.hosts), len(cu.pending.scans))
+	}
+	if scans, backlog := stream(1); scans != 2 || backlog != 2 {
+		t.Fatalf("expected 2 scans and a backlog of 2, got %d and %d", scans, backlog)
+	}
+
+	// The default consumer is not affected.
+	if n := testutil.CountRows(t, db, "hdb_scans_mainnet WHERE fetched > 0"); n != 0 {
+		t.Fatalf("expected no fetched scans, got %d", n)
+	}
+
+	if err := s.finalizeConsumerUpdates("shadow", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.tx.Exec("UPDATE hdb_hosts_mainnet SET modified = ? WHERE public_key = ?", now-1, pkLate[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cursor survives a restart.
+	if err := s.tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	s = newStore()
+	if err := s.pickConsumerUpdates("shadow", 2, 100); err != nil {
+		t.Fatal(err)
+	}
+	if cu := s.consumers["shadow"]; cu.acked != 1 || len(cu.pending.hosts) != 1 || len(cu.pending.scans) != 1 {
+		t.Fatalf("expected 1 host and 1 scan after batch 1, got %d and %d after batch %d", len(cu.pending.hosts), len(cu.pending.scans), cu.acked)
+	}
+	if scans, backlog := stream(2); scans != 1 || backlog != 0 {
+		t.Fatalf("expected 1 scan and no backlog, got %d and %d", scans, backlog)
+	}
+}
+
+func TestPendingUpdates(t *testing.T) {
+	db := testutil.NewDB(t, "init_sqlite.sql")
+	testutil.AddNodeHost(t, db, "mainnet")
+	testutil.AddNodeHost(t, db, "mainnet")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &hostDBStore{
+		db:      db,
+		tx:      tx,
+		log:     zap.NewNop(),
+		network: "mainnet",
+	}
+	t.Cleanup(func() { s.tx.Rollback() })
+
+	pick := func(id UpdateID) (hosts, backlog int) {
+		t.Helper()
+		if err := s.pickUpdates(id, 1); err != nil {
+			t.Fatal(err)
+		}
+		backlog, err := s.streamRecentUpdates(id, func(UpdateItem) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(s.lastUpdate.hosts), backlog
+	}
+
+	if hosts, backlog := pick(1); hosts != 1 || backlog != 1 {
+		t.Fatalf("expected 1 host and a backlog of 1, got %d and %d", hosts, backlog)
+	}
+	if err := s.finalizeUpdates(1); err != nil {
+		t.Fatal(err)
+	}
+	if hosts, backlog := pick(2); hosts != 1 || backlog != 0 {
+		t.Fatalf("expected 1 host and no backlog, got %d and %d", hosts, backlog)
+	}
+	if err := s.finalizeUpdates(2); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CountRows(t, db, "hdb_hosts_mainnet WHERE fetched = 0"); n != 0 {
+		t.Fatalf("expected no pending hosts, got %d", n)
+	}
+}
+
+func TestCommitWithRetry(t *testing.T) {
+	db := testutil.NewDB(t, "init_sqlite.sql")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &hostDBStore{
+		db:      db,
+		tx:      tx,
+		log:     zap.NewNop(),
+		network: "mainnet",
+	}
+	t.Cleanup(func() { s.tx.Rollback() })
+
+	// A statement of the chain state pending in the current transaction.
+	if _, err := s.tx.Exec(`
+		REPLACE INTO hdb_tip (id, network, height, bid)
+		VALUES (1, 'mainnet', 1, ?)
+	`, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed operation must only roll back its own statements.
+	err = s.commitWithRetry(func() error {
+		if _, err := s.tx.Exec(`
+			INSERT INTO hdb_updates (network, acked, pending)
+			VALUES ('mainnet', 1, ?)
+		`, []byte{}); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Fatal("expected the error to be returned")
+	}
+	if n := testutil.CountRows(t, db, "hdb_tip"); n != 1 {
+		t.Fatalf("expected the tip to be committed, got %d rows", n)
+	}
+	if n := testutil.CountRows(t, db, "hdb_updates"); n != 0 {
+		t.Fatalf("expected the update to be rolled back, got %d rows", n)
+	}
+}
+
+func TestStreamUpdatesUnlocked(t *testing.T) {
+	db := testutil.NewDB(t, "init_sqlite.sql")
+	pk := testutil.AddNodeHost(t, db, "mainnet")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &hostDBStore{
+		db:      db,
+		tx:      tx,
+		log:     zap.NewNop(),
+		network: "mainnet",
+		hosts: map[types.PublicKey]*HostDBEntry{
+			pk: {ID: 1, PublicKey: pk, Network: "mainnet"},
+		},
+	}
+	t.Cleanup(func() { s.tx.Rollback() })
+	if err := s.pickUpdates(1, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow client must not keep the store locked.
+	done := make(chan error, 1)
+	go func() {
+		var n int
+		_, err := s.streamRecentUpdates(1, func(UpdateItem) error {
+			s.updateIDs()
+			n++
+			return nil
+		})
+		if err == nil && n != 1 {
+			err = fmt.Errorf("expected 1 host, got %d", n)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the store is locked while streaming")
+	}
+}
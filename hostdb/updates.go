@@ -0,0 +1,148 @@
+package hostdb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// EncodeTo implements types.EncoderTo.
+func (pu pendingUpdates) EncodeTo(e *types.Encoder) {
+	e.WriteUint64(pu.id)
+	e.WriteUint64(uint64(len(pu.hosts)))
+	for _, id := range pu.hosts {
+		e.WriteUint64(uint64(id))
+	}
+	e.WriteUint64(uint64(len(pu.scans)))
+	for _, id := range pu.scans {
+		e.WriteUint64(uint64(id))
+	}
+	e.WriteUint64(uint64(len(pu.benchmarks)))
+	for _, id := range pu.benchmarks {
+		e.WriteUint64(uint64(id))
+	}
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (pu *pendingUpdates) DecodeFrom(d *types.Decoder) {
+	pu.id = d.ReadUint64()
+	pu.hosts = make([]int, d.ReadUint64())
+	for i := range pu.hosts {
+		pu.hosts[i] = int(d.ReadUint64())
+	}
+	pu.scans = make([]int64, d.ReadUint64())
+	for i := range pu.scans {
+		pu.scans[i] = int64(d.ReadUint64())
+	}
+	pu.benchmarks = make([]int64, d.ReadUint64())
+	for i := range pu.benchmarks {
+		pu.benchmarks[i] = int64(d.ReadUint64())
+	}
+}
+
+// loadUpdates loads the ID of the last confirmed batch of updates and the
+// batch not confirmed yet.
+func (s *hostDBStore) loadUpdates() error {
+	var acked uint64
+	var pending []byte
+	err := s.db.QueryRow(`
+		SELECT acked, pending
+		FROM hdb_updates
+		WHERE network = ?
+	`, s.network).Scan(&acked, &pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return utils.AddContext(err, "couldn't load updates")
+	}
+
+	s.acked = acked
+	if len(pending) > 0 {
+		d := types.NewBufDecoder(pending)
+		s.lastUpdate.DecodeFrom(d)
+		if err := d.Err(); err != nil {
+			return utils.AddContext(err, "couldn't decode pending updates")
+		}
+	}
+
+	return nil
+}
+
+// saveUpdates persists the ID of the last confirmed batch of updates and
+// the batch not confirmed yet.
+// NOTE: a lock must be acquired before calling saveUpdates.
+func (s *hostDBStore) saveUpdates(acked UpdateID, pending pendingUpdates) error {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	pending.EncodeTo(e)
+	e.Flush()
+	_, err := s.tx.Exec(`
+		INSERT INTO hdb_updates (network, acked, pending)
+		VALUES (?, ?, ?) AS new
+		ON DUPLICATE KEY (network) UPDATE
+			acked = new.acked,
+			pending = new.pending
+	`, s.network, acked, buf.Bytes())
+	return utils.AddContext(err, "couldn't save updates")
+}
+
+// updateIDs returns the ID of the last confirmed batch of updates and
+// the ID of the batch not confirmed yet, which is zero if there is none.
+func (s *hostDBStore) updateIDs() (acked, pending UpdateID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acked, s.lastUpdate.id
+}
+
+// nextUpdates returns the ID of the batch of updates to send. A batch not
+// confirmed yet is sent again unchanged, otherwise a new batch is picked
+// with the next sequence number.
+func (hdb *HostDB) nextUpdates(limit int) (UpdateID, error) {
+	hdb.updatesMu.Lock()
+	defer hdb.updatesMu.Unlock()
+
+	acked, pending := hdb.s.updateIDs()
+	ackedZen, pendingZen := hdb.sZen.updateIDs()
+	if id := max(pending, pendingZen); id != 0 {
+		return id, nil
+	}
+
+	id := max(acked, ackedZen) + 1
+	if err := hdb.s.pickUpdates(id, limit); err != nil {
+		return 0, err
+	}
+	if err := hdb.sZen.pickUpdates(id, limit); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// nextBatch returns the ID of the batch of updates to send to the
+// consumer.
+func (hdb *HostDB) nextBatch(consumer string, limit int) (UpdateID, error) {
+	if consumer != "" {
+		return hdb.nextConsumerUpdates(consumer, limit)
+	}
+	return hdb.nextUpdates(limit)
+}
+
+// UpdatesSignal returns a channel that is closed as soon as new updates
+// are recorded.
+func (hdb *HostDB) UpdatesSignal() <-chan struct{} {
+	hdb.signalMu.Lock()
+	defer hdb.signalMu.Unlock()
+	return hdb.updatesSignal
+}
+
+// notifyUpdates closes the channel returned by UpdatesSignal.
+func (hdb *HostDB) notifyUpdates() {
+	hdb.signalMu.Lock()
+	defer hdb.signalMu.Unlock()
+	close(hdb.updatesSignal)
+	hdb.updatesSignal = make(chan struct{})
+}
@@ -34,6 +34,13 @@ CREATE TABLE wt_locked (
 
 /* hostdb */
 DROP TABLE IF EXISTS hdb_domains;
+DROP TABLE IF EXISTS hdb_optouts;
+DROP TABLE IF EXISTS hdb_allowlist;
+DROP TABLE IF EXISTS hdb_spending;
+DROP TABLE IF EXISTS hdb_contracts;
+DROP TABLE IF EXISTS hdb_host_spending;
+DROP TABLE IF EXISTS hdb_consumers;
+DROP TABLE IF EXISTS hdb_updates;
 DROP TABLE IF EXISTS hdb_tip;
 DROP TABLE IF EXISTS hdb_scans_mainnet;
 DROP TABLE IF EXISTS hdb_benchmarks_mainnet;
@@ -62,9 +69,15 @@ CREATE TABLE hdb_hosts_mainnet (
 	revision       BLOB,
 	settings       BLOB,
 	price_table    BLOB,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	deferred_benchmarks INT NOT NULL DEFAULT 0,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
-	PRIMARY KEY (id)
+	PRIMARY KEY (id),
+	INDEX idx_hdb_hosts_mainnet_fetched (fetched)
 );
 
 CREATE TABLE hdb_scans_mainnet (
@@ -74,12 +87,26 @@ CREATE TABLE hdb_scans_mainnet (
 	success      BOOL NOT NULL,
 	latency      DOUBLE NOT NULL,
 	error        TEXT NOT NULL,
+	failure      TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	ipv4_latency DOUBLE NOT NULL DEFAULT 0,
+	ipv6         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	ipv6_latency DOUBLE NOT NULL DEFAULT 0,
+	dial_time    DOUBLE NOT NULL DEFAULT 0,
+	handshake_time DOUBLE NOT NULL DEFAULT 0,
+	settings_time DOUBLE NOT NULL DEFAULT 0,
 	settings     BLOB,
 	price_table  BLOB,
 	modified     BIGINT NOT NULL,
 	fetched      BIGINT NOT NULL,
 	PRIMARY KEY (id),
-	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key)
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE,
+	INDEX idx_hdb_scans_mainnet (public_key, ran_at),
+	INDEX idx_hdb_scans_mainnet_ran_at (ran_at),
+	INDEX idx_hdb_scans_mainnet_fetched (fetched)
 );
 
 CREATE TABLE hdb_benchmarks_mainnet (
@@ -90,11 +117,17 @@ CREATE TABLE hdb_benchmarks_mainnet (
 	upload_speed   DOUBLE NOT NULL,
 	download_speed DOUBLE NOT NULL,
 	ttfb           DOUBLE NOT NULL,
+	uploaded       BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	downloaded     BIGINT UNSIGNED NOT NULL DEFAULT 0,
 	error          TEXT NOT NULL,
+	failure        TINYINT UNSIGNED NOT NULL DEFAULT 0,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
 	PRIMARY KEY (id),
-	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key)
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE,
+	INDEX idx_hdb_benchmarks_mainnet (public_key, ran_at),
+	INDEX idx_hdb_benchmarks_mainnet_ran_at (ran_at),
+	INDEX idx_hdb_benchmarks_mainnet_fetched (fetched)
 );
 
 CREATE TABLE hdb_hosts_zen (
@@ -117,9 +150,15 @@ CREATE TABLE hdb_hosts_zen (
 	revision       BLOB,
 	settings       BLOB,
 	price_table    BLOB,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	deferred_benchmarks INT NOT NULL DEFAULT 0,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
-	PRIMARY KEY (id)
+	PRIMARY KEY (id),
+	INDEX idx_hdb_hosts_zen_fetched (fetched)
 );
 
 CREATE TABLE hdb_scans_zen (
@@ -129,12 +168,26 @@ CREATE TABLE hdb_scans_zen (
 	success      BOOL NOT NULL,
 	latency      DOUBLE NOT NULL,
 	error        TEXT NOT NULL,
+	failure      TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	ipv4_latency DOUBLE NOT NULL DEFAULT 0,
+	ipv6         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	ipv6_latency DOUBLE NOT NULL DEFAULT 0,
+	dial_time    DOUBLE NOT NULL DEFAULT 0,
+	handshake_time DOUBLE NOT NULL DEFAULT 0,
+	settings_time DOUBLE NOT NULL DEFAULT 0,
 	settings     BLOB,
 	price_table  BLOB,
 	modified     BIGINT NOT NULL,
 	fetched      BIGINT NOT NULL,
 	PRIMARY KEY (id),
-	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key)
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE,
+	INDEX idx_hdb_scans_zen (public_key, ran_at),
+	INDEX idx_hdb_scans_zen_ran_at (ran_at),
+	INDEX idx_hdb_scans_zen_fetched (fetched)
 );
 
 CREATE TABLE hdb_benchmarks_zen (
@@ -145,11 +198,17 @@ CREATE TABLE hdb_benchmarks_zen (
 	upload_speed   DOUBLE NOT NULL,
 	download_speed DOUBLE NOT NULL,
 	ttfb           DOUBLE NOT NULL,
+	uploaded       BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	downloaded     BIGINT UNSIGNED NOT NULL DEFAULT 0,
 	error          TEXT NOT NULL,
+	failure        TINYINT UNSIGNED NOT NULL DEFAULT 0,
 	modified       BIGINT NOT NULL,
 	fetched        BIGINT NOT NULL,
 	PRIMARY KEY (id),
-	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key)
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE,
+	INDEX idx_hdb_benchmarks_zen (public_key, ran_at),
+	INDEX idx_hdb_benchmarks_zen_ran_at (ran_at),
+	INDEX idx_hdb_benchmarks_zen_fetched (fetched)
 );
 
 CREATE TABLE hdb_tip (
@@ -164,6 +223,66 @@ CREATE TABLE hdb_domains (
 	dom VARCHAR(255) NOT NULL
 );
 
+CREATE TABLE hdb_optouts (
+	network    VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	level      VARCHAR(16) NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+CREATE TABLE hdb_allowlist (
+	public_key BINARY(32) NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
+CREATE TABLE hdb_spending (
+	network VARCHAR(8) NOT NULL,
+	day     BIGINT NOT NULL,
+	amount  TINYBLOB NOT NULL,
+	PRIMARY KEY (network, day)
+);
+
+CREATE TABLE hdb_contracts (
+	id           BINARY(32) NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	formed_at    BIGINT NOT NULL,
+	window_start BIGINT UNSIGNED NOT NULL,
+	window_end   BIGINT UNSIGNED NOT NULL,
+	renewed_from BINARY(32),
+	renewed_to   BINARY(32),
+	state        VARCHAR(16) NOT NULL,
+	cost         TINYBLOB NOT NULL,
+	PRIMARY KEY (id),
+	INDEX idx_hdb_contracts (network, public_key)
+);
+
+CREATE TABLE hdb_host_spending (
+	network    VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	contracts  TINYBLOB NOT NULL,
+	funding    TINYBLOB NOT NULL,
+	upload     TINYBLOB NOT NULL,
+	download   TINYBLOB NOT NULL,
+	last_spent BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+CREATE TABLE hdb_updates (
+	network VARCHAR(8) NOT NULL,
+	acked   BIGINT UNSIGNED NOT NULL,
+	pending LONGBLOB NOT NULL,
+	PRIMARY KEY (network)
+);
+
+CREATE TABLE hdb_consumers (
+	network  VARCHAR(8) NOT NULL,
+	consumer VARCHAR(64) NOT NULL,
+	acked    BIGINT UNSIGNED NOT NULL,
+	state    LONGBLOB NOT NULL,
+	PRIMARY KEY (network, consumer)
+);
+
 INSERT INTO hdb_domains (dom)
 VALUES
 	('45.148.30.56'),
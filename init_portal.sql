@@ -1,7 +1,23 @@
+DROP TABLE IF EXISTS update_offsets;
+DROP TABLE IF EXISTS benchmark_rollups;
+DROP TABLE IF EXISTS scan_rollups;
+DROP TABLE IF EXISTS uptime_daily;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS network_history;
+DROP TABLE IF EXISTS opt_outs;
+DROP TABLE IF EXISTS watchlist;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS alerts;
+DROP TABLE IF EXISTS score_history;
+DROP TABLE IF EXISTS host_reports;
+DROP TABLE IF EXISTS community_reports;
+DROP TABLE IF EXISTS diagnostics;
 DROP TABLE IF EXISTS locations;
 DROP TABLE IF EXISTS scans;
 DROP TABLE IF EXISTS benchmarks;
 DROP TABLE IF EXISTS interactions;
+DROP TABLE IF EXISTS settings_history;
 DROP TABLE IF EXISTS price_changes;
 DROP TABLE IF EXISTS hosts;
 
@@ -25,9 +41,12 @@ CREATE TABLE hosts (
     latency_score      DOUBLE NOT NULL,
     benchmarks_score   DOUBLE NOT NULL,
     contracts_score    DOUBLE NOT NULL,
+    duration_score     DOUBLE NOT NULL DEFAULT 0,
     total_score        DOUBLE NOT NULL,
 	settings       BLOB,
 	price_table    BLOB,
+	quarantined    BOOL NOT NULL DEFAULT FALSE,
+	tags           VARCHAR(1024) NOT NULL DEFAULT '',
 	PRIMARY KEY (id, network)
 );
 
@@ -49,14 +68,22 @@ CREATE TABLE interactions (
     latency_score      DOUBLE NOT NULL,
     benchmarks_score   DOUBLE NOT NULL,
     contracts_score    DOUBLE NOT NULL,
+    duration_score     DOUBLE NOT NULL DEFAULT 0,
     total_score        DOUBLE NOT NULL,
 	historic_successful_interactions DOUBLE NOT NULL,
 	historic_failed_interactions     DOUBLE NOT NULL,
 	recent_successful_interactions   DOUBLE NOT NULL,
 	recent_failed_interactions       DOUBLE NOT NULL,
 	last_update                      BIGINT UNSIGNED NOT NULL,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	ingress             BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	egress              BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	traffic_since       BIGINT NOT NULL DEFAULT 0,
 	PRIMARY KEY (network, node, public_key),
-    FOREIGN KEY (public_key) REFERENCES hosts(public_key),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
     INDEX idx_interactions (network, public_key)
 );
 
@@ -69,9 +96,21 @@ CREATE TABLE scans (
 	success      BOOL NOT NULL,
 	latency      DOUBLE NOT NULL,
 	error        TEXT NOT NULL,
+	failure      TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	ipv4_latency DOUBLE NOT NULL DEFAULT 0,
+	ipv6         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+	ipv6_latency DOUBLE NOT NULL DEFAULT 0,
+	dial_time    DOUBLE NOT NULL DEFAULT 0,
+	handshake_time DOUBLE NOT NULL DEFAULT 0,
+	settings_time DOUBLE NOT NULL DEFAULT 0,
 	PRIMARY KEY (id),
-    FOREIGN KEY (public_key) REFERENCES hosts(public_key),
-    INDEX idx_scans (network, node, public_key, ran_at)
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_scans (network, node, public_key, ran_at),
+	INDEX idx_scans_ran_at (ran_at)
 );
 
 CREATE TABLE benchmarks (
@@ -84,9 +123,14 @@ CREATE TABLE benchmarks (
 	upload_speed   DOUBLE NOT NULL,
 	download_speed DOUBLE NOT NULL,
 	ttfb           DOUBLE NOT NULL,
+	uploaded       BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	downloaded     BIGINT UNSIGNED NOT NULL DEFAULT 0,
 	error          TEXT NOT NULL,
+	failure        TINYINT UNSIGNED NOT NULL DEFAULT 0,
 	PRIMARY KEY (id),
-    FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_benchmarks (network, node, public_key, ran_at),
+	INDEX idx_benchmarks_ran_at (ran_at)
 );
 
 CREATE TABLE price_changes (
@@ -101,7 +145,35 @@ CREATE TABLE price_changes (
     upload_price      TINYBLOB NOT NULL,
     download_price    TINYBLOB NOT NULL,
     PRIMARY KEY (id),
-    FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_price_changes (network, public_key, changed_at)
+);
+
+CREATE TABLE settings_history (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BINARY(32) NOT NULL,
+	changed_at  BIGINT NOT NULL,
+	settings    BLOB NOT NULL,
+	price_table BLOB NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_settings_history (network, public_key, changed_at)
+);
+
+CREATE TABLE diagnostics (
+	id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	ran_at     BIGINT NOT NULL,
+	target     VARCHAR(255) NOT NULL,
+	reached    BOOL NOT NULL,
+	hops       TEXT NOT NULL,
+	error      TEXT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_diagnostics (network, public_key, ran_at)
 );
 
 CREATE TABLE locations (
@@ -116,6 +188,203 @@ CREATE TABLE locations (
 	isp        TEXT NOT NULL,
 	zip        TEXT NOT NULL,
 	time_zone  TEXT NOT NULL,
+	asn        VARCHAR(16) NOT NULL DEFAULT '',
 	fetched_at BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE community_reports (
+	network             VARCHAR(8) NOT NULL,
+	public_key          BINARY(32) NOT NULL,
+	contributor         VARCHAR(16) NOT NULL,
+	day                 BIGINT NOT NULL,
+	formation_successes BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	formation_failures  BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	upload_successes    BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	upload_failures     BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	download_successes  BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	download_failures   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	PRIMARY KEY (network, public_key, contributor, day),
+	INDEX idx_day (day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE host_reports (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BINARY(32) NOT NULL,
+	category    VARCHAR(16) NOT NULL,
+	reason      TEXT NOT NULL,
+	reporter    VARCHAR(16) NOT NULL,
+	created_at  BIGINT NOT NULL,
+	status      VARCHAR(16) NOT NULL DEFAULT 'open',
+	reviewed_at BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	INDEX idx_status (status),
+	INDEX idx_reporter (reporter, created_at),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE score_history (
+	id                 BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network            VARCHAR(8) NOT NULL,
+	public_key         BINARY(32) NOT NULL,
+	day                BIGINT NOT NULL,
+	price_score        DOUBLE NOT NULL,
+	storage_score      DOUBLE NOT NULL,
+	collateral_score   DOUBLE NOT NULL,
+	interactions_score DOUBLE NOT NULL,
+	uptime_score       DOUBLE NOT NULL,
+	age_score          DOUBLE NOT NULL,
+	version_score      DOUBLE NOT NULL,
+	latency_score      DOUBLE NOT NULL,
+	benchmarks_score   DOUBLE NOT NULL,
+	contracts_score    DOUBLE NOT NULL,
+	duration_score     DOUBLE NOT NULL DEFAULT 0,
+	total_score        DOUBLE NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE INDEX idx_host_day (network, public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE network_history (
+	id                  BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network             VARCHAR(8) NOT NULL,
+	hour                BIGINT NOT NULL,
+	hosts               INT NOT NULL,
+	online_hosts        INT NOT NULL,
+	accepting_contracts INT NOT NULL,
+	total_storage       BIGINT UNSIGNED NOT NULL,
+	used_storage        BIGINT UNSIGNED NOT NULL,
+	storage_price       TINYBLOB NOT NULL,
+	collateral          TINYBLOB NOT NULL,
+	upload_price        TINYBLOB NOT NULL,
+	download_price      TINYBLOB NOT NULL,
+	upload_speed        DOUBLE NOT NULL,
+	download_speed      DOUBLE NOT NULL,
+	ttfb                BIGINT NOT NULL,
+	collateral_capacity TINYBLOB NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE INDEX idx_network_hour (network, hour)
+);
+
+CREATE TABLE alerts (
+	id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	channel      VARCHAR(16) NOT NULL,
+	target       VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL UNIQUE,
+	offline      BOOL NOT NULL,
+	min_score    DOUBLE NOT NULL,
+	price_change DOUBLE NOT NULL,
+	benchmark    BOOL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE subscriptions (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	email        VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL,
+	confirmed    BOOL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key, email),
+	INDEX idx_subscriptions_email (email),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE api_keys (
+	id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name         VARCHAR(64) NOT NULL,
+	key_hash     BINARY(32) NOT NULL UNIQUE,
+	tier         VARCHAR(32) NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE watchlist (
+	key_id       BIGINT UNSIGNED NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	added_at     BIGINT NOT NULL,
+	last_fetched BIGINT NOT NULL,
+	last_score   DOUBLE NOT NULL,
+	last_online  BOOL NOT NULL,
+	PRIMARY KEY (key_id, network, public_key),
+	FOREIGN KEY (key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+CREATE TABLE opt_outs (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	level        VARCHAR(16) NOT NULL,
+	requested_at BIGINT NOT NULL,
 	PRIMARY KEY (network, public_key)
 );
+
+CREATE TABLE idempotency_keys (
+	id_key       VARCHAR(255) NOT NULL,
+	fingerprint  BINARY(32) NOT NULL,
+	status       INT NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	body         MEDIUMBLOB NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id_key),
+	INDEX idx_idempotency_created_at (created_at)
+);
+
+CREATE TABLE uptime_daily (
+	network          VARCHAR(8) NOT NULL,
+	public_key       BINARY(32) NOT NULL,
+	day              BIGINT NOT NULL,
+	up_slots         INT NOT NULL,
+	down_slots       INT NOT NULL,
+	incidents        INT NOT NULL,
+	longest_outage   INT NOT NULL,
+	leading_outage   INT NOT NULL,
+	trailing_outage  INT NOT NULL,
+	PRIMARY KEY (network, public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE scan_rollups (
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	span       INT NOT NULL,
+	period     BIGINT NOT NULL,
+	scans      INT NOT NULL,
+	successes  INT NOT NULL,
+	latency    DOUBLE NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_scan_rollups (span, period)
+);
+
+CREATE TABLE benchmark_rollups (
+	network        VARCHAR(8) NOT NULL,
+	node           VARCHAR(8) NOT NULL,
+	public_key     BINARY(32) NOT NULL,
+	span           INT NOT NULL,
+	period         BIGINT NOT NULL,
+	benchmarks     INT NOT NULL,
+	successes      INT NOT NULL,
+	upload_speed   DOUBLE NOT NULL,
+	download_speed DOUBLE NOT NULL,
+	ttfb           DOUBLE NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_benchmark_rollups (span, period)
+);
+
+CREATE TABLE update_offsets (
+	node    VARCHAR(8) NOT NULL,
+	standby BOOL NOT NULL,
+	seq     BIGINT UNSIGNED NOT NULL,
+	applied BIGINT NOT NULL,
+	PRIMARY KEY (node, standby)
+);
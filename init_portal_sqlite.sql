@@ -0,0 +1,380 @@
+DROP TABLE IF EXISTS update_offsets;
+DROP TABLE IF EXISTS benchmark_rollups;
+DROP TABLE IF EXISTS scan_rollups;
+DROP TABLE IF EXISTS uptime_daily;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS network_history;
+DROP TABLE IF EXISTS opt_outs;
+DROP TABLE IF EXISTS watchlist;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS alerts;
+DROP TABLE IF EXISTS score_history;
+DROP TABLE IF EXISTS host_reports;
+DROP TABLE IF EXISTS community_reports;
+DROP TABLE IF EXISTS diagnostics;
+DROP TABLE IF EXISTS locations;
+DROP TABLE IF EXISTS scans;
+DROP TABLE IF EXISTS benchmarks;
+DROP TABLE IF EXISTS interactions;
+DROP TABLE IF EXISTS settings_history;
+DROP TABLE IF EXISTS price_changes;
+DROP TABLE IF EXISTS hosts;
+
+CREATE TABLE hosts (
+	id             INT NOT NULL,
+	network        VARCHAR(8) NOT NULL,
+	public_key     BLOB NOT NULL UNIQUE,
+	first_seen     BIGINT NOT NULL,
+	known_since    BIGINT NOT NULL,
+	blocked        BOOL NOT NULL,
+	net_address    VARCHAR(255) NOT NULL,
+	ip_nets        TEXT NOT NULL,
+	last_ip_change BIGINT NOT NULL,
+    price_score        REAL NOT NULL,
+    storage_score      REAL NOT NULL,
+    collateral_score   REAL NOT NULL,
+    interactions_score REAL NOT NULL,
+    uptime_score       REAL NOT NULL,
+    age_score          REAL NOT NULL,
+    version_score      REAL NOT NULL,
+    latency_score      REAL NOT NULL,
+    benchmarks_score   REAL NOT NULL,
+    contracts_score    REAL NOT NULL,
+    duration_score     REAL NOT NULL DEFAULT 0,
+    total_score        REAL NOT NULL,
+	settings       BLOB,
+	price_table    BLOB,
+	quarantined    BOOL NOT NULL DEFAULT FALSE,
+	tags           VARCHAR(1024) NOT NULL DEFAULT '',
+	PRIMARY KEY (id, network)
+);
+
+CREATE TABLE interactions (
+	network      VARCHAR(8) NOT NULL,
+	node         VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	uptime       BIGINT NOT NULL,
+	downtime     BIGINT NOT NULL,
+	last_seen    BIGINT NOT NULL,
+	active_hosts INT NOT NULL,
+    price_score        REAL NOT NULL,
+    storage_score      REAL NOT NULL,
+    collateral_score   REAL NOT NULL,
+    interactions_score REAL NOT NULL,
+    uptime_score       REAL NOT NULL,
+    age_score          REAL NOT NULL,
+    version_score      REAL NOT NULL,
+    latency_score      REAL NOT NULL,
+    benchmarks_score   REAL NOT NULL,
+    contracts_score    REAL NOT NULL,
+    duration_score     REAL NOT NULL DEFAULT 0,
+    total_score        REAL NOT NULL,
+	historic_successful_interactions REAL NOT NULL,
+	historic_failed_interactions     REAL NOT NULL,
+	recent_successful_interactions   REAL NOT NULL,
+	recent_failed_interactions       REAL NOT NULL,
+	last_update                      BIGINT NOT NULL,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	ingress             BIGINT NOT NULL DEFAULT 0,
+	egress              BIGINT NOT NULL DEFAULT 0,
+	traffic_since       BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (network, node, public_key),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_interactions ON interactions (network, public_key);
+
+CREATE TABLE scans (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	network      VARCHAR(8) NOT NULL,
+	node         VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      REAL NOT NULL,
+	error        TEXT NOT NULL,
+	failure      SMALLINT NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency REAL NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency REAL NOT NULL DEFAULT 0,
+	dial_time    REAL NOT NULL DEFAULT 0,
+	handshake_time REAL NOT NULL DEFAULT 0,
+	settings_time REAL NOT NULL DEFAULT 0,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_scans ON scans (network, node, public_key, ran_at);
+CREATE INDEX idx_scans_ran_at ON scans (ran_at);
+
+CREATE TABLE benchmarks (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	network        VARCHAR(8) NOT NULL,
+	node           VARCHAR(8) NOT NULL,
+	public_key     BLOB NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	uploaded       BIGINT NOT NULL DEFAULT 0,
+	downloaded     BIGINT NOT NULL DEFAULT 0,
+	error          TEXT NOT NULL,
+	failure        SMALLINT NOT NULL DEFAULT 0,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_benchmarks ON benchmarks (network, node, public_key, ran_at);
+CREATE INDEX idx_benchmarks_ran_at ON benchmarks (ran_at);
+
+CREATE TABLE price_changes (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	network           VARCHAR(8) NOT NULL,
+	public_key        BLOB NOT NULL,
+	changed_at        BIGINT NOT NULL,
+	remaining_storage BIGINT NOT NULL,
+	total_storage     BIGINT NOT NULL,
+	collateral        BLOB NOT NULL,
+	storage_price     BLOB NOT NULL,
+	upload_price      BLOB NOT NULL,
+	download_price    BLOB NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_price_changes ON price_changes (network, public_key, changed_at);
+
+CREATE TABLE settings_history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BLOB NOT NULL,
+	changed_at  BIGINT NOT NULL,
+	settings    BLOB NOT NULL,
+	price_table BLOB NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_settings_history ON settings_history (network, public_key, changed_at);
+
+CREATE TABLE diagnostics (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	ran_at     BIGINT NOT NULL,
+	target     VARCHAR(255) NOT NULL,
+	reached    BOOL NOT NULL,
+	hops       TEXT NOT NULL,
+	error      TEXT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_diagnostics ON diagnostics (network, public_key, ran_at);
+
+CREATE TABLE locations (
+	network    VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	ip         TEXT NOT NULL,
+	host_name  TEXT NOT NULL,
+	city       TEXT NOT NULL,
+	region     TEXT NOT NULL,
+	country    TEXT NOT NULL,
+	loc        TEXT NOT NULL,
+	isp        TEXT NOT NULL,
+	zip        TEXT NOT NULL,
+	time_zone  TEXT NOT NULL,
+	asn        VARCHAR(16) NOT NULL DEFAULT '',
+	fetched_at BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE community_reports (
+	network             VARCHAR(8) NOT NULL,
+	public_key          BLOB NOT NULL,
+	contributor         VARCHAR(16) NOT NULL,
+	day                 BIGINT NOT NULL,
+	formation_successes BIGINT NOT NULL DEFAULT 0,
+	formation_failures  BIGINT NOT NULL DEFAULT 0,
+	upload_successes    BIGINT NOT NULL DEFAULT 0,
+	upload_failures     BIGINT NOT NULL DEFAULT 0,
+	download_successes  BIGINT NOT NULL DEFAULT 0,
+	download_failures   BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (network, public_key, contributor, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_day ON community_reports (day);
+
+CREATE TABLE host_reports (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BLOB NOT NULL,
+	category    VARCHAR(16) NOT NULL,
+	reason      TEXT NOT NULL,
+	reporter    VARCHAR(16) NOT NULL,
+	created_at  BIGINT NOT NULL,
+	status      VARCHAR(16) NOT NULL DEFAULT 'open',
+	reviewed_at BIGINT NOT NULL DEFAULT 0,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_status ON host_reports (status);
+CREATE INDEX idx_reporter ON host_reports (reporter, created_at);
+
+CREATE TABLE score_history (
+	id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+	network            VARCHAR(8) NOT NULL,
+	public_key         BLOB NOT NULL,
+	day                BIGINT NOT NULL,
+	price_score        REAL NOT NULL,
+	storage_score      REAL NOT NULL,
+	collateral_score   REAL NOT NULL,
+	interactions_score REAL NOT NULL,
+	uptime_score       REAL NOT NULL,
+	age_score          REAL NOT NULL,
+	version_score      REAL NOT NULL,
+	latency_score      REAL NOT NULL,
+	benchmarks_score   REAL NOT NULL,
+	contracts_score    REAL NOT NULL,
+	duration_score     REAL NOT NULL DEFAULT 0,
+	total_score        REAL NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX idx_host_day ON score_history (network, public_key, day);
+
+CREATE TABLE network_history (
+	id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+	network             VARCHAR(8) NOT NULL,
+	hour                BIGINT NOT NULL,
+	hosts               INT NOT NULL,
+	online_hosts        INT NOT NULL,
+	accepting_contracts INT NOT NULL,
+	total_storage       BIGINT NOT NULL,
+	used_storage        BIGINT NOT NULL,
+	storage_price       BLOB NOT NULL,
+	collateral          BLOB NOT NULL,
+	upload_price        BLOB NOT NULL,
+	download_price      BLOB NOT NULL,
+	upload_speed        REAL NOT NULL,
+	download_speed      REAL NOT NULL,
+	ttfb                BIGINT NOT NULL,
+	collateral_capacity BLOB NOT NULL
+);
+CREATE UNIQUE INDEX idx_network_hour ON network_history (network, hour);
+
+CREATE TABLE alerts (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	channel      VARCHAR(16) NOT NULL,
+	target       VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL UNIQUE,
+	offline      BOOL NOT NULL,
+	min_score    REAL NOT NULL,
+	price_change REAL NOT NULL,
+	benchmark    BOOL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE subscriptions (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	email        VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL,
+	confirmed    BOOL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key, email),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_subscriptions_email ON subscriptions (email);
+
+CREATE TABLE api_keys (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	name         VARCHAR(64) NOT NULL,
+	key_hash     BLOB NOT NULL UNIQUE,
+	tier         VARCHAR(32) NOT NULL,
+	created_at   BIGINT NOT NULL
+);
+
+CREATE TABLE watchlist (
+	key_id       INTEGER NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	added_at     BIGINT NOT NULL,
+	last_fetched BIGINT NOT NULL,
+	last_score   REAL NOT NULL,
+	last_online  BOOL NOT NULL,
+	PRIMARY KEY (key_id, network, public_key),
+	FOREIGN KEY (key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+CREATE TABLE opt_outs (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	level        VARCHAR(16) NOT NULL,
+	requested_at BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+CREATE TABLE idempotency_keys (
+	id_key       VARCHAR(255) PRIMARY KEY,
+	fingerprint  BLOB NOT NULL,
+	status       INT NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	body         BLOB NOT NULL,
+	created_at   BIGINT NOT NULL
+);
+CREATE INDEX idx_idempotency_created_at ON idempotency_keys (created_at);
+
+CREATE TABLE uptime_daily (
+	network          VARCHAR(8) NOT NULL,
+	public_key       BLOB NOT NULL,
+	day              BIGINT NOT NULL,
+	up_slots         INT NOT NULL,
+	down_slots       INT NOT NULL,
+	incidents        INT NOT NULL,
+	longest_outage   INT NOT NULL,
+	leading_outage   INT NOT NULL,
+	trailing_outage  INT NOT NULL,
+	PRIMARY KEY (network, public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+CREATE TABLE scan_rollups (
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	span       INT NOT NULL,
+	period     BIGINT NOT NULL,
+	scans      INT NOT NULL,
+	successes  INT NOT NULL,
+	latency    REAL NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_scan_rollups ON scan_rollups (span, period);
+
+CREATE TABLE benchmark_rollups (
+	network        VARCHAR(8) NOT NULL,
+	node           VARCHAR(8) NOT NULL,
+	public_key     BLOB NOT NULL,
+	span           INT NOT NULL,
+	period         BIGINT NOT NULL,
+	benchmarks     INT NOT NULL,
+	successes      INT NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_benchmark_rollups ON benchmark_rollups (span, period);
+
+CREATE TABLE update_offsets (
+	node    VARCHAR(8) NOT NULL,
+	standby BOOL NOT NULL,
+	seq     BIGINT NOT NULL,
+	applied BIGINT NOT NULL,
+	PRIMARY KEY (node, standby)
+);
@@ -0,0 +1,291 @@
+/* wallet */
+DROP TABLE IF EXISTS wt_tip CASCADE;
+DROP TABLE IF EXISTS wt_sces CASCADE;
+DROP TABLE IF EXISTS wt_sfes CASCADE;
+DROP TABLE IF EXISTS wt_locked CASCADE;
+
+CREATE TABLE wt_tip (
+	id      INT NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	height  BIGINT NOT NULL,
+	bid     BYTEA NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE wt_sces (
+	scoid   BYTEA NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	bytes   BYTEA NOT NULL,
+	PRIMARY KEY (scoid)
+);
+
+CREATE TABLE wt_sfes (
+	sfoid   BYTEA NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	bytes   BYTEA NOT NULL,
+	PRIMARY KEY (sfoid)
+);
+
+CREATE TABLE wt_locked (
+	id    BYTEA NOT NULL,
+	until BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+
+/* hostdb */
+DROP TABLE IF EXISTS hdb_domains CASCADE;
+DROP TABLE IF EXISTS hdb_optouts CASCADE;
+DROP TABLE IF EXISTS hdb_allowlist CASCADE;
+DROP TABLE IF EXISTS hdb_spending CASCADE;
+DROP TABLE IF EXISTS hdb_contracts CASCADE;
+DROP TABLE IF EXISTS hdb_host_spending CASCADE;
+DROP TABLE IF EXISTS hdb_consumers CASCADE;
+DROP TABLE IF EXISTS hdb_updates CASCADE;
+DROP TABLE IF EXISTS hdb_tip CASCADE;
+DROP TABLE IF EXISTS hdb_scans_mainnet CASCADE;
+DROP TABLE IF EXISTS hdb_benchmarks_mainnet CASCADE;
+DROP TABLE IF EXISTS hdb_hosts_mainnet CASCADE;
+DROP TABLE IF EXISTS hdb_scans_zen CASCADE;
+DROP TABLE IF EXISTS hdb_benchmarks_zen CASCADE;
+DROP TABLE IF EXISTS hdb_hosts_zen CASCADE;
+
+CREATE TABLE hdb_hosts_mainnet (
+	id             SERIAL NOT NULL,
+	public_key     BYTEA NOT NULL UNIQUE,
+	first_seen     BIGINT NOT NULL,
+	known_since    BIGINT NOT NULL,
+	blocked        BOOL NOT NULL,
+	net_address    VARCHAR(255) NOT NULL,
+	uptime         BIGINT NOT NULL,
+	downtime       BIGINT NOT NULL,
+	last_seen      BIGINT NOT NULL,
+	ip_nets        TEXT NOT NULL,
+	last_ip_change BIGINT NOT NULL,
+	historic_successful_interactions DOUBLE PRECISION NOT NULL,
+	historic_failed_interactions     DOUBLE PRECISION NOT NULL,
+	recent_successful_interactions   DOUBLE PRECISION NOT NULL,
+	recent_failed_interactions       DOUBLE PRECISION NOT NULL,
+	last_update                      BIGINT NOT NULL,
+	revision       BYTEA,
+	settings       BYTEA,
+	price_table    BYTEA,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	deferred_benchmarks INT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX idx_hdb_hosts_mainnet_fetched ON hdb_hosts_mainnet (fetched);
+
+CREATE TABLE hdb_scans_mainnet (
+	id           BIGSERIAL NOT NULL,
+	public_key   BYTEA NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      DOUBLE PRECISION NOT NULL,
+	error        TEXT NOT NULL,
+	failure      SMALLINT NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+	dial_time    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	handshake_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+	settings_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+	settings     BYTEA,
+	price_table  BYTEA,
+	modified     BIGINT NOT NULL,
+	fetched      BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_scans_mainnet ON hdb_scans_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_ran_at ON hdb_scans_mainnet (ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_fetched ON hdb_scans_mainnet (fetched);
+
+CREATE TABLE hdb_benchmarks_mainnet (
+	id             BIGSERIAL NOT NULL,
+	public_key     BYTEA NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   DOUBLE PRECISION NOT NULL,
+	download_speed DOUBLE PRECISION NOT NULL,
+	ttfb           DOUBLE PRECISION NOT NULL,
+	uploaded       BIGINT NOT NULL DEFAULT 0,
+	downloaded     BIGINT NOT NULL DEFAULT 0,
+	error          TEXT NOT NULL,
+	failure        SMALLINT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_benchmarks_mainnet ON hdb_benchmarks_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_ran_at ON hdb_benchmarks_mainnet (ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_fetched ON hdb_benchmarks_mainnet (fetched);
+
+CREATE TABLE hdb_hosts_zen (
+	id             SERIAL NOT NULL,
+	public_key     BYTEA NOT NULL UNIQUE,
+	first_seen     BIGINT NOT NULL,
+	known_since    BIGINT NOT NULL,
+	blocked        BOOL NOT NULL,
+	net_address    VARCHAR(255) NOT NULL,
+	uptime         BIGINT NOT NULL,
+	downtime       BIGINT NOT NULL,
+	last_seen      BIGINT NOT NULL,
+	ip_nets        TEXT NOT NULL,
+	last_ip_change BIGINT NOT NULL,
+	historic_successful_interactions DOUBLE PRECISION NOT NULL,
+	historic_failed_interactions     DOUBLE PRECISION NOT NULL,
+	recent_successful_interactions   DOUBLE PRECISION NOT NULL,
+	recent_failed_interactions       DOUBLE PRECISION NOT NULL,
+	last_update                      BIGINT NOT NULL,
+	revision       BYTEA,
+	settings       BYTEA,
+	price_table    BYTEA,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	deferred_benchmarks INT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX idx_hdb_hosts_zen_fetched ON hdb_hosts_zen (fetched);
+
+CREATE TABLE hdb_scans_zen (
+	id           BIGSERIAL NOT NULL,
+	public_key   BYTEA NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      DOUBLE PRECISION NOT NULL,
+	error        TEXT NOT NULL,
+	failure      SMALLINT NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+	dial_time    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	handshake_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+	settings_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+	settings     BYTEA,
+	price_table  BYTEA,
+	modified     BIGINT NOT NULL,
+	fetched      BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_scans_zen ON hdb_scans_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_zen_ran_at ON hdb_scans_zen (ran_at);
+CREATE INDEX idx_hdb_scans_zen_fetched ON hdb_scans_zen (fetched);
+
+CREATE TABLE hdb_benchmarks_zen (
+	id             BIGSERIAL NOT NULL,
+	public_key     BYTEA NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   DOUBLE PRECISION NOT NULL,
+	download_speed DOUBLE PRECISION NOT NULL,
+	ttfb           DOUBLE PRECISION NOT NULL,
+	uploaded       BIGINT NOT NULL DEFAULT 0,
+	downloaded     BIGINT NOT NULL DEFAULT 0,
+	error          TEXT NOT NULL,
+	failure        SMALLINT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_benchmarks_zen ON hdb_benchmarks_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_ran_at ON hdb_benchmarks_zen (ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_fetched ON hdb_benchmarks_zen (fetched);
+
+CREATE TABLE hdb_tip (
+	id               INT NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	height           BIGINT NOT NULL,
+	bid              BYTEA NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE hdb_domains (
+	dom VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE hdb_optouts (
+	network    VARCHAR(8) NOT NULL,
+	public_key BYTEA NOT NULL,
+	level      VARCHAR(16) NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+CREATE TABLE hdb_allowlist (
+	public_key BYTEA NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
+CREATE TABLE hdb_spending (
+	network VARCHAR(8) NOT NULL,
+	day     BIGINT NOT NULL,
+	amount  BYTEA NOT NULL,
+	PRIMARY KEY (network, day)
+);
+
+CREATE TABLE hdb_contracts (
+	id           BYTEA NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BYTEA NOT NULL,
+	formed_at    BIGINT NOT NULL,
+	window_start BIGINT NOT NULL,
+	window_end   BIGINT NOT NULL,
+	renewed_from BYTEA,
+	renewed_to   BYTEA,
+	state        VARCHAR(16) NOT NULL,
+	cost         BYTEA NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX idx_hdb_contracts ON hdb_contracts (network, public_key);
+
+CREATE TABLE hdb_host_spending (
+	network    VARCHAR(8) NOT NULL,
+	public_key BYTEA NOT NULL,
+	contracts  BYTEA NOT NULL,
+	funding    BYTEA NOT NULL,
+	upload     BYTEA NOT NULL,
+	download   BYTEA NOT NULL,
+	last_spent BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+CREATE TABLE hdb_updates (
+	network VARCHAR(8) NOT NULL,
+	acked   BIGINT NOT NULL,
+	pending BYTEA NOT NULL,
+	PRIMARY KEY (network)
+);
+
+CREATE TABLE hdb_consumers (
+	network  VARCHAR(8) NOT NULL,
+	consumer VARCHAR(64) NOT NULL,
+	acked    BIGINT NOT NULL,
+	state    BYTEA NOT NULL,
+	PRIMARY KEY (network, consumer)
+);
+
+INSERT INTO hdb_domains (dom)
+VALUES
+	('45.148.30.56'),
+	('51.158.108.244'),
+	('siacentral.ddnsfree.com'),
+	('siacentral.mooo.com');
@@ -0,0 +1,285 @@
+/* wallet */
+DROP TABLE IF EXISTS wt_tip;
+DROP TABLE IF EXISTS wt_sces;
+DROP TABLE IF EXISTS wt_sfes;
+DROP TABLE IF EXISTS wt_locked;
+
+CREATE TABLE wt_tip (
+	id      INT NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	height  BIGINT NOT NULL,
+	bid     BLOB NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE wt_sces (
+	scoid   BLOB NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	bytes   BLOB NOT NULL,
+	PRIMARY KEY (scoid)
+);
+
+CREATE TABLE wt_sfes (
+	sfoid   BLOB NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	bytes   BLOB NOT NULL,
+	PRIMARY KEY (sfoid)
+);
+
+CREATE TABLE wt_locked (
+	id    BLOB NOT NULL,
+	until BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+
+/* hostdb */
+DROP TABLE IF EXISTS hdb_domains;
+DROP TABLE IF EXISTS hdb_optouts;
+DROP TABLE IF EXISTS hdb_allowlist;
+DROP TABLE IF EXISTS hdb_spending;
+DROP TABLE IF EXISTS hdb_contracts;
+DROP TABLE IF EXISTS hdb_host_spending;
+DROP TABLE IF EXISTS hdb_consumers;
+DROP TABLE IF EXISTS hdb_updates;
+DROP TABLE IF EXISTS hdb_tip;
+DROP TABLE IF EXISTS hdb_scans_mainnet;
+DROP TABLE IF EXISTS hdb_benchmarks_mainnet;
+DROP TABLE IF EXISTS hdb_hosts_mainnet;
+DROP TABLE IF EXISTS hdb_scans_zen;
+DROP TABLE IF EXISTS hdb_benchmarks_zen;
+DROP TABLE IF EXISTS hdb_hosts_zen;
+
+CREATE TABLE hdb_hosts_mainnet (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key     BLOB NOT NULL UNIQUE,
+	first_seen     BIGINT NOT NULL,
+	known_since    BIGINT NOT NULL,
+	blocked        BOOL NOT NULL,
+	net_address    VARCHAR(255) NOT NULL,
+	uptime         BIGINT NOT NULL,
+	downtime       BIGINT NOT NULL,
+	last_seen      BIGINT NOT NULL,
+	ip_nets        TEXT NOT NULL,
+	last_ip_change BIGINT NOT NULL,
+	historic_successful_interactions REAL NOT NULL,
+	historic_failed_interactions     REAL NOT NULL,
+	recent_successful_interactions   REAL NOT NULL,
+	recent_failed_interactions       REAL NOT NULL,
+	last_update                      BIGINT NOT NULL,
+	revision       BLOB,
+	settings       BLOB,
+	price_table    BLOB,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	deferred_benchmarks INT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL
+);
+CREATE INDEX idx_hdb_hosts_mainnet_fetched ON hdb_hosts_mainnet (fetched);
+
+CREATE TABLE hdb_scans_mainnet (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key   BLOB NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      REAL NOT NULL,
+	error        TEXT NOT NULL,
+	failure      SMALLINT NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency REAL NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency REAL NOT NULL DEFAULT 0,
+	dial_time    REAL NOT NULL DEFAULT 0,
+	handshake_time REAL NOT NULL DEFAULT 0,
+	settings_time REAL NOT NULL DEFAULT 0,
+	settings     BLOB,
+	price_table  BLOB,
+	modified     BIGINT NOT NULL,
+	fetched      BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_scans_mainnet ON hdb_scans_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_ran_at ON hdb_scans_mainnet (ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_fetched ON hdb_scans_mainnet (fetched);
+
+CREATE TABLE hdb_benchmarks_mainnet (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key     BLOB NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	uploaded       BIGINT NOT NULL DEFAULT 0,
+	downloaded     BIGINT NOT NULL DEFAULT 0,
+	error          TEXT NOT NULL,
+	failure        SMALLINT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_benchmarks_mainnet ON hdb_benchmarks_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_ran_at ON hdb_benchmarks_mainnet (ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_fetched ON hdb_benchmarks_mainnet (fetched);
+
+CREATE TABLE hdb_hosts_zen (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key     BLOB NOT NULL UNIQUE,
+	first_seen     BIGINT NOT NULL,
+	known_since    BIGINT NOT NULL,
+	blocked        BOOL NOT NULL,
+	net_address    VARCHAR(255) NOT NULL,
+	uptime         BIGINT NOT NULL,
+	downtime       BIGINT NOT NULL,
+	last_seen      BIGINT NOT NULL,
+	ip_nets        TEXT NOT NULL,
+	last_ip_change BIGINT NOT NULL,
+	historic_successful_interactions REAL NOT NULL,
+	historic_failed_interactions     REAL NOT NULL,
+	recent_successful_interactions   REAL NOT NULL,
+	recent_failed_interactions       REAL NOT NULL,
+	last_update                      BIGINT NOT NULL,
+	revision       BLOB,
+	settings       BLOB,
+	price_table    BLOB,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	deferred_benchmarks INT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL
+);
+CREATE INDEX idx_hdb_hosts_zen_fetched ON hdb_hosts_zen (fetched);
+
+CREATE TABLE hdb_scans_zen (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key   BLOB NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      REAL NOT NULL,
+	error        TEXT NOT NULL,
+	failure      SMALLINT NOT NULL DEFAULT 0,
+	clock_skew   BIGINT NOT NULL DEFAULT 0,
+	valid_until  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency REAL NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency REAL NOT NULL DEFAULT 0,
+	dial_time    REAL NOT NULL DEFAULT 0,
+	handshake_time REAL NOT NULL DEFAULT 0,
+	settings_time REAL NOT NULL DEFAULT 0,
+	settings     BLOB,
+	price_table  BLOB,
+	modified     BIGINT NOT NULL,
+	fetched      BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_scans_zen ON hdb_scans_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_zen_ran_at ON hdb_scans_zen (ran_at);
+CREATE INDEX idx_hdb_scans_zen_fetched ON hdb_scans_zen (fetched);
+
+CREATE TABLE hdb_benchmarks_zen (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key     BLOB NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	uploaded       BIGINT NOT NULL DEFAULT 0,
+	downloaded     BIGINT NOT NULL DEFAULT 0,
+	error          TEXT NOT NULL,
+	failure        SMALLINT NOT NULL DEFAULT 0,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_hdb_benchmarks_zen ON hdb_benchmarks_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_ran_at ON hdb_benchmarks_zen (ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_fetched ON hdb_benchmarks_zen (fetched);
+
+CREATE TABLE hdb_tip (
+	id               INT NOT NULL,
+	network VARCHAR(8) NOT NULL,
+	height           BIGINT NOT NULL,
+	bid              BLOB NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE hdb_domains (
+	dom VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE hdb_optouts (
+	network    VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	level      VARCHAR(16) NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+CREATE TABLE hdb_allowlist (
+	public_key BLOB NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
+CREATE TABLE hdb_spending (
+	network VARCHAR(8) NOT NULL,
+	day     BIGINT NOT NULL,
+	amount  BLOB NOT NULL,
+	PRIMARY KEY (network, day)
+);
+
+CREATE TABLE hdb_contracts (
+	id           BLOB NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	formed_at    BIGINT NOT NULL,
+	window_start BIGINT NOT NULL,
+	window_end   BIGINT NOT NULL,
+	renewed_from BLOB,
+	renewed_to   BLOB,
+	state        VARCHAR(16) NOT NULL,
+	cost         BLOB NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX idx_hdb_contracts ON hdb_contracts (network, public_key);
+
+CREATE TABLE hdb_host_spending (
+	network    VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	contracts  BLOB NOT NULL,
+	funding    BLOB NOT NULL,
+	upload     BLOB NOT NULL,
+	download   BLOB NOT NULL,
+	last_spent BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+CREATE TABLE hdb_updates (
+	network VARCHAR(8) NOT NULL,
+	acked   BIGINT NOT NULL,
+	pending BLOB NOT NULL,
+	PRIMARY KEY (network)
+);
+
+CREATE TABLE hdb_consumers (
+	network  VARCHAR(8) NOT NULL,
+	consumer VARCHAR(64) NOT NULL,
+	acked    BIGINT NOT NULL,
+	state    BLOB NOT NULL,
+	PRIMARY KEY (network, consumer)
+);
+
+INSERT INTO hdb_domains (dom)
+VALUES
+	('45.148.30.56'),
+	('51.158.108.244'),
+	('siacentral.ddnsfree.com'),
+	('siacentral.mooo.com');
@@ -0,0 +1,166 @@
+// Package metrics exposes the runtime metrics in the Prometheus text
+// format. The values are collected when the metrics are scraped, so the
+// instrumented code doesn't need to be aware of the metrics.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type is the type of a metric.
+type Type string
+
+const (
+	// Counter is a value that only increases.
+	Counter Type = "counter"
+
+	// Gauge is a value that can go up and down.
+	Gauge Type = "gauge"
+)
+
+// Sample is a single value of a metric.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// metric is a registered metric.
+type metric struct {
+	name    string
+	help    string
+	typ     Type
+	collect func() []Sample
+}
+
+// Registry holds the registered metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a metric with the values returned by collect.
+func (r *Registry) Register(name, help string, typ Type, collect func() []Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		collect: collect,
+	})
+}
+
+// GaugeFunc adds an unlabeled gauge.
+func (r *Registry) GaugeFunc(name, help string, value func() float64) {
+	r.Register(name, help, Gauge, func() []Sample {
+		return []Sample{{Value: value()}}
+	})
+}
+
+// CounterFunc adds an unlabeled counter.
+func (r *Registry) CounterFunc(name, help string, value func() float64) {
+	r.Register(name, help, Counter, func() []Sample {
+		return []Sample{{Value: value()}}
+	})
+}
+
+// PerNetwork returns one sample per network.
+func PerNetwork(mainnet, zen float64) []Sample {
+	return []Sample{
+		{Labels: map[string]string{"network": "mainnet"}, Value: mainnet},
+		{Labels: map[string]string{"network": "zen"}, Value: zen},
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, escape(m.help, false))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", m.name, m.typ)
+		for _, s := range m.collect() {
+			buf.WriteString(m.name)
+			writeLabels(&buf, s.Labels)
+			buf.WriteByte(' ')
+			buf.WriteString(formatValue(s.Value))
+			buf.WriteByte('\n')
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// writeLabels writes the labels sorted by name.
+func writeLabels(buf *bytes.Buffer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, "%s=\"%s\"", name, escape(labels[name], true))
+	}
+	buf.WriteByte('}')
+}
+
+// escape escapes the backslashes and the newlines, as well as the double
+// quotes in the label values.
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// PingLatency returns the time it takes to ping the database in seconds,
+// or NaN if the database is not responding.
+func PingLatency(db *sql.DB) float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		return math.NaN()
+	}
+	return time.Since(start).Seconds()
+}
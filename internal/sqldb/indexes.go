@@ -0,0 +1,65 @@
+package sqldb
+
+import (
+	"slices"
+	"strings"
+)
+
+// Index describes an index that the queries rely on.
+type Index struct {
+	Table   string
+	Columns []string
+}
+
+// String implements fmt.Stringer.
+func (idx Index) String() string {
+	return idx.Table + " (" + strings.Join(idx.Columns, ", ") + ")"
+}
+
+// indexes returns the columns of each index of the table.
+func (db *DB) indexes(table string) (map[string][]string, error) {
+	rows, err := db.Query(db.dialect.IndexQuery(), table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string][]string)
+	for rows.Next() {
+		var name, column string
+		if err := rows.Scan(&name, &column); err != nil {
+			return nil, err
+		}
+		indexes[name] = append(indexes[name], strings.ToLower(column))
+	}
+	return indexes, rows.Err()
+}
+
+// MissingIndexes returns the expected indexes that the database lacks.
+// The indexes of the versioned tables are checked if they are in use.
+// An expected index is present if an index of the table starts with
+// the same columns, because such an index serves the same queries.
+func (db *DB) MissingIndexes(expected []Index) (missing []Index, err error) {
+	tables := make(map[string]map[string][]string)
+	for _, idx := range expected {
+		indexes, ok := tables[idx.Table]
+		if !ok {
+			indexes, err = db.indexes(db.Table(idx.Table))
+			if err != nil {
+				return nil, err
+			}
+			tables[idx.Table] = indexes
+		}
+		var found bool
+		for _, columns := range indexes {
+			if len(columns) >= len(idx.Columns) && slices.Equal(columns[:len(idx.Columns)], idx.Columns) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, idx)
+		}
+	}
+	return
+}
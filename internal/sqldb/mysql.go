@@ -0,0 +1,59 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlDialect is the native dialect of the queries.
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return MySQL }
+
+func (mysqlDialect) DefaultAddr() string { return "127.0.0.1:3306" }
+
+func (mysqlDialect) Open(cfg Config) (*sql.DB, error) {
+	mc := mysql.Config{
+		User:                 cfg.User,
+		Passwd:               cfg.Password,
+		Net:                  "tcp",
+		Addr:                 cfg.Addr,
+		DBName:               cfg.Name,
+		AllowNativePasswords: true,
+	}
+	return sql.Open("mysql", mc.FormatDSN())
+}
+
+// Rebind removes the keys named by the upserts, since MySQL applies the
+// update to whichever unique key is violated.
+func (mysqlDialect) Rebind(query string) string {
+	return upsert.ReplaceAllString(query, ") AS new ON DUPLICATE KEY UPDATE")
+}
+
+// Retryable recognizes the deadlocks (1213) and the lock wait timeouts
+// (1205). The errors with an added context have lost their type, so the
+// message is checked as well.
+func (mysqlDialect) Retryable(err error) (retryable, deadlock bool) {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == 1213 || me.Number == 1205, me.Number == 1213
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "Error 1213") {
+		return true, true
+	}
+	return strings.Contains(msg, "Error 1205"), false
+}
+
+func (mysqlDialect) IndexQuery() string {
+	return `
+		SELECT index_name, column_name
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE()
+		AND table_name = ?
+		ORDER BY index_name, seq_in_index
+	`
+}
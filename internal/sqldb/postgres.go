@@ -0,0 +1,101 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// upsert matches the upserts, which name the key they update on
+	// between ON DUPLICATE KEY and UPDATE, e.g.
+	// ON DUPLICATE KEY (network, public_key) UPDATE.
+	upsert = regexp.MustCompile(`(?i)\)\s*AS\s+new\s+ON\s+DUPLICATE\s+KEY\s*(?:\(([^)]*)\))?\s*UPDATE`)
+	newRow = regexp.MustCompile(`\bnew\.`)
+)
+
+// postgresDialect translates the queries for PostgreSQL.
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return Postgres }
+
+func (postgresDialect) DefaultAddr() string { return "127.0.0.1:5432" }
+
+func (postgresDialect) Open(cfg Config) (*sql.DB, error) {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     cfg.Addr,
+		Path:     "/" + cfg.Name,
+		RawQuery: "sslmode=disable",
+	}
+	return sql.Open("postgres", u.String())
+}
+
+// Rebind converts the upserts into ON CONFLICT clauses and the
+// placeholders into the numbered form. PostgreSQL only updates the row on
+// a conflict over the key named by the upsert, so an upsert without one
+// is left as is and fails.
+func (postgresDialect) Rebind(query string) string {
+	if m := upsert.FindStringSubmatch(query); m != nil && m[1] != "" {
+		query = upsert.ReplaceAllString(query, ") ON CONFLICT ($1) DO UPDATE SET")
+		query = newRow.ReplaceAllString(query, "EXCLUDED.")
+	}
+
+	var sb strings.Builder
+	var n int
+	var quoted bool
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func (postgresDialect) IndexQuery() string {
+	return `
+		SELECT i.relname, a.attname
+		FROM pg_index x
+		JOIN pg_class t ON t.oid = x.indrelid
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN LATERAL unnest(x.indkey) WITH ORDINALITY AS k(attnum, pos) ON TRUE
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		WHERE t.relname = ?
+		AND pg_table_is_visible(t.oid)
+		ORDER BY i.relname, k.pos
+	`
+}
+
+// Retryable recognizes the deadlocks, the serialization failures, and
+// the lock timeouts. The errors with an added context have lost their
+// type, so the message is checked as well.
+func (postgresDialect) Retryable(err error) (retryable, deadlock bool) {
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		switch pe.Code {
+		case "40P01": // deadlock_detected
+			return true, true
+		case "40001", "55P03": // serialization_failure, lock_not_available
+			return true, false
+		default:
+			return false, false
+		}
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "pq: deadlock detected") {
+		return true, true
+	}
+	return strings.Contains(msg, "pq: could not serialize access") || strings.Contains(msg, "pq: could not obtain lock"), false
+}
@@ -0,0 +1,60 @@
+package sqldb
+
+import (
+	"time"
+
+	"lukechampine.com/frand"
+)
+
+const (
+	// maxRetries is how many times an operation failing with a transient
+	// error is retried.
+	maxRetries = 5
+
+	// retryBaseDelay is the delay before the first retry. It doubles with
+	// every following retry.
+	retryBaseDelay = 50 * time.Millisecond
+)
+
+// Retryable returns true if the error is transient, so that the operation
+// can be retried.
+func (db *DB) Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	retryable, _ := db.dialect.Retryable(err)
+	return retryable
+}
+
+// Retry runs fn and repeats it with a growing delay as long as it fails
+// with a transient error, up to maxRetries times. fn must be safe to
+// repeat: a deadlock rolls back the whole transaction, so fn has to
+// begin the transaction anew.
+func (db *DB) Retry(fn func() error) (err error) {
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		retryable, deadlock := db.dialect.Retryable(err)
+		if deadlock {
+			db.deadlocks.Add(1)
+		}
+		if !retryable || attempt >= maxRetries {
+			return err
+		}
+		db.retries.Add(1)
+		delay := retryBaseDelay << attempt
+		time.Sleep(delay + time.Duration(frand.Intn(int(delay))))
+	}
+}
+
+// Deadlocks returns the number of deadlocks encountered so far.
+func (db *DB) Deadlocks() uint64 {
+	return db.deadlocks.Load()
+}
+
+// Retries returns the number of operations retried so far.
+func (db *DB) Retries() uint64 {
+	return db.retries.Load()
+}
@@ -0,0 +1,253 @@
+// Package sqldb provides a database handle that runs the queries written
+// in the MySQL dialect on any of the supported database backends.
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Supported database types.
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Config contains the parameters required to connect to the database.
+type Config struct {
+	Type     string
+	Addr     string
+	User     string
+	Password string
+	Name     string
+
+	// Version, if set, makes the queries use the versioned copies of
+	// Tables, named <table>_<version>, so that an instance can run next
+	// to another one in the same database without touching its data.
+	Version string
+	Tables  []string
+}
+
+// Dialect hides the differences between the database backends.
+type Dialect interface {
+	// Name returns the database type.
+	Name() string
+
+	// DefaultAddr returns the address the database server listens at
+	// by default.
+	DefaultAddr() string
+
+	// Open connects to the database.
+	Open(cfg Config) (*sql.DB, error)
+
+	// Rebind translates a query from the MySQL dialect, in which all
+	// queries are written, into the dialect of the backend. The upserts
+	// name the key they update on, which MySQL doesn't need but the other
+	// backends do: ON DUPLICATE KEY (<columns>) UPDATE.
+	Rebind(query string) string
+
+	// IndexQuery returns a query that lists the names and the columns
+	// of the indexes of the table given as the only argument, ordered
+	// by the index name and the position of the column.
+	IndexQuery() string
+
+	// Retryable returns true if the error is transient, like a deadlock
+	// or a lock wait timeout, so that the operation can be retried. The
+	// second value is true if the error is a deadlock.
+	Retryable(err error) (retryable, deadlock bool)
+}
+
+// ErrUnknownType is returned when an unsupported database type is
+// requested.
+var ErrUnknownType = errors.New("unknown database type")
+
+// dialects lists the supported backends.
+var dialects = map[string]Dialect{
+	MySQL:    mysqlDialect{},
+	Postgres: postgresDialect{},
+	SQLite:   sqliteDialect{},
+}
+
+// DB wraps a database handle and translates the queries into the
+// dialect of the backend.
+type DB struct {
+	*sql.DB
+	dialect Dialect
+	queries sync.Map
+	lazy    bool
+	version string
+	tables  versionedTables
+
+	deadlocks atomic.Uint64
+	retries   atomic.Uint64
+}
+
+// Tx wraps a transaction and translates the queries into the dialect
+// of the backend. If the transactions are lazy, the underlying
+// transaction only begins with the first statement that may write to
+// the database, and the reads preceding it are run outside of it.
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+// Open connects to the database of the given type. MySQL is used if the
+// type is empty.
+func Open(cfg Config) (*DB, error) {
+	if cfg.Type == "" {
+		cfg.Type = MySQL
+	}
+	d, ok := dialects[cfg.Type]
+	if !ok {
+		return nil, ErrUnknownType
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = d.DefaultAddr()
+	}
+	db, err := d.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db.SetConnMaxLifetime(time.Minute * 3)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(10)
+
+	// SQLite allows only one writer at a time, and the stores keep their
+	// transactions open between the updates. Beginning the transactions
+	// lazily keeps an idle store from holding the write lock.
+	return &DB{
+		DB:      db,
+		dialect: d,
+		lazy:    cfg.Type == SQLite,
+		version: cfg.Version,
+		tables:  newVersionedTables(cfg.Tables, cfg.Version),
+	}, nil
+}
+
+// Dialect returns the dialect of the backend.
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+// rebind translates the query, renaming the versioned tables, and caches
+// the result, since the same queries are run over and over again.
+func (db *DB) rebind(query string) string {
+	if q, ok := db.queries.Load(query); ok {
+		return q.(string)
+	}
+	q := db.dialect.Rebind(db.tables.rename(query))
+	db.queries.Store(query, q)
+	return q
+}
+
+// Exec executes a query without returning any rows. The query is retried
+// if it fails with a transient error.
+func (db *DB) Exec(query string, args ...any) (res sql.Result, err error) {
+	err = db.Retry(func() error {
+		res, err = db.DB.Exec(db.rebind(query), args...)
+		return err
+	})
+	return
+}
+
+// Query executes a query that returns rows.
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.rebind(query), args...)
+}
+
+// QueryRow executes a query that is expected to return at most one row.
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.rebind(query), args...)
+}
+
+// Prepare creates a prepared statement.
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.rebind(query))
+}
+
+// Begin starts a transaction.
+func (db *DB) Begin() (*Tx, error) {
+	if db.lazy {
+		return &Tx{db: db}, nil
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+// begin starts the underlying transaction if it hasn't begun yet.
+func (tx *Tx) begin() error {
+	if tx.Tx != nil {
+		return nil
+	}
+	t, err := tx.db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	tx.Tx = t
+	return nil
+}
+
+// Exec executes a query without returning any rows.
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	if err := tx.begin(); err != nil {
+		return nil, err
+	}
+	return tx.Tx.Exec(tx.db.rebind(query), args...)
+}
+
+// Query executes a query that returns rows.
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	if tx.Tx == nil && isRead(query) {
+		return tx.db.Query(query, args...)
+	}
+	if err := tx.begin(); err != nil {
+		return nil, err
+	}
+	return tx.Tx.Query(tx.db.rebind(query), args...)
+}
+
+// QueryRow executes a query that is expected to return at most one row.
+// If the transaction hasn't begun yet, the query is run outside of it.
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	if tx.Tx == nil {
+		return tx.db.QueryRow(query, args...)
+	}
+	return tx.Tx.QueryRow(tx.db.rebind(query), args...)
+}
+
+// Prepare creates a prepared statement within the transaction.
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	if err := tx.begin(); err != nil {
+		return nil, err
+	}
+	return tx.Tx.Prepare(tx.db.rebind(query))
+}
+
+// Retryable returns true if the error is transient, so that the
+// transaction can be repeated.
+func (tx *Tx) Retryable(err error) bool {
+	return tx.db.Retryable(err)
+}
+
+// Commit commits the transaction.
+func (tx *Tx) Commit() error {
+	if tx.Tx == nil {
+		return nil
+	}
+	return tx.Tx.Commit()
+}
+
+// Rollback aborts the transaction.
+func (tx *Tx) Rollback() error {
+	if tx.Tx == nil {
+		return nil
+	}
+	return tx.Tx.Rollback()
+}
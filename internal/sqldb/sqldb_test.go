@@ -0,0 +1,38 @@
+package sqldb
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	query := `INSERT INTO hosts (network, public_key, tags) VALUES (?, ?, '?') AS new
+		ON DUPLICATE KEY (network, public_key) UPDATE tags = new.tags`
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{
+			dialect: mysqlDialect{},
+			want:    `INSERT INTO hosts (network, public_key, tags) VALUES (?, ?, '?') AS new ON DUPLICATE KEY UPDATE tags = new.tags`,
+		},
+		{
+			dialect: postgresDialect{},
+			want:    `INSERT INTO hosts (network, public_key, tags) VALUES ($1, $2, '?') ON CONFLICT (network, public_key) DO UPDATE SET tags = EXCLUDED.tags`,
+		},
+		{
+			dialect: sqliteDialect{},
+			want:    `INSERT INTO hosts (network, public_key, tags) VALUES (?, ?, '?') ON CONFLICT (network, public_key) DO UPDATE SET tags = excluded.tags`,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.Rebind(query); got != tt.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.dialect.Name(), tt.want, got)
+		}
+	}
+
+	// The columns after the key of the upsert are not mistaken for tables.
+	vt := newVersionedTables([]string{"hosts", "tags"}, "v2")
+	want := `INSERT INTO hosts_v2 (network, public_key, tags) VALUES (?, ?, '?') AS new
+		ON DUPLICATE KEY (network, public_key) UPDATE tags = new.tags`
+	if got := vt.rename(query); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
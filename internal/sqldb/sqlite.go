@@ -0,0 +1,82 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDialect translates the queries for an embedded SQLite database.
+// The database name is the path to the database file.
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return SQLite }
+
+func (sqliteDialect) DefaultAddr() string { return "" }
+
+// Open opens the database file in the WAL mode, so that the readers do
+// not block the writer. The transactions acquire the write lock when
+// they begin and wait for it if another connection is holding it.
+// The foreign keys are enforced, like in the other databases.
+func (sqliteDialect) Open(cfg Config) (*sql.DB, error) {
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_synchronous", "NORMAL")
+	params.Set("_busy_timeout", "60000")
+	params.Set("_txlock", "immediate")
+	params.Set("_foreign_keys", "1")
+	return sql.Open("sqlite3", "file:"+cfg.Name+"?"+params.Encode())
+}
+
+// Rebind converts the upserts into ON CONFLICT clauses on the key named
+// by the upsert. Without one, SQLite applies the update to whichever
+// uniqueness constraint is violated.
+func (sqliteDialect) Rebind(query string) string {
+	if m := upsert.FindStringSubmatch(query); m != nil {
+		target := ""
+		if m[1] != "" {
+			target = " (" + m[1] + ")"
+		}
+		query = upsert.ReplaceAllLiteralString(query, ") ON CONFLICT"+target+" DO UPDATE SET")
+		query = newRow.ReplaceAllString(query, "excluded.")
+	}
+	return query
+}
+
+func (sqliteDialect) IndexQuery() string {
+	return `
+		SELECT il.name, ii.name
+		FROM pragma_index_list(?) AS il, pragma_index_info(il.name) AS ii
+		ORDER BY il.name, ii.seqno
+	`
+}
+
+// Retryable recognizes the busy and locked errors. SQLite has no
+// deadlocks, but a transaction may not get the write lock in time. The
+// errors with an added context have lost their type, so the message is
+// checked as well.
+func (sqliteDialect) Retryable(err error) (retryable, deadlock bool) {
+	var se sqlite3.Error
+	if errors.As(err, &se) {
+		return se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked, false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked"), false
+}
+
+// isRead returns true if the query does not modify the database.
+func isRead(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH":
+		return true
+	default:
+		return false
+	}
+}
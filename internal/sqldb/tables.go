@@ -0,0 +1,129 @@
+package sqldb
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+)
+
+var (
+	// tableRef matches the table names where the syntax expects them.
+	// UPDATE is not followed by a table after ON DUPLICATE KEY and the
+	// key of the upsert, so the preceding KEY is captured to tell the two
+	// apart.
+	tableRef = regexp.MustCompile(`(?i)\b(KEY\s*(?:\([^)]*\)\s*)?)?(FROM|JOIN|INTO|UPDATE|TABLE(?:\s+IF\s+NOT\s+EXISTS)?|REFERENCES)(\s+)(\w+)`)
+
+	createTable = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?`)
+	createIndex = regexp.MustCompile(`(?i)^\s*CREATE\s+(UNIQUE\s+)?INDEX\s+(IF\s+NOT\s+EXISTS\s+)?(\w+)\s+ON\s+(\w+)`)
+)
+
+// versionedTables maps the tables of an instance that keeps its own
+// versions of them in a shared database to their versioned names.
+type versionedTables map[string]string
+
+// newVersionedTables returns the versioned names of the tables, which
+// carry the version as a suffix.
+func newVersionedTables(tables []string, version string) versionedTables {
+	if version == "" {
+		return nil
+	}
+	vt := make(versionedTables)
+	for _, table := range tables {
+		vt[table] = table + "_" + version
+	}
+	return vt
+}
+
+// rename replaces the names of the tables in the query with their
+// versioned names.
+func (vt versionedTables) rename(query string) string {
+	if len(vt) == 0 {
+		return query
+	}
+	return tableRef.ReplaceAllStringFunc(query, func(ref string) string {
+		m := tableRef.FindStringSubmatch(ref)
+		name, ok := vt[strings.ToLower(m[4])]
+		if !ok || (m[1] != "" && strings.EqualFold(m[2], "UPDATE")) {
+			return ref
+		}
+		return m[1] + m[2] + m[3] + name
+	})
+}
+
+// Table returns the name the table has in the database, which is its
+// versioned name if the tables are versioned.
+func (db *DB) Table(name string) string {
+	if versioned, ok := db.tables[name]; ok {
+		return versioned
+	}
+	return name
+}
+
+// CreateVersionedTables creates the versioned tables that don't exist yet
+// as copies of the original ones, with the same columns, keys, and
+// indexes, but without the rows. MySQL and PostgreSQL don't copy the
+// foreign keys, so the deletions don't cascade in the copies there.
+func (db *DB) CreateVersionedTables() error {
+	for table, versioned := range db.tables {
+		var err error
+		switch db.dialect.Name() {
+		case MySQL:
+			_, err = db.DB.Exec("CREATE TABLE IF NOT EXISTS " + versioned + " LIKE " + table)
+		case Postgres:
+			_, err = db.DB.Exec("CREATE TABLE IF NOT EXISTS " + versioned + " (LIKE " + table + " INCLUDING ALL)")
+		case SQLite:
+			err = db.copySQLiteTable(table)
+		}
+		if err != nil {
+			return utils.AddContext(err, "couldn't create table "+versioned)
+		}
+	}
+	return nil
+}
+
+// copySQLiteTable copies the table by running the statements that
+// created it and its indexes with the versioned names.
+func (db *DB) copySQLiteTable(table string) error {
+	rows, err := db.DB.Query(`
+		SELECT type, name, sql
+		FROM sqlite_master
+		WHERE tbl_name = ?
+		AND sql IS NOT NULL
+		ORDER BY type DESC
+	`, table)
+	if err != nil {
+		return err
+	}
+	var statements []string
+	for rows.Next() {
+		var kind, name, stmt string
+		if err := rows.Scan(&kind, &name, &stmt); err != nil {
+			rows.Close()
+			return err
+		}
+		switch kind {
+		case "table":
+			stmt = createTable.ReplaceAllString(stmt, "CREATE TABLE IF NOT EXISTS ")
+			stmt = db.tables.rename(stmt)
+		case "index":
+			m := createIndex.FindStringSubmatch(stmt)
+			if m == nil {
+				continue
+			}
+			stmt = "CREATE " + m[1] + "INDEX IF NOT EXISTS " + m[3] + "_" + db.version + " ON " + db.tables[table] + stmt[len(m[0]):]
+		}
+		statements = append(statements, stmt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Package testutil contains the helpers shared by the tests of hsd and the
+// portal.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/mike76-dev/hostscore/internal/sqldb"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+// NewDB creates an SQLite database from the schema in the given file, e.g.
+// init_sqlite.sql, which is looked up in the root of the repository.
+func NewDB(t testing.TB, schema string) *sqldb.DB {
+	t.Helper()
+	db, err := sqldb.Open(sqldb.Config{
+		Type: sqldb.SQLite,
+		Name: filepath.Join(t.TempDir(), "hostscore.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	script, err := os.ReadFile(filepath.Join(rootDir(), schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(string(script)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// rootDir returns the root of the repository.
+func rootDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
+
+// AddNodeHost inserts a host into the hosts table of the network in a
+// database of hsd.
+func AddNodeHost(t testing.TB, db *sqldb.DB, network string) types.PublicKey {
+	t.Helper()
+	pk := types.PublicKey(frand.Entropy256())
+	_, err := db.Exec(`
+		INSERT INTO hdb_hosts_`+network+` (
+			public_key, first_seen, known_since, blocked, net_address,
+			uptime, downtime, last_seen, ip_nets, last_ip_change,
+			historic_successful_interactions, historic_failed_interactions,
+			recent_successful_interactions, recent_failed_interactions,
+			last_update, modified, fetched
+		)
+		VALUES (?, 0, 0, FALSE, 'host.example.com:9982', 0, 0, 0, '', 0, 0, 0, 0, 0, 0, 0, 0)
+	`, pk[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pk
+}
+
+// AddPortalHost inserts a host into the hosts table of a database of the
+// portal.
+func AddPortalHost(t testing.TB, db *sqldb.DB, id int, network string) types.PublicKey {
+	t.Helper()
+	pk := types.PublicKey(frand.Entropy256())
+	_, err := db.Exec(`
+		INSERT INTO hosts (
+			id, network, public_key, first_seen, known_since, blocked,
+			net_address, ip_nets, last_ip_change,
+			price_score, storage_score, collateral_score, interactions_score,
+			uptime_score, age_score, version_score, latency_score,
+			benchmarks_score, contracts_score, duration_score, total_score
+		)
+		VALUES (?, ?, ?, 0, 0, FALSE, 'host.example.com:9982', '', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	`, id, network, pk[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pk
+}
+
+// CountRows returns the number of rows in the table.
+func CountRows(t testing.TB, db *sqldb.DB, table string) (count int) {
+	t.Helper()
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// QueryPlan returns the steps of the SQLite query plan, one per line.
+func QueryPlan(t testing.TB, db *sqldb.DB, query string, args ...any) string {
+	t.Helper()
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var steps []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatal(err)
+		}
+		steps = append(steps, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(steps, "\n")
+}
@@ -0,0 +1,14 @@
+package utils
+
+import "time"
+
+// DurationToMS converts a duration into fractional milliseconds, so that
+// the short durations aren't rounded down to zero when stored.
+func DurationToMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// MSToDuration converts fractional milliseconds back into a duration.
+func MSToDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
@@ -169,6 +169,30 @@ func LookupIPNets(addr string) (ipNets []string, err error) {
 	return
 }
 
+// ResolveAddresses looks up the A and AAAA records of the host and returns
+// the first IPv4 and the first IPv6 address joined with the port. An empty
+// string means that there is no address of that family.
+func ResolveAddresses(addr string) (ipv4, ipv6 string, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+	addresses, err := net.LookupIP(host)
+	if err != nil {
+		return "", "", err
+	}
+	for _, ip := range addresses {
+		if ip.To4() != nil {
+			if ipv4 == "" {
+				ipv4 = net.JoinHostPort(ip.String(), port)
+			}
+		} else if ipv6 == "" {
+			ipv6 = net.JoinHostPort(ip.String(), port)
+		}
+	}
+	return
+}
+
 // EqualIPNets checks if two slices of IP subnets contain the same subnets.
 func EqualIPNets(ipNetsA, ipNetsB []string) bool {
 	// Check the length first.
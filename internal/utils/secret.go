@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ErrNoTerminal is returned if a secret is not provided by the environment
+// and can't be prompted for either.
+var ErrNoTerminal = errors.New("standard input is not a terminal")
+
+// LookupSecret returns the value of the environment variable env. If it is
+// not set, the secret is read from the file named by the env_FILE variable,
+// which is how the container runtimes pass the secrets. source is the name
+// of the variable the secret was taken from, or empty if neither is set.
+func LookupSecret(env string) (secret, source string, err error) {
+	if secret = os.Getenv(env); secret != "" {
+		return secret, env, nil
+	}
+	file := os.Getenv(env + "_FILE")
+	if file == "" {
+		return "", "", nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", "", AddContext(err, "couldn't read "+env+"_FILE")
+	}
+	return strings.TrimRight(string(b), "\r\n"), env + "_FILE", nil
+}
+
+// ReadSecret looks up the secret in the environment. If it is not there,
+// the user is prompted for it. Without a terminal, an error is returned
+// instead of waiting for the input forever.
+func ReadSecret(env, prompt string) (string, error) {
+	secret, source, err := LookupSecret(env)
+	if err != nil {
+		return "", err
+	}
+	if source != "" {
+		log.Printf("Using %s environment variable.\n", source)
+		return secret, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("%w, set %s or %s_FILE", ErrNoTerminal, env, env)
+	}
+	fmt.Print(prompt)
+	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(pw), nil
+}
@@ -0,0 +1,306 @@
+package walletutil
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/syncer"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultStuckThreshold is the default number of blocks after which
+	// an unconfirmed transaction is considered stuck.
+	DefaultStuckThreshold = 6
+
+	// pendingCheckInterval is how often the pending transactions are checked.
+	pendingCheckInterval = 5 * time.Minute
+
+	// stuckRetention is how long an evicted transaction is reported after
+	// its inputs have been released.
+	stuckRetention = 24 * time.Hour
+
+	// feeBumpMultiplier is how much the recommended fee is multiplied by
+	// when bumping the fee of a stuck transaction.
+	feeBumpMultiplier = 2
+)
+
+const (
+	// StatusUnconfirmed means that the transaction is still in the pool
+	// but hasn't been confirmed within the stuck threshold.
+	StatusUnconfirmed = "unconfirmed"
+
+	// StatusEvicted means that the transaction has disappeared from the
+	// pool without being confirmed.
+	StatusEvicted = "evicted"
+)
+
+// StuckTransaction describes a wallet transaction that failed to confirm
+// in time.
+type StuckTransaction struct {
+	ID           types.TransactionID `json:"id"`
+	Network      string              `json:"network"`
+	Height       uint64              `json:"height"`
+	Status       string              `json:"status"`
+	DetectedAt   time.Time           `json:"detectedAt"`
+	Rebroadcasts int                 `json:"rebroadcasts"`
+	BumpedFee    types.Currency      `json:"bumpedFee"`
+}
+
+// pendingSet is a transaction set broadcast by the wallet that is waiting
+// to be confirmed.
+type pendingSet struct {
+	txnSet       []types.Transaction
+	network      string
+	height       uint64
+	status       string
+	detectedAt   time.Time
+	rebroadcasts int
+	bumpedFee    types.Currency
+}
+
+// AddPending starts tracking a broadcast transaction set until it gets
+// confirmed.
+func (w *Wallet) AddPending(network string, txnSet []types.Transaction) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.addPending(network, txnSet)
+}
+
+// addPending starts tracking a broadcast transaction set.
+// NOTE: a lock must be acquired before calling this function.
+func (w *Wallet) addPending(network string, txnSet []types.Transaction) {
+	if len(txnSet) == 0 {
+		return
+	}
+	var height uint64
+	if network == "zen" {
+		height = w.cmZen.Tip().Height
+	} else {
+		height = w.cm.Tip().Height
+	}
+	w.pending[txnSet[len(txnSet)-1].ID()] = &pendingSet{
+		txnSet:  txnSet,
+		network: network,
+		height:  height,
+	}
+}
+
+// StuckTransactions returns the transactions that failed to confirm in time.
+func (w *Wallet) StuckTransactions(network string) (stuck []StuckTransaction) {
+	if network != "mainnet" && network != "zen" {
+		panic("wrong network provided")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, ps := range w.pending {
+		if ps.network != network || ps.status == "" {
+			continue
+		}
+		stuck = append(stuck, StuckTransaction{
+			ID:           id,
+			Network:      ps.network,
+			Height:       ps.height,
+			Status:       ps.status,
+			DetectedAt:   ps.detectedAt,
+			Rebroadcasts: ps.rebroadcasts,
+			BumpedFee:    ps.bumpedFee,
+		})
+	}
+	return
+}
+
+// ownInputsUnspent returns true if any of the wallet's inputs spent by
+// the transaction set are still unspent.
+func ownInputsUnspent(s *DBStore, txnSet []types.Transaction) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, txn := range txnSet {
+		for _, sci := range txn.SiacoinInputs {
+			if sci.UnlockConditions.UnlockHash() != s.addr {
+				continue
+			}
+			if _, exists := s.sces[sci.ParentID]; exists {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkPending detects the pending transactions that got stuck.
+func (w *Wallet) checkPending(network string) {
+	var cm *chain.Manager
+	var s *DBStore
+	var sync *syncer.Syncer
+	if network == "zen" {
+		cm, s, sync = w.cmZen, w.sZen, w.syncerZen
+	} else {
+		cm, s, sync = w.cm, w.s, w.syncer
+	}
+
+	// If the wallet is lagging behind the chain, a confirmed transaction
+	// could be mistaken for an evicted one.
+	s.mu.Lock()
+	tip := s.tip
+	s.mu.Unlock()
+	if tip != cm.Tip() {
+		return
+	}
+
+	inPool := make(map[types.TransactionID]bool)
+	for _, txn := range cm.PoolTransactions() {
+		inPool[txn.ID()] = true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for id, ps := range w.pending {
+		if ps.network != network {
+			continue
+		}
+
+		if ps.status == StatusEvicted {
+			if time.Since(ps.detectedAt) > stuckRetention {
+				delete(w.pending, id)
+			}
+			continue
+		}
+
+		if inPool[id] {
+			if tip.Height < ps.height+w.stuckThreshold {
+				continue
+			}
+			if ps.status == "" {
+				ps.status = StatusUnconfirmed
+				ps.detectedAt = time.Now()
+				w.log.Warn("transaction stuck in the pool", zap.String("network", network), zap.Stringer("id", id), zap.Uint64("height", ps.height))
+			}
+			if ps.bumpedFee.IsZero() {
+				if err := w.bumpFee(network, cm, ps); err != nil {
+					w.log.Warn("couldn't bump transaction fee", zap.String("network", network), zap.Stringer("id", id), zap.Error(err))
+				}
+			}
+			sync.BroadcastTransactionSet(ps.txnSet)
+			ps.rebroadcasts++
+			continue
+		}
+
+		if !ownInputsUnspent(s, ps.txnSet) {
+			// Confirmed.
+			delete(w.pending, id)
+			continue
+		}
+
+		// The transaction was evicted from the pool, so release the inputs.
+		w.releaseInputs(ps.txnSet...)
+		ps.status = StatusEvicted
+		ps.detectedAt = time.Now()
+		w.log.Warn("transaction evicted from the pool", zap.String("network", network), zap.Stringer("id", id), zap.Uint64("height", ps.height))
+	}
+}
+
+// bumpFee adds a child transaction to a stuck transaction set, which spends
+// the change output of the set and pays a higher fee, so that the miners
+// have an incentive to include the whole set in a block. The total fee of
+// the set is capped by the configured maximum for the network; a zero cap
+// disables fee bumping.
+// NOTE: a lock must be acquired before calling this function.
+func (w *Wallet) bumpFee(network string, cm *chain.Manager, ps *pendingSet) error {
+	maxFee := w.maxFee
+	addr := w.s.addr
+	if network == "zen" {
+		maxFee = w.maxFeeZen
+		addr = w.sZen.addr
+	}
+	if maxFee.IsZero() {
+		return nil
+	}
+
+	// Find a change output to spend.
+	var parentID types.SiacoinOutputID
+	var value types.Currency
+	for i := len(ps.txnSet) - 1; i >= 0 && value.IsZero(); i-- {
+		for j, sco := range ps.txnSet[i].SiacoinOutputs {
+			if sco.Address == addr {
+				parentID = ps.txnSet[i].SiacoinOutputID(j)
+				value = sco.Value
+				break
+			}
+		}
+	}
+	if value.IsZero() {
+		return errors.New("no change output to spend")
+	}
+
+	// Calculate the fee paid so far.
+	cs := cm.TipState()
+	var paid types.Currency
+	var weight uint64
+	for _, txn := range ps.txnSet {
+		for _, fee := range txn.MinerFees {
+			paid = paid.Add(fee)
+		}
+		weight += cs.TransactionWeight(txn)
+	}
+
+	child := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			ParentID:         parentID,
+			UnlockConditions: types.StandardUnlockConditions(w.Key(network).PublicKey()),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr}},
+		MinerFees:      []types.Currency{types.ZeroCurrency},
+	}
+	weight += cs.TransactionWeight(child) + bytesPerInput
+	fee := cm.RecommendedFee().Mul64(feeBumpMultiplier).Mul64(weight)
+	if fee.Cmp(paid) <= 0 {
+		return nil
+	}
+	fee = fee.Sub(paid)
+	if paid.Add(fee).Cmp(maxFee) > 0 {
+		if paid.Cmp(maxFee) >= 0 {
+			return fmt.Errorf("fee cap reached: %v >= %v", paid, maxFee)
+		}
+		fee = maxFee.Sub(paid)
+	}
+	if fee.Cmp(value) >= 0 {
+		return fmt.Errorf("change output too small: %v <= %v", value, fee)
+	}
+	child.MinerFees[0] = fee
+	child.SiacoinOutputs[0].Value = value.Sub(fee)
+	w.Sign(network, &child, []types.Hash256{types.Hash256(parentID)}, types.CoveredFields{WholeTransaction: true})
+
+	txnSet := append(append([]types.Transaction(nil), ps.txnSet...), child)
+	if _, err := cm.AddPoolTransactions(txnSet); err != nil {
+		return utils.AddContext(err, "invalid transaction set")
+	}
+	ps.txnSet = txnSet
+	ps.bumpedFee = fee
+	w.log.Info("bumped transaction fee", zap.String("network", network), zap.Stringer("id", child.ID()), zap.Stringer("fee", fee))
+
+	return nil
+}
+
+// monitorPending periodically checks the pending transactions.
+func (w *Wallet) monitorPending(network string) {
+	if err := w.tg.Add(); err != nil {
+		w.log.Error("couldn't add a thread", zap.Error(err))
+		return
+	}
+	defer w.tg.Done()
+
+	for {
+		select {
+		case <-w.tg.StopChan():
+			return
+		case <-time.After(pendingCheckInterval):
+			w.checkPending(network)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mike76-dev/hostscore/internal/sqldb"
 	"github.com/mike76-dev/hostscore/internal/utils"
 	"github.com/mike76-dev/hostscore/wallet"
 	"go.sia.tech/core/types"
@@ -23,8 +24,8 @@ type DBStore struct {
 	sces          map[types.SiacoinOutputID]types.SiacoinElement
 	sfes          map[types.SiafundOutputID]types.SiafundElement
 	mu            sync.Mutex
-	db            *sql.DB
-	tx            *sql.Tx
+	db            *sqldb.DB
+	tx            *sqldb.Tx
 	log           *zap.Logger
 	network       string
 	lastCommitted time.Time
@@ -40,8 +41,12 @@ func (s *DBStore) save() error {
 		row = 2
 	}
 	_, err := s.tx.Exec(`
-		REPLACE INTO wt_tip (id, network, height, bid)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO wt_tip (id, network, height, bid)
+		VALUES (?, ?, ?, ?) AS new
+		ON DUPLICATE KEY (id) UPDATE
+			network = new.network,
+			height = new.height,
+			bid = new.bid
 	`, row, s.network, s.tip.Height, s.tip.ID[:])
 	if err != nil {
 		s.tx.Rollback()
@@ -147,7 +152,7 @@ func (s *DBStore) addSiacoinElements(sces []types.SiacoinElement) error {
 		_, err := s.tx.Exec(`
 			INSERT INTO wt_sces (scoid, network, bytes)
 			VALUES (?, ?, ?) AS new
-			ON DUPLICATE KEY UPDATE
+			ON DUPLICATE KEY (scoid) UPDATE
 				bytes = new.bytes
 		`, sce.ID[:], s.network, buf.Bytes())
 		if err != nil {
@@ -185,7 +190,7 @@ func (s *DBStore) addSiafundElements(sfes []types.SiafundElement) error {
 		_, err := s.tx.Exec(`
 			INSERT INTO wt_sfes (sfoid, network, bytes)
 			VALUES (?, ?, ?) AS new
-			ON DUPLICATE KEY UPDATE
+			ON DUPLICATE KEY (sfoid) UPDATE
 				bytes = new.bytes
 		`, sfe.ID[:], s.network, buf.Bytes())
 		if err != nil {
@@ -508,7 +513,7 @@ func (s *DBStore) Address() types.Address {
 }
 
 // NewDBStore returns a new DBStore.
-func NewDBStore(db *sql.DB, seed, network string, logger *zap.Logger) (*DBStore, types.ChainIndex, error) {
+func NewDBStore(db *sqldb.DB, seed, network string, logger *zap.Logger) (*DBStore, types.ChainIndex, error) {
 	sk, err := wallet.KeyFromPhrase(seed)
 	if err != nil {
 		return nil, types.ChainIndex{}, err
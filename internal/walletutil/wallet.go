@@ -1,7 +1,6 @@
 package walletutil
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"log"
@@ -10,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mike76-dev/hostscore/internal/sqldb"
 	siasync "github.com/mike76-dev/hostscore/internal/sync"
 	"github.com/mike76-dev/hostscore/internal/utils"
 	"github.com/mike76-dev/hostscore/persist"
@@ -49,7 +49,7 @@ var (
 var ErrInsufficientBalance = errors.New("insufficient balance")
 
 type Wallet struct {
-	db             *sql.DB
+	db             *sqldb.DB
 	s              *DBStore
 	sZen           *DBStore
 	cm             *chain.Manager
@@ -61,9 +61,14 @@ type Wallet struct {
 	log            *zap.Logger
 	closeFn        func()
 
-	mu     sync.Mutex
-	tg     siasync.ThreadGroup
-	locked map[types.Hash256]time.Time
+	mu        sync.Mutex
+	tg        siasync.ThreadGroup
+	locked    map[types.Hash256]time.Time
+	pending   map[types.TransactionID]*pendingSet
+	maxFee    types.Currency
+	maxFeeZen types.Currency
+
+	stuckThreshold uint64
 }
 
 // Address implements api.Wallet.
@@ -111,6 +116,31 @@ func (w *Wallet) UnspentOutputs(network string) ([]types.SiacoinElement, []types
 	panic("wrong network provided")
 }
 
+// Balance returns the mature and the immature siacoin balance of the
+// wallet.
+func (w *Wallet) Balance(network string) (sc, immature types.Currency, err error) {
+	scos, _, err := w.UnspentOutputs(network)
+	if err != nil {
+		return types.ZeroCurrency, types.ZeroCurrency, err
+	}
+
+	var height uint64
+	if network == "zen" {
+		height = w.cmZen.Tip().Height
+	} else {
+		height = w.cm.Tip().Height
+	}
+
+	for _, sco := range scos {
+		if height >= sco.MaturityHeight {
+			sc = sc.Add(sco.SiacoinOutput.Value)
+		} else {
+			immature = immature.Add(sco.SiacoinOutput.Value)
+		}
+	}
+	return
+}
+
 // Close shuts down the wallet.
 func (w *Wallet) Close() {
 	if err := w.tg.Stop(); err != nil {
@@ -139,7 +169,15 @@ func syncStore(store *DBStore, cm *chain.Manager, index types.ChainIndex) error
 }
 
 // NewWallet returns a wallet that is stored in a MySQL database.
-func NewWallet(db *sql.DB, seed, seedZen, dir string, cm *chain.Manager, cmZen *chain.Manager, syncer *syncer.Syncer, syncerZen *syncer.Syncer) (*Wallet, error) {
+// maxFee and maxFeeZen cap the total fee paid by a transaction set when
+// its fee is bumped; zero values disable fee bumping. stuckThreshold is
+// the number of blocks after which an unconfirmed transaction is
+// considered stuck; zero means DefaultStuckThreshold.
+func NewWallet(db *sqldb.DB, seed, seedZen, dir string, maxFee, maxFeeZen types.Currency, stuckThreshold uint64, cm *chain.Manager, cmZen *chain.Manager, syncer *syncer.Syncer, syncerZen *syncer.Syncer) (*Wallet, error) {
+	if stuckThreshold == 0 {
+		stuckThreshold = DefaultStuckThreshold
+	}
+
 	l, closeFn, err := persist.NewFileLogger(filepath.Join(dir, "wallet.log"))
 	if err != nil {
 		log.Fatal(err)
@@ -166,6 +204,11 @@ func NewWallet(db *sql.DB, seed, seedZen, dir string, cm *chain.Manager, cmZen *
 		log:       l,
 		closeFn:   closeFn,
 		locked:    make(map[types.Hash256]time.Time),
+		pending:   make(map[types.TransactionID]*pendingSet),
+		maxFee:    maxFee,
+		maxFeeZen: maxFeeZen,
+
+		stuckThreshold: stuckThreshold,
 	}
 
 	go func() {
@@ -242,6 +285,8 @@ func NewWallet(db *sql.DB, seed, seedZen, dir string, cm *chain.Manager, cmZen *
 	go w.performWalletMaintenance("mainnet")
 	go w.performWalletMaintenance("zen")
 	go w.pruneLocked()
+	go w.monitorPending("mainnet")
+	go w.monitorPending("zen")
 
 	return w, nil
 }
@@ -251,7 +296,7 @@ func (w *Wallet) lock(id types.Hash256, until time.Time) {
 	_, err := w.db.Exec(`
 		INSERT INTO wt_locked (id, until)
 		VALUES (?, ?) AS new
-		ON DUPLICATE KEY UPDATE until = new.until
+		ON DUPLICATE KEY (id) UPDATE until = new.until
 	`, id[:], until.Unix())
 	if err != nil {
 		w.log.Error("couldn't lock input", zap.Stringer("ID", id), zap.Error(err))
@@ -626,6 +671,9 @@ func (w *Wallet) Redistribute(network string, amount types.Currency, outputs int
 	} else {
 		w.syncer.BroadcastTransactionSet(txns)
 	}
+	for _, txn := range txns {
+		w.addPending(network, []types.Transaction{txn})
+	}
 
 	return nil
 }
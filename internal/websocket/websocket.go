@@ -0,0 +1,231 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) to the extent needed to push events to the clients.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes defined by RFC 6455.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+const (
+	// acceptGUID is appended to the client key to compute the accept key.
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// maxMessageSize is the largest message accepted from a client.
+	maxMessageSize = 64 << 10
+
+	// writeTimeout limits the time it takes to send a frame.
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	// ErrClosed is returned when the client has closed the connection.
+	ErrClosed = errors.New("connection closed")
+
+	errNotWebSocket    = errors.New("not a websocket handshake")
+	errUnmaskedFrame   = errors.New("client frame is not masked")
+	errMessageTooLarge = errors.New("message too large")
+)
+
+// Conn is a server-side WebSocket connection.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade performs the opening handshake and takes over the connection.
+// If the request is not a valid handshake, an error response is written.
+func Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		http.Error(w, errNotWebSocket.Error(), http.StatusBadRequest)
+		return nil, errNotWebSocket
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.Sum([]byte(key + acceptGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+// writeFrame sends a single unfragmented frame. Server frames are not
+// masked.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// WriteJSON sends v as a text message.
+func (c *Conn) WriteJSON(v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(opText, js)
+}
+
+// Ping sends a ping to keep the connection alive.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// readFrame reads a single frame and unmasks its payload.
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	opcode = h[0] & 0x0f
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, errUnmaskedFrame
+	}
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > maxMessageSize {
+		return false, 0, nil, errMessageTooLarge
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next text or binary message from the client.
+// The control frames are handled internally. ErrClosed is returned when
+// the client closes the connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, ErrClosed
+		case opText, opBinary, opContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > maxMessageSize {
+				return nil, errMessageTooLarge
+			}
+		default:
+			return nil, errors.New("unknown opcode")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// Close sends a normal closure frame and closes the connection.
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, []byte{0x03, 0xe8})
+	return c.conn.Close()
+}
@@ -20,9 +20,32 @@ type HSDConfig struct {
 	GatewayMainnet string `json:"gatewayMainnet"`
 	GatewayZen     string `json:"gatewayZen"`
 	APIAddr        string `json:"api"`
+	MetricsAddr    string `json:"metrics,omitempty"`
 	Dir            string `json:"dir"`
+	DBType         string `json:"dbType,omitempty"`
+	DBAddr         string `json:"dbAddr,omitempty"`
 	DBUser         string `json:"dbUser"`
 	DBName         string `json:"dbName"`
+	MaxFeeMainnet  string `json:"maxFeeMainnet,omitempty"`
+	MaxFeeZen      string `json:"maxFeeZen,omitempty"`
+	StuckThreshold uint64 `json:"stuckThreshold,omitempty"`
+
+	BenchmarkSectors        int    `json:"benchmarkSectors,omitempty"`
+	BenchmarkInterval       string `json:"benchmarkInterval,omitempty"`
+	BenchmarkSchedule       string `json:"benchmarkSchedule,omitempty"`
+	MaxBenchmarkInterval    string `json:"maxBenchmarkInterval,omitempty"`
+	BenchmarkTopRank        int    `json:"benchmarkTopRank,omitempty"`
+	MaxBenchmarkCostMainnet string `json:"maxBenchmarkCostMainnet,omitempty"`
+	MaxBenchmarkCostZen     string `json:"maxBenchmarkCostZen,omitempty"`
+	BenchmarkBudgetMainnet  string `json:"benchmarkBudgetMainnet,omitempty"`
+	BenchmarkBudgetZen      string `json:"benchmarkBudgetZen,omitempty"`
+	MinBalanceMainnet       string `json:"minBalanceMainnet,omitempty"`
+	MinBalanceZen           string `json:"minBalanceZen,omitempty"`
+
+	AllowlistMode bool     `json:"allowlistMode,omitempty"`
+	Allowlist     []string `json:"allowlist,omitempty"`
+
+	UpdateCheck bool `json:"updateCheck,omitempty"`
 }
 
 // hsdMetadata contains the header and version strings that identify the
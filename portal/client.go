@@ -0,0 +1,374 @@
+// Package portal provides a client of the public API of a HostScore portal.
+package portal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mike76-dev/hostscore/internal/utils"
+	"go.sia.tech/core/types"
+)
+
+// apiKeyHeader is the header carrying the API key.
+const apiKeyHeader = "X-HostScore-API-Key"
+
+// A Client provides methods for interacting with the API of a portal.
+type Client struct {
+	addr   string
+	apiKey string
+	c      *http.Client
+	ctx    context.Context
+}
+
+// NewClient returns a client of the portal API at addr, e.g.
+// "https://api.hostscore.info/v1". The API key is optional; without it,
+// the requests are subject to the anonymous rate limit.
+func NewClient(addr, apiKey string) *Client {
+	return &Client{
+		addr:   strings.TrimSuffix(addr, "/"),
+		apiKey: apiKey,
+		c:      &http.Client{Timeout: time.Minute},
+		ctx:    context.Background(),
+	}
+}
+
+// WithContext returns a copy of the client that uses the provided context
+// for all requests.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	return &Client{
+		addr:   c.addr,
+		apiKey: c.apiKey,
+		c:      c.c,
+		ctx:    ctx,
+	}
+}
+
+// get performs a GET request and decodes the response into resp.
+func (c *Client) get(route string, query url.Values, resp any) error {
+	u := c.addr + route
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if c.apiKey != "" {
+		req.Header.Set(apiKeyHeader, c.apiKey)
+	}
+
+	r, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		// The portal returns the errors as JSON strings.
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 4096))
+		var msg string
+		if err := json.Unmarshal(body, &msg); err != nil || msg == "" {
+			msg = strings.TrimSpace(string(body))
+		}
+		if msg == "" {
+			return fmt.Errorf("portal returned %s", r.Status)
+		}
+		return fmt.Errorf("portal returned %s: %s", r.Status, msg)
+	}
+
+	return utils.AddContext(json.NewDecoder(r.Body).Decode(resp), "couldn't decode response")
+}
+
+// networkQuery returns the query parameters selecting the network.
+func networkQuery(network string) url.Values {
+	query := url.Values{}
+	if network != "" {
+		query.Set("network", network)
+	}
+	return query
+}
+
+// HostsOptions contains the parameters of a hosts query. The zero value
+// requests the first page of the online hosts sorted by rank.
+type HostsOptions struct {
+	// All includes the offline hosts.
+	All bool
+
+	// Offset is the number of the hosts to skip. It can't be combined
+	// with After.
+	Offset int
+
+	// After is the cursor returned as Next with the previous page. It
+	// requires sorting by rank or by ID.
+	After int
+
+	// Limit is the size of the page. Zero or a negative value means no
+	// limit.
+	Limit int
+
+	// Sort is one of "rank", "id", "total", "used", "storage", "upload",
+	// "download", "uptime", "latency", "benchmarks", "age", "collateral",
+	// and "firstseen". Order is "asc" or "desc".
+	Sort  string
+	Order string
+
+	// The filters. Online is nil to return both the online and the
+	// offline hosts.
+	Query              string
+	Country            string
+	ISP                string
+	ASN                string
+	Version            string
+	Tag                string
+	MinScore           float64
+	AcceptingContracts bool
+	Online             *bool
+}
+
+// query returns the query parameters of the options.
+func (opts HostsOptions) query(network string) url.Values {
+	query := networkQuery(network)
+	if opts.All {
+		query.Set("all", "true")
+	}
+	if opts.After > 0 {
+		query.Set("after", strconv.Itoa(opts.After))
+	} else {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	query.Set("limit", strconv.Itoa(limit))
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("sort", opts.Sort)
+	set("order", opts.Order)
+	set("query", opts.Query)
+	set("country", opts.Country)
+	set("isp", opts.ISP)
+	set("asn", opts.ASN)
+	set("version", opts.Version)
+	set("tag", opts.Tag)
+	if opts.MinScore > 0 {
+		query.Set("minScore", strconv.FormatFloat(opts.MinScore, 'f', -1, 64))
+	}
+	if opts.AcceptingContracts {
+		query.Set("acceptingContracts", "true")
+	}
+	if opts.Online != nil {
+		query.Set("online", strconv.FormatBool(*opts.Online))
+	}
+	return query
+}
+
+// Hosts returns a page of the hosts of the network.
+func (c *Client) Hosts(network string, opts HostsOptions) (resp HostsResponse, err error) {
+	err = c.get("/hosts", opts.query(network), &resp)
+	return
+}
+
+// HostSummaries returns a page of the hosts of the network in the compact
+// view.
+func (c *Client) HostSummaries(network string, opts HostsOptions) (resp HostSummariesResponse, err error) {
+	query := opts.query(network)
+	query.Set("view", "summary")
+	err = c.get("/hosts", query, &resp)
+	return
+}
+
+// KeysOptions contains the criteria the hosts must meet to be returned by
+// HostKeys. The zero values mean no restriction.
+type KeysOptions struct {
+	// Node is the node whose measurements are used. It defaults to all
+	// nodes.
+	Node string
+
+	MaxStoragePrice      types.Currency // per byte per block
+	MaxUploadPrice       types.Currency // per byte
+	MaxDownloadPrice     types.Currency // per byte
+	MaxContractPrice     types.Currency
+	MaxBaseRPCPrice      types.Currency
+	MaxSectorAccessPrice types.Currency
+	MinContractDuration  uint64 // in blocks
+	MinAvailableStorage  uint64 // in bytes
+	MinVersion           string
+	MaxLatency           time.Duration
+	MinUploadSpeed       uint64 // in bytes per second
+	MinDownloadSpeed     uint64 // in bytes per second
+	Countries            []string
+
+	// Limit is the maximum number of the keys returned. Zero means no
+	// limit.
+	Limit int
+}
+
+// HostKeys returns the public keys of the hosts of the network meeting
+// the criteria, the best ranked first.
+func (c *Client) HostKeys(network string, opts KeysOptions) ([]types.PublicKey, error) {
+	query := networkQuery(network)
+	if opts.Node != "" {
+		query.Set("node", opts.Node)
+	}
+	prices := []struct {
+		key   string
+		value types.Currency
+	}{
+		{"maxStoragePrice", opts.MaxStoragePrice},
+		{"maxUploadPrice", opts.MaxUploadPrice},
+		{"maxDownloadPrice", opts.MaxDownloadPrice},
+		{"maxContractPrice", opts.MaxContractPrice},
+		{"maxBaseRPCPrice", opts.MaxBaseRPCPrice},
+		{"maxSectorAccessPrice", opts.MaxSectorAccessPrice},
+	}
+	for _, p := range prices {
+		if !p.value.IsZero() {
+			query.Set(p.key, p.value.ExactString())
+		}
+	}
+	numbers := []struct {
+		key   string
+		value uint64
+	}{
+		{"minContractDuration", opts.MinContractDuration},
+		{"minAvailableStorage", opts.MinAvailableStorage},
+		{"maxLatency", uint64(opts.MaxLatency)},
+		{"minUploadSpeed", opts.MinUploadSpeed},
+		{"minDownloadSpeed", opts.MinDownloadSpeed},
+	}
+	for _, n := range numbers {
+		if n.value > 0 {
+			query.Set(n.key, strconv.FormatUint(n.value, 10))
+		}
+	}
+	if opts.MinVersion != "" {
+		query.Set("minVersion", opts.MinVersion)
+	}
+	for _, country := range opts.Countries {
+		query.Add("country", country)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	var resp struct {
+		Keys []types.PublicKey `json:"keys"`
+	}
+	err := c.get("/hosts/keys", query, &resp)
+	return resp.Keys, err
+}
+
+// NewHosts returns the hosts of the network first seen after since, the
+// oldest first. The zero time means the last 24 hours, and a zero limit
+// means the maximum allowed by the portal.
+func (c *Client) NewHosts(network string, since time.Time, limit int) ([]NewHost, error) {
+	query := networkQuery(network)
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var resp struct {
+		Hosts []NewHost `json:"hosts"`
+	}
+	err := c.get("/hosts/new", query, &resp)
+	return resp.Hosts, err
+}
+
+// NetworkHosts returns the number of the hosts of the network.
+func (c *Client) NetworkHosts(network string) (HostCount, error) {
+	var resp struct {
+		Hosts HostCount `json:"hosts"`
+	}
+	err := c.get("/network/hosts", networkQuery(network), &resp)
+	return resp.Hosts, err
+}
+
+// NetworkAverages returns the average prices of the network by tier.
+func (c *Client) NetworkAverages(network string) (map[string]NetworkAverages, error) {
+	var resp struct {
+		Averages map[string]NetworkAverages `json:"averages"`
+	}
+	err := c.get("/network/averages", networkQuery(network), &resp)
+	return resp.Averages, err
+}
+
+// NetworkStorage returns the total and the used storage of the online
+// hosts of the network.
+func (c *Client) NetworkStorage(network string) (NetworkStorage, error) {
+	var resp struct {
+		Storage NetworkStorage `json:"storage"`
+	}
+	err := c.get("/network/storage", networkQuery(network), &resp)
+	return resp.Storage, err
+}
+
+// NetworkHistory returns the hourly statistics of the network between
+// from and to. The zero times and a zero limit mean no restriction.
+func (c *Client) NetworkHistory(network string, from, to time.Time, limit int) ([]NetworkSnapshot, error) {
+	query := networkQuery(network)
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var resp struct {
+		History []NetworkSnapshot `json:"history"`
+	}
+	err := c.get("/network/history", query, &resp)
+	return resp.History, err
+}
+
+// NetworkASNs returns how the hosts of the network are spread across the
+// autonomous systems. If all is false, only the online hosts are counted.
+func (c *Client) NetworkASNs(network string, all bool) (resp ASNReport, err error) {
+	query := networkQuery(network)
+	query.Set("all", strconv.FormatBool(all))
+	err = c.get("/network/asns", query, &resp)
+	return
+}
+
+// NetworkSybil returns the sybil clusters of the network.
+func (c *Client) NetworkSybil(network string) (resp SybilReport, err error) {
+	err = c.get("/network/sybil", networkQuery(network), &resp)
+	return
+}
+
+// NetworkDistribution returns a histogram of the hosts of the network by
+// "version", "country", "storage_price", or "collateral". If all is false,
+// only the online hosts are counted.
+func (c *Client) NetworkDistribution(network, by string, all bool) (resp Distribution, err error) {
+	query := networkQuery(network)
+	query.Set("by", by)
+	query.Set("all", strconv.FormatBool(all))
+	err = c.get("/network/distribution", query, &resp)
+	return
+}
+
+// NetworkCountries returns the countries the hosts of the network are
+// located in. If all is false, only the online hosts are considered.
+func (c *Client) NetworkCountries(network string, all bool) ([]string, error) {
+	query := networkQuery(network)
+	query.Set("all", strconv.FormatBool(all))
+	var resp struct {
+		Countries []string `json:"countries"`
+	}
+	err := c.get("/network/countries", query, &resp)
+	return resp.Countries, err
+}
@@ -0,0 +1,285 @@
+package portal
+
+import (
+	"time"
+
+	"github.com/mike76-dev/hostscore/external"
+	"github.com/mike76-dev/hostscore/hostdb"
+	rhpv2 "go.sia.tech/core/rhp/v2"
+	rhpv3 "go.sia.tech/core/rhp/v3"
+	"go.sia.tech/core/types"
+)
+
+// ScoreBreakdown contains the partial scores of a host and the total one.
+type ScoreBreakdown struct {
+	PricesScore       float64 `json:"prices"`
+	StorageScore      float64 `json:"storage"`
+	CollateralScore   float64 `json:"collateral"`
+	InteractionsScore float64 `json:"interactions"`
+	UptimeScore       float64 `json:"uptime"`
+	AgeScore          float64 `json:"age"`
+	VersionScore      float64 `json:"version"`
+	LatencyScore      float64 `json:"latency"`
+	BenchmarksScore   float64 `json:"benchmarks"`
+	ContractsScore    float64 `json:"contracts"`
+	DurationScore     float64 `json:"duration"`
+	TotalScore        float64 `json:"total"`
+}
+
+// Scan is a scan of a host by a node.
+type Scan struct {
+	Timestamp  time.Time           `json:"timestamp"`
+	Success    bool                `json:"success"`
+	Latency    time.Duration       `json:"latency"`
+	Error      string              `json:"error"`
+	Failure    hostdb.FailureClass `json:"failure"`
+	ClockSkew  int64               `json:"clockSkew"`
+	ValidUntil time.Time           `json:"validUntil"`
+	InvalidSig bool                `json:"invalidSignature"`
+	IPv4       hostdb.AddressScan  `json:"ipv4"`
+	IPv6       hostdb.AddressScan  `json:"ipv6"`
+	Timings    hostdb.ScanTimings  `json:"timings"`
+}
+
+// Reachability shows over which address families a host can be reached.
+type Reachability struct {
+	IPv4      hostdb.Reachability `json:"ipv4"`
+	IPv6      hostdb.Reachability `json:"ipv6"`
+	DualStack bool                `json:"dualStack"`
+}
+
+// Traffic is the amount of data exchanged with a host by the benchmarks.
+type Traffic struct {
+	Ingress uint64    `json:"ingress"` // bytes received by the host
+	Egress  uint64    `json:"egress"`  // bytes sent by the host
+	Since   time.Time `json:"since"`
+}
+
+// NodeInteractions contains what a node knows about a host.
+type NodeInteractions struct {
+	Uptime           time.Duration             `json:"uptime"`
+	Downtime         time.Duration             `json:"downtime"`
+	ScanHistory      []Scan                    `json:"scanHistory"`
+	BenchmarkHistory []hostdb.HostBenchmark    `json:"benchmarkHistory"`
+	LastSeen         time.Time                 `json:"lastSeen"`
+	ActiveHosts      int                       `json:"activeHosts"`
+	Score            ScoreBreakdown            `json:"score"`
+	Standby          bool                      `json:"standby"`
+	Compliance       hostdb.ContractCompliance `json:"compliance"`
+	HighSkew         bool                      `json:"highSkew"`
+	InvalidSig       bool                      `json:"invalidSignature"`
+	Reachability     Reachability              `json:"reachability"`
+	Traffic          Traffic                   `json:"traffic"`
+	hostdb.HostInteractions
+}
+
+// FederatedScore is the score of a host received from another portal.
+type FederatedScore struct {
+	Portal    string         `json:"portal"`
+	Node      string         `json:"node"`
+	Score     ScoreBreakdown `json:"score"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// CommunityScore sums up the telemetry reported by the renters.
+type CommunityScore struct {
+	Contributors       int     `json:"contributors"`
+	FormationSuccesses int64   `json:"formationSuccesses"`
+	FormationFailures  int64   `json:"formationFailures"`
+	UploadSuccesses    int64   `json:"uploadSuccesses"`
+	UploadFailures     int64   `json:"uploadFailures"`
+	DownloadSuccesses  int64   `json:"downloadSuccesses"`
+	DownloadFailures   int64   `json:"downloadFailures"`
+	Score              float64 `json:"score"`
+}
+
+// TimeZoneHint tells the local time of a host.
+type TimeZoneHint struct {
+	UTCOffset   int    `json:"utcOffset"`   // in minutes, on the current date
+	MidnightUTC string `json:"midnightUTC"` // local midnight as the UTC time of day
+}
+
+// Host is a host as seen by the portal.
+type Host struct {
+	ID           int                         `json:"id"`
+	Rank         int                         `json:"rank"`
+	PublicKey    types.PublicKey             `json:"publicKey"`
+	FirstSeen    time.Time                   `json:"firstSeen"`
+	KnownSince   uint64                      `json:"knownSince"`
+	NetAddress   string                      `json:"netaddress"`
+	Blocked      bool                        `json:"blocked"`
+	Quarantined  bool                        `json:"quarantined"`
+	Interactions map[string]NodeInteractions `json:"interactions"`
+	Federated    []FederatedScore            `json:"federated,omitempty"`
+	Community    *CommunityScore             `json:"community,omitempty"`
+	IPNets       []string                    `json:"ipNets"`
+	LastIPChange time.Time                   `json:"lastIPChange"`
+	Reachability Reachability                `json:"reachability"`
+	Score        ScoreBreakdown              `json:"score"`
+	Settings     rhpv2.HostSettings          `json:"settings"`
+	PriceTable   rhpv3.HostPriceTable        `json:"priceTable"`
+	TimeZoneHint *TimeZoneHint               `json:"timeZoneHint,omitempty"`
+	Traffic      Traffic                     `json:"traffic"`
+	OptOut       hostdb.OptOutLevel          `json:"optOut,omitempty"`
+	Tags         []string                    `json:"tags"`
+	SybilCluster int                         `json:"sybilCluster,omitempty"`
+	external.IPInfo
+}
+
+// HostSummary is the compact view of a host.
+type HostSummary struct {
+	ID                 int                `json:"id"`
+	Rank               int                `json:"rank"`
+	PublicKey          types.PublicKey    `json:"publicKey"`
+	NetAddress         string             `json:"netaddress"`
+	FirstSeen          time.Time          `json:"firstSeen"`
+	Online             bool               `json:"online"`
+	Quarantined        bool               `json:"quarantined"`
+	OptOut             hostdb.OptOutLevel `json:"optOut,omitempty"`
+	Tags               []string           `json:"tags"`
+	Score              ScoreBreakdown     `json:"score"`
+	AcceptingContracts bool               `json:"acceptingContracts"`
+	TotalStorage       uint64             `json:"totalStorage"`
+	RemainingStorage   uint64             `json:"remainingStorage"`
+	StoragePrice       types.Currency     `json:"storagePrice"`
+	Collateral         types.Currency     `json:"collateral"`
+	UploadPrice        types.Currency     `json:"uploadPrice"`
+	DownloadPrice      types.Currency     `json:"downloadPrice"`
+	ContractPrice      types.Currency     `json:"contractPrice"`
+	Version            string             `json:"version"`
+	Release            string             `json:"release"`
+	Country            string             `json:"country"`
+}
+
+// HostsResponse is a page of hosts.
+type HostsResponse struct {
+	Hosts []Host `json:"hosts"`
+	More  bool   `json:"more"`
+	Total int    `json:"total"`
+	Next  int    `json:"next,omitempty"` // cursor of the next page
+}
+
+// HostSummariesResponse is a page of hosts in the compact view.
+type HostSummariesResponse struct {
+	Hosts []HostSummary `json:"hosts"`
+	More  bool          `json:"more"`
+	Total int           `json:"total"`
+	Next  int           `json:"next,omitempty"` // cursor of the next page
+}
+
+// NewHost is a host that has announced itself for the first time.
+type NewHost struct {
+	Network    string              `json:"network"`
+	PublicKey  types.PublicKey     `json:"publicKey"`
+	NetAddress string              `json:"netAddress"`
+	FirstSeen  time.Time           `json:"firstSeen"`
+	KnownSince uint64              `json:"knownSince"`
+	Settings   *rhpv2.HostSettings `json:"settings,omitempty"`
+}
+
+// HostCount is the number of the hosts of a network.
+type HostCount struct {
+	Total  int `json:"total"`
+	Online int `json:"online"`
+}
+
+// NetworkAverages contains the average prices of a group of hosts.
+type NetworkAverages struct {
+	StoragePrice     types.Currency `json:"storagePrice"`
+	Collateral       types.Currency `json:"collateral"`
+	UploadPrice      types.Currency `json:"uploadPrice"`
+	DownloadPrice    types.Currency `json:"downloadPrice"`
+	ContractDuration uint64         `json:"contractDuration"`
+	Available        bool           `json:"available"`
+}
+
+// StorageStats sums up the storage of a group of online hosts.
+type StorageStats struct {
+	Hosts        int    `json:"hosts"`
+	TotalStorage uint64 `json:"totalStorage"`
+	UsedStorage  uint64 `json:"usedStorage"`
+}
+
+// NetworkStorage sums up the storage of the network, in total, by
+// country, and by tier.
+type NetworkStorage struct {
+	StorageStats
+	Countries map[string]StorageStats `json:"countries"`
+	Tiers     map[string]StorageStats `json:"tiers"`
+}
+
+// NetworkSnapshot contains the statistics of a network at some point of
+// time.
+type NetworkSnapshot struct {
+	Timestamp          time.Time      `json:"timestamp"`
+	Hosts              int            `json:"hosts"`
+	OnlineHosts        int            `json:"onlineHosts"`
+	AcceptingContracts int            `json:"acceptingContracts"`
+	TotalStorage       uint64         `json:"totalStorage"`
+	UsedStorage        uint64         `json:"usedStorage"`
+	StoragePrice       types.Currency `json:"storagePrice"`
+	Collateral         types.Currency `json:"collateral"`
+	UploadPrice        types.Currency `json:"uploadPrice"`
+	DownloadPrice      types.Currency `json:"downloadPrice"`
+	UploadSpeed        float64        `json:"uploadSpeed"`
+	DownloadSpeed      float64        `json:"downloadSpeed"`
+	TTFB               time.Duration  `json:"ttfb"`
+	CollateralCapacity types.Currency `json:"collateralCapacity"`
+}
+
+// ASNShare is the number of hosts in an autonomous system.
+type ASNShare struct {
+	ASN      string  `json:"asn"`
+	Provider string  `json:"provider"`
+	Hosts    int     `json:"hosts"`
+	Share    float64 `json:"share"`
+}
+
+// ASNReport shows how the hosts are spread across the autonomous
+// systems. The hosts without a known ASN are only counted in Unknown.
+type ASNReport struct {
+	Total   int        `json:"total"`
+	Unknown int        `json:"unknown"`
+	ASNs    []ASNShare `json:"asns"`
+}
+
+// SybilHost is a member of a sybil cluster.
+type SybilHost struct {
+	PublicKey  types.PublicKey `json:"publicKey"`
+	NetAddress string          `json:"netaddress"`
+	Rank       int             `json:"rank"`
+	Penalized  bool            `json:"penalized"`
+}
+
+// SybilCluster is a group of hosts sharing subnets or wallets.
+type SybilCluster struct {
+	ID      int             `json:"id"`
+	Hosts   []SybilHost     `json:"hosts"`
+	Subnets []string        `json:"subnets"`
+	Wallets []types.Address `json:"wallets"`
+}
+
+// SybilReport lists the sybil clusters of a network, the largest first.
+type SybilReport struct {
+	Penalty  float64        `json:"penalty"`
+	Clusters []SybilCluster `json:"clusters"`
+}
+
+// DistributionBucket is the number of hosts in a bucket of the histogram.
+// The numeric buckets contain the values from Min up to, but not
+// including, Max, in Hastings per TB per month. The last bucket has no
+// Max.
+type DistributionBucket struct {
+	Label string          `json:"label"`
+	Min   *types.Currency `json:"min,omitempty"`
+	Max   *types.Currency `json:"max,omitempty"`
+	Hosts int             `json:"hosts"`
+	Share float64         `json:"share"`
+}
+
+// Distribution is a histogram of the hosts of a network.
+type Distribution struct {
+	By      string               `json:"by"`
+	Total   int                  `json:"total"`
+	Buckets []DistributionBucket `json:"buckets"`
+}
@@ -423,3 +423,151 @@ func RPCAccountBalance(ctx context.Context, t *rhpv3.Transport, payment rhpv3.Pa
 	}
 	return resp.Balance, nil
 }
+
+// RenewPrepareFunc is a function that can be passed in to RPCRenewContract.
+// It is called after the price table is received from the host and supposed
+// to return a funded transaction set, which contains the final revision of
+// the old contract and the new contract. It can also be used to perform
+// gouging checks before the renewal.
+type RenewPrepareFunc func(pt rhpv3.HostPriceTable) ([]types.Transaction, error)
+
+// RenewSignFunc is a function that can be passed in to RPCRenewContract.
+// It is called after the host has added its inputs and supposed to sign
+// the inputs that the renter has funded the transaction with.
+type RenewSignFunc func(txn *types.Transaction, cf types.CoveredFields)
+
+// RPCRenewContract renews the contract with the host. The host's temporary
+// price table is used, so that the old contract doesn't need to be revised
+// before the renewal.
+func RPCRenewContract(ctx context.Context, t *rhpv3.Transport, renterKey types.PrivateKey, rev types.FileContractRevision, prepareFunc RenewPrepareFunc, signFunc RenewSignFunc) (_ rhpv2.ContractRevision, _ []types.Transaction, err error) {
+	s := t.DialStream()
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(time.Minute))
+
+	// Request a temporary price table.
+	const maxPriceTableSize = 16 * 1024
+	var ptUID rhpv3.SettingsID
+	var ptr rhpv3.RPCUpdatePriceTableResponse
+	var pt rhpv3.HostPriceTable
+	if err := s.WriteRequest(rhpv3.RPCRenewContractID, &ptUID); err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	} else if err := s.ReadResponse(&ptr, maxPriceTableSize); err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	} else if err := json.Unmarshal(ptr.PriceTableJSON, &pt); err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	}
+
+	// Prepare the transaction set.
+	txnSet, err := prepareFunc(pt)
+	if err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	}
+	parents := append([]types.Transaction(nil), txnSet[:len(txnSet)-1]...)
+	txn := txnSet[len(txnSet)-1]
+
+	// Sign the new contract and the final revision only, so that the host
+	// can add its own inputs and outputs.
+	h := types.NewHasher()
+	txn.FileContracts[0].EncodeTo(h.E)
+	txn.FileContractRevisions[0].EncodeTo(h.E)
+	finalRevisionSignature := renterKey.SignHash(h.Sum())
+
+	req := &rhpv3.RPCRenewContractRequest{
+		TransactionSet:         txnSet,
+		RenterKey:              rev.UnlockConditions.PublicKeys[0],
+		FinalRevisionSignature: finalRevisionSignature,
+	}
+	if err := s.WriteResponse(req); err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	}
+
+	// Incorporate the host's additions.
+	var hostAdditions rhpv3.RPCRenewContractHostAdditions
+	if err := s.ReadResponse(&hostAdditions, 65536); err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	}
+	parents = append(parents, hostAdditions.Parents...)
+	txn.SiacoinInputs = append(txn.SiacoinInputs, hostAdditions.SiacoinInputs...)
+	txn.SiacoinOutputs = append(txn.SiacoinOutputs, hostAdditions.SiacoinOutputs...)
+	cf := types.CoveredFields{
+		FileContracts:         []uint64{0},
+		FileContractRevisions: []uint64{0},
+	}
+	txn.Signatures = []types.TransactionSignature{
+		{
+			ParentID:       types.Hash256(rev.ParentID),
+			PublicKeyIndex: 0,
+			CoveredFields:  cf,
+			Signature:      finalRevisionSignature[:],
+		},
+		{
+			ParentID:       types.Hash256(rev.ParentID),
+			PublicKeyIndex: 1,
+			CoveredFields:  cf,
+			Signature:      hostAdditions.FinalRevisionSignature[:],
+		},
+	}
+
+	// Sign our inputs, covering the whole transaction including the
+	// signatures of the final revision.
+	signFunc(&txn, types.CoveredFields{
+		WholeTransaction: true,
+		Signatures:       []uint64{0, 1},
+	})
+
+	// Create the initial (no-op) revision of the new contract and sign it.
+	fc := txn.FileContracts[0]
+	initRevision := types.FileContractRevision{
+		ParentID: txn.FileContractID(0),
+		UnlockConditions: types.UnlockConditions{
+			PublicKeys: []types.UnlockKey{
+				renterKey.PublicKey().UnlockKey(),
+				rev.UnlockConditions.PublicKeys[1],
+			},
+			SignaturesRequired: 2,
+		},
+		FileContract: types.FileContract{
+			RevisionNumber:     1,
+			Filesize:           fc.Filesize,
+			FileMerkleRoot:     fc.FileMerkleRoot,
+			WindowStart:        fc.WindowStart,
+			WindowEnd:          fc.WindowEnd,
+			ValidProofOutputs:  fc.ValidProofOutputs,
+			MissedProofOutputs: fc.MissedProofOutputs,
+			UnlockHash:         fc.UnlockHash,
+		},
+	}
+	h = types.NewHasher()
+	initRevision.EncodeTo(h.E)
+	revSig := renterKey.SignHash(h.Sum())
+	renterRevisionSig := types.TransactionSignature{
+		ParentID:       types.Hash256(initRevision.ParentID),
+		CoveredFields:  types.CoveredFields{FileContractRevisions: []uint64{0}},
+		PublicKeyIndex: 0,
+		Signature:      revSig[:],
+	}
+
+	// Write our signatures.
+	rs := &rhpv3.RPCRenewSignatures{
+		TransactionSignatures: txn.Signatures[2:],
+		RevisionSignature:     renterRevisionSig,
+	}
+	if err := s.WriteResponse(rs); err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	}
+
+	// Read the host's signatures and merge them with our own.
+	var hostSigs rhpv3.RPCRenewSignatures
+	if err := s.ReadResponse(&hostSigs, 4096); err != nil {
+		return rhpv2.ContractRevision{}, nil, err
+	}
+	txn.Signatures = append(txn.Signatures, hostSigs.TransactionSignatures...)
+
+	return rhpv2.ContractRevision{
+		Revision: initRevision,
+		Signatures: [2]types.TransactionSignature{
+			renterRevisionSig,
+			hostSigs.RevisionSignature,
+		},
+	}, append(parents, txn), nil
+}
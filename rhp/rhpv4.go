@@ -0,0 +1,131 @@
+package rhp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/mux"
+)
+
+const (
+	// pricesV4Size is the size of the signed prices, which close the RHP4
+	// settings: six currencies, the tip height, the expiry, and the
+	// signature.
+	pricesV4Size = 6*16 + 8 + 8 + 64
+
+	// maxSettingsV4Size is the maximum size of the RHP4 settings.
+	maxSettingsV4Size = 16 * 1024
+)
+
+// rpcSettingsV4ID is the ID of the RHP4 Settings RPC.
+var rpcSettingsV4ID = types.NewSpecifier("Settings")
+
+// PricesV4 contains the parts of the prices an RHP4 host signs that tell
+// how long the prices are valid.
+type PricesV4 struct {
+	TipHeight  uint64
+	ValidUntil time.Time
+}
+
+// SiamuxV4Addr returns the address of the RHP4 listener of the host, which
+// by convention runs two ports above the RHP2 one.
+func SiamuxV4Addr(netAddress string) (string, error) {
+	host, port, err := net.SplitHostPort(netAddress)
+	if err != nil {
+		return "", err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p > 65533 {
+		return "", errors.New("invalid port")
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(p+2, 10)), nil
+}
+
+// RPCPricesV4 fetches the RHP4 settings of the host and returns the signed
+// prices. Only the prices are decoded, which come last, so that the other
+// fields may change without breaking this. The signature makes sure that
+// they were decoded correctly.
+func RPCPricesV4(ctx context.Context, addr string, hostKey types.PublicKey) (prices PricesV4, err error) {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return PricesV4{}, err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			conn.Close()
+		}
+	}()
+	defer func() {
+		close(done)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	m, err := mux.Dial(conn, hostKey[:])
+	if err != nil {
+		return PricesV4{}, err
+	}
+	defer m.Close()
+	s := m.DialStream()
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	rpcSettingsV4ID.EncodeTo(e)
+	e.Flush()
+	if _, err := s.Write(buf.Bytes()); err != nil {
+		return PricesV4{}, err
+	}
+	resp, err := io.ReadAll(io.LimitReader(s, maxSettingsV4Size))
+	if err != nil {
+		return PricesV4{}, err
+	}
+	return decodePricesV4(resp, hostKey)
+}
+
+// decodePricesV4 decodes the signed prices from the end of the response
+// to the Settings RPC and verifies the signature of the host.
+func decodePricesV4(resp []byte, hostKey types.PublicKey) (PricesV4, error) {
+	if len(resp) == 0 {
+		return PricesV4{}, errors.New("empty response")
+	}
+	if resp[0] != 0 {
+		d := types.NewBufDecoder(resp[1:])
+		d.ReadUint8() // error code
+		if msg := d.ReadString(); d.Err() == nil && msg != "" {
+			return PricesV4{}, errors.New(msg)
+		}
+		return PricesV4{}, errors.New("host returned an error")
+	}
+	if len(resp) < 1+pricesV4Size {
+		return PricesV4{}, errors.New("response too short")
+	}
+	signed := resp[len(resp)-pricesV4Size : len(resp)-64]
+	var sig types.Signature
+	copy(sig[:], resp[len(resp)-64:])
+	h := types.NewHasher()
+	h.E.Write(signed)
+	if !hostKey.VerifyHash(h.Sum(), sig) {
+		return PricesV4{}, errors.New("invalid price signature")
+	}
+
+	d := types.NewBufDecoder(signed[6*16:])
+	prices := PricesV4{
+		TipHeight:  d.ReadUint64(),
+		ValidUntil: d.ReadTime(),
+	}
+	return prices, d.Err()
+}
@@ -0,0 +1,59 @@
+package rhp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestDecodePricesV4(t *testing.T) {
+	sk := types.GeneratePrivateKey()
+	validUntil := time.Unix(time.Now().Add(30*time.Minute).Unix(), 0)
+
+	var signed bytes.Buffer
+	e := types.NewEncoder(&signed)
+	for i := 0; i < 6; i++ {
+		types.V2Currency(types.Siacoins(uint32(i))).EncodeTo(e)
+	}
+	e.WriteUint64(123)
+	e.WriteTime(validUntil)
+	e.Flush()
+	h := types.NewHasher()
+	h.E.Write(signed.Bytes())
+	sig := sk.SignHash(h.Sum())
+
+	// The fields before the prices are skipped.
+	resp := append([]byte{0}, []byte("settings")...)
+	resp = append(resp, signed.Bytes()...)
+	resp = append(resp, sig[:]...)
+
+	prices, err := decodePricesV4(resp, sk.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices.TipHeight != 123 || !prices.ValidUntil.Equal(validUntil) {
+		t.Fatalf("expected height 123 and expiry %v, got %d and %v", validUntil, prices.TipHeight, prices.ValidUntil)
+	}
+
+	if _, err := decodePricesV4(resp, types.GeneratePrivateKey().PublicKey()); err == nil {
+		t.Fatal("expected the signature of another key to be rejected")
+	}
+	if _, err := decodePricesV4(resp[:len(resp)-1], sk.PublicKey()); err == nil {
+		t.Fatal("expected a truncated response to be rejected")
+	}
+}
+
+func TestSiamuxV4Addr(t *testing.T) {
+	addr, err := SiamuxV4Addr("host.example.com:9982")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "host.example.com:9984" {
+		t.Fatalf("expected host.example.com:9984, got %s", addr)
+	}
+	if _, err := SiamuxV4Addr("host.example.com:65535"); err == nil {
+		t.Fatal("expected an out-of-range port to be rejected")
+	}
+}
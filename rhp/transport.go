@@ -3,6 +3,7 @@ package rhp
 import (
 	"context"
 	"net"
+	"time"
 
 	rhpv2 "go.sia.tech/core/rhp/v2"
 	rhpv3 "go.sia.tech/core/rhp/v3"
@@ -15,12 +16,31 @@ func dial(ctx context.Context, hostIP string) (net.Conn, error) {
 	return conn, err
 }
 
+// ConnTimings contains the durations of the steps of establishing a
+// connection to a host.
+type ConnTimings struct {
+	Dial      time.Duration
+	Handshake time.Duration
+}
+
 // WithTransportV2 creates a transport and calls an RHP2 RPC.
 func WithTransportV2(ctx context.Context, hostIP string, hostKey types.PublicKey, fn func(*rhpv2.Transport) error) (err error) {
+	return WithTimedTransportV2(ctx, hostIP, hostKey, nil, fn)
+}
+
+// WithTimedTransportV2 works like WithTransportV2, but also measures how
+// long it takes to connect to the host and to complete the handshake. The
+// timings are ignored if nil.
+func WithTimedTransportV2(ctx context.Context, hostIP string, hostKey types.PublicKey, timings *ConnTimings, fn func(*rhpv2.Transport) error) (err error) {
+	if timings == nil {
+		timings = new(ConnTimings)
+	}
+	start := time.Now()
 	conn, err := dial(ctx, hostIP)
 	if err != nil {
 		return err
 	}
+	timings.Dial = time.Since(start)
 	done := make(chan struct{})
 	go func() {
 		select {
@@ -35,10 +55,12 @@ func WithTransportV2(ctx context.Context, hostIP string, hostKey types.PublicKey
 			err = ctx.Err()
 		}
 	}()
+	start = time.Now()
 	t, err := rhpv2.NewRenterTransport(conn, hostKey)
 	if err != nil {
 		return err
 	}
+	timings.Handshake = time.Since(start)
 	defer t.Close()
 	return fn(t)
 }
@@ -0,0 +1,204 @@
+/*
+ * Upgrades a database created from an older version of init.sql. Each
+ * section adds one change to the schema; run the sections that the
+ * database doesn't have yet, from the top down.
+ */
+
+/* contract compliance */
+ALTER TABLE hdb_hosts_mainnet
+	ADD COLUMN formation_successes INT NOT NULL DEFAULT 0 AFTER price_table,
+	ADD COLUMN duration_violations INT NOT NULL DEFAULT 0 AFTER formation_successes,
+	ADD COLUMN expiry_successes    INT NOT NULL DEFAULT 0 AFTER duration_violations,
+	ADD COLUMN expiry_failures     INT NOT NULL DEFAULT 0 AFTER expiry_successes;
+
+ALTER TABLE hdb_hosts_zen
+	ADD COLUMN formation_successes INT NOT NULL DEFAULT 0 AFTER price_table,
+	ADD COLUMN duration_violations INT NOT NULL DEFAULT 0 AFTER formation_successes,
+	ADD COLUMN expiry_successes    INT NOT NULL DEFAULT 0 AFTER duration_violations,
+	ADD COLUMN expiry_failures     INT NOT NULL DEFAULT 0 AFTER expiry_successes;
+
+/* block height skew */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN height_skew BIGINT NOT NULL DEFAULT 0 AFTER error;
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN height_skew BIGINT NOT NULL DEFAULT 0 AFTER error;
+
+/* invalid signatures */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN invalid_signature BOOL NOT NULL DEFAULT FALSE AFTER height_skew;
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN invalid_signature BOOL NOT NULL DEFAULT FALSE AFTER height_skew;
+
+/* scan and benchmark indexes */
+CREATE INDEX idx_hdb_scans_mainnet ON hdb_scans_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_ran_at ON hdb_scans_mainnet (ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet ON hdb_benchmarks_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_ran_at ON hdb_benchmarks_mainnet (ran_at);
+
+CREATE INDEX idx_hdb_scans_zen ON hdb_scans_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_zen_ran_at ON hdb_scans_zen (ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen ON hdb_benchmarks_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_ran_at ON hdb_benchmarks_zen (ran_at);
+
+/* IPv4 and IPv6 reachability */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN ipv4         TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER invalid_signature,
+	ADD COLUMN ipv4_latency DOUBLE NOT NULL DEFAULT 0 AFTER ipv4,
+	ADD COLUMN ipv6         TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER ipv4_latency,
+	ADD COLUMN ipv6_latency DOUBLE NOT NULL DEFAULT 0 AFTER ipv6;
+
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN ipv4         TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER invalid_signature,
+	ADD COLUMN ipv4_latency DOUBLE NOT NULL DEFAULT 0 AFTER ipv4,
+	ADD COLUMN ipv6         TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER ipv4_latency,
+	ADD COLUMN ipv6_latency DOUBLE NOT NULL DEFAULT 0 AFTER ipv6;
+
+/*
+ * cascading host deletions
+ *
+ * The constraint names are the ones generated by MySQL. If yours differ,
+ * look them up with SHOW CREATE TABLE.
+ */
+ALTER TABLE hdb_scans_mainnet DROP FOREIGN KEY hdb_scans_mainnet_ibfk_1;
+ALTER TABLE hdb_scans_mainnet ADD CONSTRAINT hdb_scans_mainnet_ibfk_1 FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE;
+
+ALTER TABLE hdb_benchmarks_mainnet DROP FOREIGN KEY hdb_benchmarks_mainnet_ibfk_1;
+ALTER TABLE hdb_benchmarks_mainnet ADD CONSTRAINT hdb_benchmarks_mainnet_ibfk_1 FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE;
+
+ALTER TABLE hdb_scans_zen DROP FOREIGN KEY hdb_scans_zen_ibfk_1;
+ALTER TABLE hdb_scans_zen ADD CONSTRAINT hdb_scans_zen_ibfk_1 FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE;
+
+ALTER TABLE hdb_benchmarks_zen DROP FOREIGN KEY hdb_benchmarks_zen_ibfk_1;
+ALTER TABLE hdb_benchmarks_zen ADD CONSTRAINT hdb_benchmarks_zen_ibfk_1 FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE;
+
+/* benchmark traffic */
+ALTER TABLE hdb_benchmarks_mainnet
+	ADD COLUMN uploaded   BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER ttfb,
+	ADD COLUMN downloaded BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER uploaded;
+
+ALTER TABLE hdb_benchmarks_zen
+	ADD COLUMN uploaded   BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER ttfb,
+	ADD COLUMN downloaded BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER uploaded;
+
+/* benchmark and listing opt-outs */
+CREATE TABLE hdb_optouts (
+	network    VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	level      VARCHAR(16) NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* deferred benchmarks */
+ALTER TABLE hdb_hosts_mainnet
+	ADD COLUMN deferred_benchmarks INT NOT NULL DEFAULT 0 AFTER expiry_failures;
+ALTER TABLE hdb_hosts_zen
+	ADD COLUMN deferred_benchmarks INT NOT NULL DEFAULT 0 AFTER expiry_failures;
+
+/* scan timings */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN dial_time      DOUBLE NOT NULL DEFAULT 0 AFTER ipv6_latency,
+	ADD COLUMN handshake_time DOUBLE NOT NULL DEFAULT 0 AFTER dial_time,
+	ADD COLUMN settings_time  DOUBLE NOT NULL DEFAULT 0 AFTER handshake_time;
+
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN dial_time      DOUBLE NOT NULL DEFAULT 0 AFTER ipv6_latency,
+	ADD COLUMN handshake_time DOUBLE NOT NULL DEFAULT 0 AFTER dial_time,
+	ADD COLUMN settings_time  DOUBLE NOT NULL DEFAULT 0 AFTER handshake_time;
+
+/* benchmark budget */
+CREATE TABLE hdb_spending (
+	network VARCHAR(8) NOT NULL,
+	day     BIGINT NOT NULL,
+	amount  TINYBLOB NOT NULL,
+	PRIMARY KEY (network, day)
+);
+
+/* failure classes */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN failure TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER error;
+ALTER TABLE hdb_benchmarks_mainnet
+	ADD COLUMN failure TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER error;
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN failure TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER error;
+ALTER TABLE hdb_benchmarks_zen
+	ADD COLUMN failure TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER error;
+
+/* contract renewals */
+CREATE TABLE hdb_contracts (
+	id           BINARY(32) NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	formed_at    BIGINT NOT NULL,
+	window_start BIGINT UNSIGNED NOT NULL,
+	window_end   BIGINT UNSIGNED NOT NULL,
+	renewed_from BINARY(32),
+	renewed_to   BINARY(32),
+	state        VARCHAR(16) NOT NULL,
+	cost         TINYBLOB NOT NULL,
+	PRIMARY KEY (id),
+	INDEX idx_hdb_contracts (network, public_key)
+);
+
+/* spending per host */
+CREATE TABLE hdb_host_spending (
+	network    VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	contracts  TINYBLOB NOT NULL,
+	funding    TINYBLOB NOT NULL,
+	upload     TINYBLOB NOT NULL,
+	download   TINYBLOB NOT NULL,
+	last_spent BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* allowlist */
+CREATE TABLE hdb_allowlist (
+	public_key BINARY(32) NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
+/* numbered update batches */
+CREATE TABLE hdb_updates (
+	network VARCHAR(8) NOT NULL,
+	acked   BIGINT UNSIGNED NOT NULL,
+	pending LONGBLOB NOT NULL,
+	PRIMARY KEY (network)
+);
+
+/* update consumers */
+CREATE TABLE hdb_consumers (
+	network  VARCHAR(8) NOT NULL,
+	consumer VARCHAR(64) NOT NULL,
+	acked    BIGINT UNSIGNED NOT NULL,
+	state    LONGBLOB NOT NULL,
+	PRIMARY KEY (network, consumer)
+);
+
+/* pending updates index */
+UPDATE hdb_hosts_mainnet SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_scans_mainnet SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_benchmarks_mainnet SET fetched = 0 WHERE modified > fetched;
+CREATE INDEX idx_hdb_hosts_mainnet_fetched ON hdb_hosts_mainnet (fetched);
+CREATE INDEX idx_hdb_scans_mainnet_fetched ON hdb_scans_mainnet (fetched);
+CREATE INDEX idx_hdb_benchmarks_mainnet_fetched ON hdb_benchmarks_mainnet (fetched);
+
+UPDATE hdb_hosts_zen SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_scans_zen SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_benchmarks_zen SET fetched = 0 WHERE modified > fetched;
+CREATE INDEX idx_hdb_hosts_zen_fetched ON hdb_hosts_zen (fetched);
+CREATE INDEX idx_hdb_scans_zen_fetched ON hdb_scans_zen (fetched);
+CREATE INDEX idx_hdb_benchmarks_zen_fetched ON hdb_benchmarks_zen (fetched);
+
+/*
+ * clock skew
+ *
+ * The skew is now measured in seconds from the signed prices rather than
+ * in blocks, so the old values are dropped.
+ */
+ALTER TABLE hdb_scans_mainnet CHANGE COLUMN height_skew clock_skew BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0 AFTER clock_skew;
+UPDATE hdb_scans_mainnet SET clock_skew = 0;
+
+ALTER TABLE hdb_scans_zen CHANGE COLUMN height_skew clock_skew BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0 AFTER clock_skew;
+UPDATE hdb_scans_zen SET clock_skew = 0;
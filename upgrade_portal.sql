@@ -0,0 +1,352 @@
+/*
+ * Upgrades a database created from an older version of init_portal.sql.
+ * Each section adds one change to the schema; run the sections that the
+ * database doesn't have yet, from the top down.
+ */
+
+/* contract compliance */
+ALTER TABLE interactions
+	ADD COLUMN formation_successes INT NOT NULL DEFAULT 0 AFTER last_update,
+	ADD COLUMN duration_violations INT NOT NULL DEFAULT 0 AFTER formation_successes,
+	ADD COLUMN expiry_successes    INT NOT NULL DEFAULT 0 AFTER duration_violations,
+	ADD COLUMN expiry_failures     INT NOT NULL DEFAULT 0 AFTER expiry_successes;
+
+/* block height skew */
+ALTER TABLE scans ADD COLUMN height_skew BIGINT NOT NULL DEFAULT 0 AFTER error;
+
+/* invalid signatures */
+ALTER TABLE scans ADD COLUMN invalid_signature BOOL NOT NULL DEFAULT FALSE AFTER height_skew;
+
+/* community reports */
+CREATE TABLE community_reports (
+	network             VARCHAR(8) NOT NULL,
+	public_key          BINARY(32) NOT NULL,
+	contributor         VARCHAR(16) NOT NULL,
+	day                 BIGINT NOT NULL,
+	formation_successes BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	formation_failures  BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	upload_successes    BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	upload_failures     BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	download_successes  BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	download_failures   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	PRIMARY KEY (network, public_key, contributor, day),
+	INDEX idx_day (day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+);
+
+/* abuse reports and quarantine */
+ALTER TABLE hosts ADD COLUMN quarantined BOOL NOT NULL DEFAULT FALSE AFTER price_table;
+CREATE TABLE host_reports (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BINARY(32) NOT NULL,
+	category    VARCHAR(16) NOT NULL,
+	reason      TEXT NOT NULL,
+	reporter    VARCHAR(16) NOT NULL,
+	created_at  BIGINT NOT NULL,
+	status      VARCHAR(16) NOT NULL DEFAULT 'open',
+	reviewed_at BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	INDEX idx_status (status),
+	INDEX idx_reporter (reporter, created_at),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+);
+
+/* score history */
+CREATE TABLE score_history (
+	id                 BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network            VARCHAR(8) NOT NULL,
+	public_key         BINARY(32) NOT NULL,
+	day                BIGINT NOT NULL,
+	price_score        DOUBLE NOT NULL,
+	storage_score      DOUBLE NOT NULL,
+	collateral_score   DOUBLE NOT NULL,
+	interactions_score DOUBLE NOT NULL,
+	uptime_score       DOUBLE NOT NULL,
+	age_score          DOUBLE NOT NULL,
+	version_score      DOUBLE NOT NULL,
+	latency_score      DOUBLE NOT NULL,
+	benchmarks_score   DOUBLE NOT NULL,
+	contracts_score    DOUBLE NOT NULL,
+	total_score        DOUBLE NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key),
+	UNIQUE INDEX idx_host_day (network, public_key, day)
+);
+
+/* scan, benchmark and price change indexes */
+CREATE INDEX idx_scans_ran_at ON scans (ran_at);
+CREATE INDEX idx_benchmarks ON benchmarks (network, node, public_key, ran_at);
+CREATE INDEX idx_price_changes ON price_changes (network, public_key, changed_at);
+
+/* IPv4 and IPv6 reachability */
+ALTER TABLE scans
+	ADD COLUMN ipv4         TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER invalid_signature,
+	ADD COLUMN ipv4_latency DOUBLE NOT NULL DEFAULT 0 AFTER ipv4,
+	ADD COLUMN ipv6         TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER ipv4_latency,
+	ADD COLUMN ipv6_latency DOUBLE NOT NULL DEFAULT 0 AFTER ipv6;
+
+/* alerts */
+CREATE TABLE alerts (
+	id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	channel      VARCHAR(16) NOT NULL,
+	target       VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL UNIQUE,
+	offline      BOOL NOT NULL,
+	min_score    DOUBLE NOT NULL,
+	price_change DOUBLE NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+);
+
+/*
+ * cascading host deletions
+ *
+ * The constraint names are the ones generated by MySQL. If yours differ,
+ * look them up with SHOW CREATE TABLE.
+ */
+ALTER TABLE interactions DROP FOREIGN KEY interactions_ibfk_1;
+ALTER TABLE interactions ADD CONSTRAINT interactions_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE scans DROP FOREIGN KEY scans_ibfk_1;
+ALTER TABLE scans ADD CONSTRAINT scans_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE benchmarks DROP FOREIGN KEY benchmarks_ibfk_1;
+ALTER TABLE benchmarks ADD CONSTRAINT benchmarks_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE price_changes DROP FOREIGN KEY price_changes_ibfk_1;
+ALTER TABLE price_changes ADD CONSTRAINT price_changes_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE community_reports DROP FOREIGN KEY community_reports_ibfk_1;
+ALTER TABLE community_reports ADD CONSTRAINT community_reports_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE host_reports DROP FOREIGN KEY host_reports_ibfk_1;
+ALTER TABLE host_reports ADD CONSTRAINT host_reports_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE score_history DROP FOREIGN KEY score_history_ibfk_1;
+ALTER TABLE score_history ADD CONSTRAINT score_history_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE alerts DROP FOREIGN KEY alerts_ibfk_1;
+ALTER TABLE alerts ADD CONSTRAINT alerts_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+DELETE FROM locations WHERE public_key NOT IN (SELECT public_key FROM hosts);
+ALTER TABLE locations ADD CONSTRAINT locations_ibfk_1 FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+/* email subscriptions */
+CREATE TABLE subscriptions (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	email        VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL,
+	confirmed    BOOL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key, email),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_subscriptions_email (email)
+);
+
+/* benchmark alerts */
+ALTER TABLE alerts ADD COLUMN benchmark BOOL NOT NULL DEFAULT FALSE AFTER price_change;
+ALTER TABLE alerts ALTER COLUMN benchmark DROP DEFAULT;
+
+/* API keys */
+CREATE TABLE api_keys (
+	id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name         VARCHAR(64) NOT NULL,
+	key_hash     BINARY(32) NOT NULL UNIQUE,
+	tier         VARCHAR(32) NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+
+/* benchmark traffic */
+ALTER TABLE interactions
+	ADD COLUMN ingress       BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER expiry_failures,
+	ADD COLUMN egress        BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER ingress,
+	ADD COLUMN traffic_since BIGINT NOT NULL DEFAULT 0 AFTER egress;
+ALTER TABLE benchmarks
+	ADD COLUMN uploaded   BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER ttfb,
+	ADD COLUMN downloaded BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER uploaded;
+
+/* benchmark and listing opt-outs */
+CREATE TABLE opt_outs (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	level        VARCHAR(16) NOT NULL,
+	requested_at BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* network history */
+CREATE TABLE network_history (
+	id                  BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network             VARCHAR(8) NOT NULL,
+	hour                BIGINT NOT NULL,
+	hosts               INT NOT NULL,
+	online_hosts        INT NOT NULL,
+	accepting_contracts INT NOT NULL,
+	total_storage       BIGINT UNSIGNED NOT NULL,
+	used_storage        BIGINT UNSIGNED NOT NULL,
+	storage_price       TINYBLOB NOT NULL,
+	collateral          TINYBLOB NOT NULL,
+	upload_price        TINYBLOB NOT NULL,
+	download_price      TINYBLOB NOT NULL,
+	upload_speed        DOUBLE NOT NULL,
+	download_speed      DOUBLE NOT NULL,
+	ttfb                BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE INDEX idx_network_hour (network, hour)
+);
+
+/* idempotency keys */
+CREATE TABLE idempotency_keys (
+	id_key       VARCHAR(255) NOT NULL,
+	fingerprint  BINARY(32) NOT NULL,
+	status       INT NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	body         MEDIUMBLOB NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id_key),
+	INDEX idx_idempotency_created_at (created_at)
+);
+
+/* scan timings */
+ALTER TABLE scans
+	ADD COLUMN dial_time      DOUBLE NOT NULL DEFAULT 0 AFTER ipv6_latency,
+	ADD COLUMN handshake_time DOUBLE NOT NULL DEFAULT 0 AFTER dial_time,
+	ADD COLUMN settings_time  DOUBLE NOT NULL DEFAULT 0 AFTER handshake_time;
+
+/* failure classes */
+ALTER TABLE scans ADD COLUMN failure TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER error;
+ALTER TABLE benchmarks ADD COLUMN failure TINYINT UNSIGNED NOT NULL DEFAULT 0 AFTER error;
+
+/* watchlist */
+CREATE TABLE watchlist (
+	key_id       BIGINT UNSIGNED NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BINARY(32) NOT NULL,
+	added_at     BIGINT NOT NULL,
+	last_fetched BIGINT NOT NULL,
+	last_score   DOUBLE NOT NULL,
+	last_online  BOOL NOT NULL,
+	PRIMARY KEY (key_id, network, public_key),
+	FOREIGN KEY (key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+/* contract duration score */
+ALTER TABLE hosts ADD COLUMN duration_score DOUBLE NOT NULL DEFAULT 0 AFTER contracts_score;
+ALTER TABLE interactions ADD COLUMN duration_score DOUBLE NOT NULL DEFAULT 0 AFTER contracts_score;
+ALTER TABLE score_history ADD COLUMN duration_score DOUBLE NOT NULL DEFAULT 0 AFTER contracts_score;
+
+/*
+ * collateral capacity
+ *
+ * The older snapshots get a zero capacity, encoded like the other
+ * currencies.
+ */
+ALTER TABLE network_history ADD COLUMN collateral_capacity TINYBLOB NOT NULL AFTER ttfb;
+UPDATE network_history SET collateral_capacity = X'0000000000000000';
+
+/* traceroute diagnostics */
+CREATE TABLE diagnostics (
+	id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	ran_at     BIGINT NOT NULL,
+	target     VARCHAR(255) NOT NULL,
+	reached    BOOL NOT NULL,
+	hops       TEXT NOT NULL,
+	error      TEXT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_diagnostics (network, public_key, ran_at)
+);
+
+/* ASN of the hosts */
+ALTER TABLE locations ADD COLUMN asn VARCHAR(16) NOT NULL DEFAULT '' AFTER time_zone;
+
+/* host tags */
+ALTER TABLE hosts ADD COLUMN tags VARCHAR(1024) NOT NULL DEFAULT '' AFTER quarantined;
+
+/* settings history */
+CREATE TABLE settings_history (
+	id          BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BINARY(32) NOT NULL,
+	changed_at  BIGINT NOT NULL,
+	settings    BLOB NOT NULL,
+	price_table BLOB NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_settings_history (network, public_key, changed_at)
+);
+
+/* daily uptime */
+CREATE TABLE uptime_daily (
+	network          VARCHAR(8) NOT NULL,
+	public_key       BINARY(32) NOT NULL,
+	day              BIGINT NOT NULL,
+	up_slots         INT NOT NULL,
+	down_slots       INT NOT NULL,
+	incidents        INT NOT NULL,
+	longest_outage   INT NOT NULL,
+	leading_outage   INT NOT NULL,
+	trailing_outage  INT NOT NULL,
+	PRIMARY KEY (network, public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+/* scan and benchmark rollups */
+CREATE TABLE scan_rollups (
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BINARY(32) NOT NULL,
+	span       INT NOT NULL,
+	period     BIGINT NOT NULL,
+	scans      INT NOT NULL,
+	successes  INT NOT NULL,
+	latency    DOUBLE NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_scan_rollups (span, period)
+);
+
+CREATE TABLE benchmark_rollups (
+	network        VARCHAR(8) NOT NULL,
+	node           VARCHAR(8) NOT NULL,
+	public_key     BINARY(32) NOT NULL,
+	span           INT NOT NULL,
+	period         BIGINT NOT NULL,
+	benchmarks     INT NOT NULL,
+	successes      INT NOT NULL,
+	upload_speed   DOUBLE NOT NULL,
+	download_speed DOUBLE NOT NULL,
+	ttfb           DOUBLE NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE,
+	INDEX idx_benchmark_rollups (span, period)
+);
+CREATE INDEX idx_benchmarks_ran_at ON benchmarks (ran_at);
+
+/* update offsets */
+CREATE TABLE update_offsets (
+	node    VARCHAR(8) NOT NULL,
+	standby BOOL NOT NULL,
+	seq     BIGINT UNSIGNED NOT NULL,
+	applied BIGINT NOT NULL,
+	PRIMARY KEY (node, standby)
+);
+
+/*
+ * clock skew
+ *
+ * The skew is now measured in seconds from the signed prices rather than
+ * in blocks, so the old values are dropped.
+ */
+ALTER TABLE scans CHANGE COLUMN height_skew clock_skew BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE scans ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0 AFTER clock_skew;
+UPDATE scans SET clock_skew = 0;
@@ -0,0 +1,315 @@
+/*
+ * Upgrades a database created from an older version of
+ * init_portal_postgres.sql. Each section adds one change to the schema;
+ * run the sections that the database doesn't have yet, from the top down.
+ */
+
+/* score history */
+CREATE TABLE score_history (
+	id                 BIGSERIAL NOT NULL,
+	network            VARCHAR(8) NOT NULL,
+	public_key         BYTEA NOT NULL,
+	day                BIGINT NOT NULL,
+	price_score        DOUBLE PRECISION NOT NULL,
+	storage_score      DOUBLE PRECISION NOT NULL,
+	collateral_score   DOUBLE PRECISION NOT NULL,
+	interactions_score DOUBLE PRECISION NOT NULL,
+	uptime_score       DOUBLE PRECISION NOT NULL,
+	age_score          DOUBLE PRECISION NOT NULL,
+	version_score      DOUBLE PRECISION NOT NULL,
+	latency_score      DOUBLE PRECISION NOT NULL,
+	benchmarks_score   DOUBLE PRECISION NOT NULL,
+	contracts_score    DOUBLE PRECISION NOT NULL,
+	total_score        DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+);
+CREATE UNIQUE INDEX idx_host_day ON score_history (network, public_key, day);
+
+/* scan, benchmark and price change indexes */
+CREATE INDEX idx_scans_ran_at ON scans (ran_at);
+CREATE INDEX idx_benchmarks ON benchmarks (network, node, public_key, ran_at);
+CREATE INDEX idx_price_changes ON price_changes (network, public_key, changed_at);
+
+/* IPv4 and IPv6 reachability */
+ALTER TABLE scans
+	ADD COLUMN ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ADD COLUMN ipv4_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ADD COLUMN ipv6_latency DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+/* alerts */
+CREATE TABLE alerts (
+	id           BIGSERIAL NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BYTEA NOT NULL,
+	channel      VARCHAR(16) NOT NULL,
+	target       VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL UNIQUE,
+	offline      BOOL NOT NULL,
+	min_score    DOUBLE PRECISION NOT NULL,
+	price_change DOUBLE PRECISION NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+);
+
+/*
+ * cascading host deletions
+ *
+ * The constraint names are the ones generated by PostgreSQL. If yours
+ * differ, look them up with \d <table> in psql.
+ */
+ALTER TABLE interactions
+	DROP CONSTRAINT interactions_public_key_fkey,
+	ADD CONSTRAINT interactions_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE scans
+	DROP CONSTRAINT scans_public_key_fkey,
+	ADD CONSTRAINT scans_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE benchmarks
+	DROP CONSTRAINT benchmarks_public_key_fkey,
+	ADD CONSTRAINT benchmarks_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE price_changes
+	DROP CONSTRAINT price_changes_public_key_fkey,
+	ADD CONSTRAINT price_changes_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE community_reports
+	DROP CONSTRAINT community_reports_public_key_fkey,
+	ADD CONSTRAINT community_reports_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE host_reports
+	DROP CONSTRAINT host_reports_public_key_fkey,
+	ADD CONSTRAINT host_reports_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE score_history
+	DROP CONSTRAINT score_history_public_key_fkey,
+	ADD CONSTRAINT score_history_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+ALTER TABLE alerts
+	DROP CONSTRAINT alerts_public_key_fkey,
+	ADD CONSTRAINT alerts_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+DELETE FROM locations WHERE public_key NOT IN (SELECT public_key FROM hosts);
+ALTER TABLE locations
+	ADD CONSTRAINT locations_public_key_fkey FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE;
+
+/* email subscriptions */
+CREATE TABLE subscriptions (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BYTEA NOT NULL,
+	email        VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL,
+	confirmed    BOOL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key, email),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_subscriptions_email ON subscriptions (email);
+
+/* benchmark alerts */
+ALTER TABLE alerts ADD COLUMN benchmark BOOL NOT NULL DEFAULT FALSE;
+ALTER TABLE alerts ALTER COLUMN benchmark DROP DEFAULT;
+
+/* API keys */
+CREATE TABLE api_keys (
+	id           BIGSERIAL NOT NULL,
+	name         VARCHAR(64) NOT NULL,
+	key_hash     BYTEA NOT NULL UNIQUE,
+	tier         VARCHAR(32) NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+
+/* benchmark traffic */
+ALTER TABLE interactions
+	ADD COLUMN ingress       BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN egress        BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN traffic_since BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE benchmarks
+	ADD COLUMN uploaded   BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN downloaded BIGINT NOT NULL DEFAULT 0;
+
+/* benchmark and listing opt-outs */
+CREATE TABLE opt_outs (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BYTEA NOT NULL,
+	level        VARCHAR(16) NOT NULL,
+	requested_at BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* network history */
+CREATE TABLE network_history (
+	id                  BIGSERIAL NOT NULL,
+	network             VARCHAR(8) NOT NULL,
+	hour                BIGINT NOT NULL,
+	hosts               INT NOT NULL,
+	online_hosts        INT NOT NULL,
+	accepting_contracts INT NOT NULL,
+	total_storage       BIGINT NOT NULL,
+	used_storage        BIGINT NOT NULL,
+	storage_price       BYTEA NOT NULL,
+	collateral          BYTEA NOT NULL,
+	upload_price        BYTEA NOT NULL,
+	download_price      BYTEA NOT NULL,
+	upload_speed        DOUBLE PRECISION NOT NULL,
+	download_speed      DOUBLE PRECISION NOT NULL,
+	ttfb                BIGINT NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_network_hour ON network_history (network, hour);
+
+/* idempotency keys */
+CREATE TABLE idempotency_keys (
+	id_key       VARCHAR(255) NOT NULL,
+	fingerprint  BYTEA NOT NULL,
+	status       INT NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	body         BYTEA NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (id_key)
+);
+CREATE INDEX idx_idempotency_created_at ON idempotency_keys (created_at);
+
+/* scan timings */
+ALTER TABLE scans
+	ADD COLUMN dial_time      DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN handshake_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN settings_time  DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+/* failure classes */
+ALTER TABLE scans ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE benchmarks ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+
+/* watchlist */
+CREATE TABLE watchlist (
+	key_id       BIGINT NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BYTEA NOT NULL,
+	added_at     BIGINT NOT NULL,
+	last_fetched BIGINT NOT NULL,
+	last_score   DOUBLE PRECISION NOT NULL,
+	last_online  BOOL NOT NULL,
+	PRIMARY KEY (key_id, network, public_key),
+	FOREIGN KEY (key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+/* contract duration score */
+ALTER TABLE hosts ADD COLUMN duration_score DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE interactions ADD COLUMN duration_score DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE score_history ADD COLUMN duration_score DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+/*
+ * collateral capacity
+ *
+ * The older snapshots get a zero capacity, encoded like the other
+ * currencies.
+ */
+ALTER TABLE network_history ADD COLUMN collateral_capacity BYTEA NOT NULL DEFAULT '\x0000000000000000';
+ALTER TABLE network_history ALTER COLUMN collateral_capacity DROP DEFAULT;
+
+/* traceroute diagnostics */
+CREATE TABLE diagnostics (
+	id         BIGSERIAL NOT NULL,
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BYTEA NOT NULL,
+	ran_at     BIGINT NOT NULL,
+	target     VARCHAR(255) NOT NULL,
+	reached    BOOL NOT NULL,
+	hops       TEXT NOT NULL,
+	error      TEXT NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+/* ASN of the hosts */
+ALTER TABLE locations ADD COLUMN asn VARCHAR(16) NOT NULL DEFAULT '';
+
+/* host tags */
+ALTER TABLE hosts ADD COLUMN tags VARCHAR(1024) NOT NULL DEFAULT '';
+
+/* settings history */
+CREATE TABLE settings_history (
+	id          BIGSERIAL NOT NULL,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BYTEA NOT NULL,
+	changed_at  BIGINT NOT NULL,
+	settings    BYTEA NOT NULL,
+	price_table BYTEA NOT NULL,
+	PRIMARY KEY (id),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_settings_history ON settings_history (network, public_key, changed_at);
+
+/* daily uptime */
+CREATE TABLE uptime_daily (
+	network          VARCHAR(8) NOT NULL,
+	public_key       BYTEA NOT NULL,
+	day              BIGINT NOT NULL,
+	up_slots         INT NOT NULL,
+	down_slots       INT NOT NULL,
+	incidents        INT NOT NULL,
+	longest_outage   INT NOT NULL,
+	leading_outage   INT NOT NULL,
+	trailing_outage  INT NOT NULL,
+	PRIMARY KEY (network, public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+/* scan and benchmark rollups */
+CREATE TABLE scan_rollups (
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BYTEA NOT NULL,
+	span       INT NOT NULL,
+	period     BIGINT NOT NULL,
+	scans      INT NOT NULL,
+	successes  INT NOT NULL,
+	latency    DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_scan_rollups ON scan_rollups (span, period);
+
+CREATE TABLE benchmark_rollups (
+	network        VARCHAR(8) NOT NULL,
+	node           VARCHAR(8) NOT NULL,
+	public_key     BYTEA NOT NULL,
+	span           INT NOT NULL,
+	period         BIGINT NOT NULL,
+	benchmarks     INT NOT NULL,
+	successes      INT NOT NULL,
+	upload_speed   DOUBLE PRECISION NOT NULL,
+	download_speed DOUBLE PRECISION NOT NULL,
+	ttfb           DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_benchmark_rollups ON benchmark_rollups (span, period);
+CREATE INDEX idx_benchmarks_ran_at ON benchmarks (ran_at);
+
+/* update offsets */
+CREATE TABLE update_offsets (
+	node    VARCHAR(8) NOT NULL,
+	standby BOOL NOT NULL,
+	seq     BIGINT NOT NULL,
+	applied BIGINT NOT NULL,
+	PRIMARY KEY (node, standby)
+);
+
+/* diagnostics index */
+CREATE INDEX idx_diagnostics ON diagnostics (network, public_key, ran_at);
+
+/*
+ * clock skew
+ *
+ * The skew is now measured in seconds from the signed prices rather than
+ * in blocks, so the old values are dropped.
+ */
+ALTER TABLE scans RENAME COLUMN height_skew TO clock_skew;
+ALTER TABLE scans ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0;
+UPDATE scans SET clock_skew = 0;
@@ -0,0 +1,546 @@
+/*
+ * Upgrades a database created from an older version of
+ * init_portal_sqlite.sql. Each section adds one change to the schema; run
+ * the sections that the database doesn't have yet, from the top down.
+ */
+
+/* score history */
+CREATE TABLE score_history (
+	id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+	network            VARCHAR(8) NOT NULL,
+	public_key         BLOB NOT NULL,
+	day                BIGINT NOT NULL,
+	price_score        REAL NOT NULL,
+	storage_score      REAL NOT NULL,
+	collateral_score   REAL NOT NULL,
+	interactions_score REAL NOT NULL,
+	uptime_score       REAL NOT NULL,
+	age_score          REAL NOT NULL,
+	version_score      REAL NOT NULL,
+	latency_score      REAL NOT NULL,
+	benchmarks_score   REAL NOT NULL,
+	contracts_score    REAL NOT NULL,
+	total_score        REAL NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+);
+CREATE UNIQUE INDEX idx_host_day ON score_history (network, public_key, day);
+
+/* scan, benchmark and price change indexes */
+CREATE INDEX idx_scans_ran_at ON scans (ran_at);
+CREATE INDEX idx_benchmarks ON benchmarks (network, node, public_key, ran_at);
+CREATE INDEX idx_price_changes ON price_changes (network, public_key, changed_at);
+
+/* IPv4 and IPv6 reachability */
+ALTER TABLE scans ADD COLUMN ipv4 SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE scans ADD COLUMN ipv4_latency REAL NOT NULL DEFAULT 0;
+ALTER TABLE scans ADD COLUMN ipv6 SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE scans ADD COLUMN ipv6_latency REAL NOT NULL DEFAULT 0;
+
+/* alerts */
+CREATE TABLE alerts (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	channel      VARCHAR(16) NOT NULL,
+	target       VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL UNIQUE,
+	offline      BOOL NOT NULL,
+	min_score    REAL NOT NULL,
+	price_change REAL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key)
+);
+
+/*
+ * cascading host deletions
+ *
+ * SQLite can't change the constraints of a table, so the tables are
+ * rebuilt with the foreign keys turned off.
+ */
+PRAGMA foreign_keys = OFF;
+BEGIN;
+
+CREATE TABLE interactions_new (
+	network      VARCHAR(8) NOT NULL,
+	node         VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	uptime       BIGINT NOT NULL,
+	downtime     BIGINT NOT NULL,
+	last_seen    BIGINT NOT NULL,
+	active_hosts INT NOT NULL,
+	price_score        REAL NOT NULL,
+	storage_score      REAL NOT NULL,
+	collateral_score   REAL NOT NULL,
+	interactions_score REAL NOT NULL,
+	uptime_score       REAL NOT NULL,
+	age_score          REAL NOT NULL,
+	version_score      REAL NOT NULL,
+	latency_score      REAL NOT NULL,
+	benchmarks_score   REAL NOT NULL,
+	contracts_score    REAL NOT NULL,
+	total_score        REAL NOT NULL,
+	historic_successful_interactions REAL NOT NULL,
+	historic_failed_interactions     REAL NOT NULL,
+	recent_successful_interactions   REAL NOT NULL,
+	recent_failed_interactions       REAL NOT NULL,
+	last_update                      BIGINT NOT NULL,
+	formation_successes INT NOT NULL DEFAULT 0,
+	duration_violations INT NOT NULL DEFAULT 0,
+	expiry_successes    INT NOT NULL DEFAULT 0,
+	expiry_failures     INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (network, node, public_key),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO interactions_new (
+	network, node, public_key, uptime, downtime, last_seen, active_hosts,
+	price_score, storage_score, collateral_score, interactions_score,
+	uptime_score, age_score, version_score, latency_score,
+	benchmarks_score, contracts_score, total_score,
+	historic_successful_interactions, historic_failed_interactions,
+	recent_successful_interactions, recent_failed_interactions,
+	last_update, formation_successes, duration_violations,
+	expiry_successes, expiry_failures
+)
+SELECT
+	network, node, public_key, uptime, downtime, last_seen, active_hosts,
+	price_score, storage_score, collateral_score, interactions_score,
+	uptime_score, age_score, version_score, latency_score,
+	benchmarks_score, contracts_score, total_score,
+	historic_successful_interactions, historic_failed_interactions,
+	recent_successful_interactions, recent_failed_interactions,
+	last_update, formation_successes, duration_violations,
+	expiry_successes, expiry_failures
+FROM interactions;
+DROP TABLE interactions;
+ALTER TABLE interactions_new RENAME TO interactions;
+CREATE INDEX idx_interactions ON interactions (network, public_key);
+
+CREATE TABLE scans_new (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	network      VARCHAR(8) NOT NULL,
+	node         VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      REAL NOT NULL,
+	error        TEXT NOT NULL,
+	height_skew  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency REAL NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency REAL NOT NULL DEFAULT 0,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO scans_new (
+	id, network, node, public_key, ran_at, success, latency, error,
+	height_skew, invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency
+)
+SELECT
+	id, network, node, public_key, ran_at, success, latency, error,
+	height_skew, invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency
+FROM scans;
+DROP TABLE scans;
+ALTER TABLE scans_new RENAME TO scans;
+CREATE INDEX idx_scans ON scans (network, node, public_key, ran_at);
+CREATE INDEX idx_scans_ran_at ON scans (ran_at);
+
+CREATE TABLE benchmarks_new (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	network        VARCHAR(8) NOT NULL,
+	node           VARCHAR(8) NOT NULL,
+	public_key     BLOB NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	error          TEXT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO benchmarks_new (
+	id, network, node, public_key, ran_at, success, upload_speed,
+	download_speed, ttfb, error
+)
+SELECT
+	id, network, node, public_key, ran_at, success, upload_speed,
+	download_speed, ttfb, error
+FROM benchmarks;
+DROP TABLE benchmarks;
+ALTER TABLE benchmarks_new RENAME TO benchmarks;
+CREATE INDEX idx_benchmarks ON benchmarks (network, node, public_key, ran_at);
+
+CREATE TABLE price_changes_new (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	network           VARCHAR(8) NOT NULL,
+	public_key        BLOB NOT NULL,
+	changed_at        BIGINT NOT NULL,
+	remaining_storage BIGINT NOT NULL,
+	total_storage     BIGINT NOT NULL,
+	collateral        BLOB NOT NULL,
+	storage_price     BLOB NOT NULL,
+	upload_price      BLOB NOT NULL,
+	download_price    BLOB NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO price_changes_new (
+	id, network, public_key, changed_at, remaining_storage, total_storage,
+	collateral, storage_price, upload_price, download_price
+)
+SELECT
+	id, network, public_key, changed_at, remaining_storage, total_storage,
+	collateral, storage_price, upload_price, download_price
+FROM price_changes;
+DROP TABLE price_changes;
+ALTER TABLE price_changes_new RENAME TO price_changes;
+CREATE INDEX idx_price_changes ON price_changes (network, public_key, changed_at);
+
+CREATE TABLE locations_new (
+	network    VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	ip         TEXT NOT NULL,
+	host_name  TEXT NOT NULL,
+	city       TEXT NOT NULL,
+	region     TEXT NOT NULL,
+	country    TEXT NOT NULL,
+	loc        TEXT NOT NULL,
+	isp        TEXT NOT NULL,
+	zip        TEXT NOT NULL,
+	time_zone  TEXT NOT NULL,
+	fetched_at BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO locations_new (
+	network, public_key, ip, host_name, city, region, country, loc, isp,
+	zip, time_zone, fetched_at
+)
+SELECT
+	network, public_key, ip, host_name, city, region, country, loc, isp,
+	zip, time_zone, fetched_at
+FROM locations WHERE public_key IN (SELECT public_key FROM hosts);
+DROP TABLE locations;
+ALTER TABLE locations_new RENAME TO locations;
+
+CREATE TABLE community_reports_new (
+	network             VARCHAR(8) NOT NULL,
+	public_key          BLOB NOT NULL,
+	contributor         VARCHAR(16) NOT NULL,
+	day                 BIGINT NOT NULL,
+	formation_successes BIGINT NOT NULL DEFAULT 0,
+	formation_failures  BIGINT NOT NULL DEFAULT 0,
+	upload_successes    BIGINT NOT NULL DEFAULT 0,
+	upload_failures     BIGINT NOT NULL DEFAULT 0,
+	download_successes  BIGINT NOT NULL DEFAULT 0,
+	download_failures   BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (network, public_key, contributor, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO community_reports_new (
+	network, public_key, contributor, day, formation_successes,
+	formation_failures, upload_successes, upload_failures,
+	download_successes, download_failures
+)
+SELECT
+	network, public_key, contributor, day, formation_successes,
+	formation_failures, upload_successes, upload_failures,
+	download_successes, download_failures
+FROM community_reports;
+DROP TABLE community_reports;
+ALTER TABLE community_reports_new RENAME TO community_reports;
+CREATE INDEX idx_day ON community_reports (day);
+
+CREATE TABLE host_reports_new (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BLOB NOT NULL,
+	category    VARCHAR(16) NOT NULL,
+	reason      TEXT NOT NULL,
+	reporter    VARCHAR(16) NOT NULL,
+	created_at  BIGINT NOT NULL,
+	status      VARCHAR(16) NOT NULL DEFAULT 'open',
+	reviewed_at BIGINT NOT NULL DEFAULT 0,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO host_reports_new (
+	id, network, public_key, category, reason, reporter, created_at,
+	status, reviewed_at
+)
+SELECT
+	id, network, public_key, category, reason, reporter, created_at,
+	status, reviewed_at
+FROM host_reports;
+DROP TABLE host_reports;
+ALTER TABLE host_reports_new RENAME TO host_reports;
+CREATE INDEX idx_status ON host_reports (status);
+CREATE INDEX idx_reporter ON host_reports (reporter, created_at);
+
+CREATE TABLE score_history_new (
+	id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+	network            VARCHAR(8) NOT NULL,
+	public_key         BLOB NOT NULL,
+	day                BIGINT NOT NULL,
+	price_score        REAL NOT NULL,
+	storage_score      REAL NOT NULL,
+	collateral_score   REAL NOT NULL,
+	interactions_score REAL NOT NULL,
+	uptime_score       REAL NOT NULL,
+	age_score          REAL NOT NULL,
+	version_score      REAL NOT NULL,
+	latency_score      REAL NOT NULL,
+	benchmarks_score   REAL NOT NULL,
+	contracts_score    REAL NOT NULL,
+	total_score        REAL NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO score_history_new (
+	id, network, public_key, day, price_score, storage_score,
+	collateral_score, interactions_score, uptime_score, age_score,
+	version_score, latency_score, benchmarks_score, contracts_score,
+	total_score
+)
+SELECT
+	id, network, public_key, day, price_score, storage_score,
+	collateral_score, interactions_score, uptime_score, age_score,
+	version_score, latency_score, benchmarks_score, contracts_score,
+	total_score
+FROM score_history;
+DROP TABLE score_history;
+ALTER TABLE score_history_new RENAME TO score_history;
+CREATE UNIQUE INDEX idx_host_day ON score_history (network, public_key, day);
+
+CREATE TABLE alerts_new (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	channel      VARCHAR(16) NOT NULL,
+	target       VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL UNIQUE,
+	offline      BOOL NOT NULL,
+	min_score    REAL NOT NULL,
+	price_change REAL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+INSERT INTO alerts_new (
+	id, network, public_key, channel, target, secret, offline, min_score,
+	price_change, created_at
+)
+SELECT
+	id, network, public_key, channel, target, secret, offline, min_score,
+	price_change, created_at
+FROM alerts;
+DROP TABLE alerts;
+ALTER TABLE alerts_new RENAME TO alerts;
+
+PRAGMA foreign_key_check;
+COMMIT;
+PRAGMA foreign_keys = ON;
+
+/* email subscriptions */
+CREATE TABLE subscriptions (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	email        VARCHAR(255) NOT NULL,
+	secret       VARCHAR(32) NOT NULL,
+	confirmed    BOOL NOT NULL,
+	created_at   BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key, email),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_subscriptions_email ON subscriptions (email);
+
+/* benchmark alerts */
+ALTER TABLE alerts ADD COLUMN benchmark BOOL NOT NULL DEFAULT FALSE;
+
+/* API keys */
+CREATE TABLE api_keys (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	name         VARCHAR(64) NOT NULL,
+	key_hash     BLOB NOT NULL UNIQUE,
+	tier         VARCHAR(32) NOT NULL,
+	created_at   BIGINT NOT NULL
+);
+
+/* benchmark traffic */
+ALTER TABLE interactions ADD COLUMN ingress BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE interactions ADD COLUMN egress BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE interactions ADD COLUMN traffic_since BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE benchmarks ADD COLUMN uploaded BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE benchmarks ADD COLUMN downloaded BIGINT NOT NULL DEFAULT 0;
+
+/* benchmark and listing opt-outs */
+CREATE TABLE opt_outs (
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	level        VARCHAR(16) NOT NULL,
+	requested_at BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* network history */
+CREATE TABLE network_history (
+	id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+	network             VARCHAR(8) NOT NULL,
+	hour                BIGINT NOT NULL,
+	hosts               INT NOT NULL,
+	online_hosts        INT NOT NULL,
+	accepting_contracts INT NOT NULL,
+	total_storage       BIGINT NOT NULL,
+	used_storage        BIGINT NOT NULL,
+	storage_price       BLOB NOT NULL,
+	collateral          BLOB NOT NULL,
+	upload_price        BLOB NOT NULL,
+	download_price      BLOB NOT NULL,
+	upload_speed        REAL NOT NULL,
+	download_speed      REAL NOT NULL,
+	ttfb                BIGINT NOT NULL
+);
+CREATE UNIQUE INDEX idx_network_hour ON network_history (network, hour);
+
+/* idempotency keys */
+CREATE TABLE idempotency_keys (
+	id_key       VARCHAR(255) PRIMARY KEY,
+	fingerprint  BLOB NOT NULL,
+	status       INT NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	body         BLOB NOT NULL,
+	created_at   BIGINT NOT NULL
+);
+CREATE INDEX idx_idempotency_created_at ON idempotency_keys (created_at);
+
+/* scan timings */
+ALTER TABLE scans ADD COLUMN dial_time REAL NOT NULL DEFAULT 0;
+ALTER TABLE scans ADD COLUMN handshake_time REAL NOT NULL DEFAULT 0;
+ALTER TABLE scans ADD COLUMN settings_time REAL NOT NULL DEFAULT 0;
+
+/* failure classes */
+ALTER TABLE scans ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE benchmarks ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+
+/* watchlist */
+CREATE TABLE watchlist (
+	key_id       INTEGER NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	added_at     BIGINT NOT NULL,
+	last_fetched BIGINT NOT NULL,
+	last_score   REAL NOT NULL,
+	last_online  BOOL NOT NULL,
+	PRIMARY KEY (key_id, network, public_key),
+	FOREIGN KEY (key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+);
+
+/* contract duration score */
+ALTER TABLE hosts ADD COLUMN duration_score REAL NOT NULL DEFAULT 0;
+ALTER TABLE interactions ADD COLUMN duration_score REAL NOT NULL DEFAULT 0;
+ALTER TABLE score_history ADD COLUMN duration_score REAL NOT NULL DEFAULT 0;
+
+/*
+ * collateral capacity
+ *
+ * The older snapshots get a zero capacity, encoded like the other
+ * currencies.
+ */
+ALTER TABLE network_history ADD COLUMN collateral_capacity BLOB NOT NULL DEFAULT X'0000000000000000';
+
+/* traceroute diagnostics */
+CREATE TABLE diagnostics (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	ran_at     BIGINT NOT NULL,
+	target     VARCHAR(255) NOT NULL,
+	reached    BOOL NOT NULL,
+	hops       TEXT NOT NULL,
+	error      TEXT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+/* ASN of the hosts */
+ALTER TABLE locations ADD COLUMN asn VARCHAR(16) NOT NULL DEFAULT '';
+
+/* host tags */
+ALTER TABLE hosts ADD COLUMN tags VARCHAR(1024) NOT NULL DEFAULT '';
+
+/* settings history */
+CREATE TABLE settings_history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	network     VARCHAR(8) NOT NULL,
+	public_key  BLOB NOT NULL,
+	changed_at  BIGINT NOT NULL,
+	settings    BLOB NOT NULL,
+	price_table BLOB NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_settings_history ON settings_history (network, public_key, changed_at);
+
+/* daily uptime */
+CREATE TABLE uptime_daily (
+	network          VARCHAR(8) NOT NULL,
+	public_key       BLOB NOT NULL,
+	day              BIGINT NOT NULL,
+	up_slots         INT NOT NULL,
+	down_slots       INT NOT NULL,
+	incidents        INT NOT NULL,
+	longest_outage   INT NOT NULL,
+	leading_outage   INT NOT NULL,
+	trailing_outage  INT NOT NULL,
+	PRIMARY KEY (network, public_key, day),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+
+/* scan and benchmark rollups */
+CREATE TABLE scan_rollups (
+	network    VARCHAR(8) NOT NULL,
+	node       VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	span       INT NOT NULL,
+	period     BIGINT NOT NULL,
+	scans      INT NOT NULL,
+	successes  INT NOT NULL,
+	latency    REAL NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_scan_rollups ON scan_rollups (span, period);
+
+CREATE TABLE benchmark_rollups (
+	network        VARCHAR(8) NOT NULL,
+	node           VARCHAR(8) NOT NULL,
+	public_key     BLOB NOT NULL,
+	span           INT NOT NULL,
+	period         BIGINT NOT NULL,
+	benchmarks     INT NOT NULL,
+	successes      INT NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	PRIMARY KEY (network, node, public_key, span, period),
+	FOREIGN KEY (public_key) REFERENCES hosts(public_key) ON DELETE CASCADE
+);
+CREATE INDEX idx_benchmark_rollups ON benchmark_rollups (span, period);
+CREATE INDEX idx_benchmarks_ran_at ON benchmarks (ran_at);
+
+/* update offsets */
+CREATE TABLE update_offsets (
+	node    VARCHAR(8) NOT NULL,
+	standby BOOL NOT NULL,
+	seq     BIGINT NOT NULL,
+	applied BIGINT NOT NULL,
+	PRIMARY KEY (node, standby)
+);
+
+/* diagnostics index */
+CREATE INDEX idx_diagnostics ON diagnostics (network, public_key, ran_at);
+
+/*
+ * clock skew
+ *
+ * The skew is now measured in seconds from the signed prices rather than
+ * in blocks, so the old values are dropped.
+ */
+ALTER TABLE scans RENAME COLUMN height_skew TO clock_skew;
+ALTER TABLE scans ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0;
+UPDATE scans SET clock_skew = 0;
@@ -0,0 +1,183 @@
+/*
+ * Upgrades a database created from an older version of init_postgres.sql.
+ * Each section adds one change to the schema; run the sections that the
+ * database doesn't have yet, from the top down.
+ */
+
+/* scan and benchmark indexes */
+CREATE INDEX idx_hdb_scans_mainnet ON hdb_scans_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_ran_at ON hdb_scans_mainnet (ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet ON hdb_benchmarks_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_ran_at ON hdb_benchmarks_mainnet (ran_at);
+
+CREATE INDEX idx_hdb_scans_zen ON hdb_scans_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_zen_ran_at ON hdb_scans_zen (ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen ON hdb_benchmarks_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_ran_at ON hdb_benchmarks_zen (ran_at);
+
+/* IPv4 and IPv6 reachability */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ADD COLUMN ipv4_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ADD COLUMN ipv6_latency DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ADD COLUMN ipv4_latency DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ADD COLUMN ipv6_latency DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+/*
+ * cascading host deletions
+ *
+ * The constraint names are the ones generated by PostgreSQL. If yours
+ * differ, look them up with \d <table> in psql.
+ */
+ALTER TABLE hdb_scans_mainnet
+	DROP CONSTRAINT hdb_scans_mainnet_public_key_fkey,
+	ADD CONSTRAINT hdb_scans_mainnet_public_key_fkey FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE;
+
+ALTER TABLE hdb_benchmarks_mainnet
+	DROP CONSTRAINT hdb_benchmarks_mainnet_public_key_fkey,
+	ADD CONSTRAINT hdb_benchmarks_mainnet_public_key_fkey FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE;
+
+ALTER TABLE hdb_scans_zen
+	DROP CONSTRAINT hdb_scans_zen_public_key_fkey,
+	ADD CONSTRAINT hdb_scans_zen_public_key_fkey FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE;
+
+ALTER TABLE hdb_benchmarks_zen
+	DROP CONSTRAINT hdb_benchmarks_zen_public_key_fkey,
+	ADD CONSTRAINT hdb_benchmarks_zen_public_key_fkey FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE;
+
+/* benchmark traffic */
+ALTER TABLE hdb_benchmarks_mainnet
+	ADD COLUMN uploaded   BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN downloaded BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE hdb_benchmarks_zen
+	ADD COLUMN uploaded   BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN downloaded BIGINT NOT NULL DEFAULT 0;
+
+/* benchmark and listing opt-outs */
+CREATE TABLE hdb_optouts (
+	network    VARCHAR(8) NOT NULL,
+	public_key BYTEA NOT NULL,
+	level      VARCHAR(16) NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* deferred benchmarks */
+ALTER TABLE hdb_hosts_mainnet
+	ADD COLUMN deferred_benchmarks INT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_hosts_zen
+	ADD COLUMN deferred_benchmarks INT NOT NULL DEFAULT 0;
+
+/* scan timings */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN dial_time      DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN handshake_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN settings_time  DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN dial_time      DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN handshake_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN settings_time  DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+/* benchmark budget */
+CREATE TABLE hdb_spending (
+	network VARCHAR(8) NOT NULL,
+	day     BIGINT NOT NULL,
+	amount  BYTEA NOT NULL,
+	PRIMARY KEY (network, day)
+);
+
+/* failure classes */
+ALTER TABLE hdb_scans_mainnet
+	ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_benchmarks_mainnet
+	ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen
+	ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_benchmarks_zen
+	ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+
+/* contract renewals */
+CREATE TABLE hdb_contracts (
+	id           BYTEA NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BYTEA NOT NULL,
+	formed_at    BIGINT NOT NULL,
+	window_start BIGINT NOT NULL,
+	window_end   BIGINT NOT NULL,
+	renewed_from BYTEA,
+	renewed_to   BYTEA,
+	state        VARCHAR(16) NOT NULL,
+	cost         BYTEA NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX idx_hdb_contracts ON hdb_contracts (network, public_key);
+
+/* spending per host */
+CREATE TABLE hdb_host_spending (
+	network    VARCHAR(8) NOT NULL,
+	public_key BYTEA NOT NULL,
+	contracts  BYTEA NOT NULL,
+	funding    BYTEA NOT NULL,
+	upload     BYTEA NOT NULL,
+	download   BYTEA NOT NULL,
+	last_spent BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* allowlist */
+CREATE TABLE hdb_allowlist (
+	public_key BYTEA NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
+/* numbered update batches */
+CREATE TABLE hdb_updates (
+	network VARCHAR(8) NOT NULL,
+	acked   BIGINT NOT NULL,
+	pending BYTEA NOT NULL,
+	PRIMARY KEY (network)
+);
+
+/* update consumers */
+CREATE TABLE hdb_consumers (
+	network  VARCHAR(8) NOT NULL,
+	consumer VARCHAR(64) NOT NULL,
+	acked    BIGINT NOT NULL,
+	state    BYTEA NOT NULL,
+	PRIMARY KEY (network, consumer)
+);
+
+/* pending updates index */
+UPDATE hdb_hosts_mainnet SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_scans_mainnet SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_benchmarks_mainnet SET fetched = 0 WHERE modified > fetched;
+CREATE INDEX idx_hdb_hosts_mainnet_fetched ON hdb_hosts_mainnet (fetched);
+CREATE INDEX idx_hdb_scans_mainnet_fetched ON hdb_scans_mainnet (fetched);
+CREATE INDEX idx_hdb_benchmarks_mainnet_fetched ON hdb_benchmarks_mainnet (fetched);
+
+UPDATE hdb_hosts_zen SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_scans_zen SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_benchmarks_zen SET fetched = 0 WHERE modified > fetched;
+CREATE INDEX idx_hdb_hosts_zen_fetched ON hdb_hosts_zen (fetched);
+CREATE INDEX idx_hdb_scans_zen_fetched ON hdb_scans_zen (fetched);
+CREATE INDEX idx_hdb_benchmarks_zen_fetched ON hdb_benchmarks_zen (fetched);
+
+/*
+ * clock skew
+ *
+ * The skew is now measured in seconds from the signed prices rather than
+ * in blocks, so the old values are dropped.
+ */
+ALTER TABLE hdb_scans_mainnet RENAME COLUMN height_skew TO clock_skew;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0;
+UPDATE hdb_scans_mainnet SET clock_skew = 0;
+
+ALTER TABLE hdb_scans_zen RENAME COLUMN height_skew TO clock_skew;
+ALTER TABLE hdb_scans_zen ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0;
+UPDATE hdb_scans_zen SET clock_skew = 0;
@@ -0,0 +1,279 @@
+/*
+ * Upgrades a database created from an older version of init_sqlite.sql. Each
+ * section adds one change to the schema; run the sections that the
+ * database doesn't have yet, from the top down.
+ */
+
+/* scan and benchmark indexes */
+CREATE INDEX idx_hdb_scans_mainnet ON hdb_scans_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_ran_at ON hdb_scans_mainnet (ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet ON hdb_benchmarks_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_ran_at ON hdb_benchmarks_mainnet (ran_at);
+
+CREATE INDEX idx_hdb_scans_zen ON hdb_scans_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_zen_ran_at ON hdb_scans_zen (ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen ON hdb_benchmarks_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_ran_at ON hdb_benchmarks_zen (ran_at);
+
+/* IPv4 and IPv6 reachability */
+ALTER TABLE hdb_scans_mainnet ADD COLUMN ipv4 SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN ipv4_latency REAL NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN ipv6 SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN ipv6_latency REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE hdb_scans_zen ADD COLUMN ipv4 SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN ipv4_latency REAL NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN ipv6 SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN ipv6_latency REAL NOT NULL DEFAULT 0;
+
+/*
+ * cascading host deletions
+ *
+ * SQLite can't change the constraints of a table, so the tables are
+ * rebuilt with the foreign keys turned off.
+ */
+PRAGMA foreign_keys = OFF;
+BEGIN;
+
+CREATE TABLE hdb_scans_mainnet_new (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key   BLOB NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      REAL NOT NULL,
+	error        TEXT NOT NULL,
+	height_skew  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency REAL NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency REAL NOT NULL DEFAULT 0,
+	settings     BLOB,
+	price_table  BLOB,
+	modified     BIGINT NOT NULL,
+	fetched      BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE
+);
+INSERT INTO hdb_scans_mainnet_new (
+	id, public_key, ran_at, success, latency, error, height_skew,
+	invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency, settings,
+	price_table, modified, fetched
+)
+SELECT
+	id, public_key, ran_at, success, latency, error, height_skew,
+	invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency, settings,
+	price_table, modified, fetched
+FROM hdb_scans_mainnet;
+DROP TABLE hdb_scans_mainnet;
+ALTER TABLE hdb_scans_mainnet_new RENAME TO hdb_scans_mainnet;
+CREATE INDEX idx_hdb_scans_mainnet ON hdb_scans_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_mainnet_ran_at ON hdb_scans_mainnet (ran_at);
+
+CREATE TABLE hdb_benchmarks_mainnet_new (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key     BLOB NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	error          TEXT NOT NULL,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_mainnet(public_key) ON DELETE CASCADE
+);
+INSERT INTO hdb_benchmarks_mainnet_new (
+	id, public_key, ran_at, success, upload_speed, download_speed, ttfb,
+	error, modified, fetched
+)
+SELECT
+	id, public_key, ran_at, success, upload_speed, download_speed, ttfb,
+	error, modified, fetched
+FROM hdb_benchmarks_mainnet;
+DROP TABLE hdb_benchmarks_mainnet;
+ALTER TABLE hdb_benchmarks_mainnet_new RENAME TO hdb_benchmarks_mainnet;
+CREATE INDEX idx_hdb_benchmarks_mainnet ON hdb_benchmarks_mainnet (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_mainnet_ran_at ON hdb_benchmarks_mainnet (ran_at);
+
+CREATE TABLE hdb_scans_zen_new (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key   BLOB NOT NULL,
+	ran_at       BIGINT NOT NULL,
+	success      BOOL NOT NULL,
+	latency      REAL NOT NULL,
+	error        TEXT NOT NULL,
+	height_skew  BIGINT NOT NULL DEFAULT 0,
+	invalid_signature BOOL NOT NULL DEFAULT FALSE,
+	ipv4         SMALLINT NOT NULL DEFAULT 0,
+	ipv4_latency REAL NOT NULL DEFAULT 0,
+	ipv6         SMALLINT NOT NULL DEFAULT 0,
+	ipv6_latency REAL NOT NULL DEFAULT 0,
+	settings     BLOB,
+	price_table  BLOB,
+	modified     BIGINT NOT NULL,
+	fetched      BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE
+);
+INSERT INTO hdb_scans_zen_new (
+	id, public_key, ran_at, success, latency, error, height_skew,
+	invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency, settings,
+	price_table, modified, fetched
+)
+SELECT
+	id, public_key, ran_at, success, latency, error, height_skew,
+	invalid_signature, ipv4, ipv4_latency, ipv6, ipv6_latency, settings,
+	price_table, modified, fetched
+FROM hdb_scans_zen;
+DROP TABLE hdb_scans_zen;
+ALTER TABLE hdb_scans_zen_new RENAME TO hdb_scans_zen;
+CREATE INDEX idx_hdb_scans_zen ON hdb_scans_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_scans_zen_ran_at ON hdb_scans_zen (ran_at);
+
+CREATE TABLE hdb_benchmarks_zen_new (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	public_key     BLOB NOT NULL,
+	ran_at         BIGINT NOT NULL,
+	success        BOOL NOT NULL,
+	upload_speed   REAL NOT NULL,
+	download_speed REAL NOT NULL,
+	ttfb           REAL NOT NULL,
+	error          TEXT NOT NULL,
+	modified       BIGINT NOT NULL,
+	fetched        BIGINT NOT NULL,
+	FOREIGN KEY (public_key) REFERENCES hdb_hosts_zen(public_key) ON DELETE CASCADE
+);
+INSERT INTO hdb_benchmarks_zen_new (
+	id, public_key, ran_at, success, upload_speed, download_speed, ttfb,
+	error, modified, fetched
+)
+SELECT
+	id, public_key, ran_at, success, upload_speed, download_speed, ttfb,
+	error, modified, fetched
+FROM hdb_benchmarks_zen;
+DROP TABLE hdb_benchmarks_zen;
+ALTER TABLE hdb_benchmarks_zen_new RENAME TO hdb_benchmarks_zen;
+CREATE INDEX idx_hdb_benchmarks_zen ON hdb_benchmarks_zen (public_key, ran_at);
+CREATE INDEX idx_hdb_benchmarks_zen_ran_at ON hdb_benchmarks_zen (ran_at);
+
+PRAGMA foreign_key_check;
+COMMIT;
+PRAGMA foreign_keys = ON;
+
+/* benchmark traffic */
+ALTER TABLE hdb_benchmarks_mainnet ADD COLUMN uploaded BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_benchmarks_mainnet ADD COLUMN downloaded BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_benchmarks_zen ADD COLUMN uploaded BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_benchmarks_zen ADD COLUMN downloaded BIGINT NOT NULL DEFAULT 0;
+
+/* benchmark and listing opt-outs */
+CREATE TABLE hdb_optouts (
+	network    VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	level      VARCHAR(16) NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* deferred benchmarks */
+ALTER TABLE hdb_hosts_mainnet ADD COLUMN deferred_benchmarks INT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_hosts_zen ADD COLUMN deferred_benchmarks INT NOT NULL DEFAULT 0;
+
+/* scan timings */
+ALTER TABLE hdb_scans_mainnet ADD COLUMN dial_time REAL NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN handshake_time REAL NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN settings_time REAL NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN dial_time REAL NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN handshake_time REAL NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN settings_time REAL NOT NULL DEFAULT 0;
+
+/* benchmark budget */
+CREATE TABLE hdb_spending (
+	network VARCHAR(8) NOT NULL,
+	day     BIGINT NOT NULL,
+	amount  BLOB NOT NULL,
+	PRIMARY KEY (network, day)
+);
+
+/* failure classes */
+ALTER TABLE hdb_scans_mainnet ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_benchmarks_mainnet ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_benchmarks_zen ADD COLUMN failure SMALLINT NOT NULL DEFAULT 0;
+
+/* contract renewals */
+CREATE TABLE hdb_contracts (
+	id           BLOB NOT NULL,
+	network      VARCHAR(8) NOT NULL,
+	public_key   BLOB NOT NULL,
+	formed_at    BIGINT NOT NULL,
+	window_start BIGINT NOT NULL,
+	window_end   BIGINT NOT NULL,
+	renewed_from BLOB,
+	renewed_to   BLOB,
+	state        VARCHAR(16) NOT NULL,
+	cost         BLOB NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX idx_hdb_contracts ON hdb_contracts (network, public_key);
+
+/* spending per host */
+CREATE TABLE hdb_host_spending (
+	network    VARCHAR(8) NOT NULL,
+	public_key BLOB NOT NULL,
+	contracts  BLOB NOT NULL,
+	funding    BLOB NOT NULL,
+	upload     BLOB NOT NULL,
+	download   BLOB NOT NULL,
+	last_spent BIGINT NOT NULL,
+	PRIMARY KEY (network, public_key)
+);
+
+/* allowlist */
+CREATE TABLE hdb_allowlist (
+	public_key BLOB NOT NULL,
+	PRIMARY KEY (public_key)
+);
+
+/* numbered update batches */
+CREATE TABLE hdb_updates (
+	network VARCHAR(8) NOT NULL,
+	acked   BIGINT NOT NULL,
+	pending BLOB NOT NULL,
+	PRIMARY KEY (network)
+);
+
+/* update consumers */
+CREATE TABLE hdb_consumers (
+	network  VARCHAR(8) NOT NULL,
+	consumer VARCHAR(64) NOT NULL,
+	acked    BIGINT NOT NULL,
+	state    BLOB NOT NULL,
+	PRIMARY KEY (network, consumer)
+);
+
+/* pending updates index */
+UPDATE hdb_hosts_mainnet SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_scans_mainnet SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_benchmarks_mainnet SET fetched = 0 WHERE modified > fetched;
+CREATE INDEX idx_hdb_hosts_mainnet_fetched ON hdb_hosts_mainnet (fetched);
+CREATE INDEX idx_hdb_scans_mainnet_fetched ON hdb_scans_mainnet (fetched);
+CREATE INDEX idx_hdb_benchmarks_mainnet_fetched ON hdb_benchmarks_mainnet (fetched);
+
+UPDATE hdb_hosts_zen SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_scans_zen SET fetched = 0 WHERE modified > fetched;
+UPDATE hdb_benchmarks_zen SET fetched = 0 WHERE modified > fetched;
+CREATE INDEX idx_hdb_hosts_zen_fetched ON hdb_hosts_zen (fetched);
+CREATE INDEX idx_hdb_scans_zen_fetched ON hdb_scans_zen (fetched);
+CREATE INDEX idx_hdb_benchmarks_zen_fetched ON hdb_benchmarks_zen (fetched);
+
+/*
+ * clock skew
+ *
+ * The skew is now measured in seconds from the signed prices rather than
+ * in blocks, so the old values are dropped.
+ */
+ALTER TABLE hdb_scans_mainnet RENAME COLUMN height_skew TO clock_skew;
+UPDATE hdb_scans_mainnet SET clock_skew = 0;
+ALTER TABLE hdb_scans_mainnet ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE hdb_scans_zen RENAME COLUMN height_skew TO clock_skew;
+UPDATE hdb_scans_zen SET clock_skew = 0;
+ALTER TABLE hdb_scans_zen ADD COLUMN valid_until BIGINT NOT NULL DEFAULT 0;
@@ -3,6 +3,7 @@ import {
 	Host,
 	NodeStatus,
 	PriceChange,
+	LatencyProbe,
 	HostSortType,
 	NetworkAverages,
 	HostCount
@@ -83,6 +84,19 @@ export const getPriceChanges = async (
 	.catch(error => console.log(error))
 }
 
+export const getLatencyProbes = async (
+	network: string,
+	host: string
+): Promise<{ probes: { [node: string]: LatencyProbe[] } }> => {
+	const url = '/hosts/latency?network=' + network + '&host=' + host
+	return instance.get(url)
+	.then(response => {
+		if (response.status === 200) return response.data
+		else console.log(response.statusText)
+	})
+	.catch(error => console.log(error))
+}
+
 export const getNetworkHosts = async (network: string):
 	Promise<{ hosts: HostCount }> => {
 	const url = '/network/hosts?network=' + network
@@ -65,19 +65,38 @@ export type HostScan = {
 	timestamp: string,
 	success: boolean,
 	latency: number,
+	timings?: ScanTimings,
 	error: string,
+	failure: 'unknown' | 'host' | 'prober',
+	clockSkew: number,
+	validUntil: string,
 	publicKey: string,
 	network: string,
 	node: string
 }
 
+export type ScanTimings = {
+	dial: number,
+	handshake: number,
+	settings: number
+}
+
+export type LatencyProbe = {
+	timestamp: string,
+	success: boolean,
+	latency: number
+}
+
 export type HostBenchmark = {
 	timestamp: string,
 	success: boolean,
 	error: string,
+	failure: 'unknown' | 'host' | 'prober' | 'restarted' | 'skipped',
 	uploadSpeed: number,
 	downloadSpeed: number,
 	ttfb: number,
+	uploaded: number,
+	downloaded: number,
 	publicKey: string,
 	network: string,
 	node: string
@@ -94,6 +113,7 @@ export type HostScore = {
 	latency: number,
 	benchmarks: number,
 	contracts: number,
+	duration: number,
 	total: number
 }
 
@@ -108,7 +128,22 @@ export type HostInteractions = {
 	historicSuccessfulInteractions: number,
 	historicFailedInteractions: number,
 	recentSuccessfulInteractions: number,
-	recentFailedInteractions: number
+	recentFailedInteractions: number,
+	speeds: NodeSpeeds,
+	traffic: Traffic
+}
+
+export type NodeSpeeds = {
+	latency: number,
+	uploadSpeed: number,
+	downloadSpeed: number,
+	ttfb: number
+}
+
+export type Traffic = {
+	ingress: number,
+	egress: number,
+	since: string
 }
 
 export type Host = {
@@ -133,20 +168,48 @@ export type Host = {
 	loc: string,
 	org: string,
 	postal: string,
-	timezone: string
+	timezone: string,
+	asn: string,
+	traffic: Traffic,
+	optOut?: 'benchmarks',
+	tags: string[],
+	sybilCluster?: number
 }
 
 export type NetworkStatus = {
 	height: number,
-	balance: string
+	balance: string,
+	lowFunds: boolean
 }
 
 export type NodeStatus = {
 	online: boolean,
 	version: string,
+	gitRevision?: string,
+	startedAt: string,
+	update?: UpdateStatus,
 	networks: { [network: string]: NetworkStatus },
 }
 
+export type NodeInfo = {
+	name: string,
+	online: boolean,
+	version: string,
+	gitRevision: string,
+	networks: string[],
+	region: string,
+	uptime: number,
+	lastRestart: string,
+	updateAvailable: boolean
+}
+
+export type UpdateStatus = {
+	available: boolean,
+	latestVersion: string,
+	releaseURL: string,
+	checkedAt: string
+}
+
 export type HostCount = {
 	total: number,
 	online: number
@@ -163,7 +226,8 @@ export type PriceChange = {
 }
 
 export type HostSortType = {
-	sortBy: 'id' | 'rank' | 'total' | 'used' | 'storage' | 'upload' | 'download',
+	sortBy: 'id' | 'rank' | 'total' | 'used' | 'storage' | 'upload' | 'download' |
+		'uptime' | 'latency' | 'benchmarks' | 'age' | 'collateral' | 'firstSeen',
 	order: 'asc' | 'desc'
 }
 
@@ -175,3 +239,35 @@ export type NetworkAverages = {
 	contractDuration: number,
 	available: boolean
 }
+
+export type StorageStats = {
+	hosts: number,
+	totalStorage: number,
+	usedStorage: number
+}
+
+export type NetworkStorage = StorageStats & {
+	countries: { [country: string]: StorageStats },
+	tiers: {
+		tier1?: StorageStats,
+		tier2?: StorageStats,
+		tier3?: StorageStats
+	}
+}
+
+export type NetworkSnapshot = {
+	timestamp: string,
+	hosts: number,
+	onlineHosts: number,
+	acceptingContracts: number,
+	totalStorage: number,
+	usedStorage: number,
+	storagePrice: string,
+	collateral: string,
+	uploadPrice: string,
+	downloadPrice: string,
+	uploadSpeed: number,
+	downloadSpeed: number,
+	ttfb: number,
+	collateralCapacity: string
+}
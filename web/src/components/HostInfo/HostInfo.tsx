@@ -5,6 +5,7 @@ import {
 	Host,
 	HostScore,
 	NetworkAverages,
+	LatencyProbe,
 	getFlagEmoji,
 	blocksToTime,
 	convertSize,
@@ -12,7 +13,8 @@ import {
 	convertPricePerBlock,
 	toSia,
 	useLocations,
-	getAverages
+	getAverages,
+	getLatencyProbes
 } from '../../api'
 import { Tooltip } from '../'
 
@@ -144,6 +146,33 @@ const ContractDurationTooltip = (props: TooltipProps) => (
 	</div>
 )
 
+type SparklineProps = {
+	probes: LatencyProbe[]
+}
+
+const Sparkline = (props: SparklineProps) => {
+	const width = 120
+	const height = 20
+	const latencies = props.probes
+		.filter(probe => probe.success === true)
+		.map(probe => probe.latency / 1e6)
+	if (latencies.length < 2) return null
+	const max = Math.max(...latencies)
+	const points = latencies.map((latency, i) => (
+		(i * width / (latencies.length - 1)).toFixed(1) + ',' +
+		(height - (max === 0 ? 0 : latency * height / max)).toFixed(1)
+	)).join(' ')
+	const average = latencies.reduce((a, b) => a + b, 0) / latencies.length
+	return (
+		<span className="host-info-sparkline">
+			{average.toFixed(0) + ' ms'}
+			<svg width={width} height={height} viewBox={'0 0 ' + width + ' ' + height}>
+				<polyline points={points}/>
+			</svg>
+		</span>
+	)
+}
+
 export const HostInfo = (props: HostInfoProps) => {
 	const locations = useLocations()
 	const location = useLocation()
@@ -165,6 +194,7 @@ export const HostInfo = (props: HostInfoProps) => {
 			latency: 0,
 			benchmarks: 0,
 			contracts: 0,
+			duration: 0,
 			total: 0
 		}
 		if (props.node === 'global') {
@@ -206,6 +236,7 @@ export const HostInfo = (props: HostInfoProps) => {
 	}
 	const { online, lastSeen, uptime, activeHosts, score } = interactions()
 	const [scoreExpanded, toggleScore] = useState(false)
+	const [probes, setProbes] = useState<LatencyProbe[]>([])
 	useEffect(() => {
 		let network = (location.pathname.indexOf('/zen') === 0) ? 'zen' : 'mainnet'
 		getAverages(network)
@@ -215,6 +246,19 @@ export const HostInfo = (props: HostInfoProps) => {
 			}
 		})
 	}, [location, setAverages])
+	useEffect(() => {
+		let network = (location.pathname.indexOf('/zen') === 0) ? 'zen' : 'mainnet'
+		getLatencyProbes(network, props.host.publicKey)
+		.then(data => {
+			if (!data || !data.probes) return
+			if (props.node !== 'global') {
+				setProbes(data.probes[props.node] || [])
+				return
+			}
+			let loc = locations.find(loc => data.probes[loc.short] && data.probes[loc.short].length > 0)
+			setProbes(loc ? data.probes[loc.short] : [])
+		})
+	}, [location, locations, props.host.publicKey, props.node, setProbes])
 	return (
 		<div className={'host-info-container' + (props.darkMode ? ' host-info-dark' : '')}>
 			<table>
@@ -225,9 +269,22 @@ export const HostInfo = (props: HostInfoProps) => {
 					<tr><td>Address</td><td>{props.host.netaddress}</td></tr>
 					<tr><td>Location</td><td>{getFlagEmoji(props.host.country)}</td></tr>
 					<tr><td>Online</td><td>{online ? 'Yes' : 'No'}</td></tr>
+					{props.host.tags && props.host.tags.length > 0 &&
+						<tr>
+							<td>Tags</td>
+							<td>
+								{props.host.tags.map(tag => (
+									<span key={tag} className="host-info-tag">{tag}</span>
+								))}
+							</td>
+						</tr>
+					}
 					<tr><td>First Seen</td><td>{new Date(props.host.firstSeen).toDateString()}</td></tr>
 					<tr><td>Last Seen</td><td>{lastSeen}</td></tr>
 					<tr><td>Uptime</td><td>{uptime}</td></tr>
+					{probes.length > 1 &&
+						<tr><td>Latency (24h)</td><td><Sparkline probes={probes}/></td></tr>
+					}
 					<tr><td>Version</td><td>{props.host.settings.version === '' ? 'N/A' : props.host.settings.version}</td></tr>
 					<tr><td>Release</td><td>{props.host.settings.release === '' ? 'N/A' : props.host.settings.release}</td></tr>
 					<tr><td>Accepting Contracts</td><td>{props.host.settings.acceptingcontracts ? 'Yes' : 'No'}</td></tr>
@@ -310,7 +367,8 @@ export const HostInfo = (props: HostInfoProps) => {
 								Age<br/>
 								Version<br/>
 								Latency<br/>
-								Benchmarks
+								Benchmarks<br/>
+								Contract Terms
 							</td>
 							<td>
 								{score.contracts.toPrecision(2)}<br/>
@@ -322,7 +380,8 @@ export const HostInfo = (props: HostInfoProps) => {
 								{score.age.toPrecision(2)}<br/>
 								{score.version.toPrecision(2)}<br/>
 								{score.latency.toPrecision(2)}<br/>
-								{score.benchmarks.toPrecision(2)}
+								{score.benchmarks.toPrecision(2)}<br/>
+								{score.duration.toPrecision(2)}
 							</td>
 						</tr>
 					}